@@ -7,6 +7,44 @@ Environment variables
 ROUTER_BASE_URL   Base URL of the Open AI Router (default http://localhost:3000)
 DSPY_SIDECAR_PORT Port to listen on (default 8780)
 DSPY_DEFAULT_LM   Fallback LM model name for the router (default gpt-4o-mini)
+DSPY_EXTRA_KINDS  More module kinds, as kind=DSPyClass pairs, comma-separated
+                  (e.g. ``mine=MyModule``, with ``dspy.MyModule`` patched in);
+                  each class is built from the signature alone
+DSPY_SIDECAR_SECRET  Secret shared with the router (see Security)
+DSPY_TLS_CERT_FILE, DSPY_TLS_KEY_FILE  Serve HTTPS with this certificate
+DSPY_TLS_CLIENT_CA  Require router client certificates signed by this CA
+
+Security
+--------
+With ``DSPY_SIDECAR_SECRET`` set, every request must carry it in
+``X-DSPy-Sidecar-Secret`` or is refused with 401, so nothing but the router
+can make this sidecar call the router with the credentials it forwards.
+The LM calls back into the router carry it too; the router sets the same
+variable and rejects callbacks with a wrong secret.  Mutual TLS can be
+layered on with the ``DSPY_TLS_*`` variables, mirrored on the router by
+``DSPY_TLS_CA_FILE``, ``DSPY_TLS_CERT_FILE`` and ``DSPY_TLS_KEY_FILE``.
+
+Module kinds
+------------
+``GET /health`` lists the kinds this sidecar can build under ``kinds``: the
+built-ins, ``pot`` (ProgramOfThought) and ``multi`` (MultiChainComparison)
+when the installed DSPy has them, and ``DSPY_EXTRA_KINDS``.  The router
+passes kinds it does not know itself to the sidecars listing them.
+
+Observability
+-------------
+The router's trace context (``traceparent``, ``tracestate``) is sent on with
+the LM calls a module makes back to it; with ``opentelemetry-api``
+installed and configured, each invocation runs in a ``dspy.invoke`` span
+continuing that trace.  Predictions report the token usage of those LM
+calls, with their count, as ``usage``.
+
+Deadlines
+---------
+An ``X-Request-Timeout`` header (seconds) carries the time the router's
+client has left.  The LM calls pass it back to the router, which bounds
+them by it, and a non-streamed invocation still running when it passes is
+answered with 504.
 
 The sidecar configures ``dspy.LM`` with ``api_base`` pointing back to the
 router so every LM call the DSPy module makes is routed through the same
@@ -20,16 +58,38 @@ back as SSE events.  Each event is a JSON object with a ``type`` field:
   * ``chunk``      — incremental token for a signature field
   * ``status``     — status/progress message from DSPy internals
   * ``tool_call``  — tool invocation from ReAct
+  * ``router_tool_call`` — call to one of the router's own tools
   * ``prediction`` — final prediction (all output fields)
+
+Router tools
+------------
+ReAct requests may carry ``router_tools`` (websearch, rag, kvmem, …) and a
+``session``.  Calling one emits a ``router_tool_call`` event and blocks the
+module until the router POSTs the result to ``/tool_result``
+(``DSPY_ROUTER_TOOL_TIMEOUT`` seconds, default 120).  Calling one of the
+client's tools in a session emits a ``tool_call`` event and ends the stream
+instead; the client runs the tool and sends its result in the next turn.
+Pending calls live in this process, so run a single worker per sidecar URL.
+
+Compiled programs
+-----------------
+``POST /compile`` runs a DSPy optimizer (``BootstrapFewShot`` or
+``MIPROv2``) over a trainset of ``{inputs, outputs}`` examples and answers
+with the optimized module's dumped state.  The router caches it; an
+``/invoke`` carrying ``program`` loads that state into the module it builds.
 """
 
 from __future__ import annotations
 
 import asyncio
+import concurrent.futures
+import hmac
 import json
 import logging
 import os
+import ssl
 import traceback
+import uuid
 from typing import Any
 
 import dspy
@@ -37,11 +97,41 @@ import uvicorn
 from fastapi import FastAPI, Request
 from fastapi.responses import JSONResponse, StreamingResponse
 
+try:
+    from opentelemetry import propagate as otel_propagate
+    from opentelemetry import trace as otel_trace
+except ImportError:  # tracing is optional
+    otel_trace = None
+
 # ─── Configuration ────────────────────────────────────────────────────────────
 
 ROUTER_BASE_URL = os.getenv("ROUTER_BASE_URL", "http://localhost:3000/inference/v1")
 SIDECAR_PORT = int(os.getenv("DSPY_SIDECAR_PORT", "8780"))
 DEFAULT_LM = os.getenv("DSPY_DEFAULT_LM", "gpt-4o-mini")
+ROUTER_TOOL_TIMEOUT = float(os.getenv("DSPY_ROUTER_TOOL_TIMEOUT", "120"))
+SIDECAR_SECRET = os.getenv("DSPY_SIDECAR_SECRET", "")
+SECRET_HEADER = "X-DSPy-Sidecar-Secret"
+TIMEOUT_HEADER = "X-Request-Timeout"
+TLS_CERT_FILE = os.getenv("DSPY_TLS_CERT_FILE", "")
+TLS_KEY_FILE = os.getenv("DSPY_TLS_KEY_FILE", "")
+TLS_CLIENT_CA = os.getenv("DSPY_TLS_CLIENT_CA", "")
+
+
+def _parse_extra_kinds(raw: str) -> dict[str, str]:
+    kinds = {}
+    for pair in raw.split(","):
+        name, _, cls = pair.partition("=")
+        if name.strip() and cls.strip():
+            kinds[name.strip()] = cls.strip()
+    return kinds
+
+
+# Kinds built as ``getattr(dspy, cls)(signature)``, when DSPy has the class.
+SIGNATURE_KINDS = {
+    "pot": "ProgramOfThought",
+    "multi": "MultiChainComparison",
+    **_parse_extra_kinds(os.getenv("DSPY_EXTRA_KINDS", "")),
+}
 
 logger = logging.getLogger("dspy_sidecar")
 logging.basicConfig(level=logging.INFO, format="%(asctime)s [%(levelname)s] %(name)s: %(message)s")
@@ -49,49 +139,165 @@ logging.basicConfig(level=logging.INFO, format="%(asctime)s [%(levelname)s] %(na
 app = FastAPI(title="DSPy Bridge Sidecar", version="0.1.0")
 
 
+@app.middleware("http")
+async def require_secret(request: Request, call_next):
+    """Refuse requests without the router's shared secret, when one is set."""
+    if SIDECAR_SECRET and not hmac.compare_digest(
+        request.headers.get(SECRET_HEADER, "").encode(), SIDECAR_SECRET.encode()
+    ):
+        return JSONResponse({"error": "invalid sidecar secret"}, status_code=401)
+    return await call_next(request)
+
+
 # ─── LM factory ──────────────────────────────────────────────────────────────
 
-def build_lm(model: str, auth_token: str | None = None) -> dspy.LM:
-    """Create a dspy.LM that calls back into the router."""
+def build_lm(
+    model: str,
+    auth_token: str | None = None,
+    temperature: float | None = None,
+    headers: dict[str, str] | None = None,
+) -> dspy.LM:
+    """Create a dspy.LM that calls back into the router, sending *headers*."""
     api_base = ROUTER_BASE_URL
     api_key = auth_token or "sidecar-internal"
+    headers = dict(headers or {})
+    if SIDECAR_SECRET:
+        headers[SECRET_HEADER] = SIDECAR_SECRET
+    extra = {"extra_headers": headers} if headers else {}
     return dspy.LM(
         model=f"openai/{model}",
         api_base=api_base,
         api_key=api_key,
-        # Reasonable defaults; callers can override via DSPy settings if needed.
-        temperature=0.7,
+        # Reasonable defaults; requests can set the temperature.
+        temperature=0.7 if temperature is None else temperature,
         max_tokens=4096,
+        **extra,
     )
 
 
+def lm_usage(lm: dspy.LM) -> dict[str, int]:
+    """Sum the token usage of the calls *lm* made."""
+    prompt = completion = 0
+    for entry in lm.history:
+        usage = entry.get("usage") or {}
+        prompt += usage.get("prompt_tokens") or 0
+        completion += usage.get("completion_tokens") or 0
+    return {
+        "prompt_tokens": prompt,
+        "completion_tokens": completion,
+        "total_tokens": prompt + completion,
+        "lm_calls": len(lm.history),
+    }
+
+
+def request_timeout(request: Request) -> float | None:
+    """Seconds the router's client has left, from X-Request-Timeout."""
+    try:
+        timeout = float(request.headers.get(TIMEOUT_HEADER, ""))
+    except ValueError:
+        return None
+    return timeout if timeout > 0 else None
+
+
+# ─── Tracing ─────────────────────────────────────────────────────────────────
+
+def start_trace(request: Request, name: str):
+    """Continue the router's trace for an invocation.
+
+    Returns the headers its LM calls send back to the router, and a function
+    ending the invocation's span.  Without OpenTelemetry the router's trace
+    context is passed through as is.
+    """
+    headers = {k: v for k in ("traceparent", "tracestate") if (v := request.headers.get(k))}
+    if otel_trace is None:
+        return headers, lambda: None
+    span = otel_trace.get_tracer("dspy_sidecar").start_span(name, context=otel_propagate.extract(headers))
+    out: dict[str, str] = {}
+    otel_propagate.inject(out, context=otel_trace.set_span_in_context(span))
+    return out or headers, span.end
+
+
 # ─── Module factory ──────────────────────────────────────────────────────────
 
-def build_module(kind: str, signature: str, tools: list[dict] | None = None) -> dspy.Module:
-    """Instantiate the appropriate DSPy module for *kind*."""
+def build_signature(signature: str, instructions: str = "", fields: dict | None = None):
+    """Apply the instructions and field descriptions of a named signature."""
+    if not instructions and not fields:
+        return signature
+    sig = dspy.Signature(signature, instructions) if instructions else dspy.Signature(signature)
+    for name, desc in (fields or {}).items():
+        if name in sig.fields:
+            sig = sig.with_updated_fields(name, desc=desc)
+    return sig
+
+
+def apply_demos(module: dspy.Module, demos: list[dict] | None) -> None:
+    """Give every predictor of *module* the few-shot demos of a named signature."""
+    if not demos:
+        return
+    examples = [dspy.Example(**d) for d in demos]
+    for predictor in module.predictors():
+        predictor.demos = list(examples)
+
+
+def build_module(
+    kind: str,
+    signature,
+    tools: list[dict] | None = None,
+    max_iters: int | None = None,
+    router_tools: list | None = None,
+    session: str = "",
+    emit=None,
+) -> dspy.Module:
+    """Instantiate the appropriate DSPy module for *kind*.
+
+    *max_iters* bounds the iterations of ReAct and RLM; other kinds ignore it.
+    *router_tools* are callables from ``_convert_router_tools``, given to ReAct.
+    With a *session* and *emit*, client tools pause the module (see
+    ``_convert_tools``).
+    """
+    iters = {"max_iters": max_iters} if max_iters else {}
     if kind == "predict":
         return dspy.Predict(signature)
     elif kind == "cot":
         return dspy.ChainOfThought(signature)
     elif kind == "react":
-        dspy_tools = _convert_tools(tools or [])
-        return dspy.ReAct(signature, tools=dspy_tools)
+        dspy_tools = _convert_tools(tools or [], session, emit) + (router_tools or [])
+        return dspy.ReAct(signature, tools=dspy_tools, **iters)
     elif kind == "rlm":
         # RLM is only available in newer DSPy builds; fall back to CoT.
         if hasattr(dspy, "RLM"):
-            return dspy.RLM(signature)
+            return dspy.RLM(signature, **iters)
         logger.warning("dspy.RLM not available, falling back to ChainOfThought")
         return dspy.ChainOfThought(signature)
+    elif kind in supported_kinds():
+        return getattr(dspy, SIGNATURE_KINDS[kind])(signature)
     else:
         raise ValueError(f"Unknown DSPy kind: {kind!r}")
 
 
-def _convert_tools(tools: list[dict]) -> list:
-    """Convert sidecar tool definitions to DSPy-compatible tool objects.
+def supported_kinds() -> list[str]:
+    """The module kinds ``build_module`` can build with the installed DSPy."""
+    return ["predict", "cot", "react", "rlm"] + [
+        kind for kind, cls in SIGNATURE_KINDS.items() if hasattr(dspy, cls)
+    ]
+
+
+class ClientToolPause(BaseException):
+    """Unwinds a module paused on a client tool call.
+
+    A BaseException, so that ReAct does not record it as a tool error and
+    carry on calling the LM.
+    """
+
+
+def _convert_tools(tools: list[dict], session: str = "", emit=None) -> list:
+    """Convert the client's tool definitions to DSPy-compatible tool objects.
 
-    DSPy ReAct expects tool callables (or dspy.Tool wrappers).  For now we
-    create thin stubs that simply return a JSON placeholder — the actual
-    tool execution is handled by the Go router's ToolPlugin.
+    DSPy ReAct expects tool callables (or dspy.Tool wrappers).  In a session,
+    a call hands ``{"type": "tool_call", …}`` to *emit* and waits: the stream
+    then ends, the client runs the tool and sends its result in a new turn,
+    and the abandoned module unwinds with ``ClientToolPause``.  Without one,
+    stubs simply return a JSON placeholder.
     """
     dspy_tools = []
     for td in tools:
@@ -102,6 +308,11 @@ def _convert_tools(tools: list[dict]) -> list:
         # Create a stub function that DSPy can inspect.
         def _make_stub(n: str, d: str, s: dict):
             def stub(**kwargs: Any) -> str:
+                if emit is not None:
+                    try:
+                        return _call_out(session, emit, "tool_call", n, kwargs, None)
+                    except concurrent.futures.CancelledError:
+                        raise ClientToolPause(n) from None
                 return json.dumps({
                     "__tool_call__": True,
                     "name": n,
@@ -117,6 +328,48 @@ def _convert_tools(tools: list[dict]) -> list:
     return dspy_tools
 
 
+# Tool calls awaiting their result: session → call_id → Future.  Ending a
+# session's stream cancels the calls still pending.
+_pending: dict[str, dict[str, concurrent.futures.Future]] = {}
+
+
+def _call_out(session: str, emit, event_type: str, name: str, args: dict, timeout: float | None) -> str:
+    """Emit a call to tool *name* and block (in DSPy's worker thread) until
+    ``/tool_result`` delivers its result."""
+    call_id = f"call_{uuid.uuid4().hex[:24]}"
+    fut: concurrent.futures.Future = concurrent.futures.Future()
+    calls = _pending.setdefault(session, {})
+    calls[call_id] = fut
+    try:
+        emit({"type": event_type, "call_id": call_id, "tool_name": name, "tool_args": args})
+        return fut.result(timeout=timeout)
+    finally:
+        calls.pop(call_id, None)
+
+
+def _convert_router_tools(tools: list[dict], session: str, emit) -> list:
+    """Wrap the router's own tools as callables for ReAct.
+
+    A call hands ``{"type": "router_tool_call", …}`` to *emit* and blocks
+    until the router has run the tool and posted its result.
+    """
+    dspy_tools = []
+    for td in tools:
+        def _make_tool(n: str, d: str, s: dict):
+            def tool(**kwargs: Any) -> str:
+                try:
+                    return _call_out(session, emit, "router_tool_call", n, kwargs, ROUTER_TOOL_TIMEOUT)
+                except concurrent.futures.TimeoutError:
+                    return f"error: no result for {n} within {ROUTER_TOOL_TIMEOUT:g}s"
+            tool.__name__ = n
+            tool.__doc__ = d
+            tool.__json_schema__ = s
+            return tool
+
+        dspy_tools.append(_make_tool(td.get("name", "unknown"), td.get("description", ""), td.get("schema", {})))
+    return dspy_tools
+
+
 # ─── History → DSPy messages ─────────────────────────────────────────────────
 
 def build_history_value(raw_history: str | list) -> list[dict[str, str]]:
@@ -217,8 +470,15 @@ async def invoke_stream(
     inputs: dict[str, Any],
     signature: str,
     kind: str,
+    side_events: asyncio.Queue | None = None,
+    lm: dspy.LM | None = None,
 ):
-    """Yield SSE events from a streamified DSPy module."""
+    """Yield SSE events from a streamified DSPy module.
+
+    Events put on *side_events* (tool calls) are interleaved with the
+    module's own, since the module blocks until they are answered.  A
+    client tool call ends the stream: the client answers it in a new turn.
+    """
     try:
         listeners = _build_stream_listeners(module, signature, kind)
         stream_module = dspy.streamify(
@@ -228,17 +488,43 @@ async def invoke_stream(
         )
         stream = stream_module(**inputs)
 
-        async for chunk in _iter_stream(stream):
-            yield chunk
+        if side_events is None:
+            async for chunk in _iter_stream(stream, lm):
+                yield chunk
+            return
+
+        done = object()
+
+        async def _pump():
+            try:
+                async for chunk in _iter_stream(stream, lm):
+                    await side_events.put(chunk)
+            finally:
+                await side_events.put(done)
+
+        pump = asyncio.create_task(_pump())
+        try:
+            while (chunk := await side_events.get()) is not done:
+                if isinstance(chunk, dict):
+                    yield _sse_event(chunk)
+                    if chunk["type"] == "tool_call":
+                        yield "data: [DONE]\n\n"
+                        return
+                else:
+                    yield chunk
+        finally:
+            pump.cancel()
 
     except Exception as exc:
         logger.error("Streaming error: %s", traceback.format_exc())
         yield _sse_event({"type": "status", "message": f"error: {exc}"})
 
 
-async def _iter_stream(stream):
+async def _iter_stream(stream, lm: dspy.LM | None = None):
     """Iterate a DSPy stream, yielding SSE event strings.
 
+    The prediction carries the usage of *lm*'s calls, when given.
+
     With ``stream_listeners`` configured, dspy.streamify yields:
     - ``StreamResponse`` (dspy) — parsed field-level chunks with
       ``.signature_field_name`` and ``.chunk``.  This is the primary path.
@@ -305,7 +591,10 @@ async def _iter_stream(stream):
                 if "rationale" in outputs and "reasoning" not in outputs:
                     outputs["reasoning"] = outputs.pop("rationale")
 
-                yield _sse_event({"type": "prediction", "outputs": outputs})
+                event: dict[str, Any] = {"type": "prediction", "outputs": outputs}
+                if lm is not None:
+                    event["usage"] = lm_usage(lm)
+                yield _sse_event(event)
             else:
                 # Unknown chunk type; emit raw.
                 yield _sse_event({"type": "status", "message": str(item)})
@@ -339,28 +628,66 @@ async def invoke(request: Request):
     model: str = body.get("model", DEFAULT_LM)
     stream: bool = body.get("stream", False)
     auth_token: str | None = body.get("auth_token") or request.headers.get("x-upstream-authorization", "").removeprefix("Bearer ").strip() or None
-
-    logger.info("invoke kind=%s model=%s stream=%s sig=%s", kind, model, stream, signature)
-
-    # Configure DSPy LM per-request using dspy.context (async-safe).
-    lm = build_lm(model, auth_token)
-
-    # Build module.
+    # Router trace ID, so sidecar logs line up with router and provider logs.
+    request_id: str = request.headers.get("x-request-id", "-")
+
+    logger.info("invoke request_id=%s kind=%s model=%s stream=%s sig=%s", request_id, kind, model, stream, signature)
+
+    # In a session tools report their calls as events, so it needs a stream.
+    session: str = body.get("session", "")
+    side_events: asyncio.Queue | None = None
+    emit = None
+    router_tools: list = []
+    if session:
+        if not stream:
+            return JSONResponse({"error": "session needs stream"}, status_code=400)
+        loop = asyncio.get_running_loop()
+        side_events = asyncio.Queue()
+
+        def emit(event: dict) -> None:
+            loop.call_soon_threadsafe(side_events.put_nowait, event)
+
+        router_tools = _convert_router_tools(body.get("router_tools", []), session, emit)
+    elif body.get("router_tools"):
+        return JSONResponse({"error": "router_tools need a session"}, status_code=400)
+
+    # Build module, loading the state of a compiled program if given.
     try:
-        module = build_module(kind, signature, tools)
+        sig = build_signature(signature, body.get("instructions", ""), body.get("fields"))
+        module = build_module(kind, sig, tools, body.get("max_iters"), router_tools, session, emit)
+        apply_demos(module, body.get("demos"))
+        if body.get("program"):
+            module.load_state(body["program"])
     except ValueError as exc:
+        _pending.pop(session, None)
         return JSONResponse({"error": str(exc)}, status_code=400)
+    except Exception as exc:
+        _pending.pop(session, None)
+        logger.error("Load compiled program %s: %s", body.get("compiled"), traceback.format_exc())
+        return JSONResponse({"error": f"load compiled program: {exc}"}, status_code=400)
 
     # Prepare inputs — deserialise history field if present.
     inputs = dict(raw_inputs)
     if "history" in inputs:
         inputs["history"] = build_history_value(inputs["history"])
 
+    # Configure DSPy LM per-request using dspy.context (async-safe).
+    trace_headers, end_trace = start_trace(request, "dspy.invoke")
+    timeout = request_timeout(request)
+    if timeout is not None:
+        trace_headers[TIMEOUT_HEADER] = request.headers[TIMEOUT_HEADER]
+    lm = build_lm(model, auth_token, body.get("temperature"), trace_headers)
+
     if stream:
         async def _stream_with_ctx():
-            with dspy.context(lm=lm):
-                async for chunk in invoke_stream(module, inputs, signature, kind):
-                    yield chunk
+            try:
+                with dspy.context(lm=lm):
+                    async for chunk in invoke_stream(module, inputs, signature, kind, side_events, lm):
+                        yield chunk
+            finally:
+                for fut in _pending.pop(session, {}).values():
+                    fut.cancel()
+                end_trace()
 
         return StreamingResponse(
             _stream_with_ctx(),
@@ -377,21 +704,124 @@ async def invoke(request: Request):
                 with dspy.context(lm=lm):
                     return invoke_sync(module, inputs)
 
-            result = await asyncio.to_thread(_sync_with_ctx)
+            result = await asyncio.wait_for(asyncio.to_thread(_sync_with_ctx), timeout)
+            result["usage"] = lm_usage(lm)
             return JSONResponse(result)
+        except asyncio.TimeoutError:
+            logger.warning("Invoke deadline exceeded request_id=%s", request_id)
+            return JSONResponse({"error": "request deadline exceeded"}, status_code=504)
         except Exception as exc:
-            logger.error("Invoke error: %s", traceback.format_exc())
+            logger.error("Invoke error request_id=%s: %s", request_id, traceback.format_exc())
             return JSONResponse({"error": str(exc)}, status_code=500)
+        finally:
+            end_trace()
+
+
+@app.post("/tool_result")
+async def tool_result(request: Request):
+    """Deliver the result of a router tool call to the module awaiting it."""
+    try:
+        body = await request.json()
+    except Exception:
+        return JSONResponse({"error": "invalid JSON body"}, status_code=400)
+
+    fut = _pending.get(body.get("session", ""), {}).get(body.get("call_id", ""))
+    if fut is None or fut.done():
+        return JSONResponse({"error": "no pending tool call"}, status_code=404)
+    fut.set_result(str(body.get("result", "")))
+    return {"status": "ok"}
+
+
+def build_optimizer(name: str, metric):
+    """Instantiate the DSPy optimizer called *name*."""
+    if name == "BootstrapFewShot":
+        return dspy.BootstrapFewShot(metric=metric)
+    if name == "MIPROv2":
+        return dspy.MIPROv2(metric=metric, auto="light")
+    raise ValueError(f"Unknown optimizer: {name!r}")
+
+
+def _answer_match(example, prediction, trace=None) -> bool:
+    """Default metric: every labelled output field matches, ignoring case."""
+    for key, want in example.labels().items():
+        got = getattr(prediction, key, None)
+        if got is None or str(got).strip().lower() != str(want).strip().lower():
+            return False
+    return True
+
+
+@app.post("/compile")
+async def compile_program(request: Request):
+    """Optimize a DSPy module against a trainset and return its state."""
+    try:
+        body = await request.json()
+    except Exception:
+        return JSONResponse({"error": "invalid JSON body"}, status_code=400)
+
+    kind: str = body.get("kind", "cot")
+    signature: str = body.get("signature", "question -> answer")
+    model: str = body.get("model", DEFAULT_LM)
+    optimizer_name: str = body.get("optimizer", "BootstrapFewShot")
+    auth_token: str | None = request.headers.get("x-upstream-authorization", "").removeprefix("Bearer ").strip() or None
+
+    try:
+        sig = build_signature(signature, body.get("instructions", ""), body.get("fields"))
+        module = build_module(kind, sig)
+        apply_demos(module, body.get("demos"))
+        optimizer = build_optimizer(optimizer_name, _answer_match)
+    except ValueError as exc:
+        return JSONResponse({"error": str(exc)}, status_code=400)
+
+    trainset = []
+    for ex in body.get("trainset", []):
+        inputs = dict(ex.get("inputs", {}))
+        if "history" in inputs:
+            inputs["history"] = build_history_value(inputs["history"])
+        example = dspy.Example(**inputs, **ex.get("outputs", {}))
+        trainset.append(example.with_inputs(*inputs.keys()))
+    if not trainset:
+        return JSONResponse({"error": "empty trainset"}, status_code=400)
+
+    logger.info("compile kind=%s model=%s optimizer=%s examples=%d", kind, model, optimizer_name, len(trainset))
+    lm = build_lm(model, auth_token)
+    try:
+        def _compile_with_ctx():
+            with dspy.context(lm=lm):
+                return optimizer.compile(module, trainset=trainset)
+
+        compiled = await asyncio.to_thread(_compile_with_ctx)
+        return JSONResponse({"state": compiled.dump_state()})
+    except Exception as exc:
+        logger.error("Compile error: %s", traceback.format_exc())
+        return JSONResponse({"error": str(exc)}, status_code=500)
 
 
 @app.get("/health")
 async def health():
-    """Health check for the Go plugin to verify sidecar reachability."""
-    return {"status": "ok", "dspy_version": getattr(dspy, "__version__", "unknown")}
+    """Health check for the Go plugin to verify sidecar reachability, and
+    the capability handshake: the module kinds this sidecar can build."""
+    return {
+        "status": "ok",
+        "dspy_version": getattr(dspy, "__version__", "unknown"),
+        "kinds": supported_kinds(),
+    }
 
 
 # ─── Entrypoint ──────────────────────────────────────────────────────────────
 
+def tls_options() -> dict[str, Any]:
+    """uvicorn settings for the DSPY_TLS_* variables; empty for plain HTTP."""
+    if not TLS_CERT_FILE:
+        return {}
+    opts: dict[str, Any] = {"ssl_certfile": TLS_CERT_FILE, "ssl_keyfile": TLS_KEY_FILE or None}
+    if TLS_CLIENT_CA:
+        opts["ssl_ca_certs"] = TLS_CLIENT_CA
+        opts["ssl_cert_reqs"] = ssl.CERT_REQUIRED
+    return opts
+
+
 if __name__ == "__main__":
+    if not SIDECAR_SECRET:
+        logger.warning("DSPY_SIDECAR_SECRET is not set; any client reaching port %d can use this sidecar", SIDECAR_PORT)
     logger.info("Starting DSPy sidecar on port %d, router at %s", SIDECAR_PORT, ROUTER_BASE_URL)
-    uvicorn.run(app, host="0.0.0.0", port=SIDECAR_PORT, log_level="info")
+    uvicorn.run(app, host="0.0.0.0", port=SIDECAR_PORT, log_level="info", **tls_options())
@@ -0,0 +1,31 @@
+// Package bufpool recycles the byte buffers of the streaming hot path.
+// Framing SSE events and encoding AIL happen once per chunk, and a long
+// stream would otherwise allocate, and grow, a fresh buffer for each.
+package bufpool
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooled caps the capacity of a returned buffer; larger ones are left
+// to the GC, so one oversized response doesn't pin its memory.
+const maxPooled = 64 << 10
+
+var pool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Get returns an empty buffer. Return it with Put once its bytes are no
+// longer referenced.
+func Get() *bytes.Buffer {
+	buf := pool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// Put returns buf to the pool.
+func Put(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooled {
+		return
+	}
+	pool.Put(buf)
+}
@@ -0,0 +1,18 @@
+package bufpool
+
+import "testing"
+
+func TestGetPut(t *testing.T) {
+	buf := Get()
+	buf.WriteString("stale")
+	Put(buf)
+	if Get().Len() != 0 {
+		t.Error("Get should return an empty buffer")
+	}
+
+	// Oversized buffers are not kept; Put must not panic on them or nil.
+	big := Get()
+	big.Grow(2 * maxPooled)
+	Put(big)
+	Put(nil)
+}
@@ -0,0 +1,322 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+// ChoiceCount returns the number of choices a request asks for (the chat
+// "n" parameter, which parsers carry as EXT_DATA), or 1.
+func ChoiceCount(prog *ail.Program) int {
+	if i := choiceParam(prog); i >= 0 {
+		var n int
+		if json.Unmarshal(prog.Code[i].JSON, &n) == nil && n > 1 {
+			return n
+		}
+	}
+	return 1
+}
+
+func choiceParam(prog *ail.Program) int {
+	for i, inst := range prog.Code {
+		if inst.Op == ail.EXT_DATA && inst.Key == "n" {
+			return i
+		}
+	}
+	return -1
+}
+
+// WithChoices adapts a request's n parameter to the provider style.
+// Chat-completions upstreams take n natively for non-streaming requests.
+// Otherwise n is stripped, since other APIs reject it, and for n > 1 the
+// returned command fans out n parallel requests and merges their results.
+// Streamed choices are merged too, because the chat stream parser drops
+// the choice index of native multi-choice streams.
+func WithChoices(style ail.Style, prog *ail.Program, cmd InferenceCommand) (*ail.Program, InferenceCommand) {
+	i := choiceParam(prog)
+	if i < 0 {
+		return prog, cmd
+	}
+	n := ChoiceCount(prog)
+	if style == ail.StyleChatCompletions && (n == 1 || !prog.IsStreaming()) {
+		return prog, cmd
+	}
+	prog = prog.ClearAtIndex(i)
+	if n == 1 {
+		return prog, cmd
+	}
+	return prog, &choicesCommand{inner: cmd, n: n}
+}
+
+// choicesCommand emulates n choices with n parallel upstream requests.
+// Usage is summed over the requests, since each one is billed.
+type choicesCommand struct {
+	inner InferenceCommand
+	n     int
+}
+
+func (c *choicesCommand) DoInference(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, *ail.Program, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		errRes   *http.Response
+	)
+	resps := make([]*http.Response, c.n)
+	outs := make([]*ail.Program, c.n)
+	for i := range c.n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, out, err := c.inner.DoInference(p, prog.Clone(), r)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr, errRes = err, res
+				}
+				mu.Unlock()
+				cancel() // one failed choice fails the request
+				return
+			}
+			resps[i], outs[i] = res, out
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return errRes, nil, firstErr
+	}
+	return resps[0], mergeChoices(outs), nil
+}
+
+// DryRun reports the inner request, sent once per choice.
+func (c *choicesCommand) DryRun(p *services.ProviderService, prog *ail.Program) (*UpstreamRequest, error) {
+	req, err := DryRun(c.inner, p, prog)
+	if err != nil {
+		return nil, err
+	}
+	req.Requests *= c.n
+	return req, nil
+}
+
+// mergeChoices concatenates the messages of single-choice responses into
+// one multi-choice response. Response-level instructions come from the
+// first; each message keeps the finish reason that follows it.
+func mergeChoices(progs []*ail.Program) *ail.Program {
+	merged := ail.NewProgram()
+	usage := make([]choiceUsage, len(progs))
+	for i, prog := range progs {
+		depth := 0
+		for _, inst := range prog.Code {
+			switch inst.Op {
+			case ail.USAGE:
+				usage[i].observe(inst.JSON)
+				continue
+			case ail.MSG_START:
+				depth++
+			case ail.MSG_END:
+				depth--
+			default:
+				if i > 0 && depth == 0 && inst.Op != ail.RESP_DONE {
+					continue
+				}
+			}
+			merged.Code = append(merged.Code, inst)
+		}
+	}
+	emitUsage(merged, usage)
+	return merged
+}
+
+func (c *choicesCommand) DoInferenceStream(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, chan InferenceStreamChunk, error) {
+	clientCtx := r.Context()
+	ctx, cancel := context.WithCancel(clientCtx)
+	r = r.WithContext(ctx)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		errRes   *http.Response
+	)
+	resps := make([]*http.Response, c.n)
+	streams := make([]chan InferenceStreamChunk, c.n)
+	for i := range c.n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, stream, err := c.inner.DoInferenceStream(p, prog.Clone(), r)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr, errRes = err, res
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			resps[i], streams[i] = res, stream
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		// Release the streams that did start; their drivers stop on the
+		// cancelled context.
+		for _, stream := range streams {
+			if stream != nil {
+				go drainStream(stream)
+			}
+		}
+		cancel()
+		return errRes, nil, firstErr
+	}
+
+	type choiceChunk struct {
+		index int
+		InferenceStreamChunk
+	}
+	tagged := make(chan choiceChunk)
+	var forwarders sync.WaitGroup
+	for i, stream := range streams {
+		forwarders.Add(1)
+		go func() {
+			defer forwarders.Done()
+			for chunk := range stream {
+				select {
+				case tagged <- choiceChunk{i, chunk}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		forwarders.Wait()
+		close(tagged)
+	}()
+
+	out := make(chan InferenceStreamChunk)
+	go func() {
+		defer close(out)
+		defer cancel()
+		send := func(chunk InferenceStreamChunk) bool {
+			select {
+			case out <- chunk:
+				return true
+			case <-clientCtx.Done():
+				cancel()
+				return false
+			}
+		}
+
+		var (
+			usage  = make([]choiceUsage, c.n)
+			respID string
+			failed bool
+		)
+		for tc := range tagged {
+			if failed {
+				continue // draining after an error
+			}
+			if tc.RuntimeError != nil {
+				failed = true
+				send(tc.InferenceStreamChunk)
+				cancel()
+				continue
+			}
+			chunk := ail.NewProgram()
+			for _, inst := range tc.Data.Code {
+				switch inst.Op {
+				case ail.USAGE:
+					usage[tc.index].observe(inst.JSON)
+					continue
+				case ail.RESP_ID:
+					// Every chunk of the response carries the same id.
+					if respID == "" {
+						respID = inst.Str
+					}
+					inst.Str = respID
+				}
+				chunk.Code = append(chunk.Code, inst)
+			}
+			if chunk.Len() == 0 {
+				continue
+			}
+			chunk.EmitKeyVal(ail.SET_META, styles.ChoiceIndexMeta, strconv.Itoa(tc.index))
+			if !send(InferenceStreamChunk{Data: chunk}) {
+				failed = true
+			}
+		}
+		if chunk := ail.NewProgram(); !failed && emitUsage(chunk, usage) {
+			send(InferenceStreamChunk{Data: chunk})
+		}
+	}()
+	return resps[0], out, nil
+}
+
+func drainStream(stream chan InferenceStreamChunk) {
+	for range stream {
+	}
+}
+
+// choiceUsage keeps the latest token counts reported for one choice; a
+// stream may report prompt and completion tokens in different events.
+type choiceUsage struct {
+	seen               bool
+	prompt, completion int
+}
+
+func (u *choiceUsage) observe(raw json.RawMessage) {
+	var v struct {
+		PromptTokens     *int `json:"prompt_tokens"`
+		CompletionTokens *int `json:"completion_tokens"`
+	}
+	if json.Unmarshal(raw, &v) != nil {
+		return
+	}
+	u.seen = true
+	if v.PromptTokens != nil {
+		u.prompt = *v.PromptTokens
+	}
+	if v.CompletionTokens != nil {
+		u.completion = *v.CompletionTokens
+	}
+}
+
+// emitUsage appends the usage summed over the choices that reported any,
+// and reports whether there was some.
+func emitUsage(prog *ail.Program, usage []choiceUsage) bool {
+	var prompt, completion int
+	seen := false
+	for _, u := range usage {
+		if u.seen {
+			seen = true
+			prompt += u.prompt
+			completion += u.completion
+		}
+	}
+	if !seen {
+		return false
+	}
+	j, _ := json.Marshal(map[string]int{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	})
+	prog.EmitJSON(ail.USAGE, j)
+	return true
+}
+
+var (
+	_ InferenceCommand = (*choicesCommand)(nil)
+	_ DryRunCommand    = (*choicesCommand)(nil)
+)
@@ -0,0 +1,162 @@
+package drivers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+// fakeChoices answers each request with a numbered single choice.
+type fakeChoices struct {
+	calls atomic.Int32
+}
+
+func (f *fakeChoices) DoInference(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, *ail.Program, error) {
+	n := strconv.Itoa(int(f.calls.Add(1)))
+	out := ail.NewProgram()
+	out.EmitString(ail.RESP_ID, "resp_"+n)
+	out.Emit(ail.MSG_START)
+	out.Emit(ail.ROLE_AST)
+	out.EmitString(ail.TXT_CHUNK, "answer "+n)
+	out.Emit(ail.MSG_END)
+	out.EmitString(ail.RESP_DONE, "stop")
+	out.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":10,"completion_tokens":2}`))
+	return &http.Response{}, out, nil
+}
+
+func (f *fakeChoices) DoInferenceStream(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, chan InferenceStreamChunk, error) {
+	n := strconv.Itoa(int(f.calls.Add(1)))
+	ch := make(chan InferenceStreamChunk, 3)
+	start := ail.NewProgram()
+	start.EmitString(ail.RESP_ID, "chunk_"+n)
+	start.Emit(ail.STREAM_START)
+	ch <- InferenceStreamChunk{Data: start}
+	delta := ail.NewProgram()
+	delta.EmitString(ail.STREAM_DELTA, "answer "+n)
+	ch <- InferenceStreamChunk{Data: delta}
+	end := ail.NewProgram()
+	end.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":10,"completion_tokens":2}`))
+	end.Emit(ail.STREAM_END)
+	ch <- InferenceStreamChunk{Data: end}
+	close(ch)
+	return &http.Response{}, ch, nil
+}
+
+func choicesProgram(stream bool) *ail.Program {
+	prog := testProgram(stream)
+	prog.EmitKeyJSON(ail.EXT_DATA, "n", json.RawMessage("3"))
+	return prog
+}
+
+func TestWithChoices(t *testing.T) {
+	fake := &fakeChoices{}
+
+	prog, cmd := WithChoices(ail.StyleChatCompletions, choicesProgram(false), fake)
+	if cmd != fake || ChoiceCount(prog) != 3 {
+		t.Error("chat-completions should take n natively")
+	}
+	prog, cmd = WithChoices(ail.StyleChatCompletions, choicesProgram(true), fake)
+	if cmd == fake || ChoiceCount(prog) != 1 || choiceParam(prog) >= 0 {
+		t.Error("streamed choices should fan out with n stripped")
+	}
+	prog, cmd = WithChoices(ail.StyleAnthropic, choicesProgram(false), fake)
+	if cmd == fake || choiceParam(prog) >= 0 {
+		t.Error("anthropic should fan out with n stripped")
+	}
+
+	one := testProgram(false)
+	one.EmitKeyJSON(ail.EXT_DATA, "n", json.RawMessage("1"))
+	prog, cmd = WithChoices(ail.StyleAnthropic, one, fake)
+	if cmd != fake || choiceParam(prog) >= 0 {
+		t.Error("n=1 should be stripped for anthropic without fanning out")
+	}
+}
+
+func TestChoicesCommand_MergesResponses(t *testing.T) {
+	fake := &fakeChoices{}
+	prog, cmd := WithChoices(ail.StyleAnthropic, choicesProgram(false), fake)
+	_, out, err := cmd.DoInference(nil, prog, httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls.Load() != 3 {
+		t.Fatalf("upstream calls = %d, want 3", fake.calls.Load())
+	}
+
+	body, err := (&ail.ChatCompletionsEmitter{}).EmitResponse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Choices []struct {
+			Index        int    `json:"index"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Choices) != 3 || resp.Usage.TotalTokens != 36 {
+		t.Fatalf("response = %s", body)
+	}
+	for i, c := range resp.Choices {
+		if c.Index != i || c.FinishReason != "stop" {
+			t.Errorf("choice %d = %+v", i, c)
+		}
+	}
+}
+
+func TestChoicesCommand_TagsStreamChunks(t *testing.T) {
+	prog, cmd := WithChoices(ail.StyleAnthropic, choicesProgram(true), &fakeChoices{})
+	_, stream, err := cmd.DoInferenceStream(nil, prog, httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]bool{}
+	deltas := map[string]int{}
+	usages := 0
+	for chunk := range stream {
+		if chunk.RuntimeError != nil {
+			t.Fatal(chunk.RuntimeError)
+		}
+		index := ""
+		for _, inst := range chunk.Data.Code {
+			if inst.Op == ail.SET_META && inst.Key == styles.ChoiceIndexMeta {
+				index = inst.Str
+			}
+		}
+		for _, inst := range chunk.Data.Code {
+			switch inst.Op {
+			case ail.RESP_ID:
+				ids[inst.Str] = true
+			case ail.STREAM_DELTA:
+				deltas[index]++
+			case ail.USAGE:
+				usages++
+				if string(inst.JSON) != `{"completion_tokens":6,"prompt_tokens":30,"total_tokens":36}` {
+					t.Errorf("usage = %s", inst.JSON)
+				}
+			}
+		}
+	}
+	if len(ids) != 1 {
+		t.Errorf("response ids = %v, want one", ids)
+	}
+	if deltas["0"] != 1 || deltas["1"] != 1 || deltas["2"] != 1 {
+		t.Errorf("deltas by choice = %v", deltas)
+	}
+	if usages != 1 {
+		t.Errorf("usage chunks = %d, want 1 summed", usages)
+	}
+}
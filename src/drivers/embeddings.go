@@ -0,0 +1,190 @@
+package drivers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Defaults of embeddings batching: inputs per upstream request (OpenAI's
+// limit), and batches of one request sent at once.
+const (
+	DefaultEmbeddingsBatchSize   = 2048
+	DefaultEmbeddingsConcurrency = 4
+)
+
+// EmbeddingsCommand creates embeddings. AIL has no embeddings opcodes, so
+// requests and responses are in the OpenAI embeddings format.
+type EmbeddingsCommand interface {
+	DoEmbeddings(p *services.ProviderService, req *EmbeddingsRequest, r *http.Request) (*EmbeddingsResponse, error)
+}
+
+// EmbeddingsRequest is a parsed OpenAI embeddings request.
+type EmbeddingsRequest struct {
+	Model string
+	// Inputs holds one entry per embedding wanted: a string or an array
+	// of token IDs.
+	Inputs []json.RawMessage
+	// Extra holds the other fields (dimensions, encoding_format, user),
+	// passed on unchanged.
+	Extra map[string]json.RawMessage
+	// single is set when input was one string or token array rather than
+	// a list of them, and is sent back that way.
+	single bool
+}
+
+// ParseEmbeddingsRequest parses an OpenAI embeddings request body.
+func ParseEmbeddingsRequest(body []byte) (*EmbeddingsRequest, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("invalid embeddings request: %v", err)
+	}
+	req := &EmbeddingsRequest{Extra: fields}
+	if err := json.Unmarshal(fields["model"], &req.Model); err != nil || req.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	input := bytes.TrimSpace(fields["input"])
+	delete(fields, "model")
+	delete(fields, "input")
+	switch {
+	case len(input) == 0 || bytes.Equal(input, []byte("null")):
+		return nil, errors.New("input is required")
+	case input[0] != '[':
+		req.Inputs, req.single = []json.RawMessage{input}, true
+		return req, nil
+	}
+	if err := json.Unmarshal(input, &req.Inputs); err != nil {
+		return nil, fmt.Errorf("invalid input: %v", err)
+	}
+	if len(req.Inputs) == 0 {
+		return nil, errors.New("input must not be empty")
+	}
+	// An array of numbers is one tokenized input, not a list of inputs.
+	if first := bytes.TrimSpace(req.Inputs[0]); len(first) > 0 && first[0] != '"' && first[0] != '[' {
+		req.Inputs, req.single = []json.RawMessage{input}, true
+	}
+	return req, nil
+}
+
+// Body encodes the request for the upstream.
+func (r *EmbeddingsRequest) Body() ([]byte, error) {
+	fields := make(map[string]any, len(r.Extra)+2)
+	for k, v := range r.Extra {
+		fields[k] = v
+	}
+	fields["model"] = r.Model
+	if r.single && len(r.Inputs) == 1 {
+		fields["input"] = r.Inputs[0]
+	} else {
+		fields["input"] = r.Inputs
+	}
+	return json.Marshal(fields)
+}
+
+// Split splits the request into requests of at most size inputs each, in
+// input order. A request that fits is returned as is.
+func (r *EmbeddingsRequest) Split(size int) []*EmbeddingsRequest {
+	if size <= 0 || len(r.Inputs) <= size {
+		return []*EmbeddingsRequest{r}
+	}
+	var batches []*EmbeddingsRequest
+	for start := 0; start < len(r.Inputs); start += size {
+		end := min(start+size, len(r.Inputs))
+		batches = append(batches, &EmbeddingsRequest{Model: r.Model, Inputs: r.Inputs[start:end], Extra: r.Extra})
+	}
+	return batches
+}
+
+// Embedding is one embedding of a response, of the input at Index.
+type Embedding struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"` // floats, or base64
+}
+
+// EmbeddingsUsage is the token usage of an embeddings response.
+type EmbeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// EmbeddingsResponse is an OpenAI embeddings response.
+type EmbeddingsResponse struct {
+	Object string          `json:"object"`
+	Data   []Embedding     `json:"data"`
+	Model  string          `json:"model"`
+	Usage  EmbeddingsUsage `json:"usage"`
+}
+
+// MergeEmbeddings joins the responses to consecutive batches of one
+// request: embeddings are renumbered by their input's position in the
+// whole request and usage is summed.
+func MergeEmbeddings(batches []*EmbeddingsResponse, sizes []int) *EmbeddingsResponse {
+	out := &EmbeddingsResponse{Object: "list"}
+	offset := 0
+	for i, b := range batches {
+		if out.Model == "" {
+			out.Model = b.Model
+		}
+		for _, e := range b.Data {
+			e.Index += offset
+			out.Data = append(out.Data, e)
+		}
+		out.Usage.PromptTokens += b.Usage.PromptTokens
+		out.Usage.TotalTokens += b.Usage.TotalTokens
+		offset += sizes[i]
+	}
+	return out
+}
+
+// BatchEmbeddings splits req into batches of at most size inputs, sends
+// up to concurrency of them at once through send and merges the answers
+// in input order. The first failure cancels the batches still running
+// and is returned.
+func BatchEmbeddings(ctx context.Context, req *EmbeddingsRequest, size, concurrency int,
+	send func(ctx context.Context, batch *EmbeddingsRequest) (*EmbeddingsResponse, error)) (*EmbeddingsResponse, error) {
+	batches := req.Split(size)
+	if len(batches) == 1 {
+		return send(ctx, req)
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultEmbeddingsConcurrency
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]*EmbeddingsResponse, len(batches))
+	sizes := make([]int, len(batches))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		sizes[i] = len(batch.Inputs)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			res, err := send(ctx, batch)
+			if err != nil {
+				cancel(fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err))
+				return
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	return MergeEmbeddings(results, sizes), nil
+}
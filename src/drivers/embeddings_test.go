@@ -0,0 +1,133 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseEmbeddingsRequest(t *testing.T) {
+	for _, tc := range []struct {
+		body   string
+		inputs int
+		single bool
+	}{
+		{`{"model":"e","input":"hello"}`, 1, true},
+		{`{"model":"e","input":[1,2,3]}`, 1, true},
+		{`{"model":"e","input":["a","b","c"]}`, 3, false},
+		{`{"model":"e","input":[[1,2],[3]]}`, 2, false},
+	} {
+		req, err := ParseEmbeddingsRequest([]byte(tc.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.body, err)
+		}
+		if len(req.Inputs) != tc.inputs || req.single != tc.single {
+			t.Errorf("%s: %d inputs, single %v", tc.body, len(req.Inputs), req.single)
+		}
+		// A request sent whole goes upstream as the client wrote it.
+		body, err := req.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want any
+		_ = json.Unmarshal(body, &got)
+		_ = json.Unmarshal([]byte(tc.body), &want)
+		if gb, _ := json.Marshal(got); string(gb) != mustMarshal(want) {
+			t.Errorf("%s: body = %s", tc.body, body)
+		}
+	}
+
+	for _, body := range []string{`{"input":"x"}`, `{"model":"e"}`, `{"model":"e","input":[]}`, `not json`} {
+		if _, err := ParseEmbeddingsRequest([]byte(body)); err == nil {
+			t.Errorf("%s: accepted", body)
+		}
+	}
+}
+
+func mustMarshal(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestEmbeddingsSplit(t *testing.T) {
+	req, err := ParseEmbeddingsRequest([]byte(`{"model":"e","input":["a","b","c","d","e"],"dimensions":256}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := req.Split(2)
+	if len(batches) != 3 || len(batches[0].Inputs) != 2 || len(batches[2].Inputs) != 1 {
+		t.Fatalf("batches = %+v", batches)
+	}
+	body, _ := batches[2].Body()
+	if string(body) != `{"dimensions":256,"input":["e"],"model":"e"}` {
+		t.Errorf("last batch body = %s", body)
+	}
+	if got := req.Split(5); len(got) != 1 || got[0] != req {
+		t.Errorf("a request that fits was split: %+v", got)
+	}
+}
+
+// embedEcho answers a batch with one embedding per input, echoing it.
+func embedEcho(_ context.Context, batch *EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	res := &EmbeddingsResponse{Object: "list", Model: batch.Model}
+	for i, in := range batch.Inputs {
+		res.Data = append(res.Data, Embedding{Object: "embedding", Index: i, Embedding: in})
+	}
+	res.Usage = EmbeddingsUsage{PromptTokens: len(batch.Inputs), TotalTokens: len(batch.Inputs)}
+	return res, nil
+}
+
+func TestBatchEmbeddings(t *testing.T) {
+	req, err := ParseEmbeddingsRequest([]byte(`{"model":"e","input":["0","1","2","3","4","5","6"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inFlight, peak atomic.Int32
+	send := func(ctx context.Context, batch *EmbeddingsRequest) (*EmbeddingsResponse, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		// Earlier batches finish last, so order comes from the merge.
+		var first string
+		_ = json.Unmarshal(batch.Inputs[0], &first)
+		time.Sleep(time.Duration('9'-first[0]) * time.Millisecond)
+		return embedEcho(ctx, batch)
+	}
+	res, err := BatchEmbeddings(context.Background(), req, 2, 2, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data) != 7 || res.Usage.TotalTokens != 7 || res.Model != "e" {
+		t.Fatalf("res = %+v", res)
+	}
+	for i, e := range res.Data {
+		var in string
+		_ = json.Unmarshal(e.Embedding, &in)
+		if e.Index != i || in != string(rune('0'+i)) {
+			t.Errorf("data[%d] = index %d of input %q", i, e.Index, in)
+		}
+	}
+	if peak.Load() > 2 {
+		t.Errorf("%d batches ran at once, want at most 2", peak.Load())
+	}
+
+	boom := errors.New("boom")
+	_, err = BatchEmbeddings(context.Background(), req, 2, 4, func(ctx context.Context, batch *EmbeddingsRequest) (*EmbeddingsResponse, error) {
+		if string(batch.Inputs[0]) == `"2"` {
+			return nil, boom
+		}
+		return embedEcho(ctx, batch)
+	})
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "batch 2 of 4") {
+		t.Errorf("failed batch: err = %v", err)
+	}
+}
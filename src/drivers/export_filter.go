@@ -7,9 +7,9 @@ import (
 )
 
 // ExportFilteredListModels wraps a ListModelsCommand and filters its results
-// to only include models that appear in the provider's ExportedModels set.
-// When ExportedModels is nil or empty the inner command's results pass through
-// untouched.
+// to only include models the provider exports (ExportedModels and
+// ExportPatterns). When no exports are configured the inner command's
+// results pass through untouched.
 //
 // This wrapper is applied during provider provisioning so that every consumer
 // (the /models endpoint, the fuzz plugin, etc.) automatically sees only the
@@ -31,13 +31,13 @@ func (f *ExportFilteredListModels) DoListModels(p *services.ProviderService, r *
 		return nil, err
 	}
 
-	if len(p.ExportedModels) == 0 {
+	if !p.HasExports() {
 		return models, nil
 	}
 
-	filtered := make([]ListModelsModel, 0, len(p.ExportedModels))
+	filtered := make([]ListModelsModel, 0, len(models))
 	for _, m := range models {
-		if p.ExportedModels[m.ID] {
+		if p.IsModelExported(m.ID) {
 			filtered = append(filtered, m)
 		}
 	}
@@ -0,0 +1,55 @@
+package drivers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+type staticListModels []string
+
+func (s staticListModels) DoListModels(*services.ProviderService, *http.Request) ([]ListModelsModel, error) {
+	models := make([]ListModelsModel, len(s))
+	for i, id := range s {
+		models[i] = ListModelsModel{ID: id}
+	}
+	return models, nil
+}
+
+func TestExportFilteredListModels(t *testing.T) {
+	inner := staticListModels{"gpt-4o", "gpt-4.1-mini", "gpt-3.5-turbo", "o3", "text-embedding-3-small"}
+	tests := []struct {
+		name     string
+		exact    map[string]bool
+		patterns []string
+		private  bool
+		want     []string
+	}{
+		{"none", nil, nil, false, []string(inner)},
+		{"exact", map[string]bool{"o3": true}, nil, false, []string{"o3"}},
+		{"glob", nil, []string{"gpt-4*"}, false, []string{"gpt-4o", "gpt-4.1-mini"}},
+		{"both", map[string]bool{"o3": true}, []string{"*embedding*"}, false, []string{"o3", "text-embedding-3-small"}},
+		{"private", nil, []string{"*"}, true, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &services.ProviderService{ExportedModels: tt.exact, ExportPatterns: tt.patterns, Private: tt.private}
+			models, err := (&ExportFilteredListModels{Inner: inner}).DoListModels(p, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, m := range models {
+				got = append(got, m.ID)
+				if !p.IsModelExported(m.ID) {
+					t.Errorf("%s listed but not exported", m.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package drivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gbnfPrimitives are the shared rules of every generated grammar, after
+// the grammar in llama.cpp's json.gbnf.
+var gbnfPrimitives = []string{
+	`ws ::= [ \t\n]{0,20}`,
+	`string ::= "\"" ( [^"\\\x7F\x00-\x1F] | "\\" ( ["\\/bfnrt] | "u" [0-9a-fA-F]{4} ) )* "\""`,
+	`integer ::= "-"? ( "0" | [1-9] [0-9]{0,15} )`,
+	`number ::= integer ( "." [0-9]+ )? ( [eE] [-+]? [0-9]+ )?`,
+	`boolean ::= "true" | "false"`,
+	`null ::= "null"`,
+	`value ::= object | array | string | number | boolean | null`,
+	`object ::= "{" ws ( string ws ":" ws value ( ws "," ws string ws ":" ws value )* )? ws "}"`,
+	`array ::= "[" ws ( value ( ws "," ws value )* )? ws "]"`,
+}
+
+// schemaGrammar converts a JSON schema to a GBNF grammar whose root rule
+// matches the JSON documents the schema describes. It covers type, enum,
+// const, properties with required (in declaration order), items,
+// anyOf/oneOf and local $refs; other keywords are not enforced.
+func schemaGrammar(raw json.RawMessage) (string, error) {
+	g := &grammar{refs: map[string]string{}, seen: map[string]bool{}}
+	if !json.Valid(raw) {
+		return "", fmt.Errorf("invalid schema")
+	}
+	g.rawDefs = localRawRefs(raw)
+	body, err := g.rule(raw, "root", 0)
+	if err != nil {
+		return "", err
+	}
+	g.add("root", body)
+
+	var b strings.Builder
+	for _, r := range g.rules {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+	for _, r := range gbnfPrimitives {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+type grammar struct {
+	rules   []string
+	seen    map[string]bool
+	refs    map[string]string // $ref → rule name
+	rawDefs map[string]json.RawMessage
+}
+
+var gbnfNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// name returns an unused rule name derived from hint.
+func (g *grammar) name(hint string) string {
+	base := strings.Trim(gbnfNameInvalid.ReplaceAllString(hint, "-"), "-")
+	if base == "" {
+		base = "r"
+	}
+	name := base
+	for i := 2; g.seen[name] || isPrimitive(name); i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.seen[name] = true
+	return name
+}
+
+func isPrimitive(name string) bool {
+	for _, r := range gbnfPrimitives {
+		if strings.HasPrefix(r, name+" ::=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *grammar) add(name, body string) {
+	g.seen[name] = true
+	g.rules = append(g.rules, name+" ::= "+body)
+}
+
+// ref returns a rule name for a sub-schema, adding its rule.
+func (g *grammar) ref(raw json.RawMessage, hint string, depth int) (string, error) {
+	body, err := g.rule(raw, hint, depth)
+	if err != nil {
+		return "", err
+	}
+	if gbnfIdent(body) {
+		return body, nil
+	}
+	name := g.name(hint)
+	g.add(name, body)
+	return name, nil
+}
+
+func gbnfIdent(s string) bool {
+	return s != "" && !gbnfNameInvalid.MatchString(s)
+}
+
+// rule returns the GBNF expression matching raw.
+func (g *grammar) rule(raw json.RawMessage, hint string, depth int) (string, error) {
+	if depth > 64 {
+		return "", fmt.Errorf("schema nests too deeply")
+	}
+	var s struct {
+		Ref        string            `json:"$ref"`
+		Type       json.RawMessage   `json:"type"`
+		Enum       []any             `json:"enum"`
+		Const      json.RawMessage   `json:"const"`
+		AnyOf      []json.RawMessage `json:"anyOf"`
+		OneOf      []json.RawMessage `json:"oneOf"`
+		Properties json.RawMessage   `json:"properties"`
+		Required   []string          `json:"required"`
+		Items      json.RawMessage   `json:"items"`
+	}
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("true")) {
+		return "value", nil
+	}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("invalid schema at %s: %w", hint, err)
+	}
+
+	if s.Ref != "" {
+		if name, ok := g.refs[s.Ref]; ok {
+			return name, nil
+		}
+		target, ok := g.rawDefs[s.Ref]
+		if !ok {
+			return "", fmt.Errorf("unresolved $ref %q", s.Ref)
+		}
+		// Name the rule before generating it, so recursive schemas
+		// refer back to it.
+		name := g.name(s.Ref[strings.LastIndex(s.Ref, "/")+1:])
+		g.refs[s.Ref] = name
+		body, err := g.rule(target, name, depth+1)
+		if err != nil {
+			return "", err
+		}
+		g.add(name, body)
+		return name, nil
+	}
+
+	if len(s.Const) > 0 {
+		return gbnfLiteral(s.Const), nil
+	}
+	if len(s.Enum) > 0 {
+		alts := make([]string, 0, len(s.Enum))
+		for _, v := range s.Enum {
+			j, _ := json.Marshal(v)
+			alts = append(alts, gbnfLiteral(j))
+		}
+		return "( " + strings.Join(alts, " | ") + " )", nil
+	}
+	if alts := append(s.AnyOf, s.OneOf...); len(alts) > 0 {
+		names := make([]string, 0, len(alts))
+		for i, a := range alts {
+			n, err := g.ref(a, hint+"-"+strconv.Itoa(i), depth+1)
+			if err != nil {
+				return "", err
+			}
+			names = append(names, n)
+		}
+		return "( " + strings.Join(names, " | ") + " )", nil
+	}
+
+	var types []string
+	if len(s.Type) > 0 {
+		var one string
+		if json.Unmarshal(s.Type, &one) == nil {
+			types = []string{one}
+		} else if err := json.Unmarshal(s.Type, &types); err != nil {
+			return "", fmt.Errorf("invalid type at %s", hint)
+		}
+	}
+	if len(types) > 1 {
+		alts := make([]string, 0, len(types))
+		for _, t := range types {
+			body, err := g.typed(t, s.Properties, s.Required, s.Items, hint+"-"+t, depth)
+			if err != nil {
+				return "", err
+			}
+			alts = append(alts, body)
+		}
+		return "( " + strings.Join(alts, " | ") + " )", nil
+	}
+	t := ""
+	if len(types) == 1 {
+		t = types[0]
+	} else if len(s.Properties) > 0 {
+		t = "object"
+	} else if len(s.Items) > 0 {
+		t = "array"
+	}
+	return g.typed(t, s.Properties, s.Required, s.Items, hint, depth)
+}
+
+func (g *grammar) typed(t string, props json.RawMessage, required []string, items json.RawMessage, hint string, depth int) (string, error) {
+	switch t {
+	case "string", "integer", "number", "boolean", "null":
+		return t, nil
+	case "array":
+		if len(items) == 0 {
+			return "array", nil
+		}
+		item, err := g.ref(items, hint+"-item", depth+1)
+		if err != nil {
+			return "", err
+		}
+		return `"[" ws ( ` + item + ` ( ws "," ws ` + item + ` )* )? ws "]"`, nil
+	case "object":
+		if len(props) == 0 {
+			return "object", nil
+		}
+		return g.object(props, required, hint, depth)
+	case "":
+		return "value", nil
+	}
+	return "", fmt.Errorf("unsupported type %q at %s", t, hint)
+}
+
+// object matches the declared properties in declaration order; required
+// ones must appear, optional ones may be left out. For the properties
+// from i on, first(i) is the grammar when none has been written yet and
+// rest(i) when one has, so commas only separate written properties.
+func (g *grammar) object(props json.RawMessage, required []string, hint string, depth int) (string, error) {
+	keys, err := orderedKeys(props)
+	if err != nil {
+		return "", fmt.Errorf("invalid properties at %s: %w", hint, err)
+	}
+	var byName map[string]json.RawMessage
+	_ = json.Unmarshal(props, &byName)
+	req := make(map[string]bool, len(required))
+	for _, r := range required {
+		req[r] = true
+	}
+
+	kvs := make([]string, len(keys))
+	for i, k := range keys {
+		v, err := g.ref(byName[k], hint+"-"+k, depth+1)
+		if err != nil {
+			return "", err
+		}
+		kj, _ := json.Marshal(k)
+		kvs[i] = gbnfLiteral(kj) + ` ws ":" ws ` + v
+	}
+
+	n := len(keys)
+	first, rest := make([]string, n+1), make([]string, n+1)
+	for i := n - 1; i >= 0; i-- {
+		var restBody, firstBody string
+		if req[keys[i]] {
+			restBody = seq(`ws "," ws `+kvs[i], rest[i+1])
+			firstBody = seq(kvs[i], rest[i+1])
+		} else {
+			restBody = seq(`( ws "," ws `+kvs[i]+` )?`, rest[i+1])
+			firstBody = "( " + seq(kvs[i], rest[i+1])
+			if first[i+1] != "" {
+				firstBody += " | " + first[i+1]
+			}
+			firstBody += " )"
+			if first[i+1] == "" {
+				firstBody += "?"
+			}
+		}
+		if i > 0 { // nothing precedes the first property
+			rest[i] = g.named(hint+"-rest", restBody)
+		}
+		first[i] = g.named(hint+"-first", firstBody)
+	}
+	return seq(`"{" ws`, first[0], `ws "}"`), nil
+}
+
+// named adds body as a rule and returns its name; empty bodies stay empty.
+func (g *grammar) named(hint, body string) string {
+	if body == "" {
+		return ""
+	}
+	name := g.name(hint)
+	g.add(name, body)
+	return name
+}
+
+func seq(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// gbnfLiteral quotes JSON text as a GBNF string literal.
+func gbnfLiteral(j []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range string(j) {
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// orderedKeys returns the keys of a JSON object in document order.
+func orderedKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// localRawRefs is localRefs keeping the raw JSON, so property order
+// survives.
+func localRawRefs(raw json.RawMessage) map[string]json.RawMessage {
+	refs := map[string]json.RawMessage{"#": raw}
+	var root map[string]json.RawMessage
+	if json.Unmarshal(raw, &root) != nil {
+		return refs
+	}
+	for _, key := range []string{"$defs", "definitions"} {
+		var m map[string]json.RawMessage
+		if json.Unmarshal(root[key], &m) != nil {
+			continue
+		}
+		for name, v := range m {
+			refs["#/"+key+"/"+name] = v
+		}
+	}
+	return refs
+}
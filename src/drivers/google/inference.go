@@ -0,0 +1,401 @@
+// Package google drives the Gemini API's own generateContent and
+// streamGenerateContent endpoints, for providers declared with style
+// google-genai.
+package google
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+)
+
+// NewInference returns the inference command of a Gemini provider. The
+// ail Google GenAI emitter and parsers do the translation; on top of them
+// the driver:
+//
+//   - puts the model in the URL, with ?alt=sse when streaming, and the
+//     provider credential in x-goog-api-key;
+//   - names tool results after their function, which Gemini matches them
+//     by, and wraps results that are not JSON objects in {"result": ...};
+//   - merges tool declarations into one tool, with their parameters in
+//     Gemini's schema dialect, and consecutive contents of one role;
+//   - sends the provider's safety settings, unless the request has its own;
+//   - gives function calls IDs, and maps finish reasons and blocked
+//     prompts onto the chat completions ones.
+func NewInference(safety []SafetySetting) (*drivers.InferenceSse, error) {
+	resp, err := ail.GetResponseParser(ail.StyleGoogleGenAI)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := ail.GetStreamChunkParser(ail.StyleGoogleGenAI)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.NewUpstreamInference(ail.StyleGoogleGenAI, &upstream{safety: safety, resp: resp, chunks: chunks})
+}
+
+// upstream implements drivers.Upstream for the Gemini API.
+type upstream struct {
+	safety []SafetySetting
+	resp   ail.ResponseParser
+	chunks ail.StreamChunkParser
+}
+
+// Target implements drivers.Upstream. The provider URL is the API root,
+// such as https://generativelanguage.googleapis.com/v1beta; models may be
+// given with or without their "models/" prefix, and tuned models by their
+// full resource name.
+func (u *upstream) Target(base url.URL, prog *ail.Program, stream bool) url.URL {
+	model := prog.GetModel()
+	if !strings.Contains(model, "/") {
+		model = "models/" + model
+	}
+	method := ":generateContent"
+	if stream {
+		method = ":streamGenerateContent"
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + model + method
+	if stream {
+		q := base.Query()
+		q.Set("alt", "sse")
+		base.RawQuery = q.Encode()
+	}
+	return base
+}
+
+// Authorize implements drivers.Upstream.
+func (u *upstream) Authorize(req *http.Request, credential string) {
+	authorize(req, credential)
+}
+
+// authorize sends credential as an API key. A client's Authorization
+// header would be taken for an OAuth token, so it does not go along.
+func authorize(req *http.Request, credential string) {
+	req.Header.Del("Authorization")
+	req.Header.Set("x-goog-api-key", credential)
+}
+
+// Adapt implements drivers.Upstream: tool results are named after the
+// function of the call they answer, and carry a JSON object.
+func (u *upstream) Adapt(prog *ail.Program) (*ail.Program, error) {
+	names := make(map[string]string)
+	var callID string
+	var results []int
+	for i, inst := range prog.Code {
+		switch inst.Op {
+		case ail.CALL_START:
+			callID = inst.Str
+		case ail.CALL_NAME:
+			if callID != "" {
+				names[callID] = inst.Str
+			}
+		case ail.RESULT_START, ail.RESULT_DATA:
+			results = append(results, i)
+		}
+	}
+	if len(results) == 0 {
+		return prog, nil
+	}
+	out := prog.Clone()
+	for _, i := range results {
+		inst := &out.Code[i]
+		if inst.Op == ail.RESULT_DATA {
+			inst.Str = functionResponse(inst.Str)
+		} else if name, ok := names[inst.Str]; ok {
+			inst.Str = name
+		}
+	}
+	return out, nil
+}
+
+// functionResponse returns a tool result as the JSON object Gemini takes.
+func functionResponse(result string) string {
+	trimmed := strings.TrimSpace(result)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return trimmed
+	}
+	var v any = result
+	if json.Valid([]byte(trimmed)) {
+		v = json.RawMessage(trimmed)
+	}
+	j, _ := json.Marshal(map[string]any{"result": v})
+	return string(j)
+}
+
+// PatchBody implements drivers.Upstream.
+func (u *upstream) PatchBody(body []byte) ([]byte, error) {
+	var req map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	delete(req, "model") // in the URL
+	if contents, ok := req["contents"].([]any); ok {
+		req["contents"] = mergeContents(contents)
+	}
+	if tools, ok := req["tools"].([]any); ok {
+		merged, err := mergeTools(tools)
+		if err != nil {
+			return nil, err
+		}
+		req["tools"] = merged
+	}
+	_, camel := req["safetySettings"]
+	_, snake := req["safety_settings"]
+	if len(u.safety) > 0 && !camel && !snake {
+		req["safetySettings"] = u.safety
+	}
+	return json.Marshal(req)
+}
+
+// mergeContents gives tool results the user role and joins consecutive
+// contents of the same role, so that the results of parallel calls come
+// in one turn, as Gemini wants them.
+func mergeContents(contents []any) []any {
+	out := make([]any, 0, len(contents))
+	var last map[string]any
+	for _, c := range contents {
+		content, ok := c.(map[string]any)
+		if !ok {
+			out = append(out, c)
+			last = nil
+			continue
+		}
+		if content["role"] == "function" {
+			content["role"] = "user"
+		}
+		if last != nil && last["role"] == content["role"] {
+			lastParts, _ := last["parts"].([]any)
+			parts, _ := content["parts"].([]any)
+			last["parts"] = append(lastParts, parts...)
+			continue
+		}
+		out = append(out, content)
+		last = content
+	}
+	return out
+}
+
+// mergeTools gathers the function declarations, which the emitter gives a
+// tool each, into one tool, converting their parameters with
+// drivers.GeminiSchema. Other tools, such as google_search, are kept.
+func mergeTools(tools []any) ([]any, error) {
+	var decls, out []any
+	for _, t := range tools {
+		tool, ok := t.(map[string]any)
+		fds, isFuncs := tool["functionDeclarations"].([]any)
+		if !ok || !isFuncs {
+			out = append(out, t)
+			continue
+		}
+		for _, fd := range fds {
+			decl, ok := fd.(map[string]any)
+			if ok && decl["parameters"] != nil {
+				raw, err := json.Marshal(decl["parameters"])
+				if err != nil {
+					return nil, err
+				}
+				schema, err := drivers.GeminiSchema(raw)
+				if err != nil {
+					return nil, err
+				}
+				// Gemini rejects objects without properties; a function
+				// taking none has no parameters.
+				if props, _ := schema["properties"].(map[string]any); schema["type"] == "OBJECT" && len(props) == 0 {
+					delete(decl, "parameters")
+				} else {
+					decl["parameters"] = schema
+				}
+			}
+			decls = append(decls, fd)
+		}
+	}
+	if len(decls) > 0 {
+		out = append([]any{map[string]any{"functionDeclarations": decls}}, out...)
+	}
+	return out, nil
+}
+
+// responseMeta is what the driver reads from a response or stream chunk
+// besides what the ail parser does.
+type responseMeta struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				FunctionCall *struct {
+					ID string `json:"id"`
+				} `json:"functionCall"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		ThoughtsTokenCount int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// callIDs returns the IDs Gemini gave the function calls of the response,
+// in order, "" for those it gave none.
+func (m *responseMeta) callIDs() []string {
+	var ids []string
+	for _, c := range m.Candidates {
+		for _, part := range c.Content.Parts {
+			if part.FunctionCall != nil {
+				ids = append(ids, part.FunctionCall.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// ParseResponse implements drivers.Upstream.
+func (u *upstream) ParseResponse(body []byte) (*ail.Program, error) {
+	prog, err := u.resp.ParseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	var meta responseMeta
+	_ = json.Unmarshal(body, &meta)
+	ids := meta.callIDs()
+	calls := 0
+	hasCall := false
+	for i := range prog.Code {
+		switch inst := &prog.Code[i]; inst.Op {
+		case ail.MSG_START:
+			hasCall = false
+		case ail.CALL_START:
+			if inst.Str == "" {
+				inst.Str = callID(ids, calls)
+			}
+			calls++
+			hasCall = true
+		case ail.RESP_DONE:
+			inst.Str = finishReason(inst.Str, hasCall)
+		case ail.USAGE:
+			inst.JSON = addThoughts(inst.JSON, meta.UsageMetadata.ThoughtsTokenCount)
+		}
+	}
+	if meta.PromptFeedback.BlockReason != "" && !prog.HasOpcode(ail.MSG_START) {
+		prog.Emit(ail.MSG_START)
+		prog.Emit(ail.ROLE_AST)
+		prog.EmitString(ail.RESP_DONE, "content_filter")
+		prog.Emit(ail.MSG_END)
+	}
+	return prog, nil
+}
+
+// NewStreamParser implements drivers.Upstream.
+func (u *upstream) NewStreamParser() ail.StreamChunkParser {
+	return &streamParser{inner: u.chunks}
+}
+
+// streamParser numbers the function calls of one stream, which the ail
+// parser all gives index 0. Gemini repeats the running usage in every
+// chunk; only the final one, with the finish reason, keeps it.
+type streamParser struct {
+	inner ail.StreamChunkParser
+	calls int
+}
+
+func (s *streamParser) ParseStreamChunk(body []byte) (*ail.Program, error) {
+	prog, err := s.inner.ParseStreamChunk(body)
+	if err != nil {
+		return nil, err
+	}
+	var meta responseMeta
+	_ = json.Unmarshal(body, &meta)
+	ids := meta.callIDs()
+	first := s.calls
+	done := prog.HasOpcode(ail.RESP_DONE)
+	var drop []int
+	for i := range prog.Code {
+		switch inst := &prog.Code[i]; inst.Op {
+		case ail.STREAM_TOOL_DELTA:
+			var delta map[string]any
+			if json.Unmarshal(inst.JSON, &delta) != nil {
+				continue
+			}
+			delta["index"] = s.calls
+			delta["id"] = callID(ids, s.calls-first)
+			inst.JSON, _ = json.Marshal(delta)
+			s.calls++
+		case ail.RESP_DONE:
+			inst.Str = finishReason(inst.Str, s.calls > 0)
+		case ail.USAGE:
+			if !done {
+				drop = append(drop, i)
+				continue
+			}
+			inst.JSON = addThoughts(inst.JSON, meta.UsageMetadata.ThoughtsTokenCount)
+		}
+	}
+	if len(drop) > 0 {
+		prog = prog.ClearAtIndex(drop...)
+	}
+	if meta.PromptFeedback.BlockReason != "" && !done {
+		prog.EmitString(ail.RESP_DONE, "content_filter")
+		prog.Emit(ail.STREAM_END)
+	}
+	return prog, nil
+}
+
+// callID returns the i-th ID of ids, or a new one when Gemini gave none.
+func callID(ids []string, i int) string {
+	if i < len(ids) && ids[i] != "" {
+		return ids[i]
+	}
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "call_" + hex.EncodeToString(b[:])
+}
+
+// finishReason maps a finish reason the ail parser left as Gemini's onto
+// the chat completions ones.
+func finishReason(reason string, hasCall bool) string {
+	switch reason {
+	case "stop":
+		if hasCall {
+			return "tool_calls"
+		}
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	case "MALFORMED_FUNCTION_CALL", "OTHER", "LANGUAGE", "FINISH_REASON_UNSPECIFIED":
+		return "stop"
+	}
+	return reason
+}
+
+// addThoughts counts the thinking tokens, which Gemini leaves out of
+// candidatesTokenCount, as completion tokens. Other fields, nested
+// details included, pass through as they are.
+func addThoughts(usage json.RawMessage, thoughts int) json.RawMessage {
+	if thoughts == 0 {
+		return usage
+	}
+	var u map[string]json.RawMessage
+	if json.Unmarshal(usage, &u) != nil {
+		return usage
+	}
+	var completion int
+	if raw, ok := u["completion_tokens"]; ok && json.Unmarshal(raw, &completion) != nil {
+		return usage
+	}
+	u["completion_tokens"], _ = json.Marshal(completion + thoughts)
+	out, err := json.Marshal(u)
+	if err != nil {
+		return usage
+	}
+	return out
+}
+
+var _ drivers.Upstream = (*upstream)(nil)
@@ -0,0 +1,294 @@
+package google
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// keyAuth hands every provider the same API key.
+type keyAuth struct{ services.NopAuthService }
+
+func (keyAuth) CollectTargetAuth(string, *services.ProviderService, *http.Request, *http.Request) (string, error) {
+	return "k1", nil
+}
+
+func testProvider(t *testing.T, h http.HandlerFunc) *services.ProviderService {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL + "/v1beta")
+	return &services.ProviderService{
+		Name:       "gemini",
+		ParsedURL:  *u,
+		Style:      ail.StyleGoogleGenAI,
+		Router:     &services.RouterService{Auth: keyAuth{}},
+		Latency:    &services.LatencyTracker{},
+		HTTPClient: services.NewProviderClient(services.ClientOptions{}),
+	}
+}
+
+// toolTurn is a chat completions request answering two parallel calls.
+func toolTurn(t *testing.T, stream bool) *ail.Program {
+	t.Helper()
+	parser, err := ail.GetParser(ail.StyleChatCompletions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.ParseRequest([]byte(`{
+		"model": "gemini-2.5-flash",
+		"stream": ` + map[bool]string{true: "true", false: "false"}[stream] + `,
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": "Weather in Paris and Rome?"},
+			{"role": "assistant", "tool_calls": [
+				{"id": "call_a", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Paris\"}"}},
+				{"id": "call_b", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Rome\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_a", "content": "{\"temp\":21}"},
+			{"role": "tool", "tool_call_id": "call_b", "content": "sunny"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "weather", "parameters": {"type": "object", "additionalProperties": false, "properties": {"city": {"type": "string"}}}}},
+			{"type": "function", "function": {"name": "now", "parameters": {"type": "object", "properties": {}}}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestInference_Request(t *testing.T) {
+	safety, _ := ParseSafetySettings([]string{"block_only_high"})
+	d, err := NewInference(safety)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]json.RawMessage
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if r.Header.Get("x-goog-api-key") != "k1" || r.Header.Get("Authorization") != "" {
+			t.Errorf("auth headers = %v", r.Header)
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+		_, _ = w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"Done."}]},"finishReason":"STOP"}]}`))
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Authorization", "Bearer router-key")
+	if _, _, err := d.DoInference(p, toolTurn(t, false), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := body["model"]; ok {
+		t.Error("model sent in the body")
+	}
+	if !strings.Contains(string(body["system_instruction"]), "Be brief.") {
+		t.Errorf("system_instruction = %s", body["system_instruction"])
+	}
+	var contents []struct {
+		Role  string                       `json:"role"`
+		Parts []map[string]json.RawMessage `json:"parts"`
+	}
+	_ = json.Unmarshal(body["contents"], &contents)
+	if len(contents) != 3 || contents[2].Role != "user" || len(contents[2].Parts) != 2 {
+		t.Fatalf("contents = %s", body["contents"])
+	}
+	for i, want := range []string{`{"name":"weather","response":{"temp":21}}`, `{"name":"weather","response":{"result":"sunny"}}`} {
+		if got := string(contents[2].Parts[i]["functionResponse"]); got != want {
+			t.Errorf("result %d = %s, want %s", i, got, want)
+		}
+	}
+	var tools []struct {
+		FunctionDeclarations []map[string]json.RawMessage `json:"functionDeclarations"`
+	}
+	_ = json.Unmarshal(body["tools"], &tools)
+	if len(tools) != 1 || len(tools[0].FunctionDeclarations) != 2 {
+		t.Fatalf("tools = %s", body["tools"])
+	}
+	if params := string(tools[0].FunctionDeclarations[0]["parameters"]); strings.Contains(params, "additionalProperties") {
+		t.Errorf("weather parameters = %s", params)
+	}
+	if _, ok := tools[0].FunctionDeclarations[1]["parameters"]; ok {
+		t.Error("now has parameters")
+	}
+	var settings []SafetySetting
+	_ = json.Unmarshal(body["safetySettings"], &settings)
+	if len(settings) != len(harmCategories) || settings[0].Threshold != "BLOCK_ONLY_HIGH" {
+		t.Errorf("safetySettings = %s", body["safetySettings"])
+	}
+}
+
+func TestInference_RequestKeepsOwnSafetySettings(t *testing.T) {
+	safety, _ := ParseSafetySettings([]string{"off"})
+	d, _ := NewInference(safety)
+	prog := toolTurn(t, false)
+	prog.EmitKeyJSON(ail.EXT_DATA, "safety_settings", json.RawMessage(`[{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_NONE"}]`))
+	up, err := d.DryRun(testProvider(t, nil), prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(up.Body), "safetySettings") || !strings.Contains(string(up.Body), "BLOCK_NONE") {
+		t.Errorf("body = %s", up.Body)
+	}
+}
+
+func TestInference_Response(t *testing.T) {
+	d, _ := NewInference(nil)
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{
+			"candidates": [{"content": {"role": "model", "parts": [
+				{"functionCall": {"name": "weather", "args": {"city": "Paris"}}},
+				{"functionCall": {"id": "g-2", "name": "weather", "args": {"city": "Rome"}}}
+			]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "thoughtsTokenCount": 7, "totalTokenCount": 22}
+		}`))
+	})
+	_, prog, err := d.DoInference(p, toolTurn(t, false), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.CALL_START:
+			ids = append(ids, inst.Str)
+		case ail.RESP_DONE:
+			if inst.Str != "tool_calls" {
+				t.Errorf("finish reason = %q", inst.Str)
+			}
+		}
+	}
+	if len(ids) != 2 || !strings.HasPrefix(ids[0], "call_") || ids[1] != "g-2" {
+		t.Errorf("call IDs = %q", ids)
+	}
+	if u, _ := services.UsageFromProgram(prog); u.CompletionTokens != 12 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestAddThoughts(t *testing.T) {
+	got := addThoughts(json.RawMessage(`{"prompt_tokens":10,"completion_tokens":5,"total_tokens":22,"prompt_tokens_details":{"cached_tokens":4}}`), 7)
+	var u struct {
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+		Details          struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	}
+	if err := json.Unmarshal(got, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.CompletionTokens != 12 || u.TotalTokens != 22 || u.Details.CachedTokens != 4 {
+		t.Errorf("usage = %s", got)
+	}
+	if got := string(addThoughts(json.RawMessage(`{"prompt_tokens":10}`), 7)); got != `{"completion_tokens":7,"prompt_tokens":10}` {
+		t.Errorf("usage without completion tokens = %s", got)
+	}
+}
+
+func TestInference_BlockedPrompt(t *testing.T) {
+	d, _ := NewInference(nil)
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"promptFeedback": {"blockReason": "SAFETY"}}`))
+	})
+	_, prog, err := d.DoInference(p, toolTurn(t, false), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter, _ := ail.GetResponseEmitter(ail.StyleChatCompletions)
+	out, err := emitter.EmitResponse(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"finish_reason":"content_filter"`) {
+		t.Errorf("response = %s", out)
+	}
+}
+
+func TestInference_Stream(t *testing.T) {
+	d, _ := NewInference(nil)
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("url = %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"weather","args":{"city":"Paris"}}}]}}],"usageMetadata":{"promptTokenCount":10,"totalTokenCount":10}}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"weather","args":{"city":"Rome"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":8,"totalTokenCount":18}}`,
+		} {
+			_, _ = w.Write([]byte("data: " + chunk + "\n\n"))
+		}
+	})
+	_, stream, err := d.DoInferenceStream(p, toolTurn(t, true), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deltas []map[string]any
+	var finish string
+	usages := 0
+	for chunk := range stream {
+		if chunk.RuntimeError != nil {
+			t.Fatal(chunk.RuntimeError)
+		}
+		for _, inst := range chunk.Data.Code {
+			switch inst.Op {
+			case ail.STREAM_TOOL_DELTA:
+				var delta map[string]any
+				_ = json.Unmarshal(inst.JSON, &delta)
+				deltas = append(deltas, delta)
+			case ail.RESP_DONE:
+				finish = inst.Str
+			case ail.USAGE:
+				usages++
+			}
+		}
+	}
+	if len(deltas) != 2 || deltas[0]["index"] != 0.0 || deltas[1]["index"] != 1.0 ||
+		deltas[0]["id"] == "" || deltas[0]["id"] == deltas[1]["id"] {
+		t.Errorf("tool deltas = %v", deltas)
+	}
+	if finish != "tool_calls" || usages != 1 {
+		t.Errorf("finish = %q, usages = %d", finish, usages)
+	}
+}
+
+func TestParseSafetySettings(t *testing.T) {
+	got, err := ParseSafetySettings([]string{"block_none", "dangerous_content=block_only_high", "HARM_CATEGORY_HARASSMENT=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"HARM_CATEGORY_HARASSMENT":        "OFF",
+		"HARM_CATEGORY_HATE_SPEECH":       "BLOCK_NONE",
+		"HARM_CATEGORY_SEXUALLY_EXPLICIT": "BLOCK_NONE",
+		"HARM_CATEGORY_DANGEROUS_CONTENT": "BLOCK_ONLY_HIGH",
+		"HARM_CATEGORY_CIVIC_INTEGRITY":   "BLOCK_NONE",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for _, s := range got {
+		if want[s.Category] != s.Threshold {
+			t.Errorf("%s = %s, want %s", s.Category, s.Threshold, want[s.Category])
+		}
+	}
+	for _, args := range [][]string{{"block_some"}, {"=block_none"}, {"harassment=never"}} {
+		if _, err := ParseSafetySettings(args); err == nil {
+			t.Errorf("%q: accepted", args)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// ListModels lists the models of the Gemini API that generate content,
+// following its pages.
+type ListModels struct{}
+
+func (c *ListModels) DoListModels(p *services.ProviderService, r *http.Request) ([]drivers.ListModelsModel, error) {
+	var models []drivers.ListModelsModel
+	pageToken := ""
+	for {
+		targetUrl := p.ParsedURL
+		targetUrl.Path = strings.TrimSuffix(targetUrl.Path, "/") + "/models"
+		q := targetUrl.Query()
+		q.Set("pageSize", "1000")
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		targetUrl.RawQuery = q.Encode()
+
+		targetHeader := r.Header.Clone()
+		targetHeader.Del("Accept-Encoding")
+
+		req := &http.Request{
+			Method: "GET",
+			URL:    &targetUrl,
+			Header: targetHeader,
+		}
+		req = req.WithContext(r.Context())
+
+		authVal, err := p.Router.Auth.CollectTargetAuth("list_models", p, r, req)
+		if err != nil {
+			return nil, err
+		}
+		if authVal != "" {
+			authorize(req, authVal)
+		}
+
+		resp, err := p.Client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("list models: upstream returned %d: %s", resp.StatusCode, string(data))
+		}
+
+		var result struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				DisplayName                string   `json:"displayName"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("%s; data: %s", err, string(data))
+		}
+		for _, m := range result.Models {
+			if len(m.SupportedGenerationMethods) > 0 && !slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+				continue
+			}
+			models = append(models, drivers.ListModelsModel{
+				Object:  "model",
+				ID:      strings.TrimPrefix(m.Name, "models/"),
+				Name:    m.DisplayName,
+				OwnedBy: "google",
+			})
+		}
+		if result.NextPageToken == "" || result.NextPageToken == pageToken {
+			return models, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
@@ -0,0 +1,69 @@
+package google
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SafetySetting is one entry of a Gemini request's safetySettings.
+type SafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+// harmCategories are the categories a bare threshold applies to.
+var harmCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+	"HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+var harmThresholds = map[string]bool{
+	"BLOCK_NONE":             true,
+	"BLOCK_ONLY_HIGH":        true,
+	"BLOCK_MEDIUM_AND_ABOVE": true,
+	"BLOCK_LOW_AND_ABOVE":    true,
+	"OFF":                    true,
+}
+
+// ParseSafetySettings parses safety settings given as a threshold for all
+// categories ("block_none") and category=threshold pairs overriding it
+// ("dangerous_content=block_only_high"). Categories may leave out their
+// HARM_CATEGORY_ prefix; case does not matter.
+func ParseSafetySettings(args []string) ([]SafetySetting, error) {
+	var out []SafetySetting
+	set := func(category, threshold string) {
+		for i := range out {
+			if out[i].Category == category {
+				out[i].Threshold = threshold
+				return
+			}
+		}
+		out = append(out, SafetySetting{Category: category, Threshold: threshold})
+	}
+	for _, arg := range args {
+		category, threshold, pair := strings.Cut(strings.ToUpper(arg), "=")
+		if !pair {
+			category, threshold = "", category
+		}
+		if !harmThresholds[threshold] {
+			return nil, fmt.Errorf("unknown safety threshold %q", threshold)
+		}
+		if !pair {
+			for _, c := range harmCategories {
+				set(c, threshold)
+			}
+			continue
+		}
+		if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+			category = "HARM_CATEGORY_" + category
+		}
+		if category == "HARM_CATEGORY_" {
+			return nil, fmt.Errorf("safety setting %q has no category", arg)
+		}
+		set(category, threshold)
+	}
+	return out, nil
+}
@@ -2,12 +2,17 @@ package drivers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
 	"github.com/neutrome-labs/open-ai-router/src/services"
 	"github.com/neutrome-labs/open-ai-router/src/sse"
 	"go.uber.org/zap"
@@ -31,6 +36,27 @@ type InferenceSse struct {
 	emitter     ail.Emitter
 	respParser  ail.ResponseParser
 	chunkParser ail.StreamChunkParser
+	upstream    Upstream // nil for the generic request
+}
+
+// Upstream adapts InferenceSse to an API whose requests do not fit the
+// generic shape: the emitted program POSTed to the provider URL plus the
+// style's endpoint, with a bearer token. See drivers/google.
+type Upstream interface {
+	// ParseResponse parses a whole response, in place of the style's
+	// parser.
+	ail.ResponseParser
+	// NewStreamParser returns the parser for the events of one stream,
+	// which may keep state across them.
+	NewStreamParser() ail.StreamChunkParser
+	// Target returns the URL of the request for prog.
+	Target(base url.URL, prog *ail.Program, stream bool) url.URL
+	// Authorize puts the provider credential on req.
+	Authorize(req *http.Request, credential string)
+	// Adapt rewrites the program before it is emitted.
+	Adapt(prog *ail.Program) (*ail.Program, error)
+	// PatchBody rewrites the emitted request body.
+	PatchBody(body []byte) ([]byte, error)
 }
 
 // NewInferenceSse creates an InferenceCommand for the given upstream style
@@ -49,6 +75,13 @@ func NewInferenceSse(style ail.Style, endpoint string) (*InferenceSse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("no stream chunk parser for style %s: %w", style, err)
 	}
+	switch style {
+	case ail.StyleResponses:
+		chunkParser = responsesChunkParser{inner: chunkParser}
+	case ail.StyleChatCompletions:
+		rp := chatReasoningParser{resp: respParser, chunk: chunkParser}
+		respParser, chunkParser = rp, rp
+	}
 	return &InferenceSse{
 		style:       style,
 		endpoint:    endpoint,
@@ -58,17 +91,55 @@ func NewInferenceSse(style ail.Style, endpoint string) (*InferenceSse, error) {
 	}, nil
 }
 
-func (d *InferenceSse) createRequest(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Request, error) {
+// NewUpstreamInference creates an InferenceCommand for the given upstream
+// style whose requests and responses up adapts.
+func NewUpstreamInference(style ail.Style, up Upstream) (*InferenceSse, error) {
+	d, err := NewInferenceSse(style, "")
+	if err != nil {
+		return nil, err
+	}
+	d.respParser, d.upstream = up, up
+	return d, nil
+}
+
+// target returns the URL of the request for prog.
+func (d *InferenceSse) target(p *services.ProviderService, prog *ail.Program, stream bool) url.URL {
+	if d.upstream != nil {
+		return d.upstream.Target(p.ParsedURL, prog, stream)
+	}
 	targetURL := p.ParsedURL
 	targetURL.Path += d.endpoint
+	return targetURL
+}
+
+// emit writes the request body for prog.
+func (d *InferenceSse) emit(prog *ail.Program, so *structuredOutput) ([]byte, error) {
+	body, err := d.emitter.EmitRequest(prog)
+	if err == nil {
+		body, err = so.patchBody(body)
+	}
+	if err == nil && d.upstream != nil {
+		body, err = d.upstream.PatchBody(body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s driver: emit request: %w", d.style, err)
+	}
+	return body, nil
+}
+
+func (d *InferenceSse) createRequest(ctx context.Context, p *services.ProviderService, prog *ail.Program, so *structuredOutput, r *http.Request, stream bool) (*http.Request, error) {
+	targetURL := d.target(p, prog, stream)
 
 	targetHeader := r.Header.Clone()
 	targetHeader.Del("Accept-Encoding")
 	targetHeader.Set("Content-Type", "application/json")
+	if traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string); traceID != "" {
+		targetHeader.Set(plugin.RequestIDHeader, traceID)
+	}
 
-	reqBody, err := d.emitter.EmitRequest(prog)
+	reqBody, err := d.emit(prog, so)
 	if err != nil {
-		return nil, fmt.Errorf("%s driver: emit request: %w", d.style, err)
+		return nil, err
 	}
 
 	httpReq := &http.Request{
@@ -78,19 +149,54 @@ func (d *InferenceSse) createRequest(p *services.ProviderService, prog *ail.Prog
 		Body:          io.NopCloser(bytes.NewReader(reqBody)),
 		ContentLength: int64(len(reqBody)),
 	}
-	httpReq = httpReq.WithContext(r.Context())
+	httpReq = httpReq.WithContext(ctx)
 
 	authVal, err := p.Router.Auth.CollectTargetAuth(string(d.style), p, r, httpReq)
 	if err != nil {
 		return nil, err
 	}
 	if authVal != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+authVal)
+		if d.upstream != nil {
+			d.upstream.Authorize(httpReq, authVal)
+		} else {
+			httpReq.Header.Set("Authorization", "Bearer "+authVal)
+		}
 	}
 
 	return httpReq, nil
 }
 
+// prepare adapts a request's reasoning, media, tool choice and structured
+// output settings to the upstream style.
+func (d *InferenceSse) prepare(p *services.ProviderService, prog *ail.Program) (*ail.Program, *structuredOutput, error) {
+	prog = normalizeReasoning(d.style, prog)
+	prog = normalizeToolChoice(d.style, prog)
+	prog, err := prepareMedia(d.style, prog)
+	if err != nil {
+		return nil, nil, err
+	}
+	prog, so, err := prepareStructuredOutput(d.style, p.StructuredOutputs, prog)
+	if err == nil && d.upstream != nil {
+		prog, err = d.upstream.Adapt(prog)
+	}
+	return prog, so, err
+}
+
+// DryRun implements DryRunCommand: the request DoInference or
+// DoInferenceStream would send, without its headers.
+func (d *InferenceSse) DryRun(p *services.ProviderService, prog *ail.Program) (*UpstreamRequest, error) {
+	upstreamProg, so, err := d.prepare(p, prog)
+	if err != nil {
+		return nil, err
+	}
+	body, err := d.emit(upstreamProg, so)
+	if err != nil {
+		return nil, err
+	}
+	targetURL := d.target(p, upstreamProg, prog.IsStreaming())
+	return &UpstreamRequest{URL: targetURL.String(), Body: body, Requests: 1}, nil
+}
+
 // DoInference implements InferenceCommand for non-streaming requests.
 func (d *InferenceSse) DoInference(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, *ail.Program, error) {
 	Logger.Debug("DoInference starting",
@@ -99,32 +205,50 @@ func (d *InferenceSse) DoInference(p *services.ProviderService, prog *ail.Progra
 		zap.String("model", prog.GetModel()),
 		zap.String("base_url", p.ParsedURL.String()))
 
-	httpReq, err := d.createRequest(p, prog, r)
+	upstreamProg, so, err := d.prepare(p, prog)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	res, err := http.DefaultClient.Do(httpReq)
+	ctx, firstByte, cancel := upstreamDeadlines(p, r.Context())
+	defer cancel()
+
+	httpReq, err := d.createRequest(ctx, p, upstreamProg, so, r, false)
 	if err != nil {
 		return nil, nil, err
 	}
+
+	start := time.Now()
+	res, err := p.Client().Do(httpReq)
+	if err != nil {
+		return nil, nil, timeoutErr(ctx, err)
+	}
 	defer res.Body.Close()
+	firstByte()
+	ttfb := time.Since(start)
 
-	respData, _ := io.ReadAll(res.Body)
+	respData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res, nil, timeoutErr(ctx, err)
+	}
 
 	if res.StatusCode != http.StatusOK {
+		noteRateLimited(p, prog, r, res)
 		Logger.Error("non-200 response",
 			zap.String("style", string(d.style)),
 			zap.Int("status", res.StatusCode),
 			zap.String("body", string(respData)))
-		return res, nil, fmt.Errorf("%s", string(respData))
+		return res, nil, services.UpstreamError(p.Name, res, respData)
 	}
 
 	respProg, err := d.respParser.ParseResponse(respData)
 	if err != nil {
 		return res, nil, err
 	}
+	respProg = so.response(respProg)
 
+	p.Latency.Observe(ttfb)
+	observeUsage(p, prog, r, respProg)
 	return res, respProg, nil
 }
 
@@ -135,31 +259,79 @@ func (d *InferenceSse) DoInferenceStream(p *services.ProviderService, prog *ail.
 		zap.String("provider", p.Name),
 		zap.String("model", prog.GetModel()))
 
-	httpReq, err := d.createRequest(p, prog, r)
+	upstreamProg, so, err := d.prepare(p, prog)
 	if err != nil {
 		return nil, nil, err
 	}
+	structured := so.stream()
+
+	ctx, firstByte, cancel := upstreamDeadlines(p, r.Context())
 
-	res, err := http.DefaultClient.Do(httpReq)
+	httpReq, err := d.createRequest(ctx, p, upstreamProg, so, r, true)
 	if err != nil {
+		cancel()
 		return nil, nil, err
 	}
 
+	start := time.Now()
+	res, err := p.Client().Do(httpReq)
+	if err != nil {
+		err = timeoutErr(ctx, err)
+		cancel()
+		return nil, nil, err
+	}
+
+	chunkParser := d.chunkParser
+	if d.upstream != nil {
+		chunkParser = d.upstream.NewStreamParser()
+	}
 	chunks := make(chan InferenceStreamChunk)
 
+	// started is closed once the upstream produced its first event (or
+	// ended); the first-byte timeout only covers the time before that.
+	started := make(chan struct{})
+	var startOnce sync.Once
+	markStarted := func(observe bool) {
+		startOnce.Do(func() {
+			firstByte()
+			if observe {
+				ttft := time.Since(start)
+				p.Latency.Observe(ttft)
+				services.Metrics.StreamTTFT.WithLabelValues(routerName(p), p.Name).Observe(ttft.Seconds())
+			}
+			close(started)
+		})
+	}
+
+	// send hands a chunk to the consumer, giving up once the client request
+	// is cancelled (client gone, or the consumer stopped reading) so the
+	// upstream body is closed instead of blocking on an abandoned channel.
+	// Provider timeouts only cancel ctx, so their errors are still sent.
+	clientDone := r.Context().Done()
+	send := func(c InferenceStreamChunk) bool {
+		select {
+		case chunks <- c:
+			return true
+		case <-clientDone:
+			return false
+		}
+	}
+
 	go func() {
 		defer close(chunks)
+		defer cancel()
 		defer res.Body.Close()
+		defer markStarted(false)
 
 		if res.StatusCode != http.StatusOK {
+			noteRateLimited(p, prog, r, res)
 			respData, _ := io.ReadAll(res.Body)
+			markStarted(false)
 			Logger.Error("non-200 streaming response",
 				zap.String("style", string(d.style)),
 				zap.Int("status", res.StatusCode),
 				zap.String("body", string(respData)))
-			chunks <- InferenceStreamChunk{
-				RuntimeError: fmt.Errorf("%s - %s", res.Status, string(respData)),
-			}
+			send(InferenceStreamChunk{RuntimeError: services.UpstreamError(p.Name, res, respData)})
 			return
 		}
 
@@ -170,41 +342,104 @@ func (d *InferenceSse) DoInferenceStream(p *services.ProviderService, prog *ail.
 			// Non-SSE response to a streaming request — parse as full response.
 			respData, err := io.ReadAll(res.Body)
 			if err != nil {
-				chunks <- InferenceStreamChunk{RuntimeError: err}
+				markStarted(false)
+				send(InferenceStreamChunk{RuntimeError: timeoutErr(ctx, err)})
 				return
 			}
+			markStarted(true)
 			respProg, err := d.respParser.ParseResponse(respData)
 			if err != nil {
-				chunks <- InferenceStreamChunk{RuntimeError: err}
+				send(InferenceStreamChunk{RuntimeError: err})
 				return
 			}
-			chunks <- InferenceStreamChunk{Data: respProg}
+			observeUsage(p, prog, r, respProg)
+			send(InferenceStreamChunk{Data: so.response(respProg)})
 			return
 		}
 
 		reader := sse.NewDefaultReader(res.Body)
 		for event := range reader.ReadEvents() {
 			if event.Error != nil {
-				chunks <- InferenceStreamChunk{RuntimeError: event.Error}
+				markStarted(false)
+				send(InferenceStreamChunk{RuntimeError: timeoutErr(ctx, event.Error)})
 				return
 			}
+			markStarted(true)
 			if event.Done {
 				return
 			}
 			if event.Data != nil {
-				chunkProg, err := d.chunkParser.ParseStreamChunk(event.Data)
+				chunkProg, err := chunkParser.ParseStreamChunk(event.Data)
 				if err != nil {
-					chunks <- InferenceStreamChunk{RuntimeError: err}
+					send(InferenceStreamChunk{RuntimeError: err})
+					return
+				}
+				observeUsage(p, prog, r, chunkProg)
+				chunk := InferenceStreamChunk{Data: structured.chunk(chunkProg)}
+				// Raw bytes go with unchanged chunks of one data line,
+				// which a writer can frame again as they came.
+				if structured == nil && bytes.IndexByte(event.Data, '\n') < 0 {
+					chunk.Raw, chunk.Event = event.Data, event.Name
+				}
+				if !send(chunk) {
 					return
 				}
-				chunks <- InferenceStreamChunk{Data: chunkProg}
 			}
 		}
 	}()
 
+	// Hold the stream back until the first event so a provider that stalls
+	// before producing anything fails here, while the pipeline can still
+	// fall over to the next provider.
+	select {
+	case <-started:
+	case <-ctx.Done():
+		go func() {
+			for range chunks {
+			}
+		}()
+		return res, nil, timeoutErr(ctx, ctx.Err())
+	}
+
 	return res, chunks, nil
 }
 
+// noteRateLimited puts the provider/model pair on cooldown when the
+// upstream answered 429, honouring its retry hints.
+func noteRateLimited(p *services.ProviderService, prog *ail.Program, r *http.Request, res *http.Response) {
+	if res.StatusCode != http.StatusTooManyRequests || p.Router == nil {
+		return
+	}
+	d, _ := services.RetryAfterFromHeaders(res.Header, time.Now())
+	p.Router.Cooldowns.Trip(r.Context(), p.Name, prog.GetModel(), d)
+	p.Router.Webhooks.Emit(services.WebhookCircuitOpened, map[string]any{
+		"provider":       p.Name,
+		"model":          prog.GetModel(),
+		"reason":         "rate_limited",
+		"retry_after_ms": d.Milliseconds(),
+	})
+	Logger.Warn("upstream rate limited, cooling down",
+		zap.String("provider", p.Name),
+		zap.String("model", prog.GetModel()),
+		zap.Duration("retry_after", d))
+}
+
+// observeUsage records the token usage reported in an upstream response
+// or stream chunk, in metrics and in the request's access record.
+func observeUsage(p *services.ProviderService, prog *ail.Program, r *http.Request, resProg *ail.Program) {
+	if u, ok := services.UsageFromProgram(resProg); ok {
+		services.ObserveUsage(routerName(p), p.Name, prog.GetModel(), u)
+	}
+	services.AccessRecordFrom(r.Context()).ObserveResponse(resProg)
+}
+
+func routerName(p *services.ProviderService) string {
+	if p.Router == nil {
+		return ""
+	}
+	return p.Router.Name
+}
+
 // EndpointForStyle returns the default upstream API path for a provider style.
 func EndpointForStyle(style ail.Style) string {
 	switch style {
@@ -215,10 +450,13 @@ func EndpointForStyle(style ail.Style) string {
 	case ail.StyleAnthropic:
 		return "/messages"
 	case ail.StyleGoogleGenAI:
-		return "/chat/completions" // Google GenAI via OpenAI-compat REST endpoint
+		return "/chat/completions" // Gemini's OpenAI-compatible endpoint; drivers/google speaks its own API
 	default:
 		return "/chat/completions"
 	}
 }
 
-var _ InferenceCommand = (*InferenceSse)(nil)
+var (
+	_ InferenceCommand = (*InferenceSse)(nil)
+	_ DryRunCommand    = (*InferenceSse)(nil)
+)
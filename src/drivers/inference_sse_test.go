@@ -0,0 +1,127 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestInferenceSse_ForwardsRequestID(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	var got string
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(plugin.RequestIDHeader)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), "trace-1"))
+	_, _, err := d.DoInference(p, testProgram(false), r)
+	if got != "trace-1" {
+		t.Errorf("upstream %s = %q, want trace-1", plugin.RequestIDHeader, got)
+	}
+
+	var re *services.RouterError
+	if !errors.As(err, &re) || re.Kind != services.ErrorProvider || re.Provider != "p" {
+		t.Errorf("expected a provider RouterError, got %v", err)
+	}
+}
+
+func TestInferenceSse_ClientCancelAbortsUpstream(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	aborted := make(chan struct{})
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		// Keep generating until the router hangs up.
+		for {
+			select {
+			case <-r.Context().Done():
+				close(aborted)
+				return
+			case <-time.After(10 * time.Millisecond):
+				_, _ = w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\".\"}}]}\n\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
+	_, stream, err := d.DoInferenceStream(p, testProgram(true), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-stream
+	cancel() // the client went away; nobody reads the stream any more
+
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request not cancelled after the client left")
+	}
+}
+
+func TestInferenceSse_DryRun(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleAnthropic, "/messages")
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a dry run reached the upstream")
+	}, nil)
+	p.Style = ail.StyleAnthropic
+
+	parser, _ := ail.GetParser(ail.StyleChatCompletions)
+	prog, err := parser.ParseRequest([]byte(`{"model":"m","n":3,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, cmd := WithChoices(p.Style, prog, d)
+	req, err := DryRun(cmd, p, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL != p.ParsedURL.String()+"/messages" || req.Requests != 3 {
+		t.Errorf("got url %q, %d requests", req.URL, req.Requests)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if string(body["model"]) != `"m"` || body["messages"] == nil || body["n"] != nil {
+		t.Errorf("unexpected upstream body: %s", req.Body)
+	}
+
+	if _, err := DryRun(&fakeTools{}, p, prog); err == nil {
+		t.Error("expected an error from a command without dry runs")
+	}
+}
+
+func TestInferenceSse_StreamCarriesRawEvents(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleAnthropic, "/messages")
+	const data = `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: content_block_delta\ndata: " + data + "\n\n"))
+	}, nil)
+	p.Style = ail.StyleAnthropic
+
+	_, stream, err := d.DoInferenceStream(p, testProgram(true), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []InferenceStreamChunk
+	for c := range stream {
+		got = append(got, c)
+	}
+	if len(got) != 1 || got[0].Data == nil || string(got[0].Raw) != data || got[0].Event != "content_block_delta" {
+		t.Fatalf("chunks = %+v, want one parsed chunk with its raw event", got)
+	}
+}
@@ -2,6 +2,8 @@
 package drivers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/neutrome-labs/ail"
@@ -23,8 +25,15 @@ type ListModelsCommand interface {
 }
 
 // InferenceStreamChunk represents a streaming response chunk as an AIL program fragment.
+//
+// Raw, when set, is the upstream event's data exactly as received, and
+// Event its SSE event name. A driver sets them only when Data is the
+// plain parse of that event, so a client of the provider's style can be
+// sent Raw instead of re-encoding Data.
 type InferenceStreamChunk struct {
 	Data         *ail.Program
+	Raw          []byte
+	Event        string
 	RuntimeError error
 }
 
@@ -39,3 +48,26 @@ type InferenceCommand interface {
 	// Returns a channel of AIL program chunks.
 	DoInferenceStream(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, chan InferenceStreamChunk, error)
 }
+
+// UpstreamRequest is the request a driver would send for a program.
+type UpstreamRequest struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+	// Requests is how many times it would be sent, when n is fanned out.
+	Requests int `json:"requests"`
+}
+
+// DryRunCommand is an InferenceCommand that can build its upstream
+// request without sending it. Credentials are not resolved.
+type DryRunCommand interface {
+	DryRun(p *services.ProviderService, prog *ail.Program) (*UpstreamRequest, error)
+}
+
+// DryRun builds the upstream request cmd would send for prog.
+func DryRun(cmd InferenceCommand, p *services.ProviderService, prog *ail.Program) (*UpstreamRequest, error) {
+	dr, ok := cmd.(DryRunCommand)
+	if !ok {
+		return nil, errors.New("the provider's driver does not support dry runs")
+	}
+	return dr.DryRun(p, prog)
+}
@@ -0,0 +1,218 @@
+package drivers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+// anthropicImageTypes are the image types Anthropic accepts.
+var anthropicImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// chatAudioFormats maps audio types to chat input_audio formats.
+var chatAudioFormats = map[string]string{
+	"audio/wav":   "wav",
+	"audio/x-wav": "wav",
+	"audio/wave":  "wav",
+	"audio/mpeg":  "mp3",
+	"audio/mp3":   "mp3",
+}
+
+// prepareMedia rewrites the content of user messages carrying images,
+// audio or files into the parts of the upstream style, which the ail
+// emitters only partly produce: images by URL or inline as each API wants
+// them, audio with its format, and files as file, document or inlineData
+// parts. The parts go in a message-level EXT_DATA ("content", or "parts"
+// for Gemini) that replaces what the emitter built. Messages that also
+// hold tool calls, results or thinking are left to the emitter. Media the
+// upstream cannot take fails the request as unsupported.
+func prepareMedia(style ail.Style, prog *ail.Program) (*ail.Program, error) {
+	key := "content"
+	switch style {
+	case ail.StyleGoogleGenAI:
+		key = "parts"
+	case ail.StyleChatCompletions, ail.StyleAnthropic, ail.StyleResponses:
+	default:
+		return prog, nil
+	}
+
+	var out *ail.Program
+	spans := prog.MessagesByRole(ail.ROLE_USR)
+	for i := len(spans) - 1; i >= 0; i-- {
+		span := spans[i]
+		parts, hasText, err := mediaParts(style, prog, span)
+		if err != nil {
+			return nil, err
+		}
+		if parts == nil {
+			continue
+		}
+		raw, err := json.Marshal(parts)
+		if err != nil {
+			return nil, err
+		}
+		inst := []ail.Instruction{{Op: ail.EXT_DATA, Key: key, JSON: raw}}
+		if !hasText && style == ail.StyleResponses {
+			// The emitter skips user messages without text.
+			inst = append(inst, ail.Instruction{Op: ail.TXT_CHUNK, Str: " "})
+		}
+		if out == nil {
+			out = prog
+		}
+		out = out.InsertBefore(span.End, inst...)
+	}
+	if out == nil {
+		return prog, nil
+	}
+	return out, nil
+}
+
+// mediaParts returns the parts of the message at span for style, or nil
+// when it has no media or is left to the emitter.
+func mediaParts(style ail.Style, prog *ail.Program, span ail.MessageSpan) ([]any, bool, error) {
+	code := prog.Code[span.Start : span.End+1]
+	if !slices.ContainsFunc(code, func(inst ail.Instruction) bool {
+		return inst.Op == ail.IMG_REF || inst.Op == ail.AUD_REF
+	}) || slices.ContainsFunc(code, func(inst ail.Instruction) bool {
+		return inst.Op == ail.CALL_START || inst.Op == ail.RESULT_START || inst.Op == ail.THINK_START
+	}) {
+		return nil, false, nil
+	}
+
+	var parts []any
+	var text, mimeType string
+	hasText := false
+	flush := func() {
+		if text != "" {
+			parts = append(parts, textPart(style, text))
+			text, hasText = "", true
+		}
+	}
+	for _, inst := range code {
+		switch inst.Op {
+		case ail.TXT_CHUNK:
+			text += inst.Str
+		case ail.TXT_REF:
+			if int(inst.Ref) < len(prog.Buffers) {
+				text += string(prog.Buffers[inst.Ref])
+			}
+		case ail.SET_META:
+			if inst.Key == "media_type" {
+				mimeType = inst.Str
+			}
+		case ail.IMG_REF, ail.AUD_REF:
+			flush()
+			var buf []byte
+			if int(inst.Ref) < len(prog.Buffers) {
+				buf = prog.Buffers[inst.Ref]
+			}
+			audio := inst.Op == ail.AUD_REF
+			part, err := mediaPart(style, styles.ResolveMedia(buf, mimeType, audio), audio)
+			if err != nil {
+				return nil, false, err
+			}
+			parts = append(parts, part)
+			mimeType = ""
+		}
+	}
+	flush()
+	return parts, hasText, nil
+}
+
+func textPart(style ail.Style, text string) any {
+	switch style {
+	case ail.StyleGoogleGenAI:
+		return map[string]any{"text": text}
+	case ail.StyleResponses:
+		return map[string]any{"type": "input_text", "text": text}
+	}
+	return map[string]any{"type": "text", "text": text}
+}
+
+// mediaPart encodes m as a content part of style.
+func mediaPart(style ail.Style, m styles.Media, audio bool) (any, error) {
+	kind := "file"
+	switch {
+	case audio:
+		kind = "audio"
+	case m.IsImage():
+		kind = "image"
+	}
+	unsupported := func(how string) error {
+		return services.NewRouterError(services.ErrorUnsupported,
+			fmt.Sprintf("%s does not accept %s input%s (%s).", style, kind, how, m.MimeType))
+	}
+
+	switch style {
+	case ail.StyleChatCompletions:
+		switch kind {
+		case "image":
+			src := m.URL
+			if src == "" {
+				src = m.DataURI()
+			}
+			return map[string]any{"type": "image_url", "image_url": map[string]any{"url": src}}, nil
+		case "audio":
+			format, ok := chatAudioFormats[m.MimeType]
+			if !ok || m.URL != "" {
+				return nil, unsupported(" other than inline wav or mp3")
+			}
+			return map[string]any{"type": "input_audio", "input_audio": map[string]any{"data": m.Data, "format": format}}, nil
+		}
+		if m.URL != "" {
+			return nil, unsupported(" by URL")
+		}
+		return map[string]any{"type": "file", "file": map[string]any{"filename": m.Filename(), "file_data": m.DataURI()}}, nil
+
+	case ail.StyleResponses:
+		switch kind {
+		case "image":
+			src := m.URL
+			if src == "" {
+				src = m.DataURI()
+			}
+			return map[string]any{"type": "input_image", "image_url": src}, nil
+		case "audio":
+			return nil, unsupported("")
+		}
+		if m.URL != "" {
+			return map[string]any{"type": "input_file", "file_url": m.URL}, nil
+		}
+		return map[string]any{"type": "input_file", "filename": m.Filename(), "file_data": m.DataURI()}, nil
+
+	case ail.StyleAnthropic:
+		block := "image"
+		switch {
+		case kind == "audio":
+			return nil, unsupported("")
+		case kind == "image" && !slices.Contains(anthropicImageTypes, m.MimeType):
+			return nil, unsupported(" of this type")
+		case kind == "file" && m.MimeType == "text/plain" && m.URL == "":
+			text, err := base64.StdEncoding.DecodeString(m.Data)
+			if err != nil {
+				return nil, services.NewRouterError(services.ErrorUnsupported, "invalid base64 in text document")
+			}
+			return map[string]any{"type": "document", "source": map[string]any{"type": "text", "media_type": "text/plain", "data": string(text)}}, nil
+		case kind == "file" && m.MimeType != "application/pdf":
+			return nil, unsupported(" of this type")
+		case kind == "file":
+			block = "document"
+		}
+		if m.URL != "" {
+			return map[string]any{"type": block, "source": map[string]any{"type": "url", "url": m.URL}}, nil
+		}
+		return map[string]any{"type": block, "source": map[string]any{"type": "base64", "media_type": m.MimeType, "data": m.Data}}, nil
+
+	case ail.StyleGoogleGenAI:
+		if m.URL != "" {
+			return map[string]any{"fileData": map[string]any{"mimeType": m.MimeType, "fileUri": m.URL}}, nil
+		}
+		return map[string]any{"inlineData": map[string]any{"mimeType": m.MimeType, "data": m.Data}}, nil
+	}
+	return nil, unsupported("")
+}
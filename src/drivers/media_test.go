@@ -0,0 +1,191 @@
+package drivers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+const pngData = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg=="
+
+// mediaRequest parses a chat request carrying an image by URL, an inline
+// image, a PDF and a wav clip.
+func mediaRequest(t *testing.T) *ail.Program {
+	t.Helper()
+	parser, err := styles.GetParser(ail.StyleChatCompletions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.ParseRequest([]byte(`{"model": "m", "messages": [{"role": "user", "content": [
+		{"type": "text", "text": "Compare"},
+		{"type": "image_url", "image_url": {"url": "https://example.com/cat.jpg"}},
+		{"type": "image_url", "image_url": {"url": "data:image/png;base64,` + pngData + `"}},
+		{"type": "file", "file": {"filename": "a.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}},
+		{"type": "input_audio", "input_audio": {"data": "UklGRg==", "format": "wav"}}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// emitContent prepares prog for style and returns the first message's
+// content as emitted.
+func emitContent(t *testing.T, style ail.Style, prog *ail.Program) ([]any, error) {
+	t.Helper()
+	out, err := prepareMedia(style, prog)
+	if err != nil {
+		return nil, err
+	}
+	em, _ := ail.GetEmitter(style)
+	body, err := em.EmitRequest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	key, msgs := "content", "messages"
+	switch style {
+	case ail.StyleGoogleGenAI:
+		key, msgs = "parts", "contents"
+	case ail.StyleResponses:
+		msgs = "input"
+	}
+	list, _ := v[msgs].([]any)
+	if len(list) == 0 {
+		t.Fatalf("%s: no messages in %s", style, body)
+	}
+	parts, _ := list[0].(map[string]any)[key].([]any)
+	return parts, nil
+}
+
+func partJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestPrepareMedia_Chat(t *testing.T) {
+	parts, err := emitContent(t, ail.StyleChatCompletions, mediaRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"text":"Compare","type":"text"}`,
+		`{"image_url":{"url":"https://example.com/cat.jpg"},"type":"image_url"}`,
+		`{"image_url":{"url":"data:image/png;base64,` + pngData + `"},"type":"image_url"}`,
+		`{"file":{"file_data":"data:application/pdf;base64,JVBERi0=","filename":"file.pdf"},"type":"file"}`,
+		`{"input_audio":{"data":"UklGRg==","format":"wav"},"type":"input_audio"}`,
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("parts = %s", partJSON(parts))
+	}
+	for i, w := range want {
+		if got := partJSON(parts[i]); got != w {
+			t.Errorf("part %d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestPrepareMedia_Anthropic(t *testing.T) {
+	if _, err := emitContent(t, ail.StyleAnthropic, mediaRequest(t)); !isUnsupported(err) {
+		t.Fatalf("audio to Anthropic: err = %v, want unsupported", err)
+	}
+
+	prog := mediaRequest(t)
+	prog.Code = dropOps(prog.Code, ail.AUD_REF)
+	parts, err := emitContent(t, ail.StyleAnthropic, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"text":"Compare","type":"text"}`,
+		`{"source":{"type":"url","url":"https://example.com/cat.jpg"},"type":"image"}`,
+		`{"source":{"data":"` + pngData + `","media_type":"image/png","type":"base64"},"type":"image"}`,
+		`{"source":{"data":"JVBERi0=","media_type":"application/pdf","type":"base64"},"type":"document"}`,
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("parts = %s", partJSON(parts))
+	}
+	for i, w := range want {
+		if got := partJSON(parts[i]); got != w {
+			t.Errorf("part %d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestPrepareMedia_Gemini(t *testing.T) {
+	parts, err := emitContent(t, ail.StyleGoogleGenAI, mediaRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"text":"Compare"}`,
+		`{"fileData":{"fileUri":"https://example.com/cat.jpg","mimeType":"image/jpeg"}}`,
+		`{"inlineData":{"data":"` + pngData + `","mimeType":"image/png"}}`,
+		`{"inlineData":{"data":"JVBERi0=","mimeType":"application/pdf"}}`,
+		`{"inlineData":{"data":"UklGRg==","mimeType":"audio/wav"}}`,
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("parts = %s", partJSON(parts))
+	}
+	for i, w := range want {
+		if got := partJSON(parts[i]); got != w {
+			t.Errorf("part %d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestPrepareMedia_Responses(t *testing.T) {
+	prog := mediaRequest(t)
+	prog.Code = dropOps(prog.Code, ail.AUD_REF, ail.TXT_CHUNK)
+	parts, err := emitContent(t, ail.StyleResponses, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"image_url":"https://example.com/cat.jpg","type":"input_image"}`,
+		`{"image_url":"data:image/png;base64,` + pngData + `","type":"input_image"}`,
+		`{"file_data":"data:application/pdf;base64,JVBERi0=","filename":"file.pdf","type":"input_file"}`,
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("parts = %s", partJSON(parts))
+	}
+	for i, w := range want {
+		if got := partJSON(parts[i]); got != w {
+			t.Errorf("part %d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestPrepareMedia_TextOnly(t *testing.T) {
+	prog := testProgram(false)
+	if out, err := prepareMedia(ail.StyleAnthropic, prog); err != nil || out != prog {
+		t.Errorf("text-only program rewritten: %v", err)
+	}
+}
+
+func dropOps(code []ail.Instruction, ops ...ail.Opcode) []ail.Instruction {
+	var out []ail.Instruction
+	for _, inst := range code {
+		keep := true
+		for _, op := range ops {
+			if inst.Op == op || (op == ail.AUD_REF && inst.Op == ail.SET_META && inst.Str == "audio/wav") {
+				keep = false
+			}
+		}
+		if keep {
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+func isUnsupported(err error) bool {
+	var re *services.RouterError
+	return errors.As(err, &re) && re.Kind == services.ErrorUnsupported
+}
@@ -0,0 +1,91 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Embeddings creates embeddings through an OpenAI-compatible /embeddings
+// endpoint. Input lists longer than the provider takes in one request are
+// split into batches sent concurrently, and the answers merged in order.
+type Embeddings struct {
+	BatchSize   int // inputs per upstream request; 0 means drivers.DefaultEmbeddingsBatchSize
+	Concurrency int // batches sent at once; 0 means drivers.DefaultEmbeddingsConcurrency
+}
+
+func (c *Embeddings) DoEmbeddings(p *services.ProviderService, req *drivers.EmbeddingsRequest, r *http.Request) (*drivers.EmbeddingsResponse, error) {
+	size := c.BatchSize
+	if size <= 0 {
+		size = drivers.DefaultEmbeddingsBatchSize
+	}
+	return drivers.BatchEmbeddings(r.Context(), req, size, c.Concurrency, func(ctx context.Context, batch *drivers.EmbeddingsRequest) (*drivers.EmbeddingsResponse, error) {
+		return c.send(ctx, p, batch, r)
+	})
+}
+
+// send makes one upstream request.
+func (c *Embeddings) send(ctx context.Context, p *services.ProviderService, batch *drivers.EmbeddingsRequest, r *http.Request) (*drivers.EmbeddingsResponse, error) {
+	body, err := batch.Body()
+	if err != nil {
+		return nil, err
+	}
+	targetUrl := p.ParsedURL
+	targetUrl.Path += "/embeddings"
+
+	targetHeader := r.Header.Clone()
+	targetHeader.Del("Accept-Encoding")
+	targetHeader.Set("Content-Type", "application/json")
+	if traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string); traceID != "" {
+		targetHeader.Set(plugin.RequestIDHeader, traceID)
+	}
+
+	req := &http.Request{
+		Method:        "POST",
+		URL:           &targetUrl,
+		Header:        targetHeader,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	req = req.WithContext(ctx)
+
+	authVal, err := p.Router.Auth.CollectTargetAuth("embeddings", p, r, req)
+	if err != nil {
+		return nil, err
+	}
+	if authVal != "" {
+		req.Header.Set("Authorization", "Bearer "+authVal)
+	}
+
+	resp, err := p.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, services.UpstreamError(p.Name, resp, data)
+	}
+
+	var result drivers.EmbeddingsResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("embeddings: %s; data: %s", err, string(data))
+	}
+	if len(result.Data) != len(batch.Inputs) {
+		return nil, fmt.Errorf("embeddings: upstream returned %d embeddings for %d inputs", len(result.Data), len(batch.Inputs))
+	}
+	return &result, nil
+}
+
+var _ drivers.EmbeddingsCommand = (*Embeddings)(nil)
@@ -35,12 +35,12 @@ func (c *ListModels) DoListModels(p *services.ProviderService, r *http.Request)
 		req.Header.Set("Authorization", "Bearer "+authVal)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.Client().Do(req)
 	if err != nil {
 		// Retry without Bearer prefix
 		if authVal != "" {
 			req.Header.Set("Authorization", authVal)
-			resp, err = http.DefaultClient.Do(req)
+			resp, err = p.Client().Do(req)
 		}
 		if err != nil {
 			return nil, err
@@ -53,6 +53,12 @@ func (c *ListModels) DoListModels(p *services.ProviderService, r *http.Request)
 		return nil, err
 	}
 
+	// Error bodies such as {"error":{...}} unmarshal cleanly into an empty
+	// list, so reject non-2xx responses explicitly.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("list models: upstream returned %d: %s", resp.StatusCode, string(data))
+	}
+
 	var result struct {
 		Data []drivers.ListModelsModel `json:"data"`
 	}
@@ -0,0 +1,252 @@
+package drivers
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// reasoningBudgets maps reasoning efforts to thinking token budgets for
+// upstreams that take a budget (Anthropic, Gemini).
+var reasoningBudgets = map[string]int{
+	"minimal": 1024,
+	"low":     2048,
+	"medium":  8192,
+	"high":    24576,
+}
+
+// defaultThinkingOutput is the room left for the answer when an Anthropic
+// request enables thinking without max_tokens, which must exceed the
+// thinking budget.
+const defaultThinkingOutput = 4096
+
+// reasoningConfig is a request's SET_THINK in any client shape:
+// {"effort"} from chat reasoning_effort, {"effort","summary"} from the
+// Responses API, {"type","budget_tokens"} from Anthropic, and
+// {"thinking_budget","include_thoughts"} or {"thinkingLevel"} from Gemini.
+type reasoningConfig struct {
+	Disabled bool
+	Effort   string
+	Budget   int
+	Summary  string
+}
+
+func parseReasoning(raw json.RawMessage) (reasoningConfig, bool) {
+	var v struct {
+		Effort          string `json:"effort"`
+		Summary         string `json:"summary"`
+		Type            string `json:"type"`
+		BudgetTokens    *int   `json:"budget_tokens"`
+		ThinkingBudget  *int   `json:"thinking_budget"`
+		ThinkingBudget2 *int   `json:"thinkingBudget"`
+		ThinkingLevel   string `json:"thinking_level"`
+		ThinkingLevel2  string `json:"thinkingLevel"`
+	}
+	if json.Unmarshal(raw, &v) != nil {
+		return reasoningConfig{}, false
+	}
+	c := reasoningConfig{Effort: v.Effort, Summary: v.Summary}
+	if c.Effort == "" {
+		c.Effort = v.ThinkingLevel + v.ThinkingLevel2
+	}
+	for _, b := range []*int{v.BudgetTokens, v.ThinkingBudget, v.ThinkingBudget2} {
+		if b != nil {
+			c.Budget = *b
+		}
+	}
+	switch {
+	case v.Type == "disabled", c.Effort == "none":
+		c.Disabled = true
+	case c.Budget == 0 && (v.ThinkingBudget != nil || v.ThinkingBudget2 != nil):
+		c.Disabled = true
+	case c.Budget < 0:
+		c.Budget = 0 // Gemini's -1: let the model decide
+		if c.Effort == "" {
+			c.Effort = "medium"
+		}
+	}
+	if c.Effort == "" && c.Budget == 0 && !c.Disabled {
+		return reasoningConfig{}, false
+	}
+	return c, true
+}
+
+func (c reasoningConfig) effort() string {
+	if c.Effort != "" {
+		return c.Effort
+	}
+	switch {
+	case c.Budget <= reasoningBudgets["low"]:
+		return "low"
+	case c.Budget <= reasoningBudgets["medium"]:
+		return "medium"
+	}
+	return "high"
+}
+
+func (c reasoningConfig) budget() int {
+	if c.Budget > 0 {
+		return c.Budget
+	}
+	if b, ok := reasoningBudgets[c.effort()]; ok {
+		return b
+	}
+	return reasoningBudgets["medium"]
+}
+
+// normalizeReasoning rewrites prog's SET_THINK into the upstream style's
+// shape, so clients can ask for reasoning with reasoning_effort, the
+// Responses API's reasoning, Anthropic's thinking or Gemini's
+// thinking_config regardless of the upstream:
+//
+//   - chat-completions: reasoning_effort; disabling drops it.
+//   - openai-responses: reasoning with a summary (default "auto"), since
+//     without one no reasoning text is returned.
+//   - anthropic-messages: thinking with a token budget. max_tokens is
+//     raised above the budget when needed, and temperature/top_p, which
+//     thinking does not accept, are dropped.
+//   - google-genai: thinking_config with a budget and include_thoughts.
+//
+// Reasoning text comes back as THINK_* blocks and STREAM_THINK_DELTA from
+// every upstream, so each client sees it in its own API's place.
+func normalizeReasoning(style ail.Style, prog *ail.Program) *ail.Program {
+	at := -1
+	for i, inst := range prog.Code {
+		if inst.Op == ail.SET_THINK {
+			at = i
+		}
+	}
+	if at < 0 {
+		return prog
+	}
+	c, ok := parseReasoning(prog.Code[at].JSON)
+	if !ok {
+		return prog
+	}
+
+	var cfg any
+	switch style {
+	case ail.StyleChatCompletions:
+		if !c.Disabled {
+			cfg = map[string]any{"effort": c.effort()}
+		}
+	case ail.StyleResponses:
+		if !c.Disabled {
+			summary := c.Summary
+			if summary == "" {
+				summary = "auto"
+			}
+			cfg = map[string]any{"effort": c.effort(), "summary": summary}
+		}
+	case ail.StyleAnthropic:
+		if c.Disabled {
+			cfg = map[string]any{"type": "disabled"}
+			break
+		}
+		budget := max(c.budget(), reasoningBudgets["minimal"])
+		cfg = map[string]any{"type": "enabled", "budget_tokens": budget}
+		return anthropicThinking(prog, at, cfg, budget)
+	case ail.StyleGoogleGenAI:
+		if c.Disabled {
+			cfg = map[string]any{"thinking_budget": 0}
+		} else {
+			cfg = map[string]any{"thinking_budget": c.budget(), "include_thoughts": true}
+		}
+	default:
+		return prog
+	}
+
+	if cfg == nil {
+		return prog.ClearAtIndex(at)
+	}
+	prog = prog.Clone()
+	prog.Code[at].JSON, _ = json.Marshal(cfg)
+	return prog
+}
+
+// anthropicThinking sets an enabled thinking config on an Anthropic
+// request and fixes up the parameters thinking constrains.
+func anthropicThinking(prog *ail.Program, at int, cfg any, budget int) *ail.Program {
+	var drop []int
+	maxAt := -1
+	for i, inst := range prog.Code {
+		switch inst.Op {
+		case ail.SET_TEMP, ail.SET_TOPP:
+			drop = append(drop, i)
+		case ail.SET_MAX:
+			maxAt = i
+		}
+	}
+	prog = prog.Clone()
+	prog.Code[at].JSON, _ = json.Marshal(cfg)
+	if maxAt < 0 {
+		prog.EmitInt(ail.SET_MAX, int32(budget+defaultThinkingOutput))
+	} else if int(prog.Code[maxAt].Int) <= budget {
+		prog.Code[maxAt].Int += int32(budget)
+	}
+	if len(drop) > 0 {
+		prog = prog.ClearAtIndex(drop...)
+	}
+	return prog
+}
+
+// chatReasoningParser accepts the "reasoning" field some chat-completions
+// upstreams (vLLM, OpenRouter) send in place of DeepSeek's
+// "reasoning_content", which is the field the chat parsers read.
+type chatReasoningParser struct {
+	resp  ail.ResponseParser
+	chunk ail.StreamChunkParser
+}
+
+func (p chatReasoningParser) ParseResponse(body []byte) (*ail.Program, error) {
+	return p.resp.ParseResponse(renameReasoning(body, "message"))
+}
+
+func (p chatReasoningParser) ParseStreamChunk(body []byte) (*ail.Program, error) {
+	return p.chunk.ParseStreamChunk(renameReasoning(body, "delta"))
+}
+
+// renameReasoning moves choices[].<field>.reasoning to reasoning_content
+// when the latter is absent.
+func renameReasoning(body []byte, field string) []byte {
+	if !bytes.Contains(body, []byte(`"reasoning"`)) {
+		return body
+	}
+	var v map[string]json.RawMessage
+	if json.Unmarshal(body, &v) != nil {
+		return body
+	}
+	var choices []map[string]json.RawMessage
+	if json.Unmarshal(v["choices"], &choices) != nil {
+		return body
+	}
+	changed := false
+	for _, c := range choices {
+		var m map[string]json.RawMessage
+		if json.Unmarshal(c[field], &m) != nil {
+			continue
+		}
+		r, ok := m["reasoning"]
+		if _, has := m["reasoning_content"]; !ok || has {
+			continue
+		}
+		var text string
+		if json.Unmarshal(r, &text) != nil {
+			continue // a structured reasoning object, not text
+		}
+		m["reasoning_content"] = r
+		delete(m, "reasoning")
+		c[field], _ = json.Marshal(m)
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+	v["choices"], _ = json.Marshal(choices)
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package drivers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func thinkProgram(cfg string) *ail.Program {
+	prog := testProgram(false)
+	prog.EmitFloat(ail.SET_TEMP, 0.2)
+	prog.EmitInt(ail.SET_MAX, 1000)
+	prog.EmitJSON(ail.SET_THINK, json.RawMessage(cfg))
+	return prog
+}
+
+func TestNormalizeReasoning(t *testing.T) {
+	cases := []struct {
+		name  string
+		style ail.Style
+		in    string
+		want  map[string]any // request body fields
+	}{
+		{"effort to anthropic", ail.StyleAnthropic, `{"effort":"high"}`, map[string]any{
+			"thinking":   map[string]any{"type": "enabled", "budget_tokens": float64(24576)},
+			"max_tokens": float64(25576),
+		}},
+		{"anthropic budget to chat", ail.StyleChatCompletions, `{"type":"enabled","budget_tokens":4000}`, map[string]any{
+			"reasoning_effort": "medium",
+		}},
+		{"effort to responses", ail.StyleResponses, `{"effort":"low"}`, map[string]any{
+			"reasoning": map[string]any{"effort": "low", "summary": "auto"},
+		}},
+		{"gemini budget to anthropic", ail.StyleAnthropic, `{"thinking_budget":512}`, map[string]any{
+			"thinking": map[string]any{"type": "enabled", "budget_tokens": float64(1024)},
+		}},
+		{"disabled to chat", ail.StyleChatCompletions, `{"type":"disabled"}`, map[string]any{
+			"reasoning_effort": nil,
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := emitBody(t, tc.style, "", normalizeReasoning(tc.style, thinkProgram(tc.in)))
+			for k, want := range tc.want {
+				got, _ := json.Marshal(body[k])
+				exp, _ := json.Marshal(want)
+				if string(got) != string(exp) {
+					t.Errorf("%s = %s, want %s", k, got, exp)
+				}
+			}
+			if tc.style == ail.StyleAnthropic && body["temperature"] != nil {
+				t.Errorf("temperature sent with thinking: %v", body["temperature"])
+			}
+		})
+	}
+
+	// Gemini gets a budget and asks for the thoughts back.
+	body := emitBody(t, ail.StyleGoogleGenAI, "", normalizeReasoning(ail.StyleGoogleGenAI, thinkProgram(`{"effort":"medium"}`)))
+	tc := body["generation_config"].(map[string]any)["thinking_config"].(map[string]any)
+	if tc["thinking_budget"] != float64(8192) || tc["include_thoughts"] != true {
+		t.Errorf("thinking_config = %v", tc)
+	}
+}
+
+func TestChatReasoningParser(t *testing.T) {
+	d, err := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := d.chunkParser.ParseStreamChunk([]byte(`{"choices":[{"index":0,"delta":{"reasoning":"hmm"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.FindAll(ail.STREAM_THINK_DELTA)) != 1 {
+		t.Errorf("reasoning delta not parsed as thinking: %s", chunk.Disasm())
+	}
+
+	resp, err := d.respParser.ParseResponse([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","reasoning":"because","content":"yes"},"finish_reason":"stop"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.FindAll(ail.THINK_CHUNK)) != 1 {
+		t.Errorf("reasoning not parsed as thinking: %s", resp.Disasm())
+	}
+}
@@ -0,0 +1,84 @@
+package drivers
+
+import (
+	"encoding/json"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// responsesChunkParser fixes the tool-call and finish handling of the ail
+// Responses stream parser, which indexes a call's announcement and its
+// argument deltas differently and reports a finish for every output item.
+// Tool deltas here are keyed by output_index, like the other styles key
+// them by choice or content block, and the finish reason is read once from
+// the final response, which may also be response.incomplete. Other events
+// go to the wrapped parser.
+type responsesChunkParser struct {
+	inner ail.StreamChunkParser
+}
+
+func (p responsesChunkParser) ParseStreamChunk(body []byte) (*ail.Program, error) {
+	var ev struct {
+		Type        string `json:"type"`
+		OutputIndex int    `json:"output_index"`
+		Delta       string `json:"delta"`
+		Item        struct {
+			Type   string `json:"type"`
+			CallID string `json:"call_id"`
+			Name   string `json:"name"`
+		} `json:"item"`
+		Response struct {
+			Status string `json:"status"`
+			Output []struct {
+				Type string `json:"type"`
+			} `json:"output"`
+			Usage *struct {
+				InputTokens  int `json:"input_tokens"`
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return p.inner.ParseStreamChunk(body)
+	}
+
+	prog := ail.NewProgram()
+	switch ev.Type {
+	case "response.output_item.added":
+		if ev.Item.Type == "function_call" {
+			j, _ := json.Marshal(map[string]any{"index": ev.OutputIndex, "id": ev.Item.CallID, "name": ev.Item.Name})
+			prog.EmitJSON(ail.STREAM_TOOL_DELTA, j)
+		}
+		return prog, nil
+	case "response.function_call_arguments.delta":
+		j, _ := json.Marshal(map[string]any{"index": ev.OutputIndex, "arguments": ev.Delta})
+		prog.EmitJSON(ail.STREAM_TOOL_DELTA, j)
+		return prog, nil
+	case "response.output_item.done":
+		return prog, nil
+	case "response.completed", "response.incomplete", "response.done":
+		finish := "stop"
+		if ev.Response.Status == "incomplete" {
+			finish = "length"
+		} else {
+			for _, item := range ev.Response.Output {
+				if item.Type == "function_call" {
+					finish = "tool_calls"
+					break
+				}
+			}
+		}
+		prog.EmitString(ail.RESP_DONE, finish)
+		if u := ev.Response.Usage; u != nil {
+			j, _ := json.Marshal(map[string]int{
+				"prompt_tokens":     u.InputTokens,
+				"completion_tokens": u.OutputTokens,
+				"total_tokens":      u.InputTokens + u.OutputTokens,
+			})
+			prog.EmitJSON(ail.USAGE, j)
+		}
+		prog.Emit(ail.STREAM_END)
+		return prog, nil
+	}
+	return p.inner.ParseStreamChunk(body)
+}
@@ -0,0 +1,65 @@
+package drivers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func TestResponsesChunkParser_ToolCalls(t *testing.T) {
+	d, err := NewInferenceSse(ail.StyleResponses, "/responses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{
+		`{"type":"response.created","response":{"id":"resp_1","model":"gpt-x"}}`,
+		`{"type":"response.output_item.added","output_index":1,"item":{"type":"function_call","id":"fc_1","call_id":"call_a","name":"weather"}}`,
+		`{"type":"response.function_call_arguments.delta","output_index":1,"item_id":"fc_1","delta":"{\"city\":"}`,
+		`{"type":"response.function_call_arguments.delta","output_index":1,"item_id":"fc_1","delta":"\"Oslo\"}"}`,
+		`{"type":"response.output_item.done","output_index":1,"item":{"type":"function_call","status":"completed"}}`,
+		`{"type":"response.completed","response":{"status":"completed","output":[{"type":"message"},{"type":"function_call"}],"usage":{"input_tokens":3,"output_tokens":4}}}`,
+	}
+
+	var deltas []map[string]any
+	var finishes []string
+	var usage, ended bool
+	for _, e := range events {
+		prog, err := d.chunkParser.ParseStreamChunk([]byte(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, inst := range prog.Code {
+			switch inst.Op {
+			case ail.STREAM_TOOL_DELTA:
+				var v map[string]any
+				_ = json.Unmarshal(inst.JSON, &v)
+				deltas = append(deltas, v)
+			case ail.RESP_DONE:
+				finishes = append(finishes, inst.Str)
+			case ail.USAGE:
+				usage = true
+			case ail.STREAM_END:
+				ended = true
+			}
+		}
+	}
+
+	if len(deltas) != 3 {
+		t.Fatalf("tool deltas = %v", deltas)
+	}
+	for _, v := range deltas {
+		if v["index"] != float64(1) {
+			t.Errorf("delta %v not keyed by output_index", v)
+		}
+	}
+	if deltas[0]["id"] != "call_a" || deltas[0]["name"] != "weather" {
+		t.Errorf("first delta = %v", deltas[0])
+	}
+	if len(finishes) != 1 || finishes[0] != "tool_calls" {
+		t.Errorf("finish reasons = %v, want [tool_calls]", finishes)
+	}
+	if !usage || !ended {
+		t.Errorf("usage=%v ended=%v", usage, ended)
+	}
+}
@@ -0,0 +1,489 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// StructuredOutputsGrammar is the provider structured_outputs mode that
+// sends a GBNF grammar instead of response_format, for llama.cpp servers.
+const StructuredOutputsGrammar = "grammar"
+
+// structuredOutputTool is the tool Anthropic upstreams are forced to call
+// with the structured answer as its input.
+const structuredOutputTool = "json_response"
+
+// responseFormat is a client's response_format in either OpenAI shape:
+// chat-completions nests the schema under "json_schema", the Responses
+// API's text.format has it at the top level.
+type responseFormat struct {
+	Type        string
+	Name        string
+	Description string
+	Schema      json.RawMessage
+	Strict      *bool
+}
+
+func parseResponseFormat(raw json.RawMessage) (responseFormat, bool) {
+	type schemaSpec struct {
+		Name        string          `json:"name,omitempty"`
+		Description string          `json:"description,omitempty"`
+		Schema      json.RawMessage `json:"schema,omitempty"`
+		Strict      *bool           `json:"strict,omitempty"`
+	}
+	var v struct {
+		Type       string      `json:"type"`
+		JSONSchema *schemaSpec `json:"json_schema,omitempty"`
+		schemaSpec
+	}
+	if json.Unmarshal(raw, &v) != nil || v.Type == "" {
+		return responseFormat{}, false
+	}
+	spec := v.schemaSpec
+	if v.JSONSchema != nil {
+		spec = *v.JSONSchema
+	}
+	return responseFormat{
+		Type:        v.Type,
+		Name:        spec.Name,
+		Description: spec.Description,
+		Schema:      spec.Schema,
+		Strict:      spec.Strict,
+	}, true
+}
+
+// wantsJSON reports whether the format asks for a JSON answer.
+func (f responseFormat) wantsJSON() bool {
+	return f.Type == "json_object" || f.Type == "json_schema"
+}
+
+// schema returns the JSON schema the answer must match; json_object
+// answers are any object.
+func (f responseFormat) schema() json.RawMessage {
+	if f.Type == "json_schema" && len(f.Schema) > 0 {
+		return f.Schema
+	}
+	return json.RawMessage(`{"type":"object"}`)
+}
+
+func (f responseFormat) spec() map[string]any {
+	spec := map[string]any{"name": f.Name}
+	if f.Name == "" {
+		spec["name"] = "response"
+	}
+	if f.Description != "" {
+		spec["description"] = f.Description
+	}
+	if len(f.Schema) > 0 {
+		spec["schema"] = f.Schema
+	}
+	if f.Strict != nil {
+		spec["strict"] = *f.Strict
+	}
+	return spec
+}
+
+// chat returns the chat-completions response_format.
+func (f responseFormat) chat() json.RawMessage {
+	v := map[string]any{"type": f.Type}
+	if f.Type == "json_schema" {
+		v["json_schema"] = f.spec()
+	}
+	j, _ := json.Marshal(v)
+	return j
+}
+
+// responses returns the Responses API text.format.
+func (f responseFormat) responses() json.RawMessage {
+	v := map[string]any{"type": f.Type}
+	if f.Type == "json_schema" {
+		for k, val := range f.spec() {
+			v[k] = val
+		}
+	}
+	j, _ := json.Marshal(v)
+	return j
+}
+
+// structuredOutput maps a request's response_format onto the upstream's
+// native mechanism and, where that mechanism is not a plain text answer,
+// maps the response back. A nil *structuredOutput leaves the request and
+// response unchanged.
+type structuredOutput struct {
+	// patch rewrites the emitted request body.
+	patch func(body map[string]any) error
+	// forcedTool is the tool whose call carries the answer (Anthropic).
+	forcedTool string
+}
+
+// prepareStructuredOutput rewrites prog's SET_FMT for the upstream style:
+//
+//   - chat-completions, openai-responses: response_format in the API's own
+//     shape, so clients of either API can target both.
+//   - google-genai: generation_config.response_mime_type and response_schema.
+//   - anthropic-messages: a tool taking the schema as input, forced with
+//     tool_choice; its call is turned back into the text answer.
+//   - mode "grammar": a GBNF grammar in the "grammar" field (llama.cpp).
+func prepareStructuredOutput(style ail.Style, mode string, prog *ail.Program) (*ail.Program, *structuredOutput, error) {
+	at := -1
+	for i, inst := range prog.Code {
+		if inst.Op == ail.SET_FMT {
+			at = i
+		}
+	}
+	if at < 0 {
+		return prog, nil, nil
+	}
+	format, ok := parseResponseFormat(prog.Code[at].JSON)
+	if !ok {
+		return prog, nil, nil
+	}
+	so := &structuredOutput{}
+
+	if mode == StructuredOutputsGrammar {
+		prog = prog.ClearAtIndex(at)
+		if !format.wantsJSON() {
+			return prog, nil, nil
+		}
+		grammar, err := schemaGrammar(format.schema())
+		if err != nil {
+			return nil, nil, fmt.Errorf("structured output: %w", err)
+		}
+		so.patch = func(body map[string]any) error {
+			body["grammar"] = grammar
+			return nil
+		}
+		return prog, so, nil
+	}
+
+	switch style {
+	case ail.StyleChatCompletions:
+		prog = prog.Clone()
+		prog.Code[at].JSON = format.chat()
+		return prog, nil, nil
+	case ail.StyleResponses:
+		prog = prog.Clone()
+		prog.Code[at].JSON = format.responses()
+		return prog, nil, nil
+	}
+
+	prog = prog.ClearAtIndex(at)
+	if !format.wantsJSON() {
+		return prog, nil, nil
+	}
+	switch style {
+	case ail.StyleGoogleGenAI:
+		var schema map[string]any
+		if format.Type == "json_schema" {
+			var err error
+			if schema, err = GeminiSchema(format.Schema); err != nil {
+				return nil, nil, fmt.Errorf("structured output: %w", err)
+			}
+		}
+		so.patch = func(body map[string]any) error {
+			cfg, _ := body["generation_config"].(map[string]any)
+			if cfg == nil {
+				cfg = make(map[string]any)
+				body["generation_config"] = cfg
+			}
+			cfg["response_mime_type"] = "application/json"
+			if schema != nil {
+				cfg["response_schema"] = schema
+			}
+			return nil
+		}
+	case ail.StyleAnthropic:
+		so.forcedTool = structuredOutputTool
+		prog.Emit(ail.DEF_START)
+		prog.EmitString(ail.DEF_NAME, structuredOutputTool)
+		desc := "Respond with a JSON object matching this schema."
+		if format.Description != "" {
+			desc += " " + format.Description
+		}
+		prog.EmitString(ail.DEF_DESC, desc)
+		prog.EmitJSON(ail.DEF_SCHEMA, format.schema())
+		prog.Emit(ail.DEF_END)
+		// Anthropic rejects forced tool use with thinking enabled; the
+		// lone tool and its description steer the model instead.
+		choice := json.RawMessage(`{"type":"tool","name":"` + structuredOutputTool + `"}`)
+		if thinkingEnabled(prog) {
+			choice = json.RawMessage(`{"type":"auto"}`)
+		}
+		prog = setExt(prog, "tool_choice", choice)
+	default:
+		return prog, nil, nil
+	}
+	return prog, so, nil
+}
+
+func thinkingEnabled(prog *ail.Program) bool {
+	for _, inst := range prog.Code {
+		if inst.Op == ail.SET_THINK {
+			var v struct {
+				Type string `json:"type"`
+			}
+			return json.Unmarshal(inst.JSON, &v) == nil && v.Type == "enabled"
+		}
+	}
+	return false
+}
+
+// setExt replaces the top-level EXT_DATA key of prog.
+func setExt(prog *ail.Program, key string, val json.RawMessage) *ail.Program {
+	var drop []int
+	for i, inst := range prog.Code {
+		if inst.Op == ail.EXT_DATA && inst.Key == key {
+			drop = append(drop, i)
+		}
+	}
+	if len(drop) > 0 {
+		prog = prog.ClearAtIndex(drop...)
+	}
+	prog.EmitKeyJSON(ail.EXT_DATA, key, val)
+	return prog
+}
+
+// patchBody applies the request body rewrite, if any.
+func (s *structuredOutput) patchBody(body []byte) ([]byte, error) {
+	if s == nil || s.patch == nil {
+		return body, nil
+	}
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	if err := s.patch(v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// response turns a call of the forced tool back into the text answer.
+func (s *structuredOutput) response(prog *ail.Program) *ail.Program {
+	if s == nil || s.forcedTool == "" {
+		return prog
+	}
+	out := ail.NewProgram()
+	out.Buffers = prog.Buffers
+	found := false
+	for i := 0; i < len(prog.Code); i++ {
+		inst := prog.Code[i]
+		if inst.Op != ail.CALL_START {
+			out.Code = append(out.Code, inst)
+			continue
+		}
+		end := i
+		for end < len(prog.Code) && prog.Code[end].Op != ail.CALL_END {
+			end++
+		}
+		var name string
+		var args json.RawMessage
+		for _, c := range prog.Code[i:min(end+1, len(prog.Code))] {
+			switch c.Op {
+			case ail.CALL_NAME:
+				name = c.Str
+			case ail.CALL_ARGS:
+				args = c.JSON
+			}
+		}
+		if name != s.forcedTool {
+			out.Code = append(out.Code, inst)
+			continue
+		}
+		found = true
+		out.EmitString(ail.TXT_CHUNK, string(args))
+		i = end
+	}
+	if found {
+		s.fixFinish(out)
+	}
+	return out
+}
+
+// chunk turns the forced tool's streamed arguments into text deltas.
+// Anthropic streams name the tool in the content block's first delta and
+// key later deltas by the same index.
+type structuredStream struct {
+	so    *structuredOutput
+	index *int
+}
+
+func (s *structuredOutput) stream() *structuredStream {
+	if s == nil || s.forcedTool == "" {
+		return nil
+	}
+	return &structuredStream{so: s}
+}
+
+func (st *structuredStream) chunk(prog *ail.Program) *ail.Program {
+	if st == nil {
+		return prog
+	}
+	out := ail.NewProgram()
+	out.Buffers = prog.Buffers
+	for _, inst := range prog.Code {
+		if inst.Op != ail.STREAM_TOOL_DELTA {
+			out.Code = append(out.Code, inst)
+			continue
+		}
+		var td struct {
+			Index     int    `json:"index"`
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		}
+		if json.Unmarshal(inst.JSON, &td) != nil {
+			out.Code = append(out.Code, inst)
+			continue
+		}
+		if td.Name == st.so.forcedTool && st.index == nil {
+			st.index = &td.Index
+		}
+		if st.index == nil || td.Index != *st.index {
+			out.Code = append(out.Code, inst)
+			continue
+		}
+		if td.Arguments != "" {
+			out.EmitString(ail.STREAM_DELTA, td.Arguments)
+		}
+	}
+	if st.index != nil {
+		st.so.fixFinish(out)
+	}
+	return out
+}
+
+// fixFinish reports the forced call as a normal stop.
+func (s *structuredOutput) fixFinish(prog *ail.Program) {
+	for i, inst := range prog.Code {
+		if inst.Op == ail.RESP_DONE && inst.Str == "tool_calls" {
+			prog.Code[i].Str = "stop"
+		}
+	}
+}
+
+// geminiKeys are the schema keywords Gemini's response_schema (an OpenAPI
+// subset) accepts.
+var geminiKeys = map[string]bool{
+	"type": true, "format": true, "description": true, "nullable": true,
+	"enum": true, "properties": true, "required": true, "items": true,
+	"anyOf": true, "minItems": true, "maxItems": true, "minimum": true,
+	"maximum": true, "minLength": true, "maxLength": true, "pattern": true,
+	"title": true, "propertyOrdering": true,
+}
+
+// GeminiSchema converts a JSON schema to a Gemini response_schema: local
+// $refs are inlined, ["T","null"] types become nullable, const becomes a
+// one-value enum, and unsupported keywords are dropped.
+func GeminiSchema(raw json.RawMessage) (map[string]any, error) {
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	refs := localRefs(root)
+	var conv func(s map[string]any, depth int) (map[string]any, error)
+	conv = func(s map[string]any, depth int) (map[string]any, error) {
+		if depth > 32 {
+			return nil, fmt.Errorf("schema nests too deeply (recursive $ref?)")
+		}
+		if ref, ok := s["$ref"].(string); ok {
+			target, ok := refs[ref]
+			if !ok {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+			return conv(target, depth+1)
+		}
+		out := make(map[string]any)
+		for k, v := range s {
+			if !geminiKeys[k] {
+				continue
+			}
+			out[k] = v
+		}
+		if c, ok := s["const"]; ok {
+			out["enum"] = []any{c}
+		}
+		if types, ok := s["type"].([]any); ok {
+			var rest []any
+			for _, t := range types {
+				if t == "null" {
+					out["nullable"] = true
+				} else {
+					rest = append(rest, t)
+				}
+			}
+			if len(rest) == 1 {
+				out["type"] = rest[0]
+			} else {
+				delete(out, "type")
+			}
+		}
+		if t, ok := out["type"].(string); ok {
+			out["type"] = strings.ToUpper(t)
+		}
+		if props, ok := s["properties"].(map[string]any); ok {
+			conved := make(map[string]any, len(props))
+			for name, p := range props {
+				ps, _ := p.(map[string]any)
+				c, err := conv(ps, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				conved[name] = c
+			}
+			out["properties"] = conved
+		}
+		if items, ok := s["items"].(map[string]any); ok {
+			c, err := conv(items, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out["items"] = c
+		}
+		for _, key := range []string{"anyOf", "oneOf"} {
+			alts, ok := s[key].([]any)
+			if !ok {
+				continue
+			}
+			conved := make([]any, 0, len(alts))
+			for _, a := range alts {
+				as, _ := a.(map[string]any)
+				if t, _ := as["type"].(string); t == "null" {
+					out["nullable"] = true
+					continue
+				}
+				c, err := conv(as, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				conved = append(conved, c)
+			}
+			if len(conved) == 1 {
+				for k, v := range conved[0].(map[string]any) {
+					out[k] = v
+				}
+			} else {
+				out["anyOf"] = conved
+			}
+		}
+		return out, nil
+	}
+	return conv(root, 0)
+}
+
+// localRefs maps the local $refs of a schema ("#", "#/$defs/x",
+// "#/definitions/x") to their targets.
+func localRefs(root map[string]any) map[string]map[string]any {
+	refs := map[string]map[string]any{"#": root}
+	for _, key := range []string{"$defs", "definitions"} {
+		m, _ := root[key].(map[string]any)
+		for name, v := range m {
+			if s, ok := v.(map[string]any); ok {
+				refs["#/"+key+"/"+name] = s
+			}
+		}
+	}
+	return refs
+}
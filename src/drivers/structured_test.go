@@ -0,0 +1,207 @@
+package drivers
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+const weatherSchema = `{"type":"object","properties":{"city":{"type":"string"},"temp":{"type":["number","null"]},"unit":{"enum":["c","f"]}},"required":["city","temp"],"additionalProperties":false}`
+
+func formatProgram(format string) *ail.Program {
+	prog := testProgram(false)
+	prog.EmitJSON(ail.SET_FMT, json.RawMessage(format))
+	return prog
+}
+
+func emitBody(t *testing.T, style ail.Style, mode string, prog *ail.Program) map[string]any {
+	t.Helper()
+	out, so, err := prepareStructuredOutput(style, mode, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	em, err := ail.GetEmitter(style)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := em.EmitRequest(out)
+	if err == nil {
+		body, err = so.patchBody(body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestStructuredOutput_OpenAIShapes(t *testing.T) {
+	chatFormat := `{"type":"json_schema","json_schema":{"name":"weather","strict":true,"schema":` + weatherSchema + `}}`
+	respFormat := `{"type":"json_schema","name":"weather","strict":true,"schema":` + weatherSchema + `}`
+
+	// A Responses client's format reaches a chat-completions upstream nested.
+	body := emitBody(t, ail.StyleChatCompletions, "", formatProgram(respFormat))
+	rf := body["response_format"].(map[string]any)
+	js, _ := rf["json_schema"].(map[string]any)
+	if rf["type"] != "json_schema" || js["name"] != "weather" || js["strict"] != true || js["schema"] == nil {
+		t.Errorf("response_format = %v", rf)
+	}
+
+	// A chat client's format reaches a Responses upstream flat.
+	body = emitBody(t, ail.StyleResponses, "", formatProgram(chatFormat))
+	f := body["text"].(map[string]any)["format"].(map[string]any)
+	if f["type"] != "json_schema" || f["name"] != "weather" || f["schema"] == nil || f["json_schema"] != nil {
+		t.Errorf("text.format = %v", f)
+	}
+}
+
+func TestStructuredOutput_Gemini(t *testing.T) {
+	body := emitBody(t, ail.StyleGoogleGenAI, "", formatProgram(
+		`{"type":"json_schema","json_schema":{"name":"weather","schema":`+weatherSchema+`}}`))
+	cfg := body["generation_config"].(map[string]any)
+	if cfg["response_mime_type"] != "application/json" {
+		t.Errorf("generation_config = %v", cfg)
+	}
+	schema := cfg["response_schema"].(map[string]any)
+	if schema["type"] != "OBJECT" || schema["additionalProperties"] != nil {
+		t.Errorf("response_schema = %v", schema)
+	}
+	temp := schema["properties"].(map[string]any)["temp"].(map[string]any)
+	if temp["type"] != "NUMBER" || temp["nullable"] != true {
+		t.Errorf("temp = %v", temp)
+	}
+
+	body = emitBody(t, ail.StyleGoogleGenAI, "", formatProgram(`{"type":"json_object"}`))
+	cfg = body["generation_config"].(map[string]any)
+	if cfg["response_mime_type"] != "application/json" || cfg["response_schema"] != nil {
+		t.Errorf("json_object generation_config = %v", cfg)
+	}
+}
+
+func TestStructuredOutput_AnthropicToolForcing(t *testing.T) {
+	prog := formatProgram(`{"type":"json_schema","json_schema":{"name":"weather","schema":` + weatherSchema + `}}`)
+	prog.EmitKeyJSON(ail.EXT_DATA, "tool_choice", json.RawMessage(`"auto"`))
+	body := emitBody(t, ail.StyleAnthropic, "", prog)
+
+	tools := body["tools"].([]any)
+	tool := tools[len(tools)-1].(map[string]any)
+	if tool["name"] != structuredOutputTool || tool["input_schema"] == nil {
+		t.Errorf("tools = %v", tools)
+	}
+	if tc := body["tool_choice"].(map[string]any); tc["type"] != "tool" || tc["name"] != structuredOutputTool {
+		t.Errorf("tool_choice = %v", body["tool_choice"])
+	}
+
+	_, so, _ := prepareStructuredOutput(ail.StyleAnthropic, "", prog)
+	parser, _ := ail.GetResponseParser(ail.StyleAnthropic)
+	resp, err := parser.ParseResponse([]byte(`{"id":"msg_1","model":"claude","role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"json_response","input":{"city":"Oslo","temp":4}}],"stop_reason":"tool_use"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body2, err := (&ail.ChatCompletionsEmitter{}).EmitResponse(so.response(resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chat struct {
+		Choices []struct {
+			FinishReason string `json:"finish_reason"`
+			Message      struct {
+				Content   string `json:"content"`
+				ToolCalls []any  `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body2, &chat); err != nil {
+		t.Fatal(err)
+	}
+	c := chat.Choices[0]
+	if c.Message.Content != `{"city":"Oslo","temp":4}` || len(c.Message.ToolCalls) != 0 || c.FinishReason != "stop" {
+		t.Errorf("response = %s", body2)
+	}
+}
+
+func TestStructuredOutput_AnthropicStream(t *testing.T) {
+	_, so, _ := prepareStructuredOutput(ail.StyleAnthropic, "", formatProgram(`{"type":"json_object"}`))
+	st := so.stream()
+	parser, _ := ail.GetStreamChunkParser(ail.StyleAnthropic)
+
+	var text strings.Builder
+	finish := ""
+	for _, ev := range []string{
+		`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"json_response"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"ok\":"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"true}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`,
+	} {
+		chunk, err := parser.ParseStreamChunk([]byte(ev))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, inst := range st.chunk(chunk).Code {
+			switch inst.Op {
+			case ail.STREAM_TOOL_DELTA:
+				t.Errorf("tool delta leaked: %s", inst.JSON)
+			case ail.STREAM_DELTA:
+				text.WriteString(inst.Str)
+			case ail.RESP_DONE:
+				finish = inst.Str
+			}
+		}
+	}
+	if text.String() != `{"ok":true}` || finish != "stop" {
+		t.Errorf("text = %q, finish = %q", text.String(), finish)
+	}
+}
+
+func TestStructuredOutput_Grammar(t *testing.T) {
+	schema := `{"type":"object","properties":{"name":{"type":"string"},"tags":{"type":"array","items":{"$ref":"#/$defs/tag"}},"next":{"$ref":"#"}},"required":["name"],"$defs":{"tag":{"enum":["a","b \"q\""]}}}`
+	body := emitBody(t, ail.StyleChatCompletions, StructuredOutputsGrammar, formatProgram(
+		`{"type":"json_schema","json_schema":{"name":"node","schema":`+schema+`}}`))
+	if body["response_format"] != nil {
+		t.Errorf("response_format still sent: %v", body["response_format"])
+	}
+	grammar, _ := body["grammar"].(string)
+	if !strings.HasPrefix(grammar, "root ::= ") && !strings.Contains(grammar, "\nroot ::= ") {
+		t.Fatalf("no root rule:\n%s", grammar)
+	}
+	for _, want := range []string{`"\"name\""`, `"\"b \\\"q\\\"\""`} {
+		if !strings.Contains(grammar, want) {
+			t.Errorf("grammar lacks %s:\n%s", want, grammar)
+		}
+	}
+
+	// Every rule referenced is defined exactly once.
+	defined := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(grammar), "\n") {
+		name, _, ok := strings.Cut(line, " ::= ")
+		if !ok {
+			t.Fatalf("bad rule %q", line)
+		}
+		defined[name]++
+	}
+	literal := regexp.MustCompile(`"(\\.|[^"\\])*"|\[(\\.|[^\]\\])*\]|\{[0-9,]+\}`)
+	ident := regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9-]*`)
+	for _, line := range strings.Split(strings.TrimSpace(grammar), "\n") {
+		name, rhs, _ := strings.Cut(line, " ::= ")
+		if defined[name] != 1 {
+			t.Errorf("rule %s defined %d times", name, defined[name])
+		}
+		for _, ref := range ident.FindAllString(literal.ReplaceAllString(rhs, " "), -1) {
+			if defined[ref] == 0 {
+				t.Errorf("rule %s references undefined %s", name, ref)
+			}
+		}
+	}
+
+	// Grammar mode without a JSON format just drops response_format.
+	body = emitBody(t, ail.StyleChatCompletions, StructuredOutputsGrammar, formatProgram(`{"type":"text"}`))
+	if body["grammar"] != nil || body["response_format"] != nil {
+		t.Errorf("text format body = %v", body)
+	}
+}
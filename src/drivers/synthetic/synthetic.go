@@ -0,0 +1,263 @@
+// Package synthetic provides a stand-in upstream for load tests. It
+// answers OpenAI chat completions in-process, with deterministic text at
+// a configured pace and error rate, so the whole router pipeline (plugins,
+// converters, SSE) can be exercised without spending provider credits.
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTokens is the completion length when Config.Tokens is unset.
+const DefaultTokens = 128
+
+// Config shapes the synthetic upstream's answers.
+type Config struct {
+	// Tokens is the completion length, capped by the request's
+	// max_tokens; DefaultTokens when <= 0.
+	Tokens int
+	// TokensPerSecond paces completions; unpaced when <= 0. A
+	// non-streaming answer arrives once all of it would have streamed.
+	TokensPerSecond float64
+	// FirstToken delays the first token.
+	FirstToken time.Duration
+	// ErrorRate is the fraction of requests answered with a 500.
+	ErrorRate float64
+	// Models are listed by GET /models; "synthetic" when empty. Any model
+	// is served.
+	Models []string
+}
+
+// words are what completions are made of; all plain ASCII, so they need
+// no JSON escaping.
+var words = strings.Fields(`the of and to in is that it for on with as was at by
+	from this be or are an have not but they which one you had all were
+	their there can more when been has will about would other into some
+	time could these two may then first any like over only new also after
+	such most where what through way even many before must out how model
+	token router stream`)
+
+// Transport is an http.RoundTripper that serves POST .../chat/completions
+// and GET .../models itself; any host will do.
+type Transport struct {
+	cfg Config
+}
+
+// NewTransport returns a synthetic upstream per cfg.
+func NewTransport(cfg Config) *Transport {
+	if cfg.Tokens <= 0 {
+		cfg.Tokens = DefaultTokens
+	}
+	if len(cfg.Models) == 0 {
+		cfg.Models = []string{"synthetic"}
+	}
+	return &Transport{cfg: cfg}
+}
+
+// request is what the synthetic upstream reads of a chat completion.
+type request struct {
+	Model               string          `json:"model"`
+	Messages            json.RawMessage `json:"messages"`
+	Stream              bool            `json:"stream"`
+	MaxTokens           int             `json:"max_tokens"`
+	MaxCompletionTokens int             `json:"max_completion_tokens"`
+	StreamOptions       struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/models"):
+		return t.models(req), nil
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/chat/completions"):
+	default:
+		return respond(req, http.StatusNotFound, "application/json",
+			io.NopCloser(strings.NewReader(`{"error":{"message":"not found","type":"invalid_request_error"}}`))), nil
+	}
+
+	var cr request
+	if err := json.Unmarshal(body, &cr); err != nil {
+		return respond(req, http.StatusBadRequest, "application/json",
+			io.NopCloser(strings.NewReader(`{"error":{"message":"invalid JSON body","type":"invalid_request_error"}}`))), nil
+	}
+	if t.cfg.ErrorRate > 0 && rand.Float64() < t.cfg.ErrorRate {
+		if err := sleep(req.Context(), t.cfg.FirstToken); err != nil {
+			return nil, err
+		}
+		return respond(req, http.StatusInternalServerError, "application/json",
+			io.NopCloser(strings.NewReader(`{"error":{"message":"synthetic upstream error","type":"server_error"}}`))), nil
+	}
+
+	c := t.completion(&cr)
+	if cr.Stream {
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(t.stream(req.Context(), pw, c, cr.StreamOptions.IncludeUsage)) }()
+		return respond(req, http.StatusOK, "text/event-stream", pr), nil
+	}
+
+	if err := sleep(req.Context(), t.cfg.FirstToken+t.pace(len(c.tokens)-1)); err != nil {
+		return nil, err
+	}
+	b := fmt.Appendf(nil, `{"id":"%s","object":"chat.completion","created":%d,"model":%s,"choices":[{"index":0,"message":{"role":"assistant","content":"%s"},"finish_reason":"%s"}],"usage":%s}`,
+		c.id, c.created, c.model, strings.Join(c.tokens, ""), c.finish, c.usage())
+	return respond(req, http.StatusOK, "application/json", io.NopCloser(bytes.NewReader(b))), nil
+}
+
+func (t *Transport) models(req *http.Request) *http.Response {
+	b := []byte(`{"object":"list","data":[`)
+	for i, id := range t.cfg.Models {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = fmt.Appendf(b, `{"id":%s,"object":"model","owned_by":"synthetic"}`, jsonString(id))
+	}
+	b = append(b, "]}"...)
+	return respond(req, http.StatusOK, "application/json", io.NopCloser(bytes.NewReader(b)))
+}
+
+// completion is the answer to one request.
+type completion struct {
+	id, finish string
+	model      string // JSON-quoted
+	created    int64
+	tokens     []string
+	prompt     int // tokens, estimated
+}
+
+// completion generates the answer to cr. The same model and messages
+// always get the same text.
+func (t *Transport) completion(cr *request) *completion {
+	h := fnv.New64a()
+	h.Write([]byte(cr.Model))
+	h.Write(cr.Messages)
+	seed := h.Sum64()
+	rng := rand.New(rand.NewPCG(seed, seed>>1))
+
+	n, finish := t.cfg.Tokens, "stop"
+	if limit := max(cr.MaxTokens, cr.MaxCompletionTokens); limit > 0 && limit < n {
+		n, finish = limit, "length"
+	}
+	c := &completion{
+		id:      "chatcmpl-synthetic-" + strconv.FormatUint(seed, 36),
+		model:   jsonString(cr.Model),
+		finish:  finish,
+		created: time.Now().Unix(),
+		tokens:  make([]string, n),
+		prompt:  max(1, len(cr.Messages)/4),
+	}
+	for i := range c.tokens {
+		w := words[rng.IntN(len(words))]
+		if i > 0 {
+			w = " " + w
+		}
+		c.tokens[i] = w
+	}
+	return c
+}
+
+func (c *completion) usage() string {
+	return fmt.Sprintf(`{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}`,
+		c.prompt, len(c.tokens), c.prompt+len(c.tokens))
+}
+
+// pace is how long n tokens take after the first.
+func (t *Transport) pace(n int) time.Duration {
+	if t.cfg.TokensPerSecond <= 0 || n <= 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / t.cfg.TokensPerSecond * float64(time.Second))
+}
+
+// stream writes c to w as chat completion chunks, one token each, on
+// schedule from the first.
+func (t *Transport) stream(ctx context.Context, w io.Writer, c *completion, includeUsage bool) error {
+	chunk := func(delta, finish string) error {
+		b := fmt.Appendf(nil, `data: {"id":"%s","object":"chat.completion.chunk","created":%d,"model":%s,"choices":[{"index":0,"delta":%s,"finish_reason":%s}]}`+"\n\n",
+			c.id, c.created, c.model, delta, finish)
+		_, err := w.Write(b)
+		return err
+	}
+	if err := sleep(ctx, t.cfg.FirstToken); err != nil {
+		return err
+	}
+	start := time.Now()
+	for i, tok := range c.tokens {
+		if i > 0 {
+			if err := sleep(ctx, time.Until(start.Add(t.pace(i)))); err != nil {
+				return err
+			}
+		}
+		delta := `{"content":"` + tok + `"}`
+		if i == 0 {
+			delta = `{"role":"assistant","content":"` + tok + `"}`
+		}
+		if err := chunk(delta, "null"); err != nil {
+			return err
+		}
+	}
+	if err := chunk("{}", `"`+c.finish+`"`); err != nil {
+		return err
+	}
+	if includeUsage {
+		b := fmt.Appendf(nil, `data: {"id":"%s","object":"chat.completion.chunk","created":%d,"model":%s,"choices":[],"usage":%s}`+"\n\n",
+			c.id, c.created, c.model, c.usage())
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n\n")
+	return err
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// sleep waits d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func respond(req *http.Request, status int, contentType string, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       body,
+		Request:    req,
+	}
+}
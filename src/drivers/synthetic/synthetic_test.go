@@ -0,0 +1,135 @@
+package synthetic
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/openai"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func testProvider(cfg Config) *services.ProviderService {
+	return &services.ProviderService{
+		Name:       "load",
+		ParsedURL:  url.URL{Scheme: "http", Host: "synthetic"},
+		Style:      ail.StyleChatCompletions,
+		Router:     &services.RouterService{Auth: services.NopAuthService{}},
+		Latency:    &services.LatencyTracker{},
+		HTTPClient: &http.Client{Transport: NewTransport(cfg)},
+	}
+}
+
+func testProgram(stream bool, user string) *ail.Program {
+	prog := ail.NewProgram()
+	prog.SetModel("synthetic")
+	if stream {
+		prog.Emit(ail.SET_STREAM)
+	}
+	prog.Emit(ail.MSG_START)
+	prog.Emit(ail.ROLE_USR)
+	prog.EmitString(ail.TXT_CHUNK, user)
+	prog.Emit(ail.MSG_END)
+	return prog
+}
+
+func responseText(prog *ail.Program) (text, finish string) {
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.TXT_CHUNK, ail.STREAM_DELTA:
+			text += inst.Str
+		case ail.RESP_DONE:
+			finish = inst.Str
+		}
+	}
+	return text, finish
+}
+
+func TestTransport_Deterministic(t *testing.T) {
+	d, _ := drivers.NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	p := testProvider(Config{Tokens: 12})
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	_, first, err := d.DoInference(p, testProgram(false, "hello"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, again, _ := d.DoInference(p, testProgram(false, "hello"), r)
+	_, other, _ := d.DoInference(p, testProgram(false, "goodbye"), r)
+
+	text, finish := responseText(first)
+	if n := len(strings.Fields(text)); n != 12 || finish != "stop" {
+		t.Errorf("got %d words, finish %q: %q", n, finish, text)
+	}
+	if againText, _ := responseText(again); againText != text {
+		t.Errorf("same messages, different text: %q vs %q", againText, text)
+	}
+	if otherText, _ := responseText(other); otherText == text {
+		t.Error("different messages got the same text")
+	}
+}
+
+func TestTransport_StreamPacedAndCapped(t *testing.T) {
+	d, _ := drivers.NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	p := testProvider(Config{Tokens: 50, TokensPerSecond: 200, FirstToken: 20 * time.Millisecond})
+	prog := testProgram(true, "hello")
+	prog.EmitInt(ail.SET_MAX, 10)
+
+	start := time.Now()
+	_, stream, err := d.DoInferenceStream(p, prog, httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text, finish string
+	for chunk := range stream {
+		if chunk.RuntimeError != nil {
+			t.Fatal(chunk.RuntimeError)
+		}
+		tx, f := responseText(chunk.Data)
+		text += tx
+		if f != "" {
+			finish = f
+		}
+	}
+	// The first token after 20ms, the other nine 5ms apart.
+	if elapsed := time.Since(start); elapsed < 65*time.Millisecond {
+		t.Errorf("stream took %v, want at least 65ms", elapsed)
+	}
+	if n := len(strings.Fields(text)); n != 10 || finish != "length" {
+		t.Errorf("got %d words, finish %q; want 10 capped by max_tokens", n, finish)
+	}
+
+	_, resp, err := d.DoInference(testProvider(Config{Tokens: 50}), testProgram(false, "hello"), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full, _ := responseText(resp); !strings.HasPrefix(full, text) {
+		t.Errorf("stream %q is not a prefix of the full answer %q", text, full)
+	}
+}
+
+func TestTransport_ErrorsAndModels(t *testing.T) {
+	d, _ := drivers.NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	p := testProvider(Config{ErrorRate: 1, Models: []string{"fast", "slow"}})
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	_, _, err := d.DoInference(p, testProgram(false, "hello"), r)
+	var re *services.RouterError
+	if !errors.As(err, &re) || re.Kind != services.ErrorProvider {
+		t.Errorf("expected a provider error, got %v", err)
+	}
+
+	models, err := (&openai.ListModels{}).DoListModels(p, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || models[0].ID != "fast" || models[1].ID != "slow" {
+		t.Errorf("models = %+v", models)
+	}
+}
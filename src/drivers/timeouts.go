@@ -0,0 +1,50 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// upstreamDeadlines derives the context for one upstream request from the
+// provider's timeouts. firstByte must be called when the first response
+// byte arrives; cancel releases the timers and must always be called.
+func upstreamDeadlines(p *services.ProviderService, parent context.Context) (ctx context.Context, firstByte, cancel func()) {
+	ctx, cancelCause := context.WithCancelCause(parent)
+
+	var total, first *time.Timer
+	if d := p.Timeouts.TotalTimeout(); d > 0 {
+		total = time.AfterFunc(d, func() { cancelCause(services.ErrTotalTimeout) })
+	}
+	if d := p.Timeouts.FirstByteFor(p.Latency); d > 0 {
+		first = time.AfterFunc(d, func() { cancelCause(services.ErrFirstByteTimeout) })
+	}
+
+	firstByte = func() {
+		if first != nil {
+			first.Stop()
+		}
+	}
+	cancel = func() {
+		firstByte()
+		if total != nil {
+			total.Stop()
+		}
+		cancelCause(nil)
+	}
+	return ctx, firstByte, cancel
+}
+
+// timeoutErr replaces err with the provider timeout or client deadline
+// that caused it, if any, so callers and logs see "first-byte timeout"
+// rather than "context canceled".
+func timeoutErr(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, services.ErrFirstByteTimeout) || errors.Is(cause, services.ErrTotalTimeout) ||
+		errors.Is(cause, services.ErrRequestDeadline) {
+		return cause
+	}
+	return err
+}
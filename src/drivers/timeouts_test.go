@@ -0,0 +1,110 @@
+package drivers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func testProvider(t *testing.T, h http.HandlerFunc, timeouts *services.Timeouts) *services.ProviderService {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return &services.ProviderService{
+		Name:       "p",
+		ParsedURL:  *u,
+		Style:      ail.StyleChatCompletions,
+		Router:     &services.RouterService{Auth: services.NopAuthService{}},
+		Latency:    &services.LatencyTracker{},
+		Timeouts:   timeouts,
+		HTTPClient: services.NewProviderClient(services.ClientOptions{}),
+	}
+}
+
+func testProgram(stream bool) *ail.Program {
+	prog := ail.NewProgram()
+	prog.SetModel("m")
+	if stream {
+		prog.Emit(ail.SET_STREAM)
+	}
+	return prog
+}
+
+// stall holds the request open until the client gives up. The body is
+// drained first so the server notices the client going away.
+func stall(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	select {
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestInferenceSse_FirstByteTimeout(t *testing.T) {
+	d, err := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider(t, stall, &services.Timeouts{FirstByte: 50 * time.Millisecond})
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	start := time.Now()
+	if _, _, err := d.DoInference(p, testProgram(false), r); !errors.Is(err, services.ErrFirstByteTimeout) {
+		t.Errorf("expected ErrFirstByteTimeout, got %v", err)
+	}
+	if _, _, err := d.DoInferenceStream(p, testProgram(true), r); !errors.Is(err, services.ErrFirstByteTimeout) {
+		t.Errorf("expected ErrFirstByteTimeout for a stream, got %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("timeouts should fail fast, took %v", waited)
+	}
+}
+
+func TestInferenceSse_StreamStallsAfterHeaders(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		stall(w, r)
+	}, &services.Timeouts{FirstByte: 50 * time.Millisecond})
+
+	// Headers alone do not count as the first byte of a stream.
+	_, _, err := d.DoInferenceStream(p, testProgram(true), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !errors.Is(err, services.ErrFirstByteTimeout) {
+		t.Errorf("expected ErrFirstByteTimeout, got %v", err)
+	}
+}
+
+func TestInferenceSse_TotalTimeoutEndsStream(t *testing.T) {
+	d, _ := NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	p := testProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		stall(w, r)
+	}, &services.Timeouts{FirstByte: time.Second, Total: 100 * time.Millisecond})
+
+	_, chunks, err := d.DoInferenceStream(p, testProgram(true), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil {
+		t.Fatalf("stream should start: %v", err)
+	}
+	var last InferenceStreamChunk
+	for c := range chunks {
+		last = c
+	}
+	if !errors.Is(last.RuntimeError, services.ErrTotalTimeout) {
+		t.Errorf("expected the stream to end with ErrTotalTimeout, got %v", last.RuntimeError)
+	}
+	if _, ok := p.Latency.Mean(); !ok {
+		t.Error("expected the first event to be recorded as a latency sample")
+	}
+}
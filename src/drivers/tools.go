@@ -0,0 +1,371 @@
+package drivers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// ToolChoiceEmulate is the provider tool_choice mode for upstreams that
+// cannot force tool use: "required" and named-function choices are sent
+// as "auto" and retried until the model calls a tool.
+const ToolChoiceEmulate = "emulate"
+
+// requiredToolRetries is how many times an emulated forced choice is
+// retried before the answer without a tool call is returned as is.
+const requiredToolRetries = 2
+
+// requiredToolNudge is the system prompt added to retried requests.
+const requiredToolNudge = "You must respond by calling one of the available tools. Do not answer in plain text."
+
+// Tool choice modes, whatever the client style called them.
+const (
+	toolChoiceAuto     = "auto"
+	toolChoiceNone     = "none"
+	toolChoiceRequired = "required"
+	toolChoiceFunction = "function"
+)
+
+// toolChoiceKeys are the top-level fields the parsers pass through as
+// EXT_DATA that say how tools may be used, in any client style.
+var toolChoiceKeys = []string{"tool_choice", "parallel_tool_calls", "tool_config", "toolConfig"}
+
+// toolChoice is a client's tool_choice and parallel_tool_calls, in any of
+// the API shapes:
+//
+//   - chat-completions: "auto" | "none" | "required" or
+//     {"type":"function","function":{"name":...}}, plus parallel_tool_calls.
+//   - openai-responses: the same, with the name at the top level.
+//   - anthropic-messages: {"type":"auto"|"any"|"tool"|"none","name":...,
+//     "disable_parallel_tool_use":...}.
+//   - google-genai: tool_config.function_calling_config with mode AUTO,
+//     ANY or NONE and allowed_function_names.
+type toolChoice struct {
+	Mode     string // auto, none, required or function; "" when unset
+	Name     string // the function, for mode function
+	Parallel *bool
+}
+
+// parseToolChoice reads the tool choice from prog's EXT_DATA and returns
+// the indices it came from.
+func parseToolChoice(prog *ail.Program) (toolChoice, []int) {
+	var tc toolChoice
+	var at []int
+	for i, inst := range prog.Code {
+		if inst.Op != ail.EXT_DATA {
+			continue
+		}
+		switch inst.Key {
+		case "tool_choice":
+			tc.parseChoice(inst.JSON)
+		case "parallel_tool_calls":
+			var b bool
+			if json.Unmarshal(inst.JSON, &b) == nil {
+				tc.Parallel = &b
+			}
+		case "tool_config", "toolConfig":
+			tc.parseGoogle(inst.JSON)
+		default:
+			continue
+		}
+		at = append(at, i)
+	}
+	return tc, at
+}
+
+func (tc *toolChoice) parseChoice(raw json.RawMessage) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		tc.Mode = choiceMode(s)
+		return
+	}
+	var v struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+		DisableParallel *bool `json:"disable_parallel_tool_use"`
+	}
+	if json.Unmarshal(raw, &v) != nil {
+		return
+	}
+	tc.Mode = choiceMode(v.Type)
+	if name := v.Name + v.Function.Name; name != "" && (v.Type == "function" || v.Type == "tool") {
+		tc.Mode, tc.Name = toolChoiceFunction, name
+	}
+	if v.DisableParallel != nil {
+		parallel := !*v.DisableParallel
+		tc.Parallel = &parallel
+	}
+}
+
+func (tc *toolChoice) parseGoogle(raw json.RawMessage) {
+	type callingConfig struct {
+		Mode          string   `json:"mode"`
+		AllowedNames  []string `json:"allowed_function_names"`
+		AllowedNames2 []string `json:"allowedFunctionNames"`
+	}
+	var v struct {
+		Config  *callingConfig `json:"function_calling_config"`
+		Config2 *callingConfig `json:"functionCallingConfig"`
+	}
+	if json.Unmarshal(raw, &v) != nil {
+		return
+	}
+	cfg := v.Config
+	if cfg == nil {
+		cfg = v.Config2
+	}
+	if cfg == nil {
+		return
+	}
+	tc.Mode = choiceMode(cfg.Mode)
+	if names := append(cfg.AllowedNames, cfg.AllowedNames2...); tc.Mode == toolChoiceRequired && len(names) == 1 {
+		tc.Mode, tc.Name = toolChoiceFunction, names[0]
+	}
+}
+
+// choiceMode maps a style's mode name onto the OpenAI one.
+func choiceMode(s string) string {
+	switch strings.ToLower(s) {
+	case "auto", "validated":
+		return toolChoiceAuto
+	case "none":
+		return toolChoiceNone
+	case "required", "any":
+		return toolChoiceRequired
+	}
+	return ""
+}
+
+// forced reports whether the choice makes the model call a tool.
+func (tc toolChoice) forced() bool {
+	return tc.Mode == toolChoiceRequired || tc.Mode == toolChoiceFunction
+}
+
+// normalizeToolChoice rewrites prog's tool choice for the upstream style,
+// so a client of any API can target any provider. parallel_tool_calls
+// becomes Anthropic's disable_parallel_tool_use; Gemini has no equivalent
+// and loses it. Requests without tools lose their tool choice, which the
+// APIs reject on its own. Other styles are left unchanged.
+func normalizeToolChoice(style ail.Style, prog *ail.Program) *ail.Program {
+	tc, at := parseToolChoice(prog)
+	if len(at) == 0 {
+		return prog
+	}
+	switch style {
+	case ail.StyleChatCompletions, ail.StyleResponses, ail.StyleAnthropic, ail.StyleGoogleGenAI:
+	default:
+		return prog
+	}
+	prog = prog.ClearAtIndex(at...)
+	if !prog.HasOpcode(ail.DEF_START) {
+		return prog
+	}
+
+	switch style {
+	case ail.StyleChatCompletions, ail.StyleResponses:
+		if tc.Mode == toolChoiceFunction {
+			fn := map[string]any{"type": "function", "name": tc.Name}
+			if style == ail.StyleChatCompletions {
+				fn = map[string]any{"type": "function", "function": map[string]any{"name": tc.Name}}
+			}
+			prog = setExtValue(prog, "tool_choice", fn)
+		} else if tc.Mode != "" {
+			prog = setExtValue(prog, "tool_choice", tc.Mode)
+		}
+		if tc.Parallel != nil {
+			prog = setExtValue(prog, "parallel_tool_calls", *tc.Parallel)
+		}
+	case ail.StyleAnthropic:
+		choice := map[string]any{"type": "auto"}
+		switch tc.Mode {
+		case toolChoiceNone:
+			choice["type"] = "none"
+		case toolChoiceRequired:
+			choice["type"] = "any"
+		case toolChoiceFunction:
+			choice["type"], choice["name"] = "tool", tc.Name
+		case "":
+			if tc.Parallel == nil {
+				return prog
+			}
+		}
+		if tc.Parallel != nil && !*tc.Parallel && tc.Mode != toolChoiceNone {
+			choice["disable_parallel_tool_use"] = true
+		}
+		prog = setExtValue(prog, "tool_choice", choice)
+	case ail.StyleGoogleGenAI:
+		cfg := map[string]any{}
+		switch tc.Mode {
+		case toolChoiceAuto:
+			cfg["mode"] = "AUTO"
+		case toolChoiceNone:
+			cfg["mode"] = "NONE"
+		case toolChoiceRequired:
+			cfg["mode"] = "ANY"
+		case toolChoiceFunction:
+			cfg["mode"], cfg["allowed_function_names"] = "ANY", []string{tc.Name}
+		default:
+			return prog
+		}
+		prog = setExtValue(prog, "tool_config", map[string]any{"function_calling_config": cfg})
+	}
+	return prog
+}
+
+func setExtValue(prog *ail.Program, key string, v any) *ail.Program {
+	j, _ := json.Marshal(v)
+	return setExt(prog, key, j)
+}
+
+// WithToolChoice emulates forced tool use for upstreams that lack it:
+// providers in tool_choice emulate mode, and Anthropic with thinking
+// enabled, which rejects forced tool use. The request goes out with
+// tool_choice "auto" (a named function is the only tool offered) and the
+// returned command retries, with a system prompt insisting on a tool
+// call, while the model answers in text. Other requests are unchanged.
+func WithToolChoice(p *services.ProviderService, prog *ail.Program, cmd InferenceCommand) (*ail.Program, InferenceCommand) {
+	tc, at := parseToolChoice(prog)
+	if !tc.forced() || !prog.HasOpcode(ail.DEF_START) {
+		return prog, cmd
+	}
+	if p.ToolChoice != ToolChoiceEmulate && !(p.Style == ail.StyleAnthropic && reasoningEnabled(prog)) {
+		return prog, cmd
+	}
+	prog = prog.ClearAtIndex(at...)
+	if tc.Mode == toolChoiceFunction {
+		prog = onlyTool(prog, tc.Name)
+	}
+	prog = setExtValue(prog, "tool_choice", toolChoiceAuto)
+	if tc.Parallel != nil {
+		prog = setExtValue(prog, "parallel_tool_calls", *tc.Parallel)
+	}
+	return prog, &requiredToolCommand{inner: cmd, retries: requiredToolRetries}
+}
+
+// reasoningEnabled reports whether prog asks for reasoning, in any style.
+func reasoningEnabled(prog *ail.Program) bool {
+	for _, inst := range prog.Code {
+		if inst.Op == ail.SET_THINK {
+			c, ok := parseReasoning(inst.JSON)
+			return ok && !c.Disabled
+		}
+	}
+	return false
+}
+
+// onlyTool drops the tool definitions other than name. The parsers put
+// all tools in one DEF block, each starting at its DEF_NAME.
+func onlyTool(prog *ail.Program, name string) *ail.Program {
+	var drop []int
+	keep := true
+	for i, inst := range prog.Code {
+		switch inst.Op {
+		case ail.DEF_START, ail.DEF_END:
+			keep = true
+			continue
+		case ail.DEF_NAME:
+			keep = inst.Str == name
+		}
+		if !keep {
+			drop = append(drop, i)
+		}
+	}
+	if len(drop) == 0 {
+		return prog
+	}
+	return prog.ClearAtIndex(drop...)
+}
+
+// requiredToolCommand retries a request until the response calls a tool.
+type requiredToolCommand struct {
+	inner   InferenceCommand
+	retries int
+}
+
+// calledTool reports whether a response or stream chunk holds a tool call.
+func calledTool(prog *ail.Program) bool {
+	return prog != nil && (prog.HasOpcode(ail.CALL_START) || prog.HasOpcode(ail.STREAM_TOOL_DELTA))
+}
+
+func (c *requiredToolCommand) DoInference(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, *ail.Program, error) {
+	res, out, err := c.inner.DoInference(p, prog, r)
+	for attempt := 1; attempt <= c.retries && err == nil && !calledTool(out); attempt++ {
+		Logger.Debug("response did not call a required tool, retrying",
+			zap.String("provider", p.Name), zap.Int("attempt", attempt))
+		res, out, err = c.inner.DoInference(p, prog.PrependSystemPrompt(requiredToolNudge), r)
+	}
+	return res, out, err
+}
+
+// DryRun reports the first attempt's request.
+func (c *requiredToolCommand) DryRun(p *services.ProviderService, prog *ail.Program) (*UpstreamRequest, error) {
+	return DryRun(c.inner, p, prog)
+}
+
+// DoInferenceStream holds each attempt's chunks back until one calls a
+// tool (or fails), then streams the rest; an attempt that ends without a
+// call is dropped and retried. The last attempt is streamed as it comes.
+func (c *requiredToolCommand) DoInferenceStream(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, chan InferenceStreamChunk, error) {
+	res, stream, err := c.inner.DoInferenceStream(p, prog, r)
+	if err != nil {
+		return res, nil, err
+	}
+	out := make(chan InferenceStreamChunk)
+	go func() {
+		defer close(out)
+		send := func(chunk InferenceStreamChunk) bool {
+			select {
+			case out <- chunk:
+				return true
+			case <-r.Context().Done():
+				return false
+			}
+		}
+		for attempt := 0; ; attempt++ {
+			forward := attempt == c.retries
+			var held []InferenceStreamChunk
+			for chunk := range stream {
+				if !forward && (chunk.RuntimeError != nil || calledTool(chunk.Data)) {
+					forward = true
+					for _, h := range held {
+						if !send(h) {
+							drainStream(stream)
+							return
+						}
+					}
+				}
+				if !forward {
+					held = append(held, chunk)
+					continue
+				}
+				if !send(chunk) {
+					drainStream(stream)
+					return
+				}
+			}
+			if forward {
+				return
+			}
+			Logger.Debug("stream did not call a required tool, retrying",
+				zap.String("provider", p.Name), zap.Int("attempt", attempt+1))
+			_, stream, err = c.inner.DoInferenceStream(p, prog.PrependSystemPrompt(requiredToolNudge), r)
+			if err != nil {
+				send(InferenceStreamChunk{RuntimeError: err})
+				return
+			}
+		}
+	}()
+	return res, out, nil
+}
+
+var (
+	_ InferenceCommand = (*requiredToolCommand)(nil)
+	_ DryRunCommand    = (*requiredToolCommand)(nil)
+)
@@ -0,0 +1,251 @@
+package drivers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+const toolsChat = `{"model": "m", "messages": [{"role": "user", "content": "Weather in Oslo?"}],
+	"tools": [{"type": "function", "function": {"name": "weather", "parameters": {"type": "object"}}},
+		{"type": "function", "function": {"name": "time", "parameters": {"type": "object"}}}]`
+
+// toolChoiceBody parses a client request, normalizes it for the upstream
+// style and returns the emitted body.
+func toolChoiceBody(t *testing.T, client styles.Style, body string, upstream ail.Style) map[string]any {
+	t.Helper()
+	parser, err := styles.GetParser(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	em, err := ail.GetEmitter(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := em.EmitRequest(normalizeToolChoice(upstream, prog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func asJSON(v any) string {
+	j, _ := json.Marshal(v)
+	return string(j)
+}
+
+func TestNormalizeToolChoice(t *testing.T) {
+	cases := []struct {
+		name     string
+		client   styles.Style
+		body     string
+		upstream ail.Style
+		want     map[string]string // body key → JSON
+	}{
+		{"chat required to anthropic", styles.StyleChatCompletions,
+			toolsChat + `, "tool_choice": "required", "parallel_tool_calls": false}`, ail.StyleAnthropic,
+			map[string]string{"tool_choice": `{"disable_parallel_tool_use":true,"type":"any"}`, "parallel_tool_calls": ``}},
+		{"chat function to gemini", styles.StyleChatCompletions,
+			toolsChat + `, "tool_choice": {"type": "function", "function": {"name": "weather"}}, "parallel_tool_calls": true}`, ail.StyleGoogleGenAI,
+			map[string]string{"tool_config": `{"function_calling_config":{"allowed_function_names":["weather"],"mode":"ANY"}}`, "tool_choice": ``, "parallel_tool_calls": ``}},
+		{"chat function to responses", styles.StyleChatCompletions,
+			toolsChat + `, "tool_choice": {"type": "function", "function": {"name": "weather"}}}`, ail.StyleResponses,
+			map[string]string{"tool_choice": `{"name":"weather","type":"function"}`}},
+		{"chat none to anthropic", styles.StyleChatCompletions,
+			toolsChat + `, "tool_choice": "none"}`, ail.StyleAnthropic,
+			map[string]string{"tool_choice": `{"type":"none"}`}},
+		{"anthropic tool to chat", styles.StyleAnthropic,
+			`{"model": "m", "max_tokens": 10, "messages": [{"role": "user", "content": "hi"}],
+			"tools": [{"name": "weather", "input_schema": {"type": "object"}}],
+			"tool_choice": {"type": "tool", "name": "weather", "disable_parallel_tool_use": true}}`, ail.StyleChatCompletions,
+			map[string]string{"tool_choice": `{"function":{"name":"weather"},"type":"function"}`, "parallel_tool_calls": `false`}},
+		{"anthropic any to chat", styles.StyleAnthropic,
+			`{"model": "m", "max_tokens": 10, "messages": [{"role": "user", "content": "hi"}],
+			"tools": [{"name": "weather", "input_schema": {"type": "object"}}], "tool_choice": {"type": "any"}}`, ail.StyleChatCompletions,
+			map[string]string{"tool_choice": `"required"`}},
+		{"gemini any to chat", styles.StyleGoogleGenAI,
+			`{"contents": [{"role": "user", "parts": [{"text": "hi"}]}],
+			"tools": [{"functionDeclarations": [{"name": "weather", "parameters": {"type": "object"}}]}],
+			"toolConfig": {"functionCallingConfig": {"mode": "ANY"}}}`, ail.StyleChatCompletions,
+			map[string]string{"tool_choice": `"required"`, "toolConfig": ``}},
+		{"no tools", styles.StyleChatCompletions,
+			`{"model": "m", "messages": [{"role": "user", "content": "hi"}], "tool_choice": "auto", "parallel_tool_calls": false}`, ail.StyleChatCompletions,
+			map[string]string{"tool_choice": ``, "parallel_tool_calls": ``}},
+	}
+	for _, tc := range cases {
+		body := toolChoiceBody(t, tc.client, tc.body, tc.upstream)
+		for key, want := range tc.want {
+			got := ""
+			if v, ok := body[key]; ok {
+				got = asJSON(v)
+			}
+			if got != want {
+				t.Errorf("%s: %s = %s, want %s", tc.name, key, got, want)
+			}
+		}
+	}
+}
+
+// fakeTools answers in text until its call'th request, then with a call.
+type fakeTools struct {
+	callOn int32
+	calls  atomic.Int32
+	progs  []*ail.Program
+}
+
+func (f *fakeTools) called() bool { return f.calls.Add(1) >= f.callOn }
+
+func (f *fakeTools) DoInference(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, *ail.Program, error) {
+	f.progs = append(f.progs, prog)
+	out := ail.NewProgram()
+	out.Emit(ail.MSG_START)
+	out.Emit(ail.ROLE_AST)
+	if f.called() {
+		out.EmitString(ail.CALL_START, "call_1")
+		out.EmitString(ail.CALL_NAME, "weather")
+		out.EmitJSON(ail.CALL_ARGS, json.RawMessage(`{}`))
+		out.Emit(ail.CALL_END)
+	} else {
+		out.EmitString(ail.TXT_CHUNK, "It is sunny.")
+	}
+	out.Emit(ail.MSG_END)
+	return &http.Response{}, out, nil
+}
+
+func (f *fakeTools) DoInferenceStream(p *services.ProviderService, prog *ail.Program, r *http.Request) (*http.Response, chan InferenceStreamChunk, error) {
+	f.progs = append(f.progs, prog)
+	ch := make(chan InferenceStreamChunk, 3)
+	start := ail.NewProgram()
+	start.Emit(ail.STREAM_START)
+	ch <- InferenceStreamChunk{Data: start}
+	delta := ail.NewProgram()
+	if f.called() {
+		delta.EmitJSON(ail.STREAM_TOOL_DELTA, json.RawMessage(`{"index":0,"name":"weather","arguments":"{}"}`))
+	} else {
+		delta.EmitString(ail.STREAM_DELTA, "It is sunny.")
+	}
+	ch <- InferenceStreamChunk{Data: delta}
+	end := ail.NewProgram()
+	end.Emit(ail.STREAM_END)
+	ch <- InferenceStreamChunk{Data: end}
+	close(ch)
+	return &http.Response{}, ch, nil
+}
+
+func forcedProgram(t *testing.T, choice string) *ail.Program {
+	t.Helper()
+	parser, _ := styles.GetParser(styles.StyleChatCompletions)
+	prog, err := parser.ParseRequest([]byte(toolsChat + `, "tool_choice": ` + choice + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestWithToolChoice(t *testing.T) {
+	native := &services.ProviderService{Name: "p", Style: ail.StyleChatCompletions}
+	emulate := &services.ProviderService{Name: "p", Style: ail.StyleChatCompletions, ToolChoice: ToolChoiceEmulate}
+	fake := &fakeTools{}
+
+	if _, cmd := WithToolChoice(native, forcedProgram(t, `"required"`), fake); cmd != fake {
+		t.Error("native provider should take required as is")
+	}
+	if _, cmd := WithToolChoice(emulate, forcedProgram(t, `"auto"`), fake); cmd != fake {
+		t.Error("auto should not be emulated")
+	}
+
+	prog, cmd := WithToolChoice(emulate, forcedProgram(t, `{"type": "function", "function": {"name": "time"}}`), fake)
+	if cmd == fake {
+		t.Fatal("forced choice should be emulated")
+	}
+	tc, _ := parseToolChoice(prog)
+	if tc.Mode != toolChoiceAuto {
+		t.Errorf("tool_choice = %+v, want auto", tc)
+	}
+	var names []string
+	for _, def := range prog.ToolDefs() {
+		for _, inst := range prog.Code[def.Start:def.End] {
+			if inst.Op == ail.DEF_NAME {
+				names = append(names, inst.Str)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"time"}) {
+		t.Errorf("tools = %v, want only the named one", names)
+	}
+
+	// Anthropic rejects forced tool use with thinking on.
+	anthropic := &services.ProviderService{Name: "p", Style: ail.StyleAnthropic}
+	thinking := forcedProgram(t, `"required"`)
+	thinking.EmitJSON(ail.SET_THINK, json.RawMessage(`{"effort":"high"}`))
+	if _, cmd := WithToolChoice(anthropic, thinking, fake); cmd == fake {
+		t.Error("anthropic with thinking should be emulated")
+	}
+}
+
+func TestRequiredToolCommand(t *testing.T) {
+	p := &services.ProviderService{Name: "p", ToolChoice: ToolChoiceEmulate}
+	r := httptest.NewRequest("POST", "/", nil)
+
+	fake := &fakeTools{callOn: 2}
+	prog, cmd := WithToolChoice(p, forcedProgram(t, `"required"`), fake)
+	_, out, err := cmd.DoInference(p, prog, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !calledTool(out) || fake.calls.Load() != 2 {
+		t.Errorf("calls = %d, tool called = %v", fake.calls.Load(), calledTool(out))
+	}
+	if fake.progs[1].SystemPrompt() != requiredToolNudge {
+		t.Errorf("retry system prompt = %q", fake.progs[1].SystemPrompt())
+	}
+
+	// Never calling: the last text answer is returned.
+	fake = &fakeTools{callOn: 100}
+	_, cmd = WithToolChoice(p, forcedProgram(t, `"required"`), fake)
+	_, out, _ = cmd.DoInference(p, prog, r)
+	if calledTool(out) || fake.calls.Load() != 1+requiredToolRetries {
+		t.Errorf("calls = %d", fake.calls.Load())
+	}
+}
+
+func TestRequiredToolCommand_Stream(t *testing.T) {
+	p := &services.ProviderService{Name: "p", ToolChoice: ToolChoiceEmulate}
+	r := httptest.NewRequest("POST", "/", nil)
+
+	collect := func(callOn int32) (deltas, tools int, calls int32) {
+		fake := &fakeTools{callOn: callOn}
+		prog, cmd := WithToolChoice(p, forcedProgram(t, `"required"`), fake)
+		_, stream, err := cmd.DoInferenceStream(p, prog, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for chunk := range stream {
+			deltas += len(chunk.Data.FindAll(ail.STREAM_DELTA))
+			tools += len(chunk.Data.FindAll(ail.STREAM_TOOL_DELTA))
+		}
+		return deltas, tools, fake.calls.Load()
+	}
+
+	if deltas, tools, calls := collect(3); deltas != 0 || tools != 1 || calls != 3 {
+		t.Errorf("text deltas = %d, tool deltas = %d, calls = %d", deltas, tools, calls)
+	}
+	if deltas, tools, calls := collect(100); deltas != 1 || tools != 0 || calls != 3 {
+		t.Errorf("last attempt: text deltas = %d, tool deltas = %d, calls = %d", deltas, tools, calls)
+	}
+}
@@ -0,0 +1,81 @@
+package virtual
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Params are generation parameters a virtual model sets on every request
+// it resolves, so an alias like "support-bot" can be a complete preset
+// rather than just a model name. Set values override the client's; the
+// system prompt goes before the client's own system messages.
+type Params struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       *int     `json:"max_tokens,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // none|minimal|low|medium|high
+	SystemPrompt    string   `json:"system_prompt,omitempty"`
+}
+
+// Validate checks the values of p that the Caddyfile parser would.
+func (p *Params) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.ReasoningEffort {
+	case "", "none", "minimal", "low", "medium", "high":
+	default:
+		return fmt.Errorf("invalid reasoning_effort %q", p.ReasoningEffort)
+	}
+	if p.Temperature != nil && *p.Temperature < 0 {
+		return fmt.Errorf("invalid temperature %g", *p.Temperature)
+	}
+	if p.TopP != nil && *p.TopP < 0 {
+		return fmt.Errorf("invalid top_p %g", *p.TopP)
+	}
+	if p.MaxTokens != nil && *p.MaxTokens <= 0 {
+		return fmt.Errorf("invalid max_tokens %d", *p.MaxTokens)
+	}
+	return nil
+}
+
+// Apply sets p on prog in place, like Program.SetModel.
+func (p *Params) Apply(prog *ail.Program) {
+	if p == nil {
+		return
+	}
+	if p.Temperature != nil {
+		setConfig(prog, ail.Instruction{Op: ail.SET_TEMP, Num: *p.Temperature})
+	}
+	if p.TopP != nil {
+		setConfig(prog, ail.Instruction{Op: ail.SET_TOPP, Num: *p.TopP})
+	}
+	if p.MaxTokens != nil {
+		setConfig(prog, ail.Instruction{Op: ail.SET_MAX, Int: int32(*p.MaxTokens)})
+	}
+	if p.ReasoningEffort != "" {
+		cfg, _ := json.Marshal(map[string]string{"effort": p.ReasoningEffort})
+		setConfig(prog, ail.Instruction{Op: ail.SET_THINK, JSON: cfg})
+	}
+	if p.SystemPrompt != "" {
+		prog.Code = prog.PrependSystemPrompt(p.SystemPrompt).Code
+	}
+}
+
+// setConfig replaces the program's config instruction of inst's opcode,
+// or adds inst after SET_MODEL.
+func setConfig(prog *ail.Program, inst ail.Instruction) {
+	for i := range prog.Code {
+		if prog.Code[i].Op == inst.Op {
+			prog.Code[i] = inst
+			return
+		}
+	}
+	at := 0
+	if len(prog.Code) > 0 && prog.Code[0].Op == ail.SET_MODEL {
+		at = 1
+	}
+	prog.Code = append(prog.Code[:at], append([]ail.Instruction{inst}, prog.Code[at:]...)...)
+}
@@ -0,0 +1,205 @@
+package virtual
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target is one target of a virtual mapping.
+type Target struct {
+	Model  string
+	Weight int
+	When   *Condition // nil: always eligible
+}
+
+// ParseTargets parses a mapping's target spec: a single target model, an
+// ordered list of targets of which the first eligible one is used, or
+// whitespace-separated pairs of target and weight that split the model's
+// traffic between the eligible ones, as for a gradual migration:
+//
+//	openai/gpt-4.1
+//	openai/gpt-4.1?healthy anthropic/claude-sonnet
+//	openai/gpt-4.1 70 anthropic/claude-sonnet 30
+//
+// A target is eligible when the conditions following its "?" hold (see
+// ParseCondition). A weight of 0 keeps a target listed but sends it
+// nothing; at least one weight must be positive.
+func ParseTargets(spec string) (targets []Target, weighted bool, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, false, fmt.Errorf("targets %q: expected <target> or <target> <weight> pairs", spec)
+	}
+	_, err = strconv.Atoi(fields[len(fields)-1])
+	weighted = len(fields) > 1 && err == nil
+	total := 0
+	for i := 0; i < len(fields); i++ {
+		var t Target
+		model, cond, hasCond := strings.Cut(fields[i], "?")
+		if _, err := strconv.Atoi(model); err == nil || model == "" {
+			return nil, false, fmt.Errorf("targets %q: expected a target, got %q", spec, fields[i])
+		}
+		t.Model, t.Weight = model, 1
+		if hasCond {
+			if t.When, err = ParseCondition(cond); err != nil {
+				return nil, false, fmt.Errorf("targets %q: %s: %v", spec, model, err)
+			}
+		}
+		if weighted {
+			i++
+			if i == len(fields) {
+				return nil, false, fmt.Errorf("targets %q: missing weight for %s", spec, model)
+			}
+			if t.Weight, err = strconv.Atoi(fields[i]); err != nil || t.Weight < 0 {
+				return nil, false, fmt.Errorf("targets %q: invalid weight %q for %s", spec, fields[i], model)
+			}
+		}
+		targets = append(targets, t)
+		total += t.Weight
+	}
+	if total == 0 {
+		return nil, false, fmt.Errorf("targets %q: all weights are zero", spec)
+	}
+	return targets, weighted, nil
+}
+
+// Condition restricts a target to a schedule or to its provider's health.
+// It is written as comma-separated terms, all of which must hold:
+//
+//	days=<day>[-<day>]          mon..sun; a range may wrap, e.g. fri-mon
+//	hours=<HH:MM>-<HH:MM>       start inclusive, end exclusive; may wrap midnight
+//	tz=<IANA zone>              zone of days and hours, default the server's
+//	healthy[=<provider>]        the provider (default the target's) is healthy
+//
+// e.g. openai/gpt-4.1?days=mon-fri,hours=09:00-18:00,tz=Europe/Berlin.
+type Condition struct {
+	Days     [7]bool // indexed by time.Weekday; all false = every day
+	Hours    bool
+	From, To int // minutes since midnight, when Hours
+	Location *time.Location
+	Healthy  bool
+	Provider string // for Healthy; "" = the target's provider
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseCondition parses the conditions of a target.
+func ParseCondition(s string) (*Condition, error) {
+	c := &Condition{}
+	for _, term := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(term), "=")
+		switch key {
+		case "days":
+			first, last, isRange := strings.Cut(strings.ToLower(value), "-")
+			if !isRange {
+				last = first
+			}
+			from, ok1 := weekdays[first]
+			to, ok2 := weekdays[last]
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("invalid days %q", value)
+			}
+			for d := from; ; d = (d + 1) % 7 {
+				c.Days[d] = true
+				if d == to {
+					break
+				}
+			}
+		case "hours":
+			start, end, _ := strings.Cut(value, "-")
+			from, err1 := parseClock(start)
+			to, err2 := parseClock(end)
+			if err1 != nil || err2 != nil || from == to {
+				return nil, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", value)
+			}
+			c.Hours, c.From, c.To = true, from, to
+		case "tz":
+			loc, err := time.LoadLocation(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tz %q: %v", value, err)
+			}
+			c.Location = loc
+		case "healthy":
+			c.Healthy, c.Provider = true, strings.ToLower(value)
+		default:
+			return nil, fmt.Errorf("unknown condition %q (want days, hours, tz or healthy)", term)
+		}
+	}
+	return c, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Holds reports whether the condition holds for target model at now;
+// healthy reports a provider's health.
+func (c *Condition) Holds(model string, now time.Time, healthy func(provider string) bool) bool {
+	if c == nil {
+		return true
+	}
+	if c.Location != nil {
+		now = now.In(c.Location)
+	}
+	if c.Days != [7]bool{} && !c.Days[now.Weekday()] {
+		return false
+	}
+	if c.Hours {
+		min := now.Hour()*60 + now.Minute()
+		in := min >= c.From && min < c.To
+		if c.From > c.To {
+			in = min >= c.From || min < c.To
+		}
+		if !in {
+			return false
+		}
+	}
+	if c.Healthy && healthy != nil {
+		provider := c.Provider
+		if provider == "" {
+			provider, _, _ = strings.Cut(strings.ToLower(model), "/")
+		}
+		if !healthy(provider) {
+			return false
+		}
+	}
+	return true
+}
+
+// PickTarget chooses a target model in proportion to the weights. A
+// non-empty key always picks the same target for the same weights; an
+// empty key picks at random.
+func PickTarget(targets []Target, key string) string {
+	if len(targets) == 1 {
+		return targets[0].Model
+	}
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	var n int
+	if key == "" {
+		n = rand.IntN(total)
+	} else {
+		sum := sha256.Sum256([]byte(key))
+		n = int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	}
+	for _, t := range targets {
+		if n < t.Weight {
+			return t.Model
+		}
+		n -= t.Weight
+	}
+	return targets[len(targets)-1].Model
+}
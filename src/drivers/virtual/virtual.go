@@ -6,7 +6,10 @@ package virtual
 import (
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/neutrome-labs/ail"
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
 	"github.com/neutrome-labs/open-ai-router/src/services"
 	"go.uber.org/zap"
@@ -21,8 +24,35 @@ var Logger *zap.Logger = zap.NewNop()
 type VirtualPlugin struct {
 	// ProviderName is the name of this virtual provider
 	ProviderName string
-	// ModelMappings maps virtual model names to target model specs (e.g., "provider/model+plugins")
+	// ModelMappings maps virtual model names to target model specs (e.g., "provider/model+plugins"),
+	// or to weighted targets (see ParseTargets).
+	// Set it before use; replace it later with SetMappings.
 	ModelMappings map[string]string
+	// Sticky keeps a conversation on the same weighted target instead of
+	// picking one at random for every request.
+	Sticky bool
+	// Healthy reports whether a provider is healthy, for targets with a
+	// healthy condition; nil treats every provider as healthy.
+	Healthy func(provider string) bool
+	// Params maps virtual model names to the generation parameters they set.
+	Params map[string]*Params
+
+	mu  sync.RWMutex
+	now func() time.Time // for tests; nil = time.Now
+}
+
+// Mappings returns the current model mappings; callers must not modify them.
+func (v *VirtualPlugin) Mappings() map[string]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.ModelMappings
+}
+
+// SetMappings replaces the model mappings, e.g. on a policy reload.
+func (v *VirtualPlugin) SetMappings(mappings map[string]string) {
+	v.mu.Lock()
+	v.ModelMappings = mappings
+	v.mu.Unlock()
 }
 
 // Name returns the plugin name
@@ -33,28 +63,34 @@ func (v *VirtualPlugin) Name() string {
 // RewriteModel checks whether the model targets this virtual provider and,
 // if so, returns the mapped real model (preserving any user plugin suffixes).
 func (v *VirtualPlugin) RewriteModel(model string) (string, bool) {
-	// Expect "virtualProvider/modelName" or "virtualProvider/modelName+plugins"
-	idx := strings.Index(model, "/")
-	if idx < 0 {
+	return v.RewriteModelKeyed(model, "")
+}
+
+// RewriteModelKeyed is RewriteModel for a request of the conversation key;
+// a sticky plugin picks a weighted target by key rather than at random.
+func (v *VirtualPlugin) RewriteModelKeyed(model, key string) (string, bool) {
+	baseModel, pluginSuffix, ok := v.split(model)
+	if !ok {
 		return model, false
 	}
-	providerPrefix := strings.ToLower(model[:idx])
-	actualModel := model[idx+1:]
 
-	if providerPrefix != v.ProviderName {
+	spec, ok := v.Mappings()[baseModel]
+	if !ok || spec == "" {
 		return model, false
 	}
-
-	// Split base model from user plugin suffix
-	baseModel := actualModel
-	pluginSuffix := ""
-	if plusIdx := strings.IndexByte(actualModel, '+'); plusIdx >= 0 {
-		baseModel = actualModel[:plusIdx]
-		pluginSuffix = actualModel[plusIdx:] // includes leading '+'
+	targets, weighted, err := ParseTargets(spec)
+	if err != nil {
+		Logger.Error("VirtualPlugin mapping is invalid",
+			zap.String("provider", v.ProviderName),
+			zap.String("model", baseModel),
+			zap.Error(err))
+		return model, false
 	}
-
-	targetModel, ok := v.ModelMappings[baseModel]
-	if !ok || targetModel == "" {
+	targetModel, ok := v.pick(targets, weighted, key)
+	if !ok {
+		Logger.Warn("VirtualPlugin mapping has no eligible target",
+			zap.String("provider", v.ProviderName),
+			zap.String("model", baseModel))
 		return model, false
 	}
 
@@ -69,10 +105,58 @@ func (v *VirtualPlugin) RewriteModel(model string) (string, bool) {
 	return finalModel, true
 }
 
+// ApplyParams sets the generation parameters of the virtual model that
+// model (as requested, before rewriting) names on prog.
+func (v *VirtualPlugin) ApplyParams(model string, prog *ail.Program) {
+	if baseModel, _, ok := v.split(model); ok {
+		v.Params[baseModel].Apply(prog)
+	}
+}
+
+// split splits "virtualProvider/modelName+plugins" into the model name and
+// the plugin suffix (with its leading '+'), if it names this provider.
+func (v *VirtualPlugin) split(model string) (baseModel, pluginSuffix string, ok bool) {
+	providerPrefix, actualModel, found := strings.Cut(model, "/")
+	if !found || strings.ToLower(providerPrefix) != v.ProviderName {
+		return "", "", false
+	}
+	baseModel = actualModel
+	if plusIdx := strings.IndexByte(actualModel, '+'); plusIdx >= 0 {
+		baseModel = actualModel[:plusIdx]
+		pluginSuffix = actualModel[plusIdx:]
+	}
+	return baseModel, pluginSuffix, true
+}
+
+// pick chooses among the targets whose conditions hold: the first of an
+// ordered list, or by weight.
+func (v *VirtualPlugin) pick(targets []Target, weighted bool, key string) (string, bool) {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	eligible := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		if t.Weight > 0 && t.When.Holds(t.Model, now, v.Healthy) {
+			if !weighted {
+				return t.Model, true
+			}
+			eligible = append(eligible, t)
+		}
+	}
+	if len(eligible) == 0 {
+		return "", false
+	}
+	if !v.Sticky {
+		key = ""
+	}
+	return PickTarget(eligible, key), true
+}
+
 // VirtualListModels implements ListModelsCommand for virtual providers.
 type VirtualListModels struct {
-	ProviderName  string
-	ModelMappings map[string]string
+	ProviderName string
+	Plugin       *VirtualPlugin
 }
 
 // DoListModels returns the list of virtual models.
@@ -80,7 +164,7 @@ func (v *VirtualListModels) DoListModels(p *services.ProviderService, r *http.Re
 	Logger.Debug("VirtualListModels.DoListModels", zap.String("provider", p.Name))
 
 	var models []drivers.ListModelsModel
-	for modelName := range v.ModelMappings {
+	for modelName := range v.Plugin.Mappings() {
 		models = append(models, drivers.ListModelsModel{
 			Object:  "model",
 			ID:      modelName,
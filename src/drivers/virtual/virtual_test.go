@@ -0,0 +1,128 @@
+package virtual
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTargets(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		want     string
+		weighted bool
+		err      bool
+	}{
+		{spec: "openai/gpt-4.1+slwin@tag=123", want: "openai/gpt-4.1+slwin@tag=123:1"},
+		{spec: "openai/gpt-4.1 70 anthropic/claude-sonnet 30", want: "openai/gpt-4.1:70 anthropic/claude-sonnet:30", weighted: true},
+		{spec: "openai/gpt-4.1 0 anthropic/claude-sonnet 1", want: "openai/gpt-4.1:0 anthropic/claude-sonnet:1", weighted: true},
+		{spec: "openai/gpt-4.1?healthy anthropic/claude-sonnet", want: "openai/gpt-4.1:1 anthropic/claude-sonnet:1"},
+		{spec: "openai/gpt-4.1 70 anthropic/claude-sonnet", err: true},
+		{spec: "openai/gpt-4.1 x anthropic/claude-sonnet 30", err: true},
+		{spec: "openai/gpt-4.1 0 anthropic/claude-sonnet 0", err: true},
+		{spec: "openai/gpt-4.1?hours=9-17", err: true},
+		{spec: "openai/gpt-4.1?days=mon-xyz", err: true},
+		{spec: "openai/gpt-4.1?weather=sunny", err: true},
+		{spec: "", err: true},
+	} {
+		got, weighted, err := ParseTargets(tc.spec)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.spec, got)
+			}
+			continue
+		}
+		var parts []string
+		for _, target := range got {
+			parts = append(parts, fmt.Sprintf("%s:%d", target.Model, target.Weight))
+		}
+		if err != nil || strings.Join(parts, " ") != tc.want || weighted != tc.weighted {
+			t.Errorf("%q = %v, %v, %v; want %s, %v", tc.spec, parts, weighted, err, tc.want, tc.weighted)
+		}
+	}
+}
+
+func TestRewriteModel_ConditionalTargets(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	down := map[string]bool{}
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, berlin) // a Wednesday
+	v := &VirtualPlugin{
+		ProviderName: "alias",
+		ModelMappings: map[string]string{
+			"office": "premium/big?days=mon-fri,hours=09:00-18:00,tz=Europe/Berlin cheap/small",
+			"night":  "cheap/small?hours=22:00-06:00,tz=Europe/Berlin premium/big",
+			"ha":     "openai/gpt-4.1?healthy anthropic/claude-sonnet?healthy=bedrock",
+		},
+		Healthy: func(provider string) bool { return !down[provider] },
+		now:     func() time.Time { return now },
+	}
+	check := func(model, want string, wantOK bool) {
+		t.Helper()
+		got, ok := v.RewriteModel("alias/" + model)
+		if ok != wantOK || (ok && got != want) {
+			t.Errorf("%s at %s = %q, %v; want %q, %v", model, now.Format(time.RFC1123), got, ok, want, wantOK)
+		}
+	}
+
+	check("office", "premium/big", true)
+	check("night", "premium/big", true)
+	now = time.Date(2026, 10, 14, 18, 0, 0, 0, berlin)
+	check("office", "cheap/small", true)
+	now = time.Date(2026, 10, 17, 11, 0, 0, 0, berlin) // Saturday
+	check("office", "cheap/small", true)
+	now = time.Date(2026, 10, 17, 23, 30, 0, 0, berlin)
+	check("night", "cheap/small", true)
+	now = time.Date(2026, 10, 18, 5, 59, 0, 0, berlin)
+	check("night", "cheap/small", true)
+
+	check("ha", "openai/gpt-4.1", true)
+	down["openai"] = true
+	check("ha", "anthropic/claude-sonnet", true)
+	down["bedrock"] = true
+	check("ha", "", false)
+}
+
+func TestRewriteModel_WeightedTargets(t *testing.T) {
+	v := &VirtualPlugin{
+		ProviderName:  "alias",
+		ModelMappings: map[string]string{"smart": "openai/gpt-4.1 70 anthropic/claude-sonnet 30 old/model 0"},
+		Sticky:        true,
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		got, ok := v.RewriteModelKeyed("alias/smart+slwin", fmt.Sprint("conv-", i))
+		if !ok {
+			t.Fatal("weighted mapping did not match")
+		}
+		counts[got]++
+	}
+	if counts["old/model+slwin"] != 0 {
+		t.Errorf("zero-weight target picked %d times", counts["old/model+slwin"])
+	}
+	if n := counts["openai/gpt-4.1+slwin"]; n < 1250 || n > 1550 {
+		t.Errorf("70%% target picked %d/2000 times: %v", n, counts)
+	}
+
+	first, _ := v.RewriteModelKeyed("alias/smart", "conv-1")
+	for i := 0; i < 50; i++ {
+		if got, _ := v.RewriteModelKeyed("alias/smart", "conv-1"); got != first {
+			t.Fatalf("sticky pick changed from %s to %s", first, got)
+		}
+	}
+
+	// Without Sticky the key is ignored: one conversation spreads out.
+	v.Sticky = false
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		got, _ := v.RewriteModelKeyed("alias/smart", "conv-1")
+		seen[got] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("non-sticky picks for one conversation = %v", seen)
+	}
+}
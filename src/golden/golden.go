@@ -0,0 +1,289 @@
+// Package golden turns sampled traffic into regression tests. It runs
+// plugin chains and style converters over the request, upstream-request
+// and response AIL triples the sampler stores, and compares the results
+// with golden disassembly checked in next to each sample:
+//
+//	<sample>/request.ail
+//	<sample>/request.up.ail        (optional)
+//	<sample>/response.ail          (optional)
+//	<sample>/golden/<suite>.txt
+//
+// A plugin's tests copy a few samples into its testdata directory and
+// call Suite.Run on it; set GOLDEN_UPDATE=1 to (re)write the golden files
+// after an intended change, then review them like any other diff.
+package golden
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// UpdateEnv names the environment variable that makes Run write golden
+// files instead of comparing against them.
+const UpdateEnv = "GOLDEN_UPDATE"
+
+// Case is one sampled request. Upstream and Response are nil when the
+// sample has no request.up.ail or response.ail.
+type Case struct {
+	Name     string // path relative to the corpus root, slash-separated
+	Dir      string
+	Request  *ail.Program
+	Upstream *ail.Program
+	Response *ail.Program
+}
+
+// Load reads every sample below dir, in lexical order.
+func Load(dir string) ([]Case, error) {
+	samples, err := services.LoadReplayCorpus(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	cases := make([]Case, 0, len(samples))
+	for _, s := range samples {
+		c := Case{Name: s.Name, Dir: s.Dir}
+		if c.Request, err = readProgram(filepath.Join(s.Dir, "request.ail")); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		if c.Upstream, err = readOptional(filepath.Join(s.Dir, "request.up.ail")); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		if c.Response, err = readOptional(filepath.Join(s.Dir, "response.ail")); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// Suite is one configuration to run every case through.
+type Suite struct {
+	// Name names the golden file: <sample>/golden/<Name>.txt.
+	Name string
+	// Plugins are plugin specs ("kvtools", "slwin:4", "preset:x"), resolved
+	// against the registry as a route's plugins are.
+	Plugins []string
+	// Styles lists the styles the resulting programs are emitted in, to
+	// cover the converters; responses are emitted for the styles that have
+	// a response emitter.
+	Styles []ail.Style
+	// Provider is passed to the plugins; nil passes a provider named
+	// "golden" of the first style.
+	Provider *services.ProviderService
+	// IgnoreOps lists opcodes left out of the programs before they are
+	// rendered, because they differ on every call. Nil means
+	// services.DefaultReplayIgnoreOps.
+	IgnoreOps []string
+}
+
+// Render runs c through the suite: the request through the before-hooks,
+// the response (if any) through the after-hooks, then both through the
+// emitters of Styles. The result is what the golden file holds.
+func (s *Suite) Render(c Case) (string, error) {
+	if err := plugin.ValidateSpecs(s.Plugins, nil); err != nil {
+		return "", err
+	}
+	chain := plugin.NewPluginChain()
+	for _, spec := range s.Plugins {
+		chain.AddSpec(spec)
+	}
+	if err := chain.Check(); err != nil {
+		return "", err
+	}
+
+	// The trace ID is fixed per case so plugins keying state by it, like
+	// kvtools, render the same way on every run.
+	ctx := context.WithValue(context.Background(), plugin.ContextTraceID(), "golden:"+c.Name)
+	r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	chain = chain.Select(r, c.Request)
+	p := s.provider()
+
+	var out strings.Builder
+	req, err := chain.RunBefore(p, r, c.Request.Clone())
+	if err != nil {
+		return "", fmt.Errorf("before: %w", err)
+	}
+	req = s.strip(req)
+	section(&out, "request")
+	out.WriteString(req.Disasm())
+	for _, style := range s.Styles {
+		em, err := ail.GetEmitter(style)
+		if err != nil {
+			return "", err
+		}
+		body, err := em.EmitRequest(req)
+		if err != nil {
+			return "", fmt.Errorf("emit %s request: %w", style, err)
+		}
+		section(&out, "request as "+string(style))
+		writeJSON(&out, body)
+	}
+
+	if c.Response == nil {
+		return out.String(), nil
+	}
+	reqProg := c.Upstream
+	if reqProg == nil {
+		reqProg = req
+	}
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: r}
+	resp, err := chain.RunAfter(p, r, reqProg, res, c.Response.Clone())
+	if err != nil {
+		return "", fmt.Errorf("after: %w", err)
+	}
+	resp = s.strip(resp)
+	section(&out, "response")
+	out.WriteString(resp.Disasm())
+	for _, style := range s.Styles {
+		em, err := ail.GetResponseEmitter(style)
+		if err != nil {
+			continue
+		}
+		body, err := em.EmitResponse(resp)
+		if err != nil {
+			return "", fmt.Errorf("emit %s response: %w", style, err)
+		}
+		section(&out, "response as "+string(style))
+		writeJSON(&out, body)
+	}
+	return out.String(), nil
+}
+
+// GoldenPath is where c's golden file for the suite lives.
+func (s *Suite) GoldenPath(c Case) string {
+	return filepath.Join(c.Dir, "golden", s.Name+".txt")
+}
+
+// Check renders c and compares it with its golden file, returning the
+// line diff (nil when they match). With update set, the golden file is
+// written instead.
+func (s *Suite) Check(c Case, update bool) ([]string, error) {
+	got, err := s.Render(c)
+	if err != nil {
+		return nil, err
+	}
+	path := s.GoldenPath(c)
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		return nil, os.WriteFile(path, []byte(got), 0o644)
+	}
+	want, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no golden file %s (run with %s=1 to create it)", path, UpdateEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return services.DiffLines(lines(string(want)), lines(got)), nil
+}
+
+// Run checks every case below dir as a subtest, writing the golden files
+// instead when GOLDEN_UPDATE is set. An empty corpus fails the test.
+func (s *Suite) Run(t *testing.T, dir string) {
+	t.Helper()
+	cases, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no samples below %s", dir)
+	}
+	update := os.Getenv(UpdateEnv) != ""
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			diff, err := s.Check(c, update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff != nil {
+				t.Errorf("%s differs from %s:\n%s", s.Name, s.GoldenPath(c), strings.Join(diff, "\n"))
+			}
+		})
+	}
+}
+
+func (s *Suite) provider() *services.ProviderService {
+	if s.Provider != nil {
+		return s.Provider
+	}
+	p := &services.ProviderService{Name: "golden"}
+	if len(s.Styles) > 0 {
+		p.Style = s.Styles[0]
+	}
+	return p
+}
+
+// strip drops the instructions of the ignored opcodes.
+func (s *Suite) strip(prog *ail.Program) *ail.Program {
+	ignore := s.IgnoreOps
+	if ignore == nil {
+		ignore = services.DefaultReplayIgnoreOps
+	}
+	if len(ignore) == 0 {
+		return prog
+	}
+	out := prog.Clone()
+	out.Code = slices.DeleteFunc(out.Code, func(in ail.Instruction) bool {
+		return slices.Contains(ignore, in.Op.Name())
+	})
+	return out
+}
+
+func section(out *strings.Builder, label string) {
+	if out.Len() > 0 {
+		out.WriteString("\n")
+	}
+	out.WriteString("; --- " + label + "\n")
+}
+
+// writeJSON writes body indented, so golden diffs are line by line.
+func writeJSON(out *strings.Builder, body []byte) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		out.Write(body)
+	} else {
+		out.Write(buf.Bytes())
+	}
+	out.WriteString("\n")
+}
+
+// lines splits text into lines, dropping trailing whitespace and blank
+// lines so editors cannot break a golden file.
+func lines(text string) []string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, " \t\r"); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// readProgram reads a sample program. Golden corpora are checked in, so
+// sealed samples are not supported.
+func readProgram(path string) (*ail.Program, error) {
+	return services.ReadSample(path, nil)
+}
+
+func readOptional(path string) (*ail.Program, error) {
+	prog, err := readProgram(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return prog, err
+}
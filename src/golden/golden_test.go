@@ -0,0 +1,131 @@
+package golden
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// suffixPlugin appends its params to the system prompt of requests and
+// to the text of responses.
+type suffixPlugin struct{}
+
+func (suffixPlugin) Name() string { return "golden-suffix" }
+
+func (suffixPlugin) Before(params string, _ *services.ProviderService, _ *http.Request, prog *ail.Program) (*ail.Program, error) {
+	return prog.PrependSystemPrompt("be " + params), nil
+}
+
+func (suffixPlugin) After(params string, _ *services.ProviderService, _ *http.Request, _ *ail.Program, _ *http.Response, res *ail.Program) (*ail.Program, error) {
+	out := res.Clone()
+	for i, in := range out.Code {
+		if in.Op == ail.TXT_CHUNK {
+			out.Code[i].Str += " (" + params + ")"
+		}
+	}
+	return out, nil
+}
+
+func writeSample(t *testing.T, dir string) {
+	t.Helper()
+	req := ail.NewProgram()
+	req.EmitString(ail.SET_MODEL, "gpt-4o")
+	req.Emit(ail.MSG_START)
+	req.Emit(ail.ROLE_USR)
+	req.EmitString(ail.TXT_CHUNK, "hi")
+	req.Emit(ail.MSG_END)
+
+	res := ail.NewProgram()
+	res.EmitString(ail.RESP_ID, "chatcmpl-1")
+	res.EmitString(ail.RESP_MODEL, "gpt-4o")
+	res.Emit(ail.MSG_START)
+	res.Emit(ail.ROLE_AST)
+	res.EmitString(ail.TXT_CHUNK, "hello")
+	res.Emit(ail.MSG_END)
+
+	for name, prog := range map[string]*ail.Program{"request.ail": req, "response.ail": res} {
+		var buf bytes.Buffer
+		if err := prog.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "2026/10/15/a", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSuiteCheck(t *testing.T) {
+	plugin.RegisterPlugin("golden-suffix", suffixPlugin{})
+	defer plugin.UnregisterPlugin("golden-suffix")
+
+	dir := t.TempDir()
+	writeSample(t, dir)
+	cases, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 1 || cases[0].Name != "2026/10/15/a" || cases[0].Upstream != nil || cases[0].Response == nil {
+		t.Fatalf("cases = %+v", cases)
+	}
+	c := cases[0]
+
+	s := &Suite{
+		Name:    "suffix",
+		Plugins: []string{"golden-suffix:brief"},
+		Styles:  []ail.Style{ail.StyleChatCompletions, ail.StyleAnthropic},
+	}
+	if _, err := s.Check(c, false); err == nil || !strings.Contains(err.Error(), UpdateEnv) {
+		t.Fatalf("missing golden file: err = %v", err)
+	}
+	if _, err := s.Check(c, true); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join(c.Dir, "golden", "suffix.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"be brief", "hello (brief)", "; --- request as anthropic-messages", "; --- response as openai-chat-completions"} {
+		if !strings.Contains(string(golden), want) {
+			t.Errorf("golden file lacks %q:\n%s", want, golden)
+		}
+	}
+	if strings.Contains(string(golden), "chatcmpl-1") {
+		t.Errorf("ignored RESP_ID rendered:\n%s", golden)
+	}
+	if diff, err := s.Check(c, false); err != nil || diff != nil {
+		t.Fatalf("unchanged suite: diff = %q, err = %v", diff, err)
+	}
+
+	s.Plugins = []string{"golden-suffix:verbose"}
+	diff, err := s.Check(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) == 0 || !strings.Contains(strings.Join(diff, "\n"), "+") {
+		t.Errorf("changed plugin params: diff = %q", diff)
+	}
+}
+
+func TestSuiteUnknownPlugin(t *testing.T) {
+	dir := t.TempDir()
+	writeSample(t, dir)
+	cases, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Suite{Name: "x", Plugins: []string{"no-such-plugin"}}
+	if _, err := s.Render(cases[0]); err == nil {
+		t.Fatal("unknown plugin rendered")
+	}
+}
@@ -0,0 +1,255 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// AdminAPI exposes router state on Caddy's admin endpoint. Caddy loads
+// every admin.api.* module automatically, so no configuration is needed.
+//
+// Routes:
+//
+//	GET /ai/health              health of every provider, by router
+//	GET /ai/health?router=<n>   health of a single router's providers
+//	GET /ai/usage?router=<n>[&key_id=<id>][&from=YYYY-MM-DD][&to=YYYY-MM-DD]
+//	                            aggregated usage, for one key or all keys
+//	GET  /ai/policy?router=<n>  the router's reloadable Policy
+//	POST /ai/policy?router=<n>  apply a Policy (JSON body); answers with the result
+//	GET    /ai/virtual?router=<n>         the router's virtual providers
+//	GET    /ai/virtual/<name>?router=<n>  one virtual provider
+//	PUT    /ai/virtual/<name>?router=<n>  create or replace a runtime virtual
+//	                                      provider (RuntimeVirtual JSON body)
+//	DELETE /ai/virtual/<name>?router=<n>  delete a runtime virtual provider
+//	POST   /ai/dspy/compile               compile a DSPy program (dspy.CompileRequest
+//	                                      JSON body); answers once it is cached
+//	GET    /ai/dspy/compiled              compiled DSPy programs, without state
+//	GET    /ai/dspy/compiled/<name>       one compiled program, with state
+//	DELETE /ai/dspy/compiled/<name>       delete a compiled program
+//	GET    /ai/dspy/signatures            named DSPy signatures
+//	GET    /ai/dspy/signatures/<name>     one named signature
+//	PUT    /ai/dspy/signatures/<name>     create or replace a stored signature
+//	                                      (dspy.Signature JSON body)
+//	DELETE /ai/dspy/signatures/<name>     delete a stored signature
+//
+// Runtime virtual providers need the router's virtual_store, stored DSPy
+// signatures a router's dspy_signature_store.
+//
+// Corpus replay (POST /ai/replay) lives in server.ReplayAdminAPI, since it
+// drives the inference pipeline.
+type AdminAPI struct{}
+
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.ai_router",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/ai/health", Handler: caddy.AdminHandlerFunc(a.handleHealth)},
+		{Pattern: "/ai/usage", Handler: caddy.AdminHandlerFunc(a.handleUsage)},
+		{Pattern: "/ai/policy", Handler: caddy.AdminHandlerFunc(a.handlePolicy)},
+		{Pattern: "/ai/virtual", Handler: caddy.AdminHandlerFunc(a.handleVirtual)},
+		{Pattern: "/ai/virtual/", Handler: caddy.AdminHandlerFunc(a.handleVirtual)},
+		{Pattern: "/ai/dspy/compile", Handler: caddy.AdminHandlerFunc(a.handleDSPyCompile)},
+		{Pattern: "/ai/dspy/compiled", Handler: caddy.AdminHandlerFunc(a.handleDSPyCompiled)},
+		{Pattern: "/ai/dspy/compiled/", Handler: caddy.AdminHandlerFunc(a.handleDSPyCompiled)},
+		{Pattern: "/ai/dspy/signatures", Handler: caddy.AdminHandlerFunc(a.handleDSPySignatures)},
+		{Pattern: "/ai/dspy/signatures/", Handler: caddy.AdminHandlerFunc(a.handleDSPySignatures)},
+	}
+}
+
+func (a *AdminAPI) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	out := make(map[string]map[string]services.HealthStatus)
+	if name := r.URL.Query().Get("router"); name != "" {
+		router, ok := GetRouter(name)
+		if !ok {
+			return caddy.APIError{
+				HTTPStatus: http.StatusNotFound,
+				Err:        fmt.Errorf("router %q not found", name),
+			}
+		}
+		out[router.Name] = router.HealthSnapshot()
+	} else {
+		routerRegistry.Range(func(_, v any) bool {
+			if router, ok := v.(*RouterModule); ok {
+				out[router.Name] = router.HealthSnapshot()
+			}
+			return true
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(out)
+}
+
+func (a *AdminAPI) handleUsage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	q := r.URL.Query()
+	router, ok := GetRouter(q.Get("router"))
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q not found", q.Get("router")),
+		}
+	}
+	if router.Impl.Usage == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q has no usage_accounting", router.Name),
+		}
+	}
+	from, to, err := services.ParseUsageRange(q.Get("from"), q.Get("to"), time.Now())
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+
+	rows, err := router.Impl.Usage.Query(r.Context(), q.Get("key_id"), from, to)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	var total services.UsageCounts
+	for _, row := range rows {
+		total.Add(row.UsageCounts)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]any{
+		"router": router.Name,
+		"data":   rows,
+		"total":  total,
+	})
+}
+
+// maxPolicyBody caps the size of a posted policy.
+const maxPolicyBody = 16 << 20
+
+func (a *AdminAPI) handlePolicy(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	router, ok := GetRouter(r.URL.Query().Get("router"))
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q not found", r.URL.Query().Get("router")),
+		}
+	}
+	if r.Method == http.MethodPost {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolicyBody))
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		p, err := ParsePolicy(data)
+		if err == nil {
+			err = router.ApplyPolicy(p)
+		}
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		router.Impl.Logger.Info("Policy applied via admin API")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(router.CurrentPolicy())
+}
+
+// maxVirtualBody caps the size of a posted virtual provider.
+const maxVirtualBody = 1 << 20
+
+func (a *AdminAPI) handleVirtual(w http.ResponseWriter, r *http.Request) error {
+	router, ok := GetRouter(r.URL.Query().Get("router"))
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q not found", r.URL.Query().Get("router")),
+		}
+	}
+	name := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/ai/virtual"), "/"))
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(router.Virtuals())
+	case r.Method == http.MethodGet:
+		info, ok := router.Virtuals()[name]
+		if !ok {
+			return caddy.APIError{
+				HTTPStatus: http.StatusNotFound,
+				Err:        fmt.Errorf("virtual provider %q not found", name),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(info)
+	case name == "":
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	case r.Method == http.MethodPut:
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVirtualBody))
+		dec.DisallowUnknownFields()
+		var rv RuntimeVirtual
+		if err := dec.Decode(&rv); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		if err = router.PutVirtual(r.Context(), name, &rv); err == nil {
+			router.Impl.Logger.Info("Virtual provider saved via admin API", zap.String("name", name))
+			w.Header().Set("Content-Type", "application/json")
+			return json.NewEncoder(w).Encode(VirtualInfo{RuntimeVirtual: rv, Runtime: true})
+		}
+	case r.Method == http.MethodDelete:
+		if err = router.DeleteVirtual(r.Context(), name); err == nil {
+			router.Impl.Logger.Info("Virtual provider deleted via admin API", zap.String("name", name))
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errNoVirtualStore), errors.Is(err, errNoSuchVirtual):
+		status = http.StatusNotFound
+	case errors.Is(err, errConfigured):
+		status = http.StatusConflict
+	case errors.Is(err, errBadVirtual):
+		status = http.StatusBadRequest
+	}
+	return caddy.APIError{HTTPStatus: status, Err: err}
+}
+
+var _ caddy.AdminRouter = (*AdminAPI)(nil)
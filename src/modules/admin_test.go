@@ -0,0 +1,73 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestAdminAPI_Health(t *testing.T) {
+	p := &ProviderConfig{Name: "up"}
+	p.Impl.Health = services.NewProviderHealth(HealthProbeTCP, 1)
+	p.Impl.Health.Report(errors.New("down"), 0)
+	m := &RouterModule{
+		Name:            "admin-test",
+		ProviderConfigs: map[string]*ProviderConfig{"up": p},
+		ProvidersOrder:  []string{"up"},
+	}
+	RegisterRouter(m.Name, m)
+
+	var a AdminAPI
+	w := httptest.NewRecorder()
+	if err := a.handleHealth(w, httptest.NewRequest(http.MethodGet, "/ai/health?router=admin-test", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[string]services.HealthStatus
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := got["admin-test"]["up"]; !ok || st.Healthy {
+		t.Errorf("expected unhealthy provider in admin output, got %+v", got)
+	}
+
+	err := a.handleHealth(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ai/health?router=missing", nil))
+	if err == nil {
+		t.Error("expected an error for an unknown router")
+	}
+}
+
+func TestAdminAPI_Usage(t *testing.T) {
+	m := &RouterModule{Name: "usage-test"}
+	m.Impl.Usage = services.NewUsageAccounting(kv.NewMemoryStore(100, time.Hour), "t:", time.Hour)
+	m.Impl.Usage.Record("k1", "openai", "gpt-4", services.Usage{PromptTokens: 4, CompletionTokens: 2}, 0)
+	m.Impl.Usage.Record("k2", "openai", "gpt-4", services.Usage{PromptTokens: 1}, 0)
+	RegisterRouter(m.Name, m)
+
+	var a AdminAPI
+	w := httptest.NewRecorder()
+	if err := a.handleUsage(w, httptest.NewRequest(http.MethodGet, "/ai/usage?router=usage-test&key_id=k1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Data  []services.UsageRow  `json:"data"`
+		Total services.UsageCounts `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := services.UsageCounts{Requests: 1, PromptTokens: 4, CompletionTokens: 2}
+	if len(got.Data) != 1 || got.Total != want {
+		t.Errorf("unexpected usage output %+v", got)
+	}
+
+	err := a.handleUsage(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ai/usage?router=usage-test&from=bad", nil))
+	if err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
@@ -0,0 +1,72 @@
+package modules
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// WeightedOrder reorders providers for a model according to the configured
+// model_weights. Weighted providers are ranked by weighted rendezvous
+// hashing on key (typically a conversation ID), so the same conversation
+// consistently lands on the same provider while traffic as a whole splits
+// according to the weights. The remaining ranks act as fallbacks.
+// Providers without a weight keep their original order after the weighted
+// ones. Returns providers unchanged when no weights are configured.
+func (m *RouterModule) WeightedOrder(model string, providers []string, key string) []string {
+	m.Impl.Mu.RLock()
+	weights, ok := m.ModelProviderWeights[model]
+	m.Impl.Mu.RUnlock()
+	if !ok || len(weights) == 0 {
+		return providers
+	}
+	return rendezvousOrder(providers, weights, key)
+}
+
+// rendezvousOrder ranks the weighted subset of providers by descending
+// weighted-HRW score for key; unweighted providers follow in input order.
+func rendezvousOrder(providers []string, weights map[string]int, key string) []string {
+	type scored struct {
+		name  string
+		score float64
+	}
+	var ranked []scored
+	var rest []string
+	for _, name := range providers {
+		w := weights[name]
+		if w <= 0 {
+			rest = append(rest, name)
+			continue
+		}
+		ranked = append(ranked, scored{name, rendezvousScore(key, name, w)})
+	}
+	if len(ranked) == 0 {
+		return providers
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	out := make([]string, 0, len(providers))
+	for _, s := range ranked {
+		out = append(out, s.name)
+	}
+	return append(out, rest...)
+}
+
+// rendezvousScore computes the weighted rendezvous score w / -ln(u), where
+// u is a uniform (0,1) value derived from hashing key and provider.
+func rendezvousScore(key, provider string, weight int) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(provider))
+	// FNV alone mixes trailing bytes poorly into the high bits; finish
+	// with splitmix64 before mapping to (0,1), never exactly 0 or 1.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	u := (float64(x>>11) + 0.5) / float64(uint64(1)<<53)
+	return float64(weight) / -math.Log(u)
+}
@@ -0,0 +1,35 @@
+package modules
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRendezvousOrder_Sticky(t *testing.T) {
+	providers := []string{"a", "b", "c"}
+	weights := map[string]int{"a": 1, "b": 1}
+	first := rendezvousOrder(providers, weights, "conv-1")
+	for i := 0; i < 10; i++ {
+		got := rendezvousOrder(providers, weights, "conv-1")
+		if got[0] != first[0] {
+			t.Fatalf("expected sticky choice %q, got %q", first[0], got[0])
+		}
+	}
+	if first[2] != "c" {
+		t.Errorf("unweighted provider should trail, got %v", first)
+	}
+}
+
+func TestRendezvousOrder_Distribution(t *testing.T) {
+	providers := []string{"a", "b"}
+	weights := map[string]int{"a": 80, "b": 20}
+	counts := map[string]int{}
+	const n = 10000
+	for i := 0; i < n; i++ {
+		counts[rendezvousOrder(providers, weights, fmt.Sprintf("k%d", i))[0]]++
+	}
+	share := float64(counts["a"]) / n
+	if share < 0.75 || share > 0.85 {
+		t.Errorf("expected ~80%% share for a, got %.2f", share)
+	}
+}
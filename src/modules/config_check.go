@@ -0,0 +1,186 @@
+package modules
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+// storeCheckTimeout bounds the read that checks a kv backend at startup.
+const storeCheckTimeout = 5 * time.Second
+
+// openStore opens the kv store of option what and checks that it answers,
+// so an unreachable backend fails the config load rather than requests.
+func openStore(ctx context.Context, what, backend, dsn string) (kv.Store, error) {
+	store, err := kv.Open(backend, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", what, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeCheckTimeout)
+	defer cancel()
+	if err := kv.Ping(ctx, store); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("%s: kv backend %q is unreachable: %v", what, cmp.Or(backend, "memory"), err)
+	}
+	return store, nil
+}
+
+// parseBaseURL parses a provider's api_base_url, which must be an
+// absolute http or https URL.
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api_base_url '%s': %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("api_base_url '%s' must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("api_base_url '%s' has no host", raw)
+	}
+	return u, nil
+}
+
+// checkReferences checks what the configuration says about providers and
+// models against what is configured: default providers, the exports and
+// targets of virtual providers, and cycles among those targets. The
+// caller holds Impl.Mu.
+func (m *RouterModule) checkReferences() error {
+	for model, names := range m.DefaultProviderForModel {
+		for _, name := range names {
+			if _, ok := m.ProviderConfigs[name]; !ok {
+				return fmt.Errorf("default_provider_for_model %s: unknown provider %s", model, name)
+			}
+		}
+	}
+
+	// edges links each virtual model ("provider/model") to the virtual
+	// models its targets name, to find cycles.
+	edges := make(map[string][]string)
+	for _, name := range m.ProvidersOrder {
+		p := m.ProviderConfigs[name]
+		if p == nil || m.runtimeVirtuals[name] || !m.isVirtual(p) {
+			continue
+		}
+		// Mappings loaded from a source are checked as they are loaded.
+		if p.MappingsSource == nil {
+			for _, export := range p.Exports {
+				if strings.HasPrefix(export, "@") || strings.ContainsAny(export, "*?[") {
+					continue
+				}
+				if _, ok := p.ModelMappings[export]; !ok {
+					return fmt.Errorf("provider %s: exports %s, which it has no mapping for", name, export)
+				}
+			}
+		}
+		for _, model := range slices.Sorted(maps.Keys(p.ModelMappings)) {
+			targets, _, err := virtual.ParseTargets(p.ModelMappings[model])
+			if err != nil {
+				return fmt.Errorf("provider %s: model %s: %v", name, model, err)
+			}
+			for _, t := range targets {
+				next, err := m.checkTarget(t)
+				if err != nil {
+					return fmt.Errorf("provider %s: model %s: target %s: %v", name, model, t.Model, err)
+				}
+				if next != "" {
+					edges[name+"/"+model] = append(edges[name+"/"+model], next)
+				}
+			}
+		}
+	}
+	if cycle := findCycle(edges); cycle != nil {
+		return fmt.Errorf("virtual model mappings form a cycle: %s", strings.Join(cycle, " → "))
+	}
+	return nil
+}
+
+// checkTarget checks one virtual target: the plugins of its suffix, the
+// provider its health condition names, and, when it names a model of
+// another virtual provider, that the model is mapped there. That model
+// is returned, as "provider/model", for cycle detection.
+//
+// A target whose prefix is not a provider is left alone: it is a model
+// ID containing a slash, such as "meta-llama/Llama-3.3-70B".
+func (m *RouterModule) checkTarget(t virtual.Target) (string, error) {
+	base, suffix, _ := strings.Cut(t.Model, "+")
+	if suffix != "" {
+		if err := plugin.ValidateSpecs(strings.Split(suffix, "+"), m.Presets); err != nil {
+			return "", err
+		}
+	}
+	if t.When != nil && t.When.Healthy && t.When.Provider != "" {
+		if _, ok := m.ProviderConfigs[strings.ToLower(t.When.Provider)]; !ok {
+			return "", fmt.Errorf("healthy: unknown provider %s", t.When.Provider)
+		}
+	}
+	prefix, model, ok := strings.Cut(base, "/")
+	if !ok {
+		return "", nil
+	}
+	name := strings.ToLower(prefix)
+	p, ok := m.ProviderConfigs[name]
+	if !ok || !m.isVirtual(p) || m.runtimeVirtuals[name] {
+		return "", nil
+	}
+	if p.MappingsSource == nil {
+		if _, ok := p.ModelMappings[model]; !ok {
+			return "", fmt.Errorf("virtual provider %s has no mapping for %s", name, model)
+		}
+	}
+	return name + "/" + model, nil
+}
+
+func (m *RouterModule) isVirtual(p *ProviderConfig) bool {
+	style, err := styles.ParseStyle(p.Style)
+	return err == nil && style == styles.StyleVirtual
+}
+
+// findCycle returns a path of edges that leads back to its first node,
+// or nil when there is none. Nodes are visited in sorted order so the
+// same config always reports the same cycle.
+func findCycle(edges map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(edges))
+	var path []string
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		switch state[node] {
+		case visiting:
+			i := slices.Index(path, node)
+			return append(slices.Clone(path[i:]), node)
+		case done:
+			return nil
+		}
+		state[node] = visiting
+		path = append(path, node)
+		for _, next := range edges[node] {
+			if cycle := visit(next); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = done
+		return nil
+	}
+	for _, node := range slices.Sorted(maps.Keys(edges)) {
+		if cycle := visit(node); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// downStore is a kv backend whose server cannot be reached.
+type downStore struct{}
+
+var errDown = errors.New("connection refused")
+
+func (downStore) Get(context.Context, string) (string, error)              { return "", errDown }
+func (downStore) Set(context.Context, string, string, time.Duration) error { return errDown }
+func (downStore) Delete(context.Context, string) error                     { return errDown }
+func (downStore) Close() error                                             { return nil }
+
+func init() {
+	kv.RegisterBackend("check-test-down", func(string) (kv.Store, error) { return downStore{}, nil })
+}
+
+func TestOpenStore(t *testing.T) {
+	store, err := openStore(context.Background(), "cooldown", "", "")
+	if err != nil {
+		t.Fatalf("memory backend: %v", err)
+	}
+	_ = store.Close()
+
+	if _, err := openStore(context.Background(), "cooldown", "check-test-down", ""); err == nil ||
+		!strings.Contains(err.Error(), `cooldown: kv backend "check-test-down" is unreachable`) {
+		t.Errorf("unreachable backend: err = %v", err)
+	}
+	if _, err := openStore(context.Background(), "sticky_routing", "no-such-backend", ""); err == nil ||
+		!strings.HasPrefix(err.Error(), "sticky_routing: ") {
+		t.Errorf("unknown backend: err = %v", err)
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://api.openai.com/v1": true,
+		"http://localhost:8080":     true,
+		"api.openai.com/v1":         false,
+		"ftp://example.com":         false,
+		"https://":                  false,
+		"http://[::1":               false,
+	} {
+		if _, err := parseBaseURL(raw); (err == nil) != ok {
+			t.Errorf("parseBaseURL(%q): err = %v", raw, err)
+		}
+	}
+}
+
+func TestCheckReferences(t *testing.T) {
+	const providers = `
+		provider openai {
+			api_base_url https://api.openai.com/v1
+		}
+		provider alias {
+			style virtual
+			model smart team/smart
+			model fast openai/gpt-4o-mini+slwin:20
+			model hf meta-llama/Llama-3.3-70B
+			exports smart fast
+		}
+	`
+	for _, tc := range []struct {
+		name, config, wantErr string
+	}{
+		{"valid", `
+			provider team {
+				style virtual
+				model smart openai/gpt-4.1?healthy=openai
+			}
+			default_provider_for_model gpt-4.1 openai`, ""},
+		{"cycle", `
+			provider team {
+				style virtual
+				model smart alias/smart
+			}`, "cycle: alias/smart → team/smart → alias/smart"},
+		{"unmapped target", `
+			provider team {
+				style virtual
+				model clever openai/gpt-4.1
+			}`, "target team/smart: virtual provider team has no mapping for smart"},
+		{"unknown health provider", `
+			provider team {
+				style virtual
+				model smart openai/gpt-4.1?healthy=azure
+			}`, "healthy: unknown provider azure"},
+		{"unknown default provider", `
+			provider team {
+				style virtual
+				model smart openai/gpt-4.1
+			}
+			default_provider_for_model gpt-4.1 azure`, "default_provider_for_model gpt-4.1: unknown provider azure"},
+	} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {` + providers + tc.config + "\n}")); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		err := m.Validate()
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		provider alias {
+			style virtual
+			model fast openai/gpt-4o-mini+no-such-plugin
+			exports fast slow
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "exports slow, which it has no mapping for") {
+		t.Errorf("unmapped export: err = %v", err)
+	}
+	m.ProviderConfigs["alias"].Exports = nil
+	if err := m.Validate(); err == nil || !strings.Contains(err.Error(), `unknown plugin "no-such-plugin"`) {
+		t.Errorf("unknown target plugin: err = %v", err)
+	}
+}
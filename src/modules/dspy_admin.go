@@ -0,0 +1,201 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/plugins/dspy"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+	"go.uber.org/zap"
+)
+
+// SignatureStore keeps the DSPy signatures saved through the admin API
+// (PUT /ai/dspy/signatures/<name>) in a kv backend: key <prefix><name>,
+// value the dspy.Signature as JSON. Signatures are shared by all routers,
+// so the store of the router provisioned last is the one in use.
+//
+// Caddyfile:
+//
+//	dspy_signature_store <backend> <prefix> [<dsn>]
+type SignatureStore struct {
+	Backend string `json:"backend,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	DSN     string `json:"dsn,omitempty"`
+}
+
+const (
+	maxCompileBody   = 1 << 16
+	maxSignatureBody = 1 << 20
+)
+
+// openSignatureStore opens dspy_signature_store and loads the signatures
+// saved in it. A saved signature that no longer checks out is logged and
+// skipped.
+func (m *RouterModule) openSignatureStore(ctx context.Context) error {
+	ss := m.DSPySignatureStore
+	if ss == nil {
+		return nil
+	}
+	store, err := kv.Open(ss.Backend, ss.DSN)
+	if err != nil {
+		return fmt.Errorf("dspy_signature_store: %v", err)
+	}
+	skipped, err := dspy.SetSignatureStore(ctx, store, ss.Prefix)
+	if err != nil {
+		_ = store.Close()
+		return fmt.Errorf("dspy_signature_store: %v", err)
+	}
+	for name, err := range skipped {
+		m.Impl.Logger.Error("Skipping stored DSPy signature", zap.String("name", name), zap.Error(err))
+	}
+	go func() {
+		<-ctx.Done()
+		dspy.ClearSignatureStore(store)
+		_ = store.Close()
+	}()
+	return nil
+}
+
+func (a *AdminAPI) handleDSPyCompile(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCompileBody))
+	dec.DisallowUnknownFields()
+	var req dspy.CompileRequest
+	if err := dec.Decode(&req); err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+
+	cp, err := dspy.Compile(r.Context(), req, r.Header)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, dspy.ErrBadCompile) || errors.Is(err, dspy.ErrNoTrainingSample) {
+			status = http.StatusBadRequest
+		}
+		return caddy.APIError{HTTPStatus: status, Err: err}
+	}
+	plugin.Logger.Info("DSPy program compiled via admin API",
+		zap.String("name", cp.Name),
+		zap.String("optimizer", cp.Optimizer),
+		zap.Int("examples", cp.Examples))
+
+	summary := *cp
+	summary.State = nil
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(summary)
+}
+
+func (a *AdminAPI) handleDSPyCompiled(w http.ResponseWriter, r *http.Request) error {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ai/dspy/compiled"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		out := []dspy.CompiledProgram{}
+		for _, n := range dspy.ListCompiled() {
+			if cp, err := dspy.GetCompiled(n); err == nil {
+				summary := *cp
+				summary.State = nil
+				out = append(out, summary)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(out)
+	case r.Method == http.MethodGet:
+		cp, err := dspy.GetCompiled(name)
+		if err != nil {
+			return compiledError(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(cp)
+	case r.Method == http.MethodDelete && name != "":
+		if err := dspy.DeleteCompiled(name); err != nil {
+			return compiledError(err)
+		}
+		plugin.Logger.Info("DSPy program deleted via admin API", zap.String("name", name))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+}
+
+func (a *AdminAPI) handleDSPySignatures(w http.ResponseWriter, r *http.Request) error {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ai/dspy/signatures"), "/")
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(dspy.ListSignatures())
+	case r.Method == http.MethodGet:
+		info, ok := dspy.ListSignatures()[name]
+		if !ok {
+			return caddy.APIError{
+				HTTPStatus: http.StatusNotFound,
+				Err:        fmt.Errorf("signature %q not found", name),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(info)
+	case name == "":
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	case r.Method == http.MethodPut:
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignatureBody))
+		dec.DisallowUnknownFields()
+		var sig dspy.Signature
+		if err := dec.Decode(&sig); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		if err = dspy.PutSignature(r.Context(), name, &sig); err == nil {
+			plugin.Logger.Info("DSPy signature saved via admin API", zap.String("name", name))
+			w.Header().Set("Content-Type", "application/json")
+			return json.NewEncoder(w).Encode(dspy.SignatureInfo{Signature: sig, Stored: true})
+		}
+	case r.Method == http.MethodDelete:
+		if err = dspy.DeleteSignature(r.Context(), name); err == nil {
+			plugin.Logger.Info("DSPy signature deleted via admin API", zap.String("name", name))
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, dspy.ErrNoSignatureStore), errors.Is(err, dspy.ErrNoSignature):
+		status = http.StatusNotFound
+	case errors.Is(err, dspy.ErrSignatureConfigured):
+		status = http.StatusConflict
+	case errors.Is(err, dspy.ErrBadSignature):
+		status = http.StatusBadRequest
+	}
+	return caddy.APIError{HTTPStatus: status, Err: err}
+}
+
+func compiledError(err error) error {
+	status := http.StatusInternalServerError
+	if errors.Is(err, dspy.ErrNoCompiled) {
+		status = http.StatusNotFound
+	}
+	return caddy.APIError{HTTPStatus: status, Err: err}
+}
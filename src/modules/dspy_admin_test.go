@@ -0,0 +1,129 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/plugins/dspy"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// signaturesStore is a kv backend sharing one MemoryStore between opens.
+var signaturesStore = kv.NewMemoryStore(100, -1)
+
+func init() {
+	kv.RegisterBackend("signatures-test", func(string) (kv.Store, error) { return signaturesStore, nil })
+}
+
+// adminStatus is the HTTP status an admin handler answers err with.
+func adminStatus(err error) int {
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus
+	}
+	return http.StatusOK
+}
+
+func TestDSPyAdmin(t *testing.T) {
+	t.Setenv("DSPY_COMPILED_DIR", t.TempDir())
+	var a AdminAPI
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/ai/dspy/compile", `{"name": "../x", "model": "m", "dir": "d"}`, http.StatusBadRequest},
+		{http.MethodPost, "/ai/dspy/compile", `{"name": "x", "model": "m", "dir": "` + t.TempDir() + `"}`, http.StatusBadRequest},
+		{http.MethodPost, "/ai/dspy/compile", `{"nam": "x"}`, http.StatusBadRequest},
+		{http.MethodGet, "/ai/dspy/compile", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/ai/dspy/compiled", "", http.StatusOK},
+		{http.MethodGet, "/ai/dspy/compiled/missing", "", http.StatusNotFound},
+		{http.MethodDelete, "/ai/dspy/compiled/missing", "", http.StatusNotFound},
+		{http.MethodDelete, "/ai/dspy/compiled", "", http.StatusMethodNotAllowed},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		var err error
+		if strings.HasPrefix(tt.path, "/ai/dspy/compiled") {
+			err = a.handleDSPyCompiled(w, r)
+		} else {
+			err = a.handleDSPyCompile(w, r)
+		}
+		if status := adminStatus(err); status != tt.want {
+			t.Errorf("%s %s: %v, want status %d", tt.method, tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestDSPySignatures(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		dspy_signature admin-invoice "document -> vendor, total" {
+			instructions "Extract the invoice fields."
+			field total "Amount due, with currency"
+			demo "document=ACME, $3" vendor=ACME total=$3
+		}
+		dspy_signature_store signatures-test sig/
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	sig := m.DSPySignatures["admin-invoice"]
+	if sig == nil || sig.Instructions != "Extract the invoice fields." || sig.Fields["total"] == "" || sig.Demos[0]["document"] != "ACME, $3" {
+		t.Fatalf("signature = %+v", sig)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.openSignatureStore(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var a AdminAPI
+	do := func(method, path, body string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		return w, a.handleDSPySignatures(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	}
+	if _, err := do(http.MethodPut, "/ai/dspy/signatures/admin-summary", `{"signature": "text -> summary", "fields": {"summary": "Two sentences"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := signaturesStore.Get(ctx, "sig/admin-summary"); !strings.Contains(saved, "Two sentences") {
+		t.Errorf("saved = %q", saved)
+	}
+	if w, err := do(http.MethodGet, "/ai/dspy/signatures/admin-invoice", ""); err != nil || !strings.Contains(w.Body.String(), `"stored":false`) {
+		t.Errorf("get configured: %v %s", err, w.Body)
+	}
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/ai/dspy/signatures/admin-invoice", `{"signature": "a -> b"}`, http.StatusConflict},
+		{http.MethodPut, "/ai/dspy/signatures/admin-bad", `{"signature": "a"}`, http.StatusBadRequest},
+		{http.MethodPut, "/ai/dspy/signatures/admin-bad", `{"sig": "a -> b"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/ai/dspy/signatures/admin-missing", "", http.StatusNotFound},
+		{http.MethodGet, "/ai/dspy/signatures/admin-missing", "", http.StatusNotFound},
+		{http.MethodPost, "/ai/dspy/signatures", "", http.StatusMethodNotAllowed},
+	} {
+		if _, err := do(tt.method, tt.path, tt.body); adminStatus(err) != tt.want {
+			t.Errorf("%s %s: %v, want status %d", tt.method, tt.path, err, tt.want)
+		}
+	}
+	if _, err := do(http.MethodDelete, "/ai/dspy/signatures/admin-summary", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dspy.LookupSignature("admin-summary"); !errors.Is(err, dspy.ErrNoSignature) {
+		t.Errorf("deleted signature still resolves: %v", err)
+	}
+
+	bad := &RouterModule{DSPySignatures: map[string]*dspy.Signature{"admin-broken": {Signature: "no arrow"}}}
+	if err := bad.provisionPlugins(); err == nil {
+		t.Error("invalid dspy_signature accepted")
+	}
+}
@@ -0,0 +1,202 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+	"go.uber.org/zap"
+)
+
+// Health probe kinds.
+const (
+	HealthProbeTCP        = "tcp"
+	HealthProbeListModels = "list_models"
+	HealthProbeCompletion = "completion"
+)
+
+// HealthCheckConfig configures active health checking for a provider.
+//
+// Caddyfile (inside a provider block):
+//
+//	health_check <tcp|list_models|completion> {
+//	    interval        <duration>   # default 30s
+//	    timeout         <duration>   # default 5s
+//	    model           <model>      # required for the completion probe
+//	    unhealthy_after <n>          # consecutive failures, default 2
+//	}
+type HealthCheckConfig struct {
+	Probe          string         `json:"probe,omitempty"`
+	Interval       caddy.Duration `json:"interval,omitempty"`
+	Timeout        caddy.Duration `json:"timeout,omitempty"`
+	Model          string         `json:"model,omitempty"`
+	UnhealthyAfter int            `json:"unhealthy_after,omitempty"`
+}
+
+const (
+	defaultHealthInterval       = 30 * time.Second
+	defaultHealthTimeout        = 5 * time.Second
+	defaultHealthUnhealthyAfter = 2
+)
+
+// startHealthChecks launches one background prober per provider with a
+// health_check configured. The probers stop when ctx is cancelled.
+func (m *RouterModule) startHealthChecks(ctx context.Context) {
+	for _, name := range m.ProvidersOrder {
+		p := m.ProviderConfigs[name]
+		if p.HealthCheck == nil || p.Impl.Health == nil {
+			continue
+		}
+		go m.runHealthChecks(ctx, p)
+	}
+}
+
+func (m *RouterModule) runHealthChecks(ctx context.Context, p *ProviderConfig) {
+	hc := p.HealthCheck
+	interval := time.Duration(hc.Interval)
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	timeout := time.Duration(hc.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := probeProvider(probeCtx, p, hc)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		wasHealthy := p.Impl.Health.Healthy()
+		p.Impl.Health.Report(err, time.Since(start))
+		if isHealthy := p.Impl.Health.Healthy(); isHealthy != wasHealthy {
+			m.Impl.Logger.Warn("Provider health changed",
+				zap.String("provider", p.Name),
+				zap.String("probe", hc.Probe),
+				zap.Bool("healthy", isHealthy),
+				zap.Error(err))
+			if !isHealthy {
+				m.Impl.Webhooks.Emit(services.WebhookCircuitOpened, map[string]any{
+					"provider": p.Name,
+					"reason":   "health_check",
+					"probe":    hc.Probe,
+					"error":    err.Error(),
+				})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeProvider runs a single health probe against a provider.
+func probeProvider(ctx context.Context, p *ProviderConfig, hc *HealthCheckConfig) error {
+	switch hc.Probe {
+	case HealthProbeTCP:
+		host := p.Impl.ParsedURL.Host
+		if p.Impl.ParsedURL.Port() == "" {
+			port := "443"
+			if p.Impl.ParsedURL.Scheme == "http" {
+				port = "80"
+			}
+			host = net.JoinHostPort(p.Impl.ParsedURL.Hostname(), port)
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case HealthProbeListModels:
+		cmd, ok := p.Impl.Commands["list_models"].(drivers.ListModelsCommand)
+		if !ok {
+			return fmt.Errorf("provider does not support list_models")
+		}
+		// Probe the upstream even for private/filtered providers.
+		if f, ok := cmd.(*drivers.ExportFilteredListModels); ok {
+			cmd = f.Inner
+		}
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+		_, err := cmd.DoListModels(&p.Impl, req)
+		return err
+
+	case HealthProbeCompletion:
+		cmd, ok := p.Impl.Commands["inference"].(drivers.InferenceCommand)
+		if !ok {
+			return fmt.Errorf("provider does not support inference")
+		}
+		prog := ail.NewProgram()
+		prog.EmitString(ail.SET_MODEL, hc.Model)
+		prog.EmitInt(ail.SET_MAX, 1)
+		prog.Emit(ail.MSG_START)
+		prog.Emit(ail.ROLE_USR)
+		prog.EmitString(ail.TXT_CHUNK, "ping")
+		prog.Emit(ail.MSG_END)
+		req, _ := http.NewRequestWithContext(ctx, "POST", "/", nil)
+		_, _, err := cmd.DoInference(&p.Impl, prog, req)
+		return err
+
+	default:
+		return fmt.Errorf("unknown health probe %q", hc.Probe)
+	}
+}
+
+// HealthSnapshot returns the current health status of every provider,
+// keyed by provider name. Providers without a health check report healthy;
+// virtual providers have no upstream and are omitted.
+func (m *RouterModule) HealthSnapshot() map[string]services.HealthStatus {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	out := make(map[string]services.HealthStatus, len(m.ProviderConfigs))
+	for _, name := range m.ProvidersOrder {
+		if p, ok := m.ProviderConfigs[name]; ok && p.Impl.Style != styles.StyleVirtual {
+			out[name] = p.Impl.Health.Status()
+		}
+	}
+	return out
+}
+
+// providerHealthy reports whether the named provider is healthy; unknown
+// providers and those without a health_check count as healthy.
+func (m *RouterModule) providerHealthy(name string) bool {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	p, ok := m.ProviderConfigs[name]
+	return !ok || p.Impl.Health.Healthy()
+}
+
+// DemoteUnhealthy returns providers reordered so that healthy ones come
+// first, preserving relative order. Unhealthy providers are still tried
+// as a last resort rather than dropped.
+func (m *RouterModule) DemoteUnhealthy(providers []string) []string {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	healthy := make([]string, 0, len(providers))
+	var unhealthy []string
+	for _, name := range providers {
+		if p, ok := m.ProviderConfigs[name]; ok && !p.Impl.Health.Healthy() {
+			unhealthy = append(unhealthy, name)
+			continue
+		}
+		healthy = append(healthy, name)
+	}
+	return append(healthy, unhealthy...)
+}
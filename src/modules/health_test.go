@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/openai"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func listModelsProvider(t *testing.T, status int, body string) *ProviderConfig {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+
+	p := &ProviderConfig{Name: "up"}
+	p.Impl = services.ProviderService{
+		Name:      "up",
+		ParsedURL: *u,
+		Router:    &services.RouterService{Auth: services.NopAuthService{}},
+		Commands: map[string]any{
+			"list_models": &drivers.ExportFilteredListModels{Inner: &openai.ListModels{}},
+		},
+	}
+	return p
+}
+
+func TestProbeProvider_ListModels(t *testing.T) {
+	hc := &HealthCheckConfig{Probe: HealthProbeListModels}
+
+	ok := listModelsProvider(t, http.StatusOK, `{"data":[{"id":"m"}]}`)
+	if err := probeProvider(context.Background(), ok, hc); err != nil {
+		t.Errorf("200 response should be healthy, got %v", err)
+	}
+
+	revoked := listModelsProvider(t, http.StatusUnauthorized, `{"error":{"message":"invalid api key"}}`)
+	if err := probeProvider(context.Background(), revoked, hc); err == nil {
+		t.Error("401 response should fail the probe")
+	}
+}
+
+func TestDemoteUnhealthy(t *testing.T) {
+	m := &RouterModule{ProviderConfigs: map[string]*ProviderConfig{}}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		p := &ProviderConfig{Name: name}
+		p.Impl.Health = services.NewProviderHealth(HealthProbeTCP, 1)
+		m.ProviderConfigs[name] = p
+	}
+	m.ProviderConfigs["a"].Impl.Health.Report(context.DeadlineExceeded, time.Second)
+	m.ProviderConfigs["c"].Impl.Health.Report(context.DeadlineExceeded, time.Second)
+
+	got := m.DemoteUnhealthy([]string{"a", "b", "c", "d"})
+	want := []string{"b", "d", "a", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseHealthCheck(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		provider up {
+			api_base_url https://example.com/v1
+			style chat-completions
+			health_check completion {
+				interval 10s
+				timeout 2s
+				model tiny
+				unhealthy_after 3
+			}
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	want := &HealthCheckConfig{
+		Probe:          HealthProbeCompletion,
+		Interval:       caddy.Duration(10 * time.Second),
+		Timeout:        caddy.Duration(2 * time.Second),
+		Model:          "tiny",
+		UnhealthyAfter: 3,
+	}
+	if got := m.ProviderConfigs["up"].HealthCheck; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	bad := caddyfile.NewTestDispenser(`ai_router {
+		provider up {
+			api_base_url https://example.com/v1
+			health_check completion
+		}
+	}`)
+	var m2 RouterModule
+	if err := m2.UnmarshalCaddyfile(bad); err == nil {
+		t.Error("completion probe without a model should be rejected")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/neutrome-labs/open-ai-router/src/plugins/dspy"
 	"github.com/neutrome-labs/open-ai-router/src/plugins/flow"
 	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
 )
 
 var APP_VERSION = "5.0.0"
@@ -23,13 +24,45 @@ func init() {
 	plugin.RegisterPlugin("chain", &plugins.ChainPlugin{})
 	plugin.RegisterPlugin("dspy", &dspy.DSPy{})
 
-	// Auto-enable the sampler when the SAMPLER env var points to a directory.
-	if dir := os.Getenv("SAMPLER"); dir != "" {
-		s := plugins.NewSampler(dir)
-		plugin.RegisterPlugin("sampler", s)
+	// Usage accounting is a no-op on routers without usage_accounting, and
+	// inspect while no ai_inspect client is connected.
+	plugin.RegisterPlugin("usage", plugins.UsageRecorder{})
+	plugin.RegisterPlugin("inspect", plugins.Inspect{})
+	plugin.TailPlugins = append(plugin.TailPlugins, [2]string{"usage", ""}, [2]string{"inspect", ""})
+
+	// Auto-enable the sampler when the SAMPLER env var names a directory or
+	// an s3:// or gs:// bucket; see plugins.NewSamplerFromEnv for the
+	// rate, filter and size-cap settings.
+	sampler, err := plugins.NewSamplerFromEnv(os.Getenv)
+	if err != nil {
+		caddy.Log().Error("sampler disabled", zap.Error(err))
+		sampler = nil
+	}
+
+	// The watchdog (WATCHDOG_LATENCY / WATCHDOG_TOKENS) runs ahead of the
+	// sampler so a flagged request it already samples gets a note rather
+	// than a second copy.
+	if wd, err := plugins.NewWatchdogFromEnv(os.Getenv, sampler); err != nil {
+		caddy.Log().Error("watchdog disabled", zap.Error(err))
+	} else if wd != nil {
+		plugin.RegisterPlugin("watchdog", wd)
+		plugin.TailPlugins = append(plugin.TailPlugins, [2]string{"watchdog", ""})
+	}
+
+	if sampler != nil {
+		plugin.RegisterPlugin("sampler", sampler)
 		plugin.TailPlugins = append(plugin.TailPlugins, [2]string{"sampler", ""})
 	}
 
+	// MIRROR copies a share of traffic into a JSONL evaluation dataset;
+	// see plugins.NewMirrorFromEnv.
+	if mirror, err := plugins.NewMirrorFromEnv(os.Getenv); err != nil {
+		caddy.Log().Error("mirror disabled", zap.Error(err))
+	} else if mirror != nil {
+		plugin.RegisterPlugin("mirror", mirror)
+		plugin.TailPlugins = append(plugin.TailPlugins, [2]string{"mirror", ""})
+	}
+
 	defer func() {
 		_ = services.FireObservabilityEvent("app", "", "init", map[string]any{
 			"version": APP_VERSION,
@@ -40,6 +73,8 @@ func init() {
 	httpcaddyfile.RegisterHandlerDirective("ai_auth_env", ParseEnvAuthModule)
 	httpcaddyfile.RegisterDirectiveOrder("ai_auth_env", httpcaddyfile.Before, "header")
 
+	caddy.RegisterModule(&AdminAPI{})
+
 	caddy.RegisterModule(&RouterModule{})
 	httpcaddyfile.RegisterHandlerDirective("ai_router", ParseRouterModule)
 	httpcaddyfile.RegisterDirectiveOrder("ai_router", httpcaddyfile.Before, "header")
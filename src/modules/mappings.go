@@ -0,0 +1,201 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+	"go.uber.org/zap"
+)
+
+// Mapping source kinds.
+const (
+	MappingsSourceURL = "url"
+	MappingsSourceKV  = "kv"
+)
+
+// MappingsSource loads a virtual provider's model mappings from outside the
+// config, so ops tooling can change aliases at runtime:
+//
+//	url  a JSON object of model → target, fetched with GET
+//	kv   every key under Prefix of a kv backend: key <prefix><model>,
+//	     value the target; the backend must be able to list keys
+//
+// The source is read at start and every Interval after. Its mappings
+// overlay the provider's model lines; when a read fails the last good
+// mappings stay.
+//
+// Caddyfile (inside a virtual provider block):
+//
+//	mappings_source url <url> {
+//	    interval <duration>   # default 30s
+//	    timeout  <duration>   # per read, default 10s
+//	}
+//	mappings_source kv <backend> <prefix> [<dsn>] { ... }
+type MappingsSource struct {
+	Kind     string         `json:"kind,omitempty"`
+	URL      string         `json:"url,omitempty"`
+	Backend  string         `json:"backend,omitempty"`
+	Prefix   string         `json:"prefix,omitempty"`
+	DSN      string         `json:"dsn,omitempty"`
+	Interval caddy.Duration `json:"interval,omitempty"`
+	Timeout  caddy.Duration `json:"timeout,omitempty"`
+}
+
+const (
+	defaultMappingsInterval = 30 * time.Second
+	defaultMappingsTimeout  = 10 * time.Second
+)
+
+// mappingsMaxBody caps a URL source's response.
+const mappingsMaxBody = 8 << 20
+
+// mappingsLoader reads a source's current mappings.
+type mappingsLoader func(ctx context.Context) (map[string]string, error)
+
+// newMappingsLoader returns the loader of src, opening its kv store.
+func newMappingsLoader(src *MappingsSource) (mappingsLoader, io.Closer, error) {
+	switch src.Kind {
+	case MappingsSourceURL:
+		return func(ctx context.Context) (map[string]string, error) {
+			return fetchMappings(ctx, src.URL)
+		}, nil, nil
+	case MappingsSourceKV:
+		store, err := kv.Open(src.Backend, src.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		lister, ok := store.(kv.Lister)
+		if !ok {
+			_ = store.Close()
+			return nil, nil, fmt.Errorf("kv backend %q cannot list keys", src.Backend)
+		}
+		return func(ctx context.Context) (map[string]string, error) {
+			entries, err := lister.List(ctx, src.Prefix)
+			if err != nil {
+				return nil, err
+			}
+			mappings := make(map[string]string, len(entries))
+			for key, target := range entries {
+				if model := key[len(src.Prefix):]; model != "" && target != "" {
+					mappings[model] = target
+				}
+			}
+			return mappings, nil
+		}, store, nil
+	}
+	return nil, nil, fmt.Errorf("unknown mappings source %q", src.Kind)
+}
+
+func fetchMappings(ctx context.Context, url string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, res.Status)
+	}
+	var mappings map[string]string
+	if err := json.NewDecoder(io.LimitReader(res.Body, mappingsMaxBody)).Decode(&mappings); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	for model, target := range mappings {
+		if model == "" || target == "" {
+			return nil, fmt.Errorf("%s: empty model or target in %q: %q", url, model, target)
+		}
+	}
+	return mappings, nil
+}
+
+// startMappingSources loads the mappings of every virtual provider with a
+// mappings_source and keeps them fresh until ctx is cancelled.
+func (m *RouterModule) startMappingSources(ctx context.Context) error {
+	for _, name := range m.ProvidersOrder {
+		p := m.ProviderConfigs[name]
+		vp := m.virtualPlugin(name)
+		if p.MappingsSource == nil || vp == nil {
+			continue
+		}
+		load, closer, err := newMappingsLoader(p.MappingsSource)
+		if err != nil {
+			return fmt.Errorf("provider %s: mappings_source: %v", name, err)
+		}
+		m.refreshMappings(ctx, p, vp, load)
+		go func() {
+			if closer != nil {
+				defer closer.Close()
+			}
+			m.watchMappings(ctx, p, vp, load)
+		}()
+	}
+	return nil
+}
+
+func (m *RouterModule) watchMappings(ctx context.Context, p *ProviderConfig, vp *virtual.VirtualPlugin, load mappingsLoader) {
+	interval := time.Duration(p.MappingsSource.Interval)
+	if interval <= 0 {
+		interval = defaultMappingsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.refreshMappings(ctx, p, vp, load)
+	}
+}
+
+// refreshMappings reads the source once and, when its mappings changed,
+// applies them over the provider's configured ones.
+func (m *RouterModule) refreshMappings(ctx context.Context, p *ProviderConfig, vp *virtual.VirtualPlugin, load mappingsLoader) {
+	timeout := time.Duration(p.MappingsSource.Timeout)
+	if timeout <= 0 {
+		timeout = defaultMappingsTimeout
+	}
+	loadCtx, cancel := context.WithTimeout(ctx, timeout)
+	loaded, err := load(loadCtx)
+	cancel()
+	if err != nil {
+		if ctx.Err() == nil {
+			m.Impl.Logger.Error("Loading virtual mappings failed, keeping the current ones",
+				zap.String("provider", vp.ProviderName), zap.Error(err))
+		}
+		return
+	}
+	for model, spec := range loaded {
+		if _, _, err = virtual.ParseTargets(spec); err != nil {
+			m.Impl.Logger.Error("Loaded virtual mappings are invalid, keeping the current ones",
+				zap.String("provider", vp.ProviderName), zap.String("model", model), zap.Error(err))
+			return
+		}
+	}
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+	mappings := maps.Clone(p.ModelMappings)
+	if mappings == nil {
+		mappings = make(map[string]string, len(loaded))
+	}
+	maps.Copy(mappings, loaded)
+	if maps.Equal(mappings, vp.Mappings()) {
+		return
+	}
+	vp.SetMappings(mappings)
+	m.Impl.Logger.Info("Virtual mappings loaded",
+		zap.String("provider", vp.ProviderName), zap.Int("models", len(mappings)))
+}
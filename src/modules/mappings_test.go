@@ -0,0 +1,155 @@
+package modules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestParseMappingsSource(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		provider fromurl {
+			style virtual
+			mappings_source url https://ops.example.com/aliases.json {
+				interval 1m
+				timeout 3s
+			}
+		}
+		provider fromkv {
+			style virtual
+			model fast openai/gpt-4o-mini
+			mappings_source kv memory aliases/
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	want := &MappingsSource{
+		Kind:     MappingsSourceURL,
+		URL:      "https://ops.example.com/aliases.json",
+		Interval: caddy.Duration(time.Minute),
+		Timeout:  caddy.Duration(3 * time.Second),
+	}
+	if got := m.ProviderConfigs["fromurl"].MappingsSource; !reflect.DeepEqual(got, want) {
+		t.Errorf("url source = %+v, want %+v", got, want)
+	}
+	want = &MappingsSource{Kind: MappingsSourceKV, Backend: "memory", Prefix: "aliases/"}
+	if got := m.ProviderConfigs["fromkv"].MappingsSource; !reflect.DeepEqual(got, want) {
+		t.Errorf("kv source = %+v, want %+v", got, want)
+	}
+
+	bad := caddyfile.NewTestDispenser(`ai_router {
+		provider alias {
+			style virtual
+			mappings_source kv memory
+		}
+	}`)
+	var m2 RouterModule
+	if err := m2.UnmarshalCaddyfile(bad); err == nil {
+		t.Error("kv source without a prefix should be rejected")
+	}
+}
+
+func TestMappingsSource_URL(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"smart": "openai/gpt-4.1"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := body.Load().(string); s != "" {
+			w.Write([]byte(s))
+			return
+		}
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	m, vp := newPolicyRouter(t)
+	p := m.ProviderConfigs["policy-alias"]
+	p.ModelMappings = map[string]string{"fast": "openai/gpt-4o-mini"}
+	p.MappingsSource = &MappingsSource{Kind: MappingsSourceURL, URL: srv.URL}
+	load, _, err := newMappingsLoader(p.MappingsSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	m.refreshMappings(ctx, p, vp, load)
+	want := map[string]string{"fast": "openai/gpt-4o-mini", "smart": "openai/gpt-4.1"}
+	if got := vp.Mappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings = %v, want %v", got, want)
+	}
+
+	// The source wins over the static mappings.
+	body.Store(`{"fast": "anthropic/claude-haiku"}`)
+	m.refreshMappings(ctx, p, vp, load)
+	want = map[string]string{"fast": "anthropic/claude-haiku"}
+	if got, _ := vp.RewriteModel("policy-alias/fast"); got != "anthropic/claude-haiku" {
+		t.Errorf("fast = %q", got)
+	}
+	if _, ok := vp.RewriteModel("policy-alias/smart"); ok {
+		t.Error("smart still mapped after the source dropped it")
+	}
+
+	// A failed read keeps the last good mappings.
+	body.Store("")
+	m.refreshMappings(ctx, p, vp, load)
+	if got := vp.Mappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings after a failed read = %v, want %v", got, want)
+	}
+
+	// Policy updates would be overwritten by the next read, so they are refused.
+	pol, _ := ParsePolicy([]byte(`{"virtual": {"policy-alias": {"x": "openai/y"}}}`))
+	if err := m.ApplyPolicy(pol); err == nil {
+		t.Error("policy changed mappings owned by a mappings_source")
+	}
+}
+
+// listStore is a kv backend sharing one MemoryStore between opens.
+var listStore = kv.NewMemoryStore(100, -1)
+
+func init() {
+	kv.RegisterBackend("mappings-test", func(string) (kv.Store, error) { return listStore, nil })
+}
+
+func TestMappingsSource_KV(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_ = listStore.Set(ctx, "aliases/fast", "openai/gpt-4o-mini", 0)
+	_ = listStore.Set(ctx, "other/slow", "openai/gpt-4.1", 0)
+
+	m, vp := newPolicyRouter(t)
+	m.ProvidersOrder = []string{"policy-alias", "openai"}
+	p := m.ProviderConfigs["policy-alias"]
+	p.MappingsSource = &MappingsSource{
+		Kind:     MappingsSourceKV,
+		Backend:  "mappings-test",
+		Prefix:   "aliases/",
+		Interval: caddy.Duration(10 * time.Millisecond),
+	}
+	if err := m.startMappingSources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := vp.Mappings(); !reflect.DeepEqual(got, map[string]string{"fast": "openai/gpt-4o-mini"}) {
+		t.Errorf("initial mappings = %v", got)
+	}
+
+	_ = listStore.Set(ctx, "aliases/smart", "openai/gpt-4.1", 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := vp.RewriteModel("policy-alias/smart"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("kv change was not picked up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,209 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// Policy is the part of a router's configuration that can change while it
+// runs, without a Caddy config reload and so without interrupting
+// in-flight streams:
+//
+//	{
+//	  "virtual": {"<virtual provider>": {"<model>": "<target>", ...}},
+//	  "presets": {"<name>": ["<plugin[:params]>", ...]},
+//	  "prices":  {"<provider>": {"<model|*>": {"input": 2.5, "output": 10}}}
+//	}
+//
+// "virtual" and "prices" replace the mappings and prices of the providers
+// they list; "presets", when present, replaces the router's presets, and
+// may not drop one that model_plugins or another preset still references.
+// Virtual providers created through the admin API are not part of it. A
+// policy is checked in full before any of it applies. It is read from
+// policy_file, and re-read when the file changes, or posted to the admin
+// API (POST /ai/policy).
+type Policy struct {
+	Virtual map[string]map[string]string              `json:"virtual,omitempty"`
+	Presets map[string][]string                       `json:"presets,omitempty"`
+	Prices  map[string]map[string]services.ModelPrice `json:"prices,omitempty"`
+}
+
+// policyPollInterval is how often policy_file is checked for changes.
+const policyPollInterval = 5 * time.Second
+
+// ParsePolicy decodes a policy, rejecting unknown fields so a typo does not
+// silently leave a setting unchanged.
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("policy: %v", err)
+	}
+	return &p, nil
+}
+
+// ApplyPolicy checks p against the router and applies it.
+func (m *RouterModule) ApplyPolicy(p *Policy) error {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	virtuals := make(map[*virtual.VirtualPlugin]map[string]string, len(p.Virtual))
+	for name, mappings := range p.Virtual {
+		vp := m.virtualPlugin(strings.ToLower(name))
+		if vp == nil {
+			return fmt.Errorf("policy: %q is not a virtual provider", name)
+		}
+		if m.ProviderConfigs[strings.ToLower(name)].MappingsSource != nil {
+			return fmt.Errorf("policy: virtual provider %s takes its mappings from its mappings_source", name)
+		}
+		if m.runtimeVirtuals[strings.ToLower(name)] {
+			return fmt.Errorf("policy: virtual provider %s is managed through the admin API (/ai/virtual)", name)
+		}
+		if len(mappings) == 0 {
+			return fmt.Errorf("policy: virtual provider %s requires at least one model mapping", name)
+		}
+		for model, spec := range mappings {
+			if _, _, err := virtual.ParseTargets(spec); err != nil {
+				return fmt.Errorf("policy: virtual provider %s: model %s: %v", name, model, err)
+			}
+		}
+		virtuals[vp] = mappings
+	}
+	prices := make(map[*services.ProviderService]map[string]services.ModelPrice, len(p.Prices))
+	for name, table := range p.Prices {
+		cfg, ok := m.ProviderConfigs[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("policy: unknown provider %q", name)
+		}
+		prices[&cfg.Impl] = table
+	}
+	for name, specs := range p.Presets {
+		if err := plugin.ValidateSpecs(specs, p.Presets); err != nil {
+			return fmt.Errorf("policy: preset %s: %v", name, err)
+		}
+	}
+	if p.Presets != nil {
+		// Presets the policy drops must not be referenced by what stays.
+		after := plugin.SnapshotPresets()
+		for name := range m.Presets {
+			delete(after, name)
+		}
+		maps.Copy(after, p.Presets)
+		for name, specs := range after {
+			if err := plugin.ValidatePresetRefs(specs, after); err != nil {
+				return fmt.Errorf("policy: preset %s: %v", name, err)
+			}
+		}
+		for model, specs := range m.ModelPlugins {
+			if err := plugin.ValidatePresetRefs(specs, after); err != nil {
+				return fmt.Errorf("policy: model_plugins %s: %v", model, err)
+			}
+		}
+	}
+
+	for vp, mappings := range virtuals {
+		vp.SetMappings(mappings)
+	}
+	for ps, table := range prices {
+		ps.SetPrices(table)
+	}
+	if p.Presets != nil {
+		for name := range m.Presets {
+			if _, keep := p.Presets[name]; !keep {
+				plugin.UnregisterPreset(name)
+			}
+		}
+		for name, specs := range p.Presets {
+			plugin.RegisterPreset(name, specs)
+		}
+		m.Presets = p.Presets
+	}
+	return nil
+}
+
+// CurrentPolicy returns the router's policy as it stands.
+func (m *RouterModule) CurrentPolicy() *Policy {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	p := &Policy{
+		Virtual: make(map[string]map[string]string),
+		Presets: make(map[string][]string),
+		Prices:  make(map[string]map[string]services.ModelPrice),
+	}
+	for name, cfg := range m.ProviderConfigs {
+		if vp := m.virtualPlugin(name); vp != nil {
+			if !m.runtimeVirtuals[name] {
+				p.Virtual[name] = vp.Mappings()
+			}
+		} else if table := cfg.Impl.ConfiguredPrices(); len(table) > 0 {
+			p.Prices[name] = table
+		}
+	}
+	for name := range m.Presets {
+		if specs, ok := plugin.GetPreset(name); ok {
+			p.Presets[name] = specs
+		}
+	}
+	return p
+}
+
+func (m *RouterModule) virtualPlugin(provider string) *virtual.VirtualPlugin {
+	if _, ok := m.ProviderConfigs[provider]; !ok {
+		return nil
+	}
+	p, _ := plugin.GetPlugin("virtual:" + provider)
+	vp, _ := p.(*virtual.VirtualPlugin)
+	return vp
+}
+
+// loadPolicyFile reads and applies policy_file.
+func (m *RouterModule) loadPolicyFile() error {
+	data, err := os.ReadFile(m.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("policy: %v", err)
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return err
+	}
+	return m.ApplyPolicy(p)
+}
+
+// watchPolicyFile re-applies policy_file whenever its modification time or
+// size changes, until ctx is cancelled. A policy that fails to load is
+// logged and the current one kept.
+func (m *RouterModule) watchPolicyFile(ctx context.Context, last os.FileInfo) {
+	ticker := time.NewTicker(policyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(m.PolicyFile)
+		if err != nil || (fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size()) {
+			continue
+		}
+		last = fi
+		if err := m.loadPolicyFile(); err != nil {
+			m.Impl.Logger.Error("Policy reload failed, keeping the current policy",
+				zap.String("file", m.PolicyFile), zap.Error(err))
+			continue
+		}
+		m.Impl.Logger.Info("Policy reloaded", zap.String("file", m.PolicyFile))
+	}
+}
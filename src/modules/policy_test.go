@@ -0,0 +1,161 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"go.uber.org/zap"
+)
+
+func newPolicyRouter(t *testing.T) (*RouterModule, *virtual.VirtualPlugin) {
+	t.Helper()
+	vp := &virtual.VirtualPlugin{ProviderName: "policy-alias", ModelMappings: map[string]string{"fast": "openai/gpt-4o-mini"}}
+	plugin.RegisterPlugin("virtual:policy-alias", vp)
+	t.Cleanup(func() { delete(plugin.Registry, "virtual:policy-alias") })
+	m := &RouterModule{
+		Name: "policy-test",
+		ProviderConfigs: map[string]*ProviderConfig{
+			"policy-alias": {Name: "policy-alias"},
+			"openai":       {Name: "openai"},
+		},
+	}
+	m.Impl.Logger = zap.NewNop()
+	return m, vp
+}
+
+func TestApplyPolicy(t *testing.T) {
+	m, vp := newPolicyRouter(t)
+	m.Presets = map[string][]string{"policy-old": {"fuzz"}}
+	plugin.RegisterPreset("policy-old", []string{"fuzz"})
+	t.Cleanup(func() { plugin.UnregisterPreset("policy-old"); plugin.UnregisterPreset("policy-new") })
+
+	p, err := ParsePolicy([]byte(`{
+		"virtual": {"policy-alias": {"fast": "openai/gpt-4.1-mini", "smart": "openai/gpt-4.1"}},
+		"presets": {"policy-new": ["slwin:20"]},
+		"prices":  {"openai": {"gpt-4.1": {"input": 2, "output": 8}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := vp.RewriteModel("policy-alias/smart+slwin"); !ok || got != "openai/gpt-4.1+slwin" {
+		t.Errorf("virtual mapping = %q, %v", got, ok)
+	}
+	if mp, ok := m.ProviderConfigs["openai"].Impl.PriceFor("gpt-4.1"); !ok || mp.Input != 2 {
+		t.Errorf("price = %+v, %v", mp, ok)
+	}
+	if _, ok := plugin.GetPreset("policy-old"); ok {
+		t.Error("replaced preset still registered")
+	}
+	if specs, _ := plugin.GetPreset("policy-new"); len(specs) != 1 {
+		t.Errorf("new preset = %q", specs)
+	}
+	if cur := m.CurrentPolicy(); len(cur.Virtual["policy-alias"]) != 2 || len(cur.Presets) != 1 || len(cur.Prices["openai"]) != 1 {
+		t.Errorf("current policy = %+v", cur)
+	}
+
+	// A bad policy changes nothing.
+	for _, bad := range []string{
+		`{"virtual": {"openai": {"x": "y"}}}`,
+		`{"prices": {"nope": {}}}`,
+		`{"virtual": {"policy-alias": {"a": "b"}}, "presets": {"p": ["no-such-plugin"]}}`,
+		`{"virtuals": {}}`,
+	} {
+		p, err := ParsePolicy([]byte(bad))
+		if err == nil {
+			err = m.ApplyPolicy(p)
+		}
+		if err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+	if len(vp.Mappings()) != 2 {
+		t.Errorf("mappings changed by a rejected policy: %v", vp.Mappings())
+	}
+}
+
+func TestApplyPolicy_DanglingPresets(t *testing.T) {
+	m, _ := newPolicyRouter(t)
+	m.Presets = map[string][]string{"policy-base": {"fuzz"}, "policy-wrap": {"preset:policy-base", "slwin:20"}}
+	m.ModelPlugins = map[string][]string{"gpt-*": {"preset:policy-wrap"}}
+	for name, specs := range m.Presets {
+		plugin.RegisterPreset(name, specs)
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"policy-base", "policy-wrap", "policy-renamed"} {
+			plugin.UnregisterPreset(name)
+		}
+	})
+
+	for _, bad := range []string{
+		`{"presets": {"policy-base": ["fuzz"]}}`,               // model_plugins uses policy-wrap
+		`{"presets": {"policy-wrap": ["preset:policy-base"]}}`, // policy-wrap uses policy-base
+	} {
+		p, err := ParsePolicy([]byte(bad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ApplyPolicy(p); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+	if _, ok := plugin.GetPreset("policy-base"); !ok {
+		t.Fatal("rejected policy removed a preset")
+	}
+
+	// Renaming a preset nothing else uses is fine.
+	p, _ := ParsePolicy([]byte(`{"presets": {"policy-wrap": ["slwin:20"], "policy-renamed": ["fuzz"]}}`))
+	if err := m.ApplyPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plugin.GetPreset("policy-base"); ok {
+		t.Error("renamed preset still registered")
+	}
+}
+
+func TestPolicyFile(t *testing.T) {
+	m, vp := newPolicyRouter(t)
+	m.PolicyFile = filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(m.PolicyFile, []byte(`{"virtual": {"policy-alias": {"fast": "openai/gpt-4.1-nano"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.loadPolicyFile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := vp.Mappings()["fast"]; got != "openai/gpt-4.1-nano" {
+		t.Errorf("mapping = %q", got)
+	}
+}
+
+func TestAdminAPI_Policy(t *testing.T) {
+	m, vp := newPolicyRouter(t)
+	RegisterRouter(m.Name, m)
+
+	var a AdminAPI
+	body := []byte(`{"virtual": {"policy-alias": {"fast": "openai/o4-mini"}}}`)
+	w := httptest.NewRecorder()
+	if err := a.handlePolicy(w, httptest.NewRequest(http.MethodPost, "/ai/policy?router=policy-test", bytes.NewReader(body))); err != nil {
+		t.Fatal(err)
+	}
+	var got Policy
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Virtual["policy-alias"]["fast"] != "openai/o4-mini" {
+		t.Errorf("response = %+v, %v", got, err)
+	}
+	if vp.Mappings()["fast"] != "openai/o4-mini" {
+		t.Error("policy not applied")
+	}
+
+	err := a.handlePolicy(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ai/policy?router=policy-test", bytes.NewReader([]byte(`{"prices": {"x": {}}}`))))
+	if err == nil {
+		t.Error("expected an error for an invalid policy")
+	}
+}
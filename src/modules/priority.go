@@ -0,0 +1,55 @@
+package modules
+
+import (
+	"fmt"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// provisionPriorities resolves the priorities block into a tenant lookup.
+// Raw API keys are stored only as the hashed tenant IDs the admission layer
+// derives from the Authorization header, with and without a Bearer prefix.
+func (m *RouterModule) provisionPriorities() error {
+	m.defaultPriority = services.PriorityNormal
+	m.tenantPriorities = nil
+	pc := m.Priorities
+	if pc == nil {
+		return nil
+	}
+	if pc.Default != "" {
+		p, err := services.ParsePriority(pc.Default)
+		if err != nil {
+			return fmt.Errorf("priorities: %v", err)
+		}
+		m.defaultPriority = p
+	}
+	m.tenantPriorities = make(map[string]services.Priority, 2*len(pc.Keys)+len(pc.Tenants))
+	for key, class := range pc.Keys {
+		p, err := services.ParsePriority(class)
+		if err != nil {
+			return fmt.Errorf("priorities: %v", err)
+		}
+		m.tenantPriorities[services.AuthTenant(key)] = p
+		m.tenantPriorities[services.AuthTenant("Bearer "+key)] = p
+	}
+	for tenant, class := range pc.Tenants {
+		p, err := services.ParsePriority(class)
+		if err != nil {
+			return fmt.Errorf("priorities: %v", err)
+		}
+		m.tenantPriorities[tenant] = p
+	}
+	return nil
+}
+
+// PriorityFor returns the priority class of a tenant, falling back to the
+// configured default (normal when unset).
+func (m *RouterModule) PriorityFor(tenant string) services.Priority {
+	if m.Priorities == nil {
+		return services.PriorityNormal
+	}
+	if p, ok := m.tenantPriorities[tenant]; ok {
+		return p
+	}
+	return m.defaultPriority
+}
@@ -0,0 +1,42 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestPriorityFor(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		priorities {
+			default low
+			key high sk-prod
+			tenant normal ip:10.0.0.1
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPriorities(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]services.Priority{
+		services.AuthTenant("Bearer sk-prod"): services.PriorityHigh,
+		services.AuthTenant("sk-prod"):        services.PriorityHigh,
+		"ip:10.0.0.1":                         services.PriorityNormal,
+		"ip:10.0.0.2":                         services.PriorityLow,
+	}
+	for tenant, want := range cases {
+		if got := m.PriorityFor(tenant); got != want {
+			t.Errorf("%s: got %v, want %v", tenant, got, want)
+		}
+	}
+
+	var unconfigured RouterModule
+	if got := unconfigured.PriorityFor("anyone"); got != services.PriorityNormal {
+		t.Errorf("unconfigured router should default to normal, got %v", got)
+	}
+}
@@ -4,24 +4,38 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/google"
 	"github.com/neutrome-labs/open-ai-router/src/drivers/openai"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/synthetic"
 	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
 	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/plugins"
+	"github.com/neutrome-labs/open-ai-router/src/plugins/dspy"
 	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
 	"github.com/neutrome-labs/open-ai-router/src/styles"
 	"go.uber.org/zap"
 )
 
 var routerRegistry sync.Map
 
+// defaultRateLimitWait is how long a request queues for rate-limit budget
+// when rate_limit_wait is not configured.
+const defaultRateLimitWait = 2 * time.Second
+
 // RegisterRouter registers a router by name
 func RegisterRouter(name string, m *RouterModule) {
 	routerRegistry.Store(strings.ToLower(name), m)
@@ -42,12 +56,174 @@ func GetRouter(name string) (*RouterModule, bool) {
 
 // RouterModule configures providers and routing rules for AI models.
 type RouterModule struct {
-	Name                    string                     `json:"name,omitempty"`
-	AuthManagerName         string                     `json:"auth_manager,omitempty"`
-	ProviderConfigs         map[string]*ProviderConfig `json:"providers,omitempty"`
-	DefaultProviderForModel map[string][]string        `json:"default_provider_for_model,omitempty"`
-	ProvidersOrder          []string                   `json:"providers_order,omitempty"`
+	Name                    string                        `json:"name,omitempty"`
+	AuthManagerName         string                        `json:"auth_manager,omitempty"`
+	ProviderConfigs         map[string]*ProviderConfig    `json:"providers,omitempty"`
+	DefaultProviderForModel map[string][]string           `json:"default_provider_for_model,omitempty"`
+	ProvidersOrder          []string                      `json:"providers_order,omitempty"`
+	FairQueue               *FairQueueConfig              `json:"fair_queue,omitempty"`
+	ModelProviderWeights    map[string]map[string]int     `json:"model_provider_weights,omitempty"` // model → provider → weight
+	RoutingStrategy         string                        `json:"routing_strategy,omitempty"`       // Default strategy when no X-Routing-Strategy header is sent
+	Cooldown                *CooldownConfig               `json:"cooldown,omitempty"`               // Upstream 429 cooldown tracking; enabled in memory by default
+	StickyRouting           *StickyRoutingConfig          `json:"sticky_routing,omitempty"`         // Optional: send a conversation's turns to the provider that served it
+	Hedging                 *HedgingConfig                `json:"hedging,omitempty"`                // Optional: duplicate requests slow to a first token to the next provider
+	LoadShed                *LoadShedConfig               `json:"load_shed,omitempty"`              // Optional overload protection
+	Priorities              *PrioritiesConfig             `json:"priorities,omitempty"`             // Optional per-key priority classes
+	AccessLog               bool                          `json:"access_log,omitempty"`             // Log one JSON line per client request
+	UsageAccounting         *UsageAccountingConfig        `json:"usage_accounting,omitempty"`       // Optional per-key usage aggregation
+	Webhooks                *WebhooksConfig               `json:"webhooks,omitempty"`               // Optional event notifications
+	SSEKeepalive            *caddy.Duration               `json:"sse_keepalive,omitempty"`          // Idle time before an SSE keepalive comment; 0 disables, default 15s
+	Presets                 map[string][]string           `json:"presets,omitempty"`                // Named plugin lists, used as "preset:<name>"
+	ModelPlugins            map[string][]string           `json:"model_plugins,omitempty"`          // Model glob → plugins every request for it gets
+	ModelGroups             map[string][]string           `json:"model_groups,omitempty"`           // Named model lists (IDs or globs), exported as "@<name>"
+	PluginPriorities        map[string]int                `json:"plugin_priorities,omitempty"`      // Plugin name → priority, overriding the plugin's own
+	PluginTimings           bool                          `json:"plugin_timings,omitempty"`         // Echo plugin run times in X-Plugin-Timings
+	PluginReport            *PluginReportConfig           `json:"plugin_report,omitempty"`          // Structured X-Plugins-Executed
+	PluginFailOpen          map[string]bool               `json:"plugin_fail_open,omitempty"`       // Plugin name → whether its failures let requests through
+	RemotePlugins           map[string]*RemotePlugin      `json:"remote_plugins,omitempty"`         // Plugins served by sidecars, by name
+	PolicyFile              string                        `json:"policy_file,omitempty"`            // Reloadable Policy, re-read when it changes
+	ModelInfo               map[string]services.ModelInfo `json:"model_info,omitempty"`             // Model → metadata overriding the built-in catalog
+	VirtualStore            *VirtualStore                 `json:"virtual_store,omitempty"`          // Where virtual providers created via the admin API persist
+	Preflight               string                        `json:"preflight,omitempty"`              // Check requests against the model catalog: check or compact; off when empty
+	DSPySignatures          map[string]*dspy.Signature    `json:"dspy_signatures,omitempty"`        // Named DSPy signatures, used as "+dspy:<kind>:@<name>"
+	DSPySignatureStore      *SignatureStore               `json:"dspy_signature_store,omitempty"`   // Where DSPy signatures saved via the admin API persist
 	Impl                    services.RouterService
+
+	defaultPriority  services.Priority
+	tenantPriorities map[string]services.Priority
+	policyMu         sync.Mutex // serializes policy reloads and runtime virtual provider changes
+	virtualStore     kv.Store
+	runtimeVirtuals  map[string]bool // virtual providers created via the admin API
+}
+
+// FairQueueConfig configures the router-wide weighted fair admission queue.
+type FairQueueConfig struct {
+	MaxInFlight int            `json:"max_in_flight,omitempty"`
+	MaxQueue    int            `json:"max_queue,omitempty"`
+	MaxWait     caddy.Duration `json:"max_wait,omitempty"`
+	Weights     map[string]int `json:"weights,omitempty"` // tenant ID → relative share
+}
+
+// PrioritiesConfig assigns priority classes to API keys and tenants.
+type PrioritiesConfig struct {
+	Default string            `json:"default,omitempty"` // class for unlisted traffic, default normal
+	Keys    map[string]string `json:"keys,omitempty"`    // raw API key → class
+	Tenants map[string]string `json:"tenants,omitempty"` // tenant ID (ip:…, auth:…, key ID) → class
+}
+
+// LoadShedConfig configures overload-based load shedding for a router.
+type LoadShedConfig struct {
+	MaxGoroutines int            `json:"max_goroutines,omitempty"`
+	MaxInFlight   int            `json:"max_in_flight,omitempty"`
+	MaxHeapMB     int            `json:"max_heap_mb,omitempty"`
+	RetryAfter    caddy.Duration `json:"retry_after,omitempty"`
+}
+
+const defaultLoadShedRetryAfter = time.Second
+
+// Preflight modes.
+const (
+	PreflightCheck   = "check"
+	PreflightCompact = "compact"
+)
+
+// defaultSSEKeepalive is the idle time before a keepalive comment is
+// written to a stream when sse_keepalive is not configured; common proxy
+// idle timeouts are 30–60s.
+const defaultSSEKeepalive = 15 * time.Second
+
+// SSEKeepaliveInterval returns how long a stream may stay idle before a
+// keepalive comment is written; 0 disables keepalives.
+func (m *RouterModule) SSEKeepaliveInterval() time.Duration {
+	if m.SSEKeepalive == nil {
+		return defaultSSEKeepalive
+	}
+	return time.Duration(*m.SSEKeepalive)
+}
+
+// CooldownConfig configures how upstream 429s are remembered.
+type CooldownConfig struct {
+	Disabled bool           `json:"disabled,omitempty"`
+	Store    string         `json:"store,omitempty"` // kv backend name, default "memory"
+	DSN      string         `json:"dsn,omitempty"`
+	Default  caddy.Duration `json:"default,omitempty"` // when the upstream gives no retry hint
+	Max      caddy.Duration `json:"max,omitempty"`     // cap on any single cooldown
+}
+
+// PluginReportConfig configures the X-Plugins-Executed report.
+type PluginReportConfig struct {
+	Format   string `json:"format"`              // structured or json
+	MaxBytes int    `json:"max_bytes,omitempty"` // header cap, default 4096
+	Debug    bool   `json:"debug,omitempty"`     // allow the full report in response bodies
+}
+
+// StickyRoutingConfig configures conversation affinity: the provider that
+// served a conversation is tried first for its next turns, while it stays
+// healthy and in the candidates.
+type StickyRoutingConfig struct {
+	Store string         `json:"store,omitempty"` // kv backend name, default "memory"
+	DSN   string         `json:"dsn,omitempty"`
+	TTL   caddy.Duration `json:"ttl,omitempty"` // how long after its last turn a conversation is remembered
+}
+
+const defaultStickyRoutingTTL = time.Hour
+
+// HedgingConfig configures hedged requests: one that has not produced a
+// first token within Delay is also sent to the next provider, and the
+// first to answer serves it. Budget bounds the duplicates, in hedges per
+// request.
+type HedgingConfig struct {
+	Delay  caddy.Duration `json:"delay,omitempty"`  // default 2s
+	Budget float64        `json:"budget,omitempty"` // default 0.05, one request in twenty
+}
+
+const (
+	defaultHedgingDelay  = 2 * time.Second
+	defaultHedgingBudget = 0.05
+)
+
+const (
+	defaultCooldown    = 10 * time.Second
+	defaultCooldownMax = 5 * time.Minute
+)
+
+// UsageAccountingConfig configures per-key usage aggregation.
+type UsageAccountingConfig struct {
+	Store         string         `json:"store,omitempty"` // kv backend name, default "memory"
+	DSN           string         `json:"dsn,omitempty"`
+	FlushInterval caddy.Duration `json:"flush_interval,omitempty"` // default 10s
+	Retention     caddy.Duration `json:"retention,omitempty"`      // how long daily buckets are kept, default 90d
+}
+
+const (
+	defaultUsageFlushInterval = 10 * time.Second
+	defaultUsageRetention     = 90 * 24 * time.Hour
+)
+
+// WebhooksConfig configures signed event delivery to external URLs.
+type WebhooksConfig struct {
+	Endpoints   []services.WebhookEndpoint `json:"endpoints,omitempty"`
+	Secret      string                     `json:"secret,omitempty"`       // HMAC-SHA256 signing key
+	MaxAttempts int                        `json:"max_attempts,omitempty"` // default 5
+	Backoff     caddy.Duration             `json:"backoff,omitempty"`      // first retry delay, default 1s
+	Timeout     caddy.Duration             `json:"timeout,omitempty"`      // per attempt, default 10s
+}
+
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Second
+	defaultWebhookTimeout  = 10 * time.Second
+)
+
+// RemotePlugin configures a plugin served by a sidecar; see plugins.Remote
+// for the protocol.
+type RemotePlugin struct {
+	URL       string         `json:"url,omitempty"`
+	Hooks     []string       `json:"hooks,omitempty"`     // before, after, chunk; default before and after
+	Timeout   caddy.Duration `json:"timeout,omitempty"`   // per call, default 5s
+	FailOpen  bool           `json:"fail_open,omitempty"` // pass programs through when the sidecar fails
+	Requires  []string       `json:"requires,omitempty"`  // plugins that must run alongside it
+	Conflicts []string       `json:"conflicts,omitempty"` // plugins that must not
 }
 
 // ProviderConfig defines a provider's configuration.
@@ -58,7 +234,66 @@ type ProviderConfig struct {
 	ModelMappings map[string]string `json:"model_mappings,omitempty"` // For virtual providers: maps model name to target model spec
 	Exports       []string          `json:"exports,omitempty"`        // Optional: restrict which models this provider exposes
 	Private       bool              `json:"private,omitempty"`        // Mark provider as completely hidden; only usable as virtual upstream
-	Impl          services.ProviderService
+
+	RateLimit       services.RateLimitConfig            `json:"rate_limit,omitempty"`        // Provider-wide RPM/TPM budget
+	ModelRateLimits map[string]services.RateLimitConfig `json:"model_rate_limits,omitempty"` // Per-upstream-model RPM/TPM budgets
+	RateLimitWait   caddy.Duration                      `json:"rate_limit_wait,omitempty"`   // Max time to queue a request before rejecting
+
+	MaxConcurrency  int            `json:"max_concurrency,omitempty"`  // Max in-flight requests; 0 = unlimited
+	ConcurrencyWait caddy.Duration `json:"concurrency_wait,omitempty"` // Grace period to wait for a free slot before falling through
+
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"` // Optional active health probing
+
+	MappingsSource *MappingsSource `json:"mappings_source,omitempty"` // For virtual providers: mappings reloaded from a URL or kv prefix
+	StickyTargets  bool            `json:"sticky_targets,omitempty"`  // For virtual providers: pick weighted targets by conversation
+
+	ModelParams map[string]*virtual.Params `json:"model_params,omitempty"` // For virtual providers: generation parameters a model sets
+
+	Prices  map[string]services.ModelPrice `json:"prices,omitempty"`  // Model (or "*") → USD per 1M tokens; overrides the built-in catalog
+	Quality map[string]int                 `json:"quality,omitempty"` // Model (or "*") → quality tier, higher is better
+
+	Timeouts   *TimeoutsConfig   `json:"timeouts,omitempty"`    // Optional upstream request timeouts
+	HTTPClient *HTTPClientConfig `json:"http_client,omitempty"` // Optional connection pool and proxy settings
+
+	StructuredOutputs string `json:"structured_outputs,omitempty"` // "grammar" sends response_format as a GBNF grammar (llama.cpp)
+	ToolChoice        string `json:"tool_choice,omitempty"`        // "emulate" retries forced tool choices the upstream cannot honour
+
+	Synthetic *SyntheticConfig `json:"synthetic,omitempty"` // For synthetic providers: the stand-in upstream's answers
+
+	EmbeddingsBatch       int `json:"embeddings_batch,omitempty"`       // Most inputs per upstream embeddings request, default 2048
+	EmbeddingsConcurrency int `json:"embeddings_concurrency,omitempty"` // Embeddings batches sent at once, default 4
+
+	SafetySettings []google.SafetySetting `json:"safety_settings,omitempty"` // For google-genai providers: default safetySettings
+
+	Impl services.ProviderService
+}
+
+// TimeoutsConfig bounds upstream requests to a provider.
+type TimeoutsConfig struct {
+	Connect        caddy.Duration `json:"connect,omitempty"`         // dial + TLS handshake, default 10s
+	FirstByte      caddy.Duration `json:"first_byte,omitempty"`      // response headers, or first stream event
+	Total          caddy.Duration `json:"total,omitempty"`           // whole request including the streamed body
+	Adaptive       bool           `json:"adaptive,omitempty"`        // derive first_byte from observed p95
+	AdaptiveFactor float64        `json:"adaptive_factor,omitempty"` // p95 multiplier, default 3
+	AdaptiveMin    caddy.Duration `json:"adaptive_min,omitempty"`    // floor for the adaptive timeout, default 1s
+}
+
+// SyntheticConfig shapes the answers of a synthetic provider, a stand-in
+// upstream for load tests; see synthetic.Config.
+type SyntheticConfig struct {
+	Tokens          int            `json:"tokens,omitempty"`            // completion length, default 128
+	TokensPerSecond float64        `json:"tokens_per_second,omitempty"` // pace; unpaced when 0
+	FirstToken      caddy.Duration `json:"first_token,omitempty"`       // delay before the first token
+	ErrorRate       float64        `json:"error_rate,omitempty"`        // fraction answered with a 500
+	Models          []string       `json:"models,omitempty"`            // listed models, default "synthetic"
+}
+
+// HTTPClientConfig tunes the connections to a provider.
+type HTTPClientConfig struct {
+	MaxIdleConns int            `json:"max_idle_conns,omitempty"` // idle connections kept to the upstream, default 32
+	IdleTimeout  caddy.Duration `json:"idle_timeout,omitempty"`   // how long an idle connection is kept, default 90s
+	Proxy        string         `json:"proxy,omitempty"`          // proxy URL, or "off"; default HTTP(S)_PROXY from the environment
+	Protocol     string         `json:"protocol,omitempty"`       // http1, http2 or http3; default HTTP/2 where TLS negotiates it
 }
 
 func ParseRouterModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
@@ -121,56 +356,1334 @@ func (m *RouterModule) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 				for d.NextBlock(1) {
 					switch d.Val() {
-					case "api_base_url":
+					case "api_base_url":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.APIBaseURL = d.Val()
+					case "style":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.Style = strings.ToLower(d.Val())
+					case "model":
+						// model <virtual_name> <target_model>
+						// model <virtual_name> <target_model> <weight> [<target_model> <weight> ...]
+						// For virtual providers: maps a model name to a target model spec
+						// Target can include plugins via + syntax, e.g. "openai/gpt-4+models:gpt-4.1,gpt-3.5"
+						// Several weighted targets split the model's traffic between them;
+						// several unweighted ones are tried in order. A target may carry
+						// conditions after "?", e.g. "openai/gpt-4.1?days=mon-fri,hours=09:00-18:00"
+						// or "openai/gpt-4.1?healthy" (see virtual.ParseCondition).
+						args := d.RemainingArgs()
+						if len(args) < 2 {
+							return d.Errf("model expects <virtual_name> <target_model> [<weight> ...], got %d args", len(args))
+						}
+						virtualName := args[0]
+						targetModel := strings.Join(args[1:], " ")
+						if _, _, err := virtual.ParseTargets(targetModel); err != nil {
+							return d.Errf("provider %s: model %s: %v", providerName, virtualName, err)
+						}
+						p.ModelMappings[virtualName] = targetModel
+					case "params":
+						// params <virtual_name> {
+						//     temperature      <float>
+						//     top_p            <float>
+						//     max_tokens       <int>
+						//     reasoning_effort <none|minimal|low|medium|high>
+						//     system_prompt    <text>
+						// }
+						// For virtual providers: generation parameters set on every
+						// request for the model, overriding the client's.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						modelName := d.Val()
+						params := &virtual.Params{}
+						for d.NextBlock(2) {
+							opt := d.Val()
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							switch opt {
+							case "temperature", "top_p":
+								f, err := strconv.ParseFloat(d.Val(), 64)
+								if err != nil || f < 0 {
+									return d.Errf("provider %s: params %s: invalid %s '%s'", providerName, modelName, opt, d.Val())
+								}
+								if opt == "temperature" {
+									params.Temperature = &f
+								} else {
+									params.TopP = &f
+								}
+							case "max_tokens":
+								n, err := strconv.Atoi(d.Val())
+								if err != nil || n <= 0 {
+									return d.Errf("provider %s: params %s: invalid max_tokens '%s'", providerName, modelName, d.Val())
+								}
+								params.MaxTokens = &n
+							case "reasoning_effort":
+								switch d.Val() {
+								case "none", "minimal", "low", "medium", "high":
+									params.ReasoningEffort = d.Val()
+								default:
+									return d.Errf("provider %s: params %s: invalid reasoning_effort '%s'", providerName, modelName, d.Val())
+								}
+							case "system_prompt":
+								params.SystemPrompt = d.Val()
+							default:
+								return d.Errf("unrecognized params option '%s' for provider '%s'", opt, providerName)
+							}
+							if d.NextArg() {
+								return d.ArgErr()
+							}
+						}
+						if p.ModelParams == nil {
+							p.ModelParams = make(map[string]*virtual.Params)
+						}
+						p.ModelParams[modelName] = params
+					case "synthetic":
+						// synthetic {
+						//     tokens            <n>          # completion length, capped by max_tokens; default 128
+						//     tokens_per_second <rate>       # unpaced when 0 (the default)
+						//     first_token       <duration>   # delay before the first token
+						//     error_rate        <fraction>   # answered with a 500, e.g. 0.01 or 1%
+						//     models            <id...>      # listed by /models; default synthetic
+						// }
+						// For synthetic providers (style synthetic): the router
+						// answers chat completions itself, with text that is the
+						// same for the same messages, to load-test the pipeline
+						// without spending provider credits.
+						sc := &SyntheticConfig{}
+						for d.NextBlock(2) {
+							switch opt := d.Val(); opt {
+							case "tokens":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								n, err := strconv.Atoi(d.Val())
+								if err != nil || n <= 0 {
+									return d.Errf("provider %s: invalid synthetic tokens '%s'", providerName, d.Val())
+								}
+								sc.Tokens = n
+							case "tokens_per_second":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								rate, err := strconv.ParseFloat(d.Val(), 64)
+								if err != nil || rate < 0 {
+									return d.Errf("provider %s: invalid synthetic tokens_per_second '%s'", providerName, d.Val())
+								}
+								sc.TokensPerSecond = rate
+							case "first_token":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								dur, err := caddy.ParseDuration(d.Val())
+								if err != nil || dur < 0 {
+									return d.Errf("provider %s: invalid synthetic first_token '%s'", providerName, d.Val())
+								}
+								sc.FirstToken = caddy.Duration(dur)
+							case "error_rate":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								rate, err := parseFraction(d.Val())
+								if err != nil {
+									return d.Errf("provider %s: invalid synthetic error_rate '%s'", providerName, d.Val())
+								}
+								sc.ErrorRate = rate
+							case "models":
+								sc.Models = d.RemainingArgs()
+								if len(sc.Models) == 0 {
+									return d.ArgErr()
+								}
+							default:
+								return d.Errf("unrecognized synthetic option '%s' for provider '%s'", opt, providerName)
+							}
+						}
+						p.Synthetic = sc
+					case "sticky_targets":
+						// sticky_targets
+						// For virtual providers: keeps a conversation on the same
+						// weighted target rather than picking one per request.
+						if d.NextArg() {
+							return d.ArgErr()
+						}
+						p.StickyTargets = true
+					case "exports":
+						// exports <model_id|glob|@group> [...]
+						// Restricts which models this provider exposes externally:
+						// exact IDs, globs like "gpt-4*", or "@<name>" for the
+						// models of a model_group. Can be specified multiple
+						// times; values accumulate.
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.Errf("exports requires at least one model ID")
+						}
+						p.Exports = append(p.Exports, args...)
+					case "rate_limit":
+						// rate_limit <rpm> [<tpm>]
+						// Provider-wide requests/tokens per minute. 0 disables a limit.
+						args := d.RemainingArgs()
+						cfg, err := parseRateLimitArgs(args)
+						if err != nil {
+							return d.Errf("provider %s: rate_limit: %v", providerName, err)
+						}
+						p.RateLimit = cfg
+					case "model_rate_limit":
+						// model_rate_limit <model> <rpm> [<tpm>]
+						// Budget for a single upstream model on this provider,
+						// enforced in addition to the provider-wide limit.
+						args := d.RemainingArgs()
+						if len(args) < 2 {
+							return d.Errf("model_rate_limit expects <model> <rpm> [<tpm>], got %d args", len(args))
+						}
+						cfg, err := parseRateLimitArgs(args[1:])
+						if err != nil {
+							return d.Errf("provider %s: model_rate_limit %s: %v", providerName, args[0], err)
+						}
+						if p.ModelRateLimits == nil {
+							p.ModelRateLimits = make(map[string]services.RateLimitConfig)
+						}
+						p.ModelRateLimits[args[0]] = cfg
+					case "rate_limit_wait":
+						// rate_limit_wait <duration>
+						// How long a request may queue for budget before it is rejected.
+						// The first rate-limited provider tried fixes the budget for
+						// the whole request; later fallbacks share what remains.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("provider %s: invalid rate_limit_wait '%s': %v", providerName, d.Val(), err)
+						}
+						p.RateLimitWait = caddy.Duration(dur)
+					case "max_concurrency":
+						// max_concurrency <n>
+						// Caps in-flight requests to this provider.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n < 0 {
+							return d.Errf("provider %s: invalid max_concurrency '%s'", providerName, d.Val())
+						}
+						p.MaxConcurrency = n
+					case "concurrency_wait":
+						// concurrency_wait <duration>
+						// How long to wait for a free slot before trying the next provider.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("provider %s: invalid concurrency_wait '%s': %v", providerName, d.Val(), err)
+						}
+						p.ConcurrencyWait = caddy.Duration(dur)
+					case "health_check":
+						// health_check <tcp|list_models|completion> { ... }
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						hc := &HealthCheckConfig{Probe: strings.ToLower(d.Val())}
+						switch hc.Probe {
+						case HealthProbeTCP, HealthProbeListModels, HealthProbeCompletion:
+						default:
+							return d.Errf("provider %s: unknown health_check probe '%s'", providerName, hc.Probe)
+						}
+						for d.NextBlock(2) {
+							switch d.Val() {
+							case "interval", "timeout":
+								opt := d.Val()
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								dur, err := caddy.ParseDuration(d.Val())
+								if err != nil {
+									return d.Errf("provider %s: invalid health_check %s '%s': %v", providerName, opt, d.Val(), err)
+								}
+								if opt == "interval" {
+									hc.Interval = caddy.Duration(dur)
+								} else {
+									hc.Timeout = caddy.Duration(dur)
+								}
+							case "model":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								hc.Model = d.Val()
+							case "unhealthy_after":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								n, err := strconv.Atoi(d.Val())
+								if err != nil || n <= 0 {
+									return d.Errf("provider %s: invalid health_check unhealthy_after '%s'", providerName, d.Val())
+								}
+								hc.UnhealthyAfter = n
+							default:
+								return d.Errf("unrecognized health_check option '%s' for provider '%s'", d.Val(), providerName)
+							}
+						}
+						if hc.Probe == HealthProbeCompletion && hc.Model == "" {
+							return d.Errf("provider %s: health_check completion requires a model", providerName)
+						}
+						p.HealthCheck = hc
+					case "mappings_source":
+						// mappings_source url <url> { ... }
+						// mappings_source kv <backend> <prefix> [<dsn>] { ... }
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						src := &MappingsSource{Kind: strings.ToLower(args[0])}
+						switch {
+						case src.Kind == MappingsSourceURL && len(args) == 2:
+							src.URL = args[1]
+						case src.Kind == MappingsSourceKV && (len(args) == 3 || len(args) == 4):
+							src.Backend, src.Prefix = args[1], args[2]
+							if len(args) == 4 {
+								src.DSN = args[3]
+							}
+						default:
+							return d.Errf("provider %s: mappings_source expects 'url <url>' or 'kv <backend> <prefix> [<dsn>]'", providerName)
+						}
+						for d.NextBlock(2) {
+							switch d.Val() {
+							case "interval", "timeout":
+								opt := d.Val()
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								dur, err := caddy.ParseDuration(d.Val())
+								if err != nil {
+									return d.Errf("provider %s: invalid mappings_source %s '%s': %v", providerName, opt, d.Val(), err)
+								}
+								if opt == "interval" {
+									src.Interval = caddy.Duration(dur)
+								} else {
+									src.Timeout = caddy.Duration(dur)
+								}
+							default:
+								return d.Errf("unrecognized mappings_source option '%s' for provider '%s'", d.Val(), providerName)
+							}
+						}
+						p.MappingsSource = src
+					case "price":
+						// price <model|*> <input_usd_per_1m> <output_usd_per_1m> [<cached_input_usd_per_1m>]
+						// Overrides the built-in catalog (services.PriceCatalog).
+						args := d.RemainingArgs()
+						if len(args) != 3 && len(args) != 4 {
+							return d.Errf("provider %s: price expects <model> <input> <output> [<cached_input>], got %d args", providerName, len(args))
+						}
+						var mp services.ModelPrice
+						var errIn, errOut, errCached error
+						mp.Input, errIn = strconv.ParseFloat(args[1], 64)
+						mp.Output, errOut = strconv.ParseFloat(args[2], 64)
+						if len(args) == 4 {
+							mp.CachedInput, errCached = strconv.ParseFloat(args[3], 64)
+						}
+						if errIn != nil || errOut != nil || errCached != nil || mp.Input < 0 || mp.Output < 0 || mp.CachedInput < 0 {
+							return d.Errf("provider %s: invalid price for %s", providerName, args[0])
+						}
+						if p.Prices == nil {
+							p.Prices = make(map[string]services.ModelPrice)
+						}
+						p.Prices[args[0]] = mp
+					case "quality":
+						// quality <tier> [<model> ...]
+						// Quality tier for the quality routing strategy; higher is
+						// better. Without models it applies to every model.
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						tier, err := strconv.Atoi(args[0])
+						if err != nil {
+							return d.Errf("provider %s: invalid quality tier '%s'", providerName, args[0])
+						}
+						if p.Quality == nil {
+							p.Quality = make(map[string]int)
+						}
+						models := args[1:]
+						if len(models) == 0 {
+							models = []string{"*"}
+						}
+						for _, model := range models {
+							p.Quality[model] = tier
+						}
+					case "timeouts":
+						// timeouts {
+						//     connect    <duration>            # dial + TLS handshake, default 10s
+						//     first_byte <duration>            # headers, or first event when streaming
+						//     total      <duration>            # whole request, including the stream
+						//     adaptive   [<factor>] [<min>]    # first_byte = observed p95 × factor (default 3),
+						//                                      # at least min (default 1s), at most first_byte
+						// }
+						// A provider that misses a timeout fails and the next one is tried.
+						tc := &TimeoutsConfig{}
+						for d.NextBlock(2) {
+							switch opt := d.Val(); opt {
+							case "connect", "first_byte", "total":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								dur, err := caddy.ParseDuration(d.Val())
+								if err != nil || dur < 0 {
+									return d.Errf("provider %s: invalid timeouts %s '%s'", providerName, opt, d.Val())
+								}
+								switch opt {
+								case "connect":
+									tc.Connect = caddy.Duration(dur)
+								case "first_byte":
+									tc.FirstByte = caddy.Duration(dur)
+								default:
+									tc.Total = caddy.Duration(dur)
+								}
+							case "adaptive":
+								args := d.RemainingArgs()
+								if len(args) > 2 {
+									return d.Errf("provider %s: timeouts adaptive expects [<factor>] [<min>], got %d args", providerName, len(args))
+								}
+								tc.Adaptive = true
+								if len(args) > 0 {
+									f, err := strconv.ParseFloat(args[0], 64)
+									if err != nil || f <= 0 {
+										return d.Errf("provider %s: invalid timeouts adaptive factor '%s'", providerName, args[0])
+									}
+									tc.AdaptiveFactor = f
+								}
+								if len(args) > 1 {
+									dur, err := caddy.ParseDuration(args[1])
+									if err != nil || dur <= 0 {
+										return d.Errf("provider %s: invalid timeouts adaptive min '%s'", providerName, args[1])
+									}
+									tc.AdaptiveMin = caddy.Duration(dur)
+								}
+							default:
+								return d.Errf("unrecognized timeouts option '%s' for provider '%s'", opt, providerName)
+							}
+						}
+						p.Timeouts = tc
+					case "http_client":
+						// http_client {
+						//     max_idle_conns <n>           # idle connections kept to the upstream, default 32
+						//     idle_timeout   <duration>    # default 90s
+						//     proxy          <url|off>     # http, https or socks5; default HTTP(S)_PROXY
+						//     protocol       <auto|http1|http2|http3>
+						// }
+						// protocol auto (the default) speaks HTTP/2 where TLS
+						// negotiates it and HTTP/1.1 otherwise; http2 also speaks
+						// it to plain http upstreams; http3 goes over QUIC to
+						// https upstreams, without a proxy.
+						hc := &HTTPClientConfig{}
+						for d.NextBlock(2) {
+							switch opt := d.Val(); opt {
+							case "max_idle_conns":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								n, err := strconv.Atoi(d.Val())
+								if err != nil || n <= 0 {
+									return d.Errf("provider %s: invalid http_client max_idle_conns '%s'", providerName, d.Val())
+								}
+								hc.MaxIdleConns = n
+							case "idle_timeout":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								dur, err := caddy.ParseDuration(d.Val())
+								if err != nil || dur <= 0 {
+									return d.Errf("provider %s: invalid http_client idle_timeout '%s'", providerName, d.Val())
+								}
+								hc.IdleTimeout = caddy.Duration(dur)
+							case "proxy":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								if _, err := parseProxy(d.Val()); err != nil {
+									return d.Errf("provider %s: %v", providerName, err)
+								}
+								hc.Proxy = d.Val()
+							case "protocol":
+								if !d.NextArg() {
+									return d.ArgErr()
+								}
+								switch proto := strings.ToLower(d.Val()); proto {
+								case "auto":
+									hc.Protocol = services.ProtocolAuto
+								case services.ProtocolHTTP1, services.ProtocolHTTP2, services.ProtocolHTTP3:
+									hc.Protocol = proto
+								default:
+									return d.Errf("provider %s: unrecognized http_client protocol '%s'", providerName, d.Val())
+								}
+							default:
+								return d.Errf("unrecognized http_client option '%s' for provider '%s'", opt, providerName)
+							}
+						}
+						p.HTTPClient = hc
+					case "structured_outputs":
+						// structured_outputs <native|grammar>
+						// How response_format reaches the upstream. native (the
+						// default) maps it onto the style's own mechanism; grammar
+						// sends a GBNF grammar instead, for llama.cpp servers.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						switch mode := strings.ToLower(d.Val()); mode {
+						case "native":
+							p.StructuredOutputs = ""
+						case drivers.StructuredOutputsGrammar:
+							p.StructuredOutputs = mode
+						default:
+							return d.Errf("unrecognized structured_outputs mode '%s' for provider '%s'", d.Val(), providerName)
+						}
+					case "tool_choice":
+						// tool_choice <native|emulate>
+						// How forced tool use ("required", or a named function)
+						// reaches the upstream. native (the default) maps it onto
+						// the style's own tool_choice; emulate sends "auto" and
+						// retries until the model calls a tool, for servers that
+						// lack forced tool use.
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						switch mode := strings.ToLower(d.Val()); mode {
+						case "native":
+							p.ToolChoice = ""
+						case drivers.ToolChoiceEmulate:
+							p.ToolChoice = mode
+						default:
+							return d.Errf("unrecognized tool_choice mode '%s' for provider '%s'", d.Val(), providerName)
+						}
+					case "embeddings_batch":
+						// embeddings_batch <size> [<concurrency>]
+						// Most inputs the upstream takes in one embeddings request;
+						// longer input lists are split into batches, up to
+						// <concurrency> (default 4) of them sent at once.
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 2 {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(args[0])
+						if err != nil || n <= 0 {
+							return d.Errf("provider %s: invalid embeddings_batch size '%s'", providerName, args[0])
+						}
+						p.EmbeddingsBatch = n
+						if len(args) == 2 {
+							if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+								return d.Errf("provider %s: invalid embeddings_batch concurrency '%s'", providerName, args[1])
+							}
+							p.EmbeddingsConcurrency = n
+						}
+					case "safety_settings":
+						// safety_settings <threshold>|<category>=<threshold>...
+						// Safety settings of google-genai providers, sent with
+						// requests that carry none of their own. A bare threshold
+						// (block_none, block_only_high, block_medium_and_above,
+						// block_low_and_above, off) applies to every category;
+						// category=threshold pairs, such as
+						// dangerous_content=block_only_high, override it.
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						settings, err := google.ParseSafetySettings(args)
+						if err != nil {
+							return d.Errf("provider %s: safety_settings: %v", providerName, err)
+						}
+						p.SafetySettings = settings
+					case "private":
+						// private
+						// Marks this provider as completely hidden from external access.
+						// No models are returned by /models and direct inference is rejected.
+						// The provider can still be used as an upstream target for virtual providers.
+						p.Private = true
+					default:
+						return d.Errf("unrecognized provider option '%s' for provider '%s'", d.Val(), providerName)
+					}
+				}
+				// private and exports are mutually exclusive
+				if p.Private && len(p.Exports) > 0 {
+					return d.Errf("provider %s: 'private' and 'exports' are mutually exclusive", providerName)
+				}
+				// Virtual and synthetic providers don't need api_base_url
+				if p.Style != "virtual" && p.Style != "synthetic" && p.APIBaseURL == "" {
+					return d.Errf("provider %s: api_base_url is required", providerName)
+				}
+				m.ProviderConfigs[providerName] = &p
+				m.ProvidersOrder = append(m.ProvidersOrder, providerName)
+			case "fair_queue":
+				// fair_queue {
+				//     max_in_flight <n>
+				//     max_queue     <n>
+				//     max_wait      <duration>     # 0 = wait indefinitely
+				//     weight        <tenant> <n>   # tenant as reported by the scheduler, e.g. ip:10.0.0.1
+				// }
+				fq := &FairQueueConfig{Weights: make(map[string]int)}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "max_in_flight", "max_queue":
+						opt := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n < 0 {
+							return d.Errf("fair_queue: invalid %s '%s'", opt, d.Val())
+						}
+						if opt == "max_in_flight" {
+							fq.MaxInFlight = n
+						} else {
+							fq.MaxQueue = n
+						}
+					case "max_wait":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("fair_queue: invalid max_wait '%s': %v", d.Val(), err)
+						}
+						fq.MaxWait = caddy.Duration(dur)
+					case "weight":
+						args := d.RemainingArgs()
+						if len(args) != 2 {
+							return d.Errf("fair_queue weight expects <tenant> <n>, got %d args", len(args))
+						}
+						n, err := strconv.Atoi(args[1])
+						if err != nil || n <= 0 {
+							return d.Errf("fair_queue: invalid weight '%s' for tenant %s", args[1], args[0])
+						}
+						fq.Weights[args[0]] = n
+					default:
+						return d.Errf("unrecognized fair_queue option '%s'", d.Val())
+					}
+				}
+				if fq.MaxInFlight == 0 {
+					return d.Errf("fair_queue: max_in_flight is required")
+				}
+				m.FairQueue = fq
+			case "priorities":
+				// priorities {
+				//     default <class>
+				//     key     <class> <api_key> [...]     # e.g. {$PROD_KEY}
+				//     tenant  <class> <tenant_id> [...]   # e.g. ip:10.0.0.1
+				// }
+				// Classes are low, normal, high or a number; higher wins.
+				pc := &PrioritiesConfig{Keys: map[string]string{}, Tenants: map[string]string{}}
+				for d.NextBlock(1) {
+					switch opt := d.Val(); opt {
+					case "default":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						if _, err := services.ParsePriority(d.Val()); err != nil {
+							return d.Errf("priorities: %v", err)
+						}
+						pc.Default = d.Val()
+					case "key", "tenant":
+						args := d.RemainingArgs()
+						if len(args) < 2 {
+							return d.Errf("priorities %s expects <class> <id> [...], got %d args", opt, len(args))
+						}
+						if _, err := services.ParsePriority(args[0]); err != nil {
+							return d.Errf("priorities: %v", err)
+						}
+						for _, id := range args[1:] {
+							if opt == "key" {
+								pc.Keys[id] = args[0]
+							} else {
+								pc.Tenants[id] = args[0]
+							}
+						}
+					default:
+						return d.Errf("unrecognized priorities option '%s'", opt)
+					}
+				}
+				m.Priorities = pc
+			case "load_shed":
+				// load_shed {
+				//     max_goroutines <n>
+				//     max_in_flight  <n>
+				//     max_heap_mb    <n>
+				//     retry_after    <duration>   # advertised to shed clients, default 1s
+				// }
+				ls := &LoadShedConfig{}
+				for d.NextBlock(1) {
+					switch opt := d.Val(); opt {
+					case "max_goroutines", "max_in_flight", "max_heap_mb":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n < 0 {
+							return d.Errf("load_shed: invalid %s '%s'", opt, d.Val())
+						}
+						switch opt {
+						case "max_goroutines":
+							ls.MaxGoroutines = n
+						case "max_in_flight":
+							ls.MaxInFlight = n
+						default:
+							ls.MaxHeapMB = n
+						}
+					case "retry_after":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("load_shed: invalid retry_after '%s': %v", d.Val(), err)
+						}
+						ls.RetryAfter = caddy.Duration(dur)
+					default:
+						return d.Errf("unrecognized load_shed option '%s'", opt)
+					}
+				}
+				m.LoadShed = ls
+			case "cooldown":
+				// cooldown off
+				// cooldown {
+				//     store   <backend> [<dsn>]   # kv backend shared across instances, default memory
+				//     default <duration>          # when a 429 has no retry hint, default 10s
+				//     max     <duration>          # cap, default 5m
+				// }
+				cd := &CooldownConfig{}
+				if d.NextArg() {
+					if d.Val() != "off" {
+						return d.Errf("cooldown: expected 'off' or a block, got '%s'", d.Val())
+					}
+					cd.Disabled = true
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "store":
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 2 {
+							return d.Errf("cooldown store expects <backend> [<dsn>], got %d args", len(args))
+						}
+						cd.Store = args[0]
+						if len(args) == 2 {
+							cd.DSN = args[1]
+						}
+					case "default", "max":
+						opt := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("cooldown: invalid %s '%s': %v", opt, d.Val(), err)
+						}
+						if opt == "default" {
+							cd.Default = caddy.Duration(dur)
+						} else {
+							cd.Max = caddy.Duration(dur)
+						}
+					default:
+						return d.Errf("unrecognized cooldown option '%s'", d.Val())
+					}
+				}
+				m.Cooldown = cd
+			case "sticky_routing":
+				// sticky_routing [<ttl>]
+				// sticky_routing {
+				//     ttl   <duration>          # remembered after a conversation's last turn, default 1h
+				//     store <backend> [<dsn>]   # kv backend shared across instances, default memory
+				// }
+				// Conversations are told apart by X-Conversation-Id, or else by
+				// the hash of their system prompt and first user message.
+				sr := &StickyRoutingConfig{}
+				if d.NextArg() {
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil || dur <= 0 {
+						return d.Errf("sticky_routing: invalid ttl '%s'", d.Val())
+					}
+					sr.TTL = caddy.Duration(dur)
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "ttl":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil || dur <= 0 {
+							return d.Errf("sticky_routing: invalid ttl '%s'", d.Val())
+						}
+						sr.TTL = caddy.Duration(dur)
+					case "store":
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 2 {
+							return d.Errf("sticky_routing store expects <backend> [<dsn>], got %d args", len(args))
+						}
+						sr.Store = args[0]
+						if len(args) == 2 {
+							sr.DSN = args[1]
+						}
+					default:
+						return d.Errf("unrecognized sticky_routing option '%s'", d.Val())
+					}
+				}
+				m.StickyRouting = sr
+			case "hedging":
+				// hedging [<delay>]
+				// hedging {
+				//     delay  <duration>   # without a first token, default 2s
+				//     budget <fraction>   # hedges per request, e.g. 0.05 or 5%
+				// }
+				// A request slow to its first token also goes to the next
+				// provider; the first to answer serves it, the other is
+				// cancelled.
+				hc := &HedgingConfig{}
+				if d.NextArg() {
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil || dur <= 0 {
+						return d.Errf("hedging: invalid delay '%s'", d.Val())
+					}
+					hc.Delay = caddy.Duration(dur)
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "delay":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil || dur <= 0 {
+							return d.Errf("hedging: invalid delay '%s'", d.Val())
+						}
+						hc.Delay = caddy.Duration(dur)
+					case "budget":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						b, err := parseFraction(d.Val())
+						if err != nil {
+							return d.Errf("hedging: invalid budget '%s'", d.Val())
+						}
+						hc.Budget = b
+					default:
+						return d.Errf("unrecognized hedging option '%s'", d.Val())
+					}
+				}
+				m.Hedging = hc
+			case "access_log":
+				// access_log
+				// Emits one line per client request (key, requested and served
+				// model, provider, latency, tokens, finish reason, plugins) on
+				// the logger http.handlers.ai_router.access. Route it with a
+				// global log block, e.g.
+				//     log access { include http.handlers.ai_router.access; output file access.log; format json }
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.AccessLog = true
+			case "usage_accounting":
+				// usage_accounting {
+				//     store          <backend> [<dsn>]   # kv backend, default memory
+				//     flush_interval <duration>          # default 10s
+				//     retention      <duration>          # default 90d
+				// }
+				// Aggregates requests and tokens per key, provider, model and
+				// day; served by the ai_usage handler and GET /ai/usage.
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				ua := &UsageAccountingConfig{}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "store":
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 2 {
+							return d.Errf("usage_accounting store expects <backend> [<dsn>], got %d args", len(args))
+						}
+						ua.Store = args[0]
+						if len(args) == 2 {
+							ua.DSN = args[1]
+						}
+					case "flush_interval", "retention":
+						opt := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil || dur <= 0 {
+							return d.Errf("usage_accounting: invalid %s '%s'", opt, d.Val())
+						}
+						if opt == "flush_interval" {
+							ua.FlushInterval = caddy.Duration(dur)
+						} else {
+							ua.Retention = caddy.Duration(dur)
+						}
+					default:
+						return d.Errf("unrecognized usage_accounting option '%s'", d.Val())
+					}
+				}
+				m.UsageAccounting = ua
+			case "webhooks":
+				// webhooks {
+				//     url          <url> [<event> ...]   # repeatable; no events means all
+				//     secret       <secret>              # signs deliveries (X-Webhook-Signature)
+				//     max_attempts <n>                   # default 5
+				//     backoff      <duration>            # first retry delay, doubled after each, default 1s
+				//     timeout      <duration>            # per attempt, default 10s
+				// }
+				// Events: request.completed, request.failed, budget.exceeded,
+				// provider.circuit_opened, model.deprecated.
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				wc := &WebhooksConfig{}
+				for d.NextBlock(1) {
+					switch opt := d.Val(); opt {
+					case "url":
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						if u, err := url.Parse(args[0]); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+							return d.Errf("webhooks: invalid url '%s'", args[0])
+						}
+						for _, ev := range args[1:] {
+							if !slices.Contains(services.WebhookEvents, ev) {
+								return d.Errf("webhooks: unknown event '%s'", ev)
+							}
+						}
+						wc.Endpoints = append(wc.Endpoints, services.WebhookEndpoint{URL: args[0], Events: args[1:]})
+					case "secret":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						wc.Secret = d.Val()
+					case "max_attempts":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n <= 0 {
+							return d.Errf("webhooks: invalid max_attempts '%s'", d.Val())
+						}
+						wc.MaxAttempts = n
+					case "backoff", "timeout":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil || dur <= 0 {
+							return d.Errf("webhooks: invalid %s '%s'", opt, d.Val())
+						}
+						if opt == "backoff" {
+							wc.Backoff = caddy.Duration(dur)
+						} else {
+							wc.Timeout = caddy.Duration(dur)
+						}
+					default:
+						return d.Errf("unrecognized webhooks option '%s'", opt)
+					}
+				}
+				if len(wc.Endpoints) == 0 {
+					return d.Errf("webhooks: at least one url is required")
+				}
+				m.Webhooks = wc
+			case "remote_plugin":
+				// remote_plugin <name> <url> {
+				//     hooks     <before|after|chunk>...  # default before after
+				//     timeout   <duration>               # per call, default 5s
+				//     on_error  <open|closed>            # default closed
+				//     requires  <plugin>...              # must run alongside it
+				//     conflicts <plugin>...              # must not run alongside it
+				// }
+				// Registers a plugin forwarding its hooks, as binary AIL, to
+				// a sidecar; use it by name like any other plugin.
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				if u, err := url.Parse(args[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					return d.Errf("remote_plugin %s: invalid url '%s'", args[0], args[1])
+				}
+				rp := &RemotePlugin{URL: args[1]}
+				for d.NextBlock(1) {
+					switch opt := d.Val(); opt {
+					case "hooks":
+						rp.Hooks = d.RemainingArgs()
+						if len(rp.Hooks) == 0 {
+							return d.ArgErr()
+						}
+						for _, h := range rp.Hooks {
+							if h != plugins.RemoteHookBefore && h != plugins.RemoteHookAfter && h != plugins.RemoteHookChunk {
+								return d.Errf("remote_plugin %s: unknown hook '%s'", args[0], h)
+							}
+						}
+					case "timeout":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(d.Val())
+						if err != nil || dur <= 0 {
+							return d.Errf("remote_plugin %s: invalid timeout '%s'", args[0], d.Val())
+						}
+						rp.Timeout = caddy.Duration(dur)
+					case "on_error":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						switch d.Val() {
+						case "open":
+							rp.FailOpen = true
+						case "closed":
+							rp.FailOpen = false
+						default:
+							return d.Errf("remote_plugin %s: on_error must be open or closed", args[0])
+						}
+					case "requires":
+						rp.Requires = d.RemainingArgs()
+						if len(rp.Requires) == 0 {
+							return d.ArgErr()
+						}
+					case "conflicts":
+						rp.Conflicts = d.RemainingArgs()
+						if len(rp.Conflicts) == 0 {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unrecognized remote_plugin option '%s'", opt)
+					}
+				}
+				if m.RemotePlugins == nil {
+					m.RemotePlugins = make(map[string]*RemotePlugin)
+				}
+				m.RemotePlugins[args[0]] = rp
+			case "policy_file":
+				// policy_file <path>
+				// JSON Policy (virtual mappings, presets, prices) applied at
+				// start and again whenever the file changes, without a
+				// config reload; see Policy.
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.PolicyFile = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "sse_keepalive":
+				// sse_keepalive <duration|off>
+				// Writes a ":keepalive" comment to a stream that has been
+				// silent this long (slow upstream, tool loop, DSPy sidecar)
+				// so proxies don't drop it. Default 15s.
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var interval caddy.Duration
+				if d.Val() != "off" {
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil || dur < 0 {
+						return d.Errf("invalid sse_keepalive '%s'", d.Val())
+					}
+					interval = caddy.Duration(dur)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.SSEKeepalive = &interval
+			case "preset":
+				// preset <name> <plugin[:params][@matcher]>[+...] [...]
+				// Names a plugin list that routes (plugins option), virtual
+				// model targets, paths and model strings can use as
+				// "preset:<name>", e.g. "gpt-4o+preset:safe". See
+				// plugin.Matcher for conditions on when a plugin runs.
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.Errf("preset expects <name> <plugin> [<plugin>...], got %d args", len(args))
+				}
+				var specs []string
+				for _, arg := range args[1:] {
+					for _, spec := range strings.Split(arg, "+") {
+						if spec == "" {
+							return d.Errf("preset %s: empty plugin in '%s'", args[0], arg)
+						}
+						specs = append(specs, spec)
+					}
+				}
+				if m.Presets == nil {
+					m.Presets = make(map[string][]string)
+				}
+				m.Presets[args[0]] = specs
+			case "preflight":
+				// preflight [compact]
+				// Checks each request against the catalog entry (model_info)
+				// of the model about to serve it: prompt size against the
+				// context window, images and audio against its modalities,
+				// tool definitions against tool support. A request that
+				// cannot fit fails with context_length_exceeded, or with
+				// compact has its oldest messages dropped until it does.
+				args := d.RemainingArgs()
+				switch {
+				case len(args) == 0:
+					m.Preflight = PreflightCheck
+				case len(args) == 1 && args[0] == PreflightCompact:
+					m.Preflight = PreflightCompact
+				default:
+					return d.Errf("preflight expects [compact], got '%s'", strings.Join(args, " "))
+				}
+			case "virtual_store":
+				// virtual_store <backend> <prefix> [<dsn>]
+				// Persists virtual providers created through the admin API
+				// (/ai/virtual) in a kv backend that can list keys.
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.Errf("virtual_store expects <backend> <prefix> [<dsn>], got %d args", len(args))
+				}
+				m.VirtualStore = &VirtualStore{Backend: args[0], Prefix: args[1]}
+				if len(args) == 3 {
+					m.VirtualStore.DSN = args[2]
+				}
+			case "dspy_signature":
+				// dspy_signature <name> <signature> {
+				//     instructions <text>
+				//     field        <field> <description>
+				//     demo         <field>=<value> [...]
+				// }
+				// Names a DSPy signature for "+dspy:<kind>:@<name>", with
+				// optional instructions, field descriptions and few-shot
+				// demos. The block is optional.
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.Errf("dspy_signature expects <name> <signature>, got %d args", len(args))
+				}
+				sig := &dspy.Signature{Signature: args[1]}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "instructions":
 						if !d.NextArg() {
 							return d.ArgErr()
 						}
-						p.APIBaseURL = d.Val()
-					case "style":
-						if !d.NextArg() {
-							return d.ArgErr()
+						sig.Instructions = d.Val()
+					case "field":
+						fargs := d.RemainingArgs()
+						if len(fargs) != 2 {
+							return d.Errf("dspy_signature field expects <field> <description>, got %d args", len(fargs))
 						}
-						p.Style = strings.ToLower(d.Val())
-					case "model":
-						// model <virtual_name> <target_model>
-						// For virtual providers: maps a model name to a target model spec
-						// Target can include plugins via + syntax, e.g. "openai/gpt-4+models:gpt-4.1,gpt-3.5"
-						args := d.RemainingArgs()
-						if len(args) != 2 {
-							return d.Errf("model expects <virtual_name> <target_model>, got %d args", len(args))
+						if sig.Fields == nil {
+							sig.Fields = make(map[string]string)
 						}
-						virtualName := args[0]
-						targetModel := args[1]
-						p.ModelMappings[virtualName] = targetModel
-					case "exports":
-						// exports <model_id> [<model_id2> ...]
-						// Restricts which models this provider exposes externally.
-						// Can be specified multiple times; values accumulate.
-						args := d.RemainingArgs()
-						if len(args) == 0 {
-							return d.Errf("exports requires at least one model ID")
+						sig.Fields[fargs[0]] = fargs[1]
+					case "demo":
+						demo := make(map[string]string)
+						for _, arg := range d.RemainingArgs() {
+							k, v, ok := strings.Cut(arg, "=")
+							if !ok || k == "" {
+								return d.Errf("dspy_signature demo expects <field>=<value>, got '%s'", arg)
+							}
+							demo[k] = v
 						}
-						p.Exports = append(p.Exports, args...)
-					case "private":
-						// private
-						// Marks this provider as completely hidden from external access.
-						// No models are returned by /models and direct inference is rejected.
-						// The provider can still be used as an upstream target for virtual providers.
-						p.Private = true
+						if len(demo) == 0 {
+							return d.ArgErr()
+						}
+						sig.Demos = append(sig.Demos, demo)
 					default:
-						return d.Errf("unrecognized provider option '%s' for provider '%s'", d.Val(), providerName)
+						return d.Errf("unrecognized dspy_signature option '%s'", d.Val())
 					}
 				}
-				// private and exports are mutually exclusive
-				if p.Private && len(p.Exports) > 0 {
-					return d.Errf("provider %s: 'private' and 'exports' are mutually exclusive", providerName)
+				if m.DSPySignatures == nil {
+					m.DSPySignatures = make(map[string]*dspy.Signature)
 				}
-				// Virtual providers don't need api_base_url
-				if p.Style != "virtual" && p.APIBaseURL == "" {
-					return d.Errf("provider %s: api_base_url is required", providerName)
+				m.DSPySignatures[args[0]] = sig
+			case "dspy_signature_store":
+				// dspy_signature_store <backend> <prefix> [<dsn>]
+				// Persists DSPy signatures saved through the admin API
+				// (/ai/dspy/signatures) in a kv backend that can list keys.
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.Errf("dspy_signature_store expects <backend> <prefix> [<dsn>], got %d args", len(args))
 				}
-				m.ProviderConfigs[providerName] = &p
-				m.ProvidersOrder = append(m.ProvidersOrder, providerName)
+				m.DSPySignatureStore = &SignatureStore{Backend: args[0], Prefix: args[1]}
+				if len(args) == 3 {
+					m.DSPySignatureStore.DSN = args[2]
+				}
+			case "model_group":
+				// model_group <name> <model_id|glob> [...]
+				// Names a list of models that providers' exports can use as
+				// "@<name>". Values accumulate.
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.Errf("model_group expects <name> <model> [<model>...], got %d args", len(args))
+				}
+				if m.ModelGroups == nil {
+					m.ModelGroups = make(map[string][]string)
+				}
+				m.ModelGroups[args[0]] = append(m.ModelGroups[args[0]], args[1:]...)
+			case "model_plugins":
+				// model_plugins <model> <plugin[:params][@matcher]>[+...] [...]
+				// Attaches plugins to every request for a model, real or
+				// virtual, whatever model string the client sends, e.g.
+				// "model_plugins support-bot guard+memory". <model> may be
+				// a glob and matches with or without the provider prefix.
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.Errf("model_plugins expects <model> <plugin> [<plugin>...], got %d args", len(args))
+				}
+				var specs []string
+				for _, arg := range args[1:] {
+					for _, spec := range strings.Split(arg, "+") {
+						if spec == "" {
+							return d.Errf("model_plugins %s: empty plugin in '%s'", args[0], arg)
+						}
+						specs = append(specs, spec)
+					}
+				}
+				if m.ModelPlugins == nil {
+					m.ModelPlugins = make(map[string][]string)
+				}
+				m.ModelPlugins[args[0]] = append(m.ModelPlugins[args[0]], specs...)
+			case "plugin_priority":
+				// plugin_priority <plugin> <first|last|n>
+				// Orders a plugin among the others: lower runs first, in
+				// every phase; first is -100, last 100, and plugins default
+				// to 0 unless they declare their own.
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				var prio int
+				switch args[1] {
+				case "first":
+					prio = plugin.PriorityFirst
+				case "last":
+					prio = plugin.PriorityLast
+				default:
+					n, err := strconv.Atoi(args[1])
+					if err != nil {
+						return d.Errf("plugin_priority %s: invalid priority '%s'", args[0], args[1])
+					}
+					prio = n
+				}
+				if m.PluginPriorities == nil {
+					m.PluginPriorities = make(map[string]int)
+				}
+				m.PluginPriorities[args[0]] = prio
+			case "model_info":
+				// model_info <model> {
+				//     context_window <tokens>
+				//     max_output     <tokens>
+				//     modalities     <text|image|audio|file> ...
+				//     tools          <true|false>
+				//     price          <input_usd_per_1m> <output_usd_per_1m> [<cached_input_usd_per_1m>]
+				//     deprecated     <YYYY-MM-DD>
+				//     replaced_by    <model>
+				// }
+				// Overrides or adds to the built-in model catalog
+				// (services.ModelCatalog) the fields it sets; dated snapshots
+				// and provider-prefixed IDs of model share the entry.
+				// Requests for a deprecated model get an X-Model-Deprecated
+				// header, and from the deprecation date on go to replaced_by
+				// (keeping their provider prefix when it has none).
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				model := d.Val()
+				mi, err := parseModelInfo(d, model)
+				if err != nil {
+					return err
+				}
+				if m.ModelInfo == nil {
+					m.ModelInfo = make(map[string]services.ModelInfo)
+				}
+				m.ModelInfo[model] = mi
+			case "plugin_timings":
+				// plugin_timings
+				// Echoes what each plugin cost the request in the response
+				// header X-Plugin-Timings: "<plugin>;dur=<ms>[;errors=<n>]",
+				// comma-separated. For debugging; the same figures are
+				// exported as metrics either way.
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.PluginTimings = true
+			case "plugin_report":
+				// plugin_report <structured|json> [<max_bytes>] [debug]
+				// Replaces the plain list in X-Plugins-Executed with a
+				// report of each plugin's params, run time and whether it
+				// modified the program, capped at max_bytes (default 4096;
+				// plugins past it are counted as omitted). With debug, a
+				// request sending X-Debug-Plugins: true gets the full
+				// report in the "plugin_report" field of a non-streaming
+				// response body.
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				rc := &PluginReportConfig{Format: args[0]}
+				if rc.Format != "structured" && rc.Format != "json" {
+					return d.Errf("plugin_report: unknown format %q (want structured or json)", rc.Format)
+				}
+				for _, arg := range args[1:] {
+					if arg == "debug" {
+						rc.Debug = true
+						continue
+					}
+					n, err := strconv.Atoi(arg)
+					if err != nil || n <= 0 {
+						return d.Errf("plugin_report: invalid max bytes %q", arg)
+					}
+					rc.MaxBytes = n
+				}
+				m.PluginReport = rc
+			case "plugin_on_error":
+				// plugin_on_error <plugin> <open|closed>
+				// What a failing plugin does to the request: open logs the
+				// failure and carries on without the plugin, closed fails
+				// the provider attempt. Plugins default to closed unless
+				// they declare themselves non-critical (sampler, usage...).
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				if args[1] != "open" && args[1] != "closed" {
+					return d.Errf("plugin_on_error %s: must be open or closed", args[0])
+				}
+				if m.PluginFailOpen == nil {
+					m.PluginFailOpen = make(map[string]bool)
+				}
+				m.PluginFailOpen[args[0]] = args[1] == "open"
+			case "model_weights":
+				// model_weights <model_name> <provider>=<weight> [<provider>=<weight> ...]
+				// Splits traffic for a model across providers by weight, sticky
+				// per conversation. Unlisted providers remain as fallbacks.
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.Errf("model_weights expects <model_name> <provider>=<weight> [...], got %d args", len(args))
+				}
+				weights := make(map[string]int, len(args)-1)
+				for _, pair := range args[1:] {
+					pName, wStr, ok := strings.Cut(pair, "=")
+					w, err := strconv.Atoi(wStr)
+					if !ok || pName == "" || err != nil || w < 0 {
+						return d.Errf("model_weights %s: invalid weight '%s', expected <provider>=<weight>", args[0], pair)
+					}
+					weights[strings.ToLower(pName)] = w
+				}
+				if m.ModelProviderWeights == nil {
+					m.ModelProviderWeights = make(map[string]map[string]int)
+				}
+				m.ModelProviderWeights[args[0]] = weights
+			case "routing_strategy":
+				// routing_strategy <order|cost|latency|quality>
+				// Default provider ordering; X-Routing-Strategy overrides it per
+				// request. Neither reorders a provider pinned by a model prefix
+				// or default_provider_for_model.
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				strategy := strings.ToLower(d.Val())
+				if !IsRoutingStrategy(strategy) {
+					return d.Errf("unknown routing_strategy '%s'", d.Val())
+				}
+				m.RoutingStrategy = strategy
 			case "default_provider_for_model":
 				args := d.RemainingArgs()
 				if len(args) < 2 {
@@ -204,6 +1717,136 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 		m.Impl.Auth = services.GetAuthService(m.AuthManagerName)
 	}
 
+	m.Impl.Name = m.Name
+	m.Impl.PluginTimings = m.PluginTimings
+	if m.PluginReport != nil {
+		m.Impl.PluginReport = services.PluginReportConfig(*m.PluginReport)
+		if m.Impl.PluginReport.MaxBytes == 0 {
+			m.Impl.PluginReport.MaxBytes = 4096
+		}
+	}
+	m.Impl.Catalog = services.NewCatalog(m.ModelInfo)
+	switch m.Preflight {
+	case "", PreflightCheck, PreflightCompact:
+	default:
+		return fmt.Errorf("preflight: unknown mode %q", m.Preflight)
+	}
+	if m.AccessLog {
+		m.Impl.AccessLog = m.Impl.Logger.Named("access")
+	}
+
+	if err := m.provisionPlugins(); err != nil {
+		return err
+	}
+
+	// Router metrics join Caddy's registry, served by the admin endpoint's
+	// /metrics and by the `metrics` handler directive.
+	if reg := ctx.GetMetricsRegistry(); reg != nil {
+		if err := services.RegisterMetrics(reg); err != nil {
+			return fmt.Errorf("metrics: %v", err)
+		}
+	}
+
+	if m.Cooldown == nil || !m.Cooldown.Disabled {
+		cd := m.Cooldown
+		if cd == nil {
+			cd = &CooldownConfig{}
+		}
+		store, err := openStore(ctx, "cooldown", cd.Store, cd.DSN)
+		if err != nil {
+			return err
+		}
+		def, max := time.Duration(cd.Default), time.Duration(cd.Max)
+		if def <= 0 {
+			def = defaultCooldown
+		}
+		if max <= 0 {
+			max = defaultCooldownMax
+		}
+		m.Impl.Cooldowns = services.NewCooldowns(store, "router:"+m.Name+":", def, max)
+	}
+
+	if sr := m.StickyRouting; sr != nil {
+		store, err := openStore(ctx, "sticky_routing", sr.Store, sr.DSN)
+		if err != nil {
+			return err
+		}
+		ttl := time.Duration(sr.TTL)
+		if ttl <= 0 {
+			ttl = defaultStickyRoutingTTL
+		}
+		m.Impl.Affinity = services.NewAffinity(store, "router:"+m.Name+":", ttl)
+	}
+
+	if hc := m.Hedging; hc != nil {
+		delay, budget := time.Duration(hc.Delay), hc.Budget
+		if delay <= 0 {
+			delay = defaultHedgingDelay
+		}
+		if budget <= 0 {
+			budget = defaultHedgingBudget
+		}
+		m.Impl.Hedging = services.NewHedging(delay, budget)
+	}
+
+	if ua := m.UsageAccounting; ua != nil {
+		store, err := openStore(ctx, "usage_accounting", ua.Store, ua.DSN)
+		if err != nil {
+			return err
+		}
+		interval, retention := time.Duration(ua.FlushInterval), time.Duration(ua.Retention)
+		if interval <= 0 {
+			interval = defaultUsageFlushInterval
+		}
+		if retention <= 0 {
+			retention = defaultUsageRetention
+		}
+		m.Impl.Usage = services.NewUsageAccounting(store, "router:"+m.Name+":", retention)
+		m.Impl.Usage.Start(ctx, interval)
+	}
+
+	if wc := m.Webhooks; wc != nil {
+		attempts, backoff, timeout := wc.MaxAttempts, time.Duration(wc.Backoff), time.Duration(wc.Timeout)
+		if attempts <= 0 {
+			attempts = defaultWebhookAttempts
+		}
+		if backoff <= 0 {
+			backoff = defaultWebhookBackoff
+		}
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		m.Impl.Webhooks = services.NewWebhooks(m.Name, wc.Endpoints, wc.Secret, attempts, backoff, timeout, m.Impl.Logger.Named("webhooks"))
+		m.Impl.Webhooks.Start(ctx)
+	}
+
+	if err := m.provisionPriorities(); err != nil {
+		return err
+	}
+
+	if m.LoadShed != nil {
+		retryAfter := time.Duration(m.LoadShed.RetryAfter)
+		if retryAfter <= 0 {
+			retryAfter = defaultLoadShedRetryAfter
+		}
+		m.Impl.Shedder = services.NewLoadShedder(services.LoadShedderConfig{
+			MaxGoroutines: m.LoadShed.MaxGoroutines,
+			MaxInFlight:   m.LoadShed.MaxInFlight,
+			MaxHeapBytes:  uint64(m.LoadShed.MaxHeapMB) << 20,
+			RetryAfter:    retryAfter,
+		})
+		m.Impl.Shedder.Start(ctx)
+	}
+
+	if m.FairQueue != nil {
+		m.Impl.Scheduler = services.NewFairScheduler(services.FairSchedulerConfig{
+			MaxInFlight: m.FairQueue.MaxInFlight,
+			MaxQueue:    m.FairQueue.MaxQueue,
+			MaxWait:     time.Duration(m.FairQueue.MaxWait),
+			Weights:     m.FairQueue.Weights,
+		})
+	}
+
 	for _, name := range m.ProvidersOrder {
 		p := m.ProviderConfigs[name]
 
@@ -212,15 +1855,18 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 			return fmt.Errorf("provider %s: invalid style '%s': %v", name, p.Style, err)
 		}
 
-		// Virtual providers don't need api_base_url
+		// Virtual providers don't need api_base_url, nor do synthetic ones,
+		// whose upstream answers any URL.
 		var parsedURL url.URL
-		if providerStyle != styles.StyleVirtual {
+		if providerStyle == styles.StyleSynthetic && p.APIBaseURL == "" {
+			parsedURL = url.URL{Scheme: "http", Host: "synthetic"}
+		} else if providerStyle != styles.StyleVirtual {
 			if p.APIBaseURL == "" {
 				return fmt.Errorf("provider %s: api_base_url is required", name)
 			}
-			parsed, err := url.Parse(p.APIBaseURL)
+			parsed, err := parseBaseURL(p.APIBaseURL)
 			if err != nil {
-				return fmt.Errorf("provider %s: invalid api_base_url '%s': %v", name, p.APIBaseURL, err)
+				return fmt.Errorf("provider %s: %v", name, err)
 			}
 			parsedURL = *parsed
 		}
@@ -230,26 +1876,126 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 			ParsedURL: parsedURL,
 			Style:     providerStyle,
 			Router:    &m.Impl,
+			Prices:    p.Prices,
+			Quality:   p.Quality,
+			Latency:   &services.LatencyTracker{},
+
+			StructuredOutputs: p.StructuredOutputs,
+			ToolChoice:        p.ToolChoice,
+		}
+
+		rateLimitWait := time.Duration(p.RateLimitWait)
+		if rateLimitWait == 0 {
+			rateLimitWait = defaultRateLimitWait
+		}
+		var connectTimeout time.Duration
+		if tc := p.Timeouts; tc != nil {
+			connectTimeout = time.Duration(tc.Connect)
+			p.Impl.Timeouts = &services.Timeouts{
+				Connect:        connectTimeout,
+				FirstByte:      time.Duration(tc.FirstByte),
+				Total:          time.Duration(tc.Total),
+				Adaptive:       tc.Adaptive,
+				AdaptiveFactor: tc.AdaptiveFactor,
+				AdaptiveMin:    time.Duration(tc.AdaptiveMin),
+			}
+		}
+		if providerStyle != styles.StyleVirtual {
+			opts := services.ClientOptions{Connect: connectTimeout, Provider: name}
+			if hc := p.HTTPClient; hc != nil {
+				opts.MaxIdleConnsPerHost = hc.MaxIdleConns
+				opts.IdleConnTimeout = time.Duration(hc.IdleTimeout)
+				proxy, err := parseProxy(hc.Proxy)
+				if err != nil {
+					return fmt.Errorf("provider %s: %v", name, err)
+				}
+				opts.Proxy, opts.NoProxy = proxy, hc.Proxy == "off"
+				opts.Protocol = hc.Protocol
+				if hc.Protocol == services.ProtocolHTTP3 {
+					if proxy != nil {
+						return fmt.Errorf("provider %s: http_client protocol http3 cannot go through a proxy", name)
+					}
+					if parsedURL.Scheme != "https" {
+						return fmt.Errorf("provider %s: http_client protocol http3 needs an https api_base_url", name)
+					}
+				}
+			}
+			p.Impl.HTTPClient = services.NewProviderClient(opts)
+		}
+		if p.Synthetic != nil && providerStyle != styles.StyleSynthetic {
+			return fmt.Errorf("provider %s: synthetic is only valid for synthetic providers", name)
+		}
+
+		p.Impl.RateLimiter = services.NewRateLimiter(p.RateLimit, p.ModelRateLimits, rateLimitWait)
+		p.Impl.Concurrency = services.NewConcurrencyLimiter(p.MaxConcurrency, time.Duration(p.ConcurrencyWait))
+
+		if p.MappingsSource != nil && providerStyle != styles.StyleVirtual {
+			return fmt.Errorf("provider %s: mappings_source is only valid for virtual providers", name)
+		}
+		if len(p.ModelParams) > 0 && providerStyle != styles.StyleVirtual {
+			return fmt.Errorf("provider %s: params are only valid for virtual providers", name)
+		}
+
+		if p.HealthCheck != nil && providerStyle != styles.StyleVirtual {
+			unhealthyAfter := p.HealthCheck.UnhealthyAfter
+			if unhealthyAfter == 0 {
+				unhealthyAfter = defaultHealthUnhealthyAfter
+			}
+			p.Impl.Health = services.NewProviderHealth(p.HealthCheck.Probe, unhealthyAfter)
 		}
 
 		// Initialize commands based on style
 		var providerCommands map[string]any
 		switch providerStyle {
 		case styles.StyleVirtual: // Virtual provider (model aliasing)
-			if len(p.ModelMappings) == 0 {
-				return fmt.Errorf("provider %s: virtual provider requires at least one model mapping", name)
+			if len(p.ModelMappings) == 0 && p.MappingsSource == nil {
+				return fmt.Errorf("provider %s: virtual provider requires at least one model mapping or a mappings_source", name)
 			}
-			virtualPlugin := &virtual.VirtualPlugin{
-				ProviderName:  name,
-				ModelMappings: p.ModelMappings,
+			if src := p.MappingsSource; src != nil && src.Kind == MappingsSourceKV && !kv.HasBackend(src.Backend) {
+				return fmt.Errorf("provider %s: mappings_source: kv backend %q is not available", name, src.Backend)
+			}
+			for model, spec := range p.ModelMappings {
+				if _, _, err := virtual.ParseTargets(spec); err != nil {
+					return fmt.Errorf("provider %s: model %s: %v", name, model, err)
+				}
+			}
+			for model, params := range p.ModelParams {
+				if err := params.Validate(); err != nil {
+					return fmt.Errorf("provider %s: params %s: %v", name, model, err)
+				}
+			}
+			providerCommands = m.registerVirtual(name, p)
+		case styles.StyleSynthetic: // Stand-in upstream for load tests
+			var cfg synthetic.Config
+			if sc := p.Synthetic; sc != nil {
+				cfg = synthetic.Config{
+					Tokens:          sc.Tokens,
+					TokensPerSecond: sc.TokensPerSecond,
+					FirstToken:      time.Duration(sc.FirstToken),
+					ErrorRate:       sc.ErrorRate,
+					Models:          sc.Models,
+				}
+			}
+			// Everything past the transport is the real chat completions
+			// driver, so the load test covers it too.
+			p.Impl.Style = styles.StyleChatCompletions
+			p.Impl.HTTPClient = &http.Client{Transport: synthetic.NewTransport(cfg)}
+			driver, err := drivers.NewInferenceSse(styles.StyleChatCompletions, drivers.EndpointForStyle(styles.StyleChatCompletions))
+			if err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+			providerCommands = map[string]any{
+				"list_models": &openai.ListModels{},
+				"inference":   driver,
+			}
+		case styles.StyleGoogleGenAI: // Gemini API
+			driver, err := google.NewInference(p.SafetySettings)
+			if err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
 			}
-			plugin.RegisterPlugin("virtual:"+name, virtualPlugin)
-
 			providerCommands = map[string]any{
-				"list_models": &virtual.VirtualListModels{
-					ProviderName:  name,
-					ModelMappings: p.ModelMappings,
-				},
+				"list_models": &google.ListModels{},
+				"inference":   driver,
 			}
 		default:
 			// Generic: create an InferenceSse driver for any ail-supported upstream style.
@@ -263,6 +2009,20 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 				"list_models": &openai.ListModels{},
 				"inference":   driver,
 			}
+			// Embeddings have no AIL form; OpenAI-compatible upstreams
+			// serve them next to chat completions.
+			if providerStyle == styles.StyleChatCompletions || providerStyle == styles.StyleResponses {
+				providerCommands["embeddings"] = &openai.Embeddings{
+					BatchSize:   p.EmbeddingsBatch,
+					Concurrency: p.EmbeddingsConcurrency,
+				}
+			}
+		}
+		if (p.EmbeddingsBatch > 0 || p.EmbeddingsConcurrency > 0) && providerCommands["embeddings"] == nil {
+			return fmt.Errorf("provider %s: embeddings_batch needs an OpenAI-compatible style", name)
+		}
+		if len(p.SafetySettings) > 0 && providerStyle != styles.StyleGoogleGenAI {
+			return fmt.Errorf("provider %s: safety_settings needs style google-genai", name)
 		}
 		p.Impl.Commands = providerCommands
 
@@ -275,11 +2035,11 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 				providerCommands["list_models"] = &drivers.ExportFilteredListModels{Inner: inner}
 			}
 		} else if len(p.Exports) > 0 {
-			exportSet := make(map[string]bool, len(p.Exports))
-			for _, modelID := range p.Exports {
-				exportSet[modelID] = true
+			exportSet, patterns, err := m.expandExports(p.Exports)
+			if err != nil {
+				return fmt.Errorf("provider %s: exports: %v", name, err)
 			}
-			p.Impl.ExportedModels = exportSet
+			p.Impl.ExportedModels, p.Impl.ExportPatterns = exportSet, patterns
 
 			// Wrap the list_models command so every consumer (fuzz, /models, etc.)
 			// automatically sees only the exported models.
@@ -293,7 +2053,16 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 			zap.String("base_url", p.APIBaseURL),
 			zap.String("style", string(providerStyle)),
 			zap.Int("exports_count", len(p.Exports)),
-			zap.Bool("private", p.Private))
+			zap.Bool("private", p.Private),
+			zap.Bool("rate_limited", p.Impl.RateLimiter != nil),
+			zap.Int("max_concurrency", p.MaxConcurrency))
+	}
+
+	if err := m.openVirtualStore(ctx); err != nil {
+		return err
+	}
+	if err := m.openSignatureStore(ctx); err != nil {
+		return err
 	}
 
 	// Expose providers to plugins (fuzz, etc.) without circular imports.
@@ -309,10 +2078,94 @@ func (m *RouterModule) Provision(ctx caddy.Context) error {
 		return out
 	}
 
+	// Probers run until the config is unloaded (ctx is cancelled).
+	m.startHealthChecks(ctx)
+	if err := m.startMappingSources(ctx); err != nil {
+		return err
+	}
+
+	if m.PolicyFile != "" {
+		fi, err := os.Stat(m.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("policy_file: %v", err)
+		}
+		if err := m.loadPolicyFile(); err != nil {
+			return fmt.Errorf("policy_file %s: %v", m.PolicyFile, err)
+		}
+		go m.watchPolicyFile(ctx, fi)
+	}
+
 	RegisterRouter(m.Name, m)
 	return nil
 }
 
+// provisionPlugins checks the router's remote plugins, presets, model
+// plugin attachments, DSPy signatures and plugin priorities and registers
+// them. All are shared by all routers, like virtual providers' plugins.
+func (m *RouterModule) provisionPlugins() error {
+	for name, rp := range m.RemotePlugins {
+		if name == "preset" || strings.ContainsAny(name, ":+/@") {
+			return fmt.Errorf("remote_plugin: invalid name %q", name)
+		}
+		if p, ok := plugin.GetPlugin(name); ok {
+			if _, remote := p.(*plugins.Remote); !remote {
+				return fmt.Errorf("remote_plugin %s: name taken by a built-in plugin", name)
+			}
+		}
+		plugin.RegisterPlugin(name, &plugins.Remote{
+			PluginName: name,
+			URL:        rp.URL,
+			Hooks:      rp.Hooks,
+			Timeout:    time.Duration(rp.Timeout),
+			FailOpen:   rp.FailOpen,
+			Requires:   rp.Requires,
+			Conflicts:  rp.Conflicts,
+			Client:     plugins.NewRemoteClient(time.Duration(rp.Timeout)),
+		})
+	}
+	for name, specs := range m.Presets {
+		if err := plugin.ValidateSpecs(specs, m.Presets); err != nil {
+			return fmt.Errorf("preset %s: %v", name, err)
+		}
+	}
+	for model, specs := range m.ModelPlugins {
+		if _, err := path.Match(model, ""); err != nil {
+			return fmt.Errorf("model_plugins %s: bad pattern: %v", model, err)
+		}
+		if err := plugin.ValidateSpecs(specs, m.Presets); err != nil {
+			return fmt.Errorf("model_plugins %s: %v", model, err)
+		}
+	}
+	for name, sig := range m.DSPySignatures {
+		if err := dspy.ValidateSignatureName(name); err != nil {
+			return fmt.Errorf("dspy_signature: %v", err)
+		}
+		if err := sig.Validate(); err != nil {
+			return fmt.Errorf("dspy_signature %s: %v", name, err)
+		}
+	}
+	for name, specs := range m.Presets {
+		plugin.RegisterPreset(name, specs)
+	}
+	dspy.RegisterSignatures(m.DSPySignatures)
+	for model, specs := range m.ModelPlugins {
+		plugin.RegisterModelPlugins(model, specs)
+	}
+	for name := range m.PluginPriorities {
+		if _, ok := plugin.GetPlugin(name); !ok {
+			return fmt.Errorf("plugin_priority: unknown plugin %q", name)
+		}
+	}
+	plugin.SetPriorities(m.PluginPriorities)
+	for name := range m.PluginFailOpen {
+		if _, ok := plugin.GetPlugin(name); !ok {
+			return fmt.Errorf("plugin_on_error: unknown plugin %q", name)
+		}
+	}
+	plugin.SetFailurePolicies(m.PluginFailOpen)
+	return nil
+}
+
 func (m *RouterModule) Validate() error {
 	m.Impl.Mu.RLock()
 	defer m.Impl.Mu.RUnlock()
@@ -320,13 +2173,117 @@ func (m *RouterModule) Validate() error {
 	if len(m.ProviderConfigs) == 0 {
 		return fmt.Errorf("at least one provider must be configured for ai_router")
 	}
-	return nil
+	for model, weights := range m.ModelProviderWeights {
+		for pName := range weights {
+			if _, ok := m.ProviderConfigs[pName]; !ok {
+				return fmt.Errorf("model_weights %s: unknown provider %s", model, pName)
+			}
+		}
+	}
+	return m.checkReferences()
 }
 
 func (m *RouterModule) ServeHTTP(w http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
 	return next.ServeHTTP(w, req)
 }
 
+// expandExports splits exports into exact model IDs and glob patterns,
+// replacing "@<group>" entries with the group's models.
+func (m *RouterModule) expandExports(exports []string) (map[string]bool, []string, error) {
+	exact := make(map[string]bool, len(exports))
+	var patterns []string
+	for _, export := range exports {
+		models := []string{export}
+		if group, ok := strings.CutPrefix(export, "@"); ok {
+			if models, ok = m.ModelGroups[group]; !ok {
+				return nil, nil, fmt.Errorf("unknown model_group %q", group)
+			}
+		}
+		for _, model := range models {
+			if !strings.ContainsAny(model, "*?[") {
+				exact[model] = true
+				continue
+			}
+			if _, err := path.Match(model, ""); err != nil {
+				return nil, nil, fmt.Errorf("bad pattern %q: %v", model, err)
+			}
+			patterns = append(patterns, model)
+		}
+	}
+	return exact, patterns, nil
+}
+
+// Provider returns the named provider's config. Use it rather than
+// ProviderConfigs once the router serves requests: virtual providers
+// created through the admin API change the map.
+func (m *RouterModule) Provider(name string) (*ProviderConfig, bool) {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	p, ok := m.ProviderConfigs[name]
+	return p, ok
+}
+
+// Providers returns the provider names in configured order.
+func (m *RouterModule) Providers() []string {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	return slices.Clone(m.ProvidersOrder)
+}
+
+// parseProxy parses an http_client proxy: a URL, or "off" (nil) for none.
+// An empty proxy is nil as well, leaving the environment's proxy in place.
+func parseProxy(s string) (*url.URL, error) {
+	if s == "" || s == "off" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid http_client proxy '%s'", s)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("http_client proxy '%s': scheme must be http, https or socks5", s)
+}
+
+// parseFraction parses a fraction, as "0.05" or "5%".
+func parseFraction(s string) (float64, error) {
+	pct := strings.HasSuffix(s, "%")
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if pct {
+		f /= 100
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("fraction %s out of range", s)
+	}
+	return f, nil
+}
+
+// parseRateLimitArgs parses "<rpm> [<tpm>]" into a RateLimitConfig.
+func parseRateLimitArgs(args []string) (services.RateLimitConfig, error) {
+	var cfg services.RateLimitConfig
+	if len(args) < 1 || len(args) > 2 {
+		return cfg, fmt.Errorf("expected <rpm> [<tpm>], got %d args", len(args))
+	}
+	rpm, err := strconv.Atoi(args[0])
+	if err != nil || rpm < 0 {
+		return cfg, fmt.Errorf("invalid rpm '%s'", args[0])
+	}
+	cfg.RPM = rpm
+	if len(args) == 2 {
+		tpm, err := strconv.Atoi(args[1])
+		if err != nil || tpm < 0 {
+			return cfg, fmt.Errorf("invalid tpm '%s'", args[1])
+		}
+		cfg.TPM = tpm
+	}
+	return cfg, nil
+}
+
 // uniqueProviders returns a slice with priority provider first, followed by
 // remaining providers from order, excluding any duplicates.
 func uniqueProviders(priority string, order []string) []string {
@@ -341,6 +2298,16 @@ func uniqueProviders(priority string, order []string) []string {
 
 // ResolveProvidersOrderAndModel determines provider order and normalizes the model name.
 func (m *RouterModule) ResolveProvidersOrderAndModel(model string) (providerNames []string, actualModelName string) {
+	pinned, rest, actualModelName := m.ResolveProviders(model)
+	return append(pinned, rest...), actualModelName
+}
+
+// ResolveProviders is ResolveProvidersOrderAndModel with the provider list
+// split in two: pinned holds the provider chosen explicitly by a
+// "provider/model" prefix or default_provider_for_model, and rest holds the
+// remaining fallbacks in configured order. Balancing and routing strategies
+// only reorder rest, so explicit routing config always wins.
+func (m *RouterModule) ResolveProviders(model string) (pinned, rest []string, actualModelName string) {
 	m.Impl.Mu.RLock()
 	defer m.Impl.Mu.RUnlock()
 
@@ -356,7 +2323,7 @@ func (m *RouterModule) ResolveProvidersOrderAndModel(model string) (providerName
 			m.Impl.Logger.Debug("Found explicit provider by prefix",
 				zap.String("prefix", pName),
 				zap.String("model", actualModelName))
-			return []string{pName}, actualModelName
+			return []string{pName}, nil, actualModelName
 		}
 		m.Impl.Logger.Debug("Prefix found but provider not recognized, skipping and checking defaults",
 			zap.String("prefix", pName),
@@ -370,7 +2337,8 @@ func (m *RouterModule) ResolveProvidersOrderAndModel(model string) (providerName
 				m.Impl.Logger.Debug("Found default provider for model",
 					zap.String("model", actualModelName),
 					zap.String("provider", pName))
-				return uniqueProviders(pName, m.ProvidersOrder), actualModelName
+				order := uniqueProviders(pName, m.ProvidersOrder)
+				return order[:1], order[1:], actualModelName
 			}
 			m.Impl.Logger.Warn("Default provider for model configured but provider itself not found",
 				zap.String("model", actualModelName),
@@ -378,7 +2346,7 @@ func (m *RouterModule) ResolveProvidersOrderAndModel(model string) (providerName
 		}
 	}
 
-	return m.ProvidersOrder, actualModelName
+	return nil, m.ProvidersOrder, actualModelName
 }
 
 var (
@@ -387,3 +2355,68 @@ var (
 	_ caddyhttp.MiddlewareHandler = (*RouterModule)(nil)
 	_ caddyfile.Unmarshaler       = (*RouterModule)(nil)
 )
+
+// parseModelInfo parses the block of a model_info option.
+func parseModelInfo(d *caddyfile.Dispenser, model string) (services.ModelInfo, error) {
+	var mi services.ModelInfo
+	for d.NextBlock(1) {
+		opt := d.Val()
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return mi, d.ArgErr()
+		}
+		switch opt {
+		case "context_window", "max_output":
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 || len(args) != 1 {
+				return mi, d.Errf("model_info %s: invalid %s '%s'", model, opt, strings.Join(args, " "))
+			}
+			if opt == "context_window" {
+				mi.ContextWindow = n
+			} else {
+				mi.MaxOutput = n
+			}
+		case "modalities":
+			for _, m := range args {
+				switch m {
+				case "text", "image", "audio", "file":
+				default:
+					return mi, d.Errf("model_info %s: unknown modality '%s'", model, m)
+				}
+			}
+			mi.Modalities = args
+		case "tools":
+			b, err := strconv.ParseBool(args[0])
+			if err != nil || len(args) != 1 {
+				return mi, d.Errf("model_info %s: tools must be true or false", model)
+			}
+			mi.Tools = &b
+		case "price":
+			if len(args) != 2 && len(args) != 3 {
+				return mi, d.Errf("model_info %s: price expects <input> <output> [<cached_input>]", model)
+			}
+			var prices [3]float64
+			for i, a := range args {
+				f, err := strconv.ParseFloat(a, 64)
+				if err != nil || f < 0 {
+					return mi, d.Errf("model_info %s: invalid price '%s'", model, a)
+				}
+				prices[i] = f
+			}
+			mi.Price = &services.ModelPrice{Input: prices[0], Output: prices[1], CachedInput: prices[2]}
+		case "deprecated":
+			if _, err := time.Parse(time.DateOnly, args[0]); err != nil || len(args) != 1 {
+				return mi, d.Errf("model_info %s: deprecated expects a YYYY-MM-DD date", model)
+			}
+			mi.Deprecated = args[0]
+		case "replaced_by":
+			if len(args) != 1 {
+				return mi, d.ArgErr()
+			}
+			mi.ReplacedBy = args[0]
+		default:
+			return mi, d.Errf("unrecognized model_info option '%s'", opt)
+		}
+	}
+	return mi, nil
+}
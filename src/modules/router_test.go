@@ -0,0 +1,476 @@
+package modules
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/plugins"
+)
+
+func TestPresets(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		preset router-test-safe fuzz+slwin:20
+		preset router-test-all preset:router-test-safe kvtools
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.UnregisterPreset("router-test-safe")
+	defer plugin.UnregisterPreset("router-test-all")
+	if got, _ := plugin.GetPreset("router-test-all"); !slices.Equal(got, []string{"preset:router-test-safe", "kvtools"}) {
+		t.Errorf("router-test-all = %q", got)
+	}
+	if got, _ := plugin.GetPreset("router-test-safe"); !slices.Equal(got, []string{"fuzz", "slwin:20"}) {
+		t.Errorf("router-test-safe = %q", got)
+	}
+
+	bad := RouterModule{Presets: map[string][]string{"router-test-bad": {"no-such-plugin"}}}
+	if err := bad.provisionPlugins(); err == nil {
+		t.Error("unknown plugin accepted")
+	}
+	if _, ok := plugin.GetPreset("router-test-bad"); ok {
+		t.Error("invalid preset registered")
+	}
+	if err := (&RouterModule{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		preset lonely
+	}`)); err == nil {
+		t.Error("preset without plugins accepted")
+	}
+}
+
+func TestModelPlugins(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		preset router-test-mp fuzz
+		model_plugins router-test-bot preset:router-test-mp+slwin:20
+		model_plugins router-test-bot kvtools
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.UnregisterPreset("router-test-mp")
+	defer plugin.UnregisterModelPlugins("router-test-bot")
+	if got := plugin.GetModelPlugins("bots/router-test-bot+fuzz"); !slices.Equal(got, []string{"preset:router-test-mp", "slwin:20", "kvtools"}) {
+		t.Errorf("router-test-bot = %q", got)
+	}
+
+	for _, bad := range []map[string][]string{
+		{"router-test-bad": {"no-such-plugin"}},
+		{"router-test-[": {"fuzz"}},
+	} {
+		if err := (&RouterModule{ModelPlugins: bad}).provisionPlugins(); err == nil {
+			t.Errorf("%v accepted", bad)
+		}
+	}
+	if got := plugin.GetModelPlugins("router-test-bad"); got != nil {
+		t.Errorf("invalid attachment registered: %q", got)
+	}
+}
+
+func TestExportGroups(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		model_group chat gpt-4* o3
+		model_group chat claude-*
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	exact, patterns, err := m.expandExports([]string{"@chat", "text-embedding-3-small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exact["o3"] || !exact["text-embedding-3-small"] || len(exact) != 2 {
+		t.Errorf("exact = %v", exact)
+	}
+	if !slices.Equal(patterns, []string{"gpt-4*", "claude-*"}) {
+		t.Errorf("patterns = %v", patterns)
+	}
+	for _, bad := range []string{"@nope", "gpt-[4"} {
+		if _, _, err := m.expandExports([]string{bad}); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestPluginPriorities(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		plugin_priority slwin first
+		plugin_priority kvtools 42
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.SetPriorities(nil)
+	slwin, _ := plugin.GetPlugin("slwin")
+	kvtools, _ := plugin.GetPlugin("kvtools")
+	if plugin.PriorityOf(slwin) != plugin.PriorityFirst || plugin.PriorityOf(kvtools) != 42 {
+		t.Errorf("priorities = %d, %d", plugin.PriorityOf(slwin), plugin.PriorityOf(kvtools))
+	}
+
+	// A reload without kvtools's priority drops it.
+	m.PluginPriorities = map[string]int{"slwin": plugin.PriorityFirst}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	if got := plugin.PriorityOf(kvtools); got == 42 {
+		t.Errorf("removed priority still applies: %d", got)
+	}
+
+	bad := RouterModule{PluginPriorities: map[string]int{"no-such-plugin": 1}}
+	if err := bad.provisionPlugins(); err == nil {
+		t.Error("unknown plugin accepted")
+	}
+	if err := (&RouterModule{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		plugin_priority slwin soon
+	}`)); err == nil {
+		t.Error("invalid priority accepted")
+	}
+}
+
+func TestPluginFailurePolicies(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		plugin_on_error slwin open
+		plugin_on_error usage closed
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.SetFailurePolicies(nil)
+	slwin, _ := plugin.GetPlugin("slwin")
+	usage, _ := plugin.GetPlugin("usage")
+	if !plugin.FailsOpen(slwin) || plugin.FailsOpen(usage) {
+		t.Errorf("fails open = %v, %v", plugin.FailsOpen(slwin), plugin.FailsOpen(usage))
+	}
+
+	// A reload without slwin's policy drops it.
+	m.PluginFailOpen = map[string]bool{"usage": false}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.FailsOpen(slwin) {
+		t.Error("removed failure policy still applies")
+	}
+
+	bad := RouterModule{PluginFailOpen: map[string]bool{"no-such-plugin": true}}
+	if err := bad.provisionPlugins(); err == nil {
+		t.Error("unknown plugin accepted")
+	}
+	if err := (&RouterModule{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		plugin_on_error slwin maybe
+	}`)); err == nil {
+		t.Error("invalid policy accepted")
+	}
+}
+
+func TestRemotePlugins(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		remote_plugin router-test-guard http://localhost:9000 {
+			hooks before chunk
+			timeout 2s
+			on_error open
+			requires tiktoken
+			conflicts kvtools
+		}
+		preset router-test-remote router-test-guard
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.provisionPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(plugin.Registry, "router-test-guard")
+	defer plugin.UnregisterPreset("router-test-remote")
+	p, ok := plugin.GetPlugin("router-test-guard")
+	rp, _ := p.(*plugins.Remote)
+	if !ok || rp == nil || rp.URL != "http://localhost:9000" || !rp.FailOpen || rp.Timeout != 2*time.Second ||
+		!slices.Equal(rp.Hooks, []string{"before", "chunk"}) ||
+		!slices.Equal(rp.Requires, []string{"tiktoken"}) || !slices.Equal(rp.Conflicts, []string{"kvtools"}) {
+		t.Fatalf("registered %#v", p)
+	}
+
+	taken := RouterModule{RemotePlugins: map[string]*RemotePlugin{"slwin": {URL: "http://x"}}}
+	if err := taken.provisionPlugins(); err == nil {
+		t.Error("built-in plugin name accepted")
+	}
+	for _, cfg := range []string{
+		`remote_plugin g ftp://x`,
+		`remote_plugin g http://x {
+			hooks during
+		}`,
+		`remote_plugin g http://x {
+			on_error maybe
+		}`,
+		`remote_plugin g http://x {
+			requires
+		}`,
+	} {
+		if err := (&RouterModule{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("ai_router {\n" + cfg + "\n}")); err == nil {
+			t.Errorf("accepted %q", cfg)
+		}
+	}
+}
+
+func TestWeightedVirtualTargets(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		provider alias {
+			style virtual
+			model smart openai/gpt-4.1 70 anthropic/claude-sonnet 30
+			model fast openai/gpt-4o-mini
+			sticky_targets
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	p := m.ProviderConfigs["alias"]
+	if got := p.ModelMappings["smart"]; got != "openai/gpt-4.1 70 anthropic/claude-sonnet 30" {
+		t.Errorf("smart = %q", got)
+	}
+	if !p.StickyTargets {
+		t.Error("sticky_targets not set")
+	}
+
+	bad := caddyfile.NewTestDispenser(`ai_router {
+		provider alias {
+			style virtual
+			model smart openai/gpt-4.1 70 anthropic/claude-sonnet
+		}
+	}`)
+	var m2 RouterModule
+	if err := m2.UnmarshalCaddyfile(bad); err == nil {
+		t.Error("a target without a weight should be rejected")
+	}
+}
+
+func TestVirtualParams(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		provider bots {
+			style virtual
+			model support openai/gpt-4.1-mini
+			params support {
+				temperature 0.2
+				max_tokens 800
+				reasoning_effort low
+				system_prompt "You are the support bot."
+			}
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	params := m.ProviderConfigs["bots"].ModelParams["support"]
+	if params == nil || *params.Temperature != 0.2 || *params.MaxTokens != 800 ||
+		params.ReasoningEffort != "low" || params.SystemPrompt != "You are the support bot." || params.TopP != nil {
+		t.Errorf("params = %+v", params)
+	}
+
+	bad := caddyfile.NewTestDispenser(`ai_router {
+		provider bots {
+			style virtual
+			params support {
+				reasoning_effort extreme
+			}
+		}
+	}`)
+	var m2 RouterModule
+	if err := m2.UnmarshalCaddyfile(bad); err == nil {
+		t.Error("an unknown reasoning_effort should be rejected")
+	}
+}
+
+func TestModelInfo(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_router {
+		model_info my-llama {
+			context_window 32768
+			max_output 4096
+			modalities text image
+			tools false
+			price 0.2 0.4
+			deprecated 2026-12-31
+		}
+	}`)
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	mi := m.ModelInfo["my-llama"]
+	if mi.ContextWindow != 32768 || mi.MaxOutput != 4096 || !slices.Equal(mi.Modalities, []string{"text", "image"}) ||
+		mi.Tools == nil || *mi.Tools || mi.Price == nil || mi.Price.Output != 0.4 || mi.Deprecated != "2026-12-31" {
+		t.Errorf("model_info = %+v", mi)
+	}
+
+	bad := caddyfile.NewTestDispenser(`ai_router {
+		model_info my-llama {
+			modalities text video
+		}
+	}`)
+	var m2 RouterModule
+	if err := m2.UnmarshalCaddyfile(bad); err == nil {
+		t.Error("an unknown modality should be rejected")
+	}
+}
+
+func TestStickyRoutingConfig(t *testing.T) {
+	for src, want := range map[string]StickyRoutingConfig{
+		`ai_router {
+			sticky_routing
+		}`: {},
+		`ai_router {
+			sticky_routing 20m
+		}`: {TTL: caddy.Duration(20 * time.Minute)},
+		`ai_router {
+			sticky_routing {
+				ttl 2h
+				store redis redis://cache:6379/0
+			}
+		}`: {TTL: caddy.Duration(2 * time.Hour), Store: "redis", DSN: "redis://cache:6379/0"},
+	} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(src)); err != nil {
+			t.Fatal(err)
+		}
+		if m.StickyRouting == nil || *m.StickyRouting != want {
+			t.Errorf("%s: sticky_routing = %+v, want %+v", src, m.StickyRouting, want)
+		}
+	}
+
+	var m RouterModule
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		sticky_routing forever
+	}`)); err == nil {
+		t.Error("an invalid ttl should be rejected")
+	}
+}
+
+func TestHedgingConfig(t *testing.T) {
+	for src, want := range map[string]HedgingConfig{
+		`ai_router {
+			hedging
+		}`: {},
+		`ai_router {
+			hedging 500ms
+		}`: {Delay: caddy.Duration(500 * time.Millisecond)},
+		`ai_router {
+			hedging {
+				delay 1s
+				budget 10%
+			}
+		}`: {Delay: caddy.Duration(time.Second), Budget: 0.1},
+	} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(src)); err != nil {
+			t.Fatal(err)
+		}
+		if m.Hedging == nil || *m.Hedging != want {
+			t.Errorf("%s: hedging = %+v, want %+v", src, m.Hedging, want)
+		}
+	}
+
+	for _, opt := range []string{"delay 0s", "budget 2", "budget lots"} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+			hedging {
+				` + opt + `
+			}
+		}`)); err == nil {
+			t.Errorf("hedging %s: expected an error", opt)
+		}
+	}
+}
+
+func TestSyntheticProvider(t *testing.T) {
+	var m RouterModule
+	err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		provider load {
+			style synthetic
+			synthetic {
+				tokens 256
+				tokens_per_second 40
+				first_token 300ms
+				error_rate 2%
+				models fast slow
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := m.ProviderConfigs["load"].Synthetic
+	if sc == nil || sc.Tokens != 256 || sc.TokensPerSecond != 40 || sc.FirstToken != caddy.Duration(300*time.Millisecond) ||
+		sc.ErrorRate != 0.02 || !slices.Equal(sc.Models, []string{"fast", "slow"}) {
+		t.Errorf("synthetic = %+v", sc)
+	}
+
+	for _, opt := range []string{"tokens 0", "tokens_per_second -1", "error_rate 150%", "models", "latency 1s"} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+			provider load {
+				style synthetic
+				synthetic {
+					` + opt + `
+				}
+			}
+		}`)); err == nil {
+			t.Errorf("synthetic %s: expected an error", opt)
+		}
+	}
+}
+
+func TestProviderHTTPClient(t *testing.T) {
+	var m RouterModule
+	err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+		provider openai {
+			api_base_url https://api.openai.com/v1
+			http_client {
+				max_idle_conns 128
+				idle_timeout 2m
+				proxy socks5://egress:1080
+				protocol http2
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HTTPClientConfig{MaxIdleConns: 128, IdleTimeout: caddy.Duration(2 * time.Minute), Proxy: "socks5://egress:1080", Protocol: "http2"}
+	if hc := m.ProviderConfigs["openai"].HTTPClient; hc == nil || *hc != want {
+		t.Errorf("http_client = %+v, want %+v", hc, want)
+	}
+
+	for _, opt := range []string{"max_idle_conns 0", "idle_timeout soon", "proxy ftp://egress", "proxy egress:1080", "protocol spdy"} {
+		var m RouterModule
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`ai_router {
+			provider openai {
+				http_client {
+					` + opt + `
+				}
+			}
+		}`)); err == nil {
+			t.Errorf("http_client %s: expected an error", opt)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// startAccessLog begins the access log entry for a client request. The
+// returned finish func writes one line, and emits the request.completed or
+// request.failed webhook, once the request is done. It is a no-op when the
+// router has neither an access log nor webhooks, and on InferFresh
+// re-entries, which fold into the outer request's entry.
+func startAccessLog(
+	router *modules.RouterModule,
+	w http.ResponseWriter,
+	r *http.Request,
+	requestedModel string,
+	prog *ail.Program,
+	chain *plugin.PluginChain,
+) (http.ResponseWriter, *http.Request, func()) {
+	logger, webhooks := router.Impl.AccessLog, router.Impl.Webhooks
+	if (logger == nil && webhooks == nil) || services.AccessRecordFrom(r.Context()) != nil {
+		return w, r, func() {}
+	}
+
+	rec := &services.AccessRecord{}
+	r = r.WithContext(services.ContextWithAccessRecord(r.Context(), rec))
+	sw := &statusWriter{ResponseWriter: w}
+	start := time.Now()
+
+	return sw, r, func() {
+		s := rec.Snapshot()
+		traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+		keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+		latency := time.Since(start)
+
+		if webhooks != nil {
+			event := services.WebhookRequestCompleted
+			if s.Outcome != outcomeSuccess {
+				event = services.WebhookRequestFailed
+			}
+			webhooks.Emit(event, map[string]any{
+				"trace_id":          traceID,
+				"key_id":            keyID,
+				"model_requested":   requestedModel,
+				"model_served":      s.Model,
+				"provider":          s.Provider,
+				"stream":            prog.IsStreaming(),
+				"status":            sw.Status(),
+				"outcome":           s.Outcome,
+				"attempts":          s.Attempts,
+				"latency_ms":        latency.Milliseconds(),
+				"prompt_tokens":     s.Usage.PromptTokens,
+				"completion_tokens": s.Usage.CompletionTokens,
+				"finish_reason":     s.FinishReason,
+				"meta":              plugin.ResponseMetaFrom(r.Context()).Meta(),
+			})
+		}
+		if logger == nil {
+			return
+		}
+		logger.Info("request",
+			zap.String("trace_id", traceID),
+			zap.String("router", router.Name),
+			zap.String("key_id", keyID),
+			zap.String("model_requested", requestedModel),
+			zap.String("model_served", s.Model),
+			zap.String("provider", s.Provider),
+			zap.Bool("stream", prog.IsStreaming()),
+			zap.Int("status", sw.Status()),
+			zap.String("outcome", s.Outcome),
+			zap.Int("attempts", s.Attempts),
+			zap.Int64("latency_ms", latency.Milliseconds()),
+			zap.Int("prompt_tokens", s.Usage.PromptTokens),
+			zap.Int("completion_tokens", s.Usage.CompletionTokens),
+			zap.Int("cached_tokens", s.Usage.CachedTokens),
+			zap.String("finish_reason", s.FinishReason),
+			zap.Any("meta", plugin.ResponseMetaFrom(r.Context()).Meta()),
+			zap.Strings("plugins", pluginNames(chain)))
+	}
+}
+
+// statusWriter records the response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Status returns the status written so far; 0 when nothing was written.
+func (w *statusWriter) Status() int { return w.status }
+
+var _ http.Flusher = (*statusWriter)(nil)
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// usageHandler serves a response carrying usage and a finish reason, the
+// way a driver reports them.
+type usageHandler struct{ recordingHandler }
+
+func (h *usageHandler) ServeNonStreaming(p *modules.ProviderConfig, cmd drivers.InferenceCommand, chain *plugin.PluginChain, prog *ail.Program, w http.ResponseWriter, r *http.Request) error {
+	res := ail.NewProgram()
+	res.EmitString(ail.RESP_DONE, "stop")
+	res.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":12,"completion_tokens":3}`))
+	services.AccessRecordFrom(r.Context()).ObserveResponse(res)
+	return h.recordingHandler.ServeNonStreaming(p, cmd, chain, prog, w, r)
+}
+
+func TestAccessLog_OneLinePerRequest(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	router := newTestRouter(a)
+	router.Name = "default"
+	core, logs := observer.New(zapcore.InfoLevel)
+	router.Impl.AccessLog = zap.New(core)
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextKeyID(), "env:test"))
+	chain := plugin.NewPluginChain()
+
+	w, r, finish := startAccessLog(router, httptest.NewRecorder(), r, "alias", prog, chain)
+
+	// A nested entry (InferFresh re-entry) must not log separately.
+	_, _, nestedFinish := startAccessLog(router, w, r, "m", prog, chain)
+	nestedFinish()
+
+	if err := RunInferencePipeline(router, chain, prog, w, r, &usageHandler{}, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected exactly one access log line, got %d", len(entries))
+	}
+	f := entries[0].ContextMap()
+	want := map[string]any{
+		"key_id":            "env:test",
+		"model_requested":   "alias",
+		"model_served":      "m",
+		"provider":          "a",
+		"status":            int64(http.StatusOK),
+		"outcome":           outcomeSuccess,
+		"prompt_tokens":     int64(12),
+		"completion_tokens": int64(3),
+		"finish_reason":     "stop",
+	}
+	for k, v := range want {
+		if f[k] != v {
+			t.Errorf("%s: got %v (%T), want %v", k, f[k], f[k], v)
+		}
+	}
+}
+
+func TestAccessLog_DisabledByDefault(t *testing.T) {
+	router := newTestRouter()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	_, r2, finish := startAccessLog(router, httptest.NewRecorder(), r, "m", ail.NewProgram(), plugin.NewPluginChain())
+	finish()
+	if services.AccessRecordFrom(r2.Context()) != nil {
+		t.Error("no access record should be attached when access logging is off")
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// admittedKey marks a request that already holds an admission slot so that
+// InferFresh re-entries don't queue a second time (which could deadlock
+// when the queue is saturated).
+type admittedKey struct{}
+
+// defaultQueueRetryAfter is advertised to clients turned away by a full
+// queue when no load shedder sets the back-off.
+const defaultQueueRetryAfter = time.Second
+
+// tenantID identifies the client for fair scheduling. Admission runs after
+// RequestPreamble, but the key and user IDs are usually still unset: the
+// built-in auth managers set nothing in CollectIncomingAuth, and the env
+// manager only sets them in CollectTargetAuth, once a provider is picked.
+// The ID branches fire for auth managers that set them on the incoming
+// request (and replays); otherwise the tenant is "auth:" plus a hash of the
+// Authorization header, or "ip:" plus the client IP for anonymous traffic.
+func tenantID(r *http.Request) string {
+	if v, _ := r.Context().Value(plugin.ContextKeyID()).(string); v != "" {
+		return v
+	}
+	if v, _ := r.Context().Value(plugin.ContextUserID()).(string); v != "" {
+		return v
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return services.AuthTenant(auth)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// admitRequest sheds the request when the process is overloaded, then waits
+// for a slot in the router's fair admission queue. On success it returns a
+// release func and the request marked as admitted. On rejection it writes
+// the error response and returns ok=false.
+func admitRequest(router *modules.RouterModule, w http.ResponseWriter, r *http.Request, logger *zap.Logger) (release func(), _ *http.Request, ok bool) {
+	if r.Context().Value(admittedKey{}) != nil {
+		return func() {}, r, true
+	}
+
+	tenant := tenantID(r)
+	priority := router.PriorityFor(tenant)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextPriority(), priority))
+
+	shedRelease, err := router.Impl.Shedder.Enter(priority)
+	if err != nil {
+		logger.Warn("request shed", zap.String("priority", priority.String()), zap.Error(err))
+		recordRejected(r, router.Name, outcomeShed)
+		re := services.NewRouterError(services.ErrorServerOverloaded, "The server is overloaded. Please retry later.")
+		re.RetryAfter = router.Impl.Shedder.RetryAfter()
+		writeRouterError(w, re)
+		return nil, r, false
+	}
+	admitted := r.WithContext(context.WithValue(r.Context(), admittedKey{}, true))
+	if router.Impl.Scheduler == nil {
+		return shedRelease, admitted, true
+	}
+
+	queueRelease, err := router.Impl.Scheduler.Admit(r.Context(), tenant, priority)
+	if err != nil {
+		shedRelease()
+		logger.Warn("request not admitted",
+			zap.String("tenant", tenant),
+			zap.String("priority", priority.String()),
+			zap.Int("queue_length", router.Impl.Scheduler.QueueLength()),
+			zap.Error(err))
+		switch {
+		case errors.Is(err, services.ErrQueueFull):
+			recordRejected(r, router.Name, outcomeQueueFull)
+			re := services.NewRouterError(services.ErrorQueueFull, "Too many queued requests. Please retry later.")
+			re.RetryAfter = router.Impl.Shedder.RetryAfter()
+			if re.RetryAfter <= 0 {
+				re.RetryAfter = defaultQueueRetryAfter
+			}
+			writeRouterError(w, re)
+		case errors.Is(err, services.ErrQueueTimeout):
+			recordRejected(r, router.Name, outcomeQueueTimeout)
+			writeRouterError(w, services.NewRouterError(services.ErrorQueueTimeout,
+				"Timed out waiting for capacity. Please retry later."))
+		case services.DeadlineExceeded(r.Context()):
+			recordRejected(r, router.Name, outcomeQueueTimeout)
+			writeRouterError(w, services.ErrRequestDeadline)
+		}
+		// Context cancellation: the client is gone, nothing to write.
+		return nil, r, false
+	}
+
+	return func() { queueRelease(); shedRelease() }, admitted, true
+}
+
+// requestPriority returns the priority class admission assigned to r.
+func requestPriority(r *http.Request) services.Priority {
+	if p, ok := r.Context().Value(plugin.ContextPriority()).(services.Priority); ok {
+		return p
+	}
+	return services.PriorityNormal
+}
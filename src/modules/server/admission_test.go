@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestTenantID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	if got := tenantID(r); got != "ip:10.0.0.1" {
+		t.Errorf("anonymous request: got %q, want ip:10.0.0.1", got)
+	}
+
+	r.Header.Set("Authorization", "Bearer sk-a")
+	a := tenantID(r)
+	if !strings.HasPrefix(a, "auth:") || strings.Contains(a, "sk-a") {
+		t.Errorf("authorized request should map to a hashed auth tenant, got %q", a)
+	}
+	r.Header.Set("Authorization", "Bearer sk-b")
+	if b := tenantID(r); b == a {
+		t.Errorf("different keys should map to different tenants, both %q", a)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextKeyID(), "key-1"))
+	if got := tenantID(r); got != "key-1" {
+		t.Errorf("key ID on context should win, got %q", got)
+	}
+}
+
+func TestAdmitRequest_ShedsWhenOverloaded(t *testing.T) {
+	router := newTestRouter()
+	router.Impl.Shedder = services.NewLoadShedder(services.LoadShedderConfig{MaxInFlight: 1, RetryAfter: 2 * time.Second})
+
+	release, _, ok := admitRequest(router, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), zap.NewNop())
+	if !ok {
+		t.Fatal("first request should be admitted")
+	}
+	defer release()
+
+	w := httptest.NewRecorder()
+	if _, _, ok := admitRequest(router, w, httptest.NewRequest(http.MethodPost, "/", nil), zap.NewNop()); ok {
+		t.Fatal("second request should be shed")
+	}
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 503 with Retry-After 2, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code := decodeErrorCode(t, w); code != "server_overloaded" {
+		t.Errorf("expected server_overloaded, got %q", code)
+	}
+}
+
+func TestAdmitRequest_QueueFullAdvertisesRetryAfter(t *testing.T) {
+	router := newTestRouter()
+	router.Impl.Scheduler = services.NewFairScheduler(services.FairSchedulerConfig{MaxInFlight: 1, MaxQueue: 1})
+
+	release, _, ok := admitRequest(router, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), zap.NewNop())
+	if !ok {
+		t.Fatal("first request should be admitted")
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		admitRequest(router, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx), zap.NewNop())
+	}()
+	defer func() { cancel(); <-waiting }()
+	for router.Impl.Scheduler.QueueLength() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	if _, _, ok := admitRequest(router, w, httptest.NewRequest(http.MethodPost, "/", nil), zap.NewNop()); ok {
+		t.Fatal("third request should be rejected")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code := decodeErrorCode(t, w); code != "queue_full" {
+		t.Errorf("expected queue_full, got %q", code)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported response encodings, in default preference order.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+// compressMinSize is the body size below which responses go out
+// uncompressed; small error bodies are not worth the framing.
+const compressMinSize = 1024
+
+// compression compresses complete (non-streaming) responses for clients
+// that accept it. SSE streams are never compressed: every event must reach
+// the client when it is flushed, and an outer encoder (such as Caddy's
+// encode) sees the Content-Encoding set here and leaves compressed bodies
+// alone. A nil *compression is disabled.
+type compression struct {
+	encodings []string // server preference order
+	binary    bool     // also compress binary AIL
+}
+
+// parseCompression resolves a module's compression option: nil or empty
+// enables every encoding, "off" disables compression.
+func parseCompression(names []string, binary bool) (*compression, error) {
+	if len(names) == 1 && names[0] == "off" {
+		return nil, nil
+	}
+	if len(names) == 0 {
+		names = []string{encodingZstd, encodingGzip}
+	}
+	for _, n := range names {
+		if n != encodingZstd && n != encodingGzip {
+			return nil, fmt.Errorf("unknown compression %q (want zstd, gzip or off)", n)
+		}
+	}
+	return &compression{encodings: names, binary: binary}, nil
+}
+
+// negotiate picks the encoding for an Accept-Encoding header: the highest
+// q-value the client gives, ties going to the server's preference.
+func (c *compression) negotiate(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	q := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	for _, enc := range c.encodings {
+		weight, ok := q[enc]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = enc, weight
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of content type ct is compressed.
+func (c *compression) compressible(ct string) bool {
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch mt {
+	case "application/json", "text/plain", "text/x-ail":
+		return true
+	case "application/x-ail", ailBatchContentType:
+		return c.binary
+	}
+	return false
+}
+
+// wrap returns w compressing for r's client, and a func that finishes the
+// body and must run before the handler returns.
+func (c *compression) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if c == nil {
+		return w, func() {}
+	}
+	enc := c.negotiate(r.Header.Get("Accept-Encoding"))
+	if enc == "" {
+		return w, func() {}
+	}
+	cw := &compressWriter{ResponseWriter: w, c: c, encoding: enc}
+	return cw, cw.close
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// compressing it pays off, then writes it through an encoder or as is.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compression
+	encoding string
+
+	status  int
+	buf     []byte
+	started bool // headers sent to the client
+	enc     io.WriteCloser
+	release func()
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.started || w.status != 0 {
+		return
+	}
+	w.status = status
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || !w.c.compressible(h.Get("Content-Type")) ||
+		status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.started {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < compressMinSize {
+			return len(b), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far, compressed if enough of it
+// has been buffered.
+func (w *compressWriter) Flush() {
+	if w.status != 0 && !w.started {
+		_ = w.start(len(w.buf) >= compressMinSize)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// start sends the headers and the buffered body, through an encoder when
+// compress is set.
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	h := w.Header()
+	if compress {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Add("Vary", "Accept-Encoding")
+		w.enc, w.release = newEncoder(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close writes out a body too small to compress, or ends the encoded one.
+func (w *compressWriter) close() {
+	if w.status != 0 && !w.started {
+		_ = w.start(false)
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.release()
+		w.enc = nil
+	}
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		gw, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return gw
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		zw, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return zw
+	}}
+)
+
+// newEncoder returns a pooled encoder writing to dst and a func returning
+// it to the pool once closed.
+func newEncoder(encoding string, dst io.Writer) (io.WriteCloser, func()) {
+	if encoding == encodingZstd {
+		zw := zstdWriters.Get().(*zstd.Encoder)
+		zw.Reset(dst)
+		return zw, func() { zstdWriters.Put(zw) }
+	}
+	gw := gzipWriters.Get().(*gzip.Writer)
+	gw.Reset(dst)
+	return gw, func() { gzipWriters.Put(gw) }
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompression_Negotiate(t *testing.T) {
+	c, _ := parseCompression(nil, false)
+	cases := map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"gzip, deflate, br, zstd":  "zstd",
+		"zstd;q=0.5, gzip":         "gzip",
+		"zstd;q=0, gzip;q=0":       "",
+		"*":                        "zstd",
+		"br, *;q=0.1":              "zstd",
+		"GZIP;q=0.8, zstd;q=0.001": "gzip",
+	}
+	for accept, want := range cases {
+		if got := c.negotiate(accept); got != want {
+			t.Errorf("%q: got %q, want %q", accept, got, want)
+		}
+	}
+
+	gzipOnly, _ := parseCompression([]string{"gzip"}, false)
+	if got := gzipOnly.negotiate("zstd, gzip"); got != "gzip" {
+		t.Errorf("gzip only: got %q", got)
+	}
+	if off, err := parseCompression([]string{"off"}, false); off != nil || err != nil {
+		t.Errorf("off = %v, %v", off, err)
+	}
+	if _, err := parseCompression([]string{"brotli"}, false); err == nil {
+		t.Error("unknown encoding accepted")
+	}
+}
+
+func compressResponse(t *testing.T, c *compression, accept, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Accept-Encoding", accept)
+	rec := httptest.NewRecorder()
+	w, finish := c.wrap(rec, r)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	// Written in pieces, as handlers do.
+	for len(body) > 0 {
+		n := min(len(body), 300)
+		if _, err := w.Write(body[:n]); err != nil {
+			t.Fatal(err)
+		}
+		body = body[n:]
+	}
+	finish()
+	return rec
+}
+
+func TestCompression_CompletesResponses(t *testing.T) {
+	c, _ := parseCompression(nil, false)
+	body := []byte(`{"choices":[{"message":{"content":"` + strings.Repeat("lorem ipsum ", 400) + `"}}]}`)
+
+	for _, enc := range []string{"gzip", "zstd"} {
+		rec := compressResponse(t, c, enc, "application/json", body)
+		if rec.Header().Get("Content-Encoding") != enc || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: headers = %v", enc, rec.Header())
+		}
+		var rd io.Reader
+		if enc == "gzip" {
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rd = gr
+		} else {
+			zr, err := zstd.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			rd = zr
+		}
+		got, err := io.ReadAll(rd)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Errorf("%s: round trip failed (%v), %d bytes", enc, err, len(got))
+		}
+	}
+
+	// Small bodies and binary AIL (unless enabled) go out as is.
+	for _, tc := range []struct {
+		ct   string
+		body []byte
+	}{
+		{"application/json", []byte(`{"error":{}}`)},
+		{"application/x-ail", bytes.Repeat([]byte{1}, 4096)},
+	} {
+		rec := compressResponse(t, c, "gzip", tc.ct, tc.body)
+		if rec.Header().Get("Content-Encoding") != "" || !bytes.Equal(rec.Body.Bytes(), tc.body) {
+			t.Errorf("%s: compressed unexpectedly", tc.ct)
+		}
+	}
+	withBinary, _ := parseCompression(nil, true)
+	if rec := compressResponse(t, withBinary, "gzip", "application/x-ail", bytes.Repeat([]byte{1}, 4096)); rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Error("binary AIL not compressed with compress_binary")
+	}
+}
+
+func TestCompression_LeavesStreamsAlone(t *testing.T) {
+	c, _ := parseCompression(nil, false)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	w, finish := c.wrap(rec, r)
+	defer finish()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, "data: {}\n\n")
+	w.(http.Flusher).Flush()
+	if rec.Body.String() != "data: {}\n\n" || !rec.Flushed || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("stream altered: flushed=%v headers=%v body=%q", rec.Flushed, rec.Header(), rec.Body.String())
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+// conversationKey returns a stable identifier for the conversation a
+// request belongs to, used for sticky provider selection. Falls back to the
+// trace ID (no stickiness) when the conversation can't be told; see
+// conversationID.
+func conversationKey(r *http.Request, prog *ail.Program) string {
+	if id := conversationID(r, prog); id != "" {
+		return id
+	}
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	return traceID
+}
+
+// conversationID identifies the conversation of a request. Prefers an
+// explicit X-Conversation-Id header; otherwise hashes the system prompt and
+// first user message, which stay constant as a conversation grows. Returns
+// "" when there is neither.
+func conversationID(r *http.Request, prog *ail.Program) string {
+	if id := r.Header.Get(plugin.ConversationIDHeader); id != "" {
+		return id
+	}
+
+	h := sha256.New()
+	h.Write([]byte(prog.SystemPrompt()))
+	for _, msg := range prog.MessagesByRole(ail.ROLE_USR) {
+		h.Write([]byte{0})
+		h.Write([]byte(prog.MessageText(msg)))
+		return hex.EncodeToString(h.Sum(nil)[:16])
+	}
+	return ""
+}
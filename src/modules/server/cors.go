@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// defaultCORSHeaders are the request headers browser clients of the
+// OpenAI, Anthropic and Google SDKs send, and the router's own. Entries
+// ending in "*" match by prefix.
+var defaultCORSHeaders = []string{
+	"Authorization", "Content-Type", "Accept", "X-Requested-With",
+	plugin.RequestIDHeader, "X-Stainless-*", "OpenAI-Organization",
+	"OpenAI-Project", "OpenAI-Beta", "X-Api-Key", "Anthropic-Version",
+	"Anthropic-Beta", "Anthropic-Dangerous-Direct-Browser-Access",
+	"X-Goog-Api-Key", "X-Goog-Api-Client", services.RequestTimeoutHeader,
+	dryRunHeader, plugin.ConversationIDHeader, debugPluginsHeader,
+}
+
+// corsExposedHeaders are the router's response headers scripts may read.
+var corsExposedHeaders = []string{
+	plugin.RequestIDHeader, "X-Real-Provider-Id", "X-Real-Model-Id",
+	"X-Plugins-Executed", "X-Plugin-Timings", requestCostHeader,
+	deprecationHeader, modelRewritesHeader, streamStatsHeader, plugin.MetaHeader, "Retry-After",
+}
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+// CORSModule answers CORS preflights and marks the responses of the
+// handlers after it readable by the allowed browser origins, so web apps
+// can call the AI endpoints directly. Origins are exact ("https://app.x"),
+// subdomain wildcards ("https://*.x") or "*", the default. Preflights for
+// disallowed origins get no CORS headers, which the browser treats as a
+// refusal.
+//
+// Caddyfile:
+//
+//	route {
+//	    ai_cors {
+//	        origins https://app.example.com https://*.example.com
+//	        headers X-Custom-*      # allowed on top of the SDK defaults
+//	        max_age 1h              # preflight cache, default 10m
+//	        credentials             # allow cookies; needs explicit origins
+//	    }
+//	    ai_inference_sse { ... }
+//	}
+type CORSModule struct {
+	Origins     []string       `json:"origins,omitempty"`
+	Headers     []string       `json:"headers,omitempty"`
+	MaxAge      caddy.Duration `json:"max_age,omitempty"`
+	Credentials bool           `json:"credentials,omitempty"`
+}
+
+func ParseCORSModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m CORSModule
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "origins":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.ArgErr()
+				}
+				m.Origins = append(m.Origins, args...)
+			case "headers":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.ArgErr()
+				}
+				m.Headers = append(m.Headers, args...)
+			case "max_age":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				d, err := caddy.ParseDuration(h.Val())
+				if err != nil || d < 0 {
+					return nil, h.Errf("invalid ai_cors max_age '%s'", h.Val())
+				}
+				m.MaxAge = caddy.Duration(d)
+			case "credentials":
+				m.Credentials = true
+			default:
+				return nil, h.Errf("unrecognized ai_cors option '%s'", h.Val())
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (*CORSModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_cors",
+		New: func() caddy.Module { return new(CORSModule) },
+	}
+}
+
+func (m *CORSModule) Provision(ctx caddy.Context) error {
+	if len(m.Origins) == 0 {
+		m.Origins = []string{"*"}
+	}
+	if m.MaxAge == 0 {
+		m.MaxAge = caddy.Duration(defaultCORSMaxAge)
+	}
+	if m.Credentials && slices.Contains(m.Origins, "*") {
+		return errors.New(`ai_cors: credentials need explicit origins, not "*"`)
+	}
+	return nil
+}
+
+func (m *CORSModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return next.ServeHTTP(w, r)
+	}
+	w.Header().Add("Vary", "Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	allowed := m.originAllowed(origin)
+	if allowed {
+		if slices.Contains(m.Origins, "*") && !m.Credentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if m.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if !preflight {
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		return next.ServeHTTP(w, r)
+	}
+
+	if allowed {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if headers := m.allowedHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(m.MaxAge).Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// originAllowed matches origin against the configured origins.
+func (m *CORSModule) originAllowed(origin string) bool {
+	for _, o := range m.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain, not the apex.
+		if scheme, host, ok := strings.Cut(o, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedHeaders returns the preflight's requested headers that are
+// allowed, as they were requested.
+func (m *CORSModule) allowedHeaders(requested string) string {
+	var out []string
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && (headerAllowed(defaultCORSHeaders, name) || headerAllowed(m.Headers, name)) {
+			out = append(out, name)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+func headerAllowed(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ caddy.Provisioner           = (*CORSModule)(nil)
+	_ caddyhttp.MiddlewareHandler = (*CORSModule)(nil)
+)
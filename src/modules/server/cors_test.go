@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func newCORS(t *testing.T, m *CORSModule) *CORSModule {
+	t.Helper()
+	if err := m.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func serveCORS(m *CORSModule, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	w := httptest.NewRecorder()
+	reached := false
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		reached = true
+		return nil
+	})
+	_ = m.ServeHTTP(w, r, next)
+	return w, reached
+}
+
+func corsPreflight(origin, headers string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/v1/chat/completions", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	r.Header.Set("Access-Control-Request-Headers", headers)
+	return r
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	m := newCORS(t, &CORSModule{Headers: []string{"X-Custom-*"}})
+
+	w, reached := serveCORS(m, corsPreflight("https://app.test", "authorization, content-type, x-stainless-os, x-custom-id, x-secret"))
+	if reached || w.Code != http.StatusNoContent {
+		t.Fatalf("preflight reached handler=%v status=%d", reached, w.Code)
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("headers = %v", h)
+	}
+	if got := h.Get("Access-Control-Allow-Headers"); got != "authorization, content-type, x-stainless-os, x-custom-id" {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+}
+
+func TestCORS_Origins(t *testing.T) {
+	m := newCORS(t, &CORSModule{Origins: []string{"https://app.test", "https://*.example.com"}, Credentials: true})
+
+	for origin, want := range map[string]bool{
+		"https://app.test":          true,
+		"https://a.b.example.com":   true,
+		"https://example.com":       false,
+		"http://a.example.com":      false,
+		"https://evil-app.test":     false,
+		"https://app.test.evil.com": false,
+	} {
+		w, _ := serveCORS(m, corsPreflight(origin, "authorization"))
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if (got == origin) != want || (!want && got != "") {
+			t.Errorf("%s: Allow-Origin = %q", origin, got)
+		}
+		if want && w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: credentials not allowed", origin)
+		}
+	}
+
+	if err := (&CORSModule{Credentials: true}).Provision(caddy.Context{}); err == nil {
+		t.Error("credentials with any origin should be rejected")
+	}
+}
+
+func TestCORS_ActualRequest(t *testing.T) {
+	m := newCORS(t, &CORSModule{})
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	r.Header.Set("Origin", "https://app.test")
+	w, reached := serveCORS(m, r)
+	if !reached {
+		t.Fatal("request did not reach the handler")
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		!strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "X-Real-Model-Id") {
+		t.Errorf("headers = %v", w.Header())
+	}
+
+	// Same-origin and non-browser requests pass through untouched.
+	w, reached = serveCORS(m, httptest.NewRequest(http.MethodOptions, "/", nil))
+	if !reached || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("request without Origin: reached=%v headers=%v", reached, w.Header())
+	}
+}
+
+func TestParseCORSModule(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`ai_cors {
+		origins https://app.test https://*.example.com
+		headers X-Custom-*
+		max_age 1h
+		credentials
+	}`)
+	h, err := ParseCORSModule(httpcaddyfile.Helper{Dispenser: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := h.(*CORSModule)
+	if len(m.Origins) != 2 || len(m.Headers) != 1 || !m.Credentials || time.Duration(m.MaxAge) != time.Hour {
+		t.Errorf("parsed = %+v", m)
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// requestCostHeader carries the USD cost of the upstream call that served
+// the response. Streaming responses send it as a trailer, since usage is
+// only known once the stream ends.
+const requestCostHeader = "X-Request-Cost"
+
+// announceRequestCost declares the cost trailer; call before the first
+// write of a streaming response.
+func announceRequestCost(w http.ResponseWriter) {
+	w.Header().Set("Trailer", requestCostHeader)
+}
+
+// setRequestCost sets the cost header for resProg's reported usage of model
+// on p. Nothing is set without usage or a known price.
+func setRequestCost(w http.ResponseWriter, p *services.ProviderService, model string, resProg *ail.Program) {
+	u, ok := services.UsageFromProgram(resProg)
+	if !ok {
+		return
+	}
+	if cost, ok := p.CostOf(model, u); ok {
+		w.Header().Set(requestCostHeader, strconv.FormatFloat(cost, 'f', -1, 64))
+	}
+}
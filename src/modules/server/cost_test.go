@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestSetRequestCost(t *testing.T) {
+	p := &services.ProviderService{Prices: map[string]services.ModelPrice{"m": {Input: 2, Output: 10}}}
+	res := ail.NewProgram()
+	res.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":1000,"completion_tokens":100}`))
+
+	w := httptest.NewRecorder()
+	setRequestCost(w, p, "m", res)
+	if got := w.Header().Get(requestCostHeader); got != "0.003" {
+		t.Errorf("%s = %q, want 0.003", requestCostHeader, got)
+	}
+
+	w = httptest.NewRecorder()
+	setRequestCost(w, p, "m", ail.NewProgram())
+	setRequestCost(w, p, "unpriced-model", res)
+	if got := w.Header().Get(requestCostHeader); got != "" {
+		t.Errorf("expected no cost without usage or price, got %q", got)
+	}
+}
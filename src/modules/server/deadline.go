@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// requestTimeoutField is the request body field a client may set its
+// timeout in instead of the header, in seconds.
+const requestTimeoutField = "request_timeout"
+
+// withRequestDeadline bounds the request by the timeout its client set in
+// X-Request-Timeout or the request_timeout body field (the header wins),
+// so admission, every provider attempt, tool loops and sidecar calls share
+// one budget. The field is removed from prog so it does not reach
+// upstreams. cancel must always be called. An invalid timeout is a 400.
+func withRequestDeadline(r *http.Request, prog *ail.Program) (_ *http.Request, cancel func(), _ *programError) {
+	raw, param := r.Header.Get(services.RequestTimeoutHeader), ""
+	for i, inst := range prog.Code {
+		if inst.Op != ail.EXT_DATA || inst.Key != requestTimeoutField {
+			continue
+		}
+		prog.Code = prog.ClearAtIndex(i).Code
+		if raw == "" {
+			param = requestTimeoutField
+			var secs json.Number
+			if err := json.Unmarshal(inst.JSON, &secs); err != nil {
+				raw = string(inst.JSON) // reported as invalid below
+			} else {
+				raw = secs.String()
+			}
+		}
+		break
+	}
+	if raw == "" {
+		return r, func() {}, nil
+	}
+	d, err := services.ParseRequestTimeout(raw)
+	if err != nil {
+		if param == "" {
+			param = services.RequestTimeoutHeader
+		}
+		return r, func() {}, &programError{
+			status:  http.StatusBadRequest,
+			code:    "invalid_request_timeout",
+			param:   param,
+			message: err.Error(),
+		}
+	}
+	ctx, cancel := services.WithRequestDeadline(r.Context(), d)
+	return r.WithContext(ctx), cancel, nil
+}
+
+// deadlineErr replaces err with the client deadline, as a RouterError,
+// when the request ran out of its client's time, whatever failed first as
+// a result.
+func deadlineErr(r *http.Request, err error) error {
+	if err != nil && services.DeadlineExceeded(r.Context()) {
+		return services.AsRouterError(services.ErrRequestDeadline)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func parseChatRequest(t *testing.T, body string) *ail.Program {
+	t.Helper()
+	parser, _ := ail.GetParser(ail.StyleChatCompletions)
+	prog, err := parser.ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func hasExt(prog *ail.Program, key string) bool {
+	for _, inst := range prog.Code {
+		if inst.Op == ail.EXT_DATA && inst.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWithRequestDeadline(t *testing.T) {
+	const body = `{"model":"m","request_timeout":30,"messages":[{"role":"user","content":"hi"}]}`
+
+	// The body field sets the deadline and does not reach upstreams.
+	prog := parseChatRequest(t, body)
+	r, cancel, perr := withRequestDeadline(httptest.NewRequest(http.MethodPost, "/", nil), prog)
+	defer cancel()
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if deadline, ok := r.Context().Deadline(); !ok || time.Until(deadline) > 30*time.Second || time.Until(deadline) < 29*time.Second {
+		t.Errorf("expected a 30s deadline, got %v, %v", deadline, ok)
+	}
+	if hasExt(prog, requestTimeoutField) {
+		t.Error("request_timeout should be removed from the program")
+	}
+
+	// The header wins over the field.
+	prog = parseChatRequest(t, body)
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(services.RequestTimeoutHeader, "2s")
+	r, cancel, perr = withRequestDeadline(r, prog)
+	defer cancel()
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if deadline, _ := r.Context().Deadline(); time.Until(deadline) > 2*time.Second {
+		t.Errorf("expected the header's 2s deadline, got %v", time.Until(deadline))
+	}
+	if hasExt(prog, requestTimeoutField) {
+		t.Error("request_timeout should be removed even when the header wins")
+	}
+
+	// No timeout, no deadline.
+	r, cancel, perr = withRequestDeadline(httptest.NewRequest(http.MethodPost, "/", nil), parseChatRequest(t, `{"model":"m","messages":[]}`))
+	defer cancel()
+	if _, ok := r.Context().Deadline(); ok || perr != nil {
+		t.Errorf("unexpected deadline or error: %v", perr)
+	}
+}
+
+func TestWithRequestDeadline_Invalid(t *testing.T) {
+	_, cancel, perr := withRequestDeadline(httptest.NewRequest(http.MethodPost, "/", nil),
+		parseChatRequest(t, `{"model":"m","request_timeout":"soon","messages":[]}`))
+	cancel()
+	if perr == nil || perr.status != http.StatusBadRequest || perr.param != requestTimeoutField {
+		t.Fatalf("expected a 400 on request_timeout, got %+v", perr)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(services.RequestTimeoutHeader, "-1")
+	_, cancel, perr = withRequestDeadline(r, parseChatRequest(t, `{"model":"m","messages":[]}`))
+	cancel()
+	if perr == nil || perr.code != "invalid_request_timeout" || perr.param != services.RequestTimeoutHeader {
+		t.Fatalf("expected a 400 on the header, got %+v", perr)
+	}
+}
+
+func TestPipeline_DeadlineReturns504(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"})
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	ctx, cancel := services.WithRequestDeadline(r.Context(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	h := &recordingHandler{}
+	w := httptest.NewRecorder()
+	err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, w, r.WithContext(ctx), h, zap.NewNop())
+	if len(h.served) != 0 {
+		t.Errorf("no provider should be tried past the deadline, got %v", h.served)
+	}
+	writeRouterError(w, err)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", w.Code)
+	}
+}
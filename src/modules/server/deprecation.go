@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+)
+
+// deprecationHeader tells clients the model they asked for is deprecated:
+// "<model>[; sunset=<YYYY-MM-DD>][; replacement=<model>]".
+const deprecationHeader = "X-Model-Deprecated"
+
+type deprecationKey struct{}
+
+// deprecation is the notice for a request naming a deprecated model.
+type deprecation struct {
+	Model       string // as requested, without plugins
+	Date        string
+	Replacement string // with the request's provider prefix
+	Redirected  bool
+}
+
+func (d *deprecation) String() string {
+	s := d.Model
+	if d.Date != "" {
+		s += "; sunset=" + d.Date
+	}
+	if d.Replacement != "" {
+		s += "; replacement=" + d.Replacement
+	}
+	return s
+}
+
+// checkDeprecated looks model up in router's catalog. For a deprecated
+// model it returns the notice, and the replacement (keeping model's plugin
+// suffix) as the model to use once the model is retired; otherwise model
+// unchanged and nil.
+func checkDeprecated(router *modules.RouterModule, model string, now time.Time) (string, *deprecation) {
+	base, suffix, _ := strings.Cut(model, "+")
+	info, ok := router.Impl.Catalog.Lookup(base)
+	if !ok || !info.IsDeprecated() {
+		return model, nil
+	}
+	dep := &deprecation{Model: base, Date: info.Deprecated, Replacement: info.ReplacedBy}
+	if prefix, _, found := strings.Cut(base, "/"); found && dep.Replacement != "" && !strings.Contains(dep.Replacement, "/") {
+		dep.Replacement = prefix + "/" + dep.Replacement
+	}
+	if !info.Retired(now) {
+		return model, dep
+	}
+	dep.Redirected = true
+	if suffix != "" {
+		return dep.Replacement + "+" + suffix, dep
+	}
+	return dep.Replacement, dep
+}
+
+// setDeprecationHeader echoes the request's deprecation notice, if any.
+func setDeprecationHeader(w http.ResponseWriter, r *http.Request) {
+	if dep, ok := r.Context().Value(deprecationKey{}).(*deprecation); ok {
+		w.Header().Set(deprecationHeader, dep.String())
+	}
+}
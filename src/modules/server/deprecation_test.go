@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestCheckDeprecated(t *testing.T) {
+	router := newTestRouter()
+	router.Impl.Catalog = services.NewCatalog(map[string]services.ModelInfo{
+		"old-model": {Deprecated: "2026-06-30", ReplacedBy: "new-model"},
+		"soon-gone": {Deprecated: "2026-06-30"},
+		"moved":     {ReplacedBy: "other/new-model"},
+		"new-model": {ContextWindow: 1000},
+	})
+	before := time.Date(2026, 6, 29, 23, 0, 0, 0, time.UTC)
+	after := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		model  string
+		now    time.Time
+		want   string
+		notice string
+	}{
+		{"p/old-model+slwin", before, "p/old-model+slwin", "p/old-model; sunset=2026-06-30; replacement=p/new-model"},
+		{"p/old-model+slwin", after, "p/new-model+slwin", "p/old-model; sunset=2026-06-30; replacement=p/new-model"},
+		{"soon-gone", after, "soon-gone", "soon-gone; sunset=2026-06-30"},
+		{"p/moved", before, "other/new-model", "p/moved; replacement=other/new-model"},
+		{"p/new-model", after, "p/new-model", ""},
+	} {
+		got, dep := checkDeprecated(router, tc.model, tc.now)
+		notice := ""
+		if dep != nil {
+			notice = dep.String()
+		}
+		if got != tc.want || notice != tc.notice {
+			t.Errorf("%s at %s = %q, %q; want %q, %q", tc.model, tc.now.Format(time.DateTime), got, notice, tc.want, tc.notice)
+		}
+	}
+}
+
+func TestDeprecatedModelRedirect(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+	router.Impl.Catalog = services.NewCatalog(map[string]services.ModelInfo{
+		"old-model": {Deprecated: "2020-01-01", ReplacedBy: "new-model"},
+	})
+	modules.RegisterRouter("deprecation-test", router)
+	m := &InferenceAILModule{RouterName: "deprecation-test", logger: zap.NewNop()}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SET_MODEL p/old-model\n"))
+	if err := m.ServeHTTP(w, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Real-Model-Id"); got != "new-model" {
+		t.Errorf("upstream model = %q", got)
+	}
+	if got := w.Header().Get(deprecationHeader); got != "p/old-model; sunset=2020-01-01; replacement=p/new-model" {
+		t.Errorf("%s = %q", deprecationHeader, got)
+	}
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// withClientCancel derives the request used for one streamed upstream call.
+// The server cancels the request context when it notices the client's
+// connection close; cancelling with services.ErrClientClosed as soon as a
+// write fails aborts the upstream request without waiting for that.
+func withClientCancel(r *http.Request) (*http.Request, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	return r.WithContext(ctx), cancel
+}
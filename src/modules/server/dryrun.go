@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+)
+
+// dryRunHeader asks an endpoint with dry_run enabled to stop at the
+// upstream request: the request goes through the preamble, plugin
+// resolution and the before-plugins of the provider that would serve it,
+// and the response describes what would be sent instead of sending it.
+const dryRunHeader = "X-Dry-Run"
+
+type dryRunCtxKey struct{}
+
+// DryRun is the response to a dry run.
+type DryRun struct {
+	Object   string `json:"object"` // "dry_run"
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Style    string `json:"style"`
+	*drivers.UpstreamRequest
+	// AIL is the disassembly of the program handed to the driver, which
+	// adapts it to the upstream style.
+	AIL string `json:"ail"`
+}
+
+// withDryRun marks r as a dry run when it asks for one. Asking an
+// endpoint without dry runs enabled is a 400, so a client never pays for
+// a request it meant to be dry.
+func withDryRun(r *http.Request, enabled bool) (*http.Request, bool, *programError) {
+	v := r.Header.Get(dryRunHeader)
+	if v == "" {
+		return r, false, nil
+	}
+	dry, err := strconv.ParseBool(v)
+	if err != nil {
+		return r, false, &programError{
+			status:  http.StatusBadRequest,
+			code:    "invalid_dry_run",
+			param:   dryRunHeader,
+			message: dryRunHeader + " must be true or false.",
+		}
+	}
+	if !dry {
+		return r, false, nil
+	}
+	if !enabled {
+		return r, false, &programError{
+			status:  http.StatusBadRequest,
+			code:    "dry_run_disabled",
+			param:   dryRunHeader,
+			message: "Dry runs are not enabled on this endpoint.",
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), dryRunCtxKey{}, true)), true, nil
+}
+
+func isDryRun(r *http.Request) bool {
+	dry, _ := r.Context().Value(dryRunCtxKey{}).(bool)
+	return dry
+}
+
+// writeDryRun answers a dry run with what cmd would send for prog.
+func writeDryRun(w http.ResponseWriter, p *modules.ProviderConfig, cmd drivers.InferenceCommand, prog *ail.Program) error {
+	up, err := drivers.DryRun(cmd, &p.Impl, prog)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(DryRun{
+		Object:          "dry_run",
+		Provider:        p.Name,
+		Model:           prog.GetModel(),
+		Style:           string(p.Impl.Style),
+		UpstreamRequest: up,
+		AIL:             prog.Disasm(),
+	})
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestWithDryRun(t *testing.T) {
+	for _, tc := range []struct {
+		header  string
+		enabled bool
+		dry     bool
+		code    string
+	}{
+		{"", false, false, ""},
+		{"false", false, false, ""},
+		{"true", true, true, ""},
+		{"1", true, true, ""},
+		{"true", false, false, "dry_run_disabled"},
+		{"maybe", true, false, "invalid_dry_run"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tc.header != "" {
+			r.Header.Set(dryRunHeader, tc.header)
+		}
+		r, dry, perr := withDryRun(r, tc.enabled)
+		code := ""
+		if perr != nil {
+			code = perr.code
+		}
+		if dry != tc.dry || isDryRun(r) != tc.dry || code != tc.code {
+			t.Errorf("%q enabled=%v: dry=%v code=%q", tc.header, tc.enabled, dry, code)
+		}
+	}
+}
+
+func TestPipeline_DryRun(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	a.Impl.RateLimiter = services.NewRateLimiter(services.RateLimitConfig{RPM: 1}, nil, 0)
+	router := newTestRouter(a)
+	u, _ := url.Parse("https://upstream.example/v1")
+	a.Impl.ParsedURL, a.Impl.Style = *u, ail.StyleChatCompletions
+	cmd, err := drivers.NewInferenceSse(ail.StyleChatCompletions, "/chat/completions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Impl.Commands["inference"] = cmd
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(dryRunHeader, "true")
+	r, _, _ = withDryRun(r, true)
+	// Dry runs take no rate-limit budget, so the second one passes too.
+	for range 2 {
+		prog := parseChatRequest(t, `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+		h := &recordingHandler{}
+		w := httptest.NewRecorder()
+		if err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, w, r, h, zap.NewNop()); err != nil {
+			t.Fatal(err)
+		}
+		if len(h.served) != 0 {
+			t.Fatal("a dry run was dispatched")
+		}
+		var got DryRun
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Object != "dry_run" || got.Provider != "a" || got.Model != "m" || got.Style != string(ail.StyleChatCompletions) ||
+			got.UpstreamRequest == nil || got.URL != "https://upstream.example/v1/chat/completions" || got.Requests != 1 {
+			t.Fatalf("unexpected dry run: %+v", got)
+		}
+		if !strings.Contains(string(got.Body), `"stream":true`) || !strings.Contains(got.AIL, "SET_STREAM") {
+			t.Errorf("unexpected upstream request: %s\n%s", got.Body, got.AIL)
+		}
+		if w.Header().Get("X-Real-Provider-Id") != "a" {
+			t.Errorf("missing provider header: %v", w.Header())
+		}
+	}
+}
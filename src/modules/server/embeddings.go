@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// EmbeddingsModule serves OpenAI-style embeddings requests. Providers are
+// tried in the order the router resolves for the model, like inference;
+// each splits an input list longer than it takes in one request into
+// batches (see the provider's embeddings_batch option), so clients need
+// not know any provider's limits.
+//
+// Caddyfile:
+//
+//	ai_embeddings {
+//	    router <name>
+//	    limits { ... }   # max_body_bytes applies; see IngressLimits
+//	}
+type EmbeddingsModule struct {
+	RouterName string         `json:"router,omitempty"`
+	Limits     *IngressLimits `json:"limits,omitempty"`
+	logger     *zap.Logger
+}
+
+func ParseEmbeddingsModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m EmbeddingsModule
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.RouterName = h.Val()
+			case "limits":
+				l, err := parseIngressLimits(h.Dispenser)
+				if err != nil {
+					return nil, err
+				}
+				m.Limits = l
+			default:
+				return nil, h.Errf("unrecognized ai_embeddings option '%s'", h.Val())
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (*EmbeddingsModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_embeddings",
+		New: func() caddy.Module { return new(EmbeddingsModule) },
+	}
+}
+
+func (m *EmbeddingsModule) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger(m)
+	return nil
+}
+
+func (m *EmbeddingsModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return nil
+	}
+	router, ok := modules.GetRouter(m.RouterName)
+	if !ok {
+		m.logger.Error("Router not found", zap.String("name", m.RouterName))
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
+		return nil
+	}
+
+	body, perr := m.Limits.readBody(w, r)
+	if perr != nil {
+		perr.write(w)
+		return nil
+	}
+	req, err := drivers.ParseEmbeddingsRequest(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "invalid_request")
+		return nil
+	}
+
+	r, err = router.Impl.Auth.CollectIncomingAuth(r)
+	if err != nil {
+		m.logger.Error("failed to collect incoming auth", zap.Error(err))
+		writePreambleError(w, err)
+		return nil
+	}
+
+	res, err := m.embed(router, req, r)
+	if err != nil {
+		writeRouterError(w, err)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
+
+// embed tries the providers of req's model in order until one answers.
+// Failures that would fail anywhere, such as an invalid request, end the
+// search.
+func (m *EmbeddingsModule) embed(router *modules.RouterModule, req *drivers.EmbeddingsRequest, r *http.Request) (*drivers.EmbeddingsResponse, error) {
+	names, model := router.ResolveProvidersOrderAndModel(req.Model)
+	var failures []services.Attempt
+	var lastErr error
+	for _, name := range names {
+		p, ok := router.Provider(name)
+		if !ok || !p.Impl.IsModelExported(model) {
+			continue
+		}
+		cmd, ok := p.Impl.Commands["embeddings"].(drivers.EmbeddingsCommand)
+		if !ok {
+			continue
+		}
+		providerReq := *req
+		providerReq.Model = model
+		res, err := cmd.DoEmbeddings(&p.Impl, &providerReq, r)
+		if err == nil {
+			return res, nil
+		}
+		if services.ClientGone(r.Context(), err) {
+			return nil, services.ErrClientClosed
+		}
+		m.logger.Warn("Embeddings failed", zap.String("provider", name), zap.String("model", model), zap.Error(err))
+		failures = append(failures, services.AttemptOf(name, err))
+		lastErr = err
+		if services.Classify(err) == services.Fatal {
+			break
+		}
+	}
+	if lastErr == nil {
+		return nil, services.NewRouterError(services.ErrorModelNotFound, "no provider serves embeddings for model "+req.Model)
+	}
+	if len(failures) > 1 {
+		return nil, services.WithAttempts(lastErr, failures)
+	}
+	return nil, services.AsRouterError(lastErr)
+}
+
+var (
+	_ caddy.Provisioner           = (*EmbeddingsModule)(nil)
+	_ caddyhttp.MiddlewareHandler = (*EmbeddingsModule)(nil)
+)
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// fakeEmbeddings is an EmbeddingsCommand that fails with err, or answers
+// each input with its position.
+type fakeEmbeddings struct {
+	err   error
+	calls int
+}
+
+func (f *fakeEmbeddings) DoEmbeddings(_ *services.ProviderService, req *drivers.EmbeddingsRequest, _ *http.Request) (*drivers.EmbeddingsResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := &drivers.EmbeddingsResponse{Object: "list", Model: req.Model}
+	for i := range req.Inputs {
+		res.Data = append(res.Data, drivers.Embedding{Object: "embedding", Index: i, Embedding: json.RawMessage(`[0]`)})
+	}
+	return res, nil
+}
+
+func TestEmbed(t *testing.T) {
+	req, err := drivers.ParseEmbeddingsRequest([]byte(`{"model":"e","input":["a","b"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := &modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"}, &modules.ProviderConfig{Name: "c"}
+	router := newTestRouter(a, b, c)
+	down := &fakeEmbeddings{err: &services.RouterError{Kind: services.ErrorProvider, UpstreamStatus: http.StatusServiceUnavailable, Message: "down"}}
+	up := &fakeEmbeddings{}
+	// a has no embeddings command and is passed over.
+	b.Impl.Commands["embeddings"] = down
+	c.Impl.Commands["embeddings"] = up
+
+	m := &EmbeddingsModule{logger: zap.NewNop()}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	res, err := m.embed(router, req, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data) != 2 || down.calls != 1 || up.calls != 1 {
+		t.Errorf("got %d embeddings after %d + %d calls", len(res.Data), down.calls, up.calls)
+	}
+
+	// An oversized input fails on every provider; c is not tried.
+	down.err = &services.RouterError{Kind: services.ErrorContextLength, UpstreamStatus: http.StatusBadRequest, Message: "input too long"}
+	if _, err := m.embed(router, req, r); err == nil || up.calls != 1 {
+		t.Errorf("fatal failure: err = %v, c called %d times", err, up.calls)
+	}
+
+	c.Impl.Commands["embeddings"] = &fakeEmbeddings{err: errors.New("boom")}
+	down.err = &services.RouterError{Kind: services.ErrorProvider, UpstreamStatus: http.StatusBadGateway, Message: "down"}
+	_, err = m.embed(router, req, r)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("all failed: err = %v", err)
+	}
+
+	delete(b.Impl.Commands, "embeddings")
+	delete(c.Impl.Commands, "embeddings")
+	var rerr *services.RouterError
+	if _, err := m.embed(router, req, r); !errors.As(err, &rerr) || rerr.Kind != services.ErrorModelNotFound {
+		t.Errorf("no provider: err = %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+)
+
+// writeJSONError writes an OpenAI-style error envelope with the given status.
+func writeJSONError(w http.ResponseWriter, status int, message, errType, code string) {
+	writeJSONParamError(w, status, message, errType, code, "")
+}
+
+// writeJSONParamError is writeJSONError naming the request parameter at
+// fault; an empty param is sent as null.
+func writeJSONParamError(w http.ResponseWriter, status int, message, errType, code, param string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": errorObject(message, errType, code, param),
+	})
+}
+
+// errorObject is the body of the OpenAI error envelope.
+func errorObject(message, errType, code, param string) map[string]any {
+	var p any
+	if param != "" {
+		p = param
+	}
+	return map[string]any{
+		"message": message,
+		"type":    errType,
+		"param":   p,
+		"code":    code,
+	}
+}
+
+// writeRouterError writes err, classified by services.AsRouterError, as an
+// OpenAI-style error. Once an SSE stream has started the status can no
+// longer change, so the error ends the stream as an event instead.
+func writeRouterError(w http.ResponseWriter, err error) {
+	re := services.AsRouterError(err)
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		sw := sse.NewWriter(w)
+		_ = writeStreamError(sw, re)
+		_ = sw.WriteDone()
+		return
+	}
+	if re.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(re.RetryAfter.Seconds()))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(re.HTTPStatus())
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": routerErrorObject(re)})
+}
+
+// routerErrorObject is the body of the error envelope for re, with the
+// attempts of a request that fell over between providers.
+func routerErrorObject(re *services.RouterError) map[string]any {
+	obj := errorObject(re.Message, re.Type(), re.Code(), "")
+	if len(re.Attempts) > 0 {
+		obj["attempts"] = re.Attempts
+	}
+	return obj
+}
+
+// writePreambleError answers a request RequestPreamble rejected: with the
+// RouterError it returned, or as an authentication failure.
+func writePreambleError(w http.ResponseWriter, err error) {
+	var re *services.RouterError
+	if errors.As(err, &re) {
+		writeRouterError(w, re)
+		return
+	}
+	writeJSONError(w, http.StatusUnauthorized, "authentication error", "authentication_error", "invalid_api_key")
+}
+
+// writeStreamError sends err as an "error" stream event carrying the
+// OpenAI error envelope. The event name and "type" field are what the
+// Anthropic and Responses SDKs look for; OpenAI chat clients read the
+// "error" object.
+func writeStreamError(sw *sse.Writer, err error) error {
+	re := services.AsRouterError(err)
+	data, jerr := json.Marshal(map[string]any{
+		"type":  "error",
+		"error": routerErrorObject(re),
+	})
+	if jerr != nil {
+		return jerr
+	}
+	return sw.WriteEvent("error", data)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+)
+
+func TestWriteRouterError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeRouterError(w, &services.RouterError{
+		Kind:       services.ErrorRateLimited,
+		Message:    "slow down",
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("got %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code := decodeErrorCode(t, w); code != "rate_limit_exceeded" {
+		t.Errorf("code = %q", code)
+	}
+
+	w = httptest.NewRecorder()
+	writeRouterError(w, services.PluginError(errors.New("boom")))
+	if w.Code != http.StatusInternalServerError || decodeErrorCode(t, w) != "plugin_error" {
+		t.Errorf("plugin error: %d", w.Code)
+	}
+}
+
+func TestWriteRouterError_StreamStarted(t *testing.T) {
+	w := httptest.NewRecorder()
+	_ = sse.NewWriter(w).WriteHeartbeat("ok")
+	writeRouterError(w, services.NewRouterError(services.ErrorContextLength, "too long"))
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Errorf("status changed mid-stream: %d", w.Code)
+	}
+	if !strings.Contains(body, "event: error\ndata: {") || !strings.Contains(body, `"type":"error"`) ||
+		!strings.Contains(body, `"code":"context_length_exceeded"`) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("stream = %q", body)
+	}
+}
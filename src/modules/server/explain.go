@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/uuid"
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+	"go.uber.org/zap"
+)
+
+// ExplainAdminAPI shows what a router would do with a request without
+// calling any provider: the virtual rewrites applied, the resolved plugin
+// chain (and which instances their matchers leave out), the provider
+// order, and for each provider the upstream program its before-plugins
+// prepare. Like the rest of the admin endpoint it needs no configuration.
+//
+// Route:
+//
+//	POST /ai/explain?router=<n>[&style=<style>|ail][&model=<model>][&path=/plugin:arg/...]
+//
+// The body is a client request in style (chat-completions by default), or
+// an AIL program (text or binary) with style=ail; model overrides its
+// model. path applies URL-path plugins, and the admin request's headers
+// stand in for the client's, for matchers and auth. Endpoint plugins are
+// not included. Before-plugins run for real, so one with side effects (a
+// remote plugin, say) has them; request-init, after and recursive
+// handlers do not run. The response is an Explanation.
+type ExplainAdminAPI struct {
+	logger *zap.Logger
+}
+
+// Explanation is what ExplainAdminAPI reports for a request.
+type Explanation struct {
+	RequestedModel string              `json:"requested_model"`
+	Model          string              `json:"model"` // after rewrites
+	Rewrites       []modelRewrite      `json:"rewrites,omitempty"`
+	Plugins        []ExplainedPlugin   `json:"plugins"`
+	Providers      []ExplainedProvider `json:"providers"`
+	// Error is why the request would be rejected before reaching any
+	// provider, an unmet plugin dependency say; Providers is empty then.
+	Error string `json:"error,omitempty"`
+}
+
+// ExplainedPlugin is a plugin instance of an Explanation's chain, in run
+// order.
+type ExplainedPlugin struct {
+	Name     string   `json:"name"`
+	Params   string   `json:"params,omitempty"`
+	When     string   `json:"when,omitempty"`
+	Selected bool     `json:"selected"` // false when the matcher leaves it out
+	Priority int      `json:"priority"`
+	FailOpen bool     `json:"fail_open,omitempty"`
+	Hooks    []string `json:"hooks"`
+}
+
+// ExplainedProvider is a provider of an Explanation, in the order they
+// would be tried. Skipped says why the pipeline would pass over it;
+// otherwise Upstream is the disassembly of the program it would receive,
+// or Error why the before-plugins failed.
+type ExplainedProvider struct {
+	Name     string            `json:"name"`
+	Model    string            `json:"model"`
+	Skipped  string            `json:"skipped,omitempty"`
+	Upstream string            `json:"upstream,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"` // promoted from SET_META x-*
+	Error    string            `json:"error,omitempty"`
+}
+
+func (ExplainAdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.ai_explain",
+		New: func() caddy.Module { return new(ExplainAdminAPI) },
+	}
+}
+
+func (a *ExplainAdminAPI) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger(a)
+	return nil
+}
+
+func (a *ExplainAdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/ai/explain", Handler: caddy.AdminHandlerFunc(a.handleExplain)},
+	}
+}
+
+func (a *ExplainAdminAPI) handleExplain(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	q := r.URL.Query()
+	router, ok := modules.GetRouter(q.Get("router"))
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q not found", q.Get("router")),
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	prog, err := parseExplainRequest(q.Get("style"), body)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	if model := q.Get("model"); model != "" {
+		prog.SetModel(model)
+	}
+	if prog.GetModel() == "" {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("request has no model")}
+	}
+
+	logger := a.logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	ex, err := explainRequest(r, router, q.Get("path"), prog, logger)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(ex)
+}
+
+// parseExplainRequest parses body as a client request in style, or as AIL.
+func parseExplainRequest(style string, body []byte) (*ail.Program, error) {
+	if style == "ail" {
+		return (&ailResponseParser{}).ParseResponse(body)
+	}
+	s, err := styles.ParseStyle(style)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := styles.GetParser(s)
+	if err != nil {
+		return nil, fmt.Errorf("no request parser for style %s: %w", s, err)
+	}
+	return parser.ParseRequest(body)
+}
+
+// explainRequest walks prog through router's request preamble and
+// provider loop up to, but not including, the upstream call.
+func explainRequest(admin *http.Request, router *modules.RouterModule, path string, prog *ail.Program, logger *zap.Logger) (*Explanation, error) {
+	r, err := http.NewRequestWithContext(admin.Context(), http.MethodPost, "/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header = admin.Header.Clone()
+	if r, err = router.Impl.Auth.CollectIncomingAuth(r); err != nil {
+		return nil, err
+	}
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), "explain-"+uuid.New().String()))
+
+	ex := &Explanation{RequestedModel: prog.GetModel()}
+	chain, model, rewrites, err := resolveModel(r, prog.GetModel(), conversationKey(r, prog), nil, logger)
+	if err != nil {
+		ex.Rewrites, ex.Error = rewrites, err.Error()
+		return ex, nil
+	}
+	if redirected, dep := checkDeprecated(router, model, time.Now()); dep != nil && dep.Redirected {
+		rewrites = append(rewrites, modelRewrite{From: model, To: redirected, Plugin: "deprecated"})
+		model = redirected
+	}
+	prog.SetModel(model)
+	applyRewriteParams(prog, rewrites)
+	ex.Model, ex.Rewrites = model, rewrites
+	for _, pi := range chain.GetPlugins() {
+		ex.Plugins = append(ex.Plugins, ExplainedPlugin{
+			Name:     pi.Plugin.Name(),
+			Params:   pi.Params,
+			When:     pi.When.String(),
+			Selected: pi.When.Match(r, prog),
+			Priority: plugin.PriorityOf(pi.Plugin),
+			FailOpen: pi.FailOpen,
+			Hooks:    pluginHooks(pi.Plugin),
+		})
+	}
+	chain = chain.Select(r, prog)
+	if err := chain.Check(); err != nil {
+		ex.Error = "plugins: " + err.Error()
+		return ex, nil
+	}
+
+	bypassExports := virtualRewrite(rewrites)
+	providers, providerModel := orderProviders(router, r, prog)
+	for _, name := range providers {
+		ep := ExplainedProvider{Name: name, Model: providerModel}
+		p, ok := router.Provider(name)
+		switch {
+		case !ok:
+			ep.Skipped = "provider not found"
+		case !bypassExports && !p.Impl.IsModelExported(providerModel):
+			ep.Skipped = "model not exported"
+		default:
+			if _, ok := p.Impl.Commands["inference"].(drivers.InferenceCommand); !ok {
+				ep.Skipped = "no inference support"
+			}
+		}
+		if ep.Skipped != "" {
+			ex.Providers = append(ex.Providers, ep)
+			continue
+		}
+
+		providerProg := prog.Clone()
+		providerProg.SetModel(providerModel)
+		providerProg, err := chain.RunBefore(&p.Impl, r, providerProg)
+		if err != nil {
+			ep.Error = err.Error()
+			ex.Providers = append(ex.Providers, ep)
+			continue
+		}
+		providerProg, err = preflight(router, p, r, providerProg, logger)
+		if err != nil {
+			ep.Error = "preflight: " + err.Error()
+			ex.Providers = append(ex.Providers, ep)
+			continue
+		}
+		h := http.Header{}
+		providerProg = promoteMetaHeaders(h, providerProg)
+		for k := range h {
+			if ep.Headers == nil {
+				ep.Headers = make(map[string]string)
+			}
+			ep.Headers[k] = h.Get(k)
+		}
+		ep.Upstream = providerProg.Disasm()
+		ex.Providers = append(ex.Providers, ep)
+	}
+	return ex, nil
+}
+
+// pluginHooks lists the hooks p implements.
+func pluginHooks(p plugin.Plugin) []string {
+	hooks := []string{}
+	add := func(ok bool, hook string) {
+		if ok {
+			hooks = append(hooks, hook)
+		}
+	}
+	_, ok := p.(plugin.ModelRewritePlugin)
+	add(ok, "model_rewrite")
+	_, ok = p.(plugin.RequestInitPlugin)
+	add(ok, "request_init")
+	_, ok = p.(plugin.RecursiveHandlerPlugin)
+	add(ok, "recursive_handler")
+	_, ok = p.(plugin.BeforePlugin)
+	add(ok, "before")
+	_, ok = p.(plugin.AfterPlugin)
+	add(ok, "after")
+	_, ok = p.(plugin.StreamChunkPlugin)
+	add(ok, "after_chunk")
+	_, ok = p.(plugin.StreamEndPlugin)
+	add(ok, "stream_end")
+	_, ok = p.(plugin.StreamTeePlugin)
+	add(ok, "tee_stream")
+	_, ok = p.(plugin.ErrorPlugin)
+	add(ok, "error")
+	return hooks
+}
+
+var (
+	_ caddy.AdminRouter = (*ExplainAdminAPI)(nil)
+	_ caddy.Provisioner = (*ExplainAdminAPI)(nil)
+)
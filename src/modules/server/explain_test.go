@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestExplainAdminAPI(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"})
+	router.Name = "explain-test"
+	router.Impl.Auth = services.NopAuthService{}
+	router.ProviderConfigs["b"].Impl.ExportedModels = map[string]bool{"other": true}
+	modules.RegisterRouter(router.Name, router)
+
+	body := `{"model":"m+slwin:1:0+fuzz@stream=true","messages":[{"role":"user","content":"one"},{"role":"user","content":"two"}]}`
+	a := &ExplainAdminAPI{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/ai/explain?router=explain-test", strings.NewReader(body))
+	if err := a.handleExplain(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var ex Explanation
+	if err := json.NewDecoder(w.Body).Decode(&ex); err != nil {
+		t.Fatal(err)
+	}
+	if ex.Model != ex.RequestedModel || len(ex.Rewrites) != 0 {
+		t.Errorf("model %q rewritten to %q (%+v)", ex.RequestedModel, ex.Model, ex.Rewrites)
+	}
+	if len(ex.Plugins) < 2 || ex.Plugins[0].Name != "slwin" || !ex.Plugins[0].Selected ||
+		ex.Plugins[1].Name != "fuzz" || ex.Plugins[1].Selected || ex.Plugins[1].When != "stream=true" {
+		t.Errorf("plugins = %+v", ex.Plugins)
+	}
+	if len(ex.Providers) != 2 {
+		t.Fatalf("providers = %+v", ex.Providers)
+	}
+	if a := ex.Providers[0]; a.Name != "a" || !strings.Contains(a.Upstream, "two") || strings.Contains(a.Upstream, "one") {
+		t.Errorf("provider a = %+v", a)
+	}
+	if b := ex.Providers[1]; b.Name != "b" || b.Skipped != "model not exported" {
+		t.Errorf("provider b = %+v", b)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/ai/explain?router=explain-test&style=ail&model=m", strings.NewReader("MSG_START\nROLE_USR\nTXT_CHUNK hi\nMSG_END\n"))
+	if err := a.handleExplain(w, r); err != nil {
+		t.Fatalf("AIL request: %v", err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/ai/explain?router=nope", strings.NewReader(body))
+	if err := a.handleExplain(httptest.NewRecorder(), r); err == nil {
+		t.Error("unknown router accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"go.uber.org/zap"
+)
+
+// HealthModule reports per-provider health as maintained by the router's
+// active health checker. Responds 200 when at least one provider is
+// healthy ("ok" or "degraded") and 503 when none are.
+//
+// Caddyfile:
+//
+//	handle_path /healthz {
+//	    ai_health {
+//	        router <name>
+//	    }
+//	}
+type HealthModule struct {
+	RouterName string `json:"router,omitempty"`
+	logger     *zap.Logger
+}
+
+func ParseHealthModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m HealthModule
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.RouterName = h.Val()
+			default:
+				return nil, h.Errf("unrecognized ai_health option '%s'", h.Val())
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (*HealthModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_health",
+		New: func() caddy.Module { return new(HealthModule) },
+	}
+}
+
+func (m *HealthModule) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger(m)
+	return nil
+}
+
+func (m *HealthModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	router, ok := modules.GetRouter(m.RouterName)
+	if !ok {
+		m.logger.Error("Router not found", zap.String("name", m.RouterName))
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
+		return nil
+	}
+
+	snapshot := router.HealthSnapshot()
+	healthy := 0
+	for _, st := range snapshot {
+		if st.Healthy {
+			healthy++
+		}
+	}
+
+	status := "ok"
+	code := http.StatusOK
+	switch {
+	case healthy == 0:
+		status = "unhealthy"
+		code = http.StatusServiceUnavailable
+	case healthy < len(snapshot):
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(map[string]any{
+		"status":    status,
+		"providers": snapshot,
+	})
+}
+
+var (
+	_ caddy.Provisioner           = (*HealthModule)(nil)
+	_ caddyhttp.MiddlewareHandler = (*HealthModule)(nil)
+)
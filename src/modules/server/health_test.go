@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestHealthModule(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	a.Impl.Health = services.NewProviderHealth(modules.HealthProbeTCP, 1)
+	b := &modules.ProviderConfig{Name: "b"}
+	b.Impl.Health = services.NewProviderHealth(modules.HealthProbeTCP, 1)
+	router := newTestRouter(a, b)
+	router.Name = "health-test"
+	modules.RegisterRouter(router.Name, router)
+
+	hm := &HealthModule{RouterName: router.Name, logger: zap.NewNop()}
+	check := func(wantCode int, wantStatus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if err := hm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), nil); err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Status string `json:"status"`
+		}
+		_ = json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != wantCode || body.Status != wantStatus {
+			t.Errorf("got %d %q, want %d %q", w.Code, body.Status, wantCode, wantStatus)
+		}
+	}
+
+	check(http.StatusOK, "ok")
+	a.Impl.Health.Report(errors.New("down"), 0)
+	check(http.StatusOK, "degraded")
+	b.Impl.Health.Report(errors.New("down"), 0)
+	check(http.StatusServiceUnavailable, "unhealthy")
+}
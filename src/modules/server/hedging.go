@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// dispatch is a provider readied for a request: its program has been
+// through the before-plugins, and it holds rate-limit budget and a
+// concurrency slot, which release gives back.
+type dispatch struct {
+	name    string
+	p       *modules.ProviderConfig
+	cmd     drivers.InferenceCommand
+	prog    *ail.Program
+	release func()
+}
+
+// hedgeResult is how one attempt at a request ended.
+type hedgeResult struct {
+	d      *dispatch
+	err    error
+	served bool // its response went to the client
+	lost   bool // cancelled once the other attempt served
+}
+
+// errHedgeLost is what the losing attempt of a hedged request gets for
+// its writes.
+var errHedgeLost = errors.New("hedged request served by another provider")
+
+// serveHedged serves primary and, when it has not started its response
+// within the hedging delay, also the backup that backup readies (it is
+// asked only then, and only while the budget lasts). The first attempt to
+// write more than SSE comments serves the client; the other is cancelled
+// and ends as if its client had left. The results list both attempts, or
+// primary alone when it was not hedged.
+func serveHedged(
+	routerName string,
+	h *services.Hedging,
+	primary *dispatch,
+	backup func() *dispatch,
+	serve func(*dispatch, http.ResponseWriter, *http.Request) error,
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *zap.Logger,
+) []hedgeResult {
+	gate := &hedgeGate{w: w, claimed: make(chan struct{})}
+	type attempt struct {
+		res    hedgeResult
+		hw     *hedgeWriter
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+	start := func(d *dispatch) *attempt {
+		ctx, cancel := context.WithCancel(r.Context())
+		a := &attempt{res: hedgeResult{d: d}, hw: gate.writer(), cancel: cancel, done: make(chan struct{})}
+		go func() {
+			defer close(a.done)
+			a.res.err = serve(d, a.hw, r.WithContext(ctx))
+		}()
+		return a
+	}
+
+	attempts := []*attempt{start(primary)}
+	timer := time.NewTimer(h.Delay)
+	defer timer.Stop()
+	delay, primaryDone := timer.C, attempts[0].done
+	var backupDone <-chan struct{}
+wait:
+	for primaryDone != nil || backupDone != nil {
+		select {
+		case <-gate.claimed:
+			break wait
+		case <-primaryDone:
+			primaryDone = nil
+		case <-backupDone:
+			backupDone = nil
+		case <-delay:
+			delay = nil
+			if !h.Spend() {
+				services.Metrics.Hedges.WithLabelValues(routerName, "over_budget").Inc()
+				continue
+			}
+			b := backup()
+			if b == nil {
+				continue
+			}
+			logger.Debug("No first token yet, hedging to the next provider",
+				zap.String("provider", primary.name),
+				zap.String("backup", b.name),
+				zap.Duration("delay", h.Delay))
+			a := start(b)
+			attempts, backupDone = append(attempts, a), a.done
+		}
+	}
+
+	// Without a claim, every attempt has ended: the first that succeeded
+	// without writing anything but comments serves what it wrote.
+	if !gate.settled() {
+		for _, a := range attempts {
+			if a.res.err == nil && gate.settle(a.hw) {
+				break
+			}
+		}
+	}
+	for _, a := range attempts {
+		if gate.won(a.hw) {
+			a.res.served = true
+			continue
+		}
+		select {
+		case <-a.done: // failed on its own
+		default:
+			a.res.lost = true
+			a.cancel()
+		}
+	}
+
+	results := make([]hedgeResult, len(attempts))
+	for i, a := range attempts {
+		<-a.done
+		a.cancel()
+		results[i] = a.res
+	}
+	if len(results) > 1 {
+		switch {
+		case results[1].served:
+			services.Metrics.Hedges.WithLabelValues(routerName, "won").Inc()
+		case results[0].served:
+			services.Metrics.Hedges.WithLabelValues(routerName, "lost").Inc()
+		default:
+			services.Metrics.Hedges.WithLabelValues(routerName, "failed").Inc()
+		}
+	}
+	return results
+}
+
+// hedgeGate passes the response of the first attempt of a hedged request
+// to write one through to the client; what the other writes is dropped.
+type hedgeGate struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	winner  *hedgeWriter
+	claimed chan struct{} // closed once there is a winner
+}
+
+// writer returns a writer for a new attempt, starting from the headers
+// set so far.
+func (g *hedgeGate) writer() *hedgeWriter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return &hedgeWriter{gate: g, header: g.w.Header().Clone()}
+}
+
+// claim makes hw the winner and writes what it held back; g.mu must be
+// held and there must be no winner yet.
+func (g *hedgeGate) claim(hw *hedgeWriter) error {
+	g.winner = hw
+	close(g.claimed)
+	h := g.w.Header()
+	clear(h)
+	maps.Copy(h, hw.header)
+	if hw.status != 0 {
+		g.w.WriteHeader(hw.status)
+	}
+	if hw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := g.w.Write(hw.buf.Bytes())
+	return err
+}
+
+// settle makes hw the winner unless there is one, reporting whether hw
+// is the winner.
+func (g *hedgeGate) settle(hw *hedgeWriter) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.winner == nil {
+		_ = g.claim(hw)
+	}
+	return g.winner == hw
+}
+
+func (g *hedgeGate) settled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.winner != nil
+}
+
+func (g *hedgeGate) won(hw *hedgeWriter) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.winner == hw
+}
+
+// hedgeWriter is one attempt's writer. Until some attempt wins it holds
+// the headers, status and SSE comments (heartbeat, keepalives) written to
+// it; its first other write wins the gate.
+type hedgeWriter struct {
+	gate   *hedgeGate
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (hw *hedgeWriter) Header() http.Header {
+	g := hw.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.winner == hw {
+		return g.w.Header()
+	}
+	return hw.header
+}
+
+func (hw *hedgeWriter) WriteHeader(code int) {
+	g := hw.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.winner == hw:
+		g.w.WriteHeader(code)
+	case g.winner == nil && hw.status == 0:
+		hw.status = code
+	}
+}
+
+func (hw *hedgeWriter) Write(b []byte) (int, error) {
+	g := hw.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.winner == hw:
+		return g.w.Write(b)
+	case g.winner != nil:
+		return 0, errHedgeLost
+	case bytes.HasPrefix(b, []byte(":")):
+		return hw.buf.Write(b)
+	}
+	if err := g.claim(hw); err != nil {
+		return 0, err
+	}
+	return g.w.Write(b)
+}
+
+func (hw *hedgeWriter) Flush() {
+	g := hw.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.w.(http.Flusher); ok && g.winner == hw {
+		f.Flush()
+	}
+}
+
+var _ http.Flusher = (*hedgeWriter)(nil)
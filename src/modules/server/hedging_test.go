@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowHandler answers with the provider's name, after sending a heartbeat
+// and waiting out the given delay (or the request's cancellation).
+type slowHandler struct {
+	mu        sync.Mutex
+	delay     map[string]time.Duration
+	served    []string
+	cancelled []string
+}
+
+func (h *slowHandler) ServeNonStreaming(p *modules.ProviderConfig, _ drivers.InferenceCommand, _ *plugin.PluginChain, _ *ail.Program, w http.ResponseWriter, r *http.Request) error {
+	h.mu.Lock()
+	h.served = append(h.served, p.Name)
+	h.mu.Unlock()
+	_, _ = w.Write([]byte(": heartbeat\n\n"))
+	select {
+	case <-time.After(h.delay[p.Name]):
+	case <-r.Context().Done():
+		h.mu.Lock()
+		h.cancelled = append(h.cancelled, p.Name)
+		h.mu.Unlock()
+		return services.ErrClientClosed
+	}
+	w.Header().Set("X-Answered-By", p.Name)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(p.Name))
+	return err
+}
+
+func (h *slowHandler) ServeStreaming(p *modules.ProviderConfig, cmd drivers.InferenceCommand, chain *plugin.PluginChain, prog *ail.Program, w http.ResponseWriter, r *http.Request) error {
+	return h.ServeNonStreaming(p, cmd, chain, prog, w, r)
+}
+
+func newHedgingRouter(name string, budget float64) *modules.RouterModule {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"})
+	router.Name = name
+	router.Impl.Hedging = services.NewHedging(20*time.Millisecond, budget)
+	return router
+}
+
+func TestPipeline_HedgesSlowProvider(t *testing.T) {
+	router := newHedgingRouter("hedge-test", 1)
+	won := services.Metrics.Hedges.WithLabelValues("hedge-test", "won")
+	before := testutil.ToFloat64(won)
+
+	h := &slowHandler{delay: map[string]time.Duration{"a": time.Minute}}
+	w := runTestPipeline(t, router, h, nil)
+
+	if got := w.Body.String(); got != ": heartbeat\n\nb" {
+		t.Errorf("body %q, want b's answer alone", got)
+	}
+	if got := w.Header().Get("X-Real-Provider-Id"); got != "b" {
+		t.Errorf("X-Real-Provider-Id %q, want b", got)
+	}
+	if got := w.Header().Get("X-Answered-By"); got != "b" {
+		t.Errorf("X-Answered-By %q, want b", got)
+	}
+	if len(h.cancelled) != 1 || h.cancelled[0] != "a" {
+		t.Errorf("cancelled %v, want the slow primary", h.cancelled)
+	}
+	if got := testutil.ToFloat64(won) - before; got != 1 {
+		t.Errorf("expected one hedge won, got %v", got)
+	}
+}
+
+func TestPipeline_FastProviderNotHedged(t *testing.T) {
+	router := newHedgingRouter("hedge-fast-test", 1)
+	h := &slowHandler{}
+	w := runTestPipeline(t, router, h, nil)
+
+	if len(h.served) != 1 || h.served[0] != "a" {
+		t.Errorf("served %v, want only a", h.served)
+	}
+	if got := w.Body.String(); got != ": heartbeat\n\na" {
+		t.Errorf("body %q, want a's answer", got)
+	}
+}
+
+func TestPipeline_HedgingBudget(t *testing.T) {
+	router := newHedgingRouter("hedge-budget-test", 0.25)
+	overBudget := services.Metrics.Hedges.WithLabelValues("hedge-budget-test", "over_budget")
+	before := testutil.ToFloat64(overBudget)
+
+	h := &slowHandler{delay: map[string]time.Duration{"a": 50 * time.Millisecond}}
+	w := runTestPipeline(t, router, h, nil)
+	if len(h.served) != 1 || w.Body.String() != ": heartbeat\n\na" {
+		t.Errorf("hedged without budget: served %v, body %q", h.served, w.Body.String())
+	}
+	if got := testutil.ToFloat64(overBudget) - before; got != 1 {
+		t.Errorf("expected one over-budget hedge, got %v", got)
+	}
+}
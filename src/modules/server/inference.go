@@ -2,15 +2,20 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
 	"github.com/neutrome-labs/open-ai-router/src/modules"
 	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/plugins/dspy"
+	"github.com/neutrome-labs/open-ai-router/src/services"
 
+	"github.com/google/uuid"
 	"github.com/neutrome-labs/ail"
 	"go.uber.org/zap"
 )
@@ -43,6 +48,44 @@ type InferenceHandler interface {
 	) error
 }
 
+// routingStrategy returns the X-Routing-Strategy header when it names a
+// known strategy, or "" to use the router default.
+func routingStrategy(r *http.Request) string {
+	s := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Routing-Strategy")))
+	if !modules.IsRoutingStrategy(s) {
+		return ""
+	}
+	return s
+}
+
+// orderProviders returns the providers to try for prog, in order, and the
+// model to ask them for. Pinned providers (explicit prefix or
+// default_provider_for_model) keep their place; balancing, the routing
+// strategy and sticky routing only reorder the fallbacks. Health demotion
+// applies to all of them.
+func orderProviders(router *modules.RouterModule, r *http.Request, prog *ail.Program) ([]string, string) {
+	pinned, rest, model := router.ResolveProviders(prog.GetModel())
+	rest = router.OrderProviders(routingStrategy(r), model, rest, prog, conversationKey(r, prog))
+	if affinity := router.Impl.Affinity; affinity != nil {
+		if last, ok := affinity.Provider(r.Context(), conversationID(r, prog), model); ok {
+			rest = preferProvider(rest, last)
+		}
+	}
+	return router.DemoteUnhealthy(append(append([]string(nil), pinned...), rest...)), model
+}
+
+// preferProvider moves name to the front of providers, if it is there.
+func preferProvider(providers []string, name string) []string {
+	i := slices.Index(providers, name)
+	if i <= 0 {
+		return providers
+	}
+	out := make([]string, 0, len(providers))
+	out = append(out, name)
+	out = append(out, providers[:i]...)
+	return append(out, providers[i+1:]...)
+}
+
 // RunInferencePipeline executes the common provider iteration loop used by
 // every endpoint module. It resolves providers, iterates them in order,
 // runs before-plugins, samples AIL, sets response headers, builds X-Plugins-Executed,
@@ -56,24 +99,58 @@ func RunInferencePipeline(
 	handler InferenceHandler,
 	logger *zap.Logger,
 ) error {
-	providers, model := router.ResolveProvidersOrderAndModel(prog.GetModel())
+	providers, model := orderProviders(router, r, prog)
+	// Taken before the before-plugins rewrite the conversation.
+	var conversation string
+	if router.Impl.Affinity != nil {
+		conversation = conversationID(r, prog)
+	}
 
 	logger.Debug("Resolved providers",
 		zap.String("model", model),
 		zap.Strings("providers", providers),
 		zap.Int("plugin_count", len(chain.GetPlugins())))
 
+	// Request metrics: outcome is overwritten on every exit path.
+	stats := requestMetrics{
+		router:  router.Name,
+		access:  services.AccessRecordFrom(r.Context()),
+		start:   time.Now(),
+		outcome: outcomeNoProvider,
+	}
+	defer stats.record()
+
 	var displayErr error
+	var failures []services.Attempt // every provider's failure, in order
 	bypassExports, _ := r.Context().Value(exportsCheckBypassedKey{}).(bool)
 	modelNotExported := false
+	rateLimited := false
+	var retryAfter time.Duration
+	var rateLimitDeadline time.Time
+	saturated := false
 
-	for _, name := range providers {
+	// ready readies provider name for dispatch: before-plugins,
+	// preflight, cooldown, rate-limit budget and a concurrency slot. It
+	// returns nil when the provider is skipped, and stop when the request
+	// ends here, with the error to return.
+	ready := func(name string) (d *dispatch, stop bool, err error) {
+		// Once the client has gone nobody reads the answer; don't fall
+		// over to (and bill) another provider. Past the client's deadline
+		// there is no time left to try one.
+		if services.DeadlineExceeded(r.Context()) {
+			stats.outcome = outcomeError
+			return nil, true, services.ErrRequestDeadline
+		}
+		if r.Context().Err() != nil {
+			stats.outcome = outcomeClientClosed
+			return nil, true, nil
+		}
 		logger.Debug("Trying provider", zap.String("provider", name))
 
-		p, ok := router.ProviderConfigs[name]
+		p, ok := router.Provider(name)
 		if !ok {
 			logger.Error("provider not found", zap.String("name", name))
-			continue
+			return nil, false, nil
 		}
 
 		// Check exports filter. When a virtual provider rewrote the model
@@ -84,13 +161,13 @@ func RunInferencePipeline(
 				zap.String("provider", name),
 				zap.String("model", model))
 			modelNotExported = true
-			continue
+			return nil, false, nil
 		}
 
 		cmd, ok := p.Impl.Commands["inference"].(drivers.InferenceCommand)
 		if !ok {
 			logger.Debug("Provider does not support inference", zap.String("provider", name))
-			continue
+			return nil, false, nil
 		}
 
 		// Clone the program and set the resolved model.
@@ -103,140 +180,532 @@ func RunInferencePipeline(
 			logger.Error("plugin before hook error",
 				zap.String("provider", name), zap.Error(err))
 			if displayErr == nil {
-				displayErr = err
+				displayErr = services.PluginError(err)
 			}
-			continue
+			failures = append(failures, services.AttemptOf(name, services.PluginError(err)))
+			return nil, false, nil
 		}
 		providerProg = processedProg
 
-		logger.Debug("Executing inference",
-			zap.String("provider", name),
-			zap.String("style", string(p.Impl.Style)),
-			zap.Bool("streaming", providerProg.IsStreaming()))
-
-		// Set response headers.
-		w.Header().Set("X-Real-Provider-Id", name)
-		w.Header().Set("X-Real-Model-Id", model)
-
-		// Build X-Plugins-Executed header.
-		var pluginNames []string
-		for _, pi := range chain.GetPlugins() {
-			pname := pi.Plugin.Name()
-			if strings.HasPrefix(pname, "virtual") {
-				continue
+		// Check the request against what the catalog knows of the model;
+		// another provider's entry may still admit it.
+		providerProg, err = preflight(router, p, r, providerProg, logger)
+		if err != nil {
+			logger.Debug("Request fails preflight, skipping provider",
+				zap.String("provider", name), zap.Error(err))
+			if displayErr == nil {
+				displayErr = err
+			}
+			failures = append(failures, services.AttemptOf(name, err))
+			return nil, false, nil
+		}
+
+		// Skip provider/model pairs still cooling down after an upstream
+		// 429; they count as rate limited for the final response.
+		if left, ok := router.Impl.Cooldowns.Remaining(r.Context(), name, providerProg.GetModel()); ok {
+			logger.Debug("Provider cooling down, skipping",
+				zap.String("provider", name),
+				zap.String("model", providerProg.GetModel()),
+				zap.Duration("remaining", left))
+			if !rateLimited || left < retryAfter {
+				retryAfter = left
 			}
-			if pi.Params != "" {
-				pname += ":" + pi.Params
+			rateLimited = true
+			return nil, false, nil
+		}
+
+		// A dry run answers with the upstream request instead, before any
+		// rate-limit budget or concurrency slot is taken.
+		if isDryRun(r) {
+			providerProg, cmd = prepareDispatch(w, router, chain, p, model, providerProg, cmd)
+			if err := writeDryRun(w, p, cmd, providerProg); err != nil {
+				stats.outcome = outcomeError
+				return nil, true, err
 			}
-			pluginNames = append(pluginNames, pname)
+			stats.outcome, stats.provider, stats.model = outcomeDryRun, name, model
+			return nil, true, nil
 		}
-		if len(pluginNames) > 0 {
-			w.Header().Set("X-Plugins-Executed", strings.Join(pluginNames, ","))
+
+		// Wait for rate-limit budget; fall through to the next provider
+		// when this one cannot admit the request in time.
+		// The first limiter consulted fixes the request's total wait
+		// budget, so trying several providers cannot multiply it.
+		if p.Impl.RateLimiter != nil && rateLimitDeadline.IsZero() {
+			rateLimitDeadline = time.Now().Add(p.Impl.RateLimiter.MaxWait)
+		}
+		cancelBudget, err := p.Impl.RateLimiter.AcquireWithin(r.Context(), model,
+			services.EstimateTokens(providerProg), max(0, time.Until(rateLimitDeadline)))
+		if err != nil {
+			var rlErr *services.RateLimitError
+			if errors.As(err, &rlErr) {
+				logger.Debug("Provider rate limited, skipping",
+					zap.String("provider", name),
+					zap.String("model", model),
+					zap.Duration("retry_after", rlErr.RetryAfter))
+				if !rateLimited || rlErr.RetryAfter < retryAfter {
+					retryAfter = rlErr.RetryAfter
+				}
+				rateLimited = true
+				return nil, false, nil
+			}
+			if services.ClientGone(r.Context(), err) {
+				stats.outcome = outcomeClientClosed
+				return nil, true, nil
+			}
+			stats.outcome = outcomeError
+			return nil, true, err
 		}
 
-		// Promote SET_META keys starting with "x-" to response headers and
-		// strip them from the program so they do not leak to the provider.
-		var metaToRemove []int
-		for i, inst := range providerProg.Code {
-			if inst.Op == ail.SET_META && strings.HasPrefix(inst.Key, "x-") {
-				w.Header().Set(inst.Key, inst.Str)
-				metaToRemove = append(metaToRemove, i)
+		// Take a concurrency slot, waiting up to the provider's grace
+		// period; fall through to the next provider when saturated.
+		release, err := p.Impl.Concurrency.Acquire(r.Context(), requestPriority(r))
+		if err != nil {
+			// The request is never sent; give the rate-limit budget back.
+			cancelBudget()
+			if errors.Is(err, services.ErrProviderSaturated) {
+				logger.Debug("Provider saturated, skipping",
+					zap.String("provider", name),
+					zap.Int("in_flight", p.Impl.Concurrency.InFlight()))
+				saturated = true
+				return nil, false, nil
+			}
+			if services.ClientGone(r.Context(), err) {
+				stats.outcome = outcomeClientClosed
+				return nil, true, nil
 			}
+			stats.outcome = outcomeError
+			return nil, true, err
+		}
+
+		return &dispatch{name: name, p: p, cmd: cmd, prog: providerProg, release: release}, false, nil
+	}
+
+	// serve dispatches d to the module's handler. Successful latency
+	// samples are taken by the driver at the upstream's first byte;
+	// failures are penalized by failed.
+	serve := func(d *dispatch, w http.ResponseWriter, r *http.Request) error {
+		defer d.release()
+		logger.Debug("Executing inference",
+			zap.String("provider", d.name),
+			zap.String("style", string(d.p.Impl.Style)),
+			zap.Bool("streaming", d.prog.IsStreaming()))
+
+		prog, cmd := prepareDispatch(w, router, chain, d.p, model, d.prog, d.cmd)
+		if prog.IsStreaming() {
+			return handler.ServeStreaming(d.p, cmd, chain, prog, w, r)
+		}
+		return handler.ServeNonStreaming(d.p, cmd, chain, prog, w, r)
+	}
+
+	// failed records d's failure, reporting whether it is fatal: the
+	// request would fail on any provider, so there is no falling over, and
+	// it is the error the client sees.
+	failed := func(d *dispatch, err error) (fatal bool) {
+		d.p.Impl.Latency.ObserveFailure()
+		services.Metrics.ProviderErrors.WithLabelValues(router.Name, d.name).Inc()
+		failures = append(failures, services.AttemptOf(d.name, err))
+		if services.Classify(err) == services.Fatal {
+			logger.Debug("Request cannot succeed on another provider",
+				zap.String("provider", d.name), zap.Error(err))
+			displayErr = err
+			return true
+		}
+		if displayErr == nil {
+			displayErr = err
 		}
-		if len(metaToRemove) > 0 {
-			providerProg = providerProg.ClearAtIndex(metaToRemove...)
+		return false
+	}
+
+	// A request slow to its first token may be hedged to the next
+	// provider; see serveHedged. Dry runs never get that far, and a
+	// WebSocket carries one answer at a time.
+	hedging := router.Impl.Hedging
+	if isDryRun(r) || wsConnOf(w) != nil {
+		hedging = nil
+	}
+	hedging.Earn()
+
+	for next := 0; next < len(providers); {
+		d, stop, err := ready(providers[next])
+		next++
+		if stop {
+			return err
+		}
+		if d == nil {
+			continue
 		}
 
-		// Dispatch to module-specific handler.
-		if providerProg.IsStreaming() {
-			err = handler.ServeStreaming(p, cmd, chain, providerProg, w, r)
+		stats.attempts++
+		var attempts []hedgeResult
+		if hedging != nil {
+			backup := func() *dispatch {
+				for next < len(providers) {
+					b, stop, _ := ready(providers[next])
+					next++
+					if stop {
+						return nil
+					}
+					if b != nil {
+						stats.attempts++
+						return b
+					}
+				}
+				return nil
+			}
+			attempts = serveHedged(router.Name, hedging, d, backup, serve, w, r, logger)
 		} else {
-			err = handler.ServeNonStreaming(p, cmd, chain, providerProg, w, r)
+			attempts = []hedgeResult{{d: d, err: serve(d, w, r), served: true}}
 		}
 
+		var served *hedgeResult
+		fatal := false
+		for i, a := range attempts {
+			switch {
+			case a.served:
+				served = &attempts[i]
+			case a.lost || a.err == nil || services.ClientGone(r.Context(), a.err):
+			default:
+				fatal = failed(a.d, a.err) || fatal
+			}
+		}
+		if served == nil {
+			if fatal {
+				break
+			}
+			continue
+		}
+		name, err := served.d.name, served.err
+
+		if err != nil && services.ClientGone(r.Context(), err) {
+			logger.Debug("Client disconnected, upstream request cancelled",
+				zap.String("provider", name), zap.String("model", model))
+			stats.outcome, stats.provider, stats.model = outcomeClientClosed, name, model
+			return nil
+		}
 		if err != nil {
-			if displayErr == nil {
-				displayErr = err
+			if failed(served.d, err) {
+				break
 			}
 			continue
 		}
 
+		stats.outcome, stats.provider, stats.model = outcomeSuccess, name, model
+		router.Impl.Affinity.Record(r.Context(), conversation, model, name)
 		return nil
 	}
 
+	// The first failure decides the status; the others are listed with it
+	// so the client can see why each fallback failed too.
 	if displayErr != nil {
-		return displayErr
+		stats.outcome = outcomeError
+		return services.WithAttempts(displayErr, failures)
+	}
+
+	// Every candidate provider was out of rate-limit budget.
+	if rateLimited {
+		stats.outcome = outcomeRateLimited
+		keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+		router.Impl.Webhooks.Emit(services.WebhookBudgetExceeded, map[string]any{
+			"key_id":         keyID,
+			"model":          model,
+			"providers":      providers,
+			"retry_after_ms": retryAfter.Milliseconds(),
+		})
+		writeRouterError(w, &services.RouterError{
+			Kind:       services.ErrorRateLimited,
+			Message:    fmt.Sprintf("Rate limit reached for model `%s`. Please retry later.", model),
+			RetryAfter: retryAfter,
+		})
+		return nil
+	}
+
+	// Every candidate provider was at its concurrency cap.
+	if saturated {
+		stats.outcome = outcomeOverloaded
+		writeRouterError(w, services.NewRouterError(services.ErrorOverloaded,
+			fmt.Sprintf("All providers for model `%s` are at capacity. Please retry later.", model)))
+		return nil
 	}
 
 	// If every candidate provider was skipped because of exports filtering,
 	// emit a proper model-not-found JSON error so the client sees a clear
 	// 404 rather than an empty response.
 	if modelNotExported {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"error": map[string]any{
-				"message": fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model),
-				"type":    "invalid_request_error",
-				"param":   nil,
-				"code":    "model_not_found",
-			},
-		})
+		stats.outcome = outcomeModelNotFound
+		writeRouterError(w, services.NewRouterError(services.ErrorModelNotFound,
+			fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model)))
 		return nil
 	}
 
+	// No provider could take the request at all: none is configured for
+	// the model, or none of them serves inference.
+	writeRouterError(w, services.NewRouterError(services.ErrorModelNotFound,
+		fmt.Sprintf("No provider is available for model `%s`.", model)))
 	return nil
 }
 
-// RequestPreamble performs the common request setup shared by all endpoint
-// modules: auth collection, virtual model aliasing, plugin resolution, and
-// trace ID generation.
+// requestTraceID returns the request's trace ID: the one already in the
+// context on InferFresh re-entry, else a well-formed incoming X-Request-Id,
+// else a fresh UUID. Clients reusing an ID should keep it unique per
+// request, since per-request plugin state (e.g. the sampler) is keyed on it.
+func requestTraceID(r *http.Request) string {
+	if id, _ := r.Context().Value(plugin.ContextTraceID()).(string); id != "" {
+		return id
+	}
+	if id := r.Header.Get(plugin.RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// validRequestID accepts up to 128 letters, digits and "-_.:", so client
+// IDs are safe to log and to forward upstream.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareDispatch sets the response headers naming the provider, model
+// and plugins, and adapts prog and cmd to what the provider supports.
+func prepareDispatch(w http.ResponseWriter, router *modules.RouterModule, chain *plugin.PluginChain, p *modules.ProviderConfig, model string, prog *ail.Program, cmd drivers.InferenceCommand) (*ail.Program, drivers.InferenceCommand) {
+	w.Header().Set("X-Real-Provider-Id", p.Name)
+	w.Header().Set("X-Real-Model-Id", model)
+
+	setPluginsHeader(w, chain, &router.Impl)
+
+	prog = promoteMetaHeaders(w.Header(), prog)
+
+	// Pass n through, or fan out when the upstream can't serve it.
+	prog, cmd = drivers.WithChoices(p.Impl.Style, prog, cmd)
+	// Emulate forced tool use where the upstream lacks it.
+	return drivers.WithToolChoice(&p.Impl, prog, cmd)
+}
+
+// promoteMetaHeaders moves SET_META keys starting with "x-" into response
+// headers h, stripping them from prog so they do not leak to the provider.
+func promoteMetaHeaders(h http.Header, prog *ail.Program) *ail.Program {
+	var metaToRemove []int
+	for i, inst := range prog.Code {
+		if inst.Op == ail.SET_META && strings.HasPrefix(inst.Key, "x-") {
+			h.Set(inst.Key, inst.Str)
+			metaToRemove = append(metaToRemove, i)
+		}
+	}
+	if len(metaToRemove) > 0 {
+		return prog.ClearAtIndex(metaToRemove...)
+	}
+	return prog
+}
+
+// pluginNames lists the plugins reportedPlugins lists, with their params.
+// Plugins that failed open are marked ";failed".
+func pluginNames(chain *plugin.PluginChain) []string {
+	var names []string
+	plugins := chain.GetPlugins()
+	for _, i := range reportedPlugins(chain) {
+		pi := plugins[i]
+		name := pi.Plugin.Name()
+		if pi.Params != "" {
+			name += ":" + pi.Params
+		}
+		if chain.FailedOpen(i) {
+			name += ";failed"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// modelRewrite is one step of resolving a model alias.
+type modelRewrite struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Plugin string `json:"plugin"`
+}
+
+// resolveModel resolves model aliases, which may chain
+// (virtual→virtual→real), and returns the plugin chain of the final model,
+// before matchers and including the plugins attached to the aliases, along
+// with the model and the rewrites that led to it. key is the conversation
+// key, for sticky weighted virtual targets. An alias that leads back to
+// itself, or a chain longer than maxRewriteDepth, is a configuration
+// error; the rewrites so far are returned with it.
+func resolveModel(r *http.Request, model, key string, route []string, logger *zap.Logger) (*plugin.PluginChain, string, []modelRewrite, error) {
+	var rewrites []modelRewrite
+	var via []string
+	seen := map[string]bool{model: true}
+	const maxRewriteDepth = 10
+	for {
+		chain := plugin.TryResolvePlugins(*r.URL, model, route, via...)
+		rewritten, rewriter := chain.RunModelRewrite(model, key)
+		if rewritten == model {
+			return chain, model, rewrites, nil
+		}
+		logger.Debug("Virtual model resolved",
+			zap.String("from", model),
+			zap.String("to", rewritten),
+			zap.String("rewriter", rewriter))
+		rewrites = append(rewrites, modelRewrite{From: model, To: rewritten, Plugin: rewriter})
+		if seen[rewritten] {
+			return nil, rewritten, rewrites, aliasCycleError(rewrites)
+		}
+		if len(rewrites) == maxRewriteDepth {
+			return nil, rewritten, rewrites, &services.RouterError{
+				Kind:    services.ErrorConfig,
+				Message: fmt.Sprintf("model alias chain longer than %d rewrites: %s", maxRewriteDepth, rewritePath(rewrites)),
+			}
+		}
+		seen[rewritten] = true
+		via = append(via, model)
+		model = rewritten
+	}
+}
+
+// aliasCycleError names the cycle the last of rewrites closes.
+func aliasCycleError(rewrites []modelRewrite) error {
+	last := rewrites[len(rewrites)-1].To
+	start := 0
+	for i, rw := range rewrites {
+		if rw.From == last {
+			start = i
+			break
+		}
+	}
+	return &services.RouterError{
+		Kind:    services.ErrorConfig,
+		Message: "model alias cycle: " + rewritePath(rewrites[start:]),
+	}
+}
+
+// rewritePath formats rewrites as "a -> b -> c".
+func rewritePath(rewrites []modelRewrite) string {
+	models := []string{rewrites[0].From}
+	for _, rw := range rewrites {
+		models = append(models, rw.To)
+	}
+	return strings.Join(models, " -> ")
+}
+
+// modelRewritesHeader traces how the requested model was resolved, one
+// "<from>;to=<to>;by=<plugin>" entry per rewrite.
+const modelRewritesHeader = "X-Model-Rewrites"
+
+type modelRewritesKey struct{}
+
+// setRewritesHeader echoes the request's model rewrites, if any.
+func setRewritesHeader(w http.ResponseWriter, r *http.Request) {
+	rewrites, _ := r.Context().Value(modelRewritesKey{}).([]modelRewrite)
+	if len(rewrites) == 0 {
+		return
+	}
+	entries := make([]string, len(rewrites))
+	for i, rw := range rewrites {
+		entries[i] = rw.From + ";to=" + rw.To + ";by=" + rw.Plugin
+	}
+	w.Header().Set(modelRewritesHeader, strings.Join(entries, ","))
+}
+
+// applyRewriteParams sets on prog the generation parameters of the models
+// it was rewritten from, the outermost alias's last so they win.
+func applyRewriteParams(prog *ail.Program, rewrites []modelRewrite) {
+	for i := len(rewrites) - 1; i >= 0; i-- {
+		p, _ := plugin.GetPlugin(rewrites[i].Plugin)
+		if pp, ok := p.(plugin.ParamsModelRewritePlugin); ok {
+			pp.ApplyParams(rewrites[i].From, prog)
+		}
+	}
+}
+
+// virtualRewrite reports whether a virtual provider rewrote the model.
+func virtualRewrite(rewrites []modelRewrite) bool {
+	for _, rw := range rewrites {
+		if strings.HasPrefix(rw.Plugin, "virtual:") {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestPreamble performs the request setup shared by all endpoint
+// modules: it checks DSPy sidecar callbacks, collects incoming auth,
+// resolves the model (virtual aliases, deprecation redirects) and its
+// plugin chain, and stores the model rewrites, resolved plugin names and
+// the router's SSE keepalive interval in the request context. route lists
+// the plugins configured on the endpoint. A chain whose plugins' declared
+// dependencies are unmet is rejected with a RouterError.
 func RequestPreamble(
 	router *modules.RouterModule,
 	prog *ail.Program,
 	r *http.Request,
+	route []string,
 	logger *zap.Logger,
 ) (*plugin.PluginChain, *http.Request, error) {
+	// LM calls from a DSPy sidecar must carry its shared secret; a wrong
+	// one is answered as an authentication failure.
+	r, err := dspy.CheckCallback(r)
+	if err != nil {
+		logger.Warn("rejected DSPy sidecar callback", zap.Error(err))
+		return nil, r, err
+	}
+
 	// Collect incoming auth.
-	r, err := router.Impl.Auth.CollectIncomingAuth(r)
+	r, err = router.Impl.Auth.CollectIncomingAuth(r)
 	if err != nil {
 		logger.Error("failed to collect incoming auth", zap.Error(err))
 		return nil, r, err
 	}
 
-	// Resolve virtual model aliases (may chain: virtual→virtual→real).
-	model := prog.GetModel()
-	var chain *plugin.PluginChain
-	virtualResolved := false
-	const maxRewriteDepth = 10
-	for i := 0; i < maxRewriteDepth; i++ {
-		chain = plugin.TryResolvePlugins(*r.URL, model)
-		rewritten, rewriter := chain.RunModelRewrite(model)
-		if rewritten != model {
-			logger.Debug("Virtual model resolved",
-				zap.String("from", model),
-				zap.String("to", rewritten),
-				zap.String("rewriter", rewriter))
-			// Track if a virtual provider plugin did the rewrite so we can
-			// bypass the exports check later (virtual aliases may target
-			// non-exported models).
-			if strings.HasPrefix(rewriter, "virtual:") {
-				virtualResolved = true
-			}
-			model = rewritten
-			continue
-		}
-		break
+	chain, model, rewrites, err := resolveModel(r, prog.GetModel(), conversationKey(r, prog), route, logger)
+	if err != nil {
+		logger.Error("Model resolution failed", zap.String("model", prog.GetModel()), zap.Error(err))
+		return nil, r, err
+	}
+	if len(rewrites) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), modelRewritesKey{}, rewrites))
+	}
+	if redirected, dep := checkDeprecated(router, model, time.Now()); dep != nil {
+		keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+		logger.Warn("Deprecated model requested",
+			zap.String("model", dep.Model),
+			zap.String("replacement", dep.Replacement),
+			zap.Bool("redirected", dep.Redirected),
+			zap.String("key_id", keyID))
+		router.Impl.Webhooks.Emit(services.WebhookModelDeprecated, map[string]any{
+			"key_id":           keyID,
+			"model":            dep.Model,
+			"deprecation_date": dep.Date,
+			"replacement":      dep.Replacement,
+			"redirected":       dep.Redirected,
+		})
+		r = r.WithContext(context.WithValue(r.Context(), deprecationKey{}, dep))
+		model = redirected
 	}
 	prog.SetModel(model)
+	applyRewriteParams(prog, rewrites)
+	chain = chain.Select(r, prog)
+	if err := chain.Check(); err != nil {
+		logger.Debug("Plugin chain rejected", zap.Error(err))
+		return nil, r, &services.RouterError{Kind: services.ErrorPlugin, Status: http.StatusBadRequest, Message: "plugins: " + err.Error()}
+	}
 
 	// When a virtual provider rewrote the model, store a flag in the
-	// request context so RunInferencePipeline skips exports filtering.
-	if virtualResolved {
+	// request context so RunInferencePipeline skips exports filtering
+	// (virtual aliases may target non-exported models).
+	if virtualRewrite(rewrites) {
 		r = r.WithContext(context.WithValue(r.Context(), exportsCheckBypassedKey{}, true))
 	}
 
 	logger.Debug("Resolved plugins", zap.Int("plugin_count", len(chain.GetPlugins())))
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextPlugins(), pluginNames(chain)))
+	r = r.WithContext(plugin.WithSSEKeepalive(r.Context(), router.SSEKeepaliveInterval()))
 
 	return chain, r, nil
 }
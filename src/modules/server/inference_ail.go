@@ -4,15 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	"github.com/google/uuid"
 	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/bufpool"
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
 	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
 	"github.com/neutrome-labs/open-ai-router/src/modules"
@@ -46,11 +48,46 @@ type ailOutputCtxKey struct{}
 // ToolPlugin for on-router tool dispatch) are fully supported, including
 // streaming requests via the hybrid buffer-then-stream approach.
 //
+// WebSocket: a GET with "Upgrade: websocket" switches to a persistent
+// connection carrying one program per message and chunk programs as raw
+// binary frames, for high-throughput internal consumers (see serveWebSocket).
+//
 // If Content-Type is absent or unrecognized, the handler auto-detects:
 // binary if the body starts with the AIL magic bytes ("AIL\x00"), text otherwise.
+//
+// Batches: Content-Type: application/x-ail-batch runs several programs in
+// one request (see serveBatch).
+//
+// Programs are validated and size-checked on arrival; see IngressLimits for
+// the limits { ... } block.
+//
+// Caddyfile:
+//
+//	ail {
+//	    router            <name>
+//	    batch_concurrency <n>    # programs of a batch run at once, default 4
+//	    max_batch         <n>    # programs per batch, default 1000; -1 unlimited
+//	    compression       <zstd|gzip...|off>    # default zstd gzip
+//	    compress_binary          # also compress binary AIL, off by default
+//	    limits { ... }
+//	    plugins           <plugin[:params][@matcher]|preset:<name>>...    # every program; see plugin.Matcher
+//	    dry_run                  # honor X-Dry-Run, as ai_inference_sse does
+//	}
+//
+// Compression applies to complete responses (text AIL, and binary AIL with
+// compress_binary); streams are sent uncompressed.
 type InferenceAILModule struct {
-	RouterName string `json:"router,omitempty"`
-	logger     *zap.Logger
+	RouterName       string         `json:"router,omitempty"`
+	BatchConcurrency int            `json:"batch_concurrency,omitempty"`
+	MaxBatch         int            `json:"max_batch,omitempty"`
+	Compression      []string       `json:"compression,omitempty"`
+	CompressBinary   bool           `json:"compress_binary,omitempty"`
+	Limits           *IngressLimits `json:"limits,omitempty"`
+	Plugins          []string       `json:"plugins,omitempty"`
+	DryRun           bool           `json:"dry_run,omitempty"`
+
+	compress *compression
+	logger   *zap.Logger
 }
 
 func ParseInferenceAILModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
@@ -63,6 +100,40 @@ func ParseInferenceAILModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandle
 					return nil, h.ArgErr()
 				}
 				m.RouterName = h.Val()
+			case "batch_concurrency", "max_batch":
+				opt := h.Val()
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				n, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid %s: %v", opt, err)
+				}
+				if opt == "batch_concurrency" {
+					m.BatchConcurrency = n
+				} else {
+					m.MaxBatch = n
+				}
+			case "compression":
+				m.Compression = h.RemainingArgs()
+				if len(m.Compression) == 0 {
+					return nil, h.ArgErr()
+				}
+			case "compress_binary":
+				m.CompressBinary = true
+			case "limits":
+				l, err := parseIngressLimits(h.Dispenser)
+				if err != nil {
+					return nil, err
+				}
+				m.Limits = l
+			case "dry_run":
+				m.DryRun = true
+			case "plugins":
+				m.Plugins = h.RemainingArgs()
+				if len(m.Plugins) == 0 {
+					return nil, h.ArgErr()
+				}
 			default:
 				return nil, h.Errf("unrecognized ail option '%s'", h.Val())
 			}
@@ -87,6 +158,14 @@ func (m *InferenceAILModule) Provision(ctx caddy.Context) error {
 	drivers.Logger = m.logger.Named("drivers")
 	virtual.Logger = m.logger.Named("virtual")
 
+	var err error
+	m.compress, err = parseCompression(m.Compression, m.CompressBinary)
+	if err != nil {
+		return fmt.Errorf("ail: %w", err)
+	}
+	if err := plugin.ValidateMatchers(m.Plugins); err != nil {
+		return fmt.Errorf("ail: plugins: %w", err)
+	}
 	return nil
 }
 
@@ -96,84 +175,155 @@ var ailMagic = []byte("AIL\x00")
 func (m *InferenceAILModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	m.logger.Debug("AIL request received", zap.String("method", r.Method))
 
-	var prog *ail.Program
-	var wantBinaryOutput bool
-
 	// Check if an AIL program is already in context (recursive call from plugin).
 	if ctxProg, ok := ail.ProgramFromContext(r.Context()); ok {
-		prog = ctxProg
 		m.logger.Debug("Using AIL program from context (recursive call)")
 		// Use text output for internal recursive calls — simpler to parse back,
 		// no base64 overhead, and the response stays in-process anyway.
-		wantBinaryOutput = false
-	} else {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			m.logger.Error("failed to read request body", zap.Error(err))
-			http.Error(w, "failed to read request body", http.StatusBadRequest)
-			return nil
-		}
+		return m.serveProgram(w, r, ctxProg, false)
+	}
 
-		if len(body) == 0 {
-			http.Error(w, "empty request body", http.StatusBadRequest)
-			return nil
-		}
+	if isWebSocketUpgrade(r) {
+		m.serveWebSocket(w, r)
+		return nil
+	}
 
-		// Determine input format.
-		inputBinary := m.isInputBinary(r, body)
+	// Compress complete responses for the client; streams pass through.
+	w, finish := m.compress.wrap(w, r)
+	defer finish()
 
-		// Parse the AIL program.
-		if inputBinary {
-			prog, err = ail.Decode(bytes.NewReader(body))
-			if err != nil {
-				m.logger.Error("failed to decode binary AIL", zap.Error(err))
-				http.Error(w, "invalid binary AIL: "+err.Error(), http.StatusBadRequest)
-				return nil
-			}
-		} else {
-			prog, err = ail.Asm(string(body))
-			if err != nil {
-				m.logger.Error("failed to assemble text AIL", zap.Error(err))
-				http.Error(w, "invalid AIL text: "+err.Error(), http.StatusBadRequest)
-				return nil
-			}
-		}
+	body, perr := m.Limits.readBody(w, r)
+	if perr != nil {
+		m.logger.Warn("request rejected before parsing", zap.Int("status", perr.status), zap.Error(perr))
+		perr.write(w)
+		return nil
+	}
+	if isBatchRequest(r) {
+		m.serveBatch(w, r, body)
+		return nil
+	}
+	prog, wantBinaryOutput, ok := m.readProgram(w, r, body)
+	if !ok {
+		return nil
+	}
+	return m.serveProgram(w, r, prog, wantBinaryOutput)
+}
+
+// readProgram parses and validates a client's AIL program, writing the
+// error response and returning false when it is rejected. It also picks
+// the output encoding.
+func (m *InferenceAILModule) readProgram(w http.ResponseWriter, r *http.Request, body []byte) (*ail.Program, bool, bool) {
+	if len(body) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "empty request body", "invalid_request_error", "invalid_request")
+		return nil, false, false
+	}
 
-		m.logger.Debug("AIL program parsed",
-			zap.String("model", prog.GetModel()),
-			zap.Bool("streaming", prog.IsStreaming()),
-			zap.Int("instructions", prog.Len()),
-			zap.Bool("input_binary", inputBinary))
+	// Determine input format.
+	inputBinary := m.isInputBinary(r, body)
 
-		// Determine output format from Accept header (default: same as input).
-		wantBinaryOutput = m.wantBinaryOutput(r, inputBinary)
+	// Parse the AIL program.
+	var prog *ail.Program
+	var err error
+	if inputBinary {
+		prog, err = ail.Decode(bytes.NewReader(body))
+		if err != nil {
+			m.logger.Error("failed to decode binary AIL", zap.Error(err))
+			writeJSONError(w, http.StatusBadRequest, "invalid binary AIL: "+err.Error(), "invalid_request_error", "invalid_request")
+			return nil, false, false
+		}
+	} else {
+		prog, err = ail.Asm(string(body))
+		if err != nil {
+			m.logger.Error("failed to assemble text AIL", zap.Error(err))
+			writeJSONError(w, http.StatusBadRequest, "invalid AIL text: "+err.Error(), "invalid_request_error", "invalid_request")
+			return nil, false, false
+		}
+	}
 
+	if perr := m.Limits.check(prog); perr != nil {
+		m.logger.Warn("AIL program rejected at ingress", zap.Int("status", perr.status), zap.Error(perr))
+		perr.write(w)
+		return nil, false, false
 	}
 
+	m.logger.Debug("AIL program parsed",
+		zap.String("model", prog.GetModel()),
+		zap.Bool("streaming", prog.IsStreaming()),
+		zap.Int("instructions", prog.Len()),
+		zap.Bool("input_binary", inputBinary))
+
+	// Determine output format from Accept header (default: same as input).
+	return prog, m.wantBinaryOutput(r, inputBinary), true
+}
+
+// serveProgram runs a parsed program through auth, plugins and inference.
+func (m *InferenceAILModule) serveProgram(w http.ResponseWriter, r *http.Request, prog *ail.Program, wantBinaryOutput bool) error {
 	// Store output format in context for InferenceHandler methods.
 	r = r.WithContext(context.WithValue(r.Context(), ailOutputCtxKey{}, wantBinaryOutput))
 
 	router, ok := modules.GetRouter(m.RouterName)
 	if !ok {
 		m.logger.Error("Router not found", zap.String("name", m.RouterName))
-		http.Error(w, "Router not found", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
 		return nil
 	}
 
 	// Shared preamble: auth, model rewrite, plugin resolution.
-	chain, r, err := RequestPreamble(router, prog, r, m.logger)
+	requestedModel := prog.GetModel()
+	chain, r, err := RequestPreamble(router, prog, r, m.Plugins, m.logger)
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		writePreambleError(w, err)
 		return nil
 	}
 
-	// Preserve trace ID across InferFresh re-entries; generate only if absent.
-	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
-	if traceID == "" {
-		traceID = uuid.New().String()
-	}
+	// Preserve trace ID across InferFresh re-entries and echo it to the client.
+	traceID := requestTraceID(r)
+	w.Header().Set(plugin.RequestIDHeader, traceID)
+	setDeprecationHeader(w, r)
+	setRewritesHeader(w, r)
 	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), traceID))
 
+	// Headers and metadata plugins attach to the response (no-op when
+	// re-entered via InferFresh).
+	w, r, finishResponseMeta := startResponseMeta(w, r)
+	defer finishResponseMeta()
+
+	// One access log line per client request (no-op when disabled or
+	// when re-entered via InferFresh).
+	w, r, finishAccessLog := startAccessLog(router, w, r, requestedModel, prog, chain)
+	defer finishAccessLog()
+
+	// Bound the request by its client's timeout, if any.
+	r, cancelDeadline, perr := withRequestDeadline(r, prog)
+	defer cancelDeadline()
+	if perr != nil {
+		perr.write(w)
+		return nil
+	}
+
+	// A dry run stops at the upstream request. It is not queued and skips
+	// request-init plugins and recursive handlers, which may call out.
+	r, dryRun, perr := withDryRun(r, m.DryRun)
+	if perr != nil {
+		perr.write(w)
+		return nil
+	}
+	if dryRun {
+		if err := RunInferencePipeline(router, chain, prog, w, r, m, m.logger); err != nil {
+			m.logger.Debug("dry run failed", zap.Error(err))
+			writeRouterError(w, err)
+		}
+		return nil
+	}
+
+	// Wait for a slot in the fair admission queue (no-op when disabled
+	// or when re-entered via InferFresh).
+	release, r, admitted := admitRequest(router, w, r, m.logger)
+	if !admitted {
+		return nil
+	}
+	defer release()
+
 	// Notify plugins of the initial parsed request (e.g., sampler).
 	chain.RunRequestInit(r, prog)
 
@@ -194,9 +344,9 @@ func (m *InferenceAILModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 	}
 	handled, err := chain.RunRecursiveHandlers(ic, prog, w, r)
 	if handled {
-		if err != nil {
+		if err != nil && !services.ClientGone(r.Context(), err) {
 			m.logger.Error("recursive handler plugin failed", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeRouterError(w, services.PluginError(deadlineErr(r, err)))
 		}
 		return nil
 	}
@@ -204,7 +354,7 @@ func (m *InferenceAILModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 	// Normal flow — shared provider iteration pipeline.
 	if err := RunInferencePipeline(router, chain, prog, w, r, m, m.logger); err != nil {
 		m.logger.Error("AIL request handling failed", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRouterError(w, err)
 		return nil
 	}
 
@@ -232,8 +382,12 @@ func (m *InferenceAILModule) ServeNonStreaming(
 	resProg, err = chain.RunAfter(&p.Impl, r, prog, res, resProg)
 	if err != nil {
 		m.logger.Error("plugin after hook error", zap.Error(err))
-		return err
+		return services.PluginError(err)
 	}
+	setPluginsHeader(w, chain, p.Impl.Router)
+	addPluginReport(r, chain, p.Impl.Router, resProg)
+
+	setRequestCost(w, &p.Impl, prog.GetModel(), resProg)
 
 	// Encode and write the response.
 	wantBinary, _ := r.Context().Value(ailOutputCtxKey{}).(bool)
@@ -241,7 +395,8 @@ func (m *InferenceAILModule) ServeNonStreaming(
 }
 
 // ServeStreaming implements InferenceHandler for AIL.
-// Pushes AIL chunk programs to the client incrementally via SSE.
+// Pushes AIL chunk programs to the client incrementally via SSE, or as
+// WebSocket frames on an upgraded connection.
 func (m *InferenceAILModule) ServeStreaming(
 	p *modules.ProviderConfig,
 	cmd drivers.InferenceCommand,
@@ -250,29 +405,36 @@ func (m *InferenceAILModule) ServeStreaming(
 	w http.ResponseWriter,
 	r *http.Request,
 ) error {
-	sseWriter := sse.NewWriter(w)
-
-	if err := sseWriter.WriteHeartbeat("ok"); err != nil {
+	wantBinary, _ := r.Context().Value(ailOutputCtxKey{}).(bool)
+	out, stop, err := m.chunkSink(chain, &p.Impl, w, r, wantBinary)
+	if err != nil {
 		return err
 	}
+	defer stop()
+
+	r, abandon := withClientCancel(r)
+	defer abandon(nil)
 
+	stats := newStreamStats()
 	hres, stream, err := cmd.DoInferenceStream(&p.Impl, prog, r)
 	if err != nil {
+		if services.ClientGone(r.Context(), err) {
+			return services.ErrClientClosed
+		}
 		m.logger.Error("inference stream error (start)",
 			zap.String("provider", p.Name), zap.Error(err))
 		_ = chain.RunError(&p.Impl, r, prog, hres, err)
-		_ = sseWriter.WriteError("start failed")
-		_ = sseWriter.WriteDone()
 		return err
 	}
 
-	wantBinary, _ := r.Context().Value(ailOutputCtxKey{}).(bool)
-
 	chunks := make([]*ail.Program, 0, 10)
 
 	for chunk := range stream {
+		if r.Context().Err() != nil {
+			break // client gone; the driver is closing the upstream
+		}
 		if chunk.RuntimeError != nil {
-			_ = sseWriter.WriteError(chunk.RuntimeError.Error())
+			_ = out.fail(chunk.RuntimeError)
 			_ = chain.RunError(&p.Impl, r, prog, hres, chunk.RuntimeError)
 			return nil
 		}
@@ -288,41 +450,98 @@ func (m *InferenceAILModule) ServeStreaming(
 
 		if chunkProg != nil {
 			chunks = append(chunks, chunkProg)
+			stats.observe(chunkProg)
 
-			// Encode the chunk and push via SSE.
-			chunkData, encErr := m.encodeAILChunk(chunkProg, wantBinary)
-			if encErr != nil {
-				m.logger.Error("chunk encode error", zap.Error(encErr))
-				continue
-			}
-			if err := sseWriter.WriteRaw(chunkData); err != nil {
-				m.logger.Error("stream write error", zap.Error(err))
-				return err
+			if err := out.chunk(chunkProg); err != nil {
+				abandon(services.ErrClientClosed)
+				break
 			}
 		}
 	}
 
-	// Assemble all chunk programs and pass the complete response to StreamEnd.
-	assembled := ail.NewProgram()
-	for _, c := range chunks {
-		if c != nil {
-			assembled = assembled.Append(c)
+	// Count usage when the upstream sent none, so every client gets it.
+	usageChunk := streamUsageChunk(m.logger, &p.Impl, r, prog, chunks)
+	if usageChunk != nil {
+		chunks = append(chunks, usageChunk)
+		if r.Context().Err() == nil {
+			_ = out.chunk(usageChunk)
 		}
 	}
+
+	// Assemble all chunk programs and pass the complete response to StreamEnd.
+	assembled := plugin.ConcatChunks(chunks)
 	_ = chain.RunStreamEnd(&p.Impl, r, prog, hres, assembled)
+	if r.Context().Err() != nil {
+		m.logger.Info("client disconnected mid-stream, upstream cancelled", zap.String("provider", p.Name))
+		return services.ErrClientClosed
+	}
+	setRequestCost(w, &p.Impl, prog.GetModel(), assembled)
 
-	_ = sseWriter.WriteDone()
+	_ = out.end(r, stats.finish(&p.Impl, prog.GetModel(), assembled))
 	return nil
 }
 
+// chunkSink delivers the chunk programs of one AIL stream to the client.
+type chunkSink interface {
+	chunk(prog *ail.Program) error
+	fail(err error) error
+	end(r *http.Request, sum streamSummary) error
+}
+
+// chunkSink opens the stream on w: WebSocket frames when w belongs to an
+// upgraded connection, SSE otherwise. stop releases it.
+func (m *InferenceAILModule) chunkSink(chain *plugin.PluginChain, p *services.ProviderService, w http.ResponseWriter, r *http.Request, binary bool) (chunkSink, func(), error) {
+	if conn := wsConnOf(w); conn != nil {
+		return &wsChunkSink{conn: conn, binary: binary, logger: m.logger}, func() {}, nil
+	}
+	w, closeTee := teeStream(chain, p, w, r)
+	sw := sse.NewWriter(w)
+	announceRequestCost(w)
+	stopKeepalive := sw.StartKeepalive(plugin.SSEKeepaliveFromContext(r.Context()))
+	stop := func() {
+		stopKeepalive()
+		closeTee()
+	}
+	if err := sw.WriteHeartbeat("ok"); err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return &sseChunkSink{m: m, sw: sw, binary: binary}, stop, nil
+}
+
+// sseChunkSink sends chunks as SSE data events, base64-encoding binary AIL.
+type sseChunkSink struct {
+	m      *InferenceAILModule
+	sw     *sse.Writer
+	binary bool
+}
+
+func (s *sseChunkSink) chunk(prog *ail.Program) error {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if err := encodeAILChunk(buf, prog, s.binary); err != nil {
+		s.m.logger.Error("chunk encode error", zap.Error(err))
+		return nil
+	}
+	return s.sw.WriteRaw(buf.Bytes())
+}
+
+func (s *sseChunkSink) fail(err error) error { return writeStreamError(s.sw, err) }
+
+func (s *sseChunkSink) end(r *http.Request, sum streamSummary) error {
+	_ = writeStreamStats(s.sw, r, sum)
+	return s.sw.WriteDone()
+}
+
 // ─── Helpers ─────────────────────────────────────────────────────────────────
 
 // writeAILResponse encodes an AIL program and writes it to the response writer.
 func (m *InferenceAILModule) writeAILResponse(w http.ResponseWriter, prog *ail.Program, wantBinary bool) error {
 	if wantBinary {
 		w.Header().Set("Content-Type", "application/x-ail")
-		var buf bytes.Buffer
-		if err := prog.Encode(&buf); err != nil {
+		buf := bufpool.Get()
+		defer bufpool.Put(buf)
+		if err := prog.Encode(buf); err != nil {
 			m.logger.Error("failed to encode binary AIL response", zap.Error(err))
 			return err
 		}
@@ -334,19 +553,22 @@ func (m *InferenceAILModule) writeAILResponse(w http.ResponseWriter, prog *ail.P
 	return err
 }
 
-// encodeAILChunk encodes a single AIL chunk program for SSE delivery.
-// Text mode: returns the disasm directly.
-// Binary mode: base64-encodes the binary AIL (SSE is text-based).
-func (m *InferenceAILModule) encodeAILChunk(prog *ail.Program, wantBinary bool) ([]byte, error) {
+// encodeAILChunk appends a single AIL chunk program to dst for SSE
+// delivery.
+// Text mode: the disasm directly.
+// Binary mode: the base64-encoded binary AIL (SSE is text-based).
+func encodeAILChunk(dst *bytes.Buffer, prog *ail.Program, wantBinary bool) error {
 	if !wantBinary {
-		return []byte(prog.Disasm()), nil
+		dst.WriteString(prog.Disasm())
+		return nil
 	}
-	var buf bytes.Buffer
-	if err := prog.Encode(&buf); err != nil {
-		return nil, err
+	bin := bufpool.Get()
+	defer bufpool.Put(bin)
+	if err := prog.Encode(bin); err != nil {
+		return err
 	}
-	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return []byte(encoded), nil
+	dst.Write(base64.StdEncoding.AppendEncode(dst.AvailableBuffer(), bin.Bytes()))
+	return nil
 }
 
 // isInputBinary determines whether the request body is binary AIL or text.
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// AIL batches
+//
+// A request with Content-Type: application/x-ail-batch carries several
+// programs, each framed as a 4-byte big-endian length followed by the
+// program in binary AIL or text disassembly. The programs run concurrently,
+// each as its own request (auth, admission, plugins, access log), and the
+// response, with the same content type and framing, holds one response
+// program per request program, in request order. Each response uses its
+// request program's encoding. Streaming programs are answered whole.
+//
+// A program that fails gets a response program holding only an
+// EXT_DATA "error" with the usual error object plus its HTTP "status", so
+// one bad entry does not fail the batch.
+const ailBatchContentType = "application/x-ail-batch"
+
+// Batch defaults; see InferenceAILModule's batch_concurrency and max_batch.
+const (
+	defaultBatchConcurrency = 4
+	defaultMaxBatch         = 1000
+)
+
+func isBatchRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), ailBatchContentType)
+}
+
+// splitBatch splits a batch body into its length-prefixed entries.
+func splitBatch(body []byte) ([][]byte, error) {
+	var entries [][]byte
+	for off := 0; off < len(body); {
+		if len(body)-off < 4 {
+			return nil, fmt.Errorf("truncated length prefix at byte %d", off)
+		}
+		n := int(binary.BigEndian.Uint32(body[off:]))
+		off += 4
+		if n > len(body)-off {
+			return nil, fmt.Errorf("entry %d claims %d bytes, %d left", len(entries), n, len(body)-off)
+		}
+		entries = append(entries, body[off:off+n])
+		off += n
+	}
+	return entries, nil
+}
+
+// appendBatchEntry appends entry to a batch body with its length prefix.
+func appendBatchEntry(dst, entry []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(entry)))
+	return append(dst, entry...)
+}
+
+// serveBatch runs every program in a batch body and writes the batch of
+// responses.
+func (m *InferenceAILModule) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	entries, err := splitBatch(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid AIL batch: "+err.Error(), "invalid_request_error", "invalid_request")
+		return
+	}
+	if len(entries) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "empty AIL batch", "invalid_request_error", "invalid_request")
+		return
+	}
+	if max := limitOrDefault(m.MaxBatch, defaultMaxBatch); max > 0 && len(entries) > max {
+		tooLarge("programs", len(entries), max).write(w)
+		return
+	}
+
+	m.logger.Debug("AIL batch received", zap.Int("programs", len(entries)))
+
+	workers := m.BatchConcurrency
+	if workers <= 0 {
+		workers = defaultBatchConcurrency
+	}
+	results := make([][]byte, len(entries))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = m.serveBatchEntry(r, entry)
+		}()
+	}
+	wg.Wait()
+
+	out := make([]byte, 0, len(body))
+	for _, res := range results {
+		out = appendBatchEntry(out, res)
+	}
+	w.Header().Set("Content-Type", ailBatchContentType)
+	_, _ = w.Write(out)
+}
+
+// serveBatchEntry runs one program of a batch and returns its encoded
+// response program.
+func (m *InferenceAILModule) serveBatchEntry(r *http.Request, entry []byte) []byte {
+	// The batch's own content type would mislead format detection; each
+	// entry is detected, and answered, by its magic bytes.
+	er := r.Clone(r.Context())
+	er.Header.Del("Content-Type")
+	er.Header.Del("Accept")
+	er.Body = http.NoBody
+
+	cw := &services.ResponseCaptureWriter{}
+	if prog, wantBinary, ok := m.readProgram(cw, er, entry); ok {
+		if at := prog.FindAll(ail.SET_STREAM); len(at) > 0 {
+			prog = prog.ClearAtIndex(at...)
+		}
+		if err := m.serveProgram(cw, er, prog, wantBinary); err != nil {
+			m.logger.Error("AIL batch entry failed", zap.Error(err))
+		}
+	}
+	if cw.StatusCode < http.StatusBadRequest {
+		return cw.Response
+	}
+	return m.batchError(cw, m.isInputBinary(er, entry))
+}
+
+// batchError turns a captured error response into an error program.
+func (m *InferenceAILModule) batchError(cw *services.ResponseCaptureWriter, binary bool) []byte {
+	var env struct {
+		Error map[string]any `json:"error"`
+	}
+	if json.Unmarshal(cw.Response, &env) != nil || env.Error == nil {
+		env.Error = map[string]any{"message": strings.TrimSpace(string(cw.Response))}
+	}
+	env.Error["status"] = cw.StatusCode
+	errJSON, _ := json.Marshal(env.Error)
+
+	prog := ail.NewProgram()
+	prog.EmitKeyJSON(ail.EXT_DATA, "error", errJSON)
+	if !binary {
+		return []byte(prog.Disasm())
+	}
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		m.logger.Error("failed to encode batch error program", zap.Error(err))
+	}
+	return buf.Bytes()
+}
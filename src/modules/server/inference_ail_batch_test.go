@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestSplitBatch(t *testing.T) {
+	var body []byte
+	for _, e := range []string{"one", "", "three"} {
+		body = appendBatchEntry(body, []byte(e))
+	}
+	entries, err := splitBatch(body)
+	if err != nil || len(entries) != 3 || string(entries[0]) != "one" || len(entries[1]) != 0 || string(entries[2]) != "three" {
+		t.Fatalf("entries = %q, err = %v", entries, err)
+	}
+	if _, err := splitBatch(body[:len(body)-1]); err == nil {
+		t.Error("truncated entry accepted")
+	}
+	if _, err := splitBatch([]byte{0, 0}); err == nil {
+		t.Error("truncated prefix accepted")
+	}
+}
+
+func TestAILBatch(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+	modules.RegisterRouter("ail-batch-test", router)
+	m := &InferenceAILModule{RouterName: "ail-batch-test", BatchConcurrency: 2, logger: zap.NewNop()}
+
+	binProg := ail.NewProgram()
+	binProg.EmitString(ail.SET_MODEL, "p/m")
+	binProg.Emit(ail.SET_STREAM)
+	var bin bytes.Buffer
+	if err := binProg.Encode(&bin); err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	body = appendBatchEntry(body, bin.Bytes())
+	body = appendBatchEntry(body, []byte("NOT_AN_OPCODE"))
+	body = appendBatchEntry(body, []byte("SET_MODEL \"p/m\"\n"))
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", ailBatchContentType)
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ailBatchContentType {
+		t.Fatalf("status %d, content type %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	entries, err := splitBatch(w.Body.Bytes())
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("entries = %q, err = %v", entries, err)
+	}
+
+	// Binary in, binary out; the streaming program is answered whole.
+	if !bytes.HasPrefix(entries[0], ailMagic) {
+		t.Errorf("entry 0 not binary: %q", entries[0])
+	}
+	// A bad program fails alone, as an error program in its own encoding.
+	bad, err := ail.Asm(string(entries[1]))
+	if err != nil {
+		t.Fatalf("entry 1: %v: %q", err, entries[1])
+	}
+	var e struct {
+		Status int    `json:"status"`
+		Code   string `json:"code"`
+	}
+	if idx := bad.FindAll(ail.EXT_DATA); len(idx) != 1 || bad.Code[idx[0]].Key != "error" {
+		t.Fatalf("entry 1 = %s", bad.Disasm())
+	} else if err := json.Unmarshal(bad.Code[idx[0]].JSON, &e); err != nil || e.Status != http.StatusBadRequest || e.Code != "invalid_request" {
+		t.Errorf("entry 1 error = %s", bad.Code[idx[0]].JSON)
+	}
+	if _, err := ail.Asm(string(entries[2])); err != nil || bytes.HasPrefix(entries[2], ailMagic) {
+		t.Errorf("entry 2 not text AIL: %q", entries[2])
+	}
+}
+
+func TestAILBatch_TooMany(t *testing.T) {
+	m := &InferenceAILModule{MaxBatch: 1, logger: zap.NewNop()}
+	body := appendBatchEntry(appendBatchEntry(nil, []byte("a")), []byte("b"))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", ailBatchContentType)
+	w := httptest.NewRecorder()
+	_ = m.ServeHTTP(w, r, nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d: %s", w.Code, w.Body.String())
+	}
+}
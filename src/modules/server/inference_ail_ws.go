@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/bufpool"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// AIL over WebSocket
+//
+// A GET with "Upgrade: websocket" on the ail endpoint switches the
+// connection to WebSocket. The client then sends programs one message at a
+// time, binary AIL or text disassembly (detected by the AIL magic bytes),
+// and the server answers each in turn:
+//
+//   - streaming programs: one frame per chunk program, then a "[DONE]"
+//     text frame;
+//   - non-streaming programs: one frame with the response program.
+//
+// Binary output (the default for binary input, or Accept: application/x-ail
+// on the upgrade request) goes out as raw binary frames with no base64 or
+// SSE framing. Text output uses text frames. Errors are text frames with
+// the usual {"error": {...}} JSON body; an error ends the response to its
+// program but leaves the connection open.
+// Authentication headers on the upgrade request apply to every program.
+
+// wsDoneFrame ends each streamed response on a WebSocket connection.
+const wsDoneFrame = "[DONE]"
+
+// isWebSocketUpgrade reports whether r asks to switch to WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, tok := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(tok), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebSocket upgrades r and serves programs until the client closes.
+func (m *InferenceAILModule) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 1 {
+		writeJSONError(w, http.StatusBadRequest, "AIL over WebSocket requires HTTP/1.1", "invalid_request_error", "invalid_request")
+		return
+	}
+	srv := websocket.Server{
+		// Consumers are services, not browsers, and authenticate with
+		// headers like any other request, so the Origin is not checked.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(conn *websocket.Conn) { m.serveConn(conn, r) },
+	}
+	srv.ServeHTTP(hijacker{w}, r)
+}
+
+// wsMessage is one message read from the client, or the read error.
+type wsMessage struct {
+	data []byte
+	err  error
+}
+
+func (m *InferenceAILModule) serveConn(conn *websocket.Conn, r *http.Request) {
+	conn.MaxPayloadBytes = limitOrDefault(m.Limits.maxBufferBytes(), defaultMaxBufferBytes)
+	if conn.MaxPayloadBytes == 0 {
+		conn.MaxPayloadBytes = math.MaxInt // limit disabled
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	r = r.WithContext(ctx)
+
+	// Read on a separate goroutine so a client that goes away cancels the
+	// program in flight, like a dropped HTTP connection.
+	msgs := make(chan wsMessage, 1)
+	go func() {
+		defer close(msgs)
+		for {
+			var data []byte
+			err := websocket.Message.Receive(conn, &data)
+			if err != nil && !errors.Is(err, websocket.ErrFrameTooLarge) {
+				cancel(services.ErrClientClosed)
+				return
+			}
+			select {
+			case msgs <- wsMessage{data: data, err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for msg := range msgs {
+		ww := &wsResponseWriter{conn: conn, header: http.Header{}}
+		if msg.err != nil {
+			(&programError{
+				status:  http.StatusRequestEntityTooLarge,
+				code:    "request_too_large",
+				message: fmt.Sprintf("message exceeds the %d byte limit", conn.MaxPayloadBytes),
+			}).write(ww)
+		} else if prog, wantBinary, ok := m.readProgram(ww, r, msg.data); ok {
+			if err := m.serveProgram(ww, r, prog, wantBinary); err != nil {
+				m.logger.Error("AIL WebSocket request failed", zap.Error(err))
+			}
+		}
+		if err := ww.flush(); err != nil || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// maxBufferBytes is the configured buffer limit of a possibly nil l.
+func (l *IngressLimits) maxBufferBytes() int {
+	if l == nil {
+		return 0
+	}
+	return l.MaxBufferBytes
+}
+
+// hijacker exposes the connection under Caddy's wrapped ResponseWriter,
+// which x/net/websocket takes over with a plain Hijacker assertion.
+type hijacker struct{ http.ResponseWriter }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// wsResponseWriter collects one non-streaming response, or an error, and
+// flush sends it as a single frame. Streams write frames directly through
+// wsChunkSink instead.
+type wsResponseWriter struct {
+	conn   *websocket.Conn
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *wsResponseWriter) Header() http.Header { return w.header }
+
+func (w *wsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *wsResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *wsResponseWriter) flush() error {
+	if w.body.Len() == 0 {
+		return nil
+	}
+	if w.status < http.StatusBadRequest && strings.HasPrefix(w.header.Get("Content-Type"), "application/x-ail") {
+		return websocket.Message.Send(w.conn, w.body.Bytes())
+	}
+	return websocket.Message.Send(w.conn, w.body.String())
+}
+
+// wsConnOf returns the WebSocket connection behind w, looking through the
+// access log's status writer, or nil for plain HTTP responses and capture
+// writers.
+func wsConnOf(w http.ResponseWriter) *websocket.Conn {
+	for {
+		switch ww := w.(type) {
+		case *wsResponseWriter:
+			return ww.conn
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// wsChunkSink sends stream chunks as WebSocket frames.
+type wsChunkSink struct {
+	conn   *websocket.Conn
+	binary bool
+	logger *zap.Logger
+}
+
+func (s *wsChunkSink) chunk(prog *ail.Program) error {
+	if !s.binary {
+		return websocket.Message.Send(s.conn, prog.Disasm())
+	}
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if err := prog.Encode(buf); err != nil {
+		s.logger.Error("chunk encode error", zap.Error(err))
+		return nil
+	}
+	return websocket.Message.Send(s.conn, buf.Bytes())
+}
+
+func (s *wsChunkSink) fail(err error) error {
+	re := services.AsRouterError(err)
+	data, _ := json.Marshal(map[string]any{"error": routerErrorObject(re)})
+	return websocket.Message.Send(s.conn, string(data))
+}
+
+func (s *wsChunkSink) end(*http.Request, streamSummary) error {
+	return websocket.Message.Send(s.conn, wsDoneFrame)
+}
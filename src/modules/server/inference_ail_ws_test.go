@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// chunkInference streams fixed chunk programs.
+type chunkInference struct{ chunks []*ail.Program }
+
+func (c chunkInference) DoInference(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, *ail.Program, error) {
+	return nil, ail.NewProgram(), nil
+}
+
+func (c chunkInference) DoInferenceStream(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, chan drivers.InferenceStreamChunk, error) {
+	ch := make(chan drivers.InferenceStreamChunk, len(c.chunks))
+	for _, p := range c.chunks {
+		ch <- drivers.InferenceStreamChunk{Data: p}
+	}
+	close(ch)
+	return nil, ch, nil
+}
+
+func dialWS(t *testing.T, h http.Handler) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receive(t *testing.T, conn *websocket.Conn) []byte {
+	t.Helper()
+	var data []byte
+	if err := websocket.Message.Receive(conn, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAILWebSocket_ErrorsKeepConnection(t *testing.T) {
+	m := &InferenceAILModule{RouterName: "missing", Limits: &IngressLimits{MaxBufferBytes: 256}, logger: zap.NewNop()}
+	conn := dialWS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = m.ServeHTTP(w, r, nil)
+	}))
+
+	for _, tc := range []struct {
+		send any
+		want string
+	}{
+		{"NOT_AN_OPCODE", "invalid AIL text"},
+		{bytes.Repeat([]byte{0}, 1024), "request_too_large"},
+		{"SET_MODEL \"m\"\n", "router_not_found"},
+	} {
+		if err := websocket.Message.Send(conn, tc.send); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(receive(t, conn)); !strings.Contains(got, tc.want) {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestAILWebSocket_StreamsBinaryFrames(t *testing.T) {
+	var chunks []*ail.Program
+	for _, text := range []string{"Hel", "lo"} {
+		c := ail.NewProgram()
+		c.EmitString(ail.STREAM_DELTA, text)
+		chunks = append(chunks, c)
+	}
+	m := &InferenceAILModule{logger: zap.NewNop()}
+	conn := dialWS(t, websocket.Handler(func(conn *websocket.Conn) {
+		r := conn.Request()
+		r = r.WithContext(context.WithValue(r.Context(), ailOutputCtxKey{}, true))
+		prog := ail.NewProgram()
+		prog.EmitString(ail.SET_MODEL, "m")
+		ww := &wsResponseWriter{conn: conn, header: http.Header{}}
+		p := &modules.ProviderConfig{Name: "p"}
+		if err := m.ServeStreaming(p, chunkInference{chunks}, plugin.NewPluginChain(), prog, ww, r); err != nil {
+			t.Error(err)
+		}
+		_ = ww.flush()
+	}))
+
+	var text strings.Builder
+	for {
+		data := receive(t, conn)
+		if string(data) == wsDoneFrame {
+			break
+		}
+		chunk, err := ail.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("frame is not binary AIL: %q", data)
+		}
+		for _, inst := range chunk.Code {
+			if inst.Op == ail.STREAM_DELTA {
+				text.WriteString(inst.Str)
+			}
+		}
+	}
+	if text.String() != "Hello" {
+		t.Errorf("text = %q", text.String())
+	}
+}
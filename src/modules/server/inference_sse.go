@@ -3,13 +3,11 @@ package server
 import (
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	"github.com/google/uuid"
 	"github.com/neutrome-labs/ail"
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
 	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
@@ -32,15 +30,35 @@ import (
 // Caddyfile:
 //
 //	ai_inference_sse {
-//	    router <name>
-//	    style  <style>   # chat-completions | openai-responses | anthropic-messages | ...
+//	    router      <name>
+//	    style       <style>                   # chat-completions | openai-responses | anthropic-messages | ...
+//	    tool_deltas <incremental|buffered>    # default incremental
+//	    compression <zstd|gzip...|off>        # non-streaming responses, default zstd gzip
+//	    limits { ... }                        # see IngressLimits
+//	    plugins     <plugin[:params][@matcher]|preset:<name>>...  # every request; see plugin.Matcher
+//	    dry_run                               # honor X-Dry-Run, off by default
 //	}
+//
+// A client can pick the tool-delta mode per request with an Accept
+// parameter, e.g. "Accept: text/event-stream; tool_deltas=buffered".
+//
+// With dry_run, a request carrying "X-Dry-Run: true" is answered with the
+// upstream request the chosen provider would get (see DryRun) instead of
+// being sent. Dry runs show plugin prompts and provider URLs, so enable
+// them on trusted routes only.
 type InferenceSseModule struct {
-	RouterName string `json:"router,omitempty"`
-	StyleName  string `json:"style,omitempty"`
-
-	// Resolved at provision time from StyleName.
+	RouterName  string         `json:"router,omitempty"`
+	StyleName   string         `json:"style,omitempty"`
+	ToolDeltas  string         `json:"tool_deltas,omitempty"`
+	Compression []string       `json:"compression,omitempty"`
+	Limits      *IngressLimits `json:"limits,omitempty"`
+	Plugins     []string       `json:"plugins,omitempty"`
+	DryRun      bool           `json:"dry_run,omitempty"`
+
+	// Resolved at provision time from StyleName, ToolDeltas and Compression.
 	clientStyle ail.Style
+	toolDeltas  styles.ToolDeltas
+	compress    *compression
 	reqParser   ail.Parser
 	respEmitter ail.ResponseEmitter
 	respParser  ail.ResponseParser // used by InferenceContext.ParseCapture
@@ -64,6 +82,29 @@ func parseInferenceSseModuleHelper(h httpcaddyfile.Helper, defaultStyle string)
 					return nil, h.ArgErr()
 				}
 				m.StyleName = h.Val()
+			case "tool_deltas":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.ToolDeltas = h.Val()
+			case "compression":
+				m.Compression = h.RemainingArgs()
+				if len(m.Compression) == 0 {
+					return nil, h.ArgErr()
+				}
+			case "limits":
+				l, err := parseIngressLimits(h.Dispenser)
+				if err != nil {
+					return nil, err
+				}
+				m.Limits = l
+			case "dry_run":
+				m.DryRun = true
+			case "plugins":
+				m.Plugins = h.RemainingArgs()
+				if len(m.Plugins) == 0 {
+					return nil, h.ArgErr()
+				}
 			default:
 				return nil, h.Errf("unrecognized ai_inference_sse option '%s'", h.Val())
 			}
@@ -104,11 +145,23 @@ func (m *InferenceSseModule) Provision(ctx caddy.Context) error {
 	}
 	m.clientStyle = s
 
-	m.reqParser, err = ail.GetParser(s)
+	m.toolDeltas, err = styles.ParseToolDeltas(m.ToolDeltas)
+	if err != nil {
+		return fmt.Errorf("ai_inference_sse: %w", err)
+	}
+	m.compress, err = parseCompression(m.Compression, false)
+	if err != nil {
+		return fmt.Errorf("ai_inference_sse: %w", err)
+	}
+	if err := plugin.ValidateMatchers(m.Plugins); err != nil {
+		return fmt.Errorf("ai_inference_sse: plugins: %w", err)
+	}
+
+	m.reqParser, err = styles.GetParser(s)
 	if err != nil {
 		return fmt.Errorf("ai_inference_sse: no request parser for style %s: %w", s, err)
 	}
-	m.respEmitter, err = ail.GetResponseEmitter(s)
+	m.respEmitter, err = styles.GetResponseEmitter(s)
 	if err != nil {
 		return fmt.Errorf("ai_inference_sse: no response emitter for style %s: %w", s, err)
 	}
@@ -129,18 +182,33 @@ func (m *InferenceSseModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 		prog = ctxProg
 		m.logger.Debug("Using AIL program from context (recursive call)")
 	} else {
-		reqBody, err := io.ReadAll(r.Body)
-		if err != nil {
-			m.logger.Error("failed to read request body", zap.Error(err))
-			http.Error(w, "failed to read request body", http.StatusBadRequest)
+		// Compress complete responses for the client; streams pass through.
+		var finish func()
+		w, finish = m.compress.wrap(w, r)
+		defer finish()
+
+		reqBody, perr := m.Limits.readBody(w, r)
+		if perr == nil {
+			perr = checkRequestFields(m.clientStyle, reqBody)
+		}
+		if perr != nil {
+			m.logger.Warn("request rejected before parsing", zap.Int("status", perr.status), zap.Error(perr))
+			perr.write(w)
 			return nil
 		}
 
+		var err error
 		prog, err = m.reqParser.ParseRequest(reqBody)
 		if err != nil {
 			m.logger.Error("failed to parse request",
 				zap.String("style", string(m.clientStyle)), zap.Error(err))
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error(), "invalid_request_error", "invalid_request")
+			return nil
+		}
+
+		if perr := m.Limits.check(prog); perr != nil {
+			m.logger.Warn("request rejected at ingress", zap.Int("status", perr.status), zap.Error(perr))
+			perr.write(w)
 			return nil
 		}
 	}
@@ -153,26 +221,68 @@ func (m *InferenceSseModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 	router, ok := modules.GetRouter(m.RouterName)
 	if !ok {
 		m.logger.Error("Router not found", zap.String("name", m.RouterName))
-		http.Error(w, "Router not found", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
 		return nil
 	}
 
-	chain, r, err := RequestPreamble(router, prog, r, m.logger)
+	requestedModel := prog.GetModel()
+	chain, r, err := RequestPreamble(router, prog, r, m.Plugins, m.logger)
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		writePreambleError(w, err)
 		return nil
 	}
 
-	// Preserve trace ID across InferFresh re-entries; generate only if absent.
-	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
-	if traceID == "" {
-		traceID = uuid.New().String()
-	}
+	// Preserve trace ID across InferFresh re-entries and echo it to the client.
+	traceID := requestTraceID(r)
+	w.Header().Set(plugin.RequestIDHeader, traceID)
+	setDeprecationHeader(w, r)
+	setRewritesHeader(w, r)
 	ctx := r.Context()
 	ctx = context.WithValue(ctx, plugin.ContextTraceID(), traceID)
 	ctx = context.WithValue(ctx, plugin.ContextClientStyleKey(), m.clientStyle)
 	r = r.WithContext(ctx)
 
+	// Headers and metadata plugins attach to the response (no-op when
+	// re-entered via InferFresh).
+	w, r, finishResponseMeta := startResponseMeta(w, r)
+	defer finishResponseMeta()
+
+	// One access log line per client request (no-op when disabled or
+	// when re-entered via InferFresh).
+	w, r, finishAccessLog := startAccessLog(router, w, r, requestedModel, prog, chain)
+	defer finishAccessLog()
+
+	// Bound the request by its client's timeout, if any.
+	r, cancelDeadline, perr := withRequestDeadline(r, prog)
+	defer cancelDeadline()
+	if perr != nil {
+		perr.write(w)
+		return nil
+	}
+
+	// A dry run stops at the upstream request. It is not queued and skips
+	// request-init plugins and recursive handlers, which may call out.
+	r, dryRun, perr := withDryRun(r, m.DryRun)
+	if perr != nil {
+		perr.write(w)
+		return nil
+	}
+	if dryRun {
+		if err := RunInferencePipeline(router, chain, prog, w, r, m, m.logger); err != nil {
+			m.logger.Debug("dry run failed", zap.Error(err))
+			writeRouterError(w, err)
+		}
+		return nil
+	}
+
+	// Wait for a slot in the fair admission queue (no-op when disabled
+	// or when re-entered via InferFresh).
+	release, r, admitted := admitRequest(router, w, r, m.logger)
+	if !admitted {
+		return nil
+	}
+	defer release()
+
 	// Notify plugins of the initial parsed request (e.g., sampler).
 	chain.RunRequestInit(r, prog)
 
@@ -201,9 +311,9 @@ func (m *InferenceSseModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 	// Check if any recursive handler plugin wants to handle this request.
 	handled, err := chain.RunRecursiveHandlers(ic, prog, w, r)
 	if handled {
-		if err != nil {
+		if err != nil && !services.ClientGone(r.Context(), err) {
 			m.logger.Error("recursive handler plugin failed", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeRouterError(w, services.PluginError(deadlineErr(r, err)))
 		}
 		return nil
 	}
@@ -211,7 +321,7 @@ func (m *InferenceSseModule) ServeHTTP(w http.ResponseWriter, r *http.Request, n
 	// Normal flow — shared provider iteration pipeline.
 	if err := RunInferencePipeline(router, chain, prog, w, r, m, m.logger); err != nil {
 		m.logger.Error("request handling failed", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRouterError(w, err)
 		return nil
 	}
 
@@ -239,17 +349,20 @@ func (m *InferenceSseModule) ServeNonStreaming(
 	resProg, err = chain.RunAfter(&p.Impl, r, prog, res, resProg)
 	if err != nil {
 		m.logger.Error("plugin after hook error", zap.Error(err))
-		http.Error(w, "Plugin error", http.StatusInternalServerError)
+		writeRouterError(w, services.PluginError(err))
 		return nil
 	}
+	setPluginsHeader(w, chain, p.Impl.Router)
+	addPluginReport(r, chain, p.Impl.Router, resProg)
 
 	resData, err := m.respEmitter.EmitResponse(resProg)
 	if err != nil {
 		m.logger.Error("Failed to emit response", zap.Error(err))
-		http.Error(w, "Response emission error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "response emission error", "server_error", "response_emission_error")
 		return nil
 	}
 
+	setRequestCost(w, &p.Impl, prog.GetModel(), resProg)
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(resData)
 	return err
@@ -264,39 +377,76 @@ func (m *InferenceSseModule) ServeStreaming(
 	w http.ResponseWriter,
 	r *http.Request,
 ) error {
+	// Copy the stream to archiving plugins as it goes out.
+	w, closeTee := teeStream(chain, &p.Impl, w, r)
+	defer closeTee()
+
 	sseWriter := sse.NewWriter(w)
+	announceRequestCost(w)
+	defer sseWriter.StartKeepalive(plugin.SSEKeepaliveFromContext(r.Context()))()
 
 	if err := sseWriter.WriteHeartbeat("ok"); err != nil {
 		return err
 	}
 
-	// StreamConverter handles cross-style chunk conversion (provider → client).
-	conv, err := ail.NewStreamConverter(p.Impl.Style, m.clientStyle)
+	// The encoder handles cross-style chunk conversion (provider → client).
+	toolDeltas := toolDeltasFor(r, m.toolDeltas)
+	enc, err := styles.NewStreamEncoder(p.Impl.Style, m.clientStyle, toolDeltas)
 	if err != nil {
-		m.logger.Error("failed to create stream converter", zap.Error(err))
+		m.logger.Error("failed to create stream encoder", zap.Error(err))
 		return err
 	}
 
+	// When the client speaks the provider's style and no plugin sees the
+	// chunks, upstream events are written as they came; chunks are still
+	// parsed for usage, stats and the assembled response. A stream falls
+	// back to the encoder at its first chunk without raw bytes.
+	passthrough := p.Impl.Style == m.clientStyle &&
+		toolDeltas != styles.ToolDeltasBuffered &&
+		!chain.HasStreamChunkPlugins()
+
+	r, abandon := withClientCancel(r)
+	defer abandon(nil)
+
+	stats := newStreamStats()
 	hres, stream, err := cmd.DoInferenceStream(&p.Impl, prog, r)
 	if err != nil {
+		if services.ClientGone(r.Context(), err) {
+			return services.ErrClientClosed
+		}
 		m.logger.Error("inference stream error", zap.String("provider", p.Name), zap.Error(err))
 		_ = chain.RunError(&p.Impl, r, prog, hres, err)
-		_ = sseWriter.WriteError("start failed")
-		_ = sseWriter.WriteDone()
 		return err
 	}
 
 	chunks := make([]*ail.Program, 0, 10)
 
 	for chunk := range stream {
+		if r.Context().Err() != nil {
+			break // client gone; the driver is closing the upstream
+		}
 		if chunk.RuntimeError != nil {
-			_ = sseWriter.WriteError(chunk.RuntimeError.Error())
+			_ = writeStreamError(sseWriter, chunk.RuntimeError)
 			_ = chain.RunError(&p.Impl, r, prog, hres, chunk.RuntimeError)
 			return nil
 		}
 
 		chunkProg := chunk.Data
 
+		if passthrough {
+			if chunk.Raw != nil {
+				if chunkProg != nil {
+					chunks = append(chunks, chunkProg)
+					stats.observe(chunkProg)
+				}
+				if err := sseWriter.WriteEvent(chunk.Event, chunk.Raw); err != nil {
+					abandon(services.ErrClientClosed)
+				}
+				continue
+			}
+			passthrough = false
+		}
+
 		chunkProg, err = chain.RunAfterChunk(&p.Impl, r, prog, hres, chunkProg)
 		if err != nil {
 			m.logger.Error("plugin after chunk error", zap.Error(err))
@@ -305,45 +455,80 @@ func (m *InferenceSseModule) ServeStreaming(
 
 		if chunkProg != nil {
 			chunks = append(chunks, chunkProg)
+			stats.observe(chunkProg)
 
-			outputs, convErr := conv.PushProgram(chunkProg)
+			events, convErr := enc.Push(chunkProg)
 			if convErr != nil {
 				m.logger.Error("stream convert error", zap.Error(convErr))
-				continue
 			}
 
-			for _, out := range outputs {
-				if err := sseWriter.WriteRaw(out); err != nil {
-					m.logger.Error("stream write error", zap.Error(err))
-					return err
+			for _, ev := range events {
+				if err := sseWriter.WriteEvent(ev.Name, ev.Data); err != nil {
+					abandon(services.ErrClientClosed)
+					break
 				}
 			}
 		}
 	}
 
-	// Flush buffered data (e.g. pending tool calls).
-	if final, flushErr := conv.Flush(); flushErr != nil {
-		m.logger.Error("stream converter flush error", zap.Error(flushErr))
-	} else {
-		for _, out := range final {
-			if err := sseWriter.WriteRaw(out); err != nil {
-				m.logger.Error("stream flush write error", zap.Error(err))
-				break
+	// Count usage when the upstream sent none, so every client gets it.
+	usageChunk := streamUsageChunk(m.logger, &p.Impl, r, prog, chunks)
+	if usageChunk != nil {
+		chunks = append(chunks, usageChunk)
+	}
+
+	// Assemble all chunks into a single response program for StreamEnd.
+	assembled := plugin.ConcatChunks(chunks)
+
+	if r.Context().Err() != nil {
+		_ = chain.RunStreamEnd(&p.Impl, r, prog, hres, assembled)
+		if services.DeadlineExceeded(r.Context()) {
+			m.logger.Info("request deadline exceeded mid-stream", zap.String("provider", p.Name))
+			_ = writeStreamError(sseWriter, services.ErrRequestDeadline)
+			if styles.StreamEndsWithDone(m.clientStyle) {
+				_ = sseWriter.WriteDone()
 			}
+			return nil
 		}
+		m.logger.Info("client disconnected mid-stream, upstream cancelled", zap.String("provider", p.Name))
+		return services.ErrClientClosed
 	}
 
-	// Assemble all chunks into a single response program for StreamEnd.
-	assembled := ail.NewProgram()
-	for _, c := range chunks {
-		if c != nil {
-			assembled = assembled.Append(c)
+	// Write the usage chunk (for chat clients the last chunk before
+	// [DONE]), then the held-back events: pending tool calls, and the
+	// terminal events of Anthropic and Responses streams, which carry it.
+	// A passed-through stream has held nothing back, and its terminal
+	// events have gone out with the upstream's own usage.
+	var final []styles.Event
+	if usageChunk != nil && (!passthrough || styles.StreamEndsWithDone(m.clientStyle)) {
+		events, err := enc.Push(usageChunk)
+		if err != nil {
+			m.logger.Error("stream convert error", zap.Error(err))
+		}
+		final = append(final, events...)
+	}
+	var events []styles.Event
+	var flushErr error
+	if !passthrough {
+		events, flushErr = enc.Flush()
+	}
+	if flushErr != nil {
+		m.logger.Error("stream encoder flush error", zap.Error(flushErr))
+	}
+	for _, ev := range append(final, events...) {
+		if err := sseWriter.WriteEvent(ev.Name, ev.Data); err != nil {
+			m.logger.Error("stream flush write error", zap.Error(err))
+			break
 		}
 	}
 
 	_ = chain.RunStreamEnd(&p.Impl, r, prog, hres, assembled)
+	setRequestCost(w, &p.Impl, prog.GetModel(), assembled)
 
-	_ = sseWriter.WriteDone()
+	_ = writeStreamStats(sseWriter, r, stats.finish(&p.Impl, prog.GetModel(), assembled))
+	if styles.StreamEndsWithDone(m.clientStyle) {
+		_ = sseWriter.WriteDone()
+	}
 	return nil
 }
 
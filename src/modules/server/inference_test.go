@@ -0,0 +1,645 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// stubInference is an InferenceCommand that is never expected to reach an
+// upstream; the pipeline tests only exercise provider selection.
+type stubInference struct{}
+
+func (stubInference) DoInference(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, *ail.Program, error) {
+	return nil, ail.NewProgram(), nil
+}
+
+func (stubInference) DoInferenceStream(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, chan drivers.InferenceStreamChunk, error) {
+	return nil, nil, nil
+}
+
+// recordingHandler records which providers the pipeline dispatched to.
+type recordingHandler struct{ served []string }
+
+func (h *recordingHandler) ServeNonStreaming(p *modules.ProviderConfig, _ drivers.InferenceCommand, _ *plugin.PluginChain, _ *ail.Program, w http.ResponseWriter, _ *http.Request) error {
+	h.served = append(h.served, p.Name)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *recordingHandler) ServeStreaming(p *modules.ProviderConfig, cmd drivers.InferenceCommand, chain *plugin.PluginChain, prog *ail.Program, w http.ResponseWriter, r *http.Request) error {
+	return h.ServeNonStreaming(p, cmd, chain, prog, w, r)
+}
+
+// newTestRouter builds a router over the given providers, in order, with
+// stub inference commands and fresh latency trackers.
+func newTestRouter(providers ...*modules.ProviderConfig) *modules.RouterModule {
+	m := &modules.RouterModule{
+		ProviderConfigs:         map[string]*modules.ProviderConfig{},
+		DefaultProviderForModel: map[string][]string{},
+	}
+	m.Impl.Logger = zap.NewNop()
+	for _, p := range providers {
+		p.Impl.Name = p.Name
+		p.Impl.Commands = map[string]any{"inference": stubInference{}}
+		if p.Impl.Latency == nil {
+			p.Impl.Latency = &services.LatencyTracker{}
+		}
+		m.ProviderConfigs[p.Name] = p
+		m.ProvidersOrder = append(m.ProvidersOrder, p.Name)
+	}
+	return m
+}
+
+func runTestPipeline(t *testing.T, router *modules.RouterModule, h InferenceHandler, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	if err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, w, r, h, zap.NewNop()); err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	return w
+}
+
+func decodeErrorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error envelope: %v", err)
+	}
+	return body.Error.Code
+}
+
+func TestPipeline_AllSaturatedReturns503(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	a.Impl.Concurrency = services.NewConcurrencyLimiter(1, 0)
+	a.Impl.RateLimiter = services.NewRateLimiter(services.RateLimitConfig{RPM: 1}, nil, 0)
+	if _, err := a.Impl.Concurrency.Acquire(context.Background(), services.PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(a)
+
+	h := &recordingHandler{}
+	w := runTestPipeline(t, router, h, nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	if code := decodeErrorCode(t, w); code != "provider_overloaded" {
+		t.Errorf("expected provider_overloaded, got %q", code)
+	}
+	if len(h.served) != 0 {
+		t.Errorf("saturated provider should not be served, got %v", h.served)
+	}
+
+	// The skipped request must not have consumed the provider's RPM budget.
+	if _, err := a.Impl.RateLimiter.Acquire(context.Background(), "m", 0); err != nil {
+		t.Errorf("rate-limit budget was not refunded: %v", err)
+	}
+}
+
+func TestPipeline_NoProviderReturns404(t *testing.T) {
+	w := runTestPipeline(t, newTestRouter(), &recordingHandler{}, nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if code := decodeErrorCode(t, w); code != "model_not_found" {
+		t.Errorf("expected model_not_found, got %q", code)
+	}
+}
+
+func TestPipeline_AllRateLimitedSetsRetryAfter(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	a.Impl.RateLimiter = services.NewRateLimiter(services.RateLimitConfig{RPM: 1}, nil, time.Millisecond)
+	_, _ = a.Impl.RateLimiter.Acquire(context.Background(), "m", 0)
+	router := newTestRouter(a)
+
+	w := runTestPipeline(t, router, &recordingHandler{}, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra == "" || ra == "0" {
+		t.Errorf("expected a positive Retry-After, got %q", ra)
+	}
+	if code := decodeErrorCode(t, w); code != "rate_limit_exceeded" {
+		t.Errorf("expected rate_limit_exceeded, got %q", code)
+	}
+}
+
+func TestPipeline_StrategyKeepsPinnedProvider(t *testing.T) {
+	pinned := &modules.ProviderConfig{Name: "pinned"}
+	pinned.Impl.Prices = map[string]services.ModelPrice{"*": {Input: 100, Output: 100}}
+	cheap := &modules.ProviderConfig{Name: "cheap"}
+	cheap.Impl.Prices = map[string]services.ModelPrice{"*": {Input: 1, Output: 1}}
+	router := newTestRouter(cheap, pinned)
+	router.DefaultProviderForModel["m"] = []string{"pinned"}
+
+	h := &recordingHandler{}
+	runTestPipeline(t, router, h, http.Header{"X-Routing-Strategy": {"cost"}})
+	if len(h.served) != 1 || h.served[0] != "pinned" {
+		t.Errorf("default_provider_for_model must win over the cost strategy, served %v", h.served)
+	}
+}
+
+func TestPipeline_SkipsCoolingDownProvider(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	b := &modules.ProviderConfig{Name: "b"}
+	router := newTestRouter(a, b)
+	router.Impl.Cooldowns = services.NewCooldowns(kv.NewMemoryStore(10, time.Minute), "", time.Second, time.Minute)
+	router.Impl.Cooldowns.Trip(context.Background(), "a", "m", 30*time.Second)
+
+	h := &recordingHandler{}
+	runTestPipeline(t, router, h, nil)
+	if len(h.served) != 1 || h.served[0] != "b" {
+		t.Fatalf("expected cooling-down provider to be skipped, served %v", h.served)
+	}
+
+	router.Impl.Cooldowns.Trip(context.Background(), "b", "m", 5*time.Second)
+	w := runTestPipeline(t, router, &recordingHandler{}, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("expected 429 with Retry-After 5, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// failingHandler fails every provider except the ones listed.
+type failingHandler struct {
+	recordingHandler
+	ok map[string]bool
+}
+
+func (h *failingHandler) ServeNonStreaming(p *modules.ProviderConfig, cmd drivers.InferenceCommand, chain *plugin.PluginChain, prog *ail.Program, w http.ResponseWriter, r *http.Request) error {
+	if !h.ok[p.Name] {
+		return errors.New("upstream failed")
+	}
+	return h.recordingHandler.ServeNonStreaming(p, cmd, chain, prog, w, r)
+}
+
+func TestPipeline_StickyRouting(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	b := &modules.ProviderConfig{Name: "b"}
+	router := newTestRouter(a, b)
+	router.Impl.Affinity = services.NewAffinity(kv.NewMemoryStore(10, time.Minute), "", time.Minute)
+	conv := func(id string) http.Header { return http.Header{plugin.ConversationIDHeader: {id}} }
+
+	// a fails over to b, which the conversation then sticks to.
+	runTestPipeline(t, router, &failingHandler{ok: map[string]bool{"b": true}}, conv("c1"))
+	h := &recordingHandler{}
+	runTestPipeline(t, router, h, conv("c1"))
+	if len(h.served) != 1 || h.served[0] != "b" {
+		t.Errorf("expected the conversation to stay on b, served %v", h.served)
+	}
+
+	h = &recordingHandler{}
+	runTestPipeline(t, router, h, conv("c2"))
+	if len(h.served) != 1 || h.served[0] != "a" {
+		t.Errorf("expected another conversation to take the configured order, served %v", h.served)
+	}
+}
+
+func TestPipeline_RecordsMetrics(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	b := &modules.ProviderConfig{Name: "b"}
+	router := newTestRouter(a, b)
+	router.Name = "metrics-test"
+
+	served := services.Metrics.Requests.WithLabelValues("metrics-test", "b", outcomeSuccess)
+	errorsA := services.Metrics.ProviderErrors.WithLabelValues("metrics-test", "a")
+	before, beforeErr := testutil.ToFloat64(served), testutil.ToFloat64(errorsA)
+
+	runTestPipeline(t, router, &failingHandler{ok: map[string]bool{"b": true}}, nil)
+
+	if got := testutil.ToFloat64(served) - before; got != 1 {
+		t.Errorf("expected one successful request served by b, got %v", got)
+	}
+	if got := testutil.ToFloat64(errorsA) - beforeErr; got != 1 {
+		t.Errorf("expected one provider error for a, got %v", got)
+	}
+	if n := testutil.CollectAndCount(services.Metrics.FallbackHops, "ai_router_fallback_hops"); n == 0 {
+		t.Error("expected a fallback hops observation")
+	}
+}
+
+// upstreamFailHandler fails every provider with the upstream status
+// given for it.
+type upstreamFailHandler struct {
+	recordingHandler
+	status map[string]int
+	body   map[string]string // default: {"error":{"message":"<name> failed"}}
+}
+
+func (h *upstreamFailHandler) ServeNonStreaming(p *modules.ProviderConfig, _ drivers.InferenceCommand, _ *plugin.PluginChain, _ *ail.Program, _ http.ResponseWriter, _ *http.Request) error {
+	h.served = append(h.served, p.Name)
+	status := h.status[p.Name]
+	res := &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}}
+	body, ok := h.body[p.Name]
+	if !ok {
+		body = `{"error":{"message":"` + p.Name + ` failed"}}`
+	}
+	return services.UpstreamError(p.Name, res, []byte(body))
+}
+
+func TestPipeline_ListsEveryFailedAttempt(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"})
+	h := &upstreamFailHandler{status: map[string]int{"a": 500, "b": 503}}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	w := httptest.NewRecorder()
+	err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, w, httptest.NewRequest(http.MethodPost, "/", nil), h, zap.NewNop())
+	if err == nil {
+		t.Fatal("expected the pipeline to fail")
+	}
+	writeRouterError(w, err)
+
+	var body struct {
+		Error struct {
+			Message  string             `json:"message"`
+			Attempts []services.Attempt `json:"attempts"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadGateway || body.Error.Message != "a failed" {
+		t.Errorf("got %d %q, want the first failure's 502", w.Code, body.Error.Message)
+	}
+	want := []services.Attempt{
+		{Provider: "a", Status: 500, Code: "provider_error", Message: "a failed"},
+		{Provider: "b", Status: 503, Code: "provider_error", Message: "b failed"},
+	}
+	if !slices.Equal(body.Error.Attempts, want) {
+		t.Errorf("attempts = %+v, want %+v", body.Error.Attempts, want)
+	}
+}
+
+func TestPipeline_FatalFailureStopsFallover(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"})
+	h := &upstreamFailHandler{
+		status: map[string]int{"a": 400, "b": 500},
+		body:   map[string]string{"a": `{"error":{"message":"maximum context length is 8192 tokens"}}`},
+	}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), h, zap.NewNop())
+	if err == nil {
+		t.Fatal("expected the pipeline to fail")
+	}
+	if !slices.Equal(h.served, []string{"a"}) {
+		t.Errorf("served = %v, want only a: an oversized prompt fails on every provider", h.served)
+	}
+	if re := services.AsRouterError(err); re.HTTPStatus() != http.StatusBadRequest {
+		t.Errorf("status = %d, want the upstream 400", re.HTTPStatus())
+	}
+}
+
+func TestPipeline_ProviderBadRequestFallsOver(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"}, &modules.ProviderConfig{Name: "b"}, &modules.ProviderConfig{Name: "c"})
+	h := &upstreamFailHandler{status: map[string]int{"a": 400, "b": 422, "c": 500}}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	if err := RunInferencePipeline(router, plugin.NewPluginChain(), prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), h, zap.NewNop()); err == nil {
+		t.Fatal("expected the pipeline to fail")
+	}
+	if !slices.Equal(h.served, []string{"a", "b", "c"}) {
+		t.Errorf("served = %v, want every provider: another may accept what one rejects", h.served)
+	}
+}
+
+func TestRequestTraceID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(plugin.RequestIDHeader, "client-req.42")
+	if got := requestTraceID(r); got != "client-req.42" {
+		t.Errorf("incoming ID not reused: %q", got)
+	}
+
+	r.Header.Set(plugin.RequestIDHeader, "bad id\r\nX-Evil: 1")
+	if got := requestTraceID(r); got == "" || got == r.Header.Get(plugin.RequestIDHeader) {
+		t.Errorf("malformed ID accepted: %q", got)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), "outer"))
+	if got := requestTraceID(r); got != "outer" {
+		t.Errorf("re-entry trace ID not preserved: %q", got)
+	}
+}
+
+// goneHandler reports the client as disconnected from the first provider.
+type goneHandler struct{ recordingHandler }
+
+func (h *goneHandler) ServeNonStreaming(p *modules.ProviderConfig, _ drivers.InferenceCommand, _ *plugin.PluginChain, _ *ail.Program, _ http.ResponseWriter, _ *http.Request) error {
+	h.served = append(h.served, p.Name)
+	return services.ErrClientClosed
+}
+
+func TestPipeline_ClientGoneStopsFallover(t *testing.T) {
+	a := &modules.ProviderConfig{Name: "a"}
+	b := &modules.ProviderConfig{Name: "b"}
+	router := newTestRouter(a, b)
+	router.Name = "client-gone-test"
+
+	closed := services.Metrics.Requests.WithLabelValues("client-gone-test", "a", outcomeClientClosed)
+	errorsA := services.Metrics.ProviderErrors.WithLabelValues("client-gone-test", "a")
+	before, beforeErr := testutil.ToFloat64(closed), testutil.ToFloat64(errorsA)
+
+	h := &goneHandler{}
+	w := runTestPipeline(t, router, h, nil)
+
+	if len(h.served) != 1 || h.served[0] != "a" {
+		t.Errorf("served %v, want only a", h.served)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("wrote %q to a gone client", w.Body.String())
+	}
+	if got := testutil.ToFloat64(closed) - before; got != 1 {
+		t.Errorf("expected one client_closed request, got %v", got)
+	}
+	if got := testutil.ToFloat64(errorsA) - beforeErr; got != 0 {
+		t.Errorf("a disconnect must not count as a provider error, got %v", got)
+	}
+}
+
+// flakyPlugin is a non-critical plugin whose Before always fails.
+type flakyPlugin struct{}
+
+func (flakyPlugin) Name() string   { return "flaky" }
+func (flakyPlugin) FailOpen() bool { return true }
+
+func (flakyPlugin) Before(string, *services.ProviderService, *http.Request, *ail.Program) (*ail.Program, error) {
+	return nil, errors.New("down")
+}
+
+func TestPipeline_PluginHeaders(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"})
+	router.Impl.PluginTimings = true
+	chain := plugin.NewPluginChain()
+	chain.Add(flakyPlugin{}, "x")
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	w := httptest.NewRecorder()
+	h := &recordingHandler{}
+	if err := RunInferencePipeline(router, chain, prog, w, httptest.NewRequest(http.MethodPost, "/", nil), h, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.served) != 1 {
+		t.Fatalf("fail-open plugin stopped dispatch: served %v", h.served)
+	}
+	if got := w.Header().Get("X-Plugins-Executed"); got != "flaky:x;failed" {
+		t.Errorf("X-Plugins-Executed = %q", got)
+	}
+	if got := w.Header().Get("X-Plugin-Timings"); !strings.HasPrefix(got, "flaky:x;dur=") || !strings.HasSuffix(got, ";errors=1") {
+		t.Errorf("X-Plugin-Timings = %q", got)
+	}
+}
+
+// initProbe records the models of the requests it is initialised with.
+type initProbe struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (*initProbe) Name() string { return "init-probe" }
+
+func (p *initProbe) OnRequestInit(_ *http.Request, prog *ail.Program) {
+	p.mu.Lock()
+	p.models = append(p.models, prog.GetModel())
+	p.mu.Unlock()
+}
+
+func TestEndpoints_RunRequestInit(t *testing.T) {
+	probe := &initProbe{}
+	plugin.RegisterPlugin(probe.Name(), probe)
+	defer delete(plugin.Registry, probe.Name())
+
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+	modules.RegisterRouter("request-init-test", router)
+
+	ailModule := &InferenceAILModule{RouterName: "request-init-test", Plugins: []string{"init-probe"}, logger: zap.NewNop()}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SET_MODEL p/ail\n"))
+	if err := ailModule.ServeHTTP(httptest.NewRecorder(), r, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sseModule := &InferenceSseModule{RouterName: "request-init-test", Plugins: []string{"init-probe"}, logger: zap.NewNop()}
+	var err error
+	if sseModule.reqParser, err = ail.GetParser(ail.StyleChatCompletions); err != nil {
+		t.Fatal(err)
+	}
+	if sseModule.respEmitter, err = styles.GetResponseEmitter(ail.StyleChatCompletions); err != nil {
+		t.Fatal(err)
+	}
+	if sseModule.compress, err = parseCompression([]string{"off"}, false); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"model":"p/sse","messages":[{"role":"user","content":"hi"}]}`))
+	if err := sseModule.ServeHTTP(httptest.NewRecorder(), r, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(probe.models, " "); got != "p/ail p/sse" {
+		t.Errorf("request-init saw %q, want one call per endpoint", got)
+	}
+}
+
+// streamEndProbe records the program StreamEnd receives.
+type streamEndProbe struct{ assembled *ail.Program }
+
+func (*streamEndProbe) Name() string { return "stream-end-probe" }
+
+func (p *streamEndProbe) StreamEnd(_ string, _ *services.ProviderService, _ *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	p.assembled = assembled
+	return nil
+}
+
+func TestStreamEnd_ReceivesAssembledResponse(t *testing.T) {
+	probe := &streamEndProbe{}
+	plugin.RegisterPlugin(probe.Name(), probe)
+	defer delete(plugin.Registry, probe.Name())
+
+	var chunks []*ail.Program
+	for _, text := range []string{"Hello", ", world"} {
+		c := ail.NewProgram()
+		c.EmitString(ail.TXT_CHUNK, text)
+		chunks = append(chunks, c)
+	}
+	p := &modules.ProviderConfig{Name: "p"}
+	router := newTestRouter(p)
+	router.Impl.Auth = services.NopAuthService{}
+	p.Impl.Commands["inference"] = chunkInference{chunks: chunks}
+	modules.RegisterRouter("stream-end-test", router)
+
+	m := &InferenceAILModule{RouterName: "stream-end-test", Plugins: []string{probe.Name()}, logger: zap.NewNop()}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SET_MODEL p/m\nSET_STREAM\n"))
+	if err := m.ServeHTTP(httptest.NewRecorder(), r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if probe.assembled == nil {
+		t.Fatal("StreamEnd not called")
+	}
+	var text strings.Builder
+	for _, inst := range probe.assembled.Code {
+		if inst.Op == ail.TXT_CHUNK {
+			text.WriteString(inst.Str)
+		}
+	}
+	if text.String() != "Hello, world" {
+		t.Errorf("assembled text = %q, want every chunk", text.String())
+	}
+}
+
+func TestRequestPreamble_RejectsUnmetDependencies(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+	modules.RegisterRouter("dependencies-test", router)
+	m := &InferenceAILModule{RouterName: "dependencies-test", logger: zap.NewNop()}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SET_MODEL p/m+kvtools:no-such-backend\n"))
+	if err := m.ServeHTTP(w, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "plugin_error") ||
+		!strings.Contains(w.Body.String(), "no-such-backend") {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestPreamble_VirtualParams(t *testing.T) {
+	temp, maxTokens := 0.2, 512
+	vp := &virtual.VirtualPlugin{
+		ProviderName:  "bots",
+		ModelMappings: map[string]string{"support": "p/m"},
+		Params: map[string]*virtual.Params{"support": {
+			Temperature:     &temp,
+			MaxTokens:       &maxTokens,
+			ReasoningEffort: "low",
+			SystemPrompt:    "You are the support bot.",
+		}},
+	}
+	plugin.RegisterPlugin("virtual:bots", vp)
+	t.Cleanup(func() { delete(plugin.Registry, "virtual:bots") })
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+
+	prog, err := ail.Asm("SET_MODEL bots/support\nSET_TEMP 0.9\nMSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if _, _, err := RequestPreamble(router, prog, r, nil, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	got := prog.Disasm()
+	for _, want := range []string{
+		"SET_MODEL p/m", "SET_TEMP 0.2", "SET_MAX 512", `"effort":"low"`, "You are the support bot.", `"hi"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("program lacks %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "0.9") {
+		t.Errorf("client temperature not overridden:\n%s", got)
+	}
+}
+
+func TestRequestPreamble_ModelPlugins(t *testing.T) {
+	plugin.RegisterPlugin("virtual:bots", &virtual.VirtualPlugin{
+		ProviderName:  "bots",
+		ModelMappings: map[string]string{"support": "p/m"},
+	})
+	plugin.RegisterModelPlugins("support", []string{"fuzz"})
+	t.Cleanup(func() {
+		delete(plugin.Registry, "virtual:bots")
+		plugin.UnregisterModelPlugins("support")
+	})
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+
+	prog, err := ail.Asm("SET_MODEL bots/support\nMSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	chain, _, err := RequestPreamble(router, prog, r, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.GetModel() != "p/m" {
+		t.Errorf("model = %s", prog.GetModel())
+	}
+	found := false
+	for _, pi := range chain.GetPlugins() {
+		found = found || pi.Plugin.Name() == "fuzz"
+	}
+	if !found {
+		t.Error("plugins attached to the alias were dropped after the rewrite")
+	}
+}
+
+func TestResolveModel_AliasCycle(t *testing.T) {
+	plugin.RegisterPlugin("virtual:loop", &virtual.VirtualPlugin{
+		ProviderName:  "loop",
+		ModelMappings: map[string]string{"entry": "loop/a", "a": "loop/b", "b": "loop/a"},
+	})
+	t.Cleanup(func() { plugin.UnregisterPlugin("virtual:loop") })
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	_, _, rewrites, err := resolveModel(r, "loop/entry", "", nil, zap.NewNop())
+	var re *services.RouterError
+	if !errors.As(err, &re) || re.Kind != services.ErrorConfig {
+		t.Fatalf("err = %v", err)
+	}
+	if want := "model alias cycle: loop/a -> loop/b -> loop/a"; re.Message != want {
+		t.Errorf("message = %q, want %q", re.Message, want)
+	}
+	if len(rewrites) != 3 {
+		t.Errorf("rewrites = %+v", rewrites)
+	}
+}
+
+func TestModelRewritesHeader(t *testing.T) {
+	plugin.RegisterPlugin("virtual:trace", &virtual.VirtualPlugin{
+		ProviderName:  "trace",
+		ModelMappings: map[string]string{"bot": "trace/inner", "inner": "p/m"},
+	})
+	t.Cleanup(func() { plugin.UnregisterPlugin("virtual:trace") })
+	router := newTestRouter(&modules.ProviderConfig{Name: "p"})
+	router.Impl.Auth = services.NopAuthService{}
+	modules.RegisterRouter("rewrites-test", router)
+	m := &InferenceAILModule{RouterName: "rewrites-test", logger: zap.NewNop()}
+
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("SET_MODEL trace/bot\n")), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "trace/bot;to=trace/inner;by=virtual:trace,trace/inner;to=p/m;by=virtual:trace"
+	if got := w.Header().Get(modelRewritesHeader); got != want {
+		t.Errorf("%s = %q, want %q", modelRewritesHeader, got, want)
+	}
+}
@@ -7,10 +7,34 @@ import (
 )
 
 func init() {
+	// CORS runs ahead of the AI handlers so it can answer preflights.
+	caddy.RegisterModule(&CORSModule{})
+	httpcaddyfile.RegisterHandlerDirective("ai_cors", ParseCORSModule)
+	httpcaddyfile.RegisterDirectiveOrder("ai_cors", httpcaddyfile.Before, "header")
+
 	caddy.RegisterModule(&ListModelsModule{})
 	httpcaddyfile.RegisterHandlerDirective("ai_list_models", ParseListModelsModule)
 	httpcaddyfile.RegisterDirectiveOrder("ai_list_models", httpcaddyfile.Before, "header")
 
+	caddy.RegisterModule(&EmbeddingsModule{})
+	httpcaddyfile.RegisterHandlerDirective("ai_embeddings", ParseEmbeddingsModule)
+	httpcaddyfile.RegisterDirectiveOrder("ai_embeddings", httpcaddyfile.Before, "header")
+
+	caddy.RegisterModule(&HealthModule{})
+	httpcaddyfile.RegisterHandlerDirective("ai_health", ParseHealthModule)
+	httpcaddyfile.RegisterDirectiveOrder("ai_health", httpcaddyfile.Before, "header")
+
+	caddy.RegisterModule(&UsageModule{})
+	httpcaddyfile.RegisterHandlerDirective("ai_usage", ParseUsageModule)
+	httpcaddyfile.RegisterDirectiveOrder("ai_usage", httpcaddyfile.Before, "header")
+
+	caddy.RegisterModule(&InspectModule{})
+	httpcaddyfile.RegisterHandlerDirective("ai_inspect", ParseInspectModule)
+	httpcaddyfile.RegisterDirectiveOrder("ai_inspect", httpcaddyfile.Before, "header")
+
+	caddy.RegisterModule(&ReplayAdminAPI{})
+	caddy.RegisterModule(&ExplainAdminAPI{})
+
 	caddy.RegisterModule(&InferenceAILModule{})
 	httpcaddyfile.RegisterHandlerDirective("ai_inference_ail", ParseInferenceAILModule)
 	httpcaddyfile.RegisterDirectiveOrder("ai_inference_ail", httpcaddyfile.Before, "header")
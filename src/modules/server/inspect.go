@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+	"go.uber.org/zap"
+)
+
+const (
+	inspectBuffer    = 256
+	inspectHeartbeat = 15 * time.Second
+)
+
+// InspectModule streams live request events over SSE: the parsed request,
+// the program sent upstream after plugins, the response and provider
+// errors, each with its AIL disassembly. Credentials are always redacted;
+// redact_content also replaces message text, reasoning and tool payloads
+// with their sizes. Filter a single request with ?trace_id=<id>.
+//
+// The stream exposes prompts from every client, so only mount it behind
+// authentication.
+//
+// Caddyfile:
+//
+//	handle_path /debug/inspect {
+//	    basic_auth { ... }
+//	    ai_inspect {
+//	        redact_content
+//	    }
+//	}
+type InspectModule struct {
+	RedactContent bool `json:"redact_content,omitempty"`
+	logger        *zap.Logger
+}
+
+// inspectMessage is one SSE event of the inspector stream.
+type inspectMessage struct {
+	services.InspectorEvent
+	AIL string `json:"ail"`
+}
+
+func ParseInspectModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m InspectModule
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "redact_content":
+				if h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.RedactContent = true
+			default:
+				return nil, h.Errf("unrecognized ai_inspect option '%s'", h.Val())
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (*InspectModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_inspect",
+		New: func() caddy.Module { return new(InspectModule) },
+	}
+}
+
+func (m *InspectModule) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger(m)
+	return nil
+}
+
+func (m *InspectModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return nil
+	}
+	traceID := r.URL.Query().Get("trace_id")
+
+	sub := services.LiveInspector.Subscribe(inspectBuffer)
+	defer sub.Close()
+
+	sseWriter := sse.NewWriter(w)
+	if err := sseWriter.WriteHeartbeat("inspector"); err != nil {
+		return nil
+	}
+	m.logger.Info("inspector connected", zap.String("remote", r.RemoteAddr))
+	defer m.logger.Info("inspector disconnected", zap.String("remote", r.RemoteAddr))
+
+	heartbeat := time.NewTicker(inspectHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if err := sseWriter.WriteHeartbeat("ping"); err != nil {
+				return nil
+			}
+		case ev := <-sub.C:
+			if traceID != "" && ev.TraceID != traceID {
+				continue
+			}
+			if n := sub.Dropped(); n > 0 {
+				_ = sseWriter.WriteData(map[string]int64{"dropped": n})
+			}
+			if err := sseWriter.WriteData(m.message(ev)); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+func (m *InspectModule) message(ev services.InspectorEvent) inspectMessage {
+	prog := ev.Prog
+	if m.RedactContent {
+		prog = services.RedactContent(prog)
+	}
+	ev.Error = services.RedactSecrets(ev.Error)
+	return inspectMessage{InspectorEvent: ev, AIL: services.RedactSecrets(prog.Disasm())}
+}
+
+var (
+	_ caddy.Provisioner           = (*InspectModule)(nil)
+	_ caddyhttp.MiddlewareHandler = (*InspectModule)(nil)
+)
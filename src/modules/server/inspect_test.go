@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestInspectModule_StreamsFilteredRedactedEvents(t *testing.T) {
+	m := &InspectModule{logger: zap.NewNop()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = m.ServeHTTP(w, r, nil)
+	}))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "?trace_id=want")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !services.LiveInspector.Active() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	prog.EmitString(ail.TXT_CHUNK, "use sk-abcdefghijklmnopqrstuv please")
+	services.LiveInspector.Publish(services.InspectorEvent{TraceID: "other", Stage: services.InspectRequest, Prog: prog})
+	services.LiveInspector.Publish(services.InspectorEvent{TraceID: "want", Stage: services.InspectUpstream, Prog: prog})
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var msg struct {
+			TraceID string `json:"trace_id"`
+			Stage   string `json:"stage"`
+			AIL     string `json:"ail"`
+		}
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.TraceID != "want" || msg.Stage != services.InspectUpstream {
+			t.Fatalf("unexpected event %+v", msg)
+		}
+		if strings.Contains(msg.AIL, "sk-abc") || !strings.Contains(msg.AIL, "[REDACTED]") {
+			t.Errorf("secret not redacted:\n%s", msg.AIL)
+		}
+		return
+	}
+	t.Fatal("stream ended without an event")
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/ail"
+)
+
+// Default ingress limits, generous enough for long agent sessions with
+// inline images while keeping a single request's program bounded.
+const (
+	defaultMaxBodyBytes    = 96 << 20 // the buffer limit, base64-encoded
+	defaultMaxInstructions = 200_000
+	defaultMaxBufferBytes  = 64 << 20
+	defaultMaxMessages     = 10_000
+	defaultMaxToolDefs     = 512
+)
+
+// IngressLimits bounds the AIL programs an inference endpoint accepts from
+// clients. Zero uses the default; a negative value disables that limit.
+//
+// Caddyfile (inside ai_inference_sse or ail):
+//
+//	limits {
+//	    max_body_bytes   <n>   # raw request body, checked before parsing
+//	    max_instructions <n>
+//	    max_buffer_bytes <n>   # buffers plus inline text and JSON operands
+//	    max_messages     <n>
+//	    max_tool_defs    <n>
+//	}
+type IngressLimits struct {
+	MaxBodyBytes    int `json:"max_body_bytes,omitempty"`
+	MaxInstructions int `json:"max_instructions,omitempty"`
+	MaxBufferBytes  int `json:"max_buffer_bytes,omitempty"`
+	MaxMessages     int `json:"max_messages,omitempty"`
+	MaxToolDefs     int `json:"max_tool_defs,omitempty"`
+}
+
+// parseIngressLimits parses a limits { ... } block.
+func parseIngressLimits(d *caddyfile.Dispenser) (*IngressLimits, error) {
+	l := &IngressLimits{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		opt := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		n, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return nil, d.Errf("invalid %s: %v", opt, err)
+		}
+		switch opt {
+		case "max_body_bytes":
+			l.MaxBodyBytes = n
+		case "max_instructions":
+			l.MaxInstructions = n
+		case "max_buffer_bytes":
+			l.MaxBufferBytes = n
+		case "max_messages":
+			l.MaxMessages = n
+		case "max_tool_defs":
+			l.MaxToolDefs = n
+		default:
+			return nil, d.Errf("unrecognized limits option '%s'", opt)
+		}
+	}
+	return l, nil
+}
+
+// limitOrDefault resolves a configured limit; 0 means no limit.
+func limitOrDefault(v, def int) int {
+	switch {
+	case v < 0:
+		return 0
+	case v == 0:
+		return def
+	}
+	return v
+}
+
+// programError is an ingress rejection with the HTTP status to send, and
+// the request field at fault, if any.
+type programError struct {
+	status  int
+	code    string
+	param   string
+	message string
+}
+
+func (e *programError) Error() string { return e.message }
+
+// write sends the rejection as an OpenAI-style error.
+func (e *programError) write(w http.ResponseWriter) {
+	writeJSONParamError(w, e.status, e.message, "invalid_request_error", e.code, e.param)
+}
+
+// readBody reads the request body, rejecting one over max_body_bytes
+// (413) without reading past the limit. A nil receiver applies the
+// default.
+func (l *IngressLimits) readBody(w http.ResponseWriter, r *http.Request) ([]byte, *programError) {
+	if l == nil {
+		l = &IngressLimits{}
+	}
+	maxBody := limitOrDefault(l.MaxBodyBytes, defaultMaxBodyBytes)
+	if maxBody > 0 && r.ContentLength > int64(maxBody) {
+		return nil, bodyTooLarge(maxBody)
+	}
+	body := r.Body
+	if maxBody > 0 {
+		body = http.MaxBytesReader(w, r.Body, int64(maxBody))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, bodyTooLarge(maxBody)
+		}
+		return nil, &programError{status: http.StatusBadRequest, code: "invalid_request", message: "failed to read request body"}
+	}
+	return data, nil
+}
+
+// check validates a freshly parsed client program: its blocks must nest
+// correctly and buffer references resolve (400), and it must stay within
+// the limits (413). A nil receiver applies the defaults.
+func (l *IngressLimits) check(prog *ail.Program) *programError {
+	if l == nil {
+		l = &IngressLimits{}
+	}
+	maxInstructions := limitOrDefault(l.MaxInstructions, defaultMaxInstructions)
+	maxBytes := limitOrDefault(l.MaxBufferBytes, defaultMaxBufferBytes)
+	maxMessages := limitOrDefault(l.MaxMessages, defaultMaxMessages)
+	maxToolDefs := limitOrDefault(l.MaxToolDefs, defaultMaxToolDefs)
+
+	// Checked before walking, so an oversized program is rejected cheaply.
+	if maxInstructions > 0 && prog.Len() > maxInstructions {
+		return tooLarge("instructions", prog.Len(), maxInstructions)
+	}
+
+	size := 0
+	for _, b := range prog.Buffers {
+		size += len(b)
+	}
+	messages, toolDefs := 0, 0
+	var open []ail.Opcode // enclosing block starts, innermost last
+	for i, inst := range prog.Code {
+		size += len(inst.Str) + len(inst.Key) + len(inst.JSON)
+		switch inst.Op {
+		case ail.MSG_START:
+			messages++
+		case ail.DEF_START:
+			toolDefs++
+		case ail.IMG_REF, ail.AUD_REF, ail.TXT_REF, ail.THINK_REF:
+			if int(inst.Ref) >= len(prog.Buffers) {
+				return invalidProgram("instruction %d: %s references missing buffer %d", i, inst.Op, inst.Ref)
+			}
+		}
+		if end, ok := blockEnds[inst.Op]; ok {
+			if inst.Op == ail.MSG_START && len(open) > 0 {
+				return invalidProgram("instruction %d: MSG_START inside an open %s block", i, open[len(open)-1])
+			}
+			open = append(open, end)
+		} else if isBlockEnd(inst.Op) {
+			if len(open) == 0 || open[len(open)-1] != inst.Op {
+				return invalidProgram("instruction %d: unmatched %s", i, inst.Op)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return invalidProgram("unterminated block, expected %s", open[len(open)-1])
+	}
+
+	switch {
+	case maxBytes > 0 && size > maxBytes:
+		return tooLarge("buffer bytes", size, maxBytes)
+	case maxMessages > 0 && messages > maxMessages:
+		return tooLarge("messages", messages, maxMessages)
+	case maxToolDefs > 0 && toolDefs > maxToolDefs:
+		return tooLarge("tool definitions", toolDefs, maxToolDefs)
+	}
+	return nil
+}
+
+// blockEnds maps each block start opcode to its end.
+var blockEnds = map[ail.Opcode]ail.Opcode{
+	ail.MSG_START:    ail.MSG_END,
+	ail.THINK_START:  ail.THINK_END,
+	ail.DEF_START:    ail.DEF_END,
+	ail.CALL_START:   ail.CALL_END,
+	ail.RESULT_START: ail.RESULT_END,
+}
+
+func isBlockEnd(op ail.Opcode) bool {
+	for _, end := range blockEnds {
+		if op == end {
+			return true
+		}
+	}
+	return false
+}
+
+func tooLarge(what string, got, limit int) *programError {
+	return &programError{
+		status:  http.StatusRequestEntityTooLarge,
+		code:    "request_too_large",
+		message: fmt.Sprintf("request has %d %s, limit is %d", got, what, limit),
+	}
+}
+
+func bodyTooLarge(limit int) *programError {
+	return &programError{
+		status:  http.StatusRequestEntityTooLarge,
+		code:    "request_too_large",
+		message: fmt.Sprintf("request body exceeds %d bytes", limit),
+	}
+}
+
+func invalidProgram(format string, args ...any) *programError {
+	return &programError{
+		status:  http.StatusBadRequest,
+		code:    "invalid_request",
+		message: "invalid program: " + fmt.Sprintf(format, args...),
+	}
+}
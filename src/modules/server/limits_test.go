@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/neutrome-labs/ail"
+)
+
+func TestIngressLimits_ParsedRequestsPass(t *testing.T) {
+	parser, _ := ail.GetParser(ail.StyleChatCompletions)
+	prog, err := parser.ParseRequest([]byte(`{"model":"m","messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]},
+		{"role":"assistant","tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":"{}"}}]},
+		{"role":"tool","tool_call_id":"c1","content":"ok"}],
+		"tools":[{"type":"function","function":{"name":"f","parameters":{"type":"object"}}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perr := (*IngressLimits)(nil).check(prog); perr != nil {
+		t.Fatalf("valid request rejected: %v", perr)
+	}
+}
+
+func TestIngressLimits_Exceeded(t *testing.T) {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	for range 3 {
+		prog.Emit(ail.MSG_START)
+		prog.Emit(ail.ROLE_USR)
+		prog.EmitString(ail.TXT_CHUNK, "hello")
+		prog.Emit(ail.MSG_END)
+	}
+	prog.Emit(ail.DEF_START)
+	prog.EmitString(ail.DEF_NAME, "f")
+	prog.Emit(ail.DEF_END)
+
+	cases := []struct {
+		limits IngressLimits
+		want   string
+	}{
+		{IngressLimits{MaxInstructions: 10}, "instructions"},
+		{IngressLimits{MaxBufferBytes: 10}, "buffer bytes"},
+		{IngressLimits{MaxMessages: 2}, "messages"},
+		{IngressLimits{MaxToolDefs: 1, MaxMessages: -1}, ""},
+		{IngressLimits{MaxToolDefs: -1, MaxMessages: 3}, ""},
+	}
+	for _, tc := range cases {
+		perr := tc.limits.check(prog)
+		switch {
+		case tc.want == "" && perr != nil:
+			t.Errorf("%+v: unexpected %v", tc.limits, perr)
+		case tc.want != "" && (perr == nil || perr.status != http.StatusRequestEntityTooLarge || !strings.Contains(perr.message, tc.want)):
+			t.Errorf("%+v: got %v, want 413 on %s", tc.limits, perr, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&IngressLimits{MaxMessages: 1}).check(prog).write(w)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "request has 3 messages, limit is 1") {
+		t.Errorf("response = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIngressLimits_Malformed(t *testing.T) {
+	for _, src := range []string{
+		"MSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\n",
+		"MSG_START\nROLE_USR\nMSG_START\nMSG_END\nMSG_END\n",
+		"MSG_START\nROLE_USR\nCALL_END\nMSG_END\n",
+		"MSG_START\nROLE_USR\nIMG_REF ref:0\nMSG_END\n",
+	} {
+		prog, err := ail.Asm(src)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		perr := (*IngressLimits)(nil).check(prog)
+		if perr == nil || perr.status != http.StatusBadRequest {
+			t.Errorf("%q: got %v, want 400", src, perr)
+		}
+	}
+}
+
+func TestIngressLimits_ReadBody(t *testing.T) {
+	l := &IngressLimits{MaxBodyBytes: 8}
+	if body, perr := l.readBody(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345678"))); perr != nil || string(body) != "12345678" {
+		t.Errorf("body=%q err=%v", body, perr)
+	}
+	// Rejected on the declared length, and when reading past the limit.
+	for _, length := range []int64{9, -1} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456789"))
+		r.ContentLength = length
+		if _, perr := l.readBody(httptest.NewRecorder(), r); perr == nil || perr.status != http.StatusRequestEntityTooLarge {
+			t.Errorf("length %d: got %v, want 413", length, perr)
+		}
+	}
+	if _, perr := (&IngressLimits{MaxBodyBytes: -1}).readBody(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456789"))); perr != nil {
+		t.Errorf("disabled limit: %v", perr)
+	}
+}
+
+func TestParseIngressLimits(t *testing.T) {
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`ail {
+		router main
+		limits {
+			max_body_bytes 4096
+			max_instructions 1000
+			max_tool_defs -1
+		}
+	}`)}
+	mh, err := ParseInferenceAILModule(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mh.(*InferenceAILModule)
+	if m.RouterName != "main" || m.Limits == nil || m.Limits.MaxBodyBytes != 4096 || m.Limits.MaxInstructions != 1000 || m.Limits.MaxToolDefs != -1 {
+		t.Errorf("module = %+v, limits = %+v", m, m.Limits)
+	}
+
+	h = httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`ail {
+		limits {
+			max_widgets 3
+		}
+	}`)}
+	if _, err := ParseInferenceAILModule(h); err == nil {
+		t.Error("unknown limits option accepted")
+	}
+}
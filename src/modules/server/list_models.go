@@ -1,22 +1,52 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/neutrome-labs/open-ai-router/src/drivers"
 	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
 	"go.uber.org/zap"
 )
 
-// ListModelsModule aggregates models from all configured providers.
+// Defaults of ai_list_models: how long one provider's list may take, and
+// how many providers are asked at once.
+const (
+	defaultListModelsTimeout     = 5 * time.Second
+	defaultListModelsConcurrency = 8
+)
+
+// ListModelsModule aggregates models from all configured providers. Each
+// entry carries what the router's catalog knows about the model: context
+// window, modalities, tool support, pricing (the provider's own, when it
+// has one) and deprecation date.
+//
+// Providers are asked in parallel, each under its own timeout; one that
+// fails or runs out of time is left out of the list, so a slow provider
+// doesn't hold up /models.
+//
+// Caddyfile:
+//
+//	ai_list_models {
+//	    router      <name>
+//	    timeout     <duration>   # per provider, default 5s
+//	    concurrency <n>          # providers asked at once, default 8
+//	}
 type ListModelsModule struct {
-	RouterName string `json:"router,omitempty"`
-	logger     *zap.Logger
+	RouterName  string         `json:"router,omitempty"`
+	Timeout     caddy.Duration `json:"timeout,omitempty"`
+	Concurrency int            `json:"concurrency,omitempty"`
+	logger      *zap.Logger
 }
 
 func ParseListModelsModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
@@ -29,6 +59,24 @@ func ParseListModelsModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler,
 					return nil, h.ArgErr()
 				}
 				m.RouterName = h.Val()
+			case "timeout":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				d, err := caddy.ParseDuration(h.Val())
+				if err != nil || d <= 0 {
+					return nil, h.Errf("invalid ai_list_models timeout '%s'", h.Val())
+				}
+				m.Timeout = caddy.Duration(d)
+			case "concurrency":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				n, err := strconv.Atoi(h.Val())
+				if err != nil || n <= 0 {
+					return nil, h.Errf("invalid ai_list_models concurrency '%s'", h.Val())
+				}
+				m.Concurrency = n
 			default:
 				return nil, h.Errf("unrecognized ai_list_models option '%s'", h.Val())
 			}
@@ -46,6 +94,12 @@ func (*ListModelsModule) CaddyModule() caddy.ModuleInfo {
 
 func (m *ListModelsModule) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger(m)
+	if m.Timeout <= 0 {
+		m.Timeout = caddy.Duration(defaultListModelsTimeout)
+	}
+	if m.Concurrency <= 0 {
+		m.Concurrency = defaultListModelsConcurrency
+	}
 	return nil
 }
 
@@ -53,13 +107,18 @@ func (m *ListModelsModule) ServeHTTP(w http.ResponseWriter, r *http.Request, nex
 	router, ok := modules.GetRouter(m.RouterName)
 	if !ok {
 		m.logger.Error("Router not found", zap.String("name", m.RouterName))
-		http.Error(w, "Router not found", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
 		return nil
 	}
 
-	models := make([]drivers.ListModelsModel, 0)
-	for _, name := range router.ProvidersOrder {
-		p := router.ProviderConfigs[name]
+	// Each provider's models go to its own slot, so the list keeps the
+	// providers' order however the fetches finish.
+	names := router.Providers()
+	lists := make([][]listedModel, len(names))
+	sem := make(chan struct{}, max(m.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		p, _ := router.Provider(name)
 		if p == nil {
 			m.logger.Warn("Provider config is nil", zap.String("name", name))
 			continue
@@ -80,20 +139,23 @@ func (m *ListModelsModule) ServeHTTP(w http.ResponseWriter, r *http.Request, nex
 			continue
 		}
 
-		xmodels, err := cmd.DoListModels(&p.Impl, r)
-		if err != nil {
-			m.logger.Error("Error listing models", zap.String("provider", name), zap.Error(err))
-			continue
-		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-r.Context().Done():
+				return
+			}
+			lists[i] = m.listProvider(router, p, cmd, r)
+		}()
+	}
+	wg.Wait()
 
-		for _, xm := range xmodels {
-			models = append(models, drivers.ListModelsModel{
-				Object:  "model",
-				ID:      strings.ToLower(p.Name) + "/" + xm.ID,
-				Name:    xm.Name,
-				OwnedBy: xm.OwnedBy,
-			})
-		}
+	models := make([]listedModel, 0)
+	for _, l := range lists {
+		models = append(models, l...)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -103,6 +165,55 @@ func (m *ListModelsModule) ServeHTTP(w http.ResponseWriter, r *http.Request, nex
 	})
 }
 
+// listProvider lists p's models within the module's timeout; a provider
+// that fails lists none.
+func (m *ListModelsModule) listProvider(router *modules.RouterModule, p *modules.ProviderConfig, cmd drivers.ListModelsCommand, r *http.Request) []listedModel {
+	timeout := time.Duration(m.Timeout)
+	if timeout <= 0 {
+		timeout = defaultListModelsTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	xmodels, err := cmd.DoListModels(&p.Impl, r.WithContext(ctx))
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			m.logger.Warn("Listing models timed out", zap.String("provider", p.Name), zap.Duration("timeout", timeout))
+		} else {
+			m.logger.Error("Error listing models", zap.String("provider", p.Name), zap.Error(err))
+		}
+		return nil
+	}
+	m.logger.Debug("Listed models", zap.String("provider", p.Name),
+		zap.Int("models", len(xmodels)), zap.Duration("took", time.Since(start)))
+
+	models := make([]listedModel, 0, len(xmodels))
+	for _, xm := range xmodels {
+		lm := listedModel{ListModelsModel: drivers.ListModelsModel{
+			Object:  "model",
+			ID:      strings.ToLower(p.Name) + "/" + xm.ID,
+			Name:    xm.Name,
+			OwnedBy: xm.OwnedBy,
+		}}
+		info, known := router.Impl.Catalog.Lookup(xm.ID)
+		if mp, ok := p.Impl.PriceFor(xm.ID); ok {
+			info.Price, known = &mp, true
+		}
+		if known {
+			lm.ModelInfo = &info
+		}
+		models = append(models, lm)
+	}
+	return models
+}
+
+// listedModel is a /models entry with its catalog metadata, when known.
+type listedModel struct {
+	drivers.ListModelsModel
+	*services.ModelInfo
+}
+
 var (
 	_ caddy.Provisioner           = (*ListModelsModule)(nil)
 	_ caddyhttp.MiddlewareHandler = (*ListModelsModule)(nil)
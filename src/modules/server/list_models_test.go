@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+type staticModels []string
+
+func (s staticModels) DoListModels(*services.ProviderService, *http.Request) ([]drivers.ListModelsModel, error) {
+	var out []drivers.ListModelsModel
+	for _, id := range s {
+		out = append(out, drivers.ListModelsModel{ID: id, Name: id})
+	}
+	return out, nil
+}
+
+func TestListModels_CatalogMetadata(t *testing.T) {
+	p := &modules.ProviderConfig{Name: "openai"}
+	router := newTestRouter(p)
+	router.Impl.Catalog = services.NewCatalog(map[string]services.ModelInfo{"gpt-4o": {Deprecated: "2026-06-30"}})
+	p.Impl.Router = &router.Impl
+	p.Impl.Prices = map[string]services.ModelPrice{"gpt-4o-mini": {Input: 0.1, Output: 0.2}}
+	p.Impl.Commands["list_models"] = staticModels{"gpt-4o", "gpt-4o-mini", "my-finetune"}
+	modules.RegisterRouter("list-models-test", router)
+
+	m := &ListModelsModule{RouterName: "list-models-test", logger: zap.NewNop()}
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/models", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Data) != 3 {
+		t.Fatalf("body %s: %v", w.Body.String(), err)
+	}
+	gpt4o, mini, custom := body.Data[0], body.Data[1], body.Data[2]
+	if gpt4o["id"] != "openai/gpt-4o" || gpt4o["context_window"] != 128000.0 ||
+		gpt4o["deprecation_date"] != "2026-06-30" || gpt4o["tools"] != true {
+		t.Errorf("gpt-4o = %v", gpt4o)
+	}
+	if pricing, _ := mini["pricing"].(map[string]any); pricing["input"] != 0.1 {
+		t.Errorf("gpt-4o-mini should carry the provider's price: %v", mini)
+	}
+	if _, ok := custom["context_window"]; ok || custom["id"] != "openai/my-finetune" {
+		t.Errorf("unknown model = %v", custom)
+	}
+}
+
+// listFunc adapts a function to drivers.ListModelsCommand.
+type listFunc func(*http.Request) ([]drivers.ListModelsModel, error)
+
+func (f listFunc) DoListModels(_ *services.ProviderService, r *http.Request) ([]drivers.ListModelsModel, error) {
+	return f(r)
+}
+
+func listModelIDs(t *testing.T, m *ListModelsModule) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/models", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %s: %v", w.Body.String(), err)
+	}
+	ids := make([]string, len(body.Data))
+	for i, d := range body.Data {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+func TestListModels_SlowAndFailingProvidersLeftOut(t *testing.T) {
+	slow, broken, fast := &modules.ProviderConfig{Name: "slow"}, &modules.ProviderConfig{Name: "broken"}, &modules.ProviderConfig{Name: "fast"}
+	router := newTestRouter(slow, broken, fast)
+	slow.Impl.Commands["list_models"] = listFunc(func(r *http.Request) ([]drivers.ListModelsModel, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+	broken.Impl.Commands["list_models"] = listFunc(func(*http.Request) ([]drivers.ListModelsModel, error) {
+		return nil, errors.New("upstream returned 500")
+	})
+	fast.Impl.Commands["list_models"] = staticModels{"a", "b"}
+	modules.RegisterRouter("list-models-partial", router)
+
+	m := &ListModelsModule{RouterName: "list-models-partial", Timeout: caddy.Duration(50 * time.Millisecond), Concurrency: 2, logger: zap.NewNop()}
+	start := time.Now()
+	ids := listModelIDs(t, m)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("listing took %v, want the slow provider cut off at its timeout", took)
+	}
+	if len(ids) != 2 || ids[0] != "fast/a" || ids[1] != "fast/b" {
+		t.Errorf("ids = %v, want fast's models only", ids)
+	}
+}
+
+func TestListModels_ConcurrentInProviderOrder(t *testing.T) {
+	var providers []*modules.ProviderConfig
+	for _, name := range []string{"p1", "p2", "p3", "p4", "p5", "p6"} {
+		providers = append(providers, &modules.ProviderConfig{Name: name})
+	}
+	router := newTestRouter(providers...)
+	var inFlight, peak atomic.Int32
+	for i, p := range providers {
+		delay := time.Duration(len(providers)-i) * 10 * time.Millisecond // later providers finish first
+		p.Impl.Commands["list_models"] = listFunc(func(*http.Request) ([]drivers.ListModelsModel, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(delay)
+			return []drivers.ListModelsModel{{ID: "m"}}, nil
+		})
+	}
+	modules.RegisterRouter("list-models-concurrent", router)
+
+	m := &ListModelsModule{RouterName: "list-models-concurrent", Timeout: caddy.Duration(time.Second), Concurrency: 3, logger: zap.NewNop()}
+	ids := listModelIDs(t, m)
+	want := []string{"p1/m", "p2/m", "p3/m", "p4/m", "p5/m", "p6/m"}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+	if got := peak.Load(); got < 2 || got > 3 {
+		t.Errorf("peak concurrency = %d, want parallel fetches capped at 3", got)
+	}
+}
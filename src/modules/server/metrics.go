@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Request outcomes reported in ai_router_requests_total.
+const (
+	outcomeSuccess       = "success"
+	outcomeError         = "error"
+	outcomeRateLimited   = "rate_limited"
+	outcomeOverloaded    = "overloaded"
+	outcomeModelNotFound = "model_not_found"
+	outcomeNoProvider    = "no_provider"
+	outcomeShed          = "shed"
+	outcomeQueueFull     = "queue_full"
+	outcomeQueueTimeout  = "queue_timeout"
+	outcomeClientClosed  = "client_closed"
+	outcomeDryRun        = "dry_run"
+)
+
+// requestMetrics accumulates what one pipeline run reports to Prometheus
+// and to the request's access record.
+type requestMetrics struct {
+	router   string
+	provider string // serving provider, empty unless outcome is success or dry_run
+	model    string // upstream model the serving provider was asked for
+	outcome  string
+	attempts int // providers dispatched to
+	start    time.Time
+	access   *services.AccessRecord
+}
+
+func (m *requestMetrics) record() {
+	m.access.SetResult(m.outcome, m.provider, m.model, m.attempts)
+	services.Metrics.Requests.WithLabelValues(m.router, m.provider, m.outcome).Inc()
+	services.Metrics.RequestDuration.WithLabelValues(m.router, m.outcome).Observe(time.Since(m.start).Seconds())
+	if m.attempts > 0 {
+		services.Metrics.FallbackHops.WithLabelValues(m.router).Observe(float64(m.attempts - 1))
+	}
+}
+
+// recordRejected counts a request refused at admission, before any
+// provider was tried.
+func recordRejected(r *http.Request, router, outcome string) {
+	services.AccessRecordFrom(r.Context()).SetResult(outcome, "", "", 0)
+	services.Metrics.Requests.WithLabelValues(router, "", outcome).Inc()
+}
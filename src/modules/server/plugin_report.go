@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// debugPluginsHeader asks a router with plugin_report debug for the full
+// report in the body of a non-streaming response, under pluginReportField.
+const (
+	debugPluginsHeader = "X-Debug-Plugins"
+	pluginReportField  = "plugin_report"
+)
+
+// pluginReportEntry is what one plugin did to a request.
+type pluginReportEntry struct {
+	Plugin     string  `json:"plugin"`
+	Params     string  `json:"params,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Modified   bool    `json:"modified,omitempty"`
+	Errors     int     `json:"errors,omitempty"`
+	FailedOpen bool    `json:"failed_open,omitempty"`
+}
+
+// pluginReport is the JSON form of the report.
+type pluginReport struct {
+	Plugins []pluginReportEntry `json:"plugins"`
+	Omitted int                 `json:"omitted,omitempty"` // dropped to fit the header
+}
+
+// reportedPlugins returns the chain indices of the plugins reported to
+// clients; internal virtual-provider plugins are left out.
+func reportedPlugins(chain *plugin.PluginChain) []int {
+	var indices []int
+	for i, pi := range chain.GetPlugins() {
+		if !strings.HasPrefix(pi.Plugin.Name(), "virtual") {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// pluginReportEntries reports the plugins reportedPlugins lists, as they
+// stand so far.
+func pluginReportEntries(chain *plugin.PluginChain) []pluginReportEntry {
+	var entries []pluginReportEntry
+	plugins := chain.GetPlugins()
+	for _, i := range reportedPlugins(chain) {
+		pi := plugins[i]
+		st := chain.Stats(i)
+		entries = append(entries, pluginReportEntry{
+			Plugin:     pi.Plugin.Name(),
+			Params:     pi.Params,
+			DurationMs: float64(st.Duration.Microseconds()) / 1000,
+			Modified:   st.Modified,
+			Errors:     st.Errors,
+			FailedOpen: st.FailedOpen,
+		})
+	}
+	return entries
+}
+
+// structured renders e as a structured-field list member (RFC 8941):
+// `<plugin>;params="<params>";dur=<ms>[;modified][;errors=<n>][;failed]`.
+func (e pluginReportEntry) structured() string {
+	var b strings.Builder
+	b.WriteString(e.Plugin)
+	if e.Params != "" {
+		b.WriteString(";params=")
+		b.WriteString(quoteSFString(e.Params))
+	}
+	fmt.Fprintf(&b, ";dur=%.3f", e.DurationMs)
+	if e.Modified {
+		b.WriteString(";modified")
+	}
+	if e.Errors > 0 {
+		fmt.Fprintf(&b, ";errors=%d", e.Errors)
+	}
+	if e.FailedOpen {
+		b.WriteString(";failed")
+	}
+	return b.String()
+}
+
+// quoteSFString quotes s as a structured-field string, which only escapes
+// quotes and backslashes and cannot hold control or non-ASCII characters;
+// those are replaced by "?".
+func quoteSFString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatPluginReport renders entries in format ("structured" or "json"),
+// dropping trailing plugins until the result fits maxBytes (0: no cap).
+// Dropped plugins are counted by an `omitted;count=<n>` member, or the
+// "omitted" field of the JSON.
+func formatPluginReport(entries []pluginReportEntry, format string, maxBytes int) string {
+	for keep := len(entries); ; keep-- {
+		var out string
+		omitted := len(entries) - keep
+		if format == "json" {
+			b, _ := json.Marshal(pluginReport{Plugins: entries[:keep], Omitted: omitted})
+			out = string(b)
+		} else {
+			items := make([]string, 0, keep+1)
+			for _, e := range entries[:keep] {
+				items = append(items, e.structured())
+			}
+			if omitted > 0 {
+				items = append(items, "omitted;count="+strconv.Itoa(omitted))
+			}
+			out = strings.Join(items, ", ")
+		}
+		if maxBytes <= 0 || len(out) <= maxBytes || keep == 0 {
+			return out
+		}
+	}
+}
+
+// pluginTimings lists what each plugin reportedPlugins lists has cost the
+// request so far, as "<name>;dur=<ms>[;errors=<n>]".
+func pluginTimings(chain *plugin.PluginChain) []string {
+	var timings []string
+	plugins := chain.GetPlugins()
+	for _, i := range reportedPlugins(chain) {
+		pi := plugins[i]
+		name := pi.Plugin.Name()
+		if pi.Params != "" {
+			name += ":" + pi.Params
+		}
+		st := chain.Stats(i)
+		t := fmt.Sprintf("%s;dur=%.3f", name, float64(st.Duration)/float64(time.Millisecond))
+		if st.Errors > 0 {
+			t += fmt.Sprintf(";errors=%d", st.Errors)
+		}
+		timings = append(timings, t)
+	}
+	return timings
+}
+
+// setPluginsHeader sets X-Plugins-Executed, and X-Plugin-Timings when the
+// router echoes them, from the chain. It is set again after the
+// after-plugins of a non-streaming response so that they show too.
+func setPluginsHeader(w http.ResponseWriter, chain *plugin.PluginChain, router *services.RouterService) {
+	if router != nil && router.PluginReport.Format != "" {
+		if entries := pluginReportEntries(chain); len(entries) > 0 {
+			w.Header().Set("X-Plugins-Executed",
+				formatPluginReport(entries, router.PluginReport.Format, router.PluginReport.MaxBytes))
+		}
+		return
+	}
+	if names := pluginNames(chain); len(names) > 0 {
+		w.Header().Set("X-Plugins-Executed", strings.Join(names, ","))
+	}
+	if router != nil && router.PluginTimings {
+		if timings := pluginTimings(chain); len(timings) > 0 {
+			w.Header().Set("X-Plugin-Timings", strings.Join(timings, ","))
+		}
+	}
+}
+
+// addPluginReport adds the full, uncapped report to a non-streaming
+// response as a top-level extension field when the router allows it and r
+// asks for it. Streamed responses only carry the header.
+func addPluginReport(r *http.Request, chain *plugin.PluginChain, router *services.RouterService, resProg *ail.Program) {
+	if router == nil || !router.PluginReport.Debug || resProg == nil {
+		return
+	}
+	if debug, _ := strconv.ParseBool(r.Header.Get(debugPluginsHeader)); !debug {
+		return
+	}
+	report, err := json.Marshal(pluginReport{Plugins: pluginReportEntries(chain)})
+	if err != nil {
+		return
+	}
+	resProg.EmitKeyJSON(ail.EXT_DATA, pluginReportField, report)
+}
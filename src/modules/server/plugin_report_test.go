@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+	"go.uber.org/zap"
+)
+
+// renamePlugin rewrites the requested model in place.
+type renamePlugin struct{}
+
+func (renamePlugin) Name() string { return "rename" }
+
+func (renamePlugin) Before(params string, _ *services.ProviderService, _ *http.Request, prog *ail.Program) (*ail.Program, error) {
+	prog.SetModel(params)
+	return prog, nil
+}
+
+// passPlugin returns an unchanged copy of the program it was given.
+type passPlugin struct{}
+
+func (passPlugin) Name() string { return "pass" }
+
+func (passPlugin) Before(_ string, _ *services.ProviderService, _ *http.Request, prog *ail.Program) (*ail.Program, error) {
+	return prog.Clone(), nil
+}
+
+func reportChain() *plugin.PluginChain {
+	chain := plugin.NewPluginChain()
+	chain.Add(renamePlugin{}, `m"2`)
+	chain.Add(passPlugin{}, "")
+	chain.Add(flakyPlugin{}, "x")
+	return chain
+}
+
+func TestPipeline_PluginReport(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"})
+	router.Impl.PluginReport = services.PluginReportConfig{Format: "structured"}
+	w := runReportPipeline(t, router)
+	items := strings.Split(w.Header().Get("X-Plugins-Executed"), ", ")
+	if len(items) != 3 {
+		t.Fatalf("X-Plugins-Executed = %q", w.Header().Get("X-Plugins-Executed"))
+	}
+	if !strings.HasPrefix(items[0], `rename;params="m\"2";dur=`) || !strings.HasSuffix(items[0], ";modified") {
+		t.Errorf("rename reported as %q", items[0])
+	}
+	if !strings.HasPrefix(items[1], "pass;dur=") || strings.Contains(items[1], "modified") {
+		t.Errorf("pass reported as %q", items[1])
+	}
+	if !strings.HasPrefix(items[2], `flaky;params="x";dur=`) || !strings.HasSuffix(items[2], ";errors=1;failed") {
+		t.Errorf("flaky reported as %q", items[2])
+	}
+	if got := w.Header().Get("X-Plugin-Timings"); got != "" {
+		t.Errorf("X-Plugin-Timings = %q alongside the report", got)
+	}
+
+	router.Impl.PluginReport.Format = "json"
+	w = runReportPipeline(t, router)
+	var report pluginReport
+	if err := json.Unmarshal([]byte(w.Header().Get("X-Plugins-Executed")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Plugins) != 3 || !report.Plugins[0].Modified || report.Plugins[0].Params != `m"2` ||
+		report.Plugins[1].Modified || !report.Plugins[2].FailedOpen || report.Plugins[2].Errors != 1 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func runReportPipeline(t *testing.T, router *modules.RouterModule) *httptest.ResponseRecorder {
+	t.Helper()
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	w := httptest.NewRecorder()
+	if err := RunInferencePipeline(router, reportChain(), prog, w, httptest.NewRequest(http.MethodPost, "/", nil), &recordingHandler{}, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestFormatPluginReport_Cap(t *testing.T) {
+	entries := []pluginReportEntry{
+		{Plugin: "a", Params: strings.Repeat("x", 40), DurationMs: 1},
+		{Plugin: "b", Params: strings.Repeat("y", 40), DurationMs: 2},
+		{Plugin: "c", Params: strings.Repeat("z", 40), DurationMs: 3},
+	}
+	full := formatPluginReport(entries, "structured", 0)
+	if strings.Count(full, ", ") != 2 {
+		t.Fatalf("uncapped report = %q", full)
+	}
+	capped := formatPluginReport(entries, "structured", 120)
+	if len(capped) > 120 || !strings.HasSuffix(capped, ", omitted;count=2") {
+		t.Errorf("capped report = %q", capped)
+	}
+
+	var report pluginReport
+	if err := json.Unmarshal([]byte(formatPluginReport(entries, "json", 150)), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Plugins) != 1 || report.Omitted != 2 {
+		t.Errorf("capped JSON report = %+v", report)
+	}
+}
+
+func TestServeNonStreaming_DebugPluginReport(t *testing.T) {
+	router := newTestRouter(&modules.ProviderConfig{Name: "a"})
+	router.Impl.PluginReport = services.PluginReportConfig{Format: "structured", MaxBytes: 16, Debug: true}
+	p := router.ProviderConfigs["a"]
+	p.Impl.Router = &router.Impl
+
+	emitter, err := styles.GetResponseEmitter(ail.StyleChatCompletions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &InferenceSseModule{respEmitter: emitter, logger: zap.NewNop()}
+	serve := func(debug string) map[string]json.RawMessage {
+		t.Helper()
+		chain := reportChain()
+		prog := ail.NewProgram()
+		prog.EmitString(ail.SET_MODEL, "m")
+		if _, err := chain.RunBefore(&p.Impl, httptest.NewRequest(http.MethodPost, "/", nil), prog); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(debugPluginsHeader, debug)
+		w := httptest.NewRecorder()
+		if err := m.ServeNonStreaming(p, stubInference{}, chain, prog, w, r); err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%v: %s", err, w.Body)
+		}
+		return body
+	}
+
+	var report pluginReport
+	if err := json.Unmarshal(serve("true")[pluginReportField], &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Plugins) != 3 || report.Omitted != 0 || !report.Plugins[0].Modified {
+		t.Errorf("debug report = %+v", report)
+	}
+	if _, ok := serve("")[pluginReportField]; ok {
+		t.Error("report in the body of a request not asking for it")
+	}
+	router.Impl.PluginReport.Debug = false
+	if _, ok := serve("true")[pluginReportField]; ok {
+		t.Error("report in the body without plugin_report debug")
+	}
+}
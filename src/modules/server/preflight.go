@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// preflight checks prog, about to go to provider p, against the catalog
+// entry of its model, so that a request the model cannot serve fails here
+// with a specific error instead of at the upstream. Limits the catalog
+// does not know are not checked. In compact mode a prompt too long for the
+// context window first loses its oldest messages, as slwin:tokens does.
+func preflight(router *modules.RouterModule, p *modules.ProviderConfig, r *http.Request, prog *ail.Program, logger *zap.Logger) (*ail.Program, error) {
+	if router.Preflight == "" {
+		return prog, nil
+	}
+	model := prog.GetModel()
+	info, ok := router.Impl.Catalog.Lookup(p.Impl.Name + "/" + model)
+	if !ok {
+		return prog, nil
+	}
+
+	modalities, tools := programNeeds(prog)
+	for _, modality := range modalities {
+		if !info.Supports(modality) {
+			return nil, services.NewRouterError(services.ErrorUnsupported,
+				fmt.Sprintf("The model `%s` does not accept %s input.", model, modality))
+		}
+	}
+	if tools && info.Tools != nil && !*info.Tools {
+		return nil, services.NewRouterError(services.ErrorUnsupported,
+			fmt.Sprintf("The model `%s` does not support tools.", model))
+	}
+
+	window := info.ContextWindow
+	if window == 0 {
+		return prog, nil
+	}
+	prompt, completion := services.EstimatePromptCompletion(prog)
+	if prompt+completion > window && router.Preflight == modules.PreflightCompact && completion < window {
+		compacted, err := compactProgram(&p.Impl, r, prog, window-completion)
+		if err == nil {
+			before := prompt
+			prog, prompt = compacted, services.CountTokens(model, compacted)
+			logger.Debug("Preflight compacted the prompt",
+				zap.String("provider", p.Impl.Name),
+				zap.String("model", model),
+				zap.Int("tokens_before", before),
+				zap.Int("tokens_after", prompt))
+		}
+	}
+	if prompt+completion > window {
+		return nil, services.NewRouterError(services.ErrorContextLength, fmt.Sprintf(
+			"This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion).",
+			window, prompt+completion, prompt, completion))
+	}
+	return prog, nil
+}
+
+// programNeeds returns the input modalities beyond text prog uses, and
+// whether it defines tools.
+func programNeeds(prog *ail.Program) (modalities []string, tools bool) {
+	var image, audio bool
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.IMG_REF:
+			image = true
+		case ail.AUD_REF:
+			audio = true
+		case ail.DEF_START:
+			tools = true
+		}
+	}
+	if image {
+		modalities = append(modalities, "image")
+	}
+	if audio {
+		modalities = append(modalities, "audio")
+	}
+	return modalities, tools
+}
+
+// compactProgram drops the oldest messages of prog, after the first, until
+// the rest fit in budget tokens, using the slwin plugin.
+func compactProgram(ps *services.ProviderService, r *http.Request, prog *ail.Program, budget int) (*ail.Program, error) {
+	p, _ := plugin.GetPlugin("slwin")
+	bp, ok := p.(plugin.BeforePlugin)
+	if !ok {
+		return nil, fmt.Errorf("slwin plugin not available")
+	}
+	return bp.Before(fmt.Sprintf("tokens=%d", budget), ps, r, prog)
+}
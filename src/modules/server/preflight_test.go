@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestPreflight(t *testing.T) {
+	noTools := false
+	p := &modules.ProviderConfig{Name: "p"}
+	router := newTestRouter(p)
+	router.Impl.Catalog = services.NewCatalog(map[string]services.ModelInfo{
+		"text-only": {ContextWindow: 1000, Modalities: []string{"text"}, Tools: &noTools},
+		"p/tiny":    {ContextWindow: 60},
+	})
+
+	long := strings.Repeat("word ", 40)
+	conversation := "MSG_START\nROLE_SYS\nTXT_CHUNK \"be brief\"\nMSG_END\n" +
+		strings.Repeat("MSG_START\nROLE_USR\nTXT_CHUNK \""+long+"\"\nMSG_END\n", 3) +
+		"MSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n"
+	tests := []struct {
+		name, mode, prog string
+		want             services.ErrorKind // "" passes
+	}{
+		{"off", "", "SET_MODEL tiny\n" + conversation, ""},
+		{"unknown model", modules.PreflightCheck, "SET_MODEL other\n" + conversation, ""},
+		{"image", modules.PreflightCheck, "SET_MODEL text-only\nMSG_START\nROLE_USR\nIMG_REF ref:0\nMSG_END\n", services.ErrorUnsupported},
+		{"tools", modules.PreflightCheck, "SET_MODEL text-only\nDEF_START\nDEF_NAME \"f\"\nDEF_END\n", services.ErrorUnsupported},
+		{"fits", modules.PreflightCheck, "SET_MODEL text-only\n" + conversation, ""},
+		{"too long", modules.PreflightCheck, "SET_MODEL tiny\n" + conversation, services.ErrorContextLength},
+		{"completion budget", modules.PreflightCheck, "SET_MODEL tiny\nSET_MAX 100\nMSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n", services.ErrorContextLength},
+		{"compacted", modules.PreflightCompact, "SET_MODEL tiny\n" + conversation, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router.Preflight = tt.mode
+			prog, err := ail.Asm(tt.prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := preflight(router, p, httptest.NewRequest("POST", "/", nil), prog, zap.NewNop())
+			var re *services.RouterError
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.want != "" && (!errors.As(err, &re) || re.Kind != tt.want):
+				t.Fatalf("error = %v, want %s", err, tt.want)
+			}
+			if tt.mode == modules.PreflightCompact {
+				if n := len(got.Messages()); n != 2 {
+					t.Errorf("compacted to %d messages:\n%s", n, got.Disasm())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/uuid"
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// replayKeyID is the key ID replayed requests run under, so usage and the
+// sampler can tell them apart (SAMPLER_EXCLUDE=key=replay).
+const replayKeyID = "replay"
+
+// ReplayAdminAPI replays a directory of sampled requests through a live
+// router and diffs the new upstream programs and responses against the
+// stored request.up.ail and response.ail, for regression testing plugin
+// and driver changes. Like the rest of the admin endpoint it needs no
+// configuration.
+//
+// Route:
+//
+//	POST /ai/replay?router=<n>&dir=<path>[&limit=<n>][&compare=upstream|response]
+//	               [&ignore=RESP_ID,USAGE][&path=/plugin:arg/...]
+//
+// path applies URL-path plugins, which are not part of the sample. Samples
+// the sampler encrypted are unsealed with SAMPLER_KEY. The response is a
+// services.ReplayReport. Replays call the real providers.
+type ReplayAdminAPI struct {
+	logger *zap.Logger
+}
+
+func (ReplayAdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.ai_replay",
+		New: func() caddy.Module { return new(ReplayAdminAPI) },
+	}
+}
+
+func (a *ReplayAdminAPI) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger(a)
+	return nil
+}
+
+func (a *ReplayAdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/ai/replay", Handler: caddy.AdminHandlerFunc(a.handleReplay)},
+	}
+}
+
+func (a *ReplayAdminAPI) handleReplay(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	q := r.URL.Query()
+	router, ok := modules.GetRouter(q.Get("router"))
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("router %q not found", q.Get("router")),
+		}
+	}
+	if q.Get("dir") == "" {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("dir is required")}
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid limit %q", v)}
+		}
+		limit = n
+	}
+	var opts services.ReplayOptions
+	switch q.Get("compare") {
+	case "":
+	case "upstream":
+		opts.SkipResponse = true
+	case "response":
+		opts.SkipUpstream = true
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("invalid compare %q (want upstream or response)", q.Get("compare")),
+		}
+	}
+	if q.Has("ignore") {
+		opts.IgnoreOps = []string{}
+		for _, op := range strings.Split(q.Get("ignore"), ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				opts.IgnoreOps = append(opts.IgnoreOps, strings.ToUpper(op))
+			}
+		}
+	}
+
+	if v := os.Getenv("SAMPLER_KEY"); v != "" {
+		key, err := services.ParseSealKey(v)
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: fmt.Errorf("SAMPLER_KEY: %w", err)}
+		}
+		opts.Key = key
+	}
+
+	samples, err := services.LoadReplayCorpus(q.Get("dir"), limit)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+
+	logger := a.logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	logger.Info("replaying corpus",
+		zap.String("router", router.Name),
+		zap.String("dir", q.Get("dir")),
+		zap.Int("samples", len(samples)))
+	path := q.Get("path")
+	report := services.RunReplay(r.Context(), samples, func(ctx context.Context, prog *ail.Program) (*ail.Program, *ail.Program, error) {
+		return replayProgram(ctx, router, path, prog, logger)
+	}, opts)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
+
+// replayProgram runs prog through router like an AIL endpoint request,
+// minus admission and access logging, and returns what the sampler would
+// have stored: the final upstream program and the response.
+func replayProgram(ctx context.Context, router *modules.RouterModule, path string, prog *ail.Program, logger *zap.Logger) (*ail.Program, *ail.Program, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &InferenceAILModule{RouterName: router.Name, logger: logger}
+
+	chain, r, err := RequestPreamble(router, prog, r, nil, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	capture := &replayCapture{}
+	chain.Add(capture, "")
+
+	ctx = r.Context()
+	ctx = context.WithValue(ctx, plugin.ContextTraceID(), "replay-"+uuid.New().String())
+	ctx = context.WithValue(ctx, plugin.ContextKeyID(), replayKeyID)
+	ctx = context.WithValue(ctx, ailOutputCtxKey{}, false)
+	r = r.WithContext(ctx)
+
+	chain.RunRequestInit(r, prog)
+
+	out := &services.ResponseCaptureWriter{}
+	ailParser := &ailResponseParser{}
+	ic := &plugin.InferenceContext{
+		Infer: func(p *ail.Program, w http.ResponseWriter, req *http.Request) error {
+			return RunInferencePipeline(router, chain, p, w, req, m, logger)
+		},
+		InferFresh: func(p *ail.Program, w http.ResponseWriter, req *http.Request) error {
+			freshR := req.WithContext(ail.ContextWithProgram(req.Context(), p))
+			return m.ServeHTTP(w, freshR, nil)
+		},
+		ParseCapture: func(cap *services.ResponseCaptureWriter) (*ail.Program, error) {
+			return plugin.ParseCapturedResponse(cap, ailParser, ailParser)
+		},
+		Chain: chain,
+	}
+	handled, err := chain.RunRecursiveHandlers(ic, prog, out, r)
+	if !handled {
+		err = RunInferencePipeline(router, chain, prog, out, r, m, logger)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if out.StatusCode >= http.StatusBadRequest {
+		return nil, nil, fmt.Errorf("status %d: %s", out.StatusCode, strings.TrimSpace(string(out.Response)))
+	}
+
+	up, res := capture.result()
+	if res == nil {
+		// A recursive handler answered without a final upstream call.
+		if res, err = ic.ParseCapture(out); err != nil {
+			return nil, nil, err
+		}
+	}
+	return up, res, nil
+}
+
+// replayCapture records the last upstream program and response of a
+// replayed request. Like the sampler, it ignores recursive sub-steps.
+type replayCapture struct {
+	mu       sync.Mutex
+	upstream *ail.Program
+	response *ail.Program
+}
+
+func (*replayCapture) Name() string { return "replay" }
+
+func (c *replayCapture) Before(_ string, _ *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
+	if _, step := plugin.SamplerStepFromContext(r.Context()); !step {
+		c.mu.Lock()
+		c.upstream = prog.Clone()
+		c.mu.Unlock()
+	}
+	return prog, nil
+}
+
+func (c *replayCapture) After(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	c.setResponse(r, resProg)
+	return resProg, nil
+}
+
+func (c *replayCapture) StreamEnd(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	c.setResponse(r, assembled)
+	return nil
+}
+
+func (c *replayCapture) setResponse(r *http.Request, prog *ail.Program) {
+	if _, step := plugin.SamplerStepFromContext(r.Context()); step || prog == nil {
+		return
+	}
+	c.mu.Lock()
+	c.response = prog.Clone()
+	c.mu.Unlock()
+}
+
+func (c *replayCapture) result() (upstream, response *ail.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.upstream, c.response
+}
+
+var (
+	_ caddy.AdminRouter      = (*ReplayAdminAPI)(nil)
+	_ caddy.Provisioner      = (*ReplayAdminAPI)(nil)
+	_ plugin.BeforePlugin    = (*replayCapture)(nil)
+	_ plugin.AfterPlugin     = (*replayCapture)(nil)
+	_ plugin.StreamEndPlugin = (*replayCapture)(nil)
+)
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// replyInference answers every request with a fixed text response.
+type replyInference struct{ text string }
+
+func (c replyInference) DoInference(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, *ail.Program, error) {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.RESP_ID, "chatcmpl-new")
+	prog.Emit(ail.MSG_START)
+	prog.Emit(ail.ROLE_AST)
+	prog.EmitString(ail.TXT_CHUNK, c.text)
+	prog.Emit(ail.MSG_END)
+	return nil, prog, nil
+}
+
+func (replyInference) DoInferenceStream(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, chan drivers.InferenceStreamChunk, error) {
+	return nil, nil, nil
+}
+
+func TestReplayAdminAPI(t *testing.T) {
+	p := &modules.ProviderConfig{Name: "p"}
+	router := newTestRouter(p)
+	router.Name = "replay-test"
+	router.Impl.Auth = services.NopAuthService{}
+	p.Impl.Commands["inference"] = replyInference{text: "new answer"}
+	modules.RegisterRouter(router.Name, router)
+
+	// One sample, recorded when the provider still answered "old answer".
+	dir := t.TempDir()
+	req := ail.NewProgram()
+	req.EmitString(ail.SET_MODEL, "m")
+	req.Emit(ail.MSG_START)
+	req.Emit(ail.ROLE_USR)
+	req.EmitString(ail.TXT_CHUNK, "hi")
+	req.Emit(ail.MSG_END)
+	_, oldRes, _ := replyInference{text: "old answer"}.DoInference(nil, nil, nil)
+	oldRes.Code[0].Str = "chatcmpl-old"
+	for name, prog := range map[string]*ail.Program{"request.ail": req, "request.up.ail": req, "response.ail": oldRes} {
+		var buf bytes.Buffer
+		if err := prog.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "abc"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "abc", name), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &ReplayAdminAPI{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/ai/replay?router=replay-test&dir="+dir, nil)
+	if err := a.handleReplay(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var report services.ReplayReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 1 || report.Changed != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	res := report.Results[0]
+	if res.Upstream == nil || res.Upstream.BaselineMissing {
+		t.Errorf("upstream not compared: %+v", res.Upstream)
+	}
+	// RESP_ID is ignored by default; only the text differs.
+	if len(res.Response.Diff) != 2 {
+		t.Errorf("response diff = %q", res.Response.Diff)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/ai/replay?router=replay-test&compare=upstream&dir="+dir, nil)
+	if err := a.handleReplay(w, r); err != nil {
+		t.Fatal(err)
+	}
+	report = services.ReplayReport{}
+	_ = json.NewDecoder(w.Body).Decode(&report)
+	if res := report.Results[0]; res.Response != nil {
+		t.Errorf("compare=upstream still compared the response: %+v", res.Response)
+	}
+
+	if err := a.handleReplay(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ai/replay", nil)); err == nil {
+		t.Error("GET accepted")
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+// startResponseMeta gives plugins a plugin.ResponseMeta for the request.
+// The returned writer writes the headers set through it ahead of the
+// body; the finish func sends those set or changed after the body started
+// as trailers. It is a no-op on InferFresh re-entries, whose plugins add
+// to the outer request's ResponseMeta.
+func startResponseMeta(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	if plugin.ResponseMetaFrom(r.Context()) != nil {
+		return w, r, func() {}
+	}
+	ctx, meta := plugin.WithResponseMeta(r.Context())
+	mw := &metaWriter{ResponseWriter: w, meta: meta}
+	return mw, r.WithContext(ctx), mw.finish
+}
+
+// metaWriter writes a ResponseMeta's headers before the first byte.
+type metaWriter struct {
+	http.ResponseWriter
+	meta *plugin.ResponseMeta
+	sent http.Header // the headers written, nil until then
+}
+
+func (w *metaWriter) writeMeta() {
+	if w.sent != nil {
+		return
+	}
+	w.sent = w.meta.Header()
+	for k, v := range w.sent {
+		w.Header()[k] = v
+	}
+}
+
+func (w *metaWriter) WriteHeader(code int) {
+	// Informational responses leave the headers for the final one.
+	if code >= 200 {
+		w.writeMeta()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metaWriter) Write(b []byte) (int, error) {
+	w.writeMeta()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metaWriter) Flush() {
+	w.writeMeta()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *metaWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// finish sends the headers set after the body started as trailers, or
+// all of them when nothing was written.
+func (w *metaWriter) finish() {
+	if w.sent == nil {
+		w.writeMeta()
+		return
+	}
+	for k, v := range w.meta.Header() {
+		if !slices.Equal(v, w.sent[k]) {
+			w.Header()[http.TrailerPrefix+k] = v
+		}
+	}
+}
+
+var _ http.Flusher = (*metaWriter)(nil)
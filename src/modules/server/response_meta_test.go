@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+func TestStartResponseMeta(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w, r, finish := startResponseMeta(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	// Re-entries add to the outer request's ResponseMeta.
+	inner, r2, _ := startResponseMeta(w, r)
+	if inner != w || plugin.ResponseMetaFrom(r2.Context()) != plugin.ResponseMetaFrom(r.Context()) {
+		t.Fatal("startResponseMeta should be a no-op on re-entry")
+	}
+
+	plugin.SetResponseHeader(r, "X-Cache", "miss")
+	plugin.SetResponseMeta(r, "variant", "b")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("data: {}\n\n"))
+	plugin.SetResponseMeta(r, "guard", "pass")
+	finish()
+
+	res := rec.Result()
+	if got := res.Header.Get("X-Cache"); got != "miss" {
+		t.Errorf("X-Cache = %q", got)
+	}
+	if got := res.Header.Get(plugin.MetaHeader); got != "variant=b" {
+		t.Errorf("%s = %q", plugin.MetaHeader, got)
+	}
+	if got := res.Trailer.Get(plugin.MetaHeader); got != "guard=pass, variant=b" {
+		t.Errorf("%s trailer = %q", plugin.MetaHeader, got)
+	}
+	if res.Trailer.Get("X-Cache") != "" {
+		t.Error("unchanged headers should not be repeated as trailers")
+	}
+}
+
+func TestStartResponseMeta_NothingWritten(t *testing.T) {
+	rec := httptest.NewRecorder()
+	_, r, finish := startResponseMeta(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	plugin.SetResponseMeta(r, "cache", "hit")
+	finish()
+	if got := rec.Header().Get(plugin.MetaHeader); got != "cache=hit" {
+		t.Errorf("%s = %q", plugin.MetaHeader, got)
+	}
+}
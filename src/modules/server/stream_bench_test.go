@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// Sustained streaming: one request of benchChunks deltas per iteration,
+// reported per chunk, so allocation regressions on the per-chunk path
+// show up regardless of the request overhead.
+const benchChunks = 500
+
+// discardWriter is a flushing ResponseWriter that drops the body, so the
+// benchmarks measure the router rather than a recorder's growing buffer.
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Flush()                      {}
+
+func benchStream() chunkInference {
+	chunks := make([]*ail.Program, benchChunks)
+	for i := range chunks {
+		c := ail.NewProgram()
+		c.EmitString(ail.RESP_ID, "chatcmpl-bench")
+		c.EmitString(ail.RESP_MODEL, "m")
+		c.EmitString(ail.STREAM_DELTA, "token ")
+		chunks[i] = c
+	}
+	return chunkInference{chunks: chunks}
+}
+
+// rawChunkInference streams chunks with the upstream bytes they were
+// parsed from, as the SSE driver does.
+type rawChunkInference struct {
+	chunkInference
+	raw []byte
+}
+
+func (c rawChunkInference) DoInferenceStream(*services.ProviderService, *ail.Program, *http.Request) (*http.Response, chan drivers.InferenceStreamChunk, error) {
+	ch := make(chan drivers.InferenceStreamChunk, len(c.chunks))
+	for _, p := range c.chunks {
+		ch <- drivers.InferenceStreamChunk{Data: p, Raw: c.raw}
+	}
+	close(ch)
+	return nil, ch, nil
+}
+
+func reportPerChunk(b *testing.B) {
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchChunks), "ns/chunk")
+}
+
+func BenchmarkInferenceSse_ServeStreaming(b *testing.B) {
+	stream := benchStream()
+	p := &modules.ProviderConfig{Name: "p"}
+	p.Impl.Style = ail.StyleChatCompletions
+	m := &InferenceSseModule{clientStyle: ail.StyleChatCompletions, logger: zap.NewNop()}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	prog.Emit(ail.SET_STREAM)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := m.ServeStreaming(p, stream, plugin.NewPluginChain(), prog, &discardWriter{header: http.Header{}}, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportPerChunk(b)
+}
+
+func BenchmarkInferenceSse_ServeStreamingPassthrough(b *testing.B) {
+	stream := rawChunkInference{
+		chunkInference: benchStream(),
+		raw:            []byte(`{"id":"chatcmpl-bench","object":"chat.completion.chunk","model":"m","choices":[{"index":0,"delta":{"content":"token "}}]}`),
+	}
+	p := &modules.ProviderConfig{Name: "p"}
+	p.Impl.Style = ail.StyleChatCompletions
+	m := &InferenceSseModule{clientStyle: ail.StyleChatCompletions, logger: zap.NewNop()}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	prog.Emit(ail.SET_STREAM)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := m.ServeStreaming(p, stream, plugin.NewPluginChain(), prog, &discardWriter{header: http.Header{}}, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportPerChunk(b)
+}
+
+func BenchmarkInferenceAIL_ServeStreamingBinary(b *testing.B) {
+	stream := benchStream()
+	p := &modules.ProviderConfig{Name: "p"}
+	m := &InferenceAILModule{logger: zap.NewNop()}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	prog.Emit(ail.SET_STREAM)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), ailOutputCtxKey{}, true))
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := m.ServeStreaming(p, stream, plugin.NewPluginChain(), prog, &discardWriter{header: http.Header{}}, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportPerChunk(b)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/drivers"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+var anthropicUpstreamEvents = []string{
+	"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"type\":\"message\",\"role\":\"assistant\",\"model\":\"claude-x\",\"content\":[],\"usage\":{\"input_tokens\":7,\"output_tokens\":1}}}\n\n",
+	"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+	"event: ping\ndata: {\"type\":\"ping\"}\n\n",
+	"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n",
+	"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+	"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":3}}\n\n",
+	"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+}
+
+// chunkSeer is a StreamChunkPlugin that lets chunks through unchanged.
+type chunkSeer struct{ seen int }
+
+func (*chunkSeer) Name() string { return "chunk-seer" }
+
+func (s *chunkSeer) AfterChunk(_ string, _ *services.ProviderService, _ *http.Request, _ *ail.Program, _ *http.Response, chunk *ail.Program) (*ail.Program, error) {
+	s.seen++
+	return chunk, nil
+}
+
+func serveAnthropicStream(t *testing.T, chain *plugin.PluginChain, clientStyle ail.Style) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range anthropicUpstreamEvents {
+			_, _ = w.Write([]byte(ev))
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	p := &modules.ProviderConfig{Name: "p"}
+	u, _ := url.Parse(upstream.URL)
+	p.Impl.Name, p.Impl.ParsedURL, p.Impl.Style = "p", *u, ail.StyleAnthropic
+	p.Impl.Router = &services.RouterService{Auth: services.NopAuthService{}}
+	p.Impl.Latency = &services.LatencyTracker{}
+	cmd, err := drivers.NewInferenceSse(ail.StyleAnthropic, "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &InferenceSseModule{clientStyle: clientStyle, logger: zap.NewNop()}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "claude-x")
+	prog.Emit(ail.SET_STREAM)
+	w := httptest.NewRecorder()
+	if err := m.ServeStreaming(p, cmd, chain, prog, w, httptest.NewRequest(http.MethodPost, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	return w.Body.String()
+}
+
+func TestServeStreaming_Passthrough(t *testing.T) {
+	body := serveAnthropicStream(t, plugin.NewPluginChain(), ail.StyleAnthropic)
+	if !strings.Contains(body, strings.Join(anthropicUpstreamEvents, "")) {
+		t.Errorf("same-style stream not passed through as sent:\n%s", body)
+	}
+	if n := strings.Count(body, "event: message_stop"); n != 1 {
+		t.Errorf("got %d message_stop events, want 1:\n%s", n, body)
+	}
+}
+
+func TestServeStreaming_PassthroughNeedsSameStyleWithoutChunkPlugins(t *testing.T) {
+	seer := &chunkSeer{}
+	chain := plugin.NewPluginChain()
+	chain.Add(seer, "")
+	body := serveAnthropicStream(t, chain, ail.StyleAnthropic)
+	if seer.seen == 0 {
+		t.Error("chunk plugin saw no chunks")
+	}
+	if strings.Contains(body, "event: ping") {
+		t.Errorf("stream with a chunk plugin was passed through:\n%s", body)
+	}
+	if !strings.Contains(body, "Hello") || strings.Count(body, "event: message_stop") != 1 {
+		t.Errorf("re-encoded stream incomplete:\n%s", body)
+	}
+
+	body = serveAnthropicStream(t, plugin.NewPluginChain(), ail.StyleChatCompletions)
+	if strings.Contains(body, "event: ") || !strings.Contains(body, "Hello") || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("cross-style stream not converted:\n%s", body)
+	}
+}
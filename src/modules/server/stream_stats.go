@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+)
+
+// streamStatsHeader opts a client into a trailing ": stats {...}" SSE
+// comment with the stream's speed, written just before [DONE]. Comments
+// are ignored by SSE parsers that do not look for them.
+const streamStatsHeader = "X-Stream-Stats"
+
+// streamStats times a streamed response as it is relayed to the client.
+type streamStats struct {
+	start, first, last time.Time
+	deltas             int
+}
+
+// streamSummary is the payload of the trailing stats comment.
+type streamSummary struct {
+	TTFTMillis       int64   `json:"ttft_ms"`
+	DurationMillis   int64   `json:"duration_ms"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TokensPerSecond  float64 `json:"tokens_per_second,omitempty"`
+}
+
+// newStreamStats starts timing; call it before dispatching upstream.
+func newStreamStats() *streamStats {
+	return &streamStats{start: time.Now()}
+}
+
+// observe notes the content deltas in a chunk about to be sent.
+func (s *streamStats) observe(chunk *ail.Program) {
+	n := 0
+	for _, inst := range chunk.Code {
+		switch inst.Op {
+		case ail.STREAM_DELTA, ail.STREAM_THINK_DELTA, ail.STREAM_TOOL_DELTA:
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	now := time.Now()
+	if s.first.IsZero() {
+		s.first = now
+	}
+	s.last = now
+	s.deltas += n
+}
+
+// finish records the stream's metrics for model on p. Completion tokens
+// come from the upstream usage, or are estimated as one per delta when
+// the provider reports none.
+func (s *streamStats) finish(p *services.ProviderService, model string, assembled *ail.Program) streamSummary {
+	sum := streamSummary{
+		DurationMillis:   time.Since(s.start).Milliseconds(),
+		CompletionTokens: s.deltas,
+	}
+	if u, ok := services.UsageFromProgram(assembled); ok && u.CompletionTokens > 0 {
+		sum.CompletionTokens = u.CompletionTokens
+	}
+	if s.first.IsZero() {
+		return sum
+	}
+
+	routerName := ""
+	if p.Router != nil {
+		routerName = p.Router.Name
+	}
+	ttft := s.first.Sub(s.start)
+	sum.TTFTMillis = ttft.Milliseconds()
+	services.Metrics.FirstToken.WithLabelValues(routerName, p.Name, model).Observe(ttft.Seconds())
+
+	// The rate covers tokens after the first, so a single-delta stream has
+	// none.
+	if gen := s.last.Sub(s.first); gen > 0 && sum.CompletionTokens > 1 {
+		sum.TokensPerSecond = float64(sum.CompletionTokens-1) / gen.Seconds()
+		services.Metrics.TokensPerSecond.WithLabelValues(routerName, p.Name, model).Observe(sum.TokensPerSecond)
+	}
+	return sum
+}
+
+// writeStreamStats writes sum as a trailing comment when the client asked
+// for it.
+func writeStreamStats(sw *sse.Writer, r *http.Request, sum streamSummary) error {
+	switch strings.ToLower(r.Header.Get(streamStatsHeader)) {
+	case "1", "true", "yes":
+	default:
+		return nil
+	}
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	return sw.WriteHeartbeat("stats " + string(data))
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStreamStats(t *testing.T) {
+	p := &services.ProviderService{Name: "p", Router: &services.RouterService{Name: "stats-test"}}
+	chunk := ail.NewProgram()
+	chunk.EmitString(ail.STREAM_DELTA, "hi")
+
+	now := time.Now()
+	s := &streamStats{start: now.Add(-3 * time.Second)}
+	s.observe(ail.NewProgram())
+	if !s.first.IsZero() {
+		t.Fatal("chunk without deltas counted as the first token")
+	}
+	s.observe(chunk)
+	s.first, s.last = now.Add(-2*time.Second), now
+
+	res := ail.NewProgram()
+	res.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":5,"completion_tokens":101}`))
+	sum := s.finish(p, "m", res)
+	if sum.TTFTMillis != 1000 || sum.CompletionTokens != 101 || sum.TokensPerSecond != 50 {
+		t.Errorf("got %+v", sum)
+	}
+	if n := testutil.CollectAndCount(services.Metrics.TokensPerSecond, "ai_router_stream_tokens_per_second"); n == 0 {
+		t.Error("expected a tokens/sec observation")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if err := writeStreamStats(sse.NewWriter(w), r, sum); err != nil || w.Body.Len() != 0 {
+		t.Fatalf("stats written without opt-in: %q, %v", w.Body.String(), err)
+	}
+	r.Header.Set(streamStatsHeader, "true")
+	if err := writeStreamStats(sse.NewWriter(w), r, sum); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); !strings.HasPrefix(got, `:stats {"ttft_ms":1000,`) || !strings.HasSuffix(got, "\n\n") {
+		t.Errorf("unexpected stats comment %q", got)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// teeStream wraps w so the SSE stream written to it also reaches the
+// chain's StreamTeePlugins, byte for byte as the client gets it. The
+// returned func closes the copies and must run after the last write.
+// Streams captured by recursive handlers never reach the client and are
+// not copied.
+func teeStream(chain *plugin.PluginChain, p *services.ProviderService, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if _, captured := w.(*services.ResponseCaptureWriter); captured {
+		return w, func() {}
+	}
+	tee := chain.RunTeeStream(p, r)
+	if tee == nil {
+		return w, func() {}
+	}
+	return &teeWriter{ResponseWriter: w, tee: tee}, func() { _ = tee.Close() }
+}
+
+// teeWriter copies what reaches the client to tee; the copy never fails
+// or delays the client's write.
+type teeWriter struct {
+	http.ResponseWriter
+	tee io.Writer
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if n > 0 {
+		_, _ = w.tee.Write(b[:n])
+	}
+	return n, err
+}
+
+func (w *teeWriter) Flush() { _ = http.NewResponseController(w.ResponseWriter).Flush() }
+
+func (w *teeWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
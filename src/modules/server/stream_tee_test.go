@@ -0,0 +1,21 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTeeWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var archive bytes.Buffer
+	w := &teeWriter{ResponseWriter: rec, tee: &archive}
+
+	_, _ = io.WriteString(w, "data: 1\n\n")
+	w.Flush()
+	_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	if !rec.Flushed || rec.Body.String() != "data: 1\n\ndata: [DONE]\n\n" || archive.String() != rec.Body.String() {
+		t.Errorf("client %q (flushed %v), archive %q", rec.Body.String(), rec.Flushed, archive.String())
+	}
+}
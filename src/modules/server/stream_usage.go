@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// streamUsageChunk returns a final chunk carrying router-counted usage for
+// a stream whose upstream reported none (providers that ignore
+// stream_options.include_usage), or nil when it did. Prompt tokens are
+// counted on the upstream request, completion tokens on the streamed
+// output. The count is recorded in metrics and the access log like
+// upstream-reported usage.
+func streamUsageChunk(logger *zap.Logger, p *services.ProviderService, r *http.Request, req *ail.Program, chunks []*ail.Program) *ail.Program {
+	for _, c := range chunks {
+		if _, ok := services.UsageFromProgram(c); ok {
+			return nil
+		}
+	}
+	assembled := plugin.ConcatChunks(chunks)
+
+	model := req.GetModel()
+	u := services.Usage{
+		PromptTokens:     services.CountTokens(model, req),
+		CompletionTokens: services.CountCompletionTokens(model, assembled),
+	}
+	data, err := json.Marshal(map[string]int{
+		"prompt_tokens":     u.PromptTokens,
+		"completion_tokens": u.CompletionTokens,
+		"total_tokens":      u.PromptTokens + u.CompletionTokens,
+	})
+	if err != nil {
+		return nil
+	}
+	chunk := ail.NewProgram()
+	chunk.EmitJSON(ail.USAGE, data)
+
+	router := ""
+	if p.Router != nil {
+		router = p.Router.Name
+	}
+	services.ObserveUsage(router, p.Name, model, u)
+	services.AccessRecordFrom(r.Context()).ObserveResponse(chunk)
+	logger.Debug("upstream stream reported no usage, counted locally",
+		zap.String("provider", p.Name),
+		zap.String("model", model),
+		zap.Int("prompt_tokens", u.PromptTokens),
+		zap.Int("completion_tokens", u.CompletionTokens))
+	return chunk
+}
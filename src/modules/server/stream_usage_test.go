@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+func TestStreamUsageChunk(t *testing.T) {
+	p := &services.ProviderService{Name: "p", Router: &services.RouterService{Name: "usage-test"}}
+	req := ail.NewProgram()
+	req.SetModel("gpt-4o")
+	req.Emit(ail.MSG_START)
+	req.Emit(ail.ROLE_USR)
+	req.EmitString(ail.TXT_CHUNK, "Say hello to the whole world.")
+	req.Emit(ail.MSG_END)
+
+	text := ail.NewProgram()
+	text.EmitString(ail.STREAM_DELTA, "Hello, world!")
+	done := ail.NewProgram()
+	done.EmitString(ail.RESP_DONE, "stop")
+
+	rec := &services.AccessRecord{}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(services.ContextWithAccessRecord(r.Context(), rec))
+
+	chunk := streamUsageChunk(zap.NewNop(), p, r, req, []*ail.Program{text, done})
+	if chunk == nil {
+		t.Fatal("expected a usage chunk for a stream without usage")
+	}
+	var u struct {
+		Prompt     int `json:"prompt_tokens"`
+		Completion int `json:"completion_tokens"`
+		Total      int `json:"total_tokens"`
+	}
+	if err := json.Unmarshal(chunk.Code[0].JSON, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Prompt == 0 || u.Completion == 0 || u.Total != u.Prompt+u.Completion {
+		t.Errorf("usage = %+v", u)
+	}
+	if got := rec.Snapshot().Usage.CompletionTokens; got != u.Completion {
+		t.Errorf("access record completion tokens = %d, want %d", got, u.Completion)
+	}
+
+	reported := ail.NewProgram()
+	reported.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":1,"completion_tokens":2}`))
+	if c := streamUsageChunk(zap.NewNop(), p, r, req, []*ail.Program{text, reported}); c != nil {
+		t.Errorf("upstream usage was replaced: %v", c.Disasm())
+	}
+}
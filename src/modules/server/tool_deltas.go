@@ -0,0 +1,30 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+// toolDeltasParam is the Accept parameter that overrides the route's
+// tool-delta mode for one request.
+const toolDeltasParam = "tool_deltas"
+
+// toolDeltasFor returns the tool-delta mode asked for in the request's
+// Accept header, or def. Unknown values are ignored.
+func toolDeltasFor(r *http.Request, def styles.ToolDeltas) styles.ToolDeltas {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			_, params, err := mime.ParseMediaType(part)
+			if err != nil || params[toolDeltasParam] == "" {
+				continue
+			}
+			if mode, err := styles.ParseToolDeltas(params[toolDeltasParam]); err == nil {
+				return mode
+			}
+		}
+	}
+	return def
+}
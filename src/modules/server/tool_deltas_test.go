@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+)
+
+func TestToolDeltasFor(t *testing.T) {
+	cases := []struct {
+		accept string
+		def    styles.ToolDeltas
+		want   styles.ToolDeltas
+	}{
+		{"", styles.ToolDeltasIncremental, styles.ToolDeltasIncremental},
+		{"", styles.ToolDeltasBuffered, styles.ToolDeltasBuffered},
+		{"text/event-stream; tool_deltas=buffered", styles.ToolDeltasIncremental, styles.ToolDeltasBuffered},
+		{"application/json, text/event-stream;tool_deltas=incremental", styles.ToolDeltasBuffered, styles.ToolDeltasIncremental},
+		{"text/event-stream; tool_deltas=bogus", styles.ToolDeltasBuffered, styles.ToolDeltasBuffered},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		if got := toolDeltasFor(r, tc.def); got != tc.want {
+			t.Errorf("Accept %q, default %s: got %s, want %s", tc.accept, tc.def, got, tc.want)
+		}
+	}
+}
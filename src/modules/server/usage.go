@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/open-ai-router/src/modules"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// UsageModule serves the calling key's aggregated usage:
+//
+//	GET /v1/usage?from=YYYY-MM-DD&to=YYYY-MM-DD
+//
+// Days are UTC; the range defaults to the last 30 days. Operators can query
+// any key through the admin endpoint's GET /ai/usage.
+type UsageModule struct {
+	RouterName string `json:"router,omitempty"`
+	logger     *zap.Logger
+}
+
+func ParseUsageModule(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m UsageModule
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.RouterName = h.Val()
+			default:
+				return nil, h.Errf("unrecognized ai_usage option '%s'", h.Val())
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (*UsageModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_usage",
+		New: func() caddy.Module { return new(UsageModule) },
+	}
+}
+
+func (m *UsageModule) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger(m)
+	return nil
+}
+
+func (m *UsageModule) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	router, ok := modules.GetRouter(m.RouterName)
+	if !ok {
+		m.logger.Error("Router not found", zap.String("name", m.RouterName))
+		writeJSONError(w, http.StatusInternalServerError, "router not found", "server_error", "router_not_found")
+		return nil
+	}
+	if router.Impl.Usage == nil {
+		writeJSONError(w, http.StatusNotFound,
+			"usage accounting is not enabled", "invalid_request_error", "usage_disabled")
+		return nil
+	}
+
+	r, err := router.Impl.Auth.CollectIncomingAuth(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, err.Error(), "authentication_error", "invalid_api_key")
+		return nil
+	}
+	keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+	if keyID == "" {
+		writeJSONError(w, http.StatusUnauthorized,
+			"usage requires an authenticated API key", "authentication_error", "invalid_api_key")
+		return nil
+	}
+
+	q := r.URL.Query()
+	from, to, err := services.ParseUsageRange(q.Get("from"), q.Get("to"), time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "invalid_range")
+		return nil
+	}
+
+	rows, err := router.Impl.Usage.Query(r.Context(), keyID, from, to)
+	if err != nil {
+		m.logger.Error("usage query failed", zap.Error(err))
+		writeJSONError(w, http.StatusInternalServerError,
+			"usage is temporarily unavailable", "api_error", "usage_unavailable")
+		return nil
+	}
+	var total services.UsageCounts
+	for _, row := range rows {
+		total.Add(row.UsageCounts)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   rows,
+		"total":  total,
+	})
+}
+
+var (
+	_ caddy.Provisioner           = (*UsageModule)(nil)
+	_ caddyhttp.MiddlewareHandler = (*UsageModule)(nil)
+)
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// requiredInputs names, per client style, the field holding the
+// conversation, which must be present and not empty. Styles not listed
+// are left to their parser.
+var requiredInputs = map[ail.Style]string{
+	ail.StyleChatCompletions: "messages",
+	ail.StyleAnthropic:       "messages",
+	ail.StyleResponses:       "input",
+}
+
+// checkRequestFields validates a client request body before it is parsed
+// into a program: it must be a JSON object with a model and a non-empty
+// conversation field, or a 400 naming the missing parameter is returned.
+// Only the top level is decoded, so junk is turned away cheaply.
+func checkRequestFields(style ail.Style, body []byte) *programError {
+	field, ok := requiredInputs[style]
+	if !ok {
+		return nil
+	}
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return &programError{
+			status:  http.StatusBadRequest,
+			code:    "invalid_request",
+			message: "request body must be a JSON object: " + err.Error(),
+		}
+	}
+	var model string
+	if err := json.Unmarshal(req["model"], &model); err != nil || model == "" {
+		return missingParam("model")
+	}
+	if v := bytes.TrimSpace(req[field]); len(v) == 0 || bytes.Equal(v, []byte("null")) || isEmptyArray(v) {
+		return missingParam(field)
+	}
+	return nil
+}
+
+// isEmptyArray reports whether v is the JSON array [].
+func isEmptyArray(v []byte) bool {
+	if len(v) == 0 || v[0] != '[' {
+		return false
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(v[1:]), []byte("]"))
+}
+
+func missingParam(name string) *programError {
+	return &programError{
+		status:  http.StatusBadRequest,
+		code:    "missing_required_parameter",
+		param:   name,
+		message: fmt.Sprintf("Missing required parameter: '%s'.", name),
+	}
+}
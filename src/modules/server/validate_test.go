@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"go.uber.org/zap"
+)
+
+func TestCheckRequestFields(t *testing.T) {
+	cases := []struct {
+		style ail.Style
+		body  string
+		param string // "" for a pass; "-" for a malformed body
+	}{
+		{ail.StyleChatCompletions, `{"model": "m", "messages": [{"role": "user", "content": "hi"}]}`, ""},
+		{ail.StyleChatCompletions, `{"messages": [{"role": "user", "content": "hi"}]}`, "model"},
+		{ail.StyleChatCompletions, `{"model": 7, "messages": [{"role": "user", "content": "hi"}]}`, "model"},
+		{ail.StyleChatCompletions, `{"model": "m"}`, "messages"},
+		{ail.StyleChatCompletions, `{"model": "m", "messages": [ ]}`, "messages"},
+		{ail.StyleChatCompletions, `{"model": "m", "messages": null}`, "messages"},
+		{ail.StyleChatCompletions, `["junk"]`, "-"},
+		{ail.StyleChatCompletions, `junk`, "-"},
+		{ail.StyleAnthropic, `{"model": "m", "max_tokens": 10}`, "messages"},
+		{ail.StyleResponses, `{"model": "m", "input": "hi"}`, ""},
+		{ail.StyleResponses, `{"model": "m", "input": []}`, "input"},
+		{ail.StyleGoogleGenAI, `{}`, ""},
+	}
+	for _, tc := range cases {
+		perr := checkRequestFields(tc.style, []byte(tc.body))
+		switch {
+		case tc.param == "" && perr != nil:
+			t.Errorf("%s %s: unexpected %v", tc.style, tc.body, perr)
+		case tc.param != "" && (perr == nil || perr.status != http.StatusBadRequest):
+			t.Errorf("%s %s: got %v, want a 400", tc.style, tc.body, perr)
+		case tc.param != "" && tc.param != "-" && perr.param != tc.param:
+			t.Errorf("%s %s: param = %q, want %q", tc.style, tc.body, perr.param, tc.param)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	missingParam("messages").write(w)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"param":"messages"`) ||
+		!strings.Contains(w.Body.String(), `"code":"missing_required_parameter"`) {
+		t.Errorf("response = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestInferenceSse_RejectsBeforeParsing(t *testing.T) {
+	m := &InferenceSseModule{clientStyle: ail.StyleChatCompletions, Limits: &IngressLimits{MaxBodyBytes: 64}, logger: zap.NewNop()}
+	for body, want := range map[string]int{
+		`{"model": "m", "messages": []}`: http.StatusBadRequest,
+		`{"model": "m", "messages": [{"role": "user", "content": "` + strings.Repeat("x", 100) + `"}]}`: http.StatusRequestEntityTooLarge,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		r.ContentLength = -1 // read through the limit rather than trusting the header
+		if err := m.ServeHTTP(w, r, nil); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != want {
+			t.Errorf("%.40s: status %d, want %d: %s", body, w.Code, want, w.Body.String())
+		}
+	}
+}
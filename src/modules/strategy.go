@@ -0,0 +1,93 @@
+package modules
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Routing strategies, selectable per router (routing_strategy) and per
+// request (X-Routing-Strategy header).
+const (
+	RoutingOrder   = "order"   // configured order with model_weights balancing (default)
+	RoutingCost    = "cost"    // cheapest priced provider first
+	RoutingLatency = "latency" // lowest observed latency first
+	RoutingQuality = "quality" // highest quality tier first
+)
+
+// IsRoutingStrategy reports whether s names a known routing strategy.
+func IsRoutingStrategy(s string) bool {
+	switch s {
+	case RoutingOrder, RoutingCost, RoutingLatency, RoutingQuality:
+		return true
+	}
+	return false
+}
+
+// OrderProviders reorders the candidate providers for model according to
+// strategy. An empty strategy falls back to the router's routing_strategy.
+// Callers pass only the unpinned providers from ResolveProviders, so
+// explicit routing config is never overridden.
+//
+// Every strategy starts from the model_weights order and sorts stably on
+// top of it, so ties keep the balanced order:
+//   - cost: cheapest estimated request first; unpriced providers last.
+//   - latency: lowest smoothed latency first; providers without recent
+//     samples first of all, so new or recovered providers get explored.
+//   - quality: highest quality tier first; unranked providers last.
+func (m *RouterModule) OrderProviders(strategy, model string, providers []string, prog *ail.Program, key string) []string {
+	if strategy == "" {
+		strategy = m.RoutingStrategy
+	}
+	providers = m.WeightedOrder(model, providers, key)
+
+	switch strategy {
+	case RoutingCost:
+		prompt, completion := services.EstimatePromptCompletion(prog)
+		return m.sortProviders(providers, func(p *services.ProviderService) float64 {
+			mp, ok := p.PriceFor(model)
+			if !ok {
+				return math.Inf(1)
+			}
+			return mp.Cost(prompt, completion)
+		})
+	case RoutingLatency:
+		return m.sortProviders(providers, func(p *services.ProviderService) float64 {
+			d, ok := p.Latency.Mean()
+			if !ok {
+				return math.Inf(-1)
+			}
+			return float64(d / time.Microsecond)
+		})
+	case RoutingQuality:
+		return m.sortProviders(providers, func(p *services.ProviderService) float64 {
+			q, ok := p.QualityFor(model)
+			if !ok {
+				return math.Inf(1)
+			}
+			return -float64(q)
+		})
+	}
+	return providers
+}
+
+// sortProviders returns providers stably sorted by ascending key.
+func (m *RouterModule) sortProviders(providers []string, key func(*services.ProviderService) float64) []string {
+	m.Impl.Mu.RLock()
+	keys := make(map[string]float64, len(providers))
+	for _, name := range providers {
+		if p, ok := m.ProviderConfigs[name]; ok {
+			keys[name] = key(&p.Impl)
+		} else {
+			keys[name] = math.Inf(1)
+		}
+	}
+	m.Impl.Mu.RUnlock()
+
+	out := append([]string(nil), providers...)
+	sort.SliceStable(out, func(i, j int) bool { return keys[out[i]] < keys[out[j]] })
+	return out
+}
@@ -0,0 +1,64 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func strategyTestRouter() *RouterModule {
+	m := &RouterModule{ProviderConfigs: map[string]*ProviderConfig{}}
+	add := func(name string, price *services.ModelPrice, latency time.Duration, quality int) {
+		p := &ProviderConfig{Name: name}
+		p.Impl.Latency = &services.LatencyTracker{}
+		if price != nil {
+			p.Impl.Prices = map[string]services.ModelPrice{"*": *price}
+		}
+		if quality > 0 {
+			p.Impl.Quality = map[string]int{"m": quality}
+		}
+		if latency > 0 {
+			p.Impl.Latency.Observe(latency)
+		}
+		m.ProviderConfigs[name] = p
+	}
+	add("pricey", &services.ModelPrice{Input: 10, Output: 30}, 100*time.Millisecond, 2)
+	add("cheap", &services.ModelPrice{Input: 1, Output: 2}, 900*time.Millisecond, 1)
+	add("unknown", nil, 0, 0)
+	return m
+}
+
+func TestOrderProviders(t *testing.T) {
+	m := strategyTestRouter()
+	providers := []string{"unknown", "pricey", "cheap"}
+	prog := ail.NewProgram()
+	prog.EmitString(ail.TXT_CHUNK, "hello there")
+
+	cases := map[string][]string{
+		RoutingCost:    {"cheap", "pricey", "unknown"},
+		RoutingLatency: {"unknown", "pricey", "cheap"}, // unsampled providers are explored first
+		RoutingQuality: {"pricey", "cheap", "unknown"},
+		"":             {"unknown", "pricey", "cheap"},
+	}
+	for strategy, want := range cases {
+		got := m.OrderProviders(strategy, "m", providers, prog, "k")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("strategy %q: got %v, want %v", strategy, got, want)
+		}
+	}
+}
+
+func TestOrderProviders_LatencyPenalizesFailures(t *testing.T) {
+	m := strategyTestRouter()
+	// pricey is fastest, but a fast failure must not keep it in front.
+	m.ProviderConfigs["pricey"].Impl.Latency.ObserveFailure()
+	m.ProviderConfigs["unknown"].Impl.Latency.Observe(500 * time.Millisecond)
+	got := m.OrderProviders(RoutingLatency, "m", []string{"pricey", "cheap", "unknown"}, ail.NewProgram(), "k")
+	want := []string{"unknown", "cheap", "pricey"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
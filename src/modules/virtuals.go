@@ -0,0 +1,261 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/neutrome-labs/open-ai-router/src/drivers/virtual"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
+	"go.uber.org/zap"
+)
+
+// VirtualStore keeps the virtual providers created through the admin API
+// (PUT /ai/virtual/<name>) in a kv backend, so new aliases need no config
+// deploy and outlive restarts: key <prefix><provider>, value the
+// provider's RuntimeVirtual as JSON. They are loaded when the router
+// starts; the backend must be able to list keys.
+//
+// Caddyfile:
+//
+//	virtual_store <backend> <prefix> [<dsn>]
+type VirtualStore struct {
+	Backend string `json:"backend,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	DSN     string `json:"dsn,omitempty"`
+}
+
+// RuntimeVirtual is a virtual provider managed through the admin API: the
+// runtime counterpart of a provider block with style virtual.
+type RuntimeVirtual struct {
+	Mappings      map[string]string          `json:"mappings"`
+	Params        map[string]*virtual.Params `json:"params,omitempty"`
+	StickyTargets bool                       `json:"sticky_targets,omitempty"`
+}
+
+// VirtualInfo describes one of a router's virtual providers. Runtime marks
+// those created through the admin API; the others are configured.
+type VirtualInfo struct {
+	RuntimeVirtual
+	Runtime bool `json:"runtime"`
+}
+
+var (
+	errNoVirtualStore = errors.New("no virtual_store configured")
+	errNoSuchVirtual  = errors.New("no such runtime virtual provider")
+	errConfigured     = errors.New("provider is configured, not created at runtime")
+	errBadVirtual     = errors.New("invalid virtual provider")
+)
+
+// Validate checks rv as provisioning checks a virtual provider block.
+func (rv *RuntimeVirtual) Validate() error {
+	if len(rv.Mappings) == 0 {
+		return errors.New("at least one model mapping is required")
+	}
+	for model, spec := range rv.Mappings {
+		if model == "" {
+			return errors.New("empty model name")
+		}
+		if _, _, err := virtual.ParseTargets(spec); err != nil {
+			return fmt.Errorf("model %s: %v", model, err)
+		}
+	}
+	for model, params := range rv.Params {
+		if err := params.Validate(); err != nil {
+			return fmt.Errorf("params %s: %v", model, err)
+		}
+	}
+	return nil
+}
+
+// registerVirtual registers the model rewriter of virtual provider p and
+// returns the provider's commands.
+func (m *RouterModule) registerVirtual(name string, p *ProviderConfig) map[string]any {
+	vp := &virtual.VirtualPlugin{
+		ProviderName:  name,
+		ModelMappings: p.ModelMappings,
+		Sticky:        p.StickyTargets,
+		Healthy:       m.providerHealthy,
+		Params:        p.ModelParams,
+	}
+	plugin.RegisterPlugin("virtual:"+name, vp)
+	return map[string]any{
+		"list_models": &virtual.VirtualListModels{
+			ProviderName: name,
+			Plugin:       vp,
+		},
+	}
+}
+
+// checkRuntimeVirtual checks that rv may be installed as name; the caller
+// holds Impl.Mu.
+func (m *RouterModule) checkRuntimeVirtual(name string, rv *RuntimeVirtual) error {
+	if name == "" || name == "preset" || strings.ContainsAny(name, ":+/@ ") {
+		return fmt.Errorf("%w: bad name %q", errBadVirtual, name)
+	}
+	if _, ok := m.ProviderConfigs[name]; ok && !m.runtimeVirtuals[name] {
+		return fmt.Errorf("%s: %w", name, errConfigured)
+	}
+	if err := rv.Validate(); err != nil {
+		return fmt.Errorf("%w %s: %v", errBadVirtual, name, err)
+	}
+	return nil
+}
+
+// installVirtual adds, or replaces, runtime virtual provider name; the
+// caller holds Impl.Mu for writing. Readers may hold an earlier
+// ProvidersOrder, so it is only ever appended to or replaced.
+func (m *RouterModule) installVirtual(name string, rv *RuntimeVirtual) {
+	p := &ProviderConfig{
+		Name:          name,
+		Style:         string(styles.StyleVirtual),
+		ModelMappings: rv.Mappings,
+		StickyTargets: rv.StickyTargets,
+		ModelParams:   rv.Params,
+	}
+	p.Impl = services.ProviderService{
+		Name:    name,
+		Style:   styles.StyleVirtual,
+		Router:  &m.Impl,
+		Latency: &services.LatencyTracker{},
+	}
+	p.Impl.Commands = m.registerVirtual(name, p)
+	if _, ok := m.ProviderConfigs[name]; !ok {
+		m.ProvidersOrder = append(m.ProvidersOrder, name)
+	}
+	m.ProviderConfigs[name] = p
+	if m.runtimeVirtuals == nil {
+		m.runtimeVirtuals = make(map[string]bool)
+	}
+	m.runtimeVirtuals[name] = true
+}
+
+// openVirtualStore opens virtual_store and installs the providers saved in
+// it. A saved provider that no longer checks out is logged and skipped.
+// Called from Provision, which holds Impl.Mu.
+func (m *RouterModule) openVirtualStore(ctx context.Context) error {
+	vs := m.VirtualStore
+	if vs == nil {
+		return nil
+	}
+	store, err := kv.Open(vs.Backend, vs.DSN)
+	if err != nil {
+		return fmt.Errorf("virtual_store: %v", err)
+	}
+	lister, ok := store.(kv.Lister)
+	if !ok {
+		_ = store.Close()
+		return fmt.Errorf("virtual_store: kv backend %q cannot list keys", vs.Backend)
+	}
+	entries, err := lister.List(ctx, vs.Prefix)
+	if err != nil {
+		_ = store.Close()
+		return fmt.Errorf("virtual_store: %v", err)
+	}
+	for key, value := range entries {
+		name := key[len(vs.Prefix):]
+		var rv RuntimeVirtual
+		err := json.Unmarshal([]byte(value), &rv)
+		if err == nil {
+			err = m.checkRuntimeVirtual(name, &rv)
+		}
+		if err != nil {
+			m.Impl.Logger.Error("Skipping stored virtual provider", zap.String("provider", name), zap.Error(err))
+			continue
+		}
+		m.installVirtual(name, &rv)
+		m.Impl.Logger.Info("Loaded runtime virtual provider", zap.String("name", name), zap.Int("models", len(rv.Mappings)))
+	}
+	m.virtualStore = store
+	go func() {
+		<-ctx.Done()
+		_ = store.Close()
+	}()
+	return nil
+}
+
+// Virtuals returns the router's virtual providers by name.
+func (m *RouterModule) Virtuals() map[string]VirtualInfo {
+	m.Impl.Mu.RLock()
+	defer m.Impl.Mu.RUnlock()
+	out := make(map[string]VirtualInfo)
+	for name, p := range m.ProviderConfigs {
+		vp := m.virtualPlugin(name)
+		if vp == nil {
+			continue
+		}
+		out[name] = VirtualInfo{
+			RuntimeVirtual: RuntimeVirtual{
+				Mappings:      vp.Mappings(),
+				Params:        p.ModelParams,
+				StickyTargets: p.StickyTargets,
+			},
+			Runtime: m.runtimeVirtuals[name],
+		}
+	}
+	return out
+}
+
+// PutVirtual creates runtime virtual provider name, or replaces it, and
+// saves it to virtual_store. Configured providers cannot be replaced.
+func (m *RouterModule) PutVirtual(ctx context.Context, name string, rv *RuntimeVirtual) error {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	if m.virtualStore == nil {
+		return errNoVirtualStore
+	}
+	name = strings.ToLower(name)
+	m.Impl.Mu.RLock()
+	err := m.checkRuntimeVirtual(name, rv)
+	m.Impl.Mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rv)
+	if err != nil {
+		return err
+	}
+	if err := m.virtualStore.Set(ctx, m.VirtualStore.Prefix+name, string(data), 0); err != nil {
+		return fmt.Errorf("virtual_store: %v", err)
+	}
+
+	m.Impl.Mu.Lock()
+	m.installVirtual(name, rv)
+	m.Impl.Mu.Unlock()
+	return nil
+}
+
+// DeleteVirtual removes runtime virtual provider name and its saved copy.
+func (m *RouterModule) DeleteVirtual(ctx context.Context, name string) error {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	if m.virtualStore == nil {
+		return errNoVirtualStore
+	}
+	name = strings.ToLower(name)
+	if !m.runtimeVirtuals[name] {
+		if _, ok := m.Provider(name); ok {
+			return fmt.Errorf("%s: %w", name, errConfigured)
+		}
+		return fmt.Errorf("%s: %w", name, errNoSuchVirtual)
+	}
+	if err := m.virtualStore.Delete(ctx, m.VirtualStore.Prefix+name); err != nil {
+		return fmt.Errorf("virtual_store: %v", err)
+	}
+
+	m.Impl.Mu.Lock()
+	delete(m.ProviderConfigs, name)
+	delete(m.runtimeVirtuals, name)
+	m.ProvidersOrder = slices.DeleteFunc(slices.Clone(m.ProvidersOrder), func(n string) bool { return n == name })
+	m.Impl.Mu.Unlock()
+	plugin.UnregisterPlugin("virtual:" + name)
+	return nil
+}
@@ -0,0 +1,129 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// virtualsStore is a kv backend sharing one MemoryStore between opens.
+var virtualsStore = kv.NewMemoryStore(100, -1)
+
+func init() {
+	kv.RegisterBackend("virtuals-test", func(string) (kv.Store, error) { return virtualsStore, nil })
+}
+
+func TestRuntimeVirtuals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_ = virtualsStore.Set(ctx, "virtuals/rt-saved", `{"mappings": {"fast": "openai/gpt-4o-mini"}}`, 0)
+	_ = virtualsStore.Set(ctx, "virtuals/rt-broken", `{"mappings": {}}`, 0)
+	t.Cleanup(func() {
+		for _, name := range []string{"rt-saved", "rt-new"} {
+			plugin.UnregisterPlugin("virtual:" + name)
+		}
+	})
+
+	m, _ := newPolicyRouter(t)
+	m.Name = "virtuals-test"
+	m.ProvidersOrder = []string{"policy-alias", "openai"}
+	m.VirtualStore = &VirtualStore{Backend: "virtuals-test", Prefix: "virtuals/"}
+	if err := m.openVirtualStore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	RegisterRouter(m.Name, m)
+	if _, ok := m.Provider("rt-broken"); ok {
+		t.Error("an invalid stored provider was installed")
+	}
+	if p, _ := plugin.GetPlugin("virtual:rt-saved"); p == nil {
+		t.Fatal("stored provider not loaded")
+	}
+
+	var a AdminAPI
+	do := func(method, path, body string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		err := a.handleVirtual(w, httptest.NewRequest(method, path+"?router=virtuals-test", strings.NewReader(body)))
+		return w, err
+	}
+	status := func(err error) int {
+		var apiErr caddy.APIError
+		if errors.As(err, &apiErr) {
+			return apiErr.HTTPStatus
+		}
+		return http.StatusOK
+	}
+
+	if _, err := do(http.MethodPut, "/ai/virtual/rt-new", `{"mappings": {"bot": "openai/gpt-4.1 70 openai/gpt-4o 30"}, "sticky_targets": true}`); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := virtualsStore.Get(ctx, "virtuals/rt-new"); err != nil || !strings.Contains(saved, `"bot"`) {
+		t.Errorf("saved = %q, %v", saved, err)
+	}
+	if got := m.Providers(); got[len(got)-1] != "rt-new" {
+		t.Errorf("providers = %v", got)
+	}
+
+	w, err := do(http.MethodGet, "/ai/virtual", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list map[string]VirtualInfo
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if !list["rt-new"].Runtime || !list["rt-new"].StickyTargets || list["policy-alias"].Runtime || list["rt-saved"].Mappings["fast"] != "openai/gpt-4o-mini" {
+		t.Errorf("list = %+v", list)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/ai/virtual/policy-alias", `{"mappings": {"x": "openai/y"}}`, http.StatusConflict},
+		{http.MethodPut, "/ai/virtual/rt-bad", `{"mappings": {"x": "openai/y 0"}}`, http.StatusBadRequest},
+		{http.MethodPut, "/ai/virtual/rt-bad", `{"mapings": {"x": "openai/y"}}`, http.StatusBadRequest},
+		{http.MethodDelete, "/ai/virtual/openai", "", http.StatusConflict},
+		{http.MethodDelete, "/ai/virtual/rt-missing", "", http.StatusNotFound},
+		{http.MethodGet, "/ai/virtual/rt-missing", "", http.StatusNotFound},
+		{http.MethodPost, "/ai/virtual", "", http.StatusMethodNotAllowed},
+	} {
+		if _, err := do(tt.method, tt.path, tt.body); status(err) != tt.want {
+			t.Errorf("%s %s: %v, want status %d", tt.method, tt.path, err, tt.want)
+		}
+	}
+
+	// Runtime providers are managed only through the admin API.
+	pol, _ := ParsePolicy([]byte(`{"virtual": {"rt-new": {"x": "openai/y"}}}`))
+	if err := m.ApplyPolicy(pol); err == nil {
+		t.Error("policy changed a runtime virtual provider")
+	}
+	if _, ok := m.CurrentPolicy().Virtual["rt-new"]; ok {
+		t.Error("runtime virtual provider listed in the policy")
+	}
+
+	if _, err := do(http.MethodDelete, "/ai/virtual/rt-new", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Provider("rt-new"); ok {
+		t.Error("deleted provider still configured")
+	}
+	if p, _ := plugin.GetPlugin("virtual:rt-new"); p != nil {
+		t.Error("deleted provider still rewrites models")
+	}
+	if _, err := virtualsStore.Get(ctx, "virtuals/rt-new"); !errors.Is(err, kv.ErrNotFound) {
+		t.Errorf("deleted provider still saved: %v", err)
+	}
+
+	bare := &RouterModule{Name: "virtuals-bare"}
+	if err := bare.PutVirtual(ctx, "x", &RuntimeVirtual{Mappings: map[string]string{"a": "b/c"}}); !errors.Is(err, errNoVirtualStore) {
+		t.Errorf("put without virtual_store: %v", err)
+	}
+}
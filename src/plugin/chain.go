@@ -1,7 +1,14 @@
 package plugin
 
 import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/neutrome-labs/ail"
 	"github.com/neutrome-labs/open-ai-router/src/services"
@@ -11,6 +18,19 @@ import (
 // PluginChain manages the execution of plugins
 type PluginChain struct {
 	plugins []PluginInstance
+
+	mu    sync.Mutex
+	stats map[int]*InstanceStats // by instance index, for this request
+}
+
+// InstanceStats is what a plugin instance cost one request.
+type InstanceStats struct {
+	Duration   time.Duration // across all hooks run
+	Errors     int
+	FailedOpen bool
+	// Modified is set once a hook leaves the program different from the
+	// one it was given, whether it edited it in place or returned another.
+	Modified bool
 }
 
 // NewPluginChain creates a new plugin chain
@@ -20,22 +40,84 @@ func NewPluginChain() *PluginChain {
 	}
 }
 
-// Add adds a plugin to the chain
+// Add adds a plugin to the chain, after the plugins of the same or lower
+// priority (see OrderedPlugin)
 func (c *PluginChain) Add(p Plugin, params string) {
-	c.plugins = append(c.plugins, PluginInstance{Plugin: p, Params: params})
+	c.insert(PluginInstance{Plugin: p, Params: params})
+}
+
+func (c *PluginChain) insert(pi PluginInstance) {
+	pi.FailOpen = FailsOpen(pi.Plugin)
+	prio := PriorityOf(pi.Plugin)
+	i := len(c.plugins)
+	for i > 0 && PriorityOf(c.plugins[i-1].Plugin) > prio {
+		i--
+	}
+	c.plugins = slices.Insert(c.plugins, i, pi)
+}
+
+// Select returns the chain of the plugins whose matchers r, requesting
+// prog, meets.
+func (c *PluginChain) Select(r *http.Request, prog *ail.Program) *PluginChain {
+	out := &PluginChain{plugins: make([]PluginInstance, 0, len(c.plugins))}
+	for _, pi := range c.plugins {
+		if pi.When.Match(r, prog) {
+			out.plugins = append(out.plugins, pi)
+		}
+	}
+	return out
+}
+
+// Check verifies the dependencies DependentPlugins declare against the
+// chain, returning the first one unmet.
+func (c *PluginChain) Check() error {
+	has := make(map[string]bool, len(c.plugins))
+	for _, pi := range c.plugins {
+		has[pi.Plugin.Name()] = true
+	}
+	for _, pi := range c.plugins {
+		dp, ok := pi.Plugin.(DependentPlugin)
+		if !ok {
+			continue
+		}
+		name := pi.Plugin.Name()
+		deps, err := dp.Dependencies(pi.Params)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, req := range deps.Requires {
+			if !has[req] {
+				return fmt.Errorf("%s requires the %s plugin", name, req)
+			}
+		}
+		for _, con := range deps.Conflicts {
+			if has[con] && con != name {
+				return fmt.Errorf("%s conflicts with %s", name, con)
+			}
+		}
+	}
+	return nil
 }
 
 // RunBefore executes all BeforePlugin implementations
 func (c *PluginChain) RunBefore(p *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
 	Logger.Debug("RunBefore starting", zap.Int("plugin_count", len(c.plugins)))
 	current := prog
-	for _, pi := range c.plugins {
+	for i, pi := range c.plugins {
 		if bp, ok := pi.Plugin.(BeforePlugin); ok {
 			Logger.Debug("Running Before plugin", zap.String("plugin", pi.Plugin.Name()), zap.String("params", pi.Params))
+			digest := programDigest(current)
+			start := time.Now()
 			next, err := bp.Before(pi.Params, p, r, current)
+			c.observe(i, pi, "before", start)
 			if err != nil {
-				Logger.Error("Before plugin failed", zap.String("plugin", pi.Plugin.Name()), zap.Error(err))
-				return nil, err
+				if err = c.fail(i, pi, "before", err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if programDigest(next) != digest {
+				c.markModified(i)
 			}
 			current = next
 		}
@@ -48,13 +130,21 @@ func (c *PluginChain) RunBefore(p *services.ProviderService, r *http.Request, pr
 func (c *PluginChain) RunAfter(p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, resProg *ail.Program) (*ail.Program, error) {
 	Logger.Debug("RunAfter starting", zap.Int("plugin_count", len(c.plugins)))
 	current := resProg
-	for _, pi := range c.plugins {
+	for i, pi := range c.plugins {
 		if ap, ok := pi.Plugin.(AfterPlugin); ok {
 			Logger.Debug("Running After plugin", zap.String("plugin", pi.Plugin.Name()), zap.String("params", pi.Params))
+			digest := programDigest(current)
+			start := time.Now()
 			next, err := ap.After(pi.Params, p, r, reqProg, res, current)
+			c.observe(i, pi, "after", start)
 			if err != nil {
-				Logger.Error("After plugin failed", zap.String("plugin", pi.Plugin.Name()), zap.Error(err))
-				return nil, err
+				if err = c.fail(i, pi, "after", err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if programDigest(next) != digest {
+				c.markModified(i)
 			}
 			current = next
 		}
@@ -66,12 +156,20 @@ func (c *PluginChain) RunAfter(p *services.ProviderService, r *http.Request, req
 // RunAfterChunk executes all StreamChunkPlugin implementations
 func (c *PluginChain) RunAfterChunk(p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, chunk *ail.Program) (*ail.Program, error) {
 	current := chunk
-	for _, pi := range c.plugins {
+	for i, pi := range c.plugins {
 		if sp, ok := pi.Plugin.(StreamChunkPlugin); ok {
+			digest := programDigest(current)
+			start := time.Now()
 			next, err := sp.AfterChunk(pi.Params, p, r, reqProg, res, current)
+			c.observe(i, pi, "after_chunk", start)
 			if err != nil {
-				Logger.Error("AfterChunk plugin failed", zap.String("plugin", pi.Plugin.Name()), zap.Error(err))
-				return nil, err
+				if err = c.fail(i, pi, "after_chunk", err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if programDigest(next) != digest {
+				c.markModified(i)
 			}
 			current = next
 		}
@@ -79,15 +177,34 @@ func (c *PluginChain) RunAfterChunk(p *services.ProviderService, r *http.Request
 	return current, nil
 }
 
-// RunStreamEnd executes all StreamEndPlugin implementations
-func (c *PluginChain) RunStreamEnd(p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, lastChunk *ail.Program) error {
-	Logger.Debug("RunStreamEnd starting", zap.Int("plugin_count", len(c.plugins)))
+// HasStreamChunkPlugins reports whether any plugin of the chain sees
+// stream chunks; without one, RunAfterChunk returns chunks unchanged.
+func (c *PluginChain) HasStreamChunkPlugins() bool {
+	if c == nil {
+		return false
+	}
 	for _, pi := range c.plugins {
+		if _, ok := pi.Plugin.(StreamChunkPlugin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RunStreamEnd executes all StreamEndPlugin implementations with the
+// assembled response
+func (c *PluginChain) RunStreamEnd(p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, assembled *ail.Program) error {
+	Logger.Debug("RunStreamEnd starting", zap.Int("plugin_count", len(c.plugins)))
+	for i, pi := range c.plugins {
 		if sep, ok := pi.Plugin.(StreamEndPlugin); ok {
 			Logger.Debug("Running StreamEnd plugin", zap.String("plugin", pi.Plugin.Name()), zap.String("params", pi.Params))
-			if err := sep.StreamEnd(pi.Params, p, r, reqProg, res, lastChunk); err != nil {
-				Logger.Error("StreamEnd plugin failed", zap.String("plugin", pi.Plugin.Name()), zap.Error(err))
-				return err
+			start := time.Now()
+			err := sep.StreamEnd(pi.Params, p, r, reqProg, res, assembled)
+			c.observe(i, pi, "stream_end", start)
+			if err != nil {
+				if err = c.fail(i, pi, "stream_end", err); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -95,14 +212,142 @@ func (c *PluginChain) RunStreamEnd(p *services.ProviderService, r *http.Request,
 	return nil
 }
 
+// observe records the run of the i-th instance's hook that began at start.
+func (c *PluginChain) observe(i int, pi PluginInstance, hook string, start time.Time) {
+	services.ObservePlugin(pi.Plugin.Name(), hook, start)
+	c.mu.Lock()
+	c.stat(i).Duration += time.Since(start)
+	c.mu.Unlock()
+}
+
+// programDigest is a cheap hash of prog's code and buffers, telling
+// whether a hook changed it.
+func programDigest(prog *ail.Program) uint64 {
+	if prog == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	_ = prog.Encode(h)
+	return h.Sum64()
+}
+
+// markModified records that the i-th instance changed the program.
+func (c *PluginChain) markModified(i int) {
+	c.mu.Lock()
+	c.stat(i).Modified = true
+	c.mu.Unlock()
+}
+
+// stat returns the i-th instance's stats; c.mu must be held.
+func (c *PluginChain) stat(i int) *InstanceStats {
+	if c.stats == nil {
+		c.stats = make(map[int]*InstanceStats)
+	}
+	st, ok := c.stats[i]
+	if !ok {
+		st = &InstanceStats{}
+		c.stats[i] = st
+	}
+	return st
+}
+
+// fail applies the failure policy of the i-th instance, pi, whose hook
+// failed with err. It returns the error to fail the request with, or nil
+// when the instance fails open and the chain carries on.
+func (c *PluginChain) fail(i int, pi PluginInstance, hook string, err error) error {
+	var re *services.RouterError
+	open := pi.FailOpen && !errors.As(err, &re)
+	c.mu.Lock()
+	st := c.stat(i)
+	st.Errors++
+	st.FailedOpen = st.FailedOpen || open
+	c.mu.Unlock()
+	if !open {
+		services.ObservePluginError(pi.Plugin.Name(), hook, "closed")
+		Logger.Error("Plugin failed", zap.String("plugin", pi.Plugin.Name()),
+			zap.String("hook", hook), zap.Error(err))
+		return err
+	}
+	services.ObservePluginError(pi.Plugin.Name(), hook, "open")
+	Logger.Warn("Plugin failed open, continuing without it", zap.String("plugin", pi.Plugin.Name()),
+		zap.String("hook", hook), zap.Error(err))
+	return nil
+}
+
+// FailedOpen reports whether the i-th plugin of GetPlugins has failed open
+// for this request.
+func (c *PluginChain) FailedOpen(i int) bool {
+	return c.Stats(i).FailedOpen
+}
+
+// Stats returns what the i-th plugin of GetPlugins has cost this request
+// so far.
+func (c *PluginChain) Stats(i int) InstanceStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.stats[i]; ok {
+		return *st
+	}
+	return InstanceStats{}
+}
+
+// RunTeeStream collects the stream copies StreamTeePlugins ask for and
+// returns a writer feeding all of them, or nil when none does.
+func (c *PluginChain) RunTeeStream(p *services.ProviderService, r *http.Request) io.WriteCloser {
+	var tees teeWriters
+	for i, pi := range c.plugins {
+		if tp, ok := pi.Plugin.(StreamTeePlugin); ok {
+			start := time.Now()
+			w := tp.TeeStream(pi.Params, p, r)
+			c.observe(i, pi, "tee_stream", start)
+			if w != nil {
+				tees = append(tees, w)
+			}
+		}
+	}
+	switch len(tees) {
+	case 0:
+		return nil
+	case 1:
+		return tees[0]
+	}
+	return tees
+}
+
+// teeWriters copies every write to all of its writers; one failing does
+// not stop the others.
+type teeWriters []io.WriteCloser
+
+func (t teeWriters) Write(b []byte) (int, error) {
+	for _, w := range t {
+		_, _ = w.Write(b)
+	}
+	return len(b), nil
+}
+
+func (t teeWriters) Close() error {
+	for _, w := range t {
+		_ = w.Close()
+	}
+	return nil
+}
+
 // RunError executes all ErrorPlugin implementations
 func (c *PluginChain) RunError(p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, providerErr error) error {
 	Logger.Debug("RunError starting", zap.Int("plugin_count", len(c.plugins)), zap.Error(providerErr))
-	for _, pi := range c.plugins {
+	for i, pi := range c.plugins {
 		if ep, ok := pi.Plugin.(ErrorPlugin); ok {
 			Logger.Debug("Running Error plugin", zap.String("plugin", pi.Plugin.Name()), zap.String("params", pi.Params))
-			if err := ep.OnError(pi.Params, p, r, reqProg, res, providerErr); err != nil {
+			start := time.Now()
+			err := ep.OnError(pi.Params, p, r, reqProg, res, providerErr)
+			c.observe(i, pi, "error", start)
+			if err != nil {
+				// The request has failed already; there is nothing to abort.
 				Logger.Error("Error plugin failed", zap.String("plugin", pi.Plugin.Name()), zap.Error(err))
+				services.ObservePluginError(pi.Plugin.Name(), "error", "open")
+				c.mu.Lock()
+				c.stat(i).Errors++
+				c.mu.Unlock()
 			}
 		}
 	}
@@ -112,11 +357,18 @@ func (c *PluginChain) RunError(p *services.ProviderService, r *http.Request, req
 
 // RunModelRewrite iterates ModelRewritePlugins and returns the first
 // successful rewrite along with the name of the plugin that matched.
-// Returns the original model and an empty name if nothing matched.
-func (c *PluginChain) RunModelRewrite(model string) (string, string) {
+// Returns the original model and an empty name if nothing matched. key is
+// the request's conversation key, passed to KeyedModelRewritePlugins.
+func (c *PluginChain) RunModelRewrite(model, key string) (string, string) {
 	for _, pi := range c.plugins {
 		if mr, ok := pi.Plugin.(ModelRewritePlugin); ok {
-			if rewritten, matched := mr.RewriteModel(model); matched {
+			rewritten, matched := "", false
+			if kr, ok := mr.(KeyedModelRewritePlugin); ok {
+				rewritten, matched = kr.RewriteModelKeyed(model, key)
+			} else {
+				rewritten, matched = mr.RewriteModel(model)
+			}
+			if matched {
 				Logger.Debug("ModelRewrite matched",
 					zap.String("plugin", pi.Plugin.Name()),
 					zap.String("from", model),
@@ -131,10 +383,12 @@ func (c *PluginChain) RunModelRewrite(model string) (string, string) {
 // RunRequestInit executes all RequestInitPlugin implementations.
 // Called once per request after parsing and plugin resolution, before provider iteration.
 func (c *PluginChain) RunRequestInit(r *http.Request, prog *ail.Program) {
-	for _, pi := range c.plugins {
+	for i, pi := range c.plugins {
 		if rip, ok := pi.Plugin.(RequestInitPlugin); ok {
 			Logger.Debug("Running RequestInit plugin", zap.String("plugin", pi.Plugin.Name()))
+			start := time.Now()
 			rip.OnRequestInit(r, prog)
+			c.observe(i, pi, "request_init", start)
 		}
 	}
 }
@@ -3,10 +3,11 @@
 package plugin
 
 import (
-	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/neutrome-labs/ail"
 	"github.com/neutrome-labs/open-ai-router/src/services"
@@ -49,23 +50,39 @@ type InferenceContext struct {
 }
 
 // Capture runs inference, captures the raw response, and parses it to AIL.
-// Returns both the parsed program and the raw capture (for replay).
+// Returns both the parsed program and the raw capture (for replay). Long
+// responses spill to disk; see services.NewResponseCapture.
+// Once the client has disconnected it returns services.ErrClientClosed
+// instead, so tool loops and chains stop issuing upstream calls.
 func (ic *InferenceContext) Capture(prog *ail.Program, r *http.Request) (*ail.Program, *services.ResponseCaptureWriter, error) {
-	cap := &services.ResponseCaptureWriter{}
+	cap := services.NewResponseCapture()
+	if r.Context().Err() != nil {
+		return nil, cap, services.ErrClientClosed
+	}
 	if err := ic.Infer(prog, cap, r); err != nil {
 		return nil, cap, err
 	}
+	if r.Context().Err() != nil {
+		return nil, cap, services.ErrClientClosed
+	}
 	parsed, err := ic.ParseCapture(cap)
 	return parsed, cap, err
 }
 
 // CaptureFresh runs inference through the full handler (fresh plugin resolution)
-// and captures + parses the response. Use when the model changed.
+// and captures + parses the response. Use when the model changed. Like
+// Capture, it returns services.ErrClientClosed once the client is gone.
 func (ic *InferenceContext) CaptureFresh(prog *ail.Program, r *http.Request) (*ail.Program, *services.ResponseCaptureWriter, error) {
-	cap := &services.ResponseCaptureWriter{}
+	cap := services.NewResponseCapture()
+	if r.Context().Err() != nil {
+		return nil, cap, services.ErrClientClosed
+	}
 	if err := ic.InferFresh(prog, cap, r); err != nil {
 		return nil, cap, err
 	}
+	if r.Context().Err() != nil {
+		return nil, cap, services.ErrClientClosed
+	}
 	parsed, err := ic.ParseCapture(cap)
 	return parsed, cap, err
 }
@@ -78,16 +95,17 @@ func ReplayCapture(capture *services.ResponseCaptureWriter, w http.ResponseWrite
 			w.Header().Add(k, v)
 		}
 	}
-	w.Write(capture.Response)
+	capture.WriteTo(w)
 }
 
 // ParseCapturedResponse parses raw captured response bytes into an AIL program.
 // Auto-detects SSE (text/event-stream) vs non-streaming by inspecting the
 // Content-Type header. For SSE, each event is parsed with the StreamChunkParser
-// and then reassembled into a full-message program (STREAM_* → MSG/CALL/TXT).
+// as it is read and then reassembled into a full-message program
+// (STREAM_* → MSG/CALL/TXT), so a spilled stream is never read back whole.
 // Used by endpoint modules to build the ParseCapture closure for InferenceContext.
 func ParseCapturedResponse(capture *services.ResponseCaptureWriter, respParser ResponseParser, streamParser ail.StreamChunkParser) (*ail.Program, error) {
-	if len(capture.Response) == 0 {
+	if capture.Len() == 0 {
 		return ail.NewProgram(), nil
 	}
 	ct := ""
@@ -95,18 +113,22 @@ func ParseCapturedResponse(capture *services.ResponseCaptureWriter, respParser R
 		ct = capture.Headers.Get("Content-Type")
 	}
 	if strings.HasPrefix(ct, "text/event-stream") {
-		return parseSSECapture(capture.Response, streamParser)
+		return parseSSECapture(capture.Reader(), streamParser)
+	}
+	body, err := capture.Bytes()
+	if err != nil {
+		return nil, err
 	}
-	return respParser.ParseResponse(capture.Response)
+	return respParser.ParseResponse(body)
 }
 
 // parseSSECapture reads captured SSE bytes, parses each chunk with StreamChunkParser,
 // and reassembles the accumulated streaming opcodes into a full-message program.
-func parseSSECapture(data []byte, parser ail.StreamChunkParser) (*ail.Program, error) {
-	reader := sse.NewDefaultReader(bytes.NewReader(data))
+func parseSSECapture(data io.Reader, parser ail.StreamChunkParser) (*ail.Program, error) {
+	reader := sse.NewDefaultReader(data)
 	events := reader.ReadEvents()
 
-	result := ail.NewProgram()
+	var chunks []*ail.Program
 	for ev := range events {
 		if ev.Done || ev.Error != nil {
 			break
@@ -119,24 +141,66 @@ func parseSSECapture(data []byte, parser ail.StreamChunkParser) (*ail.Program, e
 			// Skip unparseable chunks (e.g. heartbeats, metadata)
 			continue
 		}
-		result = result.Append(chunk)
+		chunks = append(chunks, chunk)
 	}
 	// Convert streaming opcodes (STREAM_DELTA, STREAM_TOOL_DELTA, etc.)
 	// into full message opcodes (TXT_CHUNK, CALL_START/CALL_END, etc.)
 	// so that ToolCalls() and Messages() work correctly on the result.
-	return ail.ReassembleStream(result), nil
+	return ail.ReassembleStream(ConcatChunks(chunks)), nil
+}
+
+// ConcatChunks concatenates the chunk programs of a stream into one, in
+// linear time: ail.Program.Append copies its whole receiver, which turns
+// a stream assembled chunk by chunk quadratic in its length. Nil chunks
+// are skipped. The result shares the chunks' strings, JSON and buffers,
+// so the chunks must not be modified afterwards.
+func ConcatChunks(chunks []*ail.Program) *ail.Program {
+	result := ail.NewProgram()
+	n := 0
+	for _, c := range chunks {
+		if c != nil {
+			n += len(c.Code)
+		}
+	}
+	result.Code = make([]ail.Instruction, 0, n)
+	for _, c := range chunks {
+		if c == nil {
+			continue
+		}
+		offset := uint32(len(result.Buffers))
+		for _, inst := range c.Code {
+			switch inst.Op {
+			case ail.IMG_REF, ail.AUD_REF, ail.TXT_REF, ail.THINK_REF:
+				inst.Ref += offset
+			}
+			result.Code = append(result.Code, inst)
+		}
+		result.Buffers = append(result.Buffers, c.Buffers...)
+	}
+	return result
 }
 
 // Logger for plugin chain - can be set by modules
 var Logger *zap.Logger = zap.NewNop()
 
+// RequestIDHeader carries the trace ID between clients, the router,
+// upstream providers and sidecars.
+const RequestIDHeader = "X-Request-Id"
+
+// ConversationIDHeader names the conversation a request belongs to, for
+// sticky routing and conversation-scoped plugin state.
+const ConversationIDHeader = "X-Conversation-Id"
+
 // Context keys
 type contextKey string
 
 const (
-	traceIDKey contextKey = "trace_id"
-	userIDKey  contextKey = "user_id"
-	keyIDKey   contextKey = "key_id"
+	traceIDKey  contextKey = "trace_id"
+	userIDKey   contextKey = "user_id"
+	keyIDKey    contextKey = "key_id"
+	priorityKey contextKey = "priority"
+	pluginsKey  contextKey = "plugins"
+	keyTagsKey  contextKey = "key_tags"
 )
 
 // ContextTraceID returns the trace ID context key
@@ -148,12 +212,63 @@ func ContextUserID() contextKey { return userIDKey }
 // ContextKeyID returns the key ID context key
 func ContextKeyID() contextKey { return keyIDKey }
 
+// ContextKeyTags returns the context key of the authenticated key's tags
+// ([]string), set by auth services that know them; plugin matchers test
+// them with tag=<glob>
+func ContextKeyTags() contextKey { return keyTagsKey }
+
+// ContextPriority returns the request priority (services.Priority) context key
+func ContextPriority() contextKey { return priorityKey }
+
+// ContextPlugins returns the context key of the request's resolved plugin
+// names ([]string, "name" or "name:params")
+func ContextPlugins() contextKey { return pluginsKey }
+
 // Plugin is the base interface for all plugins
 type Plugin interface {
 	// Name returns the plugin's identifier
 	Name() string
 }
 
+// OrderedPlugin declares where a plugin runs in the chain. Lower
+// priorities run first in every phase; plugins that don't declare one have
+// PriorityDefault, and plugins of equal priority run in the order they were
+// added. A priority set with SetPriorities overrides the declared one.
+type OrderedPlugin interface {
+	Plugin
+	Priority() int
+}
+
+// FailOpenPlugin declares a plugin non-critical: when one of its hooks
+// fails, the failure is logged and the chain carries on without the hook's
+// change instead of failing the provider attempt. Plugins that don't
+// declare it fail closed. A policy set with SetFailurePolicies overrides
+// the declared one; a RouterError (a guard's deliberate rejection) always
+// fails closed.
+type FailOpenPlugin interface {
+	Plugin
+	FailOpen() bool
+}
+
+// DependentPlugin declares what a plugin needs to work. A request's chain
+// is checked once its matchers are applied (see PluginChain.Check), so
+// plugins that cannot work together fail the request with a clear error
+// instead of misbehaving.
+type DependentPlugin interface {
+	Plugin
+	// Dependencies returns the plugins an instance with params requires
+	// and conflicts with in the same chain. An error means something the
+	// instance needs outside the chain, a kv backend say, is missing.
+	Dependencies(params string) (Dependencies, error)
+}
+
+// Dependencies lists plugins, by name, that a DependentPlugin requires or
+// conflicts with.
+type Dependencies struct {
+	Requires  []string
+	Conflicts []string
+}
+
 // ModelRewritePlugin can rewrite the model name before plugin resolution.
 // Used by virtual providers for model aliasing. Runs in a loop until the
 // model stabilises, so chained virtual→virtual mappings work naturally.
@@ -164,6 +279,22 @@ type ModelRewritePlugin interface {
 	RewriteModel(model string) (rewritten string, matched bool)
 }
 
+// KeyedModelRewritePlugin is a ModelRewritePlugin whose rewrite may depend
+// on the request's conversation key, to keep a conversation on one of
+// several weighted targets say. RunModelRewrite prefers it when present.
+type KeyedModelRewritePlugin interface {
+	ModelRewritePlugin
+	RewriteModelKeyed(model, key string) (rewritten string, matched bool)
+}
+
+// ParamsModelRewritePlugin is a ModelRewritePlugin whose models can carry
+// generation parameters, as a virtual model preset does. ApplyParams sets
+// those of model, a model it rewrote, on prog in place.
+type ParamsModelRewritePlugin interface {
+	ModelRewritePlugin
+	ApplyParams(model string, prog *ail.Program)
+}
+
 // RequestInitPlugin is called once per request after the initial AIL program
 // is parsed and plugin resolution is complete, but before provider iteration.
 // Ideal for sampling and observability hooks that need the pre-plugin state.
@@ -200,8 +331,21 @@ type StreamChunkPlugin interface {
 // StreamEndPlugin handles stream completion.
 type StreamEndPlugin interface {
 	Plugin
-	// StreamEnd is called when the stream completes.
-	StreamEnd(params string, p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, lastChunk *ail.Program) error
+	// StreamEnd is called when the stream completes, or is cut short by the
+	// client. assembled is the whole response as sent to the client: every
+	// chunk after the StreamChunkPlugins, in order, including the usage
+	// chunk the router adds when the upstream sent none.
+	StreamEnd(params string, p *services.ProviderService, r *http.Request, reqProg *ail.Program, res *http.Response, assembled *ail.Program) error
+}
+
+// StreamTeePlugin receives a copy of an SSE stream as it is sent to the
+// client, for archiving streams without holding them in memory.
+type StreamTeePlugin interface {
+	Plugin
+	// TeeStream returns a writer for the stream's bytes, or nil to skip
+	// this stream. Write must not block the client for long; Close is
+	// called once the stream has ended.
+	TeeStream(params string, p *services.ProviderService, r *http.Request) io.WriteCloser
 }
 
 // ErrorPlugin handles errors from provider calls.
@@ -243,6 +387,11 @@ var ProviderLister func() []*services.ProviderService
 type PluginInstance struct {
 	Plugin Plugin
 	Params string
+	When   Matcher // nil runs for every request
+
+	// FailOpen lets the request through when the instance fails; see
+	// FailOpenPlugin.
+	FailOpen bool
 }
 
 // ─── Client style context ───────────────────────────────────────────────────
@@ -289,3 +438,22 @@ func SamplerStepFromContext(ctx context.Context) (SamplerStep, bool) {
 	v, ok := ctx.Value(samplerStepCtxKey{}).(SamplerStep)
 	return v, ok
 }
+
+// ─── SSE keepalive context ──────────────────────────────────────────────────
+
+// sseKeepaliveCtxKey carries the router's SSE keepalive interval so every
+// code path that opens a stream (endpoint modules, DSPy, …) uses the same
+// setting.
+type sseKeepaliveCtxKey struct{}
+
+// WithSSEKeepalive returns a context with the SSE keepalive interval.
+func WithSSEKeepalive(ctx context.Context, interval time.Duration) context.Context {
+	return context.WithValue(ctx, sseKeepaliveCtxKey{}, interval)
+}
+
+// SSEKeepaliveFromContext extracts the SSE keepalive interval; 0 (no
+// keepalives) when unset.
+func SSEKeepaliveFromContext(ctx context.Context) time.Duration {
+	v, _ := ctx.Value(sseKeepaliveCtxKey{}).(time.Duration)
+	return v
+}
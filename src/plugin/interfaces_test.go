@@ -0,0 +1,71 @@
+package plugin_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestConcatChunks(t *testing.T) {
+	var chunks []*ail.Program
+	for _, text := range []string{"one", "two"} {
+		c := ail.NewProgram()
+		c.EmitString(ail.STREAM_DELTA, text)
+		c.EmitRef(ail.TXT_REF, c.AddBuffer([]byte(text+" buffer")))
+		chunks = append(chunks, c, nil)
+	}
+
+	got := plugin.ConcatChunks(chunks)
+	want := chunks[0].Append(chunks[2])
+	if got.Disasm() != want.Disasm() {
+		t.Errorf("ConcatChunks =\n%s\nwant\n%s", got.Disasm(), want.Disasm())
+	}
+	if ref := got.Code[3].Ref; string(got.Buffers[ref]) != "two buffer" {
+		t.Errorf("second chunk's ref points at %q", got.Buffers[ref])
+	}
+	if plugin.ConcatChunks(nil).Len() != 0 {
+		t.Error("no chunks should concatenate to an empty program")
+	}
+}
+
+func TestParseCapturedResponse_SpilledStream(t *testing.T) {
+	cap := &services.ResponseCaptureWriter{SpillThreshold: 256, SpillDir: t.TempDir()}
+	defer cap.Close()
+	cap.Header().Set("Content-Type", "text/event-stream")
+	var want strings.Builder
+	for i := range 50 {
+		fmt.Fprintf(cap, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"w%d \"}}]}\n\n", i)
+		fmt.Fprintf(&want, "w%d ", i)
+	}
+	fmt.Fprint(cap, "data: [DONE]\n\n")
+	if !cap.Spilled() {
+		t.Fatal("capture did not spill")
+	}
+
+	parser, _ := ail.GetStreamChunkParser(ail.StyleChatCompletions)
+	respParser, _ := ail.GetResponseParser(ail.StyleChatCompletions)
+	prog, err := plugin.ParseCapturedResponse(cap, respParser, parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for _, inst := range prog.Code {
+		if inst.Op == ail.TXT_CHUNK {
+			text.WriteString(inst.Str)
+		}
+	}
+	if text.String() != want.String() {
+		t.Errorf("parsed text = %q, want %q", text.String(), want.String())
+	}
+
+	rec := httptest.NewRecorder()
+	plugin.ReplayCapture(cap, rec)
+	if int64(rec.Body.Len()) != cap.Len() || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("replayed %d bytes of %d, headers %v", rec.Body.Len(), cap.Len(), rec.Header())
+	}
+}
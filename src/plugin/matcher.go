@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Matcher restricts a plugin instance to the requests meeting all of its
+// conditions. It follows the plugin's params after an "@":
+//
+//	guard@tag=external
+//	slwin:20@model=gpt-4*,stream=true
+//	redact@!key=internal-*,header=X-Tenant
+//
+// Conditions are field=value, joined by commas; a leading "!" negates one.
+//
+//	model=<glob>            the requested model, with or without its provider prefix
+//	key=<glob>              the authenticated key ID
+//	tag=<glob>              any tag of the authenticated key (see ContextKeyTags)
+//	header=<name>[:<glob>]  the header is present (and a value matches)
+//	stream=<true|false>     whether the request streams
+//
+// Matchers are evaluated once per request, after plugin resolution, so an
+// instance runs in every phase or in none.
+type Matcher []Condition
+
+// Condition is one test of a Matcher.
+type Condition struct {
+	Field  string
+	Value  string
+	Negate bool
+}
+
+// ParseMatcher parses the comma-separated conditions of a matcher.
+func ParseMatcher(s string) (Matcher, error) {
+	var m Matcher
+	for _, part := range strings.Split(s, ",") {
+		var c Condition
+		part, c.Negate = strings.CutPrefix(strings.TrimSpace(part), "!")
+		field, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("condition %q must be field=value", part)
+		}
+		c.Field, c.Value = field, value
+		pattern := value
+		switch field {
+		case "model", "key", "tag":
+		case "header":
+			_, pattern, _ = strings.Cut(value, ":")
+		case "stream":
+			if value != "true" && value != "false" {
+				return nil, fmt.Errorf("condition %q: stream must be true or false", part)
+			}
+		default:
+			return nil, fmt.Errorf("unknown condition field %q (want model, key, tag, header or stream)", field)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("condition %q: bad pattern: %v", part, err)
+		}
+		m = append(m, c)
+	}
+	return m, nil
+}
+
+// splitMatcher separates a plugin spec from its matcher. Only a suffix
+// after the last "@" that holds conditions is a matcher, since params
+// (a chain step's prompt, say) may contain "@" themselves.
+func splitMatcher(spec string) (string, Matcher, error) {
+	i := strings.LastIndexByte(spec, '@')
+	if i < 0 || !strings.Contains(spec[i+1:], "=") {
+		return spec, nil, nil
+	}
+	m, err := ParseMatcher(spec[i+1:])
+	return spec[:i], m, err
+}
+
+// ValidateMatchers checks the matchers of plugin specs. Unlike
+// ValidateSpecs it does not need the plugins or presets registered yet.
+func ValidateMatchers(specs []string) error {
+	for _, spec := range specs {
+		if _, _, err := splitMatcher(spec); err != nil {
+			return fmt.Errorf("%s: %v", spec, err)
+		}
+	}
+	return nil
+}
+
+// String formats the matcher as it is written after "@".
+func (m Matcher) String() string {
+	parts := make([]string, len(m))
+	for i, c := range m {
+		parts[i] = c.Field + "=" + c.Value
+		if c.Negate {
+			parts[i] = "!" + parts[i]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Match reports whether r, requesting prog, meets every condition.
+func (m Matcher) Match(r *http.Request, prog *ail.Program) bool {
+	for _, c := range m {
+		if c.match(r, prog) == c.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) match(r *http.Request, prog *ail.Program) bool {
+	switch c.Field {
+	case "model":
+		model, _, _ := strings.Cut(prog.GetModel(), "+")
+		_, bare, _ := strings.Cut(model, "/")
+		return globMatch(c.Value, model) || (bare != "" && globMatch(c.Value, bare))
+	case "key":
+		keyID, _ := r.Context().Value(ContextKeyID()).(string)
+		return globMatch(c.Value, keyID)
+	case "tag":
+		tags, _ := r.Context().Value(ContextKeyTags()).([]string)
+		for _, tag := range tags {
+			if globMatch(c.Value, tag) {
+				return true
+			}
+		}
+		return false
+	case "header":
+		name, pattern, hasPattern := strings.Cut(c.Value, ":")
+		values := r.Header.Values(name)
+		if !hasPattern {
+			return len(values) > 0
+		}
+		for _, v := range values {
+			if globMatch(pattern, v) {
+				return true
+			}
+		}
+		return false
+	case "stream":
+		return prog.IsStreaming() == (c.Value == "true")
+	}
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
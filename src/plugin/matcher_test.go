@@ -0,0 +1,92 @@
+package plugin_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+func TestMatcher(t *testing.T) {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "openai/gpt-4o+slwin")
+	prog.Emit(ail.SET_STREAM)
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	ctx := context.WithValue(r.Context(), plugin.ContextKeyID(), "ext-42")
+	ctx = context.WithValue(ctx, plugin.ContextKeyTags(), []string{"external", "tier:gold"})
+	r = r.WithContext(ctx)
+
+	tests := map[string]bool{
+		"model=gpt-4o":              true,
+		"model=openai/gpt-4*":       true,
+		"model=claude-*":            false,
+		"key=ext-*":                 true,
+		"!key=ext-*":                false,
+		"tag=external":              true,
+		"tag=tier:*":                true,
+		"!tag=internal":             true,
+		"header=X-Tenant":           true,
+		"header=X-Tenant:ac*":       true,
+		"header=X-Tenant:other":     false,
+		"header=X-Missing":          false,
+		"stream=true":               true,
+		"stream=false":              false,
+		"tag=external,stream=false": false,
+		"tag=external,model=gpt-4o": true,
+	}
+	for spec, want := range tests {
+		m, err := plugin.ParseMatcher(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if got := m.Match(r, prog); got != want {
+			t.Errorf("%s: got %v, want %v", spec, got, want)
+		}
+	}
+
+	for _, spec := range []string{"model", "model=", "color=red", "stream=maybe", "model=[a"} {
+		if _, err := plugin.ParseMatcher(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestPluginChain_Select(t *testing.T) {
+	plugin.RegisterPreset("test-guarded", []string{"slwin:20", "kvtools@stream=true"})
+	defer plugin.UnregisterPreset("test-guarded")
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "gpt-4o")
+	r := httptest.NewRequest("POST", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextKeyTags(), []string{"external"}))
+
+	chain := plugin.TryResolvePlugins(url.URL{}, "gpt-4o+fuzz:mail me@example.com", []string{
+		"preset:test-guarded@tag=external",
+		"fuzz:internal@!tag=external",
+		"fuzz:bad@colour=red",
+	})
+	var got []string
+	for _, pi := range chain.Select(r, prog).GetPlugins() {
+		if name := pi.Plugin.Name(); name != "tiktoken" && name != "usage" && name != "inspect" {
+			got = append(got, name+":"+pi.Params)
+		}
+	}
+	// kvtools needs a stream as well as the preset's tag; "@example.com"
+	// holds no conditions, so it stays in the params.
+	if want := "slwin:20 fuzz:mail me@example.com"; strings.Join(got, " ") != want {
+		t.Errorf("plugins = %q, want %q", strings.Join(got, " "), want)
+	}
+
+	if err := plugin.ValidateMatchers([]string{"guard@tag=x", "chain:a@b"}); err != nil {
+		t.Error(err)
+	}
+	if err := plugin.ValidateMatchers([]string{"guard@tags=x"}); err == nil {
+		t.Error("bad matcher accepted")
+	}
+}
@@ -2,7 +2,12 @@ package plugin
 
 import (
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
 )
 
 // HeadPlugins are plugins that are always executed before others.
@@ -13,11 +18,70 @@ var TailPlugins = [][2]string{
 	{"tiktoken", ""},
 }
 
-func TryResolvePlugins(url url.URL, model string) *PluginChain {
+// maxPresetDepth bounds presets referencing presets, so a cycle cannot
+// recurse forever.
+const maxPresetDepth = 8
+
+// maxCachedChains bounds the resolved chains kept; model strings come
+// from clients, so the keys are unbounded.
+const maxCachedChains = 4096
+
+// configGen counts changes to what chains are resolved from: the
+// registry, presets, model plugins, priorities and failure policies.
+var configGen atomic.Uint64
+
+// chains caches resolved chains by URL path, model, route and aliases,
+// for the config generation gen.
+var chains struct {
+	sync.Mutex
+	gen   uint64
+	byKey map[string]*PluginChain
+}
+
+// InvalidateChains drops the resolved chains; the registry functions call
+// it on every change.
+func InvalidateChains() {
+	configGen.Add(1)
+}
+
+// TryResolvePlugins builds the plugin chain for a request: virtual model
+// rewriters, head plugins, the route's plugins, plugins attached to the
+// model, plugins from the URL path and from the model suffix, then tail
+// plugins. via lists the models the request was rewritten from, whose
+// attached plugins apply too.
+//
+// Chains are cached until the configuration changes; each call returns a
+// chain of its own.
+func TryResolvePlugins(url url.URL, model string, route []string, via ...string) *PluginChain {
+	key := strings.Join(slices.Concat([]string{url.Path, model}, route, []string{"\x00"}, via), "\x01")
+	gen := configGen.Load()
+	chains.Lock()
+	if chains.gen != gen {
+		chains.gen, chains.byKey = gen, nil
+	}
+	cached, ok := chains.byKey[key]
+	chains.Unlock()
+	if !ok {
+		cached = resolvePlugins(url, model, route, via)
+		chains.Lock()
+		if chains.gen == gen {
+			if chains.byKey == nil || len(chains.byKey) >= maxCachedChains {
+				chains.byKey = make(map[string]*PluginChain)
+			}
+			chains.byKey[key] = cached
+		}
+		chains.Unlock()
+	}
+	// Clipped, so adding to the copy never writes into the cached slice.
+	return &PluginChain{plugins: slices.Clip(cached.plugins)}
+}
+
+func resolvePlugins(url url.URL, model string, route []string, via []string) *PluginChain {
 	chain := NewPluginChain()
 
 	// Add all virtual provider plugins (model rewriters).
 	// They run via RunModelRewrite before the main plugin flow.
+	registryMu.RLock()
 	for name, p := range Registry {
 		if strings.HasPrefix(name, "virtual:") {
 			if _, ok := p.(ModelRewritePlugin); ok {
@@ -25,6 +89,7 @@ func TryResolvePlugins(url url.URL, model string) *PluginChain {
 			}
 		}
 	}
+	registryMu.RUnlock()
 
 	// Add mandatory plugins
 	for _, mp := range HeadPlugins {
@@ -33,53 +98,30 @@ func TryResolvePlugins(url url.URL, model string) *PluginChain {
 		}
 	}
 
+	// Plugins configured on the route
+	for _, spec := range route {
+		addSpec(chain, spec, 0, nil)
+	}
+
+	// Plugins attached to the model or the aliases it was resolved from
+	for _, m := range append(via[:len(via):len(via)], model) {
+		for _, spec := range GetModelPlugins(m) {
+			addSpec(chain, spec, 0, nil)
+		}
+	}
+
 	// Plugins from path: /plugin1:arg1/plugin2:arg2
 	path := strings.TrimPrefix(url.Path, "/")
 	if path != "" {
-		pathParts := strings.Split(path, "/")
-		for _, part := range pathParts {
-			if part == "" {
-				continue
-			}
-			if idx := strings.IndexByte(part, ':'); idx > 0 {
-				if p, ok := GetPlugin(part[:idx]); ok {
-					chain.Add(p, part[idx+1:])
-				}
-			} else if part != "" {
-				if p, ok := GetPlugin(part); ok {
-					chain.Add(p, "")
-				}
-			}
+		for _, part := range strings.Split(path, "/") {
+			addSpec(chain, part, 0, nil)
 		}
 	}
 
 	// Plugins from model suffix: model="gpt-4+plugin1:arg1+plugin2"
 	if idx := strings.IndexByte(model, '+'); idx >= 0 {
-		pluginPart := model[idx+1:]
-		for len(pluginPart) > 0 {
-			var part string
-			if nextIdx := strings.IndexByte(pluginPart, '+'); nextIdx >= 0 {
-				part = pluginPart[:nextIdx]
-				pluginPart = pluginPart[nextIdx+1:]
-			} else {
-				part = pluginPart
-				pluginPart = ""
-			}
-			if part == "" {
-				continue
-			}
-			if colonIdx := strings.IndexByte(part, ':'); colonIdx >= 0 {
-				name := part[:colonIdx]
-				if name != "" {
-					if p, ok := GetPlugin(name); ok {
-						chain.Add(p, part[colonIdx+1:])
-					}
-				}
-			} else {
-				if p, ok := GetPlugin(part); ok {
-					chain.Add(p, "")
-				}
-			}
+		for _, part := range strings.Split(model[idx+1:], "+") {
+			addSpec(chain, part, 0, nil)
 		}
 	}
 
@@ -92,3 +134,40 @@ func TryResolvePlugins(url url.URL, model string) *PluginChain {
 
 	return chain
 }
+
+// AddSpec adds the plugins a spec names the way a route or model suffix
+// would: "name", "name:params" or "preset:<name>", optionally followed by
+// a Matcher. Unknown names are ignored; see ValidateSpecs.
+func (c *PluginChain) AddSpec(spec string) {
+	addSpec(c, spec, 0, nil)
+}
+
+// addSpec adds the plugin a spec ("name" or "name:params", optionally
+// followed by a Matcher) names, or the plugins of a "preset:<name>" spec;
+// unknown names are ignored. when holds the matcher of enclosing presets.
+func addSpec(chain *PluginChain, spec string, depth int, when Matcher) {
+	spec, m, err := splitMatcher(spec)
+	if err != nil {
+		Logger.Debug("Skipping plugin with invalid matcher", zap.String("spec", spec), zap.Error(err))
+		return
+	}
+	when = append(when[:len(when):len(when)], m...)
+	name, params, _ := strings.Cut(spec, ":")
+	if name == "" {
+		return
+	}
+	if name == "preset" {
+		specs, ok := GetPreset(params)
+		if !ok || depth >= maxPresetDepth {
+			Logger.Debug("Skipping preset", zap.String("preset", params), zap.Bool("known", ok))
+			return
+		}
+		for _, s := range specs {
+			addSpec(chain, s, depth+1, when)
+		}
+		return
+	}
+	if p, ok := GetPlugin(name); ok {
+		chain.insert(PluginInstance{Plugin: p, Params: params, When: when})
+	}
+}
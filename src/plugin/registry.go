@@ -1,15 +1,241 @@
 package plugin
 
-// Registry holds all available plugins
-var Registry = map[string]Plugin{}
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds all available plugins. Virtual providers created through
+// the admin API register while requests are served, so use the functions
+// below.
+var (
+	Registry   = map[string]Plugin{}
+	registryMu sync.RWMutex
+)
+
+// Presets holds named plugin lists, referenced as "preset:<name>" wherever
+// a plugin can be named. Each entry is "name" or "name:params". Presets
+// change at runtime (policy reloads), so use the functions below.
+var (
+	Presets   = map[string][]string{}
+	presetsMu sync.RWMutex
+)
+
+// ModelPlugins holds plugin lists attached to models: every request for a
+// model whose name (without its plugin suffix, with or without its
+// provider prefix) matches a key's glob gets the key's plugins, whatever
+// the client sent. Like Presets they are shared by all routers.
+var (
+	ModelPlugins   = map[string][]string{}
+	modelPluginsMu sync.RWMutex
+)
 
 // GetPlugin returns a plugin by name
 func GetPlugin(name string) (Plugin, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
 	p, ok := Registry[name]
 	return p, ok
 }
 
 // RegisterPlugin registers a plugin
 func RegisterPlugin(name string, p Plugin) {
+	registryMu.Lock()
 	Registry[name] = p
+	registryMu.Unlock()
+	InvalidateChains()
+}
+
+// UnregisterPlugin removes a plugin
+func UnregisterPlugin(name string) {
+	registryMu.Lock()
+	delete(Registry, name)
+	registryMu.Unlock()
+	InvalidateChains()
+}
+
+// Well-known plugin priorities; see OrderedPlugin.
+const (
+	PriorityFirst   = -100
+	PriorityDefault = 0
+	PriorityLast    = 100
+)
+
+// Priorities holds configured plugin priorities by plugin name, overriding
+// those the plugins declare. Config reloads replace them while requests
+// are served, so use the functions below.
+var (
+	Priorities   = map[string]int{}
+	prioritiesMu sync.RWMutex
+)
+
+// SetPriorities replaces the configured plugin priorities; plugins left
+// out run at the priority they declare
+func SetPriorities(priorities map[string]int) {
+	prioritiesMu.Lock()
+	Priorities = maps.Clone(priorities)
+	prioritiesMu.Unlock()
+	InvalidateChains()
+}
+
+// PriorityOf returns the priority a plugin runs at
+func PriorityOf(p Plugin) int {
+	prioritiesMu.RLock()
+	prio, ok := Priorities[p.Name()]
+	prioritiesMu.RUnlock()
+	if ok {
+		return prio
+	}
+	if op, ok := p.(OrderedPlugin); ok {
+		return op.Priority()
+	}
+	return PriorityDefault
+}
+
+// FailurePolicies holds configured failure policies by plugin name (true
+// fails open), overriding those the plugins declare. Like Priorities they
+// are replaced on reload, so use the functions below.
+var (
+	FailurePolicies   = map[string]bool{}
+	failurePoliciesMu sync.RWMutex
+)
+
+// SetFailurePolicies replaces the configured failure policies: whether
+// each plugin's failures let the request through (open) or fail it
+// (closed); plugins left out keep the policy they declare
+func SetFailurePolicies(policies map[string]bool) {
+	failurePoliciesMu.Lock()
+	FailurePolicies = maps.Clone(policies)
+	failurePoliciesMu.Unlock()
+	InvalidateChains()
+}
+
+// FailsOpen reports whether a plugin's failures let the request through
+func FailsOpen(p Plugin) bool {
+	failurePoliciesMu.RLock()
+	open, ok := FailurePolicies[p.Name()]
+	failurePoliciesMu.RUnlock()
+	if ok {
+		return open
+	}
+	if fp, ok := p.(FailOpenPlugin); ok {
+		return fp.FailOpen()
+	}
+	return false
+}
+
+// GetPreset returns a preset's plugin list by name
+func GetPreset(name string) ([]string, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	specs, ok := Presets[name]
+	return specs, ok
+}
+
+// RegisterPreset registers a preset, replacing any of the same name
+func RegisterPreset(name string, specs []string) {
+	presetsMu.Lock()
+	Presets[name] = specs
+	presetsMu.Unlock()
+	InvalidateChains()
+}
+
+// SnapshotPresets returns a copy of the registered presets
+func SnapshotPresets() map[string][]string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	return maps.Clone(Presets)
+}
+
+// UnregisterPreset removes a preset
+func UnregisterPreset(name string) {
+	presetsMu.Lock()
+	delete(Presets, name)
+	presetsMu.Unlock()
+	InvalidateChains()
+}
+
+// RegisterModelPlugins attaches plugins to models matching pattern,
+// replacing any attached to the same pattern
+func RegisterModelPlugins(pattern string, specs []string) {
+	modelPluginsMu.Lock()
+	ModelPlugins[pattern] = specs
+	modelPluginsMu.Unlock()
+	InvalidateChains()
+}
+
+// UnregisterModelPlugins detaches the plugins attached to pattern
+func UnregisterModelPlugins(pattern string) {
+	modelPluginsMu.Lock()
+	delete(ModelPlugins, pattern)
+	modelPluginsMu.Unlock()
+	InvalidateChains()
+}
+
+// GetModelPlugins returns the plugins attached to model, patterns in
+// lexical order.
+func GetModelPlugins(model string) []string {
+	model, _, _ = strings.Cut(model, "+")
+	_, bare, _ := strings.Cut(model, "/")
+	modelPluginsMu.RLock()
+	defer modelPluginsMu.RUnlock()
+	patterns := make([]string, 0, len(ModelPlugins))
+	for pattern := range ModelPlugins {
+		if globMatch(pattern, model) || (bare != "" && globMatch(pattern, bare)) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	var specs []string
+	for _, pattern := range patterns {
+		specs = append(specs, ModelPlugins[pattern]...)
+	}
+	return specs
+}
+
+// ValidateSpecs checks that every plugin named in specs is registered,
+// that referenced presets exist and that matchers parse; presets holds
+// presets about to be registered alongside the global ones.
+func ValidateSpecs(specs []string, presets map[string][]string) error {
+	for _, spec := range specs {
+		base, _, err := splitMatcher(spec)
+		if err != nil {
+			return fmt.Errorf("%s: %v", spec, err)
+		}
+		name, params, _ := strings.Cut(base, ":")
+		if name == "preset" {
+			if _, ok := presets[params]; ok {
+				continue
+			}
+			if _, ok := GetPreset(params); !ok {
+				return fmt.Errorf("unknown preset %q", params)
+			}
+			continue
+		}
+		if _, ok := GetPlugin(name); !ok {
+			return fmt.Errorf("unknown plugin %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidatePresetRefs checks that every preset specs references is in
+// presets alone, e.g. the presets as they will stand once a change
+// applies; malformed specs are left to ValidateSpecs.
+func ValidatePresetRefs(specs []string, presets map[string][]string) error {
+	for _, spec := range specs {
+		base, _, err := splitMatcher(spec)
+		if err != nil {
+			continue
+		}
+		if name, params, _ := strings.Cut(base, ":"); name == "preset" {
+			if _, ok := presets[params]; !ok {
+				return fmt.Errorf("unknown preset %q", params)
+			}
+		}
+	}
+	return nil
 }
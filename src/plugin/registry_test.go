@@ -1,8 +1,11 @@
 package plugin_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/neutrome-labs/ail"
@@ -115,7 +118,281 @@ func TestMandatoryPlugins(t *testing.T) {
 	if len(plugin.HeadPlugins) != 0 {
 		t.Errorf("Expected empty HeadPlugins, got %d", len(plugin.HeadPlugins))
 	}
-	if len(plugin.TailPlugins) != 1 {
-		t.Errorf("Expected 1 TailPlugin, got %d", len(plugin.TailPlugins))
+	// tiktoken plus usage and inspect, registered via modules/init.go.
+	if len(plugin.TailPlugins) != 3 {
+		t.Errorf("Expected 3 TailPlugins, got %d", len(plugin.TailPlugins))
+	}
+}
+
+func TestTryResolvePlugins_Presets(t *testing.T) {
+	plugin.RegisterPreset("test-safe", []string{"fuzz", "slwin:20"})
+	plugin.RegisterPreset("test-outer", []string{"preset:test-safe", "kvtools"})
+	plugin.RegisterPreset("test-loop", []string{"preset:test-loop"})
+	defer func() {
+		plugin.UnregisterPreset("test-safe")
+		plugin.UnregisterPreset("test-outer")
+		plugin.UnregisterPreset("test-loop")
+	}()
+
+	names := func(chain *plugin.PluginChain) []string {
+		var out []string
+		for _, pi := range chain.GetPlugins() {
+			if pi.Plugin.Name() == "tiktoken" || pi.Plugin.Name() == "usage" || pi.Plugin.Name() == "inspect" {
+				continue
+			}
+			out = append(out, pi.Plugin.Name()+":"+pi.Params)
+		}
+		return out
+	}
+	u, _ := url.Parse("/preset:test-safe")
+	tests := []struct {
+		name  string
+		url   url.URL
+		model string
+		route []string
+		want  string
+	}{
+		{"model", url.URL{}, "gpt-4o+preset:test-safe", nil, "fuzz: slwin:20"},
+		{"path", *u, "gpt-4o", nil, "fuzz: slwin:20"},
+		{"route first", url.URL{}, "gpt-4o+kvtools", []string{"preset:test-safe"}, "fuzz: slwin:20 kvtools:"},
+		{"nested", url.URL{}, "gpt-4o+preset:test-outer", nil, "fuzz: slwin:20 kvtools:"},
+		{"unknown", url.URL{}, "gpt-4o+preset:nope+fuzz", nil, "fuzz:"},
+		{"cycle", url.URL{}, "gpt-4o+preset:test-loop", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(names(plugin.TryResolvePlugins(tt.url, tt.model, tt.route)), " "); got != tt.want {
+				t.Errorf("plugins = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryResolvePlugins_ModelPlugins(t *testing.T) {
+	plugin.RegisterModelPlugins("test-bot", []string{"fuzz"})
+	plugin.RegisterModelPlugins("test-gpt-*", []string{"slwin:20"})
+	defer func() {
+		plugin.UnregisterModelPlugins("test-bot")
+		plugin.UnregisterModelPlugins("test-gpt-*")
+	}()
+
+	names := func(chain *plugin.PluginChain) []string {
+		var out []string
+		for _, pi := range chain.GetPlugins() {
+			if pi.Plugin.Name() == "tiktoken" || pi.Plugin.Name() == "usage" || pi.Plugin.Name() == "inspect" {
+				continue
+			}
+			out = append(out, pi.Plugin.Name()+":"+pi.Params)
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		model string
+		via   []string
+		want  string
+	}{
+		{"exact", "test-bot", nil, "fuzz:"},
+		{"prefixed", "bots/test-bot+kvtools", nil, "fuzz: kvtools:"},
+		{"glob", "openai/test-gpt-4o", nil, "slwin:20"},
+		{"alias", "openai/test-gpt-4o", []string{"bots/test-bot"}, "fuzz: slwin:20"},
+		{"none", "test-bots", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(names(plugin.TryResolvePlugins(url.URL{}, tt.model, nil, tt.via...)), " "); got != tt.want {
+				t.Errorf("plugins = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryResolvePlugins_Cache(t *testing.T) {
+	plugin.RegisterPreset("test-cached", []string{"fuzz"})
+	defer plugin.UnregisterPreset("test-cached")
+
+	has := func(chain *plugin.PluginChain, name string) bool {
+		for _, pi := range chain.GetPlugins() {
+			if pi.Plugin.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	resolve := func() *plugin.PluginChain {
+		return plugin.TryResolvePlugins(url.URL{}, "gpt-4o+preset:test-cached", nil)
+	}
+
+	first := resolve()
+	slwin, _ := plugin.GetPlugin("slwin")
+	first.Add(slwin, "")
+	if second := resolve(); !has(second, "fuzz") || has(second, "slwin") {
+		t.Error("adding to a resolved chain changed the cached one")
+	}
+
+	plugin.RegisterPreset("test-cached", []string{"kvtools"})
+	if chain := resolve(); has(chain, "fuzz") || !has(chain, "kvtools") {
+		t.Error("preset change did not invalidate the cached chain")
+	}
+
+	plugin.RegisterPlugin("test-late", slwin)
+	defer plugin.UnregisterPlugin("test-late")
+	plugin.RegisterPreset("test-cached", []string{"test-late"})
+	if !has(resolve(), "slwin") {
+		t.Error("plugin registered later missing from the chain")
+	}
+}
+
+func TestValidateSpecs(t *testing.T) {
+	pending := map[string][]string{"new": {"fuzz"}}
+	if err := plugin.ValidateSpecs([]string{"fuzz", "slwin:20", "preset:new"}, pending); err != nil {
+		t.Error(err)
+	}
+	for _, spec := range []string{"nope", "preset:missing"} {
+		if err := plugin.ValidateSpecs([]string{spec}, pending); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+type orderedTestPlugin struct {
+	name string
+	prio int
+}
+
+func (p orderedTestPlugin) Name() string  { return p.name }
+func (p orderedTestPlugin) Priority() int { return p.prio }
+
+func TestPluginChain_Priority(t *testing.T) {
+	chain := plugin.NewPluginChain()
+	fuzz, _ := plugin.GetPlugin("fuzz")
+	chain.Add(orderedTestPlugin{"sample", plugin.PriorityLast}, "")
+	chain.Add(fuzz, "a")
+	chain.Add(orderedTestPlugin{"cache", plugin.PriorityFirst}, "")
+	chain.Add(fuzz, "b")
+	chain.Add(orderedTestPlugin{"redact", 50}, "")
+
+	plugin.SetPriorities(map[string]int{"configured": plugin.PriorityFirst - 1})
+	defer plugin.SetPriorities(nil)
+	chain.Add(orderedTestPlugin{"configured", plugin.PriorityLast}, "")
+
+	var got []string
+	for _, pi := range chain.GetPlugins() {
+		got = append(got, pi.Plugin.Name()+pi.Params)
+	}
+	if want := "configured cache fuzza fuzzb redact sample"; strings.Join(got, " ") != want {
+		t.Errorf("order = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+// failingTestPlugin fails every Before call, with a RouterError when
+// blocking.
+type failingTestPlugin struct {
+	name     string
+	open     bool
+	blocking bool
+}
+
+func (p failingTestPlugin) Name() string   { return p.name }
+func (p failingTestPlugin) FailOpen() bool { return p.open }
+
+func (p failingTestPlugin) Before(_ string, _ *services.ProviderService, _ *http.Request, _ *ail.Program) (*ail.Program, error) {
+	if p.blocking {
+		return nil, &services.RouterError{Kind: services.ErrorGuardBlocked, Status: http.StatusForbidden, Message: "blocked"}
+	}
+	return nil, errors.New("sink down")
+}
+
+func TestPluginChain_FailurePolicy(t *testing.T) {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "gpt-4")
+	httpReq := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	provider := &services.ProviderService{Name: "test"}
+
+	chain := plugin.NewPluginChain()
+	chain.Add(failingTestPlugin{name: "logging", open: true}, "")
+	out, err := chain.RunBefore(provider, httpReq, prog)
+	if err != nil {
+		t.Fatalf("fail-open plugin failed the request: %v", err)
+	}
+	if out != prog || !chain.FailedOpen(0) {
+		t.Errorf("program = %p (want %p), failed open = %v", out, prog, chain.FailedOpen(0))
+	}
+	if st := chain.Stats(0); st.Errors != 1 || st.Duration <= 0 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	closed := plugin.NewPluginChain()
+	closed.Add(failingTestPlugin{name: "guard"}, "")
+	if _, err := closed.RunBefore(provider, httpReq, prog); err == nil {
+		t.Error("fail-closed plugin let the request through")
+	}
+	if closed.FailedOpen(0) {
+		t.Error("fail-closed plugin recorded as failed open")
+	}
+
+	blocking := plugin.NewPluginChain()
+	blocking.Add(failingTestPlugin{name: "blocker", open: true, blocking: true}, "")
+	if _, err := blocking.RunBefore(provider, httpReq, prog); err == nil {
+		t.Error("fail-open plugin's rejection was ignored")
+	}
+
+	plugin.SetFailurePolicies(map[string]bool{"guard": true})
+	defer plugin.SetFailurePolicies(nil)
+	configured := plugin.NewPluginChain()
+	configured.Add(failingTestPlugin{name: "guard"}, "")
+	if _, err := configured.RunBefore(provider, httpReq, prog); err != nil {
+		t.Errorf("configured fail-open policy ignored: %v", err)
+	}
+}
+
+// dependentTestPlugin declares fixed dependencies.
+type dependentTestPlugin struct {
+	name string
+	deps plugin.Dependencies
+}
+
+func (p dependentTestPlugin) Name() string { return p.name }
+
+func (p dependentTestPlugin) Dependencies(string) (plugin.Dependencies, error) {
+	return p.deps, nil
+}
+
+func TestPluginChain_Check(t *testing.T) {
+	fuzz, _ := plugin.GetPlugin("fuzz")
+	race := dependentTestPlugin{"race", plugin.Dependencies{Conflicts: []string{"bestof"}}}
+	cache := dependentTestPlugin{"cache", plugin.Dependencies{Requires: []string{"fuzz"}}}
+
+	for _, tc := range []struct {
+		plugins []plugin.Plugin
+		want    string
+	}{
+		{[]plugin.Plugin{race, fuzz}, ""},
+		{[]plugin.Plugin{race, race}, ""},
+		{[]plugin.Plugin{race, dependentTestPlugin{name: "bestof"}}, "race conflicts with bestof"},
+		{[]plugin.Plugin{dependentTestPlugin{name: "bestof"}, race}, "race conflicts with bestof"},
+		{[]plugin.Plugin{cache}, "cache requires the fuzz plugin"},
+		{[]plugin.Plugin{cache, fuzz}, ""},
+	} {
+		chain := plugin.NewPluginChain()
+		for _, p := range tc.plugins {
+			chain.Add(p, "")
+		}
+		err := chain.Check()
+		if (err == nil) != (tc.want == "") || (err != nil && err.Error() != tc.want) {
+			t.Errorf("%v: err = %v, want %q", tc.plugins, err, tc.want)
+		}
+	}
+
+	kvtools, _ := plugin.GetPlugin("kvtools")
+	chain := plugin.NewPluginChain()
+	chain.Add(kvtools, "no-such-backend")
+	if err := chain.Check(); err == nil || !strings.Contains(err.Error(), "no-such-backend") {
+		t.Errorf("unknown kv backend: err = %v", err)
+	}
+	chain = plugin.NewPluginChain()
+	chain.Add(kvtools, "memory")
+	if err := chain.Check(); err != nil {
+		t.Errorf("memory backend: %v", err)
 	}
 }
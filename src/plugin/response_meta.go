@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetaHeader carries the metadata plugins attached to a response, as a
+// structured-field dictionary (RFC 8941): cache=hit, guard=pass, variant="b".
+const MetaHeader = "X-Router-Meta"
+
+// ResponseMeta collects the headers and metadata plugins attach to a
+// response, so they need not write to the http.ResponseWriter themselves.
+// The endpoint modules write what was set before the body; what is set
+// once the body has started (at stream end, say) goes out as trailers.
+// Metadata is also recorded in the access log entry.
+//
+// A request carries one ResponseMeta across InferFresh re-entries, so
+// plugins of recursive sub-steps add to the outer response. Methods are
+// safe for concurrent use and no-ops on a nil ResponseMeta.
+type ResponseMeta struct {
+	mu     sync.Mutex
+	header http.Header
+	meta   map[string]string
+}
+
+type responseMetaCtxKey struct{}
+
+// reservedResponseHeaders frame the body; the module and the driver own them.
+var reservedResponseHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Trailer":           true,
+	MetaHeader:          true,
+}
+
+// WithResponseMeta returns ctx carrying a new ResponseMeta, or ctx itself
+// when it already carries one.
+func WithResponseMeta(ctx context.Context) (context.Context, *ResponseMeta) {
+	if m := ResponseMetaFrom(ctx); m != nil {
+		return ctx, m
+	}
+	m := &ResponseMeta{}
+	return context.WithValue(ctx, responseMetaCtxKey{}, m), m
+}
+
+// ResponseMetaFrom returns the ResponseMeta of ctx, or nil.
+func ResponseMetaFrom(ctx context.Context) *ResponseMeta {
+	m, _ := ctx.Value(responseMetaCtxKey{}).(*ResponseMeta)
+	return m
+}
+
+// SetResponseHeader sets a header of r's response. Headers framing the
+// body (Content-Type, Content-Length, ...) cannot be set.
+func SetResponseHeader(r *http.Request, key, value string) {
+	ResponseMetaFrom(r.Context()).SetHeader(key, value)
+}
+
+// SetResponseMeta sets a metadata entry of r's response. Keys are
+// lowercased; see ResponseMeta.Set.
+func SetResponseMeta(r *http.Request, key, value string) {
+	ResponseMetaFrom(r.Context()).Set(key, value)
+}
+
+// SetHeader sets a response header, replacing earlier values.
+func (m *ResponseMeta) SetHeader(key, value string) {
+	if m == nil {
+		return
+	}
+	key = http.CanonicalHeaderKey(key)
+	if reservedResponseHeaders[key] {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.header == nil {
+		m.header = make(http.Header)
+	}
+	m.header.Set(key, value)
+}
+
+// Set sets a metadata entry. Keys are lowercase tokens of letters,
+// digits, "_", "-", "." and "*"; entries with other keys are dropped.
+func (m *ResponseMeta) Set(key, value string) {
+	if m == nil {
+		return
+	}
+	key = strings.ToLower(key)
+	if !validMetaKey(key) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.meta == nil {
+		m.meta = make(map[string]string)
+	}
+	m.meta[key] = value
+}
+
+// Meta returns a copy of the metadata entries.
+func (m *ResponseMeta) Meta() map[string]string {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.meta) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m.meta))
+	for k, v := range m.meta {
+		out[k] = v
+	}
+	return out
+}
+
+// Header returns the response headers set so far, metadata included
+// under MetaHeader.
+func (m *ResponseMeta) Header() http.Header {
+	h := make(http.Header)
+	if m == nil {
+		return h
+	}
+	m.mu.Lock()
+	for k, v := range m.header {
+		h[k] = append([]string(nil), v...)
+	}
+	m.mu.Unlock()
+	if meta := m.Meta(); meta != nil {
+		h.Set(MetaHeader, formatMeta(meta))
+	}
+	return h
+}
+
+// formatMeta serializes meta as a structured-field dictionary, keys sorted.
+func formatMeta(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + metaValue(meta[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// metaValue writes v as a token when it is one, else as a quoted string.
+func metaValue(v string) string {
+	if isMetaToken(v) {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range v {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 0x20 || c > 0x7e:
+			// Quoted strings are printable ASCII only.
+			b.WriteByte('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func validMetaKey(k string) bool {
+	if k == "" || !(k[0] >= 'a' && k[0] <= 'z' || k[0] == '*') {
+		return false
+	}
+	for _, c := range k {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("_-.*", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isMetaToken reports whether v is a structured-field token.
+func isMetaToken(v string) bool {
+	if v == "" || !(v[0] >= 'a' && v[0] <= 'z' || v[0] >= 'A' && v[0] <= 'Z' || v[0] == '*') {
+		return false
+	}
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~:/", c)) {
+			return false
+		}
+	}
+	return true
+}
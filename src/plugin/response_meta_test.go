@@ -0,0 +1,48 @@
+package plugin_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+func TestResponseMeta(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	// Without a ResponseMeta in the context, setters are no-ops.
+	plugin.SetResponseHeader(r, "X-Cache", "hit")
+	plugin.SetResponseMeta(r, "cache", "hit")
+
+	ctx, meta := plugin.WithResponseMeta(r.Context())
+	if again, same := plugin.WithResponseMeta(ctx); again != ctx || same != meta {
+		t.Fatal("WithResponseMeta should reuse the ResponseMeta of its context")
+	}
+	r = r.WithContext(ctx)
+
+	plugin.SetResponseHeader(r, "x-cache", "hit")
+	plugin.SetResponseHeader(r, "Content-Type", "text/plain")
+	plugin.SetResponseHeader(r, plugin.MetaHeader, "forged")
+	plugin.SetResponseMeta(r, "Guard", "pass")
+	plugin.SetResponseMeta(r, "variant", "b c")
+	plugin.SetResponseMeta(r, "note", `say "hi"`)
+	plugin.SetResponseMeta(r, "bad key", "x")
+
+	h := meta.Header()
+	if got := h.Get("X-Cache"); got != "hit" {
+		t.Errorf("X-Cache = %q", got)
+	}
+	if h.Get("Content-Type") != "" {
+		t.Error("Content-Type should be reserved")
+	}
+	if got, want := h.Get(plugin.MetaHeader), `guard=pass, note="say \"hi\"", variant="b c"`; got != want {
+		t.Errorf("%s = %q, want %q", plugin.MetaHeader, got, want)
+	}
+	if m := meta.Meta(); len(m) != 3 || m["guard"] != "pass" {
+		t.Errorf("Meta() = %v", m)
+	}
+
+	var none *plugin.ResponseMeta
+	if none.Meta() != nil || len(none.Header()) != 0 {
+		t.Error("a nil ResponseMeta should be empty")
+	}
+}
@@ -45,6 +45,13 @@ type ToolCallContext struct {
 	Request *http.Request
 }
 
+// ToolSource is implemented by plugins serving on-router tools (every
+// ToolPlugin), so that handlers running their own agent loop, like DSPy's
+// ReAct, can offer those tools and dispatch calls to them.
+type ToolSource interface {
+	ToolHandler() ToolHandler
+}
+
 // ─── ToolPlugin: composable base ─────────────────────────────────────────────
 
 // ToolPlugin is a reusable base struct that turns any ToolHandler into a
@@ -74,6 +81,11 @@ func (tp *ToolPlugin) Name() string {
 	return tp.Handler.ToolName()
 }
 
+// ToolHandler returns the wrapped handler — satisfies ToolSource.
+func (tp *ToolPlugin) ToolHandler() ToolHandler {
+	return tp.Handler
+}
+
 // Before injects tool definitions — satisfies BeforePlugin.
 func (tp *ToolPlugin) Before(params string, _ *services.ProviderService, _ *http.Request, prog *ail.Program) (*ail.Program, error) {
 	defs := tp.Handler.ToolDefs(params)
@@ -132,6 +144,9 @@ func (tp *ToolPlugin) RecursiveHandler(
 		// Pipeline failed — don't handle, let the caller deal with it.
 		return false, nil
 	}
+	// Drop each round's capture, which may have spilled to disk, once
+	// done with it.
+	defer func() { capture.Close() }()
 
 	// Check if the response has any calls to our tools.
 	resultInsts, nHandled := tp.dispatchCalls(params, resProg, ctx)
@@ -161,6 +176,7 @@ func (tp *ToolPlugin) RecursiveHandler(
 			zap.String("tool", tp.Handler.ToolName()),
 			zap.Int("round", round))
 
+		capture.Close()
 		resProg, capture, err = ic.Capture(currentProg, r)
 		if err != nil {
 			return true, err
@@ -220,18 +236,8 @@ func (tp *ToolPlugin) dispatchCalls(
 			}
 		}
 
-		Logger.Debug("ToolPlugin dispatching call",
-			zap.String("tool", call.Name),
-			zap.String("call_id", call.CallID))
-
-		result, wasHandled, err := tp.Handler.HandleToolCall(params, call.CallID, args, ctx)
-		if err != nil {
-			Logger.Error("ToolPlugin handler error",
-				zap.String("tool", call.Name),
-				zap.Error(err))
-			result = "error: " + err.Error()
-		}
-		if !wasHandled && err == nil {
+		result, wasHandled := CallTool(tp.Handler, params, call.Name, call.CallID, args, ctx)
+		if !wasHandled {
 			continue
 		}
 
@@ -251,6 +257,23 @@ func (tp *ToolPlugin) dispatchCalls(
 
 // ─── Helpers ─────────────────────────────────────────────────────────────────
 
+// CallTool runs one call to tool name through h. A handler error becomes an
+// "error: …" result for the model to read, and counts as handled.
+func CallTool(h ToolHandler, params, name, callID string, args json.RawMessage, ctx *ToolCallContext) (result string, handled bool) {
+	Logger.Debug("ToolPlugin dispatching call",
+		zap.String("tool", name),
+		zap.String("call_id", callID))
+
+	result, handled, err := h.HandleToolCall(params, callID, args, ctx)
+	if err != nil {
+		Logger.Error("ToolPlugin handler error",
+			zap.String("tool", name),
+			zap.Error(err))
+		return "error: " + err.Error(), true
+	}
+	return result, handled
+}
+
 // BuildToolDef is a convenience helper that builds a complete
 // DEF_START..DEF_END instruction sequence from structured data.
 func BuildToolDef(name, description string, schema json.RawMessage) []ail.Instruction {
@@ -291,4 +314,5 @@ func injectDefs(prog *ail.Program, defs []ail.Instruction) *ail.Program {
 var (
 	_ BeforePlugin           = (*ToolPlugin)(nil)
 	_ RecursiveHandlerPlugin = (*ToolPlugin)(nil)
+	_ ToolSource             = (*ToolPlugin)(nil)
 )
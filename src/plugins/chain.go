@@ -172,7 +172,10 @@ func (c *ChainPlugin) RecursiveHandler(
 		}
 		stepProg = applyOverrides(stepProg, step)
 
-		resProg, _, err := captureFull(ic, stepProg, step, r, stepCounter, totalSteps)
+		resProg, capture, err := captureFull(ic, stepProg, step, r, stepCounter, totalSteps)
+		// Only the parsed result is kept; drop the capture, which may
+		// have spilled to disk.
+		capture.Close()
 		stepCounter++
 		if err != nil {
 			return true, err
@@ -203,6 +206,7 @@ func (c *ChainPlugin) RecursiveHandler(
 			// other recursive handlers (dspy, etc.) fire on the final output.
 			baseStep := chainStep{Mode: "base", Prompt: "base"}
 			baseRes, baseCapture, err := captureForExit(ic, stripStreaming(finalProg), baseStep, r, stepCounter, totalSteps)
+			defer baseCapture.Close()
 			if err != nil {
 				return true, err
 			}
@@ -220,7 +224,8 @@ func (c *ChainPlugin) RecursiveHandler(
 
 	// Capture base inference (non-streaming for intermediate use).
 	baseStep := chainStep{Mode: "base", Prompt: "base"}
-	baseResProg, _, err := captureFull(ic, currentProg, baseStep, r, stepCounter, totalSteps)
+	baseResProg, baseCapture, err := captureFull(ic, currentProg, baseStep, r, stepCounter, totalSteps)
+	baseCapture.Close()
 	stepCounter++
 	if err != nil {
 		return true, err
@@ -273,12 +278,16 @@ func (c *ChainPlugin) RecursiveHandler(
 			capturedProg, capture, err = captureFull(ic, stepProg, step, r, stepCounter, totalSteps)
 		}
 		stepCounter++
+		if err != nil || !isLast {
+			capture.Close()
+		}
 		if err != nil {
 			return true, err
 		}
 		prevResult = capturedProg
 		lastCapture = capture
 	}
+	defer lastCapture.Close()
 
 	// Non-streaming final output: replay the last captured response.
 	if len(prependResults) > 0 {
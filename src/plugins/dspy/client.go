@@ -0,0 +1,96 @@
+package dspy
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxIdleConns = 64
+	idleConnTimeout     = 90 * time.Second
+	sidecarCallAttempts = 2
+)
+
+// sidecarClient returns the client for sidecar calls. Unlike
+// http.DefaultClient, which keeps two idle connections per host, it keeps
+// up to DSPY_MAX_IDLE_CONNS (default 64) so high-QPS traffic reuses
+// connections instead of leaving sockets in TIME_WAIT until the ephemeral
+// ports run out. Dialing is bounded by DSPY_CONNECT_TIMEOUT; the total
+// budget of a call (DSPY_TIMEOUT) is applied through its context. TLS
+// to the sidecars is configured by sidecarTLSConfig; a configuration
+// that cannot be loaded is logged and left out, so the calls fail rather
+// than fall back silently.
+var sidecarClient = sync.OnceValue(func() *http.Client {
+	idle := getMaxIdleConns()
+	client := services.NewProviderClient(services.ClientOptions{
+		Connect:             getConnectTimeout(),
+		MaxIdleConnsPerHost: idle,
+		IdleConnTimeout:     idleConnTimeout,
+	})
+	transport := client.Transport.(*http.Transport)
+	transport.MaxIdleConns = idle
+	cfg, err := sidecarTLSConfig()
+	if err != nil {
+		plugin.Logger.Error("dspy: sidecar TLS configuration", zap.Error(err))
+	}
+	if cfg != nil {
+		// Keep resuming TLS sessions with the sidecar's own settings.
+		cfg.ClientSessionCache = transport.TLSClientConfig.ClientSessionCache
+		transport.TLSClientConfig = cfg
+	}
+	return client
+})
+
+// doSidecar sends req with the sidecar client, carrying the shared secret.
+// A pooled connection the
+// sidecar has already closed fails with a reset; such calls are retried
+// once on a fresh connection when the body can be replayed.
+func doSidecar(req *http.Request) (*http.Response, error) {
+	signSidecarRequest(req)
+	for attempt := 1; ; attempt++ {
+		resp, err := sidecarClient().Do(req)
+		if err == nil || attempt == sidecarCallAttempts || !isConnReset(err) || req.GetBody == nil || req.Context().Err() != nil {
+			return resp, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		plugin.Logger.Debug("dspy: sidecar connection reset, retrying", zap.Error(err))
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+}
+
+// isConnReset reports whether err is the peer resetting or closing the
+// connection under a request.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func getConnectTimeout() time.Duration {
+	if t := os.Getenv("DSPY_CONNECT_TIMEOUT"); t != "" {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			return d
+		}
+	}
+	return services.DefaultConnectTimeout
+}
+
+func getMaxIdleConns() int {
+	if s := os.Getenv("DSPY_MAX_IDLE_CONNS"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxIdleConns
+}
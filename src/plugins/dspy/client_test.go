@@ -0,0 +1,48 @@
+package dspy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDoSidecar_RetriesReset(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) == 1 {
+			// Drop the connection with a RST, as a restarted sidecar does.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/invoke", bytes.NewReader([]byte(`{"kind":"cot"}`)))
+	resp, err := doSidecar(req)
+	if err != nil {
+		t.Fatalf("doSidecar: %v (calls=%d)", err, calls.Load())
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if string(got) != `{"kind":"cot"}` || calls.Load() != 2 {
+		t.Errorf("body = %q after %d calls, want the payload replayed once", got, calls.Load())
+	}
+}
+
+func TestSidecarClient_Pooling(t *testing.T) {
+	transport := sidecarClient().Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != defaultMaxIdleConns {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, defaultMaxIdleConns)
+	}
+	if sidecarClient() != sidecarClient() {
+		t.Error("sidecar calls do not share one client")
+	}
+}
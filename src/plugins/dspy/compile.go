@@ -0,0 +1,392 @@
+package dspy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+const defaultCompileTimeout = 30 * time.Minute
+
+// validOptimizers maps the optimizer names accepted by Compile to the
+// DSPy teleprompters the sidecar runs.
+var validOptimizers = map[string]string{
+	"bootstrap": "BootstrapFewShot",
+	"mipro":     "MIPROv2",
+}
+
+var compiledNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Errors from Compile and the compiled-program cache.
+var (
+	ErrBadCompile       = errors.New("invalid compile request")
+	ErrNoCompiled       = errors.New("no such compiled program")
+	ErrNoTrainingSample = errors.New("no usable samples")
+)
+
+// CompileRequest asks the sidecar to optimize a DSPy module for a
+// signature against a directory of sampled requests (see the sampler
+// plugin). Each sample's request.ail provides the inputs and its
+// response.ail the expected output.
+type CompileRequest struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind,omitempty"`      // default cot
+	Signature string `json:"signature,omitempty"` // default "history, question -> answer"; "@<name>" for a named one
+	Model     string `json:"model"`               // LM the optimizer calls back through the router
+	Optimizer string `json:"optimizer,omitempty"` // bootstrap (default) or mipro
+	Dir       string `json:"dir"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// CompiledProgram is an optimized DSPy module: the sidecar's dumped
+// module state plus what is needed to rebuild it. +dspy:@<name> runs it.
+type CompiledProgram struct {
+	Name       string          `json:"name"`
+	Kind       string          `json:"kind"`
+	Signature  string          `json:"signature"`
+	Model      string          `json:"model"`
+	Optimizer  string          `json:"optimizer"`
+	Examples   int             `json:"examples"`
+	CompiledAt time.Time       `json:"compiled_at"`
+	State      json.RawMessage `json:"state,omitempty"`
+}
+
+// trainingExample is one entry of the trainset sent to the sidecar.
+type trainingExample struct {
+	Inputs  map[string]string `json:"inputs"`
+	Outputs map[string]string `json:"outputs"`
+}
+
+type sidecarCompileRequest struct {
+	Kind      string            `json:"kind"`
+	Signature string            `json:"signature"`
+	Model     string            `json:"model"`
+	Optimizer string            `json:"optimizer"`
+	Trainset  []trainingExample `json:"trainset"`
+	signatureDetails
+}
+
+type sidecarCompileResponse struct {
+	State json.RawMessage `json:"state"`
+}
+
+// Validate fills in defaults and checks the request.
+func (req *CompileRequest) Validate() error {
+	if req.Kind == "" {
+		req.Kind = defaultKind
+	}
+	if req.Signature == "" {
+		req.Signature = defaultSignature
+	}
+	if req.Optimizer == "" {
+		req.Optimizer = "bootstrap"
+	}
+	kindErr := checkKind(req.Kind)
+	switch {
+	case !compiledNameRe.MatchString(req.Name):
+		return fmt.Errorf("%w: name %q must be letters, digits, '-' and '_'", ErrBadCompile, req.Name)
+	case kindErr != nil:
+		return fmt.Errorf("%w: %v", ErrBadCompile, kindErr)
+	case validOptimizers[req.Optimizer] == "":
+		return fmt.Errorf("%w: unknown optimizer %q (want bootstrap or mipro)", ErrBadCompile, req.Optimizer)
+	case req.Model == "":
+		return fmt.Errorf("%w: model is required", ErrBadCompile)
+	case req.Dir == "":
+		return fmt.Errorf("%w: dir is required", ErrBadCompile)
+	}
+	return nil
+}
+
+// Compile builds a trainset from the samples in req.Dir, has a sidecar run
+// the optimizer over it, and caches the result under req.Name. header is
+// forwarded like on inference calls, so the optimizer's LM calls are
+// attributed to the caller.
+func Compile(ctx context.Context, req CompileRequest, header http.Header) (*CompiledProgram, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	var named *Signature
+	if name, ok := strings.CutPrefix(req.Signature, "@"); ok {
+		sig, err := LookupSignature(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadCompile, err)
+		}
+		req.Signature, named = sig.Signature, sig
+	}
+	trainset, err := buildTrainset(req.Dir, req.Limit, req.Kind, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := getSidecarPool()
+	pool.start()
+	target := pool.pick(pool.lacking(req.Kind))
+	if target == nil {
+		return nil, pool.unavailableError()
+	}
+	body, err := json.Marshal(sidecarCompileRequest{
+		Kind:      req.Kind,
+		Signature: req.Signature,
+		Model:     stripDspySuffix(req.Model),
+		Optimizer: validOptimizers[req.Optimizer],
+		Trainset:  trainset,
+
+		signatureDetails: named.details(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, getCompileTimeout())
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target.url+"/compile", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if auth := header.Get("Authorization"); auth != "" {
+		httpReq.Header.Set("X-Upstream-Authorization", auth)
+	}
+
+	plugin.Logger.Info("dspy: compiling",
+		zap.String("name", req.Name),
+		zap.String("optimizer", req.Optimizer),
+		zap.Int("examples", len(trainset)))
+	resp, err := doSidecar(httpReq)
+	if err != nil {
+		err = sidecarCallError(ctx, err)
+		if errors.Is(err, errSidecarUnreachable) {
+			target.report(err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("sidecar returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	var out sidecarCompileResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode sidecar response: %w", err)
+	}
+	if len(out.State) == 0 {
+		return nil, fmt.Errorf("sidecar returned no program state")
+	}
+
+	cp := &CompiledProgram{
+		Name:       req.Name,
+		Kind:       req.Kind,
+		Signature:  req.Signature,
+		Model:      stripDspySuffix(req.Model),
+		Optimizer:  req.Optimizer,
+		Examples:   len(trainset),
+		CompiledAt: time.Now().UTC(),
+		State:      out.State,
+	}
+	if err := compiled.put(cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// buildTrainset turns the samples below dir into training examples.
+// Samples without a response, or whose response has no text, are skipped.
+func buildTrainset(dir string, limit int, kind, signature string) ([]trainingExample, error) {
+	samples, err := services.LoadReplayCorpus(dir, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCompile, err)
+	}
+	_, outputFields := parseSignatureFields(signature)
+	field := outputFields[0]
+	for _, f := range outputFields {
+		if f != "reasoning" {
+			field = f
+			break
+		}
+	}
+
+	var trainset []trainingExample
+	for _, s := range samples {
+		reqProg, err := readSampleProgram(filepath.Join(s.Dir, "request.ail"))
+		if err != nil {
+			continue
+		}
+		resProg, err := readSampleProgram(filepath.Join(s.Dir, "response.ail"))
+		if err != nil {
+			continue
+		}
+		answer := responseText(resProg)
+		if answer == "" {
+			continue
+		}
+		payload, err := buildSidecarPayload(kind, signature, reqProg)
+		if err != nil {
+			continue
+		}
+		trainset = append(trainset, trainingExample{
+			Inputs:  payload.Inputs,
+			Outputs: map[string]string{field: answer},
+		})
+	}
+	if len(trainset) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoTrainingSample, dir)
+	}
+	return trainset, nil
+}
+
+func readSampleProgram(path string) (*ail.Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ail.Decode(bytes.NewReader(data))
+}
+
+// responseText joins the text of a response program's assistant message.
+func responseText(prog *ail.Program) string {
+	var b strings.Builder
+	for _, inst := range prog.Code {
+		if inst.Op == ail.TXT_CHUNK {
+			b.WriteString(inst.Str)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// ─── Compiled-program cache ──────────────────────────────────────────────────
+
+// compiledCache keeps compiled programs in memory and, when
+// DSPY_COMPILED_DIR is set, as <name>.json files there, so they survive
+// restarts and are shared by routers reading the same directory.
+type compiledCache struct {
+	mu       sync.Mutex
+	programs map[string]*CompiledProgram
+}
+
+var compiled = &compiledCache{programs: map[string]*CompiledProgram{}}
+
+func compiledPath(name string) string {
+	if dir := os.Getenv("DSPY_COMPILED_DIR"); dir != "" {
+		return filepath.Join(dir, name+".json")
+	}
+	return ""
+}
+
+func (c *compiledCache) put(cp *CompiledProgram) error {
+	if path := compiledPath(cp.Name); path != "" {
+		data, err := json.Marshal(cp)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	c.programs[cp.Name] = cp
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *compiledCache) get(name string) (*CompiledProgram, error) {
+	if !compiledNameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrNoCompiled, name)
+	}
+	c.mu.Lock()
+	cp, ok := c.programs[name]
+	c.mu.Unlock()
+	if ok {
+		return cp, nil
+	}
+	path := compiledPath(name)
+	if path == "" {
+		return nil, fmt.Errorf("%w: %q", ErrNoCompiled, name)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrNoCompiled, name)
+	} else if err != nil {
+		return nil, err
+	}
+	cp = &CompiledProgram{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("compiled program %q: %w", name, err)
+	}
+	c.mu.Lock()
+	c.programs[name] = cp
+	c.mu.Unlock()
+	return cp, nil
+}
+
+// GetCompiled returns the compiled program called name.
+func GetCompiled(name string) (*CompiledProgram, error) {
+	return compiled.get(name)
+}
+
+// ListCompiled returns the names of the compiled programs, sorted.
+func ListCompiled() []string {
+	names := map[string]bool{}
+	compiled.mu.Lock()
+	for name := range compiled.programs {
+		names[name] = true
+	}
+	compiled.mu.Unlock()
+	if dir := os.Getenv("DSPY_COMPILED_DIR"); dir != "" {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if name, ok := strings.CutSuffix(e.Name(), ".json"); ok && compiledNameRe.MatchString(name) {
+				names[name] = true
+			}
+		}
+	}
+	out := make([]string, 0, len(names))
+	for name := range names {
+		out = append(out, name)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// DeleteCompiled removes the compiled program called name.
+func DeleteCompiled(name string) error {
+	if _, err := compiled.get(name); err != nil {
+		return err
+	}
+	if path := compiledPath(name); path != "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	compiled.mu.Lock()
+	delete(compiled.programs, name)
+	compiled.mu.Unlock()
+	return nil
+}
+
+func getCompileTimeout() time.Duration {
+	if t := os.Getenv("DSPY_COMPILE_TIMEOUT"); t != "" {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultCompileTimeout
+}
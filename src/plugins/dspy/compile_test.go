@@ -0,0 +1,139 @@
+package dspy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// writeSample stores a sampled request and, if answer is not empty, its
+// response under dir/name.
+func writeSample(t *testing.T, dir, name, question, answer string) {
+	t.Helper()
+	progs := map[string]string{
+		"request.ail": "SET_MODEL openai/gpt-4o\nMSG_START\nROLE_USR\nTXT_CHUNK " + question + "\nMSG_END\n",
+	}
+	if answer != "" {
+		progs["response.ail"] = "MSG_START\nROLE_AST\nTXT_CHUNK " + answer + "\nMSG_END\n"
+	}
+	for file, src := range progs {
+		prog, err := ail.Asm(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := prog.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, file), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	corpus := t.TempDir()
+	writeSample(t, corpus, "a", "2+2?", "4")
+	writeSample(t, corpus, "b", "capital of France?", "Paris")
+	writeSample(t, corpus, "c", "unanswered", "")
+
+	var got sidecarCompileRequest
+	var invoked sidecarRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/compile":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"state": {"predict": {"demos": [{"question": "2+2?", "answer": "4"}]}}}`))
+		case "/invoke":
+			json.NewDecoder(r.Body).Decode(&invoked)
+			w.Write([]byte(`{"outputs": {"answer": "4"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	t.Setenv("DSPY_COMPILED_DIR", filepath.Join(t.TempDir(), "compiled"))
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	cp, err := Compile(t.Context(), CompileRequest{
+		Name:      "math",
+		Kind:      "predict",
+		Signature: "question -> answer",
+		Model:     "openai/gpt-4o+dspy",
+		Optimizer: "mipro",
+		Dir:       corpus,
+	}, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Optimizer != "MIPROv2" || got.Model != "openai/gpt-4o" || len(got.Trainset) != 2 {
+		t.Fatalf("sidecar got %+v", got)
+	}
+	if ex := got.Trainset[0]; ex.Inputs["question"] != "2+2?" || ex.Outputs["answer"] != "4" {
+		t.Errorf("example = %+v", ex)
+	}
+	if cp.Examples != 2 || !strings.Contains(string(cp.State), "demos") {
+		t.Errorf("compiled = %+v", cp)
+	}
+
+	// The cache survives a restart through DSPY_COMPILED_DIR.
+	compiled.mu.Lock()
+	delete(compiled.programs, "math")
+	compiled.mu.Unlock()
+	if names := ListCompiled(); len(names) != 1 || names[0] != "math" {
+		t.Errorf("compiled programs = %v", names)
+	}
+
+	prog, _ := ail.Asm("SET_MODEL openai/gpt-4o+dspy:@math\nMSG_START\nROLE_USR\nTXT_CHUNK \"3+3?\"\nMSG_END\n")
+	w := httptest.NewRecorder()
+	handled, err := (&DSPy{}).RecursiveHandler("@math", nil, prog, w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if !handled || err != nil {
+		t.Fatalf("handled=%v err=%v", handled, err)
+	}
+	if invoked.Kind != "predict" || invoked.Compiled != "math" || !strings.Contains(string(invoked.Program), "demos") {
+		t.Errorf("invoke payload = %+v", invoked)
+	}
+
+	if err := DeleteCompiled("math"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetCompiled("math"); !errors.Is(err, ErrNoCompiled) {
+		t.Errorf("after delete: %v", err)
+	}
+	_, err = (&DSPy{}).RecursiveHandler("@math", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err == nil || !strings.Contains(err.Error(), "no such compiled program") {
+		t.Errorf("unknown compiled program: %v", err)
+	}
+}
+
+func TestCompileRequest_Validate(t *testing.T) {
+	for _, req := range []CompileRequest{
+		{Name: "../x", Model: "m", Dir: "d"},
+		{Name: "x", Model: "m", Dir: "d", Kind: "Magic!"},
+		{Name: "x", Model: "m", Dir: "d", Optimizer: "gepa"},
+		{Name: "x", Dir: "d"},
+		{Name: "x", Model: "m"},
+	} {
+		if err := req.Validate(); !errors.Is(err, ErrBadCompile) {
+			t.Errorf("%+v: %v", req, err)
+		}
+	}
+	req := CompileRequest{Name: "x", Model: "m", Dir: "d"}
+	if err := req.Validate(); err != nil || req.Kind != "cot" || req.Optimizer != "bootstrap" {
+		t.Errorf("defaults: %+v, %v", req, err)
+	}
+}
@@ -11,28 +11,84 @@
 //	+dspy:react                                        → ReAct agent (tool use)
 //	+dspy:predict                                      → bare Predict
 //	+dspy:rlm                                          → Recursive Language Model
+//	+dspy:pot                                          → any other kind a sidecar offers
 //	+dspy:cot:context,%20question%20->%20answer         → custom signature (URL-encoded)
+//	+dspy:cot:@invoice_extractor                       → named signature "invoice_extractor"
+//	+dspy:@support-faq                                 → compiled program "support-faq"
+//
+// With +dspy:react, the tools of the router's tool plugins (websearch,
+// rag, kvmem, …) in the chain are offered to the module next to the
+// client's; the sidecar streams their calls back, they run here, and the
+// results go back to it (POST /tool_result). A call to one of the
+// client's tools ends the response with finish_reason "tool_calls"; the
+// client sends the result in its next turn, which ReAct picks up from the
+// history.
+//
+// DSPY_KINDS (comma-separated) replaces the built-in kinds above. Other
+// kinds go to the sidecars that list them in their GET /health, so new
+// DSPy modules need no router release.
+//
+// Requests may also carry a "dspy" object, {"kind", "signature",
+// "max_iters", "temperature", "fallback"}, whose kind and signature
+// override the suffix; see requestOptions.
 //
 // The sidecar must be running and reachable at DSPY_SIDECAR_URL (default
 // http://localhost:8780).  It receives LM-callback credentials so its
-// own dspy.LM calls route back through the router.
+// own dspy.LM calls route back through the router. DSPY_SIDECAR_URL may
+// list several sidecars, comma-separated; calls go to them round-robin.
+// Their GET /health is probed every DSPY_HEALTH_INTERVAL (default 10s);
+// a sidecar that is down gets no calls, and while all are down requests
+// fail at once. Calls share a pool of keep-alive connections
+// (DSPY_MAX_IDLE_CONNS, default 64) and dial within DSPY_CONNECT_TIMEOUT
+// (default 10s); DSPY_TIMEOUT (default 5m) bounds a whole call.
+//
+// With DSPY_FALLBACK, or the request's "fallback" option, set to plain,
+// a request the sidecars fail before its response started (all down, or
+// the call erroring) is served by plain inference instead; cot also adds
+// a system prompt asking for step-by-step reasoning and the signature's
+// outputs, so the endpoint stays usable through sidecar deploys.
+//
+// With DSPY_SIDECAR_SECRET set, on the router and the sidecars alike,
+// every call to a sidecar carries it in X-DSPy-Sidecar-Secret and the
+// sidecar refuses calls without it, so its port cannot be used to make
+// the router run requests on forwarded credentials. The sidecar's LM
+// calls carry it back, and CheckCallback rejects a callback whose secret
+// is wrong. DSPY_TLS_CA_FILE, DSPY_TLS_CERT_FILE and DSPY_TLS_KEY_FILE
+// add TLS, mutual when the sidecar asks for a client certificate.
+//
+// The trace context of a request (traceparent, tracestate) is passed on
+// to the sidecar and its LM calls, and so is the time left before the
+// client's deadline (X-Request-Timeout). Responses report the token usage of
+// those inner calls, which the router also accounts as requests of their
+// own; ai_router_dspy_* metrics cover runs, sidecar errors and LM calls
+// per kind.
+//
+// Compiled programs come from running a DSPy optimizer (BootstrapFewShot,
+// MIPROv2) over sampled requests through POST /ai/dspy/compile on the
+// admin endpoint (DSPY_COMPILE_TIMEOUT, default 30m); they are kept in
+// memory and, with DSPY_COMPILED_DIR, on disk.
 package dspy
 
 import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/neutrome-labs/ail"
 	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
 	"github.com/neutrome-labs/open-ai-router/src/sse"
+	"github.com/neutrome-labs/open-ai-router/src/styles"
 	"go.uber.org/zap"
 )
 
@@ -44,7 +100,8 @@ const (
 	defaultTimeout   = 5 * time.Minute
 )
 
-// validKinds is the set of DSPy module kinds the sidecar understands.
+// validKinds is the set of DSPy module kinds the sidecar understands,
+// unless DSPY_KINDS says otherwise; see checkKind.
 var validKinds = map[string]bool{
 	"predict": true,
 	"cot":     true,
@@ -61,7 +118,7 @@ type DSPy struct{}
 func (d *DSPy) Name() string { return "dspy" }
 
 // dspyRecursionGuard prevents re-entrant calls when the sidecar calls
-// back into the router.
+// back into the router; CheckCallback sets it on authenticated callbacks.
 type dspyRecursionGuard struct{}
 
 // ─── RecursiveHandler ────────────────────────────────────────────────────────
@@ -80,75 +137,202 @@ func (d *DSPy) RecursiveHandler(
 	}
 
 	kind, signature := parseParams(params)
-	if !validKinds[kind] {
+	opts, err := takeRequestOptions(prog)
+	if err != nil {
+		return true, &services.RouterError{
+			Kind:    services.ErrorPlugin,
+			Status:  http.StatusBadRequest,
+			Message: "dspy: " + err.Error(),
+		}
+	}
+	if opts.Kind != "" {
+		kind = opts.Kind
+	}
+	if opts.Signature != "" {
+		signature = opts.Signature
+	}
+	var compiledProg *CompiledProgram
+	if name, ok := strings.CutPrefix(params, "@"); ok {
+		cp, err := GetCompiled(name)
+		if err != nil {
+			return true, &services.RouterError{
+				Kind:    services.ErrorPlugin,
+				Status:  http.StatusBadRequest,
+				Message: "dspy: " + err.Error(),
+			}
+		}
+		kind, signature, compiledProg = cp.Kind, cp.Signature, cp
+	}
+	var named *Signature
+	if name, ok := strings.CutPrefix(signature, "@"); ok && compiledProg == nil {
+		sig, err := LookupSignature(name)
+		if err != nil {
+			return true, &services.RouterError{
+				Kind:    services.ErrorPlugin,
+				Status:  http.StatusBadRequest,
+				Message: "dspy: " + err.Error(),
+			}
+		}
+		signature, named = sig.Signature, sig
+	}
+	if err := checkKind(kind); err != nil {
 		plugin.Logger.Error("dspy: unknown kind", zap.String("kind", kind))
-		http.Error(w, fmt.Sprintf("dspy: unknown kind %q", kind), http.StatusBadRequest)
-		return true, nil
+		return true, &services.RouterError{
+			Kind:    services.ErrorPlugin,
+			Status:  http.StatusBadRequest,
+			Message: "dspy: " + err.Error(),
+		}
 	}
 
 	// Build the sidecar request payload.
 	payload, err := buildSidecarPayload(kind, signature, prog)
 	if err != nil {
 		plugin.Logger.Error("dspy: failed to build payload", zap.Error(err))
-		http.Error(w, "dspy: "+err.Error(), http.StatusInternalServerError)
-		return true, nil
+		return true, fmt.Errorf("dspy: %w", err)
+	}
+	if compiledProg != nil {
+		payload.Compiled, payload.Program = compiledProg.Name, compiledProg.State
+	}
+	payload.signatureDetails = named.details()
+	payload.MaxIters, payload.Temperature = opts.MaxIters, opts.Temperature
+
+	// ReAct with tools runs as a session: it can use the router's own
+	// tools, and pauses on a call to one of the client's.
+	var tools *routerToolSet
+	if kind == "react" {
+		if tools = collectRouterTools(ic, prog, r); tools != nil {
+			payload.Tools = tools.clientTools(payload.Tools)
+			payload.RouterTools = tools.defs
+		}
+		if tools != nil || len(payload.Tools) > 0 {
+			payload.Session = uuid.New().String()
+			if tools != nil {
+				tools.session = payload.Session
+			}
+		}
 	}
 
 	// Forward auth from the original request so the sidecar's LM calls
-	// are attributed to the same user.
-	authHeader := r.Header.Get("Authorization")
+	// are attributed to the same user, and the trace ID for its logs.
+	sidecarHeader := http.Header{}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sidecarHeader.Set("X-Upstream-Authorization", auth)
+	}
+	if traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string); traceID != "" {
+		sidecarHeader.Set(plugin.RequestIDHeader, traceID)
+	}
+	copyTraceHeaders(sidecarHeader, r.Header)
+	if left, ok := services.RemainingTimeout(r.Context()); ok {
+		sidecarHeader.Set(services.RequestTimeoutHeader, left)
+	}
 
-	sidecarURL := getSidecarURL()
 	timeout := getTimeout()
+	start := time.Now()
+
+	// Don't wait on sidecars known to be down, or to lack the kind.
+	pool := getSidecarPool()
+	pool.start()
+	skip := pool.lacking(kind)
+	target := pool.pick(skip)
+	if target == nil {
+		services.ObserveDSPyError(kind, "unreachable")
+		if mode := getFallback(opts); mode != fallbackNone {
+			plugin.Logger.Debug("dspy: sidecars down, falling back to plain inference", zap.String("mode", mode))
+			services.ObserveDSPy(kind, "fallback", start)
+			degrade(prog, mode, signature, named)
+			return false, nil
+		}
+		services.ObserveDSPy(kind, "error", start)
+		return true, &services.RouterError{
+			Kind:    services.ErrorPlugin,
+			Status:  http.StatusServiceUnavailable,
+			Message: "dspy: " + pool.unavailableError().Error(),
+		}
+	}
 
 	// Resolve emitters for the client-facing format.
 	clientStyle := plugin.ClientStyleFromContext(r.Context())
+	sw := &startedWriter{ResponseWriter: w}
 
 	if prog.IsStreaming() {
 		chunkEmitter, emErr := ail.GetStreamChunkEmitter(clientStyle)
 		if emErr != nil {
 			plugin.Logger.Error("dspy: no stream chunk emitter", zap.Error(emErr))
-			http.Error(w, "dspy: "+emErr.Error(), http.StatusInternalServerError)
-			return true, nil
+			return true, fmt.Errorf("dspy: %w", emErr)
+		}
+		keepalive := plugin.SSEKeepaliveFromContext(r.Context())
+		err = d.handleStreaming(r.Context(), target.url, timeout, keepalive, payload, tools, sidecarHeader, sw, chunkEmitter)
+		if errors.Is(err, errSidecarUnreachable) {
+			target.report(err)
 		}
-		err = d.handleStreaming(sidecarURL, timeout, payload, authHeader, w, chunkEmitter)
 	} else {
-		respEmitter, emErr := ail.GetResponseEmitter(clientStyle)
+		respEmitter, emErr := styles.GetResponseEmitter(clientStyle)
 		if emErr != nil {
 			plugin.Logger.Error("dspy: no response emitter", zap.Error(emErr))
-			http.Error(w, "dspy: "+emErr.Error(), http.StatusInternalServerError)
-			return true, nil
+			return true, fmt.Errorf("dspy: %w", emErr)
+		}
+		// Nothing has been written yet when a sidecar turns out to be
+		// unreachable, so the call moves on to the next one.
+		tried := maps.Clone(skip)
+		for target != nil {
+			tried[target] = true
+			err = d.handleNonStreaming(r.Context(), target.url, timeout, payload, tools, sidecarHeader, sw, respEmitter)
+			if !errors.Is(err, errSidecarUnreachable) {
+				break
+			}
+			target.report(err)
+			services.ObserveDSPyError(kind, "unreachable")
+			target = pool.pick(tried)
 		}
-		err = d.handleNonStreaming(sidecarURL, timeout, payload, authHeader, w, respEmitter)
 	}
 	if err != nil {
+		// Failover already counted the unreachable sidecars it skipped.
+		if !errors.Is(err, errSidecarUnreachable) || prog.IsStreaming() {
+			services.ObserveDSPyError(kind, errorReason(err))
+		}
+		// Until the response starts, a failed call can still be served
+		// by plain inference, e.g. while the sidecars are redeployed.
+		if mode := getFallback(opts); mode != fallbackNone && canFallBack(r.Context(), err, sw.started) {
+			plugin.Logger.Warn("dspy: sidecar call failed, falling back to plain inference", zap.String("mode", mode), zap.Error(err))
+			services.ObserveDSPy(kind, "fallback", start)
+			w.Header().Del("X-DSPy-Kind")
+			degrade(prog, mode, signature, named)
+			return false, nil
+		}
+		services.ObserveDSPy(kind, "error", start)
 		plugin.Logger.Error("dspy: sidecar call failed", zap.Error(err))
-		// If headers haven't been written yet, return a proper HTTP error.
-		// If streaming already started, the SSE error was already emitted
-		// inside handleStreaming, so just return the Go error for logging.
+		// The endpoint reports it as a plugin_error: as an error body if
+		// nothing was written yet, otherwise as a final stream event.
 		return true, fmt.Errorf("dspy: sidecar error: %w", err)
 	}
+	services.ObserveDSPy(kind, "ok", start)
 	return true, nil
 }
 
 // ─── Non-streaming path ─────────────────────────────────────────────────────
 
 func (d *DSPy) handleNonStreaming(
+	ctx context.Context,
 	sidecarURL string,
 	timeout time.Duration,
 	payload *sidecarRequest,
-	authHeader string,
+	tools *routerToolSet,
+	sidecarHeader http.Header,
 	w http.ResponseWriter,
 	respEmitter ail.ResponseEmitter,
 ) error {
-	payload.Stream = false
+	// Tool calls can only come back as events, so a session streams and
+	// the prediction, or the client tool call, is collected here.
+	payload.Stream = payload.Session != ""
 
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// Derived from the client request so a disconnect aborts the sidecar
+	// call (and its LM calls back into the router).
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "POST", sidecarURL+"/invoke", bytes.NewReader(body))
@@ -156,13 +340,13 @@ func (d *DSPy) handleNonStreaming(
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if authHeader != "" {
-		req.Header.Set("X-Upstream-Authorization", authHeader)
+	for k, v := range sidecarHeader {
+		req.Header[k] = v
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doSidecar(req)
 	if err != nil {
-		return fmt.Errorf("sidecar POST: %w", err)
+		return sidecarCallError(ctx, err)
 	}
 	defer resp.Body.Close()
 
@@ -172,12 +356,17 @@ func (d *DSPy) handleNonStreaming(
 	}
 
 	var sResp sidecarResponse
-	if err := json.NewDecoder(resp.Body).Decode(&sResp); err != nil {
+	if payload.Stream {
+		if err := collectStream(ctx, resp.Body, sidecarURL, sidecarHeader, tools, &sResp); err != nil {
+			return err
+		}
+	} else if err := json.NewDecoder(resp.Body).Decode(&sResp); err != nil {
 		return fmt.Errorf("decode sidecar response: %w", err)
 	}
 
 	// Build an AIL response program from the sidecar prediction.
 	resProg := buildResponseProgram(payload.Model, payload.Signature, &sResp)
+	observeUsage(ctx, payload.Kind, sResp.Usage, resProg)
 
 	resData, err := respEmitter.EmitResponse(resProg)
 	if err != nil {
@@ -193,10 +382,13 @@ func (d *DSPy) handleNonStreaming(
 // ─── Streaming path ──────────────────────────────────────────────────────────
 
 func (d *DSPy) handleStreaming(
+	ctx context.Context,
 	sidecarURL string,
 	timeout time.Duration,
+	keepalive time.Duration,
 	payload *sidecarRequest,
-	authHeader string,
+	tools *routerToolSet,
+	sidecarHeader http.Header,
 	w http.ResponseWriter,
 	chunkEmitter ail.StreamChunkEmitter,
 ) error {
@@ -207,7 +399,7 @@ func (d *DSPy) handleStreaming(
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "POST", sidecarURL+"/invoke", bytes.NewReader(body))
@@ -216,13 +408,19 @@ func (d *DSPy) handleStreaming(
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "text/event-stream")
-	if authHeader != "" {
-		req.Header.Set("X-Upstream-Authorization", authHeader)
+	for k, v := range sidecarHeader {
+		req.Header[k] = v
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// The sidecar can stay silent for minutes before its first event, so
+	// the stream (and its keepalives) starts before the call.
+	sseWriter := sse.NewWriter(w)
+	w.Header().Set("X-DSPy-Kind", payload.Kind)
+	defer sseWriter.StartKeepalive(keepalive)()
+
+	resp, err := doSidecar(req)
 	if err != nil {
-		return fmt.Errorf("sidecar POST: %w", err)
+		return sidecarCallError(ctx, err)
 	}
 	defer resp.Body.Close()
 
@@ -231,17 +429,16 @@ func (d *DSPy) handleStreaming(
 		return fmt.Errorf("sidecar returned %d: %s", resp.StatusCode, string(respBody))
 	}
 
-	sseWriter := sse.NewWriter(w)
 	if err := sseWriter.WriteHeartbeat("ok"); err != nil {
 		return err
 	}
 
-	w.Header().Set("X-DSPy-Kind", payload.Kind)
-
 	reader := sse.NewDefaultReader(resp.Body)
 	events := reader.ReadEvents()
 
 	chunkIndex := 0
+	toolCalls := 0
+	var usage *sidecarUsage
 	var streamErr error
 
 	for ev := range events {
@@ -249,7 +446,6 @@ func (d *DSPy) handleStreaming(
 			break
 		}
 		if ev.Error != nil {
-			_ = sseWriter.WriteError(ev.Error.Error())
 			streamErr = ev.Error
 			break
 		}
@@ -287,8 +483,8 @@ func (d *DSPy) handleStreaming(
 			}
 
 		case "tool_call":
-			// Tool call from ReAct — emit as a tool_calls delta.
-			chunkProg := buildStreamToolCall(payload.Model, &sEvent)
+			// ReAct paused on a client tool — emit as a tool_calls delta.
+			chunkProg := buildStreamToolCall(payload.Model, toolCalls, &sEvent)
 			chunkData, err := chunkEmitter.EmitStreamChunk(chunkProg)
 			if err != nil {
 				continue
@@ -296,8 +492,19 @@ func (d *DSPy) handleStreaming(
 			if err := sseWriter.WriteRaw(chunkData); err != nil {
 				return err
 			}
+			toolCalls++
+
+		case "router_tool_call":
+			// ReAct waits on an on-router tool; the client never sees it.
+			if tools == nil {
+				return fmt.Errorf("sidecar called router tool %q, none offered", sEvent.ToolName)
+			}
+			if err := tools.dispatch(ctx, sidecarURL, sidecarHeader, &sEvent); err != nil {
+				return err
+			}
 
 		case "prediction":
+			usage = sEvent.Usage
 			// If no chunks were streamed yet (dspy.streamify may skip
 			// incremental deltas and emit only a final Prediction),
 			// emit the prediction content as stream chunks so the
@@ -337,13 +544,33 @@ func (d *DSPy) handleStreaming(
 			if errMsg == "" {
 				errMsg = "unknown sidecar error"
 			}
-			_ = sseWriter.WriteError(errMsg)
 			streamErr = fmt.Errorf("sidecar stream error: %s", errMsg)
 		}
 	}
 
+	// On error the endpoint ends the stream with the error event.
+	if streamErr != nil {
+		return streamErr
+	}
+	if toolCalls > 0 {
+		// The client runs its tool and sends the result in a new turn.
+		if chunkData, err := chunkEmitter.EmitStreamChunk(buildStreamFinish(payload.Model, "tool_calls")); err == nil {
+			if err := sseWriter.WriteRaw(chunkData); err != nil {
+				return err
+			}
+		}
+	}
+	if usage != nil {
+		chunkProg := buildStreamUsage(payload.Model, usage)
+		observeUsage(ctx, payload.Kind, usage, chunkProg)
+		if chunkData, err := chunkEmitter.EmitStreamChunk(chunkProg); err == nil {
+			if err := sseWriter.WriteRaw(chunkData); err != nil {
+				return err
+			}
+		}
+	}
 	_ = sseWriter.WriteDone()
-	return streamErr
+	return nil
 }
 
 // ─── Params parsing ──────────────────────────────────────────────────────────
@@ -395,6 +622,28 @@ type sidecarRequest struct {
 	Model     string            `json:"model"`
 	Stream    bool              `json:"stream"`
 	AuthToken string            `json:"auth_token,omitempty"`
+	signatureDetails
+
+	// RouterTools are on-router tools offered to ReAct; the sidecar calls
+	// them back through router_tool_call events tagged with Session.
+	RouterTools []sidecarToolDef `json:"router_tools,omitempty"`
+	Session     string           `json:"session,omitempty"`
+
+	// From the request's dspy object.
+	MaxIters    int      `json:"max_iters,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// Compiled and Program name and carry the state of a compiled
+	// program, which the sidecar loads into the module it builds.
+	Compiled string          `json:"compiled,omitempty"`
+	Program  json.RawMessage `json:"program,omitempty"`
+}
+
+// signatureDetails carries what a named signature adds to its text.
+type signatureDetails struct {
+	Instructions string              `json:"instructions,omitempty"`
+	Fields       map[string]string   `json:"fields,omitempty"`
+	Demos        []map[string]string `json:"demos,omitempty"`
 }
 
 type sidecarToolDef struct {
@@ -406,6 +655,7 @@ type sidecarToolDef struct {
 type sidecarResponse struct {
 	Outputs   map[string]string `json:"outputs"`
 	ToolCalls []sidecarToolCall `json:"tool_calls,omitempty"`
+	Usage     *sidecarUsage     `json:"usage,omitempty"`
 }
 
 type sidecarToolCall struct {
@@ -415,18 +665,19 @@ type sidecarToolCall struct {
 }
 
 type sidecarStreamEvent struct {
-	Type    string `json:"type"`              // "chunk", "status", "tool_call", "prediction"
+	Type    string `json:"type"`              // "chunk", "status", "tool_call", "router_tool_call", "prediction"
 	Field   string `json:"field,omitempty"`   // for "chunk": signature field name
 	Text    string `json:"text,omitempty"`    // for "chunk": token content
 	Message string `json:"message,omitempty"` // for "status"
 
-	// For "tool_call"
+	// For "tool_call" and "router_tool_call"
 	CallID   string          `json:"call_id,omitempty"`
 	ToolName string          `json:"tool_name,omitempty"`
 	ToolArgs json.RawMessage `json:"tool_args,omitempty"`
 
 	// For "prediction"
 	Outputs map[string]string `json:"outputs,omitempty"`
+	Usage   *sidecarUsage     `json:"usage,omitempty"`
 }
 
 // buildSidecarPayload extracts inputs from the AIL program for the sidecar.
@@ -468,9 +719,7 @@ func buildSidecarPayload(kind, signature string, prog *ail.Program) (*sidecarReq
 	// Extract tool definitions for ReAct.
 	var tools []sidecarToolDef
 	if kind == "react" {
-		for _, td := range prog.ToolDefs() {
-			tools = append(tools, extractToolDef(prog, td))
-		}
+		tools = extractToolDefs(prog.Code)
 	}
 
 	return &sidecarRequest{
@@ -508,7 +757,7 @@ func buildHistory(prog *ail.Program) []historyMessage {
 		default:
 			continue
 		}
-		text := prog.MessageText(msg)
+		text := historyText(prog, msg)
 		if text == "" {
 			continue
 		}
@@ -517,22 +766,51 @@ func buildHistory(prog *ail.Program) []historyMessage {
 	return history
 }
 
+// historyText returns the content of msg for the history, including the
+// tool calls of an assistant turn and the result in a tool message, so
+// that ReAct resumes from a client tool's result on the next turn.
+func historyText(prog *ail.Program, msg ail.MessageSpan) string {
+	parts := []string{}
+	if text := prog.MessageText(msg); text != "" {
+		parts = append(parts, text)
+	}
+	for i := msg.Start; i <= msg.End && i < len(prog.Code); i++ {
+		switch inst := prog.Code[i]; inst.Op {
+		case ail.CALL_NAME:
+			parts = append(parts, "[tool call] "+inst.Str)
+		case ail.CALL_ARGS:
+			if n := len(parts); n > 0 {
+				parts[n-1] += " " + string(inst.JSON)
+			}
+		case ail.RESULT_DATA:
+			parts = append(parts, inst.Str)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
 // ─── Signature parsing ───────────────────────────────────────────────────────
 
-// extractToolDef converts a ToolDefSpan into a sidecarToolDef.
-// ailmanip exposes Name via the span; DEF_DESC and DEF_SCHEMA require a
-// focused range scan since ToolDefSpan does not surface them directly.
-func extractToolDef(prog *ail.Program, td ail.ToolDefSpan) sidecarToolDef {
-	def := sidecarToolDef{Name: td.Name}
-	for i := td.Start; i <= td.End && i < len(prog.Code); i++ {
-		switch prog.Code[i].Op {
+// extractToolDefs converts tool definitions into sidecarToolDefs. It goes
+// by DEF_NAME rather than ToolDefSpan, which only names the first tool of
+// a DEF_START..DEF_END block, and the chat parser puts them all in one.
+func extractToolDefs(code []ail.Instruction) []sidecarToolDef {
+	var defs []sidecarToolDef
+	for _, inst := range code {
+		switch inst.Op {
+		case ail.DEF_NAME:
+			defs = append(defs, sidecarToolDef{Name: inst.Str})
 		case ail.DEF_DESC:
-			def.Description = prog.Code[i].Str
+			if len(defs) > 0 {
+				defs[len(defs)-1].Description = inst.Str
+			}
 		case ail.DEF_SCHEMA:
-			def.Schema = prog.Code[i].JSON
+			if len(defs) > 0 {
+				defs[len(defs)-1].Schema = inst.JSON
+			}
 		}
 	}
-	return def
+	return defs
 }
 
 // parseSignatureFields splits "a, b -> c, d" into input fields and output fields.
@@ -625,6 +903,9 @@ func buildResponseProgram(model, signature string, resp *sidecarResponse) *ail.P
 	}
 
 	prog.Emit(ail.MSG_END)
+	if resp.Usage != nil {
+		resp.Usage.emit(prog)
+	}
 	return prog
 }
 
@@ -647,12 +928,12 @@ func buildStreamChunk(model, field, text string, isFirst bool) *ail.Program {
 }
 
 // buildStreamToolCall creates an AIL stream chunk for a tool call delta.
-func buildStreamToolCall(model string, ev *sidecarStreamEvent) *ail.Program {
+func buildStreamToolCall(model string, index int, ev *sidecarStreamEvent) *ail.Program {
 	prog := ail.NewProgram()
 	prog.EmitString(ail.RESP_MODEL, model)
 
 	toolDelta := map[string]any{
-		"index": 0,
+		"index": index,
 		"id":    ev.CallID,
 		"name":  ev.ToolName,
 	}
@@ -665,13 +946,31 @@ func buildStreamToolCall(model string, ev *sidecarStreamEvent) *ail.Program {
 	return prog
 }
 
+// buildStreamFinish creates the final chunk of a stream, carrying its
+// finish reason.
+func buildStreamFinish(model, reason string) *ail.Program {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.RESP_MODEL, model)
+	prog.EmitString(ail.RESP_DONE, reason)
+	prog.Emit(ail.STREAM_END)
+	return prog
+}
+
 // ─── Config helpers ──────────────────────────────────────────────────────────
 
-func getSidecarURL() string {
-	if u := os.Getenv("DSPY_SIDECAR_URL"); u != "" {
-		return strings.TrimRight(u, "/")
+// getSidecarURLs returns the sidecars listed, comma-separated, in
+// DSPY_SIDECAR_URL.
+func getSidecarURLs() []string {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("DSPY_SIDECAR_URL"), ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return []string{"http://localhost:8780"}
 	}
-	return "http://localhost:8780"
+	return urls
 }
 
 func getTimeout() time.Duration {
@@ -0,0 +1,107 @@
+package dspy
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Fallback modes, for requests the sidecars fail to serve before any of
+// the response was written: fail them (none), serve them by plain
+// inference (plain), or by plain inference asked to reason step by step
+// as ChainOfThought would (cot).
+const (
+	fallbackNone  = "none"
+	fallbackPlain = "plain"
+	fallbackCoT   = "cot"
+)
+
+var fallbackModes = map[string]bool{fallbackNone: true, fallbackPlain: true, fallbackCoT: true}
+
+// getFallback returns the fallback mode of a request: its dspy "fallback"
+// option, else DSPY_FALLBACK, else none.
+func getFallback(opts *requestOptions) string {
+	if opts != nil && opts.Fallback != "" {
+		return opts.Fallback
+	}
+	if mode := os.Getenv("DSPY_FALLBACK"); fallbackModes[mode] {
+		return mode
+	}
+	return fallbackNone
+}
+
+// canFallBack reports whether a sidecar call that failed with err can
+// still be served by plain inference: nothing was written yet, and the
+// failure, classified by services.Classify, is not one plain inference
+// would hit too, such as the client having gone or run out of time.
+func canFallBack(ctx context.Context, err error, started bool) bool {
+	if started || services.ClientGone(ctx, err) {
+		return false
+	}
+	if cause := context.Cause(ctx); cause != nil {
+		err = cause
+	}
+	return services.Classify(err) != services.Fatal
+}
+
+// degrade prepares prog for plain inference in mode: with cot it gains a
+// system prompt standing in for the module.
+func degrade(prog *ail.Program, mode, signature string, named *Signature) {
+	if mode == fallbackCoT {
+		prog.Code = prog.PrependSystemPrompt(cotPrompt(signature, named)).Code
+	}
+}
+
+// cotPrompt synthesizes a system prompt asking for what a ChainOfThought
+// module over signature produces: reasoning first, then its outputs.
+func cotPrompt(signature string, named *Signature) string {
+	var b strings.Builder
+	if named != nil && named.Instructions != "" {
+		b.WriteString(named.Instructions)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("Think through the request step by step before answering: write out your reasoning first, then give your final answer.")
+	_, outputs := parseSignatureFields(signature)
+	if len(outputs) == 1 && outputs[0] == "answer" {
+		return b.String()
+	}
+	b.WriteString(" End with these fields, each on its own line as \"name: value\":")
+	for _, f := range outputs {
+		b.WriteString("\n- " + f)
+		if named != nil && named.Fields[f] != "" {
+			b.WriteString(" — " + named.Fields[f])
+		}
+	}
+	return b.String()
+}
+
+// startedWriter records whether any of the response was written, after
+// which a failed call can no longer fall back.
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *startedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+var _ http.Flusher = (*startedWriter)(nil)
@@ -0,0 +1,106 @@
+package dspy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestGetFallback(t *testing.T) {
+	if got := getFallback(&requestOptions{}); got != fallbackNone {
+		t.Errorf("default = %q", got)
+	}
+	t.Setenv("DSPY_FALLBACK", "cot")
+	if got := getFallback(&requestOptions{}); got != fallbackCoT {
+		t.Errorf("DSPY_FALLBACK = %q", got)
+	}
+	if got := getFallback(&requestOptions{Fallback: "none"}); got != fallbackNone {
+		t.Errorf("request option = %q", got)
+	}
+}
+
+func TestCanFallBack(t *testing.T) {
+	sidecarErr := errors.New("sidecar returned 500: module crashed")
+	if !canFallBack(context.Background(), sidecarErr, false) {
+		t.Error("sidecar failure: no fallback")
+	}
+	if canFallBack(context.Background(), sidecarErr, true) {
+		t.Error("fell back after the response started")
+	}
+	oversized := fmt.Errorf("lm call: %w", services.NewRouterError(services.ErrorContextLength, "prompt is too long"))
+	if canFallBack(context.Background(), oversized, false) {
+		t.Error("fell back on a prompt over the context window")
+	}
+	gone, cancel := context.WithCancelCause(context.Background())
+	cancel(services.ErrClientClosed)
+	if canFallBack(gone, sidecarErr, false) {
+		t.Error("fell back for a client that has gone")
+	}
+	late, stop := services.WithRequestDeadline(context.Background(), -time.Second)
+	defer stop()
+	if canFallBack(late, sidecarErr, false) {
+		t.Error("fell back past the client's deadline")
+	}
+}
+
+func TestCotPrompt(t *testing.T) {
+	if p := cotPrompt(defaultSignature, nil); !strings.Contains(p, "step by step") || strings.Contains(p, "fields") {
+		t.Errorf("default signature prompt = %q", p)
+	}
+	named := &Signature{
+		Signature:    "document -> total, currency",
+		Instructions: "Extract the invoice total.",
+		Fields:       map[string]string{"currency": "ISO 4217 code"},
+	}
+	p := cotPrompt(named.Signature, named)
+	for _, want := range []string{"Extract the invoice total.", "- total", "- currency — ISO 4217 code"} {
+		if !strings.Contains(p, want) {
+			t.Errorf("prompt lacks %q:\n%s", want, p)
+		}
+	}
+}
+
+func TestRecursiveHandler_Fallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "module crashed"}`, http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	for _, stream := range []bool{false, true} {
+		body := `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "2+2?"}], "dspy": {"fallback": "cot"}}`
+		if stream {
+			body = strings.Replace(body, "{", `{"stream": true, `, 1)
+		}
+		prog := parseChat(t, body)
+		w := httptest.NewRecorder()
+		handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, w, httptest.NewRequest(http.MethodPost, "/", nil))
+		if handled || err != nil {
+			t.Fatalf("stream=%v: handled=%v err=%v, want plain inference", stream, handled, err)
+		}
+		if w.Body.Len() != 0 || w.Header().Get("X-DSPy-Kind") != "" {
+			t.Errorf("stream=%v: response started: %q %v", stream, w.Body.String(), w.Header())
+		}
+		msgs := prog.Messages()
+		if len(msgs) != 3 || msgs[0].Role != ail.ROLE_SYS || !strings.Contains(prog.MessageText(msgs[0]), "step by step") {
+			t.Errorf("stream=%v: no CoT prompt first:\n%s", stream, prog.Disasm())
+		}
+	}
+
+	// Without a fallback the error is the client's.
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "user", "content": "2+2?"}]}`)
+	if handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil)); !handled || err == nil {
+		t.Errorf("handled=%v err=%v, want the sidecar error", handled, err)
+	}
+}
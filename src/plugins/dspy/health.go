@@ -0,0 +1,142 @@
+package dspy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	healthProbeTimeout    = 2 * time.Second
+)
+
+// errSidecarUnreachable marks sidecar calls that failed below HTTP:
+// connection refused, reset, DNS. Errors the sidecar answers with, and
+// calls that simply ran out of time, do not count.
+var errSidecarUnreachable = errors.New("sidecar unreachable")
+
+// sidecarHealth is a circuit breaker in front of one sidecar. An
+// unreachable sidecar or a failed probe of its GET /health opens it; while
+// open, the sidecar gets no requests instead of holding them up while it
+// is gone. A prober, started by the first request, checks /health every
+// interval and closes it on recovery.
+type sidecarHealth struct {
+	url string
+
+	mu      sync.Mutex
+	down    bool
+	since   time.Time
+	lastErr error
+	kinds   []string // advertised by /health; nil until it does
+
+	startOnce sync.Once
+}
+
+// available reports whether requests may go to the sidecar.
+func (h *sidecarHealth) available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.down
+}
+
+// report records the outcome of a call or probe; nil means the sidecar
+// answered.
+func (h *sidecarHealth) report(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case err != nil && !h.down:
+		h.down, h.since, h.lastErr = true, time.Now(), err
+		plugin.Logger.Warn("dspy: sidecar down, short-circuiting requests", zap.String("sidecar", h.url), zap.Error(err))
+	case err == nil && h.down:
+		plugin.Logger.Info("dspy: sidecar recovered", zap.String("sidecar", h.url), zap.Duration("down_for", time.Since(h.since)))
+		h.down, h.lastErr = false, nil
+	case err != nil:
+		h.lastErr = err
+	}
+}
+
+// unavailableError is what requests get while the breaker is open.
+func (h *sidecarHealth) unavailableError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Errorf("sidecar unavailable since %s: %v", h.since.Format(time.RFC3339), h.lastErr)
+}
+
+// start launches the prober once.
+func (h *sidecarHealth) start() {
+	h.startOnce.Do(func() {
+		go h.watch(context.Background(), h.url, getHealthInterval())
+	})
+}
+
+// watch probes the sidecar every interval until ctx is cancelled.
+func (h *sidecarHealth) watch(ctx context.Context, sidecarURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		kinds, err := probeSidecar(ctx, sidecarURL)
+		if err == nil {
+			h.setKinds(kinds)
+		}
+		h.report(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeSidecar checks the sidecar's GET /health, and returns the module
+// kinds it advertises there, if any.
+func probeSidecar(ctx context.Context, sidecarURL string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sidecarURL+"/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	signSidecarRequest(req)
+	resp, err := sidecarClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	var health struct {
+		Kinds []string `json:"kinds"`
+	}
+	// Older sidecars answer without kinds, or with no JSON at all.
+	_ = json.NewDecoder(resp.Body).Decode(&health)
+	return health.Kinds, nil
+}
+
+// sidecarCallError classifies an error from sending a sidecar request:
+// failures to reach it wrap errSidecarUnreachable.
+func sidecarCallError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("sidecar POST: %w", err)
+	}
+	return fmt.Errorf("sidecar POST: %w: %v", errSidecarUnreachable, err)
+}
+
+func getHealthInterval() time.Duration {
+	if t := os.Getenv("DSPY_HEALTH_INTERVAL"); t != "" {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultHealthInterval
+}
@@ -0,0 +1,87 @@
+package dspy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestSidecarHealth_Recovery(t *testing.T) {
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	h := &sidecarHealth{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.watch(ctx, srv.URL, 5*time.Millisecond)
+
+	waitFor := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for h.available() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("available never became %v", want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(false)
+	up.Store(true)
+	waitFor(true)
+}
+
+func TestSidecarCallError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url+"/invoke", nil)
+	_, err := http.DefaultClient.Do(req)
+	if err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if !errors.Is(sidecarCallError(context.Background(), err), errSidecarUnreachable) {
+		t.Error("connection refused should mark the sidecar unreachable")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if errors.Is(sidecarCallError(ctx, context.Canceled), errSidecarUnreachable) {
+		t.Error("a cancelled call should not mark the sidecar unreachable")
+	}
+}
+
+func TestRecursiveHandler_SidecarDown(t *testing.T) {
+	t.Setenv("DSPY_SIDECAR_URL", "http://sidecar-down.invalid")
+	h := getSidecarPool().members[0]
+	h.startOnce.Do(func() {})
+	h.report(errors.New("connection refused"))
+
+	prog, err := ail.Asm("SET_MODEL openai/gpt-4o+dspy\nMSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, httptest.NewRecorder(), r)
+	var re *services.RouterError
+	if !handled || !errors.As(err, &re) || re.Status != http.StatusServiceUnavailable {
+		t.Errorf("handled=%v err=%v, want a 503", handled, err)
+	}
+
+	t.Setenv("DSPY_FALLBACK", "plain")
+	if handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, httptest.NewRecorder(), r); handled || err != nil {
+		t.Errorf("handled=%v err=%v, want plain inference", handled, err)
+	}
+}
@@ -0,0 +1,80 @@
+package dspy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// kindNameRe is what a kind outside the configured list must look like to
+// be passed through to the sidecars.
+var kindNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// getKinds returns the kinds accepted without asking the sidecars:
+// DSPY_KINDS (comma-separated) when set, else validKinds.
+func getKinds() map[string]bool {
+	s := os.Getenv("DSPY_KINDS")
+	if s == "" {
+		return validKinds
+	}
+	kinds := map[string]bool{}
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			kinds[k] = true
+		}
+	}
+	return kinds
+}
+
+// checkKind reports whether kind can be used. A kind outside the
+// configured list goes to the sidecars that advertise it in their
+// GET /health; until one has answered, the sidecar it reaches decides.
+func checkKind(kind string) error {
+	if getKinds()[kind] {
+		return nil
+	}
+	if !kindNameRe.MatchString(kind) {
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	if getSidecarPool().rejects(kind) {
+		return fmt.Errorf("unknown kind %q: no sidecar supports it", kind)
+	}
+	return nil
+}
+
+// setKinds records the kinds the sidecar advertised; nil when it does
+// not say.
+func (h *sidecarHealth) setKinds(kinds []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.kinds = kinds
+}
+
+// lacks reports whether the sidecar advertised kinds without kind.
+func (h *sidecarHealth) lacks(kind string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.kinds != nil && !slices.Contains(h.kinds, kind)
+}
+
+// lacking returns the sidecars that cannot run kind, for pick to skip.
+// Every sidecar runs the configured kinds.
+func (p *sidecarPool) lacking(kind string) map[*sidecarHealth]bool {
+	exclude := map[*sidecarHealth]bool{}
+	if getKinds()[kind] {
+		return exclude
+	}
+	for _, h := range p.members {
+		if h.lacks(kind) {
+			exclude[h] = true
+		}
+	}
+	return exclude
+}
+
+// rejects reports whether every sidecar advertised kinds without kind.
+func (p *sidecarPool) rejects(kind string) bool {
+	return len(p.lacking(kind)) == len(p.members)
+}
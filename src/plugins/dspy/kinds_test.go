@@ -0,0 +1,100 @@
+package dspy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestGetKinds(t *testing.T) {
+	if kinds := getKinds(); !kinds["cot"] || kinds["pot"] {
+		t.Errorf("default kinds = %v", kinds)
+	}
+	t.Setenv("DSPY_KINDS", " cot, pot ,")
+	if kinds := getKinds(); len(kinds) != 2 || !kinds["cot"] || !kinds["pot"] {
+		t.Errorf("DSPY_KINDS kinds = %v", kinds)
+	}
+}
+
+func TestProbeSidecar_Kinds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok", "kinds": ["predict", "pot"]}`))
+	}))
+	defer srv.Close()
+	kinds, err := probeSidecar(t.Context(), srv.URL)
+	if err != nil || !slices.Equal(kinds, []string{"predict", "pot"}) {
+		t.Errorf("kinds=%v err=%v", kinds, err)
+	}
+
+	// Sidecars from before the handshake answer without kinds.
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer old.Close()
+	if kinds, err := probeSidecar(t.Context(), old.URL); err != nil || kinds != nil {
+		t.Errorf("old sidecar: kinds=%v err=%v", kinds, err)
+	}
+}
+
+func TestCheckKind(t *testing.T) {
+	t.Setenv("DSPY_SIDECAR_URL", "http://kinds-a.invalid,http://kinds-b.invalid")
+	pool := getSidecarPool()
+
+	// Nothing advertised yet: the sidecar decides.
+	if err := checkKind("pot"); err != nil {
+		t.Errorf("before the handshake: %v", err)
+	}
+	if err := checkKind("Pot!"); err == nil {
+		t.Error("malformed kind accepted")
+	}
+
+	pool.members[0].setKinds([]string{"cot", "pot"})
+	pool.members[1].setKinds([]string{"cot"})
+	if err := checkKind("pot"); err != nil {
+		t.Errorf("advertised kind: %v", err)
+	}
+	if skip := pool.lacking("pot"); len(skip) != 1 || !skip[pool.members[1]] {
+		t.Errorf("lacking pot = %v", skip)
+	}
+	if err := checkKind("refine"); err == nil {
+		t.Error("kind no sidecar advertises accepted")
+	}
+	if err := checkKind("react"); err != nil || len(pool.lacking("react")) != 0 {
+		t.Errorf("configured kind: %v, lacking %v", err, pool.lacking("react"))
+	}
+}
+
+func TestRecursiveHandler_PassThroughKind(t *testing.T) {
+	var got sidecarRequest
+	hits := map[string]int{}
+	newSidecar := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"outputs": {"answer": "ok"}}`))
+		}))
+	}
+	with, without := newSidecar("with"), newSidecar("without")
+	defer with.Close()
+	defer without.Close()
+	t.Setenv("DSPY_SIDECAR_URL", without.URL+","+with.URL)
+	pool := getSidecarPool()
+	for _, h := range pool.members {
+		h.startOnce.Do(func() {})
+	}
+	pool.members[0].setKinds([]string{"cot"})
+	pool.members[1].setKinds([]string{"cot", "pot"})
+
+	for range 3 {
+		prog := parseChat(t, `{"model": "openai/gpt-4o+dspy:pot", "messages": [{"role": "user", "content": "2+2?"}]}`)
+		handled, err := (&DSPy{}).RecursiveHandler("pot", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+		if !handled || err != nil {
+			t.Fatalf("handled=%v err=%v", handled, err)
+		}
+	}
+	if got.Kind != "pot" || hits["with"] != 3 || hits["without"] != 0 {
+		t.Errorf("kind=%q hits=%v", got.Kind, hits)
+	}
+}
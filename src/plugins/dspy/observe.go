@@ -0,0 +1,77 @@
+package dspy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// traceHeaders are the W3C trace context headers passed on to the sidecar,
+// which continues the trace into the LM calls it makes back through the
+// router.
+var traceHeaders = []string{"Traceparent", "Tracestate"}
+
+// sidecarUsage is the token usage of a module's inner LM calls, as the
+// sidecar reports it with the prediction.
+//
+// Those calls come back through the router as requests of their own, under
+// the client's key, and are accounted there; this sum only reports them on
+// the DSPy response and in its access record.
+type sidecarUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	LMCalls          int `json:"lm_calls"`
+}
+
+// emit appends u to prog as a USAGE instruction.
+func (u *sidecarUsage) emit(prog *ail.Program) {
+	data, err := json.Marshal(map[string]int{
+		"prompt_tokens":     u.PromptTokens,
+		"completion_tokens": u.CompletionTokens,
+		"total_tokens":      u.PromptTokens + u.CompletionTokens,
+	})
+	if err == nil {
+		prog.EmitJSON(ail.USAGE, data)
+	}
+}
+
+// observeUsage records the inner LM calls of a module of kind, and their
+// usage in the access record of the request. resProg is the response or
+// usage chunk carrying it.
+func observeUsage(ctx context.Context, kind string, u *sidecarUsage, resProg *ail.Program) {
+	if u == nil {
+		return
+	}
+	services.ObserveDSPyLMCalls(kind, u.LMCalls)
+	services.AccessRecordFrom(ctx).ObserveResponse(resProg)
+}
+
+// buildStreamUsage creates the chunk carrying the usage of a stream.
+func buildStreamUsage(model string, u *sidecarUsage) *ail.Program {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.RESP_MODEL, model)
+	u.emit(prog)
+	return prog
+}
+
+// errorReason labels a failed sidecar call for ObserveDSPyError.
+func errorReason(err error) string {
+	if errors.Is(err, errSidecarUnreachable) {
+		return "unreachable"
+	}
+	return "sidecar"
+}
+
+// copyTraceHeaders copies the trace context of the client request to the
+// sidecar call.
+func copyTraceHeaders(dst, src http.Header) {
+	for _, h := range traceHeaders {
+		if v := src.Get(h); v != "" {
+			dst.Set(h, v)
+		}
+	}
+}
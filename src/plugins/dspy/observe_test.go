@@ -0,0 +1,90 @@
+package dspy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecursiveHandler_Observability(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	for _, stream := range []bool{false, true} {
+		var gotTrace, gotTimeout string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotTrace = r.Header.Get("Traceparent")
+			gotTimeout = r.Header.Get(services.RequestTimeoutHeader)
+			usage := `"usage": {"prompt_tokens": 120, "completion_tokens": 30, "total_tokens": 150, "lm_calls": 3}`
+			if stream {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(`data: {"type": "prediction", "outputs": {"answer": "ok"}, ` + usage + "}\n\ndata: [DONE]\n\n"))
+				return
+			}
+			w.Write([]byte(`{"outputs": {"answer": "ok"}, ` + usage + `}`))
+		}))
+		t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+		for _, h := range getSidecarPool().members {
+			h.startOnce.Do(func() {})
+		}
+		calls := testutil.ToFloat64(services.Metrics.DSPyLMCalls.WithLabelValues("predict"))
+
+		body := `{"model": "openai/gpt-4o+dspy:predict", "messages": [{"role": "user", "content": "hi"}]}`
+		if stream {
+			body = strings.Replace(body, "{", `{"stream": true, `, 1)
+		}
+		rec := &services.AccessRecord{}
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Traceparent", traceparent)
+		ctx, cancel := services.WithRequestDeadline(services.ContextWithAccessRecord(r.Context(), rec), time.Minute)
+		r = r.WithContext(ctx)
+		w := httptest.NewRecorder()
+		handled, err := (&DSPy{}).RecursiveHandler("predict", nil, parseChat(t, body), w, r)
+		cancel()
+		srv.Close()
+		if !handled || err != nil {
+			t.Fatalf("stream=%v: handled=%v err=%v", stream, handled, err)
+		}
+
+		if gotTrace != traceparent {
+			t.Errorf("stream=%v: sidecar got traceparent %q", stream, gotTrace)
+		}
+		if d, err := services.ParseRequestTimeout(gotTimeout); err != nil || d > time.Minute || d < 50*time.Second {
+			t.Errorf("stream=%v: sidecar got timeout %q", stream, gotTimeout)
+		}
+		if !strings.Contains(w.Body.String(), `"total_tokens":150`) {
+			t.Errorf("stream=%v: response lacks usage: %s", stream, w.Body.String())
+		}
+		if u := rec.Snapshot().Usage; u.PromptTokens != 120 || u.CompletionTokens != 30 {
+			t.Errorf("stream=%v: access record usage = %+v", stream, u)
+		}
+		if got := testutil.ToFloat64(services.Metrics.DSPyLMCalls.WithLabelValues("predict")) - calls; got != 3 {
+			t.Errorf("stream=%v: lm calls counted = %v", stream, got)
+		}
+	}
+}
+
+func TestRecursiveHandler_ErrorMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "boom"})
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+	errs := testutil.ToFloat64(services.Metrics.DSPyErrors.WithLabelValues("cot", "sidecar"))
+
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "user", "content": "hi"}]}`)
+	if _, err := (&DSPy{}).RecursiveHandler("cot", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil)); err == nil {
+		t.Fatal("expected a sidecar error")
+	}
+	if got := testutil.ToFloat64(services.Metrics.DSPyErrors.WithLabelValues("cot", "sidecar")) - errs; got != 1 {
+		t.Errorf("sidecar errors counted = %v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package dspy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// optionsKey is the request body field, carried as EXT_DATA, holding
+// per-request DSPy options.
+const optionsKey = "dspy"
+
+// requestOptions are the DSPy options a client sends in the request body:
+//
+//	{"model": "openai/gpt-4o+dspy", "dspy": {"kind": "react", "max_iters": 8}}
+//
+// Kind and Signature override the model suffix. MaxIters bounds the
+// iterations of ReAct and RLM; Temperature applies to the module's inner
+// LM calls. Fallback overrides DSPY_FALLBACK for the request; see
+// getFallback.
+type requestOptions struct {
+	Kind        string   `json:"kind,omitempty"`
+	Signature   string   `json:"signature,omitempty"`
+	MaxIters    int      `json:"max_iters,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Fallback    string   `json:"fallback,omitempty"`
+}
+
+// takeRequestOptions reads the dspy options from prog and removes them, so
+// that they never reach a provider, should the request fall back to plain
+// inference.
+func takeRequestOptions(prog *ail.Program) (*requestOptions, error) {
+	opts := &requestOptions{}
+	// Top-level fields come after the messages, so the last match is the
+	// request's own.
+	i := len(prog.Code) - 1
+	for ; i >= 0; i-- {
+		if inst := prog.Code[i]; inst.Op == ail.EXT_DATA && inst.Key == optionsKey {
+			break
+		}
+	}
+	if i < 0 {
+		return opts, nil
+	}
+	raw := prog.Code[i].JSON
+	prog.Code = slices.Delete(prog.Code, i, i+1)
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return nil, fmt.Errorf("invalid %q object: %v", optionsKey, err)
+	}
+	switch {
+	case opts.Kind != "" && !kindNameRe.MatchString(opts.Kind):
+		return nil, fmt.Errorf("unknown kind %q", opts.Kind)
+	case opts.MaxIters < 0:
+		return nil, fmt.Errorf("max_iters must be positive, got %d", opts.MaxIters)
+	case opts.Temperature != nil && (*opts.Temperature < 0 || *opts.Temperature > 2):
+		return nil, fmt.Errorf("temperature must be between 0 and 2, got %g", *opts.Temperature)
+	case opts.Fallback != "" && !fallbackModes[opts.Fallback]:
+		return nil, fmt.Errorf("unknown fallback %q, want none, plain or cot", opts.Fallback)
+	}
+	return opts, nil
+}
@@ -0,0 +1,81 @@
+package dspy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func parseChat(t *testing.T, body string) *ail.Program {
+	t.Helper()
+	parser, err := ail.GetParser(ail.StyleChatCompletions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestTakeRequestOptions(t *testing.T) {
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "user", "content": "hi"}],
+		"dspy": {"kind": "react", "signature": "question -> answer", "max_iters": 8, "temperature": 0.2}}`)
+	opts, err := takeRequestOptions(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Kind != "react" || opts.MaxIters != 8 || opts.Temperature == nil || *opts.Temperature != 0.2 {
+		t.Errorf("opts = %+v", opts)
+	}
+	if strings.Contains(prog.Disasm(), "EXT_DATA dspy") {
+		t.Errorf("options left in the program:\n%s", prog.Disasm())
+	}
+
+	for _, bad := range []string{
+		`{"kind": "Magic!"}`,
+		`{"max_iters": -1}`,
+		`{"temperature": 3}`,
+		`{"fallback": "retry"}`,
+		`{"kinds": "cot"}`,
+		`"cot"`,
+	} {
+		prog := parseChat(t, `{"model": "m", "messages": [], "dspy": `+bad+`}`)
+		if _, err := takeRequestOptions(prog); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestRecursiveHandler_RequestOptions(t *testing.T) {
+	var got sidecarRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"outputs": {"answer": "ok"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy:cot", "messages": [{"role": "user", "content": "hi"}],
+		"dspy": {"kind": "predict", "signature": "question -> answer", "max_iters": 3, "temperature": 0}}`)
+	handled, err := (&DSPy{}).RecursiveHandler("cot", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !handled || err != nil {
+		t.Fatalf("handled=%v err=%v", handled, err)
+	}
+	if got.Kind != "predict" || got.Signature != "question -> answer" || got.MaxIters != 3 || got.Temperature == nil || *got.Temperature != 0 {
+		t.Errorf("payload = %+v", got)
+	}
+
+	prog = parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [], "dspy": {"kind": "Magic!"}}`)
+	if handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil)); !handled || err == nil {
+		t.Errorf("bad options: handled=%v err=%v", handled, err)
+	}
+}
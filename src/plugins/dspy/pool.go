@@ -0,0 +1,72 @@
+package dspy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// sidecarPool spreads calls round-robin over the sidecars listed in
+// DSPY_SIDECAR_URL, skipping those whose breaker is open, since a single
+// Python process becomes the bottleneck under load.
+type sidecarPool struct {
+	members []*sidecarHealth
+	next    atomic.Uint64
+}
+
+func newSidecarPool(urls []string) *sidecarPool {
+	p := &sidecarPool{}
+	for _, u := range urls {
+		p.members = append(p.members, &sidecarHealth{url: u})
+	}
+	return p
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*sidecarPool{}
+)
+
+// getSidecarPool returns the pool for the current DSPY_SIDECAR_URL. Pools
+// are kept per value so the health of each sidecar survives across calls.
+func getSidecarPool() *sidecarPool {
+	key := os.Getenv("DSPY_SIDECAR_URL")
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	p, ok := pools[key]
+	if !ok {
+		p = newSidecarPool(getSidecarURLs())
+		pools[key] = p
+	}
+	return p
+}
+
+// start launches the prober of every sidecar.
+func (p *sidecarPool) start() {
+	for _, h := range p.members {
+		h.start()
+	}
+}
+
+// pick returns the next available sidecar not in exclude, or nil when
+// there is none.
+func (p *sidecarPool) pick(exclude map[*sidecarHealth]bool) *sidecarHealth {
+	n := uint64(len(p.members))
+	first := p.next.Add(1) - 1
+	for i := range n {
+		h := p.members[(first+i)%n]
+		if !exclude[h] && h.available() {
+			return h
+		}
+	}
+	return nil
+}
+
+// unavailableError is what requests get while no sidecar is available.
+func (p *sidecarPool) unavailableError() error {
+	if len(p.members) == 1 {
+		return p.members[0].unavailableError()
+	}
+	return fmt.Errorf("all %d sidecars unavailable", len(p.members))
+}
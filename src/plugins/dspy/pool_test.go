@@ -0,0 +1,73 @@
+package dspy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func TestSidecarPool_Pick(t *testing.T) {
+	p := newSidecarPool([]string{"http://a", "http://b", "http://c"})
+	var got []string
+	for range 4 {
+		got = append(got, p.pick(nil).url)
+	}
+	if strings.Join(got, " ") != "http://a http://b http://c http://a" {
+		t.Errorf("round-robin = %v", got)
+	}
+
+	p.members[1].report(errors.New("connection refused"))
+	for range 4 {
+		if h := p.pick(nil); h.url == "http://b" {
+			t.Fatal("picked a sidecar that is down")
+		}
+	}
+	if h := p.pick(map[*sidecarHealth]bool{p.members[0]: true, p.members[2]: true}); h != nil {
+		t.Errorf("picked %s with every available sidecar excluded", h.url)
+	}
+}
+
+func TestGetSidecarURLs(t *testing.T) {
+	t.Setenv("DSPY_SIDECAR_URL", " http://a:8780/ ,, http://b:8780")
+	if got := getSidecarURLs(); strings.Join(got, ",") != "http://a:8780,http://b:8780" {
+		t.Errorf("urls = %v", got)
+	}
+	t.Setenv("DSPY_SIDECAR_URL", "")
+	if got := getSidecarURLs(); len(got) != 1 || got[0] != "http://localhost:8780" {
+		t.Errorf("default urls = %v", got)
+	}
+}
+
+func TestRecursiveHandler_Failover(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"outputs": {"answer": "from live"}}`))
+	}))
+	defer live.Close()
+
+	t.Setenv("DSPY_SIDECAR_URL", dead.URL+","+live.URL)
+	pool := getSidecarPool()
+	for _, h := range pool.members {
+		h.startOnce.Do(func() {})
+	}
+
+	prog, err := ail.Asm("SET_MODEL openai/gpt-4o+dspy\nMSG_START\nROLE_USR\nTXT_CHUNK \"hi\"\nMSG_END\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		w := httptest.NewRecorder()
+		handled, err := (&DSPy{}).RecursiveHandler("", nil, prog, w, httptest.NewRequest(http.MethodPost, "/", nil))
+		if !handled || err != nil || !strings.Contains(w.Body.String(), "from live") {
+			t.Fatalf("handled=%v err=%v body=%s", handled, err, w.Body)
+		}
+	}
+	if pool.members[0].available() {
+		t.Error("unreachable sidecar still takes calls")
+	}
+}
@@ -0,0 +1,85 @@
+package dspy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// sidecarSecretHeader carries DSPY_SIDECAR_SECRET on every call between
+// the router and its sidecars, in both directions.
+const sidecarSecretHeader = "X-DSPy-Sidecar-Secret"
+
+// errBadSidecarSecret is returned for a request claiming to come from a
+// sidecar without the right secret.
+var errBadSidecarSecret = errors.New("dspy: invalid sidecar secret")
+
+func getSidecarSecret() string {
+	return os.Getenv("DSPY_SIDECAR_SECRET")
+}
+
+// signSidecarRequest adds the shared secret, when one is configured, to a
+// request for a sidecar.
+func signSidecarRequest(req *http.Request) {
+	if secret := getSidecarSecret(); secret != "" {
+		req.Header.Set(sidecarSecretHeader, secret)
+	}
+}
+
+// CheckCallback authenticates the LM calls a sidecar makes back into the
+// router. A request without the secret header is an ordinary client
+// request and is returned as is. One carrying it must match
+// DSPY_SIDECAR_SECRET, or an error is returned; when it does, the header
+// is dropped so it is never forwarded upstream, and the request is marked
+// as a callback so the +dspy plugin does not handle it again.
+func CheckCallback(r *http.Request) (*http.Request, error) {
+	got := r.Header.Get(sidecarSecretHeader)
+	if got == "" {
+		return r, nil
+	}
+	secret := getSidecarSecret()
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		return r, errBadSidecarSecret
+	}
+	r = r.WithContext(context.WithValue(r.Context(), dspyRecursionGuard{}, true))
+	r.Header.Del(sidecarSecretHeader)
+	return r, nil
+}
+
+// sidecarTLSConfig returns the TLS settings for sidecar calls:
+// DSPY_TLS_CA_FILE is the CA sidecar certificates are verified against,
+// and DSPY_TLS_CERT_FILE with DSPY_TLS_KEY_FILE the client certificate
+// presented to sidecars requiring mutual TLS. It returns nil when none is
+// set.
+func sidecarTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("DSPY_TLS_CA_FILE")
+	certFile, keyFile := os.Getenv("DSPY_TLS_CERT_FILE"), os.Getenv("DSPY_TLS_KEY_FILE")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("DSPY_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("DSPY_TLS_CA_FILE: no certificates in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("DSPY_TLS_CERT_FILE/DSPY_TLS_KEY_FILE: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,78 @@
+package dspy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSidecarSecret_Sent(t *testing.T) {
+	t.Setenv("DSPY_SIDECAR_SECRET", "s3cret")
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Path+" "+r.Header.Get(sidecarSecretHeader))
+		w.Write([]byte(`{"outputs": {"answer": "ok"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	if _, err := probeSidecar(t.Context(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "user", "content": "hi"}]}`)
+	if handled, err := (&DSPy{}).RecursiveHandler("cot", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil)); !handled || err != nil {
+		t.Fatalf("handled=%v err=%v", handled, err)
+	}
+	if len(got) != 2 || got[0] != "/health s3cret" || got[1] != "/invoke s3cret" {
+		t.Errorf("sidecar saw %q", got)
+	}
+}
+
+func TestCheckCallback(t *testing.T) {
+	newReq := func(secret string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if secret != "" {
+			r.Header.Set(sidecarSecretHeader, secret)
+		}
+		return r
+	}
+
+	// Without a configured secret, only requests not claiming one pass.
+	if r, err := CheckCallback(newReq("")); err != nil || r.Context().Value(dspyRecursionGuard{}) != nil {
+		t.Errorf("client request: guard=%v err=%v", r.Context().Value(dspyRecursionGuard{}), err)
+	}
+	if _, err := CheckCallback(newReq("guess")); err == nil {
+		t.Error("secret accepted with none configured")
+	}
+
+	t.Setenv("DSPY_SIDECAR_SECRET", "s3cret")
+	if _, err := CheckCallback(newReq("guess")); err == nil {
+		t.Error("wrong secret accepted")
+	}
+	r, err := CheckCallback(newReq("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Context().Value(dspyRecursionGuard{}) == nil {
+		t.Error("callback not marked")
+	}
+	if r.Header.Get(sidecarSecretHeader) != "" {
+		t.Error("secret header kept")
+	}
+	if handled, _ := (&DSPy{}).RecursiveHandler("cot", nil, nil, httptest.NewRecorder(), r); handled {
+		t.Error("callback handled by dspy again")
+	}
+}
+
+func TestSidecarTLSConfig(t *testing.T) {
+	if cfg, err := sidecarTLSConfig(); cfg != nil || err != nil {
+		t.Errorf("unset: cfg=%v err=%v", cfg, err)
+	}
+	t.Setenv("DSPY_TLS_CA_FILE", t.TempDir()+"/missing.pem")
+	if _, err := sidecarTLSConfig(); err == nil {
+		t.Error("missing CA file accepted")
+	}
+}
@@ -0,0 +1,225 @@
+package dspy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// Errors from the signature registry.
+var (
+	ErrNoSignature         = errors.New("no such signature")
+	ErrBadSignature        = errors.New("invalid signature")
+	ErrSignatureConfigured = errors.New("signature is configured, not stored")
+	ErrNoSignatureStore    = errors.New("no dspy_signature_store configured")
+)
+
+// Signature is a named DSPy signature: +dspy:<kind>:@<name> uses it in
+// place of a URL-encoded signature in the model suffix. Instructions
+// become the signature's docstring, Fields describe its fields by name,
+// and Demos are few-shot examples (field name → value) given to every
+// predictor of the module.
+type Signature struct {
+	Signature    string              `json:"signature"`
+	Instructions string              `json:"instructions,omitempty"`
+	Fields       map[string]string   `json:"fields,omitempty"`
+	Demos        []map[string]string `json:"demos,omitempty"`
+}
+
+// Validate checks that s is a signature with inputs and outputs whose
+// field descriptions and demos only name its fields.
+func (s *Signature) Validate() error {
+	if !strings.Contains(s.Signature, "->") {
+		return fmt.Errorf("%w: %q has no \"->\"", ErrBadSignature, s.Signature)
+	}
+	inputs, outputs := parseSignatureFields(s.Signature)
+	known := map[string]bool{"reasoning": true}
+	for _, f := range append(inputs, outputs...) {
+		known[f] = true
+	}
+	for f := range s.Fields {
+		if !known[f] {
+			return fmt.Errorf("%w: description for unknown field %q", ErrBadSignature, f)
+		}
+	}
+	for i, demo := range s.Demos {
+		for f := range demo {
+			if !known[f] {
+				return fmt.Errorf("%w: demo %d has unknown field %q", ErrBadSignature, i, f)
+			}
+		}
+	}
+	return nil
+}
+
+// details returns what s adds to its signature text; nil s adds nothing.
+func (s *Signature) details() signatureDetails {
+	if s == nil {
+		return signatureDetails{}
+	}
+	return signatureDetails{Instructions: s.Instructions, Fields: s.Fields, Demos: s.Demos}
+}
+
+// signatureRegistry holds the signatures from config and, when a router
+// sets dspy_signature_store, those saved through the admin API. Config
+// wins over the store, which cannot shadow a configured name.
+type signatureRegistry struct {
+	mu         sync.RWMutex
+	configured map[string]*Signature
+	stored     map[string]*Signature // cache of the store
+	store      kv.Store
+	prefix     string
+}
+
+var signatures = &signatureRegistry{
+	configured: map[string]*Signature{},
+	stored:     map[string]*Signature{},
+}
+
+// RegisterSignatures adds configured signatures, replacing any with the
+// same name.
+func RegisterSignatures(sigs map[string]*Signature) {
+	signatures.mu.Lock()
+	defer signatures.mu.Unlock()
+	maps.Copy(signatures.configured, sigs)
+}
+
+// SetSignatureStore makes store, under prefix, where signatures managed
+// through the admin API live, and loads the ones already saved. A saved
+// signature that no longer validates is returned in skipped and left out.
+func SetSignatureStore(ctx context.Context, store kv.Store, prefix string) (skipped map[string]error, err error) {
+	lister, ok := store.(kv.Lister)
+	if !ok {
+		return nil, errors.New("kv backend cannot list keys")
+	}
+	entries, err := lister.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]*Signature{}
+	for key, value := range entries {
+		name := key[len(prefix):]
+		sig := &Signature{}
+		err := json.Unmarshal([]byte(value), sig)
+		if err == nil {
+			err = sig.Validate()
+		}
+		if err != nil {
+			if skipped == nil {
+				skipped = map[string]error{}
+			}
+			skipped[name] = err
+			continue
+		}
+		loaded[name] = sig
+	}
+	signatures.mu.Lock()
+	signatures.store, signatures.prefix, signatures.stored = store, prefix, loaded
+	signatures.mu.Unlock()
+	return skipped, nil
+}
+
+// ClearSignatureStore forgets store if it is the current signature store,
+// before it is closed.
+func ClearSignatureStore(store kv.Store) {
+	signatures.mu.Lock()
+	defer signatures.mu.Unlock()
+	if signatures.store == store {
+		signatures.store, signatures.prefix, signatures.stored = nil, "", map[string]*Signature{}
+	}
+}
+
+// LookupSignature returns the signature called name.
+func LookupSignature(name string) (*Signature, error) {
+	signatures.mu.RLock()
+	defer signatures.mu.RUnlock()
+	if sig, ok := signatures.configured[name]; ok {
+		return sig, nil
+	}
+	if sig, ok := signatures.stored[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoSignature, name)
+}
+
+// SignatureInfo describes a registered signature. Stored marks those
+// managed through the admin API; the others are configured.
+type SignatureInfo struct {
+	Signature
+	Stored bool `json:"stored"`
+}
+
+// ListSignatures returns every registered signature by name.
+func ListSignatures() map[string]SignatureInfo {
+	signatures.mu.RLock()
+	defer signatures.mu.RUnlock()
+	out := make(map[string]SignatureInfo, len(signatures.configured)+len(signatures.stored))
+	for name, sig := range signatures.stored {
+		out[name] = SignatureInfo{Signature: *sig, Stored: true}
+	}
+	for name, sig := range signatures.configured {
+		out[name] = SignatureInfo{Signature: *sig}
+	}
+	return out
+}
+
+// ValidateSignatureName checks that name can follow "@" in a model suffix.
+func ValidateSignatureName(name string) error {
+	if !compiledNameRe.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be letters, digits, '-' and '_'", ErrBadSignature, name)
+	}
+	return nil
+}
+
+// PutSignature validates sig and saves it as name in the signature store.
+func PutSignature(ctx context.Context, name string, sig *Signature) error {
+	if err := ValidateSignatureName(name); err != nil {
+		return err
+	}
+	if err := sig.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+	signatures.mu.Lock()
+	defer signatures.mu.Unlock()
+	if signatures.store == nil {
+		return ErrNoSignatureStore
+	}
+	if _, ok := signatures.configured[name]; ok {
+		return fmt.Errorf("%s: %w", name, ErrSignatureConfigured)
+	}
+	if err := signatures.store.Set(ctx, signatures.prefix+name, string(data), 0); err != nil {
+		return err
+	}
+	signatures.stored[name] = sig
+	return nil
+}
+
+// DeleteSignature removes stored signature name.
+func DeleteSignature(ctx context.Context, name string) error {
+	signatures.mu.Lock()
+	defer signatures.mu.Unlock()
+	if signatures.store == nil {
+		return ErrNoSignatureStore
+	}
+	if _, ok := signatures.configured[name]; ok {
+		return fmt.Errorf("%s: %w", name, ErrSignatureConfigured)
+	}
+	if _, ok := signatures.stored[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrNoSignature, name)
+	}
+	if err := signatures.store.Delete(ctx, signatures.prefix+name); err != nil && !errors.Is(err, kv.ErrNotFound) {
+		return err
+	}
+	delete(signatures.stored, name)
+	return nil
+}
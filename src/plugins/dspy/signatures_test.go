@@ -0,0 +1,133 @@
+package dspy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestSignature_Validate(t *testing.T) {
+	for _, sig := range []Signature{
+		{Signature: "question"},
+		{Signature: "question -> answer", Fields: map[string]string{"total": "x"}},
+		{Signature: "question -> answer", Demos: []map[string]string{{"question": "q", "total": "1"}}},
+	} {
+		if err := sig.Validate(); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%+v: %v", sig, err)
+		}
+	}
+	ok := Signature{
+		Signature: "document -> vendor, total",
+		Fields:    map[string]string{"total": "Amount due, with currency"},
+		Demos:     []map[string]string{{"document": "ACME, $3", "vendor": "ACME", "total": "$3", "reasoning": "r"}},
+	}
+	if err := ok.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignatureRegistry(t *testing.T) {
+	ctx := context.Background()
+	store := kv.NewMemoryStore(100, -1)
+	_ = store.Set(ctx, "sig/sr-saved", `{"signature": "question -> answer"}`, 0)
+	_ = store.Set(ctx, "sig/sr-broken", `{"signature": "question"}`, 0)
+	RegisterSignatures(map[string]*Signature{"sr-config": {Signature: "context, question -> answer"}})
+	t.Cleanup(func() {
+		ClearSignatureStore(store)
+		signatures.mu.Lock()
+		delete(signatures.configured, "sr-config")
+		signatures.mu.Unlock()
+	})
+
+	if err := PutSignature(ctx, "sr-new", &Signature{Signature: "a -> b"}); !errors.Is(err, ErrNoSignatureStore) {
+		t.Errorf("put without a store: %v", err)
+	}
+	skipped, err := SetSignatureStore(ctx, store, "sig/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := skipped["sr-broken"]; !ok || len(skipped) != 1 {
+		t.Errorf("skipped = %v", skipped)
+	}
+	if sig, err := LookupSignature("sr-saved"); err != nil || sig.Signature != "question -> answer" {
+		t.Errorf("sr-saved = %+v, %v", sig, err)
+	}
+
+	if err := PutSignature(ctx, "sr-config", &Signature{Signature: "a -> b"}); !errors.Is(err, ErrSignatureConfigured) {
+		t.Errorf("put over a configured signature: %v", err)
+	}
+	if err := PutSignature(ctx, "sr/new", &Signature{Signature: "a -> b"}); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("put with a bad name: %v", err)
+	}
+	if err := PutSignature(ctx, "sr-new", &Signature{Signature: "a -> b", Instructions: "Be terse."}); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := store.Get(ctx, "sig/sr-new"); saved == "" {
+		t.Error("signature not saved")
+	}
+	list := ListSignatures()
+	if !list["sr-new"].Stored || list["sr-config"].Stored || list["sr-new"].Instructions != "Be terse." {
+		t.Errorf("list = %+v", list)
+	}
+
+	if err := DeleteSignature(ctx, "sr-config"); !errors.Is(err, ErrSignatureConfigured) {
+		t.Errorf("delete a configured signature: %v", err)
+	}
+	if err := DeleteSignature(ctx, "sr-new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupSignature("sr-new"); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("after delete: %v", err)
+	}
+	if err := DeleteSignature(ctx, "sr-new"); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("delete twice: %v", err)
+	}
+}
+
+func TestRecursiveHandler_NamedSignature(t *testing.T) {
+	RegisterSignatures(map[string]*Signature{"invoice_extractor": {
+		Signature:    "question -> vendor, total",
+		Instructions: "Extract the invoice fields.",
+		Fields:       map[string]string{"total": "Amount due"},
+		Demos:        []map[string]string{{"question": "ACME $3", "vendor": "ACME", "total": "$3"}},
+	}})
+	t.Cleanup(func() {
+		signatures.mu.Lock()
+		delete(signatures.configured, "invoice_extractor")
+		signatures.mu.Unlock()
+	})
+
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"outputs": {"vendor": "ACME", "total": "$3"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	prog, _ := ail.Asm("SET_MODEL openai/gpt-4o+dspy:predict:@invoice_extractor\nMSG_START\nROLE_USR\nTXT_CHUNK ACME invoice, total $3\nMSG_END\n")
+	handled, err := (&DSPy{}).RecursiveHandler("predict:@invoice_extractor", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !handled || err != nil {
+		t.Fatalf("handled=%v err=%v", handled, err)
+	}
+	if got["signature"] != "question -> vendor, total" || got["instructions"] != "Extract the invoice fields." || got["demos"] == nil {
+		t.Errorf("payload = %v", got)
+	}
+	if inputs, _ := got["inputs"].(map[string]any); inputs["question"] != "ACME invoice, total $3" {
+		t.Errorf("inputs = %v", got["inputs"])
+	}
+
+	_, err = (&DSPy{}).RecursiveHandler("predict:@unknown", nil, prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err == nil {
+		t.Error("unknown signature accepted")
+	}
+}
@@ -0,0 +1,166 @@
+package dspy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/sse"
+	"go.uber.org/zap"
+)
+
+// routerTool is an on-router tool (websearch, rag, kvmem, …) served by a
+// ToolPlugin in the chain.
+type routerTool struct {
+	handler plugin.ToolHandler
+	params  string
+}
+
+// routerToolSet holds the on-router tools offered to a ReAct module. The
+// sidecar runs them by emitting router_tool_call events; each is
+// dispatched here and its result POSTed back to /tool_result for the
+// session, on the sidecar that emitted it.
+type routerToolSet struct {
+	session string // the payload's
+	defs    []sidecarToolDef
+	tools   map[string]routerTool // by function name
+	ctx     *plugin.ToolCallContext
+}
+
+// collectRouterTools gathers the tools of the ToolSource plugins in ic's
+// chain. It returns nil when there are none.
+func collectRouterTools(ic *plugin.InferenceContext, prog *ail.Program, r *http.Request) *routerToolSet {
+	if ic == nil || ic.Chain == nil {
+		return nil
+	}
+	set := &routerToolSet{tools: map[string]routerTool{}}
+	for _, pi := range ic.Chain.GetPlugins() {
+		src, ok := pi.Plugin.(plugin.ToolSource)
+		if !ok {
+			continue
+		}
+		h := src.ToolHandler()
+		for _, td := range extractToolDefs(h.ToolDefs(pi.Params)) {
+			if _, dup := set.tools[td.Name]; dup {
+				continue
+			}
+			set.defs = append(set.defs, td)
+			set.tools[td.Name] = routerTool{handler: h, params: pi.Params}
+		}
+	}
+	if len(set.tools) == 0 {
+		return nil
+	}
+
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	set.ctx = &plugin.ToolCallContext{
+		TraceID:     traceID,
+		RequestProg: prog,
+		Infer:       ic,
+		Request:     r,
+	}
+	return set
+}
+
+// clientTools drops the client tools that share a name with a router tool,
+// which serves calls to it instead, as in the inference pipeline.
+func (s *routerToolSet) clientTools(tools []sidecarToolDef) []sidecarToolDef {
+	var out []sidecarToolDef
+	for _, td := range tools {
+		if _, ok := s.tools[td.Name]; !ok {
+			out = append(out, td)
+		}
+	}
+	return out
+}
+
+// dispatch runs the router tool call in ev and hands its result back to
+// the sidecar at sidecarURL. A call to a tool not offered gets an error
+// result rather than leaving the module waiting.
+func (s *routerToolSet) dispatch(ctx context.Context, sidecarURL string, header http.Header, ev *sidecarStreamEvent) error {
+	result := fmt.Sprintf("error: unknown tool %q", ev.ToolName)
+	if t, ok := s.tools[ev.ToolName]; ok {
+		if res, handled := plugin.CallTool(t.handler, t.params, ev.ToolName, ev.CallID, ev.ToolArgs, s.ctx); handled {
+			result = res
+		} else {
+			result = fmt.Sprintf("error: tool %q did not handle the call", ev.ToolName)
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"session": s.session,
+		"call_id": ev.CallID,
+		"result":  result,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sidecarURL+"/tool_result", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := doSidecar(req)
+	if err != nil {
+		return fmt.Errorf("tool result: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("tool result: sidecar returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// collectStream reads a sidecar event stream into out, dispatching router
+// tool calls as they come, for a client that did not ask to stream. The
+// stream ends with a prediction, or a client tool call ReAct paused on.
+func collectStream(ctx context.Context, body io.Reader, sidecarURL string, header http.Header, tools *routerToolSet, out *sidecarResponse) error {
+	for ev := range sse.NewDefaultReader(body).ReadEvents() {
+		if ev.Done {
+			break
+		}
+		if ev.Error != nil {
+			return ev.Error
+		}
+		if len(ev.Data) == 0 {
+			continue
+		}
+		var sEvent sidecarStreamEvent
+		if err := json.Unmarshal(ev.Data, &sEvent); err != nil {
+			plugin.Logger.Debug("dspy: skipping unparseable SSE event", zap.Error(err))
+			continue
+		}
+		switch sEvent.Type {
+		case "router_tool_call":
+			if tools == nil {
+				return fmt.Errorf("sidecar called router tool %q, none offered", sEvent.ToolName)
+			}
+			if err := tools.dispatch(ctx, sidecarURL, header, &sEvent); err != nil {
+				return err
+			}
+		case "tool_call":
+			out.ToolCalls = append(out.ToolCalls, sidecarToolCall{ID: sEvent.CallID, Name: sEvent.ToolName, Args: sEvent.ToolArgs})
+		case "prediction":
+			out.Outputs, out.Usage = sEvent.Outputs, sEvent.Usage
+		case "error":
+			msg := sEvent.Message
+			if msg == "" {
+				msg = "unknown sidecar error"
+			}
+			return fmt.Errorf("sidecar stream error: %s", msg)
+		}
+	}
+	if out.Outputs == nil && len(out.ToolCalls) == 0 {
+		return fmt.Errorf("sidecar stream ended without a prediction")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package dspy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+// lookupTool is an on-router tool answering "lookup" calls with their
+// query, failing on "boom".
+type lookupTool struct{}
+
+func (lookupTool) ToolName() string { return "lookup" }
+
+func (lookupTool) ToolDefs(string) []ail.Instruction {
+	return plugin.BuildToolDef("lookup", "Look something up", json.RawMessage(`{"type":"object"}`))
+}
+
+func (lookupTool) HandleToolCall(params, callID string, args json.RawMessage, ctx *plugin.ToolCallContext) (string, bool, error) {
+	var in struct{ Query string }
+	_ = json.Unmarshal(args, &in)
+	if in.Query == "boom" {
+		return "", true, errors.New("lookup failed")
+	}
+	return params + ":" + in.Query, true, nil
+}
+
+// routerToolSidecar fakes a sidecar whose ReAct module calls lookup once
+// with query and answers with what /tool_result delivered.
+func routerToolSidecar(t *testing.T, query string, got *sidecarRequest) *httptest.Server {
+	t.Helper()
+	results := make(chan map[string]string, 1)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tool_result":
+			var res map[string]string
+			json.NewDecoder(r.Body).Decode(&res)
+			results <- res
+			w.Write([]byte(`{"status": "ok"}`))
+		case "/invoke":
+			json.NewDecoder(r.Body).Decode(got)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"type\": \"router_tool_call\", \"call_id\": \"c1\", \"tool_name\": \"lookup\", \"tool_args\": {\"query\": %q}}\n\n", query)
+			w.(http.Flusher).Flush()
+			res := <-results
+			if res["session"] != got.Session || res["call_id"] != "c1" {
+				t.Errorf("tool result = %v", res)
+			}
+			out, _ := json.Marshal(map[string]any{"type": "prediction", "outputs": map[string]string{"answer": res["result"]}})
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", out)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func toolChain() *plugin.InferenceContext {
+	chain := plugin.NewPluginChain()
+	chain.Add(plugin.NewToolPlugin(lookupTool{}), "kb")
+	return &plugin.InferenceContext{Chain: chain}
+}
+
+func TestRecursiveHandler_RouterTools(t *testing.T) {
+	for _, tc := range []struct {
+		name, query, want string
+		stream            bool
+	}{
+		{"non-streaming", "go", "kb:go", false},
+		{"streaming", "go", "kb:go", true},
+		{"tool error", "boom", "error: lookup failed", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got sidecarRequest
+			srv := routerToolSidecar(t, tc.query, &got)
+			defer srv.Close()
+			t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+			for _, h := range getSidecarPool().members {
+				h.startOnce.Do(func() {})
+			}
+
+			prog := parseChat(t, fmt.Sprintf(`{"model": "openai/gpt-4o+dspy:react", "stream": %v,
+				"messages": [{"role": "user", "content": "hi"}],
+				"tools": [{"type": "function", "function": {"name": "lookup", "description": "client"}},
+					{"type": "function", "function": {"name": "weather", "description": "client"}}]}`, tc.stream))
+			w := httptest.NewRecorder()
+			handled, err := (&DSPy{}).RecursiveHandler("react", toolChain(), prog, w, httptest.NewRequest(http.MethodPost, "/", nil))
+			if !handled || err != nil {
+				t.Fatalf("handled=%v err=%v", handled, err)
+			}
+			if !got.Stream || got.Session == "" || len(got.RouterTools) != 1 || got.RouterTools[0].Name != "lookup" {
+				t.Errorf("payload = %+v", got)
+			}
+			if len(got.Tools) != 1 || got.Tools[0].Name != "weather" {
+				t.Errorf("client tools = %+v", got.Tools)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tc.want) || strings.Contains(body, "router_tool_call") {
+				t.Errorf("response = %s", body)
+			}
+		})
+	}
+}
+
+func TestRecursiveHandler_RouterToolsOnlyForReAct(t *testing.T) {
+	var got sidecarRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"outputs": {"answer": "ok"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+	for _, h := range getSidecarPool().members {
+		h.startOnce.Do(func() {})
+	}
+
+	prog := parseChat(t, `{"model": "openai/gpt-4o+dspy", "messages": [{"role": "user", "content": "hi"}]}`)
+	handled, err := (&DSPy{}).RecursiveHandler("cot", toolChain(), prog, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !handled || err != nil {
+		t.Fatalf("handled=%v err=%v", handled, err)
+	}
+	if got.Stream || len(got.RouterTools) != 0 || got.Session != "" {
+		t.Errorf("payload = %+v", got)
+	}
+}
+
+func TestRecursiveHandler_ClientToolPause(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprint("stream=", stream), func(t *testing.T) {
+			var got sidecarRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&got)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte("data: {\"type\": \"tool_call\", \"call_id\": \"call_1\", \"tool_name\": \"weather\", \"tool_args\": {\"city\": \"Oslo\"}}\n\ndata: [DONE]\n\n"))
+			}))
+			defer srv.Close()
+			t.Setenv("DSPY_SIDECAR_URL", srv.URL)
+			for _, h := range getSidecarPool().members {
+				h.startOnce.Do(func() {})
+			}
+
+			prog := parseChat(t, fmt.Sprintf(`{"model": "openai/gpt-4o+dspy:react", "stream": %v,
+				"messages": [{"role": "user", "content": "weather in Oslo?"}],
+				"tools": [{"type": "function", "function": {"name": "weather", "description": "client"}}]}`, stream))
+			w := httptest.NewRecorder()
+			handled, err := (&DSPy{}).RecursiveHandler("react", nil, prog, w, httptest.NewRequest(http.MethodPost, "/", nil))
+			if !handled || err != nil {
+				t.Fatalf("handled=%v err=%v", handled, err)
+			}
+			if !got.Stream || got.Session == "" || len(got.RouterTools) != 0 {
+				t.Errorf("payload = %+v", got)
+			}
+			body := w.Body.String()
+			for _, want := range []string{`"call_1"`, `"weather"`, `"finish_reason":"tool_calls"`} {
+				if !strings.Contains(body, want) {
+					t.Errorf("response lacks %s: %s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildHistory_ToolTurns(t *testing.T) {
+	prog := parseChat(t, `{"model": "m", "messages": [
+		{"role": "user", "content": "weather in Oslo?"},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function",
+			"function": {"name": "weather", "arguments": "{\"city\":\"Oslo\"}"}}]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "rainy, 8C"}]}`)
+	history := buildHistory(prog)
+	if len(history) != 3 {
+		t.Fatalf("history = %+v", history)
+	}
+	if h := history[1]; h.Role != "assistant" || !strings.Contains(h.Content, "weather") || !strings.Contains(h.Content, "Oslo") {
+		t.Errorf("tool call turn = %+v", h)
+	}
+	if h := history[2]; h.Role != "tool" || h.Content != "rainy, 8C" {
+		t.Errorf("tool result turn = %+v", h)
+	}
+}
@@ -0,0 +1,81 @@
+package plugins
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// Inspect publishes each stage of a request to services.LiveInspector for
+// the ai_inspect debug endpoint. It runs as a tail plugin, so the upstream
+// stage shows the program after every other plugin ran; with no inspector
+// connected it does nothing.
+type Inspect struct{}
+
+func (Inspect) Name() string { return "inspect" }
+
+// FailOpen keeps debugging from failing requests.
+func (Inspect) FailOpen() bool { return true }
+
+// OnRequestInit publishes the parsed client request.
+func (Inspect) OnRequestInit(r *http.Request, prog *ail.Program) {
+	publishInspect(services.InspectRequest, nil, r, prog, nil)
+}
+
+// Before publishes the program about to be sent upstream.
+func (Inspect) Before(_ string, p *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
+	publishInspect(services.InspectUpstream, p, r, prog, nil)
+	return prog, nil
+}
+
+// After publishes a complete response.
+func (Inspect) After(_ string, p *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	publishInspect(services.InspectResponse, p, r, resProg, nil)
+	return resProg, nil
+}
+
+// StreamEnd publishes the assembled streamed response.
+func (Inspect) StreamEnd(_ string, p *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	publishInspect(services.InspectStreamEnd, p, r, assembled, nil)
+	return nil
+}
+
+// OnError publishes a failed provider call with the request it carried.
+func (Inspect) OnError(_ string, p *services.ProviderService, r *http.Request, reqProg *ail.Program, _ *http.Response, providerErr error) error {
+	publishInspect(services.InspectError, p, r, reqProg, providerErr)
+	return nil
+}
+
+func publishInspect(stage string, p *services.ProviderService, r *http.Request, prog *ail.Program, err error) {
+	if !services.LiveInspector.Active() || prog == nil {
+		return
+	}
+	ev := services.InspectorEvent{
+		Time:  time.Now(),
+		Stage: stage,
+		Model: prog.GetModel(),
+		// Later plugins and handlers may still mutate prog.
+		Prog: prog.Clone(),
+	}
+	ev.TraceID, _ = r.Context().Value(plugin.ContextTraceID()).(string)
+	ev.KeyID, _ = r.Context().Value(plugin.ContextKeyID()).(string)
+	if p != nil {
+		ev.Provider = p.Name
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	services.LiveInspector.Publish(ev)
+}
+
+var (
+	_ plugin.RequestInitPlugin = Inspect{}
+	_ plugin.BeforePlugin      = Inspect{}
+	_ plugin.AfterPlugin       = Inspect{}
+	_ plugin.StreamEndPlugin   = Inspect{}
+	_ plugin.ErrorPlugin       = Inspect{}
+	_ plugin.FailOpenPlugin    = Inspect{}
+)
@@ -1,10 +1,13 @@
 package plugins
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,8 +30,23 @@ import (
 //
 // Syntax:
 //
-//	kvtools         → defaults (memory backend, 30m TTL)
-//	kvtools:redis   → use redis backend
+//	kvtools                 → defaults (memory backend, 30m TTL)
+//	kvtools:redis           → use redis backend
+//	kvtools:memory:min=6000 → only cache and strip, and offer
+//	                          get_tool_result, once the prompt is
+//	                          estimated at more than 6000 tokens
+//	kvtools:redis=...:conv  → keep results for the conversation named by
+//	                          the X-Conversation-Id header
+//	kvtools:conv=metadata.conversation_id
+//	                        → ... named by this header or body field
+//
+// Below min, short conversations are passed on untouched.
+//
+// Results are kept per request (trace ID) unless conv is set, when
+// follow-up requests of the same conversation can recall them too;
+// requests without a conversation ID fall back to their trace ID.
+// Conversation IDs are scoped by API key, so clients cannot read each
+// other's results by guessing them.
 type KvTools struct {
 	plugin.ToolPlugin // BeforePlugin (def injection) + RecursiveHandlerPlugin (dispatch loop)
 	store             kv.Store
@@ -84,11 +102,14 @@ func (k *KvTools) HandleToolCall(params string, callID string, args json.RawMess
 	}
 
 	store := k.ensureStore(params)
-	traceID := ""
+	scope := ""
 	if ctx != nil {
-		traceID = ctx.TraceID
+		scope = ctx.TraceID
+		if ctx.Request != nil {
+			scope = kvScope(params, ctx.Request, ctx.RequestProg)
+		}
 	}
-	val, err := store.Get(context.Background(), kvKey(traceID, input.ToolCallID))
+	val, err := store.Get(context.Background(), kvKey(scope, input.ToolCallID))
 	if err != nil {
 		return "tool result not found for call_id: " + input.ToolCallID, true, nil
 	}
@@ -102,6 +123,9 @@ func (k *KvTools) HandleToolCall(params string, callID string, args json.RawMess
 //   - chain.RunBefore → KvTools.Before (cache & strip + delegate to ToolPlugin.Before)
 
 func (k *KvTools) Before(params string, p *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
+	if opts, _ := parseKvToolsParams(params); opts.minTokens > 0 && services.CountTokens(prog.GetModel(), prog) <= opts.minTokens {
+		return prog, nil
+	}
 	// First: cache older tool results and strip them.
 	prog, err := k.cacheAndStrip(params, r, prog)
 	if err != nil {
@@ -142,11 +166,7 @@ func (k *KvTools) cacheAndStrip(params string, r *http.Request, prog *ail.Progra
 		return prog, nil
 	}
 
-	// Extract trace ID for KV key scoping.
-	traceID := ""
-	if v := r.Context().Value(plugin.ContextTraceID()); v != nil {
-		traceID, _ = v.(string)
-	}
+	scope := kvScope(params, r, prog)
 
 	toCache := interactions[:len(interactions)-1]
 
@@ -163,7 +183,7 @@ func (k *KvTools) cacheAndStrip(params string, r *http.Request, prog *ail.Progra
 					if idx, data := resultDataIndex(prog, res); data != "" {
 						_ = store.Set(
 							context.Background(),
-							kvKey(traceID, res.CallID),
+							kvKey(scope, res.CallID),
 							data,
 							30*time.Minute,
 						)
@@ -188,22 +208,27 @@ func (k *KvTools) cacheAndStrip(params string, r *http.Request, prog *ail.Progra
 	return result, nil
 }
 
+// Dependencies fails instances naming a kv backend that is not registered,
+// which would otherwise fall back to memory silently.
+func (k *KvTools) Dependencies(params string) (plugin.Dependencies, error) {
+	opts, err := parseKvToolsParams(params)
+	if err != nil {
+		return plugin.Dependencies{}, err
+	}
+	if !kv.HasBackend(opts.backend) {
+		return plugin.Dependencies{}, fmt.Errorf("kv backend %q is not available", opts.backend)
+	}
+	return plugin.Dependencies{}, nil
+}
+
 // ─── Helpers ─────────────────────────────────────────────────────────────────
 
 func (k *KvTools) ensureStore(params string) kv.Store {
 	if k.store != nil {
 		return k.store
 	}
-	backend := "memory"
-	dsn := ""
-	if params != "" {
-		parts := strings.SplitN(params, "=", 2)
-		backend = parts[0]
-		if len(parts) == 2 {
-			dsn = parts[1]
-		}
-	}
-	s, err := kv.Open(backend, dsn)
+	opts, _ := parseKvToolsParams(params)
+	s, err := kv.Open(opts.backend, opts.dsn)
 	if err != nil {
 		s, _ = kv.Open("memory", "")
 	}
@@ -211,9 +236,90 @@ func (k *KvTools) ensureStore(params string) kv.Store {
 	return k.store
 }
 
-func kvKey(traceID, callID string) string {
-	if traceID != "" {
-		return "kvtools:" + traceID + ":" + callID
+// kvToolsParams are the parsed params of a kvtools instance.
+type kvToolsParams struct {
+	backend, dsn string
+	minTokens    int    // 0 means always active
+	conv         string // header or body field naming the conversation; "" keys by trace ID
+}
+
+// parseKvToolsParams splits "backend[=dsn][:min=N][:conv[=name]]". Options
+// are looked for only in the trailing colon-separated segments, as DSNs
+// contain colons of their own.
+func parseKvToolsParams(params string) (kvToolsParams, error) {
+	var opts kvToolsParams
+	for {
+		i := strings.LastIndex(params, ":")
+		name, val, hasVal := strings.Cut(params[i+1:], "=")
+		if name == "min" && hasVal {
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return kvToolsParams{}, fmt.Errorf("invalid min %q: want a positive token count", val)
+			}
+			opts.minTokens = n
+		} else if name == "conv" {
+			opts.conv = cmp.Or(val, plugin.ConversationIDHeader)
+		} else {
+			break
+		}
+		params = params[:max(i, 0)]
+	}
+	opts.backend, opts.dsn, _ = strings.Cut(params, "=")
+	if opts.backend == "" {
+		opts.backend = "memory"
+	}
+	return opts, nil
+}
+
+// kvScope returns what the results of a request are keyed by: its
+// conversation ID, scoped by API key, when the instance has conv set and
+// the request names one; else its trace ID.
+func kvScope(params string, r *http.Request, prog *ail.Program) string {
+	if opts, _ := parseKvToolsParams(params); opts.conv != "" {
+		if id := conversationID(opts.conv, r, prog); id != "" {
+			keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+			return "conv:" + keyID + ":" + id
+		}
+	}
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	return traceID
+}
+
+// conversationID reads the conversation ID from the header called name,
+// or else from the request body field at the dotted path name (e.g.
+// metadata.conversation_id), which parsers keep as EXT_DATA.
+func conversationID(name string, r *http.Request, prog *ail.Program) string {
+	if id := r.Header.Get(name); id != "" {
+		return id
+	}
+	if prog == nil {
+		return ""
+	}
+	path := strings.Split(name, ".")
+	for _, inst := range prog.Code {
+		if inst.Op != ail.EXT_DATA || inst.Key != path[0] {
+			continue
+		}
+		raw := inst.JSON
+		for _, field := range path[1:] {
+			var obj map[string]json.RawMessage
+			if json.Unmarshal(raw, &obj) != nil {
+				return ""
+			}
+			raw = obj[field]
+		}
+		var id string
+		if json.Unmarshal(raw, &id) != nil {
+			return ""
+		}
+		return id
+	}
+	return ""
+}
+
+func kvKey(scope, callID string) string {
+	if scope != "" {
+		return "kvtools:" + scope + ":" + callID
 	}
 	return "kvtools::" + callID
 }
@@ -244,4 +350,5 @@ func resultDataIndex(prog *ail.Program, res ail.ToolResultSpan) (int, string) {
 var (
 	_ plugin.BeforePlugin           = (*KvTools)(nil)
 	_ plugin.RecursiveHandlerPlugin = (*KvTools)(nil)
+	_ plugin.DependentPlugin        = (*KvTools)(nil)
 )
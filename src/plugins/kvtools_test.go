@@ -0,0 +1,139 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// toolConversation has two tool interactions, the older of which kvtools
+// caches and strips, each result padded to about size characters.
+func toolConversation(size int) *ail.Program {
+	p := ail.NewProgram()
+	p.EmitString(ail.SET_MODEL, "gpt-4o")
+	for _, id := range []string{"call_1", "call_2"} {
+		p.Emit(ail.MSG_START)
+		p.Emit(ail.ROLE_AST)
+		p.EmitString(ail.CALL_START, id)
+		p.EmitString(ail.CALL_NAME, "search")
+		p.Emit(ail.CALL_END)
+		p.Emit(ail.MSG_END)
+		p.Emit(ail.MSG_START)
+		p.Emit(ail.ROLE_TOOL)
+		p.EmitString(ail.RESULT_START, id)
+		p.EmitString(ail.RESULT_DATA, "result of "+id+strings.Repeat(" lorem ipsum", size/12))
+		p.Emit(ail.RESULT_END)
+		p.Emit(ail.MSG_END)
+	}
+	return p
+}
+
+func countOp(prog *ail.Program, op ail.Opcode) int {
+	n := 0
+	for _, inst := range prog.Code {
+		if inst.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+func TestKvTools_MinTokens(t *testing.T) {
+	k := NewKvTools()
+	k.store, _ = kv.Open("memory", "")
+	r := httptest.NewRequest("POST", "/", nil)
+
+	short := toolConversation(100)
+	out, err := k.Before("memory:min=6000", nil, r, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != short {
+		t.Errorf("short conversation was changed:\n%s", out.Disasm())
+	}
+
+	long := toolConversation(40000)
+	out, err = k.Before("memory:min=6000", nil, r, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := countOp(out, ail.RESULT_DATA); got != 1 {
+		t.Errorf("long conversation kept %d results, want 1", got)
+	}
+	if got := countOp(out, ail.DEF_START); got != 1 {
+		t.Errorf("long conversation has %d tool defs, want get_tool_result", got)
+	}
+}
+
+func TestParseKvToolsParams(t *testing.T) {
+	for _, tc := range []struct {
+		params string
+		want   kvToolsParams
+	}{
+		{"", kvToolsParams{backend: "memory"}},
+		{"min=6000", kvToolsParams{backend: "memory", minTokens: 6000}},
+		{"memory:min=6000", kvToolsParams{backend: "memory", minTokens: 6000}},
+		{"redis=redis://localhost:6379/0", kvToolsParams{backend: "redis", dsn: "redis://localhost:6379/0"}},
+		{"redis=redis://localhost:6379/0:min=500:conv", kvToolsParams{backend: "redis", dsn: "redis://localhost:6379/0", minTokens: 500, conv: "X-Conversation-Id"}},
+		{"conv=metadata.conversation_id", kvToolsParams{backend: "memory", conv: "metadata.conversation_id"}},
+	} {
+		got, err := parseKvToolsParams(tc.params)
+		if err != nil || got != tc.want {
+			t.Errorf("%q: %+v %v", tc.params, got, err)
+		}
+	}
+	for _, params := range []string{"memory:min=lots", "min=0"} {
+		if _, err := parseKvToolsParams(params); err == nil {
+			t.Errorf("%q: accepted", params)
+		}
+	}
+}
+
+func TestKvTools_ConversationMemory(t *testing.T) {
+	k := NewKvTools()
+	k.store, _ = kv.Open("memory", "")
+	request := func(traceID, keyID string, header http.Header) *http.Request {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header = header
+		ctx := context.WithValue(r.Context(), plugin.ContextTraceID(), traceID)
+		return r.WithContext(context.WithValue(ctx, plugin.ContextKeyID(), keyID))
+	}
+	recall := func(params string, r *http.Request, prog *ail.Program) string {
+		traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+		out, _, _ := k.HandleToolCall(params, "call_9", json.RawMessage(`{"tool_call_id":"call_1"}`),
+			&plugin.ToolCallContext{TraceID: traceID, Request: r, RequestProg: prog})
+		return out
+	}
+	conv := http.Header{"X-Conversation-Id": {"c1"}}
+
+	if _, err := k.Before("conv", nil, request("t1", "key-a", conv), toolConversation(100)); err != nil {
+		t.Fatal(err)
+	}
+	if got := recall("conv", request("t2", "key-a", conv), nil); !strings.HasPrefix(got, "result of call_1") {
+		t.Errorf("follow-up request recalled %q", got)
+	}
+	if got := recall("conv", request("t2", "key-b", conv), nil); strings.HasPrefix(got, "result of") {
+		t.Errorf("another key's request recalled %q", got)
+	}
+	if got := recall("", request("t2", "key-a", conv), nil); strings.HasPrefix(got, "result of") {
+		t.Errorf("an instance without conv recalled %q across requests", got)
+	}
+
+	// The conversation ID may be a body field instead.
+	const field = "conv=metadata.conversation_id"
+	prog := toolConversation(100)
+	prog.EmitKeyJSON(ail.EXT_DATA, "metadata", json.RawMessage(`{"conversation_id":"c2"}`))
+	if _, err := k.Before(field, nil, request("t3", "key-a", http.Header{}), prog); err != nil {
+		t.Fatal(err)
+	}
+	if got := recall(field, request("t4", "key-a", http.Header{}), prog); !strings.HasPrefix(got, "result of call_1") {
+		t.Errorf("follow-up request by body field recalled %q", got)
+	}
+}
@@ -0,0 +1,331 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// Mirror copies a share of production traffic into an evaluation dataset:
+// each picked request, with the response its client got, becomes one line
+// of JSONL in the OpenAI fine-tuning chat format
+//
+//	{"messages": [{"role": "system", ...}, ..., {"role": "assistant", ...}], "tools": [...]}
+//
+// so the files can seed evals or be uploaded for fine-tuning as they are.
+// The conversation is the request as the client sent it, before plugins
+// trimmed or rewrote it. Credentials and personal data (see
+// services.RedactPII) are redacted from message text, tool arguments and
+// tool results; reasoning, images and audio are left out. Failed requests
+// and responses without an assistant message are not mirrored.
+//
+// Lines are batched by a background writer into objects of up to
+// BatchSize lines, written at least every FlushInterval, named
+// "<partition><time>-<random>.jsonl" in the sink. When the writer falls
+// behind, lines are dropped rather than delaying requests.
+//
+// Registered as a tail plugin by modules.init() when MIRROR is set.
+type Mirror struct {
+	Sink SampleSink
+	// Partition groups files by UTC date: "day", "hour", or "" for none.
+	Partition string
+	// Rate is the fraction of requests mirrored; 0 mirrors all of them.
+	Rate float64
+	// Include and Exclude filter requests as for the Sampler.
+	Include []SampleMatch
+	Exclude []SampleMatch
+	// BatchSize caps the lines of one file; FlushInterval bounds how long
+	// a line waits to be written.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	mu        sync.Mutex
+	requests  map[string]*mirrorState
+	lastSweep time.Time
+
+	lines     chan []byte
+	startOnce sync.Once
+}
+
+type mirrorState struct {
+	start time.Time
+	req   *ail.Program
+}
+
+const (
+	defaultMirrorBatch = 1000
+	defaultMirrorFlush = time.Minute
+	mirrorQueueSize    = 1024
+)
+
+// NewMirrorFromEnv builds the mirror from the environment, or returns nil
+// when MIRROR is unset:
+//
+//	MIRROR            directory or s3:// / gs:// URL (see OpenSampleSink)
+//	MIRROR_PARTITION  day, hour or none
+//	MIRROR_RATE       fraction of requests to mirror: 0.01 or 1%
+//	MIRROR_INCLUDE    only mirror matching requests: model=gpt-4*,key=team-*
+//	MIRROR_EXCLUDE    never mirror matching requests: key=internal-*
+//	MIRROR_BATCH      lines per file, default 1000
+//	MIRROR_FLUSH      longest wait before lines are written, default 1m
+func NewMirrorFromEnv(getenv func(string) string) (*Mirror, error) {
+	spec := getenv("MIRROR")
+	if spec == "" {
+		return nil, nil
+	}
+	sink, err := OpenSampleSink(spec)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{Sink: sink}
+	if m.Partition, err = sinkPartition(sink, getenv("MIRROR_PARTITION")); err != nil {
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+	if m.Rate, err = parseSampleRate(getenv("MIRROR_RATE")); err != nil {
+		return nil, err
+	}
+	if m.Include, err = ParseSampleMatches(getenv("MIRROR_INCLUDE")); err != nil {
+		return nil, err
+	}
+	if m.Exclude, err = ParseSampleMatches(getenv("MIRROR_EXCLUDE")); err != nil {
+		return nil, err
+	}
+	if v := getenv("MIRROR_BATCH"); v != "" {
+		if m.BatchSize, err = strconv.Atoi(v); err != nil || m.BatchSize <= 0 {
+			return nil, fmt.Errorf("mirror: invalid batch %q", v)
+		}
+	}
+	if v := getenv("MIRROR_FLUSH"); v != "" {
+		d, err := caddy.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("mirror: invalid flush interval %q", v)
+		}
+		m.FlushInterval = d
+	}
+	return m, nil
+}
+
+func (m *Mirror) Name() string { return "mirror" }
+
+// FailOpen keeps a failing sink from failing the requests it mirrors.
+func (m *Mirror) FailOpen() bool { return true }
+
+// OnRequestInit picks the request and keeps it until its response.
+func (m *Mirror) OnRequestInit(r *http.Request, prog *ail.Program) {
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	if traceID == "" {
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[string]*mirrorState)
+	}
+	// InferFresh re-entry: the outer request is the one mirrored.
+	if _, ok := m.requests[traceID]; ok {
+		return
+	}
+	if now.Sub(m.lastSweep) > time.Minute {
+		for id, st := range m.requests {
+			if now.Sub(st.start) > watchStateTTL {
+				delete(m.requests, id)
+			}
+		}
+		m.lastSweep = now
+	}
+	if !pickRequest(m.Rate, m.Include, m.Exclude, r, prog) {
+		return
+	}
+	m.requests[traceID] = &mirrorState{start: now, req: prog.Clone()}
+}
+
+// After mirrors a complete response.
+func (m *Mirror) After(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	m.mirror(r, resProg)
+	return resProg, nil
+}
+
+// StreamEnd mirrors the assembled streamed response.
+func (m *Mirror) StreamEnd(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	m.mirror(r, ail.ReassembleStream(assembled))
+	return nil
+}
+
+func (m *Mirror) mirror(r *http.Request, res *ail.Program) {
+	// Sub-steps of recursive handlers are part of the outer request.
+	if _, step := plugin.SamplerStepFromContext(r.Context()); step || res == nil {
+		return
+	}
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	m.mu.Lock()
+	st := m.requests[traceID]
+	delete(m.requests, traceID)
+	m.mu.Unlock()
+	if st == nil {
+		return
+	}
+	line, ok, err := datasetLine(st.req, res)
+	if err != nil {
+		Logger.Error("MIRROR: encode failed", zap.Error(err))
+		return
+	}
+	if ok {
+		m.enqueue(line)
+	}
+}
+
+// datasetLine encodes req and the first assistant message of res as a
+// fine-tuning example; ok is false when res has no such message.
+func datasetLine(req, res *ail.Program) (line []byte, ok bool, err error) {
+	var reply *ail.Program
+	for _, span := range res.Messages() {
+		if span.Role == ail.ROLE_AST {
+			reply = res.ExtractMessage(span)
+			break
+		}
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	emitter, err := ail.GetEmitter(ail.StyleChatCompletions)
+	if err != nil {
+		return nil, false, err
+	}
+	body, err := emitter.EmitRequest(datasetProgram(req.Append(reply)))
+	if err != nil {
+		return nil, false, err
+	}
+	var example struct {
+		Messages []json.RawMessage `json:"messages"`
+		Tools    json.RawMessage   `json:"tools,omitempty"`
+	}
+	if err := json.Unmarshal(body, &example); err != nil {
+		return nil, false, err
+	}
+	if len(example.Messages) == 0 {
+		return nil, false, nil
+	}
+	line, err = json.Marshal(example)
+	return line, err == nil, err
+}
+
+// datasetProgram returns prog with its text, tool arguments and tool
+// results redacted, and without reasoning or media.
+func datasetProgram(prog *ail.Program) *ail.Program {
+	out := ail.NewProgram()
+	thinking := false
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.THINK_START:
+			thinking = true
+			continue
+		case ail.THINK_END:
+			thinking = false
+			continue
+		case ail.THINK_CHUNK, ail.THINK_REF, ail.IMG_REF, ail.AUD_REF:
+			continue
+		case ail.SET_META:
+			if inst.Key == "media_type" {
+				continue
+			}
+		case ail.TXT_CHUNK, ail.RESULT_DATA:
+			inst.Str = services.RedactPII(inst.Str)
+		case ail.TXT_REF:
+			if int(inst.Ref) < len(prog.Buffers) {
+				inst = ail.Instruction{Op: ail.TXT_CHUNK, Str: services.RedactPII(string(prog.Buffers[inst.Ref]))}
+			}
+		case ail.CALL_ARGS:
+			inst.JSON = json.RawMessage(services.RedactPII(string(inst.JSON)))
+		}
+		if !thinking {
+			out.Code = append(out.Code, inst)
+		}
+	}
+	return out
+}
+
+func (m *Mirror) enqueue(line []byte) {
+	m.startOnce.Do(func() {
+		m.lines = make(chan []byte, mirrorQueueSize)
+		go m.writeLoop()
+	})
+	select {
+	case m.lines <- line:
+	default:
+		Logger.Warn("MIRROR: write queue full, dropping a line")
+	}
+}
+
+// writeLoop batches lines into files: a file is written once it holds
+// BatchSize lines, or FlushInterval after its first line.
+func (m *Mirror) writeLoop() {
+	batch, flush := m.BatchSize, m.FlushInterval
+	if batch <= 0 {
+		batch = defaultMirrorBatch
+	}
+	if flush <= 0 {
+		flush = defaultMirrorFlush
+	}
+	var buf bytes.Buffer
+	n := 0
+	timer := time.NewTimer(flush)
+	timer.Stop()
+	write := func() {
+		if n > 0 {
+			m.put(buf.Bytes())
+		}
+		buf.Reset()
+		n = 0
+		timer.Stop()
+	}
+	for {
+		select {
+		case line := <-m.lines:
+			if n == 0 {
+				timer.Reset(flush)
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+			if n++; n >= batch {
+				write()
+			}
+		case <-timer.C:
+			write()
+		}
+	}
+}
+
+func (m *Mirror) put(data []byte) {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	now := time.Now().UTC()
+	name := partitionPrefix(m.Partition, now) + now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix[:]) + ".jsonl"
+	ctx, cancel := context.WithTimeout(context.Background(), samplerWriteTimeout)
+	defer cancel()
+	if err := m.Sink.Put(ctx, name, data); err != nil {
+		Logger.Error("MIRROR: write failed", zap.String("name", name), zap.Error(err))
+		return
+	}
+	Logger.Debug("MIRROR: wrote batch", zap.String("name", name))
+}
+
+var (
+	_ plugin.RequestInitPlugin = (*Mirror)(nil)
+	_ plugin.AfterPlugin       = (*Mirror)(nil)
+	_ plugin.StreamEndPlugin   = (*Mirror)(nil)
+	_ plugin.FailOpenPlugin    = (*Mirror)(nil)
+)
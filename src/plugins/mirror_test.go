@@ -0,0 +1,148 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+)
+
+func parseChatResponse(t *testing.T, body string) *ail.Program {
+	t.Helper()
+	parser, _ := ail.GetResponseParser(ail.StyleChatCompletions)
+	prog, err := parser.ParseResponse([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestDatasetLine(t *testing.T) {
+	parser, _ := ail.GetParser(ail.StyleChatCompletions)
+	req, err := parser.ParseRequest([]byte(`{"model":"m","temperature":0.2,"messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":[{"type":"text","text":"mail me at jane@example.com"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}],
+		"tools":[{"type":"function","function":{"name":"send","parameters":{"type":"object"}}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := parseChatResponse(t, `{"id":"r","model":"m","choices":[{"message":{"role":"assistant","reasoning_content":"hmm","content":"ok",
+		"tool_calls":[{"id":"c1","type":"function","function":{"name":"send","arguments":"{\"to\":\"jane@example.com\"}"}}]}}],
+		"usage":{"prompt_tokens":1,"completion_tokens":1}}`)
+
+	line, ok, err := datasetLine(req, res)
+	if err != nil || !ok {
+		t.Fatalf("datasetLine = %v, %v", ok, err)
+	}
+	var example struct {
+		Messages []struct {
+			Role      string          `json:"role"`
+			Content   json.RawMessage `json:"content"`
+			ToolCalls []struct {
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"messages"`
+		Tools []json.RawMessage `json:"tools"`
+	}
+	if err := json.Unmarshal(line, &example); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	if len(example.Messages) != 3 || len(example.Tools) != 1 {
+		t.Fatalf("expected 3 messages and 1 tool: %s", line)
+	}
+	if s := string(line); strings.Contains(s, "jane@") || strings.Contains(s, "hmm") ||
+		strings.Contains(s, "base64") || strings.Contains(s, "temperature") || strings.Contains(s, `"model"`) {
+		t.Errorf("line leaks PII, reasoning, media or parameters: %s", s)
+	}
+	reply := example.Messages[2]
+	if reply.Role != "assistant" || len(reply.ToolCalls) != 1 || reply.ToolCalls[0].Function.Arguments != `{"to":"[EMAIL]"}` {
+		t.Errorf("unexpected reply: %s", line)
+	}
+
+	if _, ok, _ := datasetLine(req, ail.NewProgram()); ok {
+		t.Error("a response without an assistant message should not be mirrored")
+	}
+}
+
+func TestMirror_WritesBatches(t *testing.T) {
+	dir := t.TempDir()
+	m := &Mirror{Sink: &DirSink{Dir: dir}, Partition: "day", BatchSize: 2, FlushInterval: time.Hour}
+	parser, _ := ail.GetParser(ail.StyleChatCompletions)
+	res := parseChatResponse(t, `{"choices":[{"message":{"role":"assistant","content":"hello"}}]}`)
+
+	for i, id := range []string{"a", "b", "sub"} {
+		req, _ := parser.ParseRequest([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), id))
+		m.OnRequestInit(r, req)
+		if id == "sub" {
+			// Sub-steps of recursive handlers are not mirrored.
+			r = r.WithContext(plugin.WithSamplerStep(r.Context(), plugin.SamplerStep{Index: 0}))
+		}
+		if i%2 == 0 {
+			m.After("", nil, r, req, nil, res)
+		} else {
+			m.StreamEnd("", nil, r, req, nil, res)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*.jsonl"))
+		if len(files) == 1 {
+			data, _ := os.ReadFile(files[0])
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 2 || !strings.Contains(lines[0], `"content":"hello"`) {
+				t.Fatalf("unexpected batch:\n%s", data)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch not written under %s", dir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.requests) != 1 {
+		t.Errorf("expected only the sub-step request pending, got %d", len(m.requests))
+	}
+}
+
+func TestNewMirrorFromEnv(t *testing.T) {
+	env := func(m map[string]string) func(string) string {
+		return func(k string) string { return m[k] }
+	}
+	if m, err := NewMirrorFromEnv(env(nil)); m != nil || err != nil {
+		t.Fatalf("MIRROR unset = %v, %v; want nil, nil", m, err)
+	}
+	m, err := NewMirrorFromEnv(env(map[string]string{
+		"MIRROR":         t.TempDir(),
+		"MIRROR_RATE":    "5%",
+		"MIRROR_INCLUDE": "model=gpt-4*",
+		"MIRROR_BATCH":   "50",
+		"MIRROR_FLUSH":   "30s",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rate != 0.05 || len(m.Include) != 1 || m.BatchSize != 50 || m.FlushInterval != 30*time.Second || m.Partition != "" {
+		t.Errorf("unexpected mirror: %+v", m)
+	}
+	for k, v := range map[string]string{"MIRROR_RATE": "2", "MIRROR_BATCH": "0", "MIRROR_FLUSH": "soon", "MIRROR_PARTITION": "week"} {
+		if _, err := NewMirrorFromEnv(env(map[string]string{"MIRROR": t.TempDir(), k: v})); err == nil {
+			t.Errorf("%s=%q: expected error", k, v)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// Remote hooks a plugin served by a sidecar, in any language, into the
+// chain. Each enabled hook is one HTTP call:
+//
+//	POST <url>/<hook>          hook is before, after or chunk
+//	Content-Type: application/x-ail
+//	X-Plugin-Params: <params>  the instance's params, if any
+//	X-Plugin-Provider: <name>  the provider the request goes to
+//	X-Plugin-Model: <model>    the requested model
+//	X-Request-Id: <trace ID>
+//
+// The body is the program the hook sees in binary AIL: the upstream request
+// for before, the response for after, one chunk for chunk. The sidecar
+// answers 200 with a replacement program (binary or text AIL), 204 to leave
+// it unchanged, or a 4xx to reject the request; the client then gets the
+// sidecar's status and message ({"error":{"message":...}} or plain text)
+// as guard_blocked.
+//
+// Anything else — a 5xx, no answer within Timeout, a program that does not
+// decode — is a failure: with FailOpen the program passes unchanged,
+// otherwise the request fails with plugin_error.
+//
+// Remote plugins are configured per router with remote_plugin.
+type Remote struct {
+	PluginName string
+	URL        string
+	Hooks      []string // default before and after
+	Timeout    time.Duration
+	FailOpen   bool
+	Requires   []string     // plugins that must run alongside it
+	Conflicts  []string     // plugins that must not
+	Client     *http.Client // see NewRemoteClient; nil uses http.DefaultClient
+}
+
+// Remote plugin hooks.
+const (
+	RemoteHookBefore = "before"
+	RemoteHookAfter  = "after"
+	RemoteHookChunk  = "chunk"
+)
+
+// defaultRemoteTimeout bounds each sidecar call when Timeout is unset.
+const defaultRemoteTimeout = 5 * time.Second
+
+// NewRemoteClient returns a client of its own for a remote plugin, with
+// its own connection pool, whose requests give up after timeout
+// (defaultRemoteTimeout when <= 0), body included.
+func NewRemoteClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+	client := services.NewProviderClient(services.ClientOptions{Connect: min(timeout, services.DefaultConnectTimeout)})
+	client.Timeout = timeout
+	return client
+}
+
+// remoteMaxResponse caps the program a sidecar may answer with.
+const remoteMaxResponse = 64 << 20
+
+func (p *Remote) Name() string { return p.PluginName }
+
+func (p *Remote) Dependencies(string) (plugin.Dependencies, error) {
+	return plugin.Dependencies{Requires: p.Requires, Conflicts: p.Conflicts}, nil
+}
+
+func (p *Remote) Before(params string, prov *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
+	return p.call(RemoteHookBefore, params, prov, r, prog, prog)
+}
+
+func (p *Remote) After(params string, prov *services.ProviderService, r *http.Request, reqProg *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	return p.call(RemoteHookAfter, params, prov, r, reqProg, resProg)
+}
+
+func (p *Remote) AfterChunk(params string, prov *services.ProviderService, r *http.Request, reqProg *ail.Program, _ *http.Response, chunk *ail.Program) (*ail.Program, error) {
+	return p.call(RemoteHookChunk, params, prov, r, reqProg, chunk)
+}
+
+// call runs hook on prog when the hook is enabled, applying the failure
+// policy; reqProg supplies the model.
+func (p *Remote) call(hook, params string, prov *services.ProviderService, r *http.Request, reqProg, prog *ail.Program) (*ail.Program, error) {
+	if !p.hooked(hook) || prog == nil {
+		return prog, nil
+	}
+	out, err := p.do(hook, params, prov, r, reqProg, prog)
+	if err == nil {
+		return out, nil
+	}
+	if _, blocked := err.(*services.RouterError); blocked {
+		return nil, err
+	}
+	if p.FailOpen && r.Context().Err() == nil {
+		Logger.Warn("remote plugin failed, passing through",
+			zap.String("plugin", p.PluginName), zap.String("hook", hook), zap.Error(err))
+		return prog, nil
+	}
+	return nil, fmt.Errorf("%s: %s: %w", p.PluginName, hook, err)
+}
+
+func (p *Remote) hooked(hook string) bool {
+	if len(p.Hooks) == 0 {
+		return hook == RemoteHookBefore || hook == RemoteHookAfter
+	}
+	return slices.Contains(p.Hooks, hook)
+}
+
+func (p *Remote) do(hook, params string, prov *services.ProviderService, r *http.Request, reqProg, prog *ail.Program) (*ail.Program, error) {
+	var body bytes.Buffer
+	if err := prog.Encode(&body); err != nil {
+		return nil, err
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.URL, "/")+"/"+hook, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ail")
+	req.Header.Set("Accept", "application/x-ail")
+	if params != "" {
+		req.Header.Set("X-Plugin-Params", params)
+	}
+	if prov != nil {
+		req.Header.Set("X-Plugin-Provider", prov.Name)
+	}
+	if reqProg != nil {
+		req.Header.Set("X-Plugin-Model", reqProg.GetModel())
+	}
+	if traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string); traceID != "" {
+		req.Header.Set(plugin.RequestIDHeader, traceID)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, remoteMaxResponse+1))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNoContent:
+		return prog, nil
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return nil, &services.RouterError{
+			Kind:    services.ErrorGuardBlocked,
+			Status:  res.StatusCode,
+			Message: remoteErrorMessage(p.PluginName, data),
+		}
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("sidecar returned %s", res.Status)
+	case len(data) > remoteMaxResponse:
+		return nil, fmt.Errorf("sidecar response over %d bytes", remoteMaxResponse)
+	}
+	return decodeRemoteProgram(data)
+}
+
+// decodeRemoteProgram decodes binary AIL, or text AIL when the magic is
+// missing.
+func decodeRemoteProgram(data []byte) (*ail.Program, error) {
+	if bytes.HasPrefix(data, []byte("AIL\x00")) {
+		return ail.Decode(bytes.NewReader(data))
+	}
+	return ail.Asm(string(data))
+}
+
+// remoteErrorMessage extracts the message of a rejecting sidecar's body.
+func remoteErrorMessage(name string, body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		return e.Error.Message
+	}
+	if msg := strings.TrimSpace(string(body)); msg != "" && len(msg) <= 1024 {
+		return msg
+	}
+	return "request rejected by " + name
+}
+
+var (
+	_ plugin.BeforePlugin      = (*Remote)(nil)
+	_ plugin.AfterPlugin       = (*Remote)(nil)
+	_ plugin.StreamChunkPlugin = (*Remote)(nil)
+	_ plugin.DependentPlugin   = (*Remote)(nil)
+)
@@ -0,0 +1,137 @@
+package plugins
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+func TestRemote(t *testing.T) {
+	// The last request's path and model header; a timed-out handler may
+	// still be running when the next request comes in.
+	var mu sync.Mutex
+	var lastPath, lastModel string
+	seen := func() (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return lastPath, lastModel
+	}
+	seenPath := func() string {
+		path, _ := seen()
+		return path
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastPath, lastModel = r.URL.Path, r.Header.Get("X-Plugin-Model")
+		mu.Unlock()
+		prog, err := ail.Decode(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.Header.Get("X-Plugin-Params") {
+		case "unchanged":
+			w.WriteHeader(http.StatusNoContent)
+		case "block":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"error":{"message":"no secrets"}}`)
+		case "crash":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case "text":
+			_, _ = io.WriteString(w, "SET_MODEL rewritten")
+		default:
+			prog.SetModel("rewritten")
+			_ = prog.Encode(w)
+		}
+	}))
+	defer srv.Close()
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "gpt-4o")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	prov := &services.ProviderService{Name: "openai"}
+	p := &Remote{PluginName: "guard", URL: srv.URL, Timeout: 50 * time.Millisecond}
+
+	out, err := p.Before("", prov, r, prog)
+	if gotPath, gotModel := seen(); err != nil || out.GetModel() != "rewritten" || gotPath != "/before" || gotModel != "gpt-4o" {
+		t.Fatalf("before: model %q, path %q, model header %q, err %v", out.GetModel(), gotPath, gotModel, err)
+	}
+	if out, err := p.After("text", prov, r, prog, nil, prog); err != nil || out.GetModel() != "rewritten" || seenPath() != "/after" {
+		t.Errorf("after (text AIL): %v, %v", out, err)
+	}
+	if out, err := p.Before("unchanged", prov, r, prog); err != nil || out != prog {
+		t.Errorf("204: %v, %v", out, err)
+	}
+
+	_, err = p.Before("block", prov, r, prog)
+	var re *services.RouterError
+	if !errors.As(err, &re) || re.Kind != services.ErrorGuardBlocked || re.HTTPStatus() != http.StatusForbidden || re.Message != "no secrets" {
+		t.Errorf("4xx: %#v", err)
+	}
+
+	for _, params := range []string{"crash", "slow"} {
+		if _, err := p.Before(params, prov, r, prog); err == nil || errors.As(err, &re) {
+			t.Errorf("%s, fail closed: %v", params, err)
+		}
+	}
+	open := *p
+	open.FailOpen = true
+	for _, params := range []string{"crash", "slow"} {
+		if out, err := open.Before(params, prov, r, prog); err != nil || out != prog {
+			t.Errorf("%s, fail open: %v, %v", params, out, err)
+		}
+	}
+	// Blocks stand whatever the policy.
+	if _, err := open.Before("block", prov, r, prog); !errors.As(err, &re) {
+		t.Errorf("block, fail open: %v", err)
+	}
+
+	// Chunks are only sent when the hook is enabled.
+	before := seenPath()
+	if out, err := p.AfterChunk("", prov, r, prog, nil, prog); err != nil || out != prog || seenPath() != before {
+		t.Errorf("chunk hook disabled: %v, %v, path %q", out, err, seenPath())
+	}
+	chunks := *p
+	chunks.Hooks = []string{RemoteHookChunk}
+	if out, err := chunks.AfterChunk("", prov, r, prog, nil, prog); err != nil || out.GetModel() != "rewritten" || seenPath() != "/chunk" {
+		t.Errorf("chunk: %v, %v", out, err)
+	}
+	if out, err := chunks.Before("", prov, r, prog); err != nil || out != prog {
+		t.Errorf("before hook disabled: %v, %v", out, err)
+	}
+}
+
+func TestNewRemoteClient(t *testing.T) {
+	if c := NewRemoteClient(0); c.Timeout != defaultRemoteTimeout || c == http.DefaultClient || c.Transport == http.DefaultTransport {
+		t.Errorf("default client: timeout %v, transport %T", c.Timeout, c.Transport)
+	}
+	if c := NewRemoteClient(2 * time.Second); c.Timeout != 2*time.Second {
+		t.Errorf("timeout = %v", c.Timeout)
+	}
+}
+
+func TestDecodeRemoteProgram(t *testing.T) {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	var buf bytes.Buffer
+	_ = prog.Encode(&buf)
+	for _, data := range [][]byte{buf.Bytes(), []byte("SET_MODEL m")} {
+		if got, err := decodeRemoteProgram(data); err != nil || got.GetModel() != "m" {
+			t.Errorf("%q: %v, %v", data, got, err)
+		}
+	}
+}
@@ -2,12 +2,13 @@ package plugins
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,38 +18,133 @@ import (
 	"go.uber.org/zap"
 )
 
-// Sampler persists AIL programs (request, upstream-prepared, response) to
-// disk for debugging and test-corpus collection.
+// Sampler persists AIL programs (request, upstream-prepared, response) for
+// debugging and test-corpus collection.
 //
-// File layout under Dir:
+// Layout in the sink, below an optional date partition ("2026/10/15/" or
+// "2026/10/15/13/"):
 //
-//	<dir>/<hash>/request.ail       – initial parsed request (binary)
-//	<dir>/<hash>/request.up.ail    – upstream-prepared after before-plugins (binary)
-//	<dir>/<hash>/response.ail      – complete response (binary)
-//	<dir>/<hash>.txt               – human-readable disassembly of all three
+//	<hash>/request.ail       – initial parsed request (binary)
+//	<hash>/request.up.ail    – upstream-prepared after before-plugins (binary)
+//	<hash>/response.ail      – complete response (binary)
+//	<hash>/stream.sse        – streamed response as sent to the client
+//	<hash>.txt               – human-readable disassembly of all three
 //
 // The hash is derived from the binary encoding of the initial request program.
 // Identical requests are deduplicated (the request.ail file is written only once).
 //
+// Redact removes user text, personal data or media from every program
+// before it is written, and Key, when set, encrypts every object with
+// AES-256-GCM (services.Seal; services.ReadSample unseals them). Streams
+// are raw provider output that can be neither redacted nor sealed
+// piecewise, so they are not archived while either is on; response.ail
+// still holds the assembled response.
+//
+// Writes go through a single background writer, in order, so slow sinks
+// such as object storage never delay requests; when the queue is full,
+// writes are dropped. Streams are written as they are sent, when the sink
+// is a StreamSink, and are discarded whole rather than cut short when the
+// sink falls behind or they exceed MaxBytes.
+//
 // The plugin is auto-enabled when registered in plugin.TailPlugins; it is
 // registered by modules.init() when the SAMPLER environment variable is set.
 type Sampler struct {
-	Dir string
-	// hashes maps traceID → request hash for the current request so that
-	// Before, After, and StreamEnd can reference the right sample directory.
-	hashes sync.Map
+	Sink SampleSink
+	// Partition groups samples by UTC date: "day", "hour", or "" for none.
+	Partition string
+	// Rate is the fraction of requests sampled; 0 samples all of them.
+	Rate float64
+	// Include, when set, limits sampling to requests matching any entry;
+	// requests matching any Exclude entry are never sampled.
+	Include []SampleMatch
+	Exclude []SampleMatch
+	// MaxBytes caps the encoded size of each program; 0 means unlimited.
+	// Oversized requests are not sampled, and oversized upstream requests
+	// and responses are noted in the .txt instead of being written.
+	MaxBytes int
+	// Redact says what is removed from programs before they are written.
+	Redact SampleRedaction
+	// Key, when set, is the AES-256 key objects are sealed with.
+	Key []byte
+
+	// samples maps traceID → *sampleState for the current request so that
+	// Before, After, and StreamEnd can reference the right sample.
+	samples sync.Map
+
+	writes    chan sampleWrite
+	startOnce sync.Once
+}
+
+// sampleState is the in-progress sample of one request. The disassembly is
+// kept in memory and rewritten whole at each stage, since object stores
+// cannot append.
+type sampleState struct {
+	base string // partition + hash
+	mu   sync.Mutex
+	txt  strings.Builder
 }
 
+type sampleWrite struct {
+	name     string
+	data     []byte
+	ifAbsent bool
+}
+
+const (
+	samplerQueueSize    = 1024
+	samplerWriteTimeout = time.Minute
+	// samplerStreamBuffer bounds the bytes of one stream waiting for the
+	// sink; a stream falling further behind is dropped.
+	samplerStreamBuffer = 4 << 20
+)
+
 // NewSampler creates a Sampler that writes samples into dir.
 func NewSampler(dir string) *Sampler {
-	return &Sampler{Dir: dir}
+	return &Sampler{Sink: &DirSink{Dir: dir}}
+}
+
+// NewSamplerFromSpec creates a Sampler for a SAMPLER spec (see
+// OpenSampleSink). An empty partition defaults to "day" for object
+// storage and none for local directories.
+func NewSamplerFromSpec(spec, partition string) (*Sampler, error) {
+	sink, err := OpenSampleSink(spec)
+	if err != nil {
+		return nil, err
+	}
+	if partition, err = sinkPartition(sink, partition); err != nil {
+		return nil, fmt.Errorf("sampler: %w", err)
+	}
+	return &Sampler{Sink: sink, Partition: partition}, nil
+}
+
+// sinkPartition resolves a partition setting for sink: "" defaults to
+// "day" for object storage and none for local directories.
+func sinkPartition(sink SampleSink, partition string) (string, error) {
+	switch partition {
+	case "":
+		if _, local := sink.(*DirSink); !local {
+			return "day", nil
+		}
+	case "none":
+		return "", nil
+	case "day", "hour":
+	default:
+		return "", fmt.Errorf("unknown partition %q (want day, hour or none)", partition)
+	}
+	return partition, nil
 }
 
 func (s *Sampler) Name() string { return "sampler" }
 
+// Priority runs the sampler after every other plugin, so it records
+// requests as they go upstream (after redaction, for instance).
+func (s *Sampler) Priority() int { return plugin.PriorityLast }
+
+// FailOpen keeps a failing sink from failing the requests it samples.
+func (s *Sampler) FailOpen() bool { return true }
+
 // OnRequestInit is called once per request with the original parsed program.
-// It computes the sample hash, creates the per-request directory, and writes
-// the initial request AIL.
+// It computes the sample hash and writes the initial request AIL.
 func (s *Sampler) OnRequestInit(r *http.Request, prog *ail.Program) {
 	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
 	if traceID == "" {
@@ -56,48 +152,84 @@ func (s *Sampler) OnRequestInit(r *http.Request, prog *ail.Program) {
 	}
 
 	// On InferFresh re-entry, OnRequestInit fires again with the sub-step's
-	// program. Skip if we already have a hash for this trace — all steps
-	// should land in the same sample directory.
-	if _, exists := s.hashes.Load(traceID); exists {
+	// program. Skip if we already have a sample for this trace — all steps
+	// should land in the same sample.
+	if _, exists := s.samples.Load(traceID); exists {
 		return
 	}
-
-	// Derive a stable hash from the binary encoding of the initial request.
-	var buf bytes.Buffer
-	if err := prog.Encode(&buf); err != nil {
-		Logger.Error("SAMPLER: encode failed for request", zap.Error(err))
+	if !s.shouldSample(r, prog) {
 		return
 	}
-	sum := sha256.Sum256(buf.Bytes())
-	hash := fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(sum[:]))
-
-	s.hashes.Store(traceID, hash)
 
-	detailsDir := filepath.Join(s.Dir, hash)
-	if err := os.MkdirAll(detailsDir, 0o755); err != nil {
-		Logger.Error("SAMPLER: failed to create directory", zap.String("dir", detailsDir), zap.Error(err))
+	st := s.startSample(prog)
+	if st == nil {
 		return
 	}
+	s.samples.Store(traceID, st)
+	Logger.Debug("SAMPLER: saved request", zap.String("sample", st.base))
+}
 
-	binPath := filepath.Join(detailsDir, "request.ail")
-	if _, err := os.Stat(binPath); err == nil {
-		// Already sampled this exact request — directory exists; skip writing.
-		Logger.Debug("SAMPLER: duplicate request, skipping write", zap.String("hash", hash))
-		return
+// startSample writes the request program and returns the new sample's
+// state, or nil when the request cannot be sampled.
+func (s *Sampler) startSample(prog *ail.Program) *sampleState {
+	data, fingerprint, err := encodeFingerprint(prog)
+	if err == nil && s.Redact.Enabled() {
+		prog, data, err = s.encode(prog)
 	}
+	if err != nil {
+		Logger.Error("SAMPLER: encode failed for request", zap.Error(err))
+		return nil
+	}
+	if s.oversized(len(data)) {
+		Logger.Debug("SAMPLER: request too large, skipping", zap.Int("bytes", len(data)))
+		return nil
+	}
+	now := time.Now().UTC()
+	st := &sampleState{base: s.partitionPrefix(now) + now.Format("20060102-150405") + "_" + fingerprint}
 
-	if err := os.WriteFile(binPath, buf.Bytes(), 0o644); err != nil {
-		Logger.Error("SAMPLER: write request binary failed", zap.String("path", binPath), zap.Error(err))
+	// Already sampled this exact request — the writer skips it.
+	s.enqueue(sampleWrite{name: st.base + "/request.ail", data: data, ifAbsent: true})
+	s.appendText(st, "", prog)
+	return st
+}
+
+// Capture writes a whole sample at once for a request the sampler did not
+// pick, such as one flagged by the watchdog; rate and filters do not
+// apply. If the request is already being sampled, only note is added.
+// up and res may be nil.
+func (s *Sampler) Capture(traceID, note string, req, up, res *ail.Program) {
+	if v, ok := s.samples.Load(traceID); ok {
+		s.appendNote(v.(*sampleState), note)
 		return
 	}
-
-	txtPath := filepath.Join(s.Dir, hash+".txt")
-	if err := os.WriteFile(txtPath, []byte(prog.Disasm()), 0o644); err != nil {
-		Logger.Error("SAMPLER: write request disasm failed", zap.String("path", txtPath), zap.Error(err))
+	st := s.startSample(req)
+	if st == nil {
 		return
 	}
-
-	Logger.Debug("SAMPLER: saved request", zap.String("hash", hash))
+	for _, part := range []struct {
+		name, label string
+		prog        *ail.Program
+	}{
+		{"request.up", "upstream request", up},
+		{"response", "response", res},
+	} {
+		if part.prog == nil {
+			continue
+		}
+		prog, data, err := s.encode(part.prog)
+		if err != nil {
+			Logger.Error("SAMPLER: encode failed", zap.String("part", part.name), zap.Error(err))
+			continue
+		}
+		if s.oversized(len(data)) {
+			s.appendNote(st, fmt.Sprintf("%s omitted: %d bytes", part.label, len(data)))
+			continue
+		}
+		s.enqueue(sampleWrite{name: st.base + "/" + part.name + ".ail", data: data})
+		s.appendText(st, part.label, prog)
+	}
+	s.appendNote(st, note)
+	Logger.Debug("SAMPLER: captured request", zap.String("sample", st.base))
 }
 
 // Before is called after all other before-plugins have run (sampler lives in
@@ -108,16 +240,14 @@ func (s *Sampler) OnRequestInit(r *http.Request, prog *ail.Program) {
 // the file is namespaced by step index: request.up.0.ail, request.up.1.ail, etc.
 func (s *Sampler) Before(_ string, _ *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
 	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
-	hashVal, ok := s.hashes.Load(traceID)
+	v, ok := s.samples.Load(traceID)
 	if !ok {
 		return prog, nil
 	}
-	hash := hashVal.(string)
+	st := v.(*sampleState)
 
-	detailsDir := filepath.Join(s.Dir, hash)
-
-	var buf bytes.Buffer
-	if err := prog.Encode(&buf); err != nil {
+	saved, data, err := s.encode(prog)
+	if err != nil {
 		Logger.Error("SAMPLER: encode failed for upstream request", zap.Error(err))
 		return prog, nil
 	}
@@ -128,29 +258,20 @@ func (s *Sampler) Before(_ string, _ *services.ProviderService, r *http.Request,
 	if hasStep {
 		suffix = fmt.Sprintf(".%d", step.Index)
 	}
-
-	binPath := filepath.Join(detailsDir, fmt.Sprintf("request.up%s.ail", suffix))
-	if err := os.WriteFile(binPath, buf.Bytes(), 0o644); err != nil {
-		Logger.Error("SAMPLER: write upstream binary failed", zap.String("path", binPath), zap.Error(err))
-		return prog, nil
-	}
-
-	txtPath := filepath.Join(s.Dir, hash+".txt")
-	f, err := os.OpenFile(txtPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
-	if err != nil {
-		Logger.Error("SAMPLER: open disasm file failed", zap.String("path", txtPath), zap.Error(err))
-		return prog, nil
-	}
 	label := "upstream request"
 	if hasStep && step.Label != "" {
 		label = fmt.Sprintf("upstream request [step %d: %s]", step.Index, step.Label)
 	} else if hasStep {
 		label = fmt.Sprintf("upstream request [step %d]", step.Index)
 	}
-	_, _ = f.WriteString("\n\n--- --- ---\n\n; " + label + "\n" + prog.Disasm())
-	_ = f.Close()
+	if s.oversized(len(data)) {
+		s.appendNote(st, fmt.Sprintf("%s omitted: %d bytes", label, len(data)))
+		return prog, nil
+	}
+	s.enqueue(sampleWrite{name: fmt.Sprintf("%s/request.up%s.ail", st.base, suffix), data: data})
+	s.appendText(st, label, saved)
 
-	Logger.Debug("SAMPLER: saved upstream request", zap.String("hash", hash), zap.String("suffix", suffix))
+	Logger.Debug("SAMPLER: saved upstream request", zap.String("sample", st.base), zap.String("suffix", suffix))
 	return prog, nil
 }
 
@@ -160,41 +281,38 @@ func (s *Sampler) After(_ string, _ *services.ProviderService, r *http.Request,
 	return resProg, nil
 }
 
-// StreamEnd is called once the stream is fully received.
-// lastChunk is the final chunk; the caller is expected to pass the assembled
-// program via the StreamEnd hook signature.
-func (s *Sampler) StreamEnd(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, lastChunk *ail.Program) error {
-	s.writeResponse(r, lastChunk)
+// StreamEnd is called once the stream is fully received, with the
+// assembled response.
+func (s *Sampler) StreamEnd(_ string, _ *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	s.writeResponse(r, assembled)
 	return nil
 }
 
 // writeResponse persists the response AIL and appends its disassembly.
 // When a SamplerStep is set in context, files are namespaced by step index
-// and the hash is NOT deleted (more steps may follow). The hash is cleaned
-// up when a response is written without step context (final response).
+// and the sample is NOT released (more steps may follow). The sample is
+// released when a response is written without step context (final response).
 func (s *Sampler) writeResponse(r *http.Request, prog *ail.Program) {
 	if prog == nil {
 		return
 	}
 	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
-	hashVal, ok := s.hashes.Load(traceID)
+	v, ok := s.samples.Load(traceID)
 	if !ok {
 		return
 	}
-	hash := hashVal.(string)
+	st := v.(*sampleState)
 
 	step, hasStep := plugin.SamplerStepFromContext(r.Context())
 
-	// Only delete the hash when this is NOT a sub-step write.
-	// Sub-step writes leave the hash alive for subsequent steps.
+	// Only release the sample when this is NOT a sub-step write.
+	// Sub-step writes leave it alive for subsequent steps.
 	if !hasStep {
-		defer s.hashes.Delete(traceID)
+		defer s.samples.Delete(traceID)
 	}
 
-	detailsDir := filepath.Join(s.Dir, hash)
-
-	var buf bytes.Buffer
-	if err := prog.Encode(&buf); err != nil {
+	prog, data, err := s.encode(prog)
+	if err != nil {
 		Logger.Error("SAMPLER: encode failed for response", zap.Error(err))
 		return
 	}
@@ -203,29 +321,248 @@ func (s *Sampler) writeResponse(r *http.Request, prog *ail.Program) {
 	if hasStep {
 		suffix = fmt.Sprintf(".%d", step.Index)
 	}
-
-	binPath := filepath.Join(detailsDir, fmt.Sprintf("response%s.ail", suffix))
-	if err := os.WriteFile(binPath, buf.Bytes(), 0o644); err != nil {
-		Logger.Error("SAMPLER: write response binary failed", zap.String("path", binPath), zap.Error(err))
+	label := "response"
+	if hasStep && step.Label != "" {
+		label = fmt.Sprintf("response [step %d: %s]", step.Index, step.Label)
+	} else if hasStep {
+		label = fmt.Sprintf("response [step %d]", step.Index)
+	}
+	if s.oversized(len(data)) {
+		s.appendNote(st, fmt.Sprintf("%s omitted: %d bytes", label, len(data)))
 		return
 	}
+	s.enqueue(sampleWrite{name: fmt.Sprintf("%s/response%s.ail", st.base, suffix), data: data})
+	s.appendText(st, label, prog)
+
+	Logger.Debug("SAMPLER: saved response", zap.String("sample", st.base), zap.String("suffix", suffix))
+}
+
+// TeeStream returns a writer archiving the SSE stream of a sampled request
+// as <hash>/stream<suffix>.sse, or nil when the request is not sampled,
+// the sink cannot write incrementally, or the sampler redacts or seals.
+func (s *Sampler) TeeStream(_ string, _ *services.ProviderService, r *http.Request) io.WriteCloser {
+	sink, ok := s.Sink.(StreamSink)
+	if !ok || s.Redact.Enabled() || s.Key != nil {
+		return nil
+	}
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	v, ok := s.samples.Load(traceID)
+	if !ok {
+		return nil
+	}
+	st := v.(*sampleState)
+	suffix := ""
+	if step, hasStep := plugin.SamplerStepFromContext(r.Context()); hasStep {
+		suffix = fmt.Sprintf(".%d", step.Index)
+	}
+	t := &streamTee{
+		s:      s,
+		st:     st,
+		name:   fmt.Sprintf("%s/stream%s.sse", st.base, suffix),
+		notify: make(chan struct{}, 1),
+	}
+	go t.run(sink)
+	return t
+}
+
+// streamTee hands a stream's writes to a goroutine writing them to the
+// sink, so the client is never held up by it. At most samplerStreamBuffer
+// bytes wait for the sink at any time.
+type streamTee struct {
+	s      *Sampler
+	st     *sampleState
+	name   string
+	notify chan struct{}
+
+	mu      sync.Mutex
+	pending []byte
+	size    int
+	dropped string // why the stream is discarded
+	closed  bool
+}
+
+func (t *streamTee) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.dropped != "" {
+		return len(b), nil
+	}
+	t.size += len(b)
+	switch {
+	case t.s.oversized(t.size):
+		t.dropped = fmt.Sprintf("stream omitted: over %d bytes", t.s.MaxBytes)
+		t.pending = nil
+	case len(t.pending)+len(b) > samplerStreamBuffer:
+		t.dropped = "stream omitted: sink too slow"
+		t.pending = nil
+	default:
+		t.pending = append(t.pending, b...)
+	}
+	t.wake()
+	return len(b), nil
+}
 
-	txtPath := filepath.Join(s.Dir, hash+".txt")
-	f, err := os.OpenFile(txtPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+func (t *streamTee) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.wake()
+	return nil
+}
+
+func (t *streamTee) wake() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for pending data and takes it; done is set once the stream
+// is closed or dropped and nothing is left to write.
+func (t *streamTee) next() (data []byte, done bool) {
+	for {
+		t.mu.Lock()
+		data, t.pending = t.pending, nil
+		done = t.dropped != "" || (t.closed && len(data) == 0)
+		t.mu.Unlock()
+		if done || len(data) > 0 {
+			return data, done
+		}
+		<-t.notify
+	}
+}
+
+func (t *streamTee) run(sink StreamSink) {
+	obj, err := sink.Create(context.Background(), t.name)
+	for err == nil {
+		data, done := t.next()
+		if done {
+			break
+		}
+		_, err = obj.Write(data)
+	}
 	if err != nil {
-		Logger.Error("SAMPLER: open disasm file failed for response", zap.String("path", txtPath), zap.Error(err))
+		if obj != nil {
+			obj.Abort()
+		}
+		Logger.Error("SAMPLER: stream write failed", zap.String("name", t.name), zap.Error(err))
+		t.mu.Lock()
+		t.dropped, t.pending = "stream write failed", nil
+		t.mu.Unlock()
 		return
 	}
-	label := "response"
-	if hasStep && step.Label != "" {
-		label = fmt.Sprintf("response [step %d: %s]", step.Index, step.Label)
-	} else if hasStep {
-		label = fmt.Sprintf("response [step %d]", step.Index)
+	t.mu.Lock()
+	reason := t.dropped
+	t.mu.Unlock()
+	if reason != "" {
+		obj.Abort()
+		Logger.Warn("SAMPLER: "+reason, zap.String("name", t.name))
+		t.s.appendNote(t.st, reason)
+		return
+	}
+	if err := obj.Close(); err != nil {
+		Logger.Error("SAMPLER: stream write failed", zap.String("name", t.name), zap.Error(err))
+		return
+	}
+	Logger.Debug("SAMPLER: saved stream", zap.String("sample", t.st.base))
+}
+
+// appendText adds prog's disassembly to the sample's text file; the first
+// section (empty label) is the request itself.
+func (s *Sampler) appendText(st *sampleState, label string, prog *ail.Program) {
+	st.mu.Lock()
+	if label != "" {
+		st.txt.WriteString("\n\n--- --- ---\n\n; " + label + "\n")
+	}
+	st.txt.WriteString(prog.Disasm())
+	data := []byte(st.txt.String())
+	st.mu.Unlock()
+	s.enqueue(sampleWrite{name: st.base + ".txt", data: data})
+}
+
+// appendNote adds a one-line section to the sample's text file.
+func (s *Sampler) appendNote(st *sampleState, note string) {
+	st.mu.Lock()
+	st.txt.WriteString("\n\n--- --- ---\n\n; " + note + "\n")
+	data := []byte(st.txt.String())
+	st.mu.Unlock()
+	s.enqueue(sampleWrite{name: st.base + ".txt", data: data})
+}
+
+// encodeFingerprint returns the binary encoding of prog and its SHA-256,
+// which identifies identical requests across samples and logs.
+func encodeFingerprint(prog *ail.Program) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:]), nil
+}
+
+// encode redacts prog as configured and returns the redacted program with
+// its binary encoding.
+func (s *Sampler) encode(prog *ail.Program) (*ail.Program, []byte, error) {
+	prog = s.Redact.Apply(prog)
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		return nil, nil, err
 	}
-	_, _ = f.WriteString("\n\n--- --- ---\n\n; " + label + "\n" + prog.Disasm())
-	_ = f.Close()
+	return prog, buf.Bytes(), nil
+}
+
+func (s *Sampler) oversized(n int) bool { return s.MaxBytes > 0 && n > s.MaxBytes }
+
+func (s *Sampler) partitionPrefix(t time.Time) string {
+	return partitionPrefix(s.Partition, t)
+}
 
-	Logger.Debug("SAMPLER: saved response", zap.String("hash", hash), zap.String("suffix", suffix))
+func partitionPrefix(partition string, t time.Time) string {
+	switch partition {
+	case "day":
+		return t.Format("2006/01/02/")
+	case "hour":
+		return t.Format("2006/01/02/15/")
+	}
+	return ""
+}
+
+func (s *Sampler) enqueue(w sampleWrite) {
+	s.startOnce.Do(func() {
+		s.writes = make(chan sampleWrite, samplerQueueSize)
+		go s.writeLoop()
+	})
+	select {
+	case s.writes <- w:
+	default:
+		Logger.Warn("SAMPLER: write queue full, dropping", zap.String("name", w.name))
+	}
+}
+
+func (s *Sampler) writeLoop() {
+	for w := range s.writes {
+		ctx, cancel := context.WithTimeout(context.Background(), samplerWriteTimeout)
+		if w.ifAbsent {
+			if exists, err := s.Sink.Exists(ctx, w.name); err == nil && exists {
+				Logger.Debug("SAMPLER: duplicate, skipping write", zap.String("name", w.name))
+				cancel()
+				continue
+			}
+		}
+		data := w.data
+		if s.Key != nil {
+			var err error
+			if data, err = services.Seal(s.Key, data); err != nil {
+				Logger.Error("SAMPLER: seal failed", zap.String("name", w.name), zap.Error(err))
+				cancel()
+				continue
+			}
+		}
+		if err := s.Sink.Put(ctx, w.name, data); err != nil {
+			Logger.Error("SAMPLER: write failed", zap.String("name", w.name), zap.Error(err))
+		}
+		cancel()
+	}
 }
 
 // Ensure Sampler implements the required plugin interfaces.
@@ -234,4 +571,7 @@ var (
 	_ plugin.BeforePlugin      = (*Sampler)(nil)
 	_ plugin.AfterPlugin       = (*Sampler)(nil)
 	_ plugin.StreamEndPlugin   = (*Sampler)(nil)
+	_ plugin.StreamTeePlugin   = (*Sampler)(nil)
+	_ plugin.OrderedPlugin     = (*Sampler)(nil)
+	_ plugin.FailOpenPlugin    = (*Sampler)(nil)
 )
@@ -0,0 +1,175 @@
+package plugins
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// SampleMatch selects requests by a glob on one attribute: "model", "key"
+// (the authenticated key ID) or "plugin" (any plugin in the request's chain).
+type SampleMatch struct {
+	Field   string
+	Pattern string
+}
+
+// NewSamplerFromEnv builds the sampler from the environment, or returns nil
+// when SAMPLER is unset:
+//
+//	SAMPLER            directory or s3:// / gs:// URL (see OpenSampleSink)
+//	SAMPLER_PARTITION  day, hour or none
+//	SAMPLER_RATE       fraction of requests to sample: 0.01 or 1%
+//	SAMPLER_INCLUDE    only sample matching requests: model=gpt-4*,key=team-*
+//	SAMPLER_EXCLUDE    never sample matching requests: plugin=kvtools
+//	SAMPLER_MAX_BYTES  skip programs larger than this: 1048576, 512k or 4m
+//	SAMPLER_REDACT     remove before writing: user (user message text),
+//	                   pii (hashed), images; e.g. user,pii
+//	SAMPLER_KEY        encrypt samples with this AES-256 key (64 hex digits
+//	                   or base64); the PII hashes use a key derived from it,
+//	                   else a random one, so hashes only match within a run
+func NewSamplerFromEnv(getenv func(string) string) (*Sampler, error) {
+	spec := getenv("SAMPLER")
+	if spec == "" {
+		return nil, nil
+	}
+	s, err := NewSamplerFromSpec(spec, getenv("SAMPLER_PARTITION"))
+	if err != nil {
+		return nil, err
+	}
+	if s.Rate, err = parseSampleRate(getenv("SAMPLER_RATE")); err != nil {
+		return nil, err
+	}
+	if s.Include, err = ParseSampleMatches(getenv("SAMPLER_INCLUDE")); err != nil {
+		return nil, err
+	}
+	if s.Exclude, err = ParseSampleMatches(getenv("SAMPLER_EXCLUDE")); err != nil {
+		return nil, err
+	}
+	if s.MaxBytes, err = parseByteSize(getenv("SAMPLER_MAX_BYTES")); err != nil {
+		return nil, err
+	}
+	if s.Redact, err = ParseSampleRedaction(getenv("SAMPLER_REDACT")); err != nil {
+		return nil, err
+	}
+	if v := getenv("SAMPLER_KEY"); v != "" {
+		if s.Key, err = services.ParseSealKey(v); err != nil {
+			return nil, fmt.Errorf("sampler: SAMPLER_KEY: %w", err)
+		}
+		s.Redact.HashKey = services.PIIHashKey(s.Key)
+	}
+	if s.Redact.PII && s.Redact.HashKey == nil {
+		s.Redact.HashKey = make([]byte, 32)
+		if _, err := crand.Read(s.Redact.HashKey); err != nil {
+			return nil, fmt.Errorf("sampler: PII hash key: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// ParseSampleMatches parses a comma-separated list of field=glob matches.
+func ParseSampleMatches(spec string) ([]SampleMatch, error) {
+	var out []SampleMatch
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field, pattern, ok := strings.Cut(part, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("sampler: filter %q must be field=glob", part)
+		}
+		switch field {
+		case "model", "key", "plugin":
+		default:
+			return nil, fmt.Errorf("sampler: unknown filter field %q (want model, key or plugin)", field)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("sampler: bad pattern %q: %v", pattern, err)
+		}
+		out = append(out, SampleMatch{Field: field, Pattern: pattern})
+	}
+	return out, nil
+}
+
+func parseSampleRate(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	pct := strings.HasSuffix(v, "%")
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if pct {
+		rate /= 100
+	}
+	if err != nil || rate <= 0 || rate > 1 {
+		return 0, fmt.Errorf("sampler: invalid rate %q (want 0 < rate <= 1, or a percentage)", v)
+	}
+	return rate, nil
+}
+
+func parseByteSize(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	mult := 1
+	switch {
+	case strings.HasSuffix(strings.ToLower(v), "k"):
+		mult, v = 1<<10, v[:len(v)-1]
+	case strings.HasSuffix(strings.ToLower(v), "m"):
+		mult, v = 1<<20, v[:len(v)-1]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("sampler: invalid max bytes %q", v)
+	}
+	return n * mult, nil
+}
+
+// shouldSample applies the filters, then the sampling rate.
+func (s *Sampler) shouldSample(r *http.Request, prog *ail.Program) bool {
+	return pickRequest(s.Rate, s.Include, s.Exclude, r, prog)
+}
+
+// pickRequest applies include and exclude filters, then picks rate of the
+// requests left; a rate of 0 picks them all.
+func pickRequest(rate float64, include, exclude []SampleMatch, r *http.Request, prog *ail.Program) bool {
+	if len(include) > 0 && !anySampleMatch(include, r, prog) {
+		return false
+	}
+	if anySampleMatch(exclude, r, prog) {
+		return false
+	}
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate
+}
+
+func anySampleMatch(matches []SampleMatch, r *http.Request, prog *ail.Program) bool {
+	for _, m := range matches {
+		var values []string
+		switch m.Field {
+		case "model":
+			values = []string{prog.GetModel()}
+		case "key":
+			keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+			values = []string{keyID}
+		case "plugin":
+			names, _ := r.Context().Value(plugin.ContextPlugins()).([]string)
+			for _, n := range names {
+				name, _, _ := strings.Cut(n, ":")
+				values = append(values, name)
+			}
+		}
+		for _, v := range values {
+			if ok, _ := path.Match(m.Pattern, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
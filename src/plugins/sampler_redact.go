@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// SampleRedaction says what the sampler removes from programs before
+// writing them. The request fingerprint naming a sample is still taken
+// from the program as received, so identical requests stay deduplicated.
+type SampleRedaction struct {
+	// UserText replaces the text of user messages with its size.
+	UserText bool
+	// PII replaces personal data in all text, including text and
+	// reasoning held in buffers, tool arguments and tool results with a
+	// keyed hash (services.HashPII) under HashKey; credentials are
+	// redacted outright.
+	PII     bool
+	HashKey []byte
+	// Images replaces images, files and audio clips with placeholder
+	// text, dropping their data.
+	Images bool
+}
+
+// ParseSampleRedaction parses a comma-separated list of "user", "pii" and
+// "images".
+func ParseSampleRedaction(spec string) (SampleRedaction, error) {
+	var rd SampleRedaction
+	for _, part := range strings.Split(spec, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "":
+		case "user":
+			rd.UserText = true
+		case "pii":
+			rd.PII = true
+		case "images":
+			rd.Images = true
+		default:
+			return SampleRedaction{}, fmt.Errorf("sampler: unknown redaction %q (want user, pii or images)", part)
+		}
+	}
+	return rd, nil
+}
+
+// Enabled reports whether any redaction is set.
+func (rd SampleRedaction) Enabled() bool { return rd.UserText || rd.PII || rd.Images }
+
+// Apply returns a redacted copy of prog, or prog itself when no redaction
+// is set.
+func (rd SampleRedaction) Apply(prog *ail.Program) *ail.Program {
+	if !rd.Enabled() {
+		return prog
+	}
+	out := prog.Clone()
+	if rd.UserText {
+		for _, msg := range out.MessagesByRole(ail.ROLE_USR) {
+			for i := msg.Start; i <= msg.End && i < len(out.Code); i++ {
+				switch inst := &out.Code[i]; inst.Op {
+				case ail.TXT_CHUNK:
+					inst.Str = redactedSize(len(inst.Str))
+				case ail.TXT_REF:
+					*inst = ail.Instruction{Op: ail.TXT_CHUNK, Str: redactedSize(len(bufferOf(out, inst.Ref)))}
+				}
+			}
+		}
+	}
+	var drop []int
+	hashed := map[uint32]bool{} // buffers already hashed, in case several refs share one
+	for i := range out.Code {
+		inst := &out.Code[i]
+		switch inst.Op {
+		case ail.TXT_CHUNK, ail.THINK_CHUNK, ail.RESULT_DATA:
+			if rd.PII {
+				inst.Str = services.HashPII(inst.Str, rd.HashKey)
+			}
+		case ail.TXT_REF, ail.THINK_REF:
+			if rd.PII && int(inst.Ref) < len(out.Buffers) && !hashed[inst.Ref] {
+				hashed[inst.Ref] = true
+				out.Buffers[inst.Ref] = []byte(services.HashPII(string(out.Buffers[inst.Ref]), rd.HashKey))
+			}
+		case ail.CALL_ARGS:
+			if rd.PII {
+				// A hashed number is no longer JSON; fall back to the size.
+				if args := services.HashPII(string(inst.JSON), rd.HashKey); json.Valid([]byte(args)) {
+					inst.JSON = json.RawMessage(args)
+				} else {
+					inst.JSON = json.RawMessage(strconv.Quote(redactedSize(len(inst.JSON))))
+				}
+			}
+		case ail.IMG_REF, ail.AUD_REF:
+			if rd.Images {
+				if int(inst.Ref) < len(out.Buffers) {
+					out.Buffers[inst.Ref] = nil
+				}
+				label := "[image omitted]"
+				if inst.Op == ail.AUD_REF {
+					label = "[audio omitted]"
+				}
+				*inst = ail.Instruction{Op: ail.TXT_CHUNK, Str: label}
+				if i > 0 && out.Code[i-1].Op == ail.SET_META && out.Code[i-1].Key == "media_type" {
+					drop = append(drop, i-1)
+				}
+			}
+		}
+	}
+	if len(drop) > 0 {
+		out = out.ClearAtIndex(drop...)
+	}
+	return out
+}
+
+func redactedSize(n int) string {
+	return "[" + strconv.Itoa(n) + " bytes]"
+}
+
+func bufferOf(prog *ail.Program, ref uint32) []byte {
+	if int(ref) < len(prog.Buffers) {
+		return prog.Buffers[ref]
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package plugins
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SampleSink stores sampler objects. Names are slash-separated relative
+// paths such as "2026/10/15/<hash>/request.ail".
+type SampleSink interface {
+	// Put writes data under name, replacing any existing object.
+	Put(ctx context.Context, name string, data []byte) error
+	// Exists reports whether name has been written.
+	Exists(ctx context.Context, name string) (bool, error)
+}
+
+// StreamSink is a SampleSink that can also write an object as its data
+// arrives, so long streams are archived without being held in memory.
+type StreamSink interface {
+	SampleSink
+	// Create starts writing name. The object appears once the writer is
+	// closed without error; Abort discards it.
+	Create(ctx context.Context, name string) (ObjectWriter, error)
+}
+
+// ObjectWriter writes one object of a StreamSink.
+type ObjectWriter interface {
+	io.WriteCloser
+	Abort()
+}
+
+// OpenSampleSink returns the sink for a SAMPLER spec:
+//
+//	/var/samples                           local directory
+//	s3://bucket/prefix[?region=&endpoint=] Amazon S3, or MinIO and other
+//	                                       S3-compatible stores via endpoint
+//	gs://bucket/prefix                     Google Cloud Storage (HMAC keys)
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN; GCS uses GCS_HMAC_ACCESS_KEY and GCS_HMAC_SECRET.
+func OpenSampleSink(spec string) (SampleSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "s3" && u.Scheme != "gs") {
+		return &DirSink{Dir: spec}, nil
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("sampler: %s URL needs a bucket", u.Scheme)
+	}
+	s := &S3Sink{
+		Bucket: u.Host,
+		Prefix: strings.Trim(u.Path, "/"),
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	q := u.Query()
+	if u.Scheme == "gs" {
+		s.Endpoint = "https://storage.googleapis.com"
+		s.Region = "auto"
+		s.AccessKey = os.Getenv("GCS_HMAC_ACCESS_KEY")
+		s.SecretKey = os.Getenv("GCS_HMAC_SECRET")
+	} else {
+		s.Region = cmp.Or(q.Get("region"), os.Getenv("AWS_REGION"), "us-east-1")
+		s.Endpoint = cmp.Or(q.Get("endpoint"), "https://s3."+s.Region+".amazonaws.com")
+		s.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("sampler: no credentials for %s://%s", u.Scheme, u.Host)
+	}
+	return s, nil
+}
+
+// ─── Local directory ────────────────────────────────────────────────────────
+
+// DirSink writes samples below a local directory.
+type DirSink struct {
+	Dir string
+}
+
+func (d *DirSink) Put(_ context.Context, name string, data []byte) error {
+	path := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (d *DirSink) Exists(_ context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(d.Dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Create writes to a temporary file next to name and renames it into
+// place on Close, so readers never see a partial object.
+func (d *DirSink) Create(_ context.Context, name string) (ObjectWriter, error) {
+	path := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	return &fileObject{File: f, path: path}, nil
+}
+
+type fileObject struct {
+	*os.File
+	path string
+}
+
+func (f *fileObject) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+func (f *fileObject) Abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// ─── S3-compatible object storage ───────────────────────────────────────────
+
+// S3Sink writes samples to an S3-compatible bucket using path-style
+// requests signed with AWS Signature Version 4.
+type S3Sink struct {
+	Endpoint     string // scheme://host[:port]
+	Bucket       string
+	Prefix       string // key prefix, without surrounding slashes
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+func (s *S3Sink) Put(ctx context.Context, name string, data []byte) error {
+	res, err := s.do(ctx, http.MethodPut, name, "", data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("sampler: PUT %s: %s: %s", name, res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (s *S3Sink) Exists(ctx context.Context, name string) (bool, error) {
+	res, err := s.do(ctx, http.MethodHead, name, "", nil)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode/100 == 2:
+		return true, nil
+	}
+	return false, fmt.Errorf("sampler: HEAD %s: %s", name, res.Status)
+}
+
+// do sends a signed request for the object name; query must already be
+// in canonical form (sorted, escaped).
+func (s *S3Sink) do(ctx context.Context, method, name, query string, body []byte) (*http.Response, error) {
+	key := name
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + name
+	}
+	u := strings.TrimRight(s.Endpoint, "/") + "/" + s3Escape(s.Bucket) + "/" + s3Escape(key)
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	signV4(req, s.AccessKey, s.SecretKey, s.Region, "s3", time.Now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// s3PartSize is the part size of multipart uploads; S3 requires at least
+// 5 MiB for every part but the last.
+const s3PartSize = 5 << 20
+
+// Create buffers one part at a time: objects that fit in a single part
+// are written with one PUT on Close, larger ones as a multipart upload.
+func (s *S3Sink) Create(ctx context.Context, name string) (ObjectWriter, error) {
+	return &s3Object{s: s, ctx: ctx, name: name}, nil
+}
+
+type s3Object struct {
+	s        *S3Sink
+	ctx      context.Context
+	name     string
+	buf      []byte
+	uploadID string
+	etags    []string
+	err      error
+}
+
+func (o *s3Object) Write(b []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.buf = append(o.buf, b...)
+	for len(o.buf) >= s3PartSize {
+		if o.err = o.uploadPart(o.buf[:s3PartSize]); o.err != nil {
+			return 0, o.err
+		}
+		o.buf = o.buf[:copy(o.buf, o.buf[s3PartSize:])]
+	}
+	return len(b), nil
+}
+
+func (o *s3Object) Close() error {
+	if o.err != nil {
+		o.Abort()
+		return o.err
+	}
+	if o.uploadID == "" {
+		return o.s.Put(o.ctx, o.name, o.buf)
+	}
+	if len(o.buf) > 0 {
+		if err := o.uploadPart(o.buf); err != nil {
+			o.Abort()
+			return err
+		}
+	}
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for i, etag := range o.etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+	body, _ := xml.Marshal(complete)
+	res, err := o.s.do(o.ctx, http.MethodPost, o.name, url.Values{"uploadId": {o.uploadID}}.Encode(), body)
+	if err != nil {
+		o.Abort()
+		return err
+	}
+	defer res.Body.Close()
+	// Completion can fail after a 200, with the error in the body.
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if res.StatusCode/100 != 2 || bytes.Contains(data, []byte("<Error>")) {
+		o.Abort()
+		return fmt.Errorf("sampler: complete upload %s: %s: %s", o.name, res.Status, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+func (o *s3Object) Abort() {
+	if o.uploadID == "" {
+		return
+	}
+	if res, err := o.s.do(o.ctx, http.MethodDelete, o.name, url.Values{"uploadId": {o.uploadID}}.Encode(), nil); err == nil {
+		res.Body.Close()
+	}
+	o.uploadID = ""
+}
+
+// uploadPart sends the next part, starting the multipart upload first.
+func (o *s3Object) uploadPart(data []byte) error {
+	if o.uploadID == "" {
+		res, err := o.s.do(o.ctx, http.MethodPost, o.name, "uploads=", nil)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		var init struct {
+			UploadID string `xml:"UploadId"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if res.StatusCode/100 != 2 || xml.Unmarshal(body, &init) != nil || init.UploadID == "" {
+			return fmt.Errorf("sampler: start upload %s: %s: %s", o.name, res.Status, bytes.TrimSpace(body))
+		}
+		o.uploadID = init.UploadID
+	}
+	q := url.Values{"partNumber": {strconv.Itoa(len(o.etags) + 1)}, "uploadId": {o.uploadID}}
+	res, err := o.s.do(o.ctx, http.MethodPut, o.name, q.Encode(), data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("sampler: upload part of %s: %s: %s", o.name, res.Status, bytes.TrimSpace(body))
+	}
+	o.etags = append(o.etags, res.Header.Get("ETag"))
+	return nil
+}
+
+// signV4 signs req with AWS Signature Version 4, covering the host and
+// every header already set. X-Amz-Content-Sha256 must be set.
+func signV4(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes an object key as SigV4 expects: everything but
+// unreserved characters and the "/" separators.
+func s3Escape(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+var (
+	_ StreamSink = (*DirSink)(nil)
+	_ StreamSink = (*S3Sink)(nil)
+)
@@ -14,9 +14,12 @@ import (
 //
 // Syntax:
 //
-//	slwin          → keep 1 from start, 10 from end (defaults)
-//	slwin:15       → keep 1 from start, 15 from end
-//	slwin:15:3     → keep 3 from start, 15 from end
+//	slwin                → keep 1 from start, 10 from end (defaults)
+//	slwin:15             → keep 1 from start, 15 from end
+//	slwin:15:3           → keep 3 from start, 15 from end
+//	slwin:tokens=8000    → keep 1 from start, then the most recent messages
+//	                       that fit in 8000 tokens (always at least one)
+//	slwin:tokens=8000:3  → same, keeping 3 from start
 //
 // Messages outside the window are removed entirely. Non-message
 // instructions (SET_MODEL, tool definitions, etc.) are always preserved.
@@ -25,10 +28,14 @@ type SlidingWindow struct{}
 func (f *SlidingWindow) Name() string { return "slwin" }
 
 func (f *SlidingWindow) Before(params string, _ *services.ProviderService, _ *http.Request, prog *ail.Program) (*ail.Program, error) {
-	keepEnd, keepStart := 10, 1
+	keepEnd, keepStart, tokenBudget := 10, 1, 0
 	if params != "" {
 		parts := strings.SplitN(params, ":", 2)
-		if v, err := strconv.Atoi(parts[0]); err == nil && v > 0 {
+		if budget, ok := strings.CutPrefix(parts[0], "tokens="); ok {
+			if v, err := strconv.Atoi(budget); err == nil && v > 0 {
+				tokenBudget = v
+			}
+		} else if v, err := strconv.Atoi(parts[0]); err == nil && v > 0 {
 			keepEnd = v
 		}
 		if len(parts) == 2 {
@@ -40,6 +47,9 @@ func (f *SlidingWindow) Before(params string, _ *services.ProviderService, _ *ht
 
 	msgs := prog.Messages()
 	total := len(msgs)
+	if tokenBudget > 0 {
+		keepEnd = tokenWindow(prog, msgs, keepStart, tokenBudget)
+	}
 	if total <= keepStart+keepEnd {
 		return prog, nil
 	}
@@ -65,4 +75,23 @@ func (f *SlidingWindow) Before(params string, _ *services.ProviderService, _ *ht
 	return prog.RemoveMessages(toRemove...), nil
 }
 
+// tokenWindow returns how many trailing messages fit in budget tokens
+// after the first keepStart messages; at least one.
+func tokenWindow(prog *ail.Program, msgs []ail.MessageSpan, keepStart, budget int) int {
+	model := prog.GetModel()
+	used := 0
+	for i := 0; i < keepStart && i < len(msgs); i++ {
+		used += services.CountMessageTokens(model, prog, msgs[i])
+	}
+	n := 0
+	for i := len(msgs) - 1; i >= keepStart; i-- {
+		used += services.CountMessageTokens(model, prog, msgs[i])
+		if used > budget && n > 0 {
+			break
+		}
+		n++
+	}
+	return max(n, 1)
+}
+
 var _ plugin.BeforePlugin = (*SlidingWindow)(nil)
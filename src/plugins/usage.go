@@ -0,0 +1,55 @@
+package plugins
+
+import (
+	"net/http"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+)
+
+// anonymousKeyID is recorded for requests without an authenticated key.
+const anonymousKeyID = "anonymous"
+
+// UsageRecorder feeds completed responses into the router's usage
+// accounting service (per key, provider and upstream model). It runs as a
+// tail plugin and does nothing on routers without usage_accounting.
+type UsageRecorder struct{}
+
+func (UsageRecorder) Name() string { return "usage" }
+
+// FailOpen keeps accounting from failing requests that were served.
+func (UsageRecorder) FailOpen() bool { return true }
+
+// After records a complete (non-streaming) response.
+func (u UsageRecorder) After(_ string, p *services.ProviderService, r *http.Request, reqProg *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	u.record(p, r, reqProg, resProg)
+	return resProg, nil
+}
+
+// StreamEnd records a finished stream from its assembled chunks.
+func (u UsageRecorder) StreamEnd(_ string, p *services.ProviderService, r *http.Request, reqProg *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	u.record(p, r, reqProg, assembled)
+	return nil
+}
+
+func (UsageRecorder) record(p *services.ProviderService, r *http.Request, reqProg, resProg *ail.Program) {
+	if p == nil || p.Router == nil || p.Router.Usage == nil {
+		return
+	}
+	keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+	if keyID == "" {
+		keyID = anonymousKeyID
+	}
+	// Responses without reported usage still count as a request.
+	model := reqProg.GetModel()
+	usage, _ := services.UsageFromProgram(resProg)
+	cost, _ := p.CostOf(model, usage)
+	p.Router.Usage.Record(keyID, p.Name, model, usage, cost)
+}
+
+var (
+	_ plugin.AfterPlugin     = UsageRecorder{}
+	_ plugin.StreamEndPlugin = UsageRecorder{}
+	_ plugin.FailOpenPlugin  = UsageRecorder{}
+)
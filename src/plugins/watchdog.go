@@ -0,0 +1,248 @@
+package plugins
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"go.uber.org/zap"
+)
+
+// Watchdog flags requests that take longer than MaxLatency or use more
+// than MaxTokens (prompt + completion, as reported upstream). Each flagged
+// request is logged with its AIL fingerprint — the SHA-256 the sampler
+// names samples by — and counted in ai_router_anomalies_total; with a
+// Sampler set it is also captured there, whether or not regular sampling
+// picked it.
+//
+// Latency runs from the parsed request to the complete response, so it
+// includes plugins, fallbacks and streaming. Failed requests are checked
+// for latency only.
+//
+// Registered as a tail plugin by modules.init() when WATCHDOG_LATENCY or
+// WATCHDOG_TOKENS is set.
+type Watchdog struct {
+	MaxLatency time.Duration
+	MaxTokens  int
+	Sampler    *Sampler
+
+	mu        sync.Mutex
+	requests  map[string]*watchState
+	lastSweep time.Time
+}
+
+type watchState struct {
+	start time.Time
+	req   *ail.Program
+	up    *ail.Program
+}
+
+// watchStateTTL bounds how long state is kept for requests that never
+// complete (e.g. every provider failed).
+const watchStateTTL = 10 * time.Minute
+
+// NewWatchdogFromEnv builds the watchdog from the environment, or returns
+// nil when no threshold is set:
+//
+//	WATCHDOG_LATENCY  flag requests slower than this: 30s, 2m
+//	WATCHDOG_TOKENS   flag requests using more tokens than this
+//	WATCHDOG_SAMPLE   capture flagged requests: a SAMPLER spec; the same
+//	                  spec as SAMPLER shares that sampler
+//
+// sampler is the regular sampler, or nil when SAMPLER is unset.
+func NewWatchdogFromEnv(getenv func(string) string, sampler *Sampler) (*Watchdog, error) {
+	w := &Watchdog{}
+	if v := getenv("WATCHDOG_LATENCY"); v != "" {
+		d, err := caddy.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("watchdog: invalid latency %q", v)
+		}
+		w.MaxLatency = d
+	}
+	if v := getenv("WATCHDOG_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("watchdog: invalid tokens %q", v)
+		}
+		w.MaxTokens = n
+	}
+	if w.MaxLatency == 0 && w.MaxTokens == 0 {
+		return nil, nil
+	}
+	switch spec := getenv("WATCHDOG_SAMPLE"); {
+	case spec == "":
+	case sampler != nil && spec == getenv("SAMPLER"):
+		w.Sampler = sampler
+	default:
+		s, err := NewSamplerFromSpec(spec, getenv("SAMPLER_PARTITION"))
+		if err != nil {
+			return nil, err
+		}
+		w.Sampler = s
+	}
+	return w, nil
+}
+
+func (w *Watchdog) Name() string { return "watchdog" }
+
+// OnRequestInit starts the clock for the request.
+func (w *Watchdog) OnRequestInit(r *http.Request, prog *ail.Program) {
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	if traceID == "" {
+		return
+	}
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.requests == nil {
+		w.requests = make(map[string]*watchState)
+	}
+	// InferFresh re-entry: keep timing the outer request.
+	if _, ok := w.requests[traceID]; ok {
+		return
+	}
+	if now.Sub(w.lastSweep) > time.Minute {
+		for id, st := range w.requests {
+			if now.Sub(st.start) > watchStateTTL {
+				delete(w.requests, id)
+			}
+		}
+		w.lastSweep = now
+	}
+	st := &watchState{start: now}
+	if w.Sampler != nil {
+		st.req = prog.Clone()
+	}
+	w.requests[traceID] = st
+}
+
+// Before keeps the upstream program for capture.
+func (w *Watchdog) Before(_ string, _ *services.ProviderService, r *http.Request, prog *ail.Program) (*ail.Program, error) {
+	if w.Sampler == nil {
+		return prog, nil
+	}
+	if _, step := plugin.SamplerStepFromContext(r.Context()); step {
+		return prog, nil
+	}
+	if st := w.state(r, false); st != nil {
+		w.mu.Lock()
+		st.up = prog.Clone()
+		w.mu.Unlock()
+	}
+	return prog, nil
+}
+
+// After checks a complete response.
+func (w *Watchdog) After(_ string, p *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, resProg *ail.Program) (*ail.Program, error) {
+	w.check(p, r, resProg, nil)
+	return resProg, nil
+}
+
+// StreamEnd checks the assembled streamed response.
+func (w *Watchdog) StreamEnd(_ string, p *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, assembled *ail.Program) error {
+	w.check(p, r, assembled, nil)
+	return nil
+}
+
+// OnError checks how long a failed provider call kept the request waiting.
+func (w *Watchdog) OnError(_ string, p *services.ProviderService, r *http.Request, _ *ail.Program, _ *http.Response, providerErr error) error {
+	w.check(p, r, nil, providerErr)
+	return nil
+}
+
+// state returns the request's state, removing it when release is set.
+func (w *Watchdog) state(r *http.Request, release bool) *watchState {
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.requests[traceID]
+	if release {
+		delete(w.requests, traceID)
+	}
+	return st
+}
+
+func (w *Watchdog) check(p *services.ProviderService, r *http.Request, resProg *ail.Program, providerErr error) {
+	// Sub-steps of recursive handlers are part of the outer request.
+	_, step := plugin.SamplerStepFromContext(r.Context())
+	// A failed provider may still fall over to the next one, so keep
+	// timing the request.
+	st := w.state(r, !step && providerErr == nil)
+	if st == nil || step {
+		return
+	}
+
+	latency := time.Since(st.start)
+	var reasons []string
+	fields := []zap.Field{zap.Duration("latency", latency)}
+	if w.MaxLatency > 0 && latency > w.MaxLatency {
+		reasons = append(reasons, "latency")
+	}
+	if u, ok := services.UsageFromProgram(resProg); ok {
+		total := u.PromptTokens + u.CompletionTokens
+		fields = append(fields, zap.Int("tokens", total))
+		if w.MaxTokens > 0 && total > w.MaxTokens {
+			reasons = append(reasons, "tokens")
+		}
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	if providerErr != nil {
+		// Flag a failing request once; later checks would repeat it.
+		w.state(r, true)
+		fields = append(fields, zap.Error(providerErr))
+	}
+
+	routerName, provider := "", ""
+	if p != nil {
+		provider = p.Name
+		if p.Router != nil {
+			routerName = p.Router.Name
+		}
+	}
+	for _, reason := range reasons {
+		services.Metrics.Anomalies.WithLabelValues(routerName, reason).Inc()
+	}
+
+	traceID, _ := r.Context().Value(plugin.ContextTraceID()).(string)
+	keyID, _ := r.Context().Value(plugin.ContextKeyID()).(string)
+	req := st.req
+	if req == nil {
+		req, _ = ail.ProgramFromContext(r.Context())
+	}
+	var fingerprint string
+	if req != nil {
+		_, fingerprint, _ = encodeFingerprint(req)
+	}
+	Logger.Warn("WATCHDOG: anomalous request",
+		append(fields,
+			zap.Strings("reasons", reasons),
+			zap.String("trace_id", traceID),
+			zap.String("key_id", keyID),
+			zap.String("provider", provider),
+			zap.String("fingerprint", fingerprint))...)
+
+	if w.Sampler != nil && st.req != nil {
+		w.mu.Lock()
+		up := st.up
+		w.mu.Unlock()
+		note := fmt.Sprintf("watchdog: %s (latency %s)", strings.Join(reasons, ", "), latency.Round(time.Millisecond))
+		w.Sampler.Capture(traceID, note, st.req, up, resProg)
+	}
+}
+
+var (
+	_ plugin.RequestInitPlugin = (*Watchdog)(nil)
+	_ plugin.BeforePlugin      = (*Watchdog)(nil)
+	_ plugin.AfterPlugin       = (*Watchdog)(nil)
+	_ plugin.StreamEndPlugin   = (*Watchdog)(nil)
+	_ plugin.ErrorPlugin       = (*Watchdog)(nil)
+)
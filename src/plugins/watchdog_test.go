@@ -0,0 +1,122 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/neutrome-labs/open-ai-router/src/plugin"
+	"github.com/neutrome-labs/open-ai-router/src/services"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWatchdog_FlagsAndCaptures(t *testing.T) {
+	dir := t.TempDir()
+	w := &Watchdog{MaxTokens: 100, Sampler: &Sampler{Sink: &DirSink{Dir: dir}}}
+	p := &services.ProviderService{Name: "p", Router: &services.RouterService{Name: "watchdog-test"}}
+	flagged := services.Metrics.Anomalies.WithLabelValues("watchdog-test", "tokens")
+	before := testutil.ToFloat64(flagged)
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "m")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), "trace"))
+	w.OnRequestInit(r, prog)
+	if _, err := w.Before("", p, r, prog); err != nil {
+		t.Fatal(err)
+	}
+	res := ail.NewProgram()
+	res.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":90,"completion_tokens":20}`))
+	if _, err := w.After("", p, r, prog, nil, res); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(flagged) - before; got != 1 {
+		t.Errorf("expected one tokens anomaly, got %v", got)
+	}
+	if st := w.state(r, false); st != nil {
+		t.Error("state not released after the response")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		txts, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+		if len(txts) == 1 {
+			data, _ := os.ReadFile(txts[0])
+			if strings.Contains(string(data), "watchdog: tokens") {
+				sample := strings.TrimSuffix(txts[0], ".txt")
+				for _, f := range []string{"request.ail", "request.up.ail", "response.ail"} {
+					if _, err := os.Stat(filepath.Join(sample, f)); err != nil {
+						t.Errorf("missing %s: %v", f, err)
+					}
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("flagged request not captured under %s", dir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchdog_UnderThresholdNotFlagged(t *testing.T) {
+	w := &Watchdog{MaxLatency: time.Minute, MaxTokens: 1000}
+	flagged := services.Metrics.Anomalies.WithLabelValues("", "latency")
+	before := testutil.ToFloat64(flagged)
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), plugin.ContextTraceID(), "quick"))
+	w.OnRequestInit(r, ail.NewProgram())
+	if err := w.StreamEnd("", nil, r, nil, nil, ail.NewProgram()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(flagged) - before; got != 0 {
+		t.Errorf("fast request flagged: %v", got)
+	}
+}
+
+func TestNewWatchdogFromEnv(t *testing.T) {
+	env := func(m map[string]string) func(string) string {
+		return func(k string) string { return m[k] }
+	}
+	if w, err := NewWatchdogFromEnv(env(nil), nil); w != nil || err != nil {
+		t.Fatalf("no thresholds = %v, %v; want nil, nil", w, err)
+	}
+
+	dir := t.TempDir()
+	shared := NewSampler(dir)
+	w, err := NewWatchdogFromEnv(env(map[string]string{
+		"WATCHDOG_LATENCY": "30s",
+		"WATCHDOG_TOKENS":  "50000",
+		"WATCHDOG_SAMPLE":  dir,
+		"SAMPLER":          dir,
+	}), shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.MaxLatency != 30*time.Second || w.MaxTokens != 50000 || w.Sampler != shared {
+		t.Errorf("got latency=%v tokens=%d sampler=%p", w.MaxLatency, w.MaxTokens, w.Sampler)
+	}
+
+	w, err = NewWatchdogFromEnv(env(map[string]string{"WATCHDOG_TOKENS": "10", "WATCHDOG_SAMPLE": t.TempDir()}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Sampler == nil {
+		t.Error("WATCHDOG_SAMPLE without SAMPLER should get its own sampler")
+	}
+
+	for k, v := range map[string]string{"WATCHDOG_LATENCY": "soon", "WATCHDOG_TOKENS": "-1"} {
+		if _, err := NewWatchdogFromEnv(env(map[string]string{k: v}), nil); err == nil {
+			t.Errorf("%s=%q: expected error", k, v)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// AccessRecord accumulates what one client request did across the pipeline:
+// the provider that served it, upstream usage and the finish reason. It is
+// carried in the request context so drivers and the pipeline can fill it in
+// for the access log. Usage sums over every upstream call the request made
+// (e.g. tool loops), which is what billing needs.
+type AccessRecord struct {
+	mu           sync.Mutex
+	provider     string
+	model        string
+	outcome      string
+	attempts     int
+	usage        Usage
+	finishReason string
+}
+
+type accessRecordKey struct{}
+
+// ContextWithAccessRecord returns ctx carrying rec.
+func ContextWithAccessRecord(ctx context.Context, rec *AccessRecord) context.Context {
+	return context.WithValue(ctx, accessRecordKey{}, rec)
+}
+
+// AccessRecordFrom returns the record carried by ctx, or nil. All methods
+// are no-ops on a nil record.
+func AccessRecordFrom(ctx context.Context) *AccessRecord {
+	rec, _ := ctx.Value(accessRecordKey{}).(*AccessRecord)
+	return rec
+}
+
+// ObserveResponse adds the usage and finish reason found in an upstream
+// response program or stream chunk.
+func (a *AccessRecord) ObserveResponse(prog *ail.Program) {
+	if a == nil || prog == nil {
+		return
+	}
+	u, hasUsage := UsageFromProgram(prog)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if hasUsage {
+		a.usage.PromptTokens += u.PromptTokens
+		a.usage.CompletionTokens += u.CompletionTokens
+		a.usage.CachedTokens += u.CachedTokens
+	}
+	for _, inst := range prog.Code {
+		if inst.Op == ail.RESP_DONE && inst.Str != "" {
+			a.finishReason = inst.Str
+		}
+	}
+}
+
+// SetResult records how a pipeline run ended. provider and model are empty
+// when nothing served the request; attempts adds to earlier runs.
+func (a *AccessRecord) SetResult(outcome, provider, model string, attempts int) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.outcome = outcome
+	if provider != "" {
+		a.provider, a.model = provider, model
+	}
+	a.attempts += attempts
+}
+
+// AccessSnapshot is a consistent copy of an AccessRecord.
+type AccessSnapshot struct {
+	Provider     string
+	Model        string
+	Outcome      string
+	Attempts     int
+	Usage        Usage
+	FinishReason string
+}
+
+// Snapshot returns the record's current values.
+func (a *AccessRecord) Snapshot() AccessSnapshot {
+	if a == nil {
+		return AccessSnapshot{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AccessSnapshot{
+		Provider:     a.provider,
+		Model:        a.model,
+		Outcome:      a.outcome,
+		Attempts:     a.attempts,
+		Usage:        a.usage,
+		FinishReason: a.finishReason,
+	}
+}
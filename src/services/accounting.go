@@ -0,0 +1,320 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// usageDayFormat is the granularity of stored usage: one bucket per UTC day.
+const usageDayFormat = "2006-01-02"
+
+const (
+	defaultUsageWindow = 30 * 24 * time.Hour  // range covered without a from
+	maxUsageWindow     = 366 * 24 * time.Hour // each day in range costs store reads
+)
+
+// UsageCounts is aggregated request and token usage.
+type UsageCounts struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	CostUSD          float64 `json:"cost_usd"` // only requests with a known price contribute
+}
+
+// Add accumulates o into c.
+func (c *UsageCounts) Add(o UsageCounts) {
+	c.Requests += o.Requests
+	c.PromptTokens += o.PromptTokens
+	c.CompletionTokens += o.CompletionTokens
+	c.CachedTokens += o.CachedTokens
+	c.CostUSD += o.CostUSD
+}
+
+// UsageRow is the usage of one key on one provider/model for one day.
+type UsageRow struct {
+	Day      string `json:"day"`
+	KeyID    string `json:"key_id"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	UsageCounts
+}
+
+type usageBucket struct {
+	day, keyID, provider, model string
+}
+
+// UsageAccounting aggregates usage per key, provider, model and day. Records
+// are summed in memory and flushed to the KV store periodically, so the hot
+// path never waits on storage.
+//
+// Layout under Prefix, per day:
+//
+//	usage:<day>:keys                        JSON list of key IDs
+//	usage:<day>:<key>:index                 JSON list of "<provider>\x00<model>"
+//	usage:<day>:<key>:<provider>:<model>    JSON UsageCounts
+//
+// Flushes read, add and write back, so instances sharing a backend can lose
+// increments that race on the same bucket; run one writer per backend when
+// exact totals matter.
+type UsageAccounting struct {
+	Store     kv.Store
+	Prefix    string
+	Retention time.Duration // how long daily buckets are kept
+
+	mu      sync.Mutex
+	pending map[usageBucket]UsageCounts
+
+	flushMu sync.Mutex // serializes flushes
+}
+
+// NewUsageAccounting creates a usage service keyed under prefix.
+func NewUsageAccounting(store kv.Store, prefix string, retention time.Duration) *UsageAccounting {
+	return &UsageAccounting{
+		Store:     store,
+		Prefix:    prefix,
+		Retention: retention,
+		pending:   make(map[usageBucket]UsageCounts),
+	}
+}
+
+// Record counts one request, its usage and its USD cost. A nil service
+// ignores the call.
+func (u *UsageAccounting) Record(keyID, provider, model string, usage Usage, cost float64) {
+	if u == nil {
+		return
+	}
+	b := usageBucket{time.Now().UTC().Format(usageDayFormat), keyID, provider, model}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.pending[b]
+	c.Add(UsageCounts{
+		Requests:         1,
+		PromptTokens:     int64(usage.PromptTokens),
+		CompletionTokens: int64(usage.CompletionTokens),
+		CachedTokens:     int64(usage.CachedTokens),
+		CostUSD:          cost,
+	})
+	u.pending[b] = c
+}
+
+// Start flushes every interval until ctx is cancelled, then flushes once
+// more so a config reload does not drop pending usage.
+func (u *UsageAccounting) Start(ctx context.Context, interval time.Duration) {
+	if u == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = u.Flush(context.Background())
+				return
+			case <-ticker.C:
+				_ = u.Flush(ctx)
+			}
+		}
+	}()
+}
+
+// Flush writes pending usage to the store. Buckets that fail to write stay
+// pending for the next flush.
+func (u *UsageAccounting) Flush(ctx context.Context) error {
+	if u == nil {
+		return nil
+	}
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[usageBucket]UsageCounts)
+	u.mu.Unlock()
+
+	var errs []error
+	for b, c := range pending {
+		if err := u.flushBucket(ctx, b, c); err != nil {
+			errs = append(errs, err)
+			u.mu.Lock()
+			back := u.pending[b]
+			back.Add(c)
+			u.pending[b] = back
+			u.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (u *UsageAccounting) flushBucket(ctx context.Context, b usageBucket, c UsageCounts) error {
+	key := u.bucketKey(b)
+	stored, err := u.loadCounts(ctx, key)
+	if err != nil {
+		return err
+	}
+	stored.Add(c)
+	data, _ := json.Marshal(stored)
+	if err := u.Store.Set(ctx, key, string(data), u.Retention); err != nil {
+		return err
+	}
+	if err := u.addToList(ctx, u.Prefix+"usage:"+b.day+":keys", b.keyID); err != nil {
+		return err
+	}
+	return u.addToList(ctx, u.indexKey(b.day, b.keyID), b.provider+"\x00"+b.model)
+}
+
+// Query returns usage rows for the UTC days from..to inclusive, for keyID
+// or for every key when keyID is empty. Unflushed usage is included.
+func (u *UsageAccounting) Query(ctx context.Context, keyID string, from, to time.Time) ([]UsageRow, error) {
+	if u == nil {
+		return nil, nil
+	}
+	u.mu.Lock()
+	pending := make(map[usageBucket]UsageCounts, len(u.pending))
+	for b, c := range u.pending {
+		pending[b] = c
+	}
+	u.mu.Unlock()
+
+	rows := make(map[usageBucket]UsageCounts)
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to.UTC()); day = day.Add(24 * time.Hour) {
+		d := day.Format(usageDayFormat)
+		keys := []string{keyID}
+		if keyID == "" {
+			var err error
+			if keys, err = u.loadList(ctx, u.Prefix+"usage:"+d+":keys"); err != nil {
+				return nil, err
+			}
+		}
+		for _, k := range keys {
+			pairs, err := u.loadList(ctx, u.indexKey(d, k))
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				provider, model, _ := strings.Cut(pair, "\x00")
+				b := usageBucket{d, k, provider, model}
+				c, err := u.loadCounts(ctx, u.bucketKey(b))
+				if err != nil {
+					return nil, err
+				}
+				rows[b] = c
+			}
+		}
+		for b, c := range pending {
+			if b.day == d && (keyID == "" || b.keyID == keyID) {
+				r := rows[b]
+				r.Add(c)
+				rows[b] = r
+			}
+		}
+	}
+
+	out := make([]UsageRow, 0, len(rows))
+	for b, c := range rows {
+		out = append(out, UsageRow{Day: b.day, KeyID: b.keyID, Provider: b.provider, Model: b.model, UsageCounts: c})
+	}
+	slices.SortFunc(out, func(a, b UsageRow) int {
+		return cmp.Or(
+			strings.Compare(a.Day, b.Day),
+			strings.Compare(a.KeyID, b.KeyID),
+			strings.Compare(a.Provider, b.Provider),
+			strings.Compare(a.Model, b.Model))
+	})
+	return out, nil
+}
+
+// Totals sums a key's usage over the UTC days from..to inclusive; used for
+// budget checks.
+func (u *UsageAccounting) Totals(ctx context.Context, keyID string, from, to time.Time) (UsageCounts, error) {
+	var total UsageCounts
+	rows, err := u.Query(ctx, keyID, from, to)
+	for _, r := range rows {
+		total.Add(r.UsageCounts)
+	}
+	return total, err
+}
+
+// ParseUsageRange parses the from/to query values (YYYY-MM-DD, UTC) of a
+// usage request. to defaults to today and from to 30 days before to.
+func ParseUsageRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	end := now.UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		t, err := time.Parse(usageDayFormat, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to %q, expected YYYY-MM-DD", to)
+		}
+		end = t
+	}
+	start := end.Add(-defaultUsageWindow)
+	if from != "" {
+		t, err := time.Parse(usageDayFormat, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from %q, expected YYYY-MM-DD", from)
+		}
+		start = t
+	}
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from %s is after to %s", start.Format(usageDayFormat), end.Format(usageDayFormat))
+	}
+	if end.Sub(start) > maxUsageWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("range exceeds %d days", int(maxUsageWindow/(24*time.Hour)))
+	}
+	return start, end, nil
+}
+
+func (u *UsageAccounting) bucketKey(b usageBucket) string {
+	return u.Prefix + "usage:" + b.day + ":" + b.keyID + ":" + b.provider + ":" + b.model
+}
+
+func (u *UsageAccounting) indexKey(day, keyID string) string {
+	return u.Prefix + "usage:" + day + ":" + keyID + ":index"
+}
+
+func (u *UsageAccounting) loadCounts(ctx context.Context, key string) (UsageCounts, error) {
+	var c UsageCounts
+	v, err := u.Store.Get(ctx, key)
+	if errors.Is(err, kv.ErrNotFound) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal([]byte(v), &c)
+	return c, err
+}
+
+func (u *UsageAccounting) loadList(ctx context.Context, key string) ([]string, error) {
+	var list []string
+	v, err := u.Store.Get(ctx, key)
+	if errors.Is(err, kv.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(v), &list)
+	return list, err
+}
+
+func (u *UsageAccounting) addToList(ctx context.Context, key, item string) error {
+	list, err := u.loadList(ctx, key)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(list, item) {
+		return nil
+	}
+	data, _ := json.Marshal(append(list, item))
+	return u.Store.Set(ctx, key, string(data), u.Retention)
+}
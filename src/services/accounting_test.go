@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestUsageAccounting_FlushAndQuery(t *testing.T) {
+	ctx := context.Background()
+	store := kv.NewMemoryStore(100, time.Hour)
+	u := NewUsageAccounting(store, "t:", time.Hour)
+
+	u.Record("k1", "openai", "gpt-4", Usage{PromptTokens: 10, CompletionTokens: 5, CachedTokens: 2}, 0.5)
+	u.Record("k1", "openai", "gpt-4", Usage{PromptTokens: 1, CompletionTokens: 1}, 0.25)
+	u.Record("k2", "anthropic", "claude", Usage{PromptTokens: 7}, 0)
+	if err := u.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Unflushed usage is merged into query results.
+	u.Record("k1", "openai", "gpt-4", Usage{PromptTokens: 100}, 0)
+
+	today := time.Now()
+	rows, err := u.Query(ctx, "k1", today, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected one row for k1, got %+v", rows)
+	}
+	want := UsageCounts{Requests: 3, PromptTokens: 111, CompletionTokens: 6, CachedTokens: 2, CostUSD: 0.75}
+	if rows[0].UsageCounts != want || rows[0].Provider != "openai" || rows[0].Model != "gpt-4" {
+		t.Errorf("k1 row = %+v, want %+v", rows[0], want)
+	}
+
+	all, err := u.Query(ctx, "", today, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].KeyID != "k1" || all[1].KeyID != "k2" {
+		t.Errorf("expected sorted rows for both keys, got %+v", all)
+	}
+
+	// A second flush adds to the stored bucket.
+	if err := u.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	total, err := NewUsageAccounting(store, "t:", time.Hour).Totals(ctx, "k1", today, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != want {
+		t.Errorf("stored totals = %+v, want %+v", total, want)
+	}
+}
+
+type failingStore struct{ kv.Store }
+
+func (failingStore) Get(context.Context, string) (string, error) {
+	return "", errors.New("unavailable")
+}
+
+func TestUsageAccounting_FailedFlushKeepsPending(t *testing.T) {
+	ctx := context.Background()
+	mem := kv.NewMemoryStore(100, time.Hour)
+	u := NewUsageAccounting(failingStore{mem}, "t:", time.Hour)
+	u.Record("k", "p", "m", Usage{PromptTokens: 3}, 0)
+	if err := u.Flush(ctx); err == nil {
+		t.Fatal("expected flush error")
+	}
+
+	u.Store = mem
+	if err := u.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	total, err := u.Totals(ctx, "k", time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Requests != 1 || total.PromptTokens != 3 {
+		t.Errorf("usage lost after failed flush: %+v", total)
+	}
+}
+
+func TestParseUsageRange(t *testing.T) {
+	now := time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC)
+	from, to, err := ParseUsageRange("", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := to.Format(usageDayFormat); got != "2026-03-31" {
+		t.Errorf("default to = %s", got)
+	}
+	if got := from.Format(usageDayFormat); got != "2026-03-01" {
+		t.Errorf("default from = %s", got)
+	}
+
+	for _, tc := range [][2]string{
+		{"2026-03-05", "2026-03-01"}, // reversed
+		{"03/01/2026", ""},           // bad format
+		{"2020-01-01", "2026-01-01"}, // too long
+	} {
+		if _, _, err := ParseUsageRange(tc[0], tc[1], now); err == nil {
+			t.Errorf("expected error for from=%q to=%q", tc[0], tc[1])
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// Affinity remembers which provider served each conversation, so its next
+// turns go back to the same upstream: the provider's prompt cache then
+// holds the conversation's prefix, and answers don't change voice
+// mid-conversation. An entry lives for TTL after the last turn it served.
+// State lives in a kv.Store, which lets several router instances share it
+// when a shared backend is configured.
+type Affinity struct {
+	Store  kv.Store
+	Prefix string
+	TTL    time.Duration
+}
+
+// NewAffinity creates an affinity table keyed under prefix.
+func NewAffinity(store kv.Store, prefix string, ttl time.Duration) *Affinity {
+	return &Affinity{Store: store, Prefix: prefix, TTL: ttl}
+}
+
+func (a *Affinity) key(conversation, model string) string {
+	return a.Prefix + "affinity:" + model + ":" + conversation
+}
+
+// Provider returns the provider that last served conversation for model.
+// A nil table, or an empty conversation, has none.
+func (a *Affinity) Provider(ctx context.Context, conversation, model string) (string, bool) {
+	if a == nil || conversation == "" {
+		return "", false
+	}
+	v, err := a.Store.Get(ctx, a.key(conversation, model))
+	if err != nil || v == "" {
+		return "", false
+	}
+	return v, true
+}
+
+// Record notes that provider served conversation for model.
+func (a *Affinity) Record(ctx context.Context, conversation, model, provider string) {
+	if a == nil || conversation == "" || a.TTL <= 0 {
+		return
+	}
+	_ = a.Store.Set(ctx, a.key(conversation, model), provider, a.TTL)
+}
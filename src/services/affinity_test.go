@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestAffinity(t *testing.T) {
+	ctx := context.Background()
+	a := NewAffinity(kv.NewMemoryStore(10, time.Minute), "router:r:", time.Minute)
+
+	if _, ok := a.Provider(ctx, "conv", "m"); ok {
+		t.Fatal("an unseen conversation should have no provider")
+	}
+	a.Record(ctx, "conv", "m", "b")
+	if p, ok := a.Provider(ctx, "conv", "m"); !ok || p != "b" {
+		t.Errorf("Provider = %q, %v; want b", p, ok)
+	}
+	if _, ok := a.Provider(ctx, "conv", "other"); ok {
+		t.Error("affinity should be per model")
+	}
+
+	a.Record(ctx, "", "m", "b")
+	if _, ok := a.Provider(ctx, "", "m"); ok {
+		t.Error("requests without a conversation should not be sticky")
+	}
+
+	var none *Affinity
+	none.Record(ctx, "conv", "m", "b")
+	if _, ok := none.Provider(ctx, "conv", "m"); ok {
+		t.Error("a nil Affinity should remember nothing")
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"strings"
+	"time"
+)
+
+// ModelInfo is what the router knows about a model: its limits, the input
+// modalities and tool use it supports, its list price and when it is
+// retired. Zero fields are unknown.
+type ModelInfo struct {
+	ContextWindow int         `json:"context_window,omitempty"`    // prompt plus output tokens
+	MaxOutput     int         `json:"max_output_tokens,omitempty"` // tokens
+	Modalities    []string    `json:"modalities,omitempty"`        // input kinds: text, image, audio, file
+	Tools         *bool       `json:"tools,omitempty"`
+	Price         *ModelPrice `json:"pricing,omitempty"`
+	Deprecated    string      `json:"deprecation_date,omitempty"` // YYYY-MM-DD
+	ReplacedBy    string      `json:"replaced_by,omitempty"`      // model requests are redirected to once deprecated
+}
+
+// Supports reports whether the model accepts input of modality; a model
+// with no known modalities is assumed to accept anything.
+func (mi ModelInfo) Supports(modality string) bool {
+	if len(mi.Modalities) == 0 {
+		return true
+	}
+	for _, m := range mi.Modalities {
+		if m == modality {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeprecated reports whether the model is marked deprecated.
+func (mi ModelInfo) IsDeprecated() bool {
+	return mi.Deprecated != "" || mi.ReplacedBy != ""
+}
+
+// Retired reports whether requests for the model should go to its
+// replacement at now: one is set and the deprecation date, if any, has
+// come.
+func (mi ModelInfo) Retired(now time.Time) bool {
+	if mi.ReplacedBy == "" {
+		return false
+	}
+	return mi.Deprecated == "" || now.Format(time.DateOnly) >= mi.Deprecated
+}
+
+// merge overlays the known fields of o on mi.
+func (mi ModelInfo) merge(o ModelInfo) ModelInfo {
+	if o.ContextWindow != 0 {
+		mi.ContextWindow = o.ContextWindow
+	}
+	if o.MaxOutput != 0 {
+		mi.MaxOutput = o.MaxOutput
+	}
+	if o.Modalities != nil {
+		mi.Modalities = o.Modalities
+	}
+	if o.Tools != nil {
+		mi.Tools = o.Tools
+	}
+	if o.Price != nil {
+		mi.Price = o.Price
+	}
+	if o.Deprecated != "" {
+		mi.Deprecated = o.Deprecated
+	}
+	if o.ReplacedBy != "" {
+		mi.ReplacedBy = o.ReplacedBy
+	}
+	return mi
+}
+
+var (
+	withTools = func() *bool { b := true; return &b }()
+	noTools   = func() *bool { b := false; return &b }()
+
+	textOnly    = []string{"text"}
+	textImage   = []string{"text", "image"}
+	multimodal  = []string{"text", "image", "audio", "file"}
+	textImgFile = []string{"text", "image", "file"}
+)
+
+// ModelCatalog holds the built-in metadata of well-known models; prices
+// come from PriceCatalog. It resolves IDs like PriceCatalog does.
+var ModelCatalog = map[string]ModelInfo{
+	// OpenAI
+	"gpt-5":                  {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage, Tools: withTools},
+	"gpt-5-mini":             {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage, Tools: withTools},
+	"gpt-5-nano":             {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage, Tools: withTools},
+	"gpt-4.1":                {ContextWindow: 1_047_576, MaxOutput: 32_768, Modalities: textImage, Tools: withTools},
+	"gpt-4.1-mini":           {ContextWindow: 1_047_576, MaxOutput: 32_768, Modalities: textImage, Tools: withTools},
+	"gpt-4.1-nano":           {ContextWindow: 1_047_576, MaxOutput: 32_768, Modalities: textImage, Tools: withTools},
+	"gpt-4o":                 {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage, Tools: withTools},
+	"gpt-4o-mini":            {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage, Tools: withTools},
+	"o3":                     {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textImage, Tools: withTools},
+	"o3-mini":                {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textOnly, Tools: withTools},
+	"o4-mini":                {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textImage, Tools: withTools},
+	"text-embedding-3-small": {ContextWindow: 8_191, Modalities: textOnly, Tools: noTools},
+	"text-embedding-3-large": {ContextWindow: 8_191, Modalities: textOnly, Tools: noTools},
+
+	// Anthropic
+	"claude-opus-4":     {ContextWindow: 200_000, MaxOutput: 32_000, Modalities: textImgFile, Tools: withTools},
+	"claude-sonnet-4":   {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImgFile, Tools: withTools},
+	"claude-3-7-sonnet": {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImgFile, Tools: withTools},
+	"claude-3-5-haiku":  {ContextWindow: 200_000, MaxOutput: 8_192, Modalities: textOnly, Tools: withTools},
+
+	// Google
+	"gemini-2.5-pro":   {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: multimodal, Tools: withTools},
+	"gemini-2.5-flash": {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: multimodal, Tools: withTools},
+	"gemini-2.0-flash": {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: multimodal, Tools: withTools},
+}
+
+// catalogEntry looks model up in table: exactly, then without a vendor
+// prefix, then as a dated snapshot or version of the longest matching
+// base name ("gpt-4o-mini-2024-07-18" is gpt-4o-mini, "o3-pro" is not o3).
+func catalogEntry[T any](table map[string]T, model string) (T, bool) {
+	if v, ok := table[model]; ok {
+		return v, true
+	}
+	if i := strings.LastIndexByte(model, '/'); i >= 0 {
+		model = model[i+1:]
+	}
+	if v, ok := table[model]; ok {
+		return v, true
+	}
+	best := ""
+	for base := range table {
+		if len(base) > len(best) && isSnapshotOf(model, base) {
+			best = base
+		}
+	}
+	if best == "" {
+		var zero T
+		return zero, false
+	}
+	return table[best], true
+}
+
+// Catalog is a router's view of model metadata: the built-in
+// ModelCatalog and PriceCatalog with the router's model_info overrides on
+// top.
+type Catalog struct {
+	overrides map[string]ModelInfo
+}
+
+// NewCatalog returns a catalog with the given per-model overrides.
+func NewCatalog(overrides map[string]ModelInfo) *Catalog {
+	return &Catalog{overrides: overrides}
+}
+
+// Lookup returns what is known about model. A nil catalog has only the
+// built-in entries.
+func (c *Catalog) Lookup(model string) (ModelInfo, bool) {
+	mi, found := catalogEntry(ModelCatalog, model)
+	if mp, ok := catalogEntry(PriceCatalog, model); ok {
+		mi.Price, found = &mp, true
+	}
+	if c != nil {
+		if o, ok := catalogEntry(c.overrides, model); ok {
+			mi, found = mi.merge(o), true
+		}
+	}
+	return mi, found
+}
+
+// Price returns the catalog price of model.
+func (c *Catalog) Price(model string) (ModelPrice, bool) {
+	mi, _ := c.Lookup(model)
+	if mi.Price == nil {
+		return ModelPrice{}, false
+	}
+	return *mi.Price, true
+}
@@ -0,0 +1,38 @@
+package services
+
+import "testing"
+
+func TestCatalogLookup(t *testing.T) {
+	c := NewCatalog(map[string]ModelInfo{
+		"gpt-4o":       {ContextWindow: 64_000, Deprecated: "2026-06-30"},
+		"my-llama":     {ContextWindow: 8_192, Modalities: []string{"text"}, Price: &ModelPrice{Input: 0.1, Output: 0.1}},
+		"gpt-4.1-nano": {Tools: noTools},
+	})
+
+	mi, ok := c.Lookup("openai/gpt-4o-2024-08-06")
+	if !ok || mi.ContextWindow != 64_000 || mi.MaxOutput != 16_384 || mi.Deprecated != "2026-06-30" ||
+		mi.Price == nil || *mi.Price != PriceCatalog["gpt-4o"] {
+		t.Errorf("gpt-4o = %+v, %v", mi, ok)
+	}
+	if !mi.Supports("image") || mi.Supports("audio") {
+		t.Errorf("gpt-4o modalities = %v", mi.Modalities)
+	}
+	if mi, _ := c.Lookup("gpt-4.1-nano"); mi.Tools == nil || *mi.Tools {
+		t.Errorf("tools override lost: %+v", mi)
+	}
+	if mi, ok := c.Lookup("local/my-llama"); !ok || mi.ContextWindow != 8_192 || mi.Price.Input != 0.1 {
+		t.Errorf("my-llama = %+v, %v", mi, ok)
+	}
+	if _, ok := c.Lookup("unknown-model"); ok {
+		t.Error("unknown model found")
+	}
+	if mi, ok := (*Catalog)(nil).Lookup("gemini-2.5-pro"); !ok || !mi.Supports("audio") {
+		t.Errorf("nil catalog lost built-in entries: %+v, %v", mi, ok)
+	}
+
+	// Providers fall back to their router's catalog for prices.
+	p := &ProviderService{Router: &RouterService{Catalog: c}}
+	if mp, ok := p.PriceFor("my-llama"); !ok || mp.Input != 0.1 {
+		t.Errorf("catalog price = %+v, %v", mp, ok)
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	// DefaultMaxIdleConnsPerHost is how many idle connections a provider
+	// client keeps to its upstream. http.DefaultTransport keeps two, which
+	// under load leaves most requests dialing (and TLS handshaking) anew.
+	DefaultMaxIdleConnsPerHost = 32
+
+	// DefaultIdleConnTimeout is how long an idle upstream connection is kept.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// http2SendPingTimeout is how long an HTTP/2 connection may be silent
+	// before it is pinged; one that does not answer within
+	// http2PingTimeout is closed rather than handed to the next request.
+	http2SendPingTimeout = 30 * time.Second
+	http2PingTimeout     = 15 * time.Second
+
+	tlsSessionCacheSize = 64
+)
+
+// Upstream protocols a provider client may be pinned to.
+const (
+	ProtocolAuto  = ""      // HTTP/2 where TLS negotiates it, else HTTP/1.1
+	ProtocolHTTP1 = "http1" // HTTP/1.1 only
+	ProtocolHTTP2 = "http2" // HTTP/2 only, with prior knowledge over plain http
+	ProtocolHTTP3 = "http3" // HTTP/3 over QUIC
+)
+
+// ClientOptions tunes the HTTP client of a provider.
+type ClientOptions struct {
+	// Connect bounds dialing and the TLS handshake; DefaultConnectTimeout
+	// when <= 0.
+	Connect time.Duration
+	// MaxIdleConnsPerHost defaults to DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout defaults to DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// Proxy routes requests through an HTTP(S) or SOCKS5 proxy. Nil uses
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment, unless
+	// NoProxy is set.
+	Proxy   *url.URL
+	NoProxy bool
+	// Protocol pins the upstream protocol; see ProtocolAuto. HTTP/3 goes
+	// direct, without a proxy.
+	Protocol string
+	// Provider, when set, labels the client's requests in
+	// Metrics.UpstreamConns.
+	Provider string
+}
+
+// NewProviderClient returns an HTTP client for upstream requests: dialing
+// and the TLS handshake are bounded by opts.Connect, idle connections are
+// pooled per opts, TLS sessions are resumed across connections, and
+// HTTP/2 connections are health-checked with pings so a dead one is not
+// reused. Response timeouts are applied per request by the drivers, since
+// the adaptive first-byte timeout changes as latency samples arrive.
+func NewProviderClient(opts ClientOptions) *http.Client {
+	connect := opts.Connect
+	if connect <= 0 {
+		connect = DefaultConnectTimeout
+	}
+	idle := opts.MaxIdleConnsPerHost
+	if idle <= 0 {
+		idle = DefaultMaxIdleConnsPerHost
+	}
+	idleTimeout := opts.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleConnTimeout
+	}
+
+	tlsConfig := &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+	}
+	if opts.Protocol == ProtocolHTTP3 {
+		return &http.Client{Transport: trackConns(opts.Provider, &http3.Transport{
+			TLSClientConfig: tlsConfig,
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: connect,
+				MaxIdleTimeout:       idleTimeout,
+				KeepAlivePeriod:      http2SendPingTimeout,
+			},
+		})}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connect,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connect
+	transport.MaxIdleConns = 0 // bounded per host
+	transport.MaxIdleConnsPerHost = idle
+	transport.IdleConnTimeout = idleTimeout
+	transport.TLSClientConfig = tlsConfig
+	// A custom dialer and TLS config would otherwise turn HTTP/2 off.
+	transport.ForceAttemptHTTP2 = true
+	transport.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: http2SendPingTimeout,
+		PingTimeout:     http2PingTimeout,
+	}
+	switch opts.Protocol {
+	case ProtocolHTTP1:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	switch {
+	case opts.Proxy != nil:
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	case opts.NoProxy:
+		transport.Proxy = nil
+	}
+	return &http.Client{Transport: trackConns(opts.Provider, transport)}
+}
+
+// trackConns counts the requests next sends for provider in
+// Metrics.UpstreamConns; without a provider it returns next as is.
+func trackConns(provider string, next http.RoundTripper) http.RoundTripper {
+	if provider == "" {
+		return next
+	}
+	return &connTracker{provider: provider, next: next}
+}
+
+// connTracker records, per request, the protocol spoken and whether the
+// connection was reused or had to be dialed (and TLS handshaken) anew.
+type connTracker struct {
+	provider string
+	next     http.RoundTripper
+}
+
+func (t *connTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	reused := "unknown" // HTTP/3 does not report its connections
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = strconv.FormatBool(info.Reused) },
+	}
+	res, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return nil, err
+	}
+	Metrics.UpstreamConns.WithLabelValues(t.provider, res.Proto, reused).Inc()
+	return res, nil
+}
+
+// defaultProviderClient serves providers provisioned without a client of
+// their own.
+var defaultProviderClient = sync.OnceValue(func() *http.Client {
+	return NewProviderClient(ClientOptions{})
+})
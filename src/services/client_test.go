@@ -0,0 +1,97 @@
+package services
+
+import (
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestNewProviderClient(t *testing.T) {
+	transport := NewProviderClient(ClientOptions{}).Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || transport.IdleConnTimeout != DefaultIdleConnTimeout ||
+		transport.TLSHandshakeTimeout != DefaultConnectTimeout {
+		t.Errorf("unexpected defaults: idle %d/%v, handshake %v",
+			transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.TLSHandshakeTimeout)
+	}
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.ClientSessionCache == nil {
+		t.Error("TLS sessions should be resumed")
+	}
+	if transport.Proxy == nil {
+		t.Error("the environment's proxy should apply by default")
+	}
+	if !transport.ForceAttemptHTTP2 || transport.HTTP2 == nil || transport.HTTP2.SendPingTimeout == 0 {
+		t.Error("HTTP/2 should be attempted, with health-check pings")
+	}
+
+	proxy, _ := url.Parse("http://proxy.internal:3128")
+	transport = NewProviderClient(ClientOptions{
+		Connect:             2 * time.Second,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     time.Minute,
+		Proxy:               proxy,
+	}).Transport.(*http.Transport)
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat/completions", nil)
+	if got, err := transport.Proxy(req); err != nil || got.String() != proxy.String() {
+		t.Errorf("Proxy = %v, %v; want %v", got, err, proxy)
+	}
+	if transport.MaxIdleConnsPerHost != 8 || transport.IdleConnTimeout != time.Minute || transport.TLSHandshakeTimeout != 2*time.Second {
+		t.Errorf("options not applied: %+v", transport)
+	}
+
+	if NewProviderClient(ClientOptions{NoProxy: true}).Transport.(*http.Transport).Proxy != nil {
+		t.Error("NoProxy should bypass the environment's proxy")
+	}
+	if (&ProviderService{}).Client() == http.DefaultClient {
+		t.Error("providers without a client should not use http.DefaultClient")
+	}
+}
+
+func TestNewProviderClient_Protocols(t *testing.T) {
+	transport := NewProviderClient(ClientOptions{Protocol: ProtocolHTTP1}).Transport.(*http.Transport)
+	if transport.Protocols == nil || !transport.Protocols.HTTP1() || transport.Protocols.HTTP2() {
+		t.Errorf("http1: protocols %v", transport.Protocols)
+	}
+	transport = NewProviderClient(ClientOptions{Protocol: ProtocolHTTP2}).Transport.(*http.Transport)
+	if transport.Protocols == nil || transport.Protocols.HTTP1() || !transport.Protocols.UnencryptedHTTP2() {
+		t.Errorf("http2: protocols %v", transport.Protocols)
+	}
+	if _, ok := NewProviderClient(ClientOptions{Protocol: ProtocolHTTP3}).Transport.(*http3.Transport); !ok {
+		t.Error("http3 should use a QUIC transport")
+	}
+}
+
+func TestNewProviderClient_TracksConnectionReuse(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := NewProviderClient(ClientOptions{Provider: "conn-test"})
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	client.Transport.(*connTracker).next.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+	for range 3 {
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	if got := testutil.ToFloat64(Metrics.UpstreamConns.WithLabelValues("conn-test", "HTTP/2.0", "false")); got != 1 {
+		t.Errorf("new HTTP/2 connections = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(Metrics.UpstreamConns.WithLabelValues("conn-test", "HTTP/2.0", "true")); got != 2 {
+		t.Errorf("reused HTTP/2 connections = %v, want 2", got)
+	}
+}
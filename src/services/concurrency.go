@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrProviderSaturated is returned by ConcurrencyLimiter.Acquire when every
+// slot stayed busy for the whole grace period.
+var ErrProviderSaturated = errors.New("provider concurrency limit reached")
+
+// ConcurrencyLimiter is a counting semaphore capping the number of in-flight
+// requests to a single provider. Freed slots go to the highest-priority
+// waiter first (FIFO within a class), and low-priority requests never wait:
+// they fall through as soon as the provider is saturated.
+type ConcurrencyLimiter struct {
+	// Grace is how long Acquire waits for a free slot before giving up.
+	// Zero means fail immediately when saturated.
+	Grace time.Duration
+
+	mu       sync.Mutex
+	max      int
+	inFlight int
+	seq      uint64
+	waiters  []*slotWaiter
+}
+
+type slotWaiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	granted  bool
+}
+
+// NewConcurrencyLimiter creates a limiter with max slots. Returns nil when
+// max <= 0; a nil *ConcurrencyLimiter never blocks.
+func NewConcurrencyLimiter(max int, grace time.Duration) *ConcurrencyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &ConcurrencyLimiter{Grace: grace, max: max}
+}
+
+// Acquire takes a slot, waiting up to Grace. The returned release func must
+// be called exactly once when the request finishes.
+func (c *ConcurrencyLimiter) Acquire(ctx context.Context, priority Priority) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	// Fast path: free slot available.
+	c.mu.Lock()
+	if c.inFlight < c.max {
+		c.inFlight++
+		c.mu.Unlock()
+		return c.release, nil
+	}
+	if c.Grace <= 0 || priority <= PriorityLow {
+		c.mu.Unlock()
+		return nil, ErrProviderSaturated
+	}
+	c.seq++
+	w := &slotWaiter{priority: priority, seq: c.seq, ready: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.Grace)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return c.release, nil
+	case <-timer.C:
+		err = ErrProviderSaturated
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.granted {
+		// Handed a slot while giving up; pass it on.
+		c.releaseLocked()
+		return nil, err
+	}
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	return nil, err
+}
+
+func (c *ConcurrencyLimiter) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+}
+
+// releaseLocked hands the slot to the best waiter, or frees it. Caller must
+// hold c.mu.
+func (c *ConcurrencyLimiter) releaseLocked() {
+	if len(c.waiters) == 0 {
+		c.inFlight--
+		return
+	}
+	best := 0
+	for i, w := range c.waiters {
+		b := c.waiters[best]
+		if w.priority > b.priority || (w.priority == b.priority && w.seq < b.seq) {
+			best = i
+		}
+	}
+	w := c.waiters[best]
+	c.waiters = append(c.waiters[:best], c.waiters[best+1:]...)
+	w.granted = true
+	close(w.ready)
+}
+
+// InFlight returns the number of currently held slots.
+func (c *ConcurrencyLimiter) InFlight() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_NilNeverBlocks(t *testing.T) {
+	var c *ConcurrencyLimiter
+	for i := 0; i < 100; i++ {
+		release, err := c.Acquire(context.Background(), PriorityNormal)
+		if err != nil {
+			t.Fatalf("nil limiter should admit, got %v", err)
+		}
+		release()
+	}
+	if NewConcurrencyLimiter(0, time.Second) != nil {
+		t.Error("expected nil limiter for max <= 0")
+	}
+}
+
+func TestConcurrencyLimiter_FastPathAndRelease(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 0)
+	release, err := c.Acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatalf("free slot should be taken immediately: %v", err)
+	}
+	if c.InFlight() != 1 {
+		t.Errorf("expected 1 in flight, got %d", c.InFlight())
+	}
+	if _, err := c.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrProviderSaturated) {
+		t.Errorf("expected ErrProviderSaturated with zero grace, got %v", err)
+	}
+	release()
+	if c.InFlight() != 0 {
+		t.Errorf("expected slot released, got %d in flight", c.InFlight())
+	}
+	if _, err := c.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Errorf("released slot should be reusable: %v", err)
+	}
+}
+
+func TestConcurrencyLimiter_GraceTimeout(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 20*time.Millisecond)
+	if _, err := c.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrProviderSaturated) {
+		t.Fatalf("expected ErrProviderSaturated after grace, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("expected to wait out the grace period, waited %v", waited)
+	}
+}
+
+func TestConcurrencyLimiter_GraceAdmitsOnRelease(t *testing.T) {
+	c := NewConcurrencyLimiter(1, time.Second)
+	release, _ := c.Acquire(context.Background(), PriorityNormal)
+	time.AfterFunc(10*time.Millisecond, release)
+	if _, err := c.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Errorf("expected slot freed during grace to be taken, got %v", err)
+	}
+}
+
+func TestConcurrencyLimiter_ContextCancel(t *testing.T) {
+	c := NewConcurrencyLimiter(1, time.Second)
+	if _, err := c.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := c.Acquire(ctx, PriorityNormal); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if c.InFlight() != 1 {
+		t.Errorf("cancelled acquire must not hold a slot, got %d in flight", c.InFlight())
+	}
+}
+
+func TestConcurrencyLimiter_Priority(t *testing.T) {
+	c := NewConcurrencyLimiter(1, time.Second)
+	release, _ := c.Acquire(context.Background(), PriorityNormal)
+
+	if _, err := c.Acquire(context.Background(), PriorityLow); !errors.Is(err, ErrProviderSaturated) {
+		t.Errorf("low priority should not wait for a slot, got %v", err)
+	}
+
+	order := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityNormal, PriorityHigh} {
+		go func(p Priority) {
+			r, err := c.Acquire(context.Background(), p)
+			if err == nil {
+				order <- p
+				time.Sleep(5 * time.Millisecond)
+				r()
+			}
+		}(p)
+		time.Sleep(10 * time.Millisecond) // normal queues before high
+	}
+	release()
+	if first := <-order; first != PriorityHigh {
+		t.Errorf("expected the high-priority waiter to get the slot first, got %v", first)
+	}
+	<-order
+}
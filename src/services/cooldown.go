@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+// Cooldowns tracks provider/model pairs that an upstream rate-limited with a
+// 429, so the router skips them until the advertised window passes. State
+// lives in a kv.Store, which lets several router instances share it when a
+// shared backend is configured.
+type Cooldowns struct {
+	Store  kv.Store
+	Prefix string
+
+	// Default is used when a 429 carries no usable retry hint; Max caps
+	// whatever the upstream asks for.
+	Default time.Duration
+	Max     time.Duration
+}
+
+// NewCooldowns creates a cooldown tracker keyed under prefix.
+func NewCooldowns(store kv.Store, prefix string, def, max time.Duration) *Cooldowns {
+	return &Cooldowns{Store: store, Prefix: prefix, Default: def, Max: max}
+}
+
+func (c *Cooldowns) key(provider, model string) string {
+	return c.Prefix + "cooldown:" + provider + ":" + model
+}
+
+// Trip puts provider/model on cooldown for d (Default when d <= 0, capped
+// at Max). A nil tracker ignores the call.
+func (c *Cooldowns) Trip(ctx context.Context, provider, model string, d time.Duration) {
+	if c == nil {
+		return
+	}
+	if d <= 0 {
+		d = c.Default
+	}
+	if c.Max > 0 && d > c.Max {
+		d = c.Max
+	}
+	if d <= 0 {
+		return
+	}
+	until := time.Now().Add(d)
+	_ = c.Store.Set(ctx, c.key(provider, model), strconv.FormatInt(until.UnixMilli(), 10), d)
+}
+
+// Remaining returns how long provider/model stays on cooldown, and whether
+// it is cooling down at all.
+func (c *Cooldowns) Remaining(ctx context.Context, provider, model string) (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	v, err := c.Store.Get(ctx, c.key(provider, model))
+	if err != nil {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	left := time.Until(time.UnixMilli(ms))
+	if left <= 0 {
+		return 0, false
+	}
+	return left, true
+}
+
+// RetryAfterFromHeaders extracts the wait an upstream asked for from the
+// common rate-limit headers: Retry-After (seconds or HTTP date),
+// retry-after-ms, OpenAI's x-ratelimit-reset-* durations and Anthropic's
+// anthropic-ratelimit-*-reset timestamps. Per-dimension resets only count
+// when that dimension's remaining budget is exhausted (or unreported).
+// The longest hint wins.
+func RetryAfterFromHeaders(h http.Header, now time.Time) (time.Duration, bool) {
+	var best time.Duration
+	consider := func(d time.Duration) {
+		if d > best {
+			best = d
+		}
+	}
+
+	if v := h.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil {
+			consider(time.Duration(ms * float64(time.Millisecond)))
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			consider(time.Duration(secs * float64(time.Second)))
+		} else if t, err := http.ParseTime(v); err == nil {
+			consider(t.Sub(now))
+		}
+	}
+	for name, values := range h {
+		lname := strings.ToLower(name)
+		if len(values) == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(lname, "x-ratelimit-reset-"):
+			dim := strings.TrimPrefix(lname, "x-ratelimit-reset-")
+			if !exhausted(h, "x-ratelimit-remaining-"+dim) {
+				continue
+			}
+			if d, err := time.ParseDuration(values[0]); err == nil {
+				consider(d)
+			}
+		case strings.HasPrefix(lname, "anthropic-ratelimit-") && strings.HasSuffix(lname, "-reset"):
+			dim := strings.TrimSuffix(lname, "-reset")
+			if !exhausted(h, dim+"-remaining") {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339, values[0]); err == nil {
+				consider(t.Sub(now))
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// exhausted reports whether the remaining-budget header is absent or zero.
+func exhausted(h http.Header, remainingHeader string) bool {
+	v := h.Get(remainingHeader)
+	return v == "" || v == "0"
+}
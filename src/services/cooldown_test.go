@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/services/kv"
+)
+
+func TestRetryAfterFromHeaders(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"7"}}, 7 * time.Second},
+		{"http date", http.Header{"Retry-After": {now.Add(30 * time.Second).Format(http.TimeFormat)}}, 30 * time.Second},
+		{"millis", http.Header{"Retry-After-Ms": {"1500"}}, 1500 * time.Millisecond},
+		{"openai exhausted dimension only", http.Header{
+			"X-Ratelimit-Remaining-Requests": {"0"},
+			"X-Ratelimit-Reset-Requests":     {"2s"},
+			"X-Ratelimit-Remaining-Tokens":   {"5000"},
+			"X-Ratelimit-Reset-Tokens":       {"6m0s"},
+		}, 2 * time.Second},
+		{"anthropic", http.Header{
+			"Anthropic-Ratelimit-Requests-Remaining": {"0"},
+			"Anthropic-Ratelimit-Requests-Reset":     {now.Add(20 * time.Second).Format(time.RFC3339)},
+		}, 20 * time.Second},
+		{"none", http.Header{}, 0},
+	}
+	for _, tc := range cases {
+		got, ok := RetryAfterFromHeaders(tc.header, now)
+		if got != tc.want || ok != (tc.want > 0) {
+			t.Errorf("%s: got %v/%v, want %v", tc.name, got, ok, tc.want)
+		}
+	}
+}
+
+func TestCooldowns_TripAndRemaining(t *testing.T) {
+	c := NewCooldowns(kv.NewMemoryStore(10, time.Minute), "t:", 50*time.Millisecond, time.Second)
+	ctx := context.Background()
+
+	if _, ok := c.Remaining(ctx, "p", "m"); ok {
+		t.Fatal("nothing should be cooling down yet")
+	}
+	c.Trip(ctx, "p", "m", time.Hour) // capped at Max
+	left, ok := c.Remaining(ctx, "p", "m")
+	if !ok || left > time.Second {
+		t.Errorf("expected cooldown capped at 1s, got %v/%v", left, ok)
+	}
+	if _, ok := c.Remaining(ctx, "p", "other"); ok {
+		t.Error("cooldown must be scoped to the model")
+	}
+
+	c.Trip(ctx, "p", "short", 0) // no hint: Default
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Remaining(ctx, "p", "short"); ok {
+		t.Error("default cooldown should have expired")
+	}
+
+	var nilC *Cooldowns
+	nilC.Trip(ctx, "p", "m", time.Second)
+	if _, ok := nilC.Remaining(ctx, "p", "m"); ok {
+		t.Error("nil tracker never cools down")
+	}
+}
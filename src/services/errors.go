@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrClientClosed is returned, and used as the cancellation cause, when the
+// client went away mid-request. The upstream request is abandoned and
+// nothing more is written.
+var ErrClientClosed = errors.New("client closed request")
+
+// ClientGone reports whether the request's client has disconnected: its
+// context is done, other than by the client's own deadline, or err is
+// ErrClientClosed.
+func ClientGone(ctx context.Context, err error) bool {
+	return (ctx.Err() != nil && !DeadlineExceeded(ctx)) || errors.Is(err, ErrClientClosed)
+}
+
+// ErrorKind classifies errors returned to clients.
+type ErrorKind string
+
+const (
+	ErrorProvider      ErrorKind = "provider_error"
+	ErrorRateLimited   ErrorKind = "rate_limited"
+	ErrorContextLength ErrorKind = "context_length_exceeded"
+	ErrorModelNotFound ErrorKind = "model_not_found"
+	ErrorPlugin        ErrorKind = "plugin_error"
+	ErrorGuardBlocked  ErrorKind = "guard_blocked"
+	ErrorUnsupported   ErrorKind = "unsupported_by_model"
+	ErrorConfig        ErrorKind = "configuration_error"
+	// ErrorOverloaded means every provider for the model is at capacity.
+	ErrorOverloaded ErrorKind = "provider_overloaded"
+	// Admission rejections: the load shedder refused the request, the fair
+	// queue was full, or the request waited too long in it.
+	ErrorServerOverloaded ErrorKind = "server_overloaded"
+	ErrorQueueFull        ErrorKind = "queue_full"
+	ErrorQueueTimeout     ErrorKind = "queue_timeout"
+)
+
+// RouterError is an error with a client-facing kind, HTTP status and
+// message. Endpoint modules render it as an OpenAI-shaped error body;
+// plugins return one (e.g. ErrorGuardBlocked) to control what the client
+// sees.
+type RouterError struct {
+	Kind    ErrorKind
+	Status  int // 0 means the kind's default
+	Message string
+	// Provider is the provider that failed, when there is one, and
+	// UpstreamStatus the status it answered with.
+	Provider       string
+	UpstreamStatus int
+	RetryAfter     time.Duration
+	Err            error
+	// Attempts lists how each provider tried failed, when the request
+	// fell over between several.
+	Attempts []Attempt
+}
+
+// Attempt is how one provider failed a request.
+type Attempt struct {
+	Provider string `json:"provider"`
+	Status   int    `json:"status"` // the upstream's, or the router's when it did not answer
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// AttemptOf describes provider failing with err.
+func AttemptOf(provider string, err error) Attempt {
+	re := AsRouterError(err)
+	status := re.UpstreamStatus
+	if status == 0 {
+		status = re.HTTPStatus()
+	}
+	return Attempt{Provider: provider, Status: status, Code: re.Code(), Message: re.Message}
+}
+
+// WithAttempts returns err, classified by AsRouterError, listing attempts.
+func WithAttempts(err error, attempts []Attempt) *RouterError {
+	re := *AsRouterError(err)
+	re.Attempts = attempts
+	return &re
+}
+
+// NewRouterError returns a RouterError of kind with its default status.
+func NewRouterError(kind ErrorKind, message string) *RouterError {
+	return &RouterError{Kind: kind, Message: message}
+}
+
+func (e *RouterError) Error() string { return e.Message }
+
+func (e *RouterError) Unwrap() error { return e.Err }
+
+// HTTPStatus returns the status the error is served with.
+func (e *RouterError) HTTPStatus() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	switch e.Kind {
+	case ErrorRateLimited, ErrorQueueFull:
+		return http.StatusTooManyRequests
+	case ErrorContextLength, ErrorGuardBlocked, ErrorUnsupported:
+		return http.StatusBadRequest
+	case ErrorModelNotFound:
+		return http.StatusNotFound
+	case ErrorPlugin, ErrorConfig:
+		return http.StatusInternalServerError
+	case ErrorOverloaded, ErrorServerOverloaded, ErrorQueueTimeout:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// Type returns the OpenAI error type for the kind.
+func (e *RouterError) Type() string {
+	switch e.Kind {
+	case ErrorRateLimited, ErrorQueueFull:
+		return "rate_limit_error"
+	case ErrorContextLength, ErrorModelNotFound, ErrorGuardBlocked, ErrorUnsupported:
+		return "invalid_request_error"
+	}
+	if s := e.HTTPStatus(); s >= 400 && s < 500 {
+		return "invalid_request_error"
+	}
+	return "server_error"
+}
+
+// Code returns the error code; OpenAI's rate_limit_exceeded for rate
+// limits, the kind otherwise.
+func (e *RouterError) Code() string {
+	if e.Kind == ErrorRateLimited {
+		return "rate_limit_exceeded"
+	}
+	return string(e.Kind)
+}
+
+// AsRouterError returns err as a RouterError, classifying errors that are
+// not one already: rate limits, provider timeouts, and anything else as a
+// provider error.
+func AsRouterError(err error) *RouterError {
+	var re *RouterError
+	if errors.As(err, &re) {
+		return re
+	}
+	var rl *RateLimitError
+	switch {
+	case errors.As(err, &rl):
+		return &RouterError{Kind: ErrorRateLimited, Message: err.Error(), RetryAfter: rl.RetryAfter, Err: err}
+	case errors.Is(err, ErrFirstByteTimeout), errors.Is(err, ErrTotalTimeout), errors.Is(err, ErrRequestDeadline):
+		return &RouterError{Kind: ErrorProvider, Status: http.StatusGatewayTimeout, Message: err.Error(), Err: err}
+	}
+	return &RouterError{Kind: ErrorProvider, Message: err.Error(), Err: err}
+}
+
+// Retryability says whether a failed request may succeed if tried again,
+// on another provider or later.
+type Retryability int
+
+const (
+	// Retryable failures (upstream server errors, timeouts, network
+	// errors, and upstream auth failures, unknown models or rejected
+	// requests, which another provider need not share) are worth trying
+	// elsewhere.
+	Retryable Retryability = iota
+	// RateLimited failures may succeed on a provider with budget left, or
+	// once the limit resets.
+	RateLimited
+	// Fatal failures would fail anywhere: the request is over the context
+	// window or blocked by a guard, the configuration is at fault, or the
+	// client has gone or run out of time. An upstream 400, 413 or 422 is
+	// not fatal by itself: providers differ in the parameters, sizes and
+	// schemas they accept.
+	Fatal
+)
+
+func (c Retryability) String() string {
+	switch c {
+	case RateLimited:
+		return "rate_limited"
+	case Fatal:
+		return "fatal"
+	}
+	return "retryable"
+}
+
+// Classify labels a failure (err must not be nil) for whoever decides
+// whether to try again: the pipeline falling over between providers, and
+// plugins that retry.
+func Classify(err error) Retryability {
+	if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrRequestDeadline) {
+		return Fatal
+	}
+	re := AsRouterError(err)
+	switch re.Kind {
+	case ErrorRateLimited:
+		return RateLimited
+	case ErrorContextLength, ErrorGuardBlocked, ErrorConfig:
+		return Fatal
+	}
+	return Retryable
+}
+
+// PluginError wraps a plugin failure as ErrorPlugin, keeping RouterErrors
+// (e.g. guard_blocked) a plugin returned deliberately.
+func PluginError(err error) error {
+	var re *RouterError
+	if err == nil || errors.As(err, &re) {
+		return err
+	}
+	return &RouterError{Kind: ErrorPlugin, Message: err.Error(), Err: err}
+}
+
+// contextLengthPattern matches how OpenAI, Anthropic, Gemini and common
+// OpenAI-compatible servers report an oversized prompt.
+var contextLengthPattern = regexp.MustCompile(`(?i)context_length_exceeded|context length|context window|prompt is too long|too many tokens|maximum.*tokens|input token count.*exceeds`)
+
+// maxUpstreamMessage bounds the upstream message passed to clients.
+const maxUpstreamMessage = 2000
+
+// UpstreamError classifies a non-2xx upstream response. Client errors the
+// caller can fix (400, 413, 422) keep their status; upstream auth and
+// server failures become 502 so provider credentials are not blamed on
+// the client.
+func UpstreamError(provider string, res *http.Response, body []byte) *RouterError {
+	e := &RouterError{
+		Kind:           ErrorProvider,
+		Status:         http.StatusBadGateway,
+		Message:        upstreamMessage(body),
+		Provider:       provider,
+		UpstreamStatus: res.StatusCode,
+	}
+	if e.Message == "" {
+		e.Message = "upstream returned " + res.Status
+	}
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		e.Kind, e.Status = ErrorRateLimited, 0
+		e.RetryAfter, _ = RetryAfterFromHeaders(res.Header, time.Now())
+	case res.StatusCode/100 == 4 && contextLengthPattern.MatchString(string(body)):
+		e.Kind, e.Status = ErrorContextLength, 0
+	case res.StatusCode == http.StatusNotFound && strings.Contains(strings.ToLower(e.Message), "model"):
+		e.Kind, e.Status = ErrorModelNotFound, 0
+	case res.StatusCode == http.StatusBadRequest,
+		res.StatusCode == http.StatusRequestEntityTooLarge,
+		res.StatusCode == http.StatusUnprocessableEntity:
+		e.Status = res.StatusCode
+	}
+	return e
+}
+
+// upstreamMessage extracts the message from an OpenAI, Anthropic or Gemini
+// error body, falling back to the raw body.
+func upstreamMessage(body []byte) string {
+	var env struct {
+		Error json.RawMessage `json:"error"`
+		// Some OpenAI-compatible servers put the message at the top level.
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &env) == nil {
+		var inner struct {
+			Message string `json:"message"`
+		}
+		var s string
+		switch {
+		case json.Unmarshal(env.Error, &inner) == nil && inner.Message != "":
+			msg = inner.Message
+		case json.Unmarshal(env.Error, &s) == nil && s != "":
+			msg = s
+		case env.Message != "":
+			msg = env.Message
+		}
+	}
+	if len(msg) > maxUpstreamMessage {
+		msg = msg[:maxUpstreamMessage] + "…"
+	}
+	return msg
+}
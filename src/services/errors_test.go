@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantKind   ErrorKind
+		wantStatus int
+		wantMsg    string
+	}{
+		{"openai context length", 400,
+			`{"error":{"message":"This model's maximum context length is 8192 tokens.","type":"invalid_request_error","code":"context_length_exceeded"}}`,
+			ErrorContextLength, 400, "This model's maximum context length is 8192 tokens."},
+		{"anthropic prompt too long", 400,
+			`{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+			ErrorContextLength, 400, "prompt is too long: 210000 tokens > 200000 maximum"},
+		{"rate limited", 429, `{"error":{"message":"slow down"}}`, ErrorRateLimited, 429, "slow down"},
+		{"unknown model", 404, `{"error":{"message":"The model 'x' does not exist"}}`, ErrorModelNotFound, 404, "The model 'x' does not exist"},
+		{"bad request passes through", 400, `{"error":{"message":"temperature out of range"}}`, ErrorProvider, 400, "temperature out of range"},
+		{"upstream auth is a gateway error", 401, `{"error":"bad key"}`, ErrorProvider, 502, "bad key"},
+		{"server error plain body", 503, "overloaded\n", ErrorProvider, 502, "overloaded"},
+		{"empty body", 500, "", ErrorProvider, 502, "upstream returned 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Status: fmt.Sprintf("%d %s", tt.status, http.StatusText(tt.status)), Header: http.Header{}}
+			e := UpstreamError("p", res, []byte(tt.body))
+			if e.Kind != tt.wantKind || e.HTTPStatus() != tt.wantStatus || e.Message != tt.wantMsg {
+				t.Errorf("got %s %d %q, want %s %d %q", e.Kind, e.HTTPStatus(), e.Message, tt.wantKind, tt.wantStatus, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestAsRouterError(t *testing.T) {
+	guard := NewRouterError(ErrorGuardBlocked, "blocked")
+	if got := AsRouterError(fmt.Errorf("wrapped: %w", guard)); got != guard {
+		t.Errorf("wrapped RouterError not unwrapped: %+v", got)
+	}
+	if got := PluginError(guard); got != error(guard) {
+		t.Errorf("PluginError replaced a RouterError: %v", got)
+	}
+	if got := AsRouterError(PluginError(errors.New("boom"))); got.Kind != ErrorPlugin || got.HTTPStatus() != 500 || got.Type() != "server_error" {
+		t.Errorf("plugin error = %+v", got)
+	}
+
+	rl := AsRouterError(&RateLimitError{RetryAfter: 3 * time.Second})
+	if rl.Kind != ErrorRateLimited || rl.RetryAfter != 3*time.Second || rl.Code() != "rate_limit_exceeded" {
+		t.Errorf("rate limit = %+v", rl)
+	}
+	if to := AsRouterError(ErrFirstByteTimeout); to.HTTPStatus() != http.StatusGatewayTimeout {
+		t.Errorf("timeout status = %d", to.HTTPStatus())
+	}
+	if other := AsRouterError(errors.New("dial tcp: refused")); other.Kind != ErrorProvider || other.HTTPStatus() != 502 {
+		t.Errorf("other = %+v", other)
+	}
+}
+
+func TestWithAttempts(t *testing.T) {
+	res := &http.Response{StatusCode: 503, Status: "503 Service Unavailable", Header: http.Header{}}
+	attempts := []Attempt{
+		AttemptOf("a", UpstreamError("a", res, []byte("overloaded"))),
+		AttemptOf("b", ErrFirstByteTimeout),
+	}
+	if a := attempts[0]; a.Provider != "a" || a.Status != 503 || a.Code != "provider_error" || a.Message != "overloaded" {
+		t.Errorf("upstream attempt = %+v", a)
+	}
+	if b := attempts[1]; b.Status != http.StatusGatewayTimeout || b.Message != ErrFirstByteTimeout.Error() {
+		t.Errorf("timeout attempt = %+v", b)
+	}
+
+	guard := NewRouterError(ErrorGuardBlocked, "blocked")
+	got := WithAttempts(guard, attempts)
+	if got.Kind != ErrorGuardBlocked || len(got.Attempts) != 2 || guard.Attempts != nil {
+		t.Errorf("WithAttempts = %+v, original %+v", got, guard)
+	}
+	if !errors.Is(WithAttempts(ErrFirstByteTimeout, attempts), ErrFirstByteTimeout) {
+		t.Error("classified error no longer wraps the original")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	upstream := func(status int, body string) error {
+		res := &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}}
+		return UpstreamError("p", res, []byte(body))
+	}
+	tests := []struct {
+		name string
+		err  error
+		want Retryability
+	}{
+		{"server error", upstream(503, "overloaded"), Retryable},
+		{"upstream auth", upstream(401, "bad key"), Retryable},
+		{"unknown model", upstream(404, `{"error":{"message":"no such model"}}`), Retryable},
+		{"network", errors.New("dial tcp: refused"), Retryable},
+		{"first byte timeout", ErrFirstByteTimeout, Retryable},
+		{"upstream rate limit", upstream(429, "slow down"), RateLimited},
+		{"local rate limit", &RateLimitError{RetryAfter: time.Second}, RateLimited},
+		{"bad request", upstream(400, "temperature out of range"), Retryable},
+		{"too large", upstream(413, ""), Retryable},
+		{"unprocessable", upstream(422, "unknown field"), Retryable},
+		{"context length", upstream(400, `{"error":{"message":"maximum context length is 8192 tokens"}}`), Fatal},
+		{"guard", fmt.Errorf("plugin: %w", NewRouterError(ErrorGuardBlocked, "blocked")), Fatal},
+		{"client gone", fmt.Errorf("read: %w", ErrClientClosed), Fatal},
+		{"deadline", ErrRequestDeadline, Fatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthStatus is a point-in-time view of a provider's health.
+type HealthStatus struct {
+	Healthy             bool      `json:"healthy"`
+	Probe               string    `json:"probe,omitempty"`
+	LastCheck           time.Time `json:"last_check,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	LatencyMs           int64     `json:"latency_ms,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"`
+}
+
+// ProviderHealth tracks the health of a single provider as reported by the
+// active health checker. A provider starts out healthy and is marked
+// unhealthy after UnhealthyAfter consecutive probe failures; a single
+// successful probe restores it.
+type ProviderHealth struct {
+	Probe          string
+	UnhealthyAfter int
+
+	mu     sync.RWMutex
+	status HealthStatus
+}
+
+// NewProviderHealth creates a tracker for the given probe kind.
+func NewProviderHealth(probe string, unhealthyAfter int) *ProviderHealth {
+	if unhealthyAfter <= 0 {
+		unhealthyAfter = 1
+	}
+	return &ProviderHealth{
+		Probe:          probe,
+		UnhealthyAfter: unhealthyAfter,
+		status:         HealthStatus{Healthy: true, Probe: probe},
+	}
+}
+
+// Healthy reports whether the provider is currently considered healthy.
+// A nil tracker (health checking disabled) is always healthy.
+func (h *ProviderHealth) Healthy() bool {
+	if h == nil {
+		return true
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status.Healthy
+}
+
+// Report records the outcome of a probe.
+func (h *ProviderHealth) Report(err error, latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.status.LastCheck = now
+	h.status.LatencyMs = latency.Milliseconds()
+	if err == nil {
+		h.status.Healthy = true
+		h.status.LastSuccess = now
+		h.status.LastError = ""
+		h.status.ConsecutiveFailures = 0
+		return
+	}
+	h.status.LastError = err.Error()
+	h.status.ConsecutiveFailures++
+	if h.status.ConsecutiveFailures >= h.UnhealthyAfter {
+		h.status.Healthy = false
+	}
+}
+
+// Status returns a copy of the current health status.
+func (h *ProviderHealth) Status() HealthStatus {
+	if h == nil {
+		return HealthStatus{Healthy: true}
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProviderHealth_UnhealthyAfterThreshold(t *testing.T) {
+	h := NewProviderHealth("tcp", 2)
+	if !h.Healthy() {
+		t.Fatal("new tracker should start healthy")
+	}
+	h.Report(errors.New("dial failed"), time.Millisecond)
+	if !h.Healthy() {
+		t.Error("one failure should not flip health with unhealthy_after 2")
+	}
+	h.Report(errors.New("dial failed"), time.Millisecond)
+	if h.Healthy() {
+		t.Error("expected unhealthy after 2 consecutive failures")
+	}
+	st := h.Status()
+	if st.ConsecutiveFailures != 2 || st.LastError != "dial failed" {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestProviderHealth_RecoversAfterOneSuccess(t *testing.T) {
+	h := NewProviderHealth("tcp", 1)
+	h.Report(errors.New("down"), 0)
+	if h.Healthy() {
+		t.Fatal("expected unhealthy")
+	}
+	h.Report(nil, 5*time.Millisecond)
+	st := h.Status()
+	if !st.Healthy || st.ConsecutiveFailures != 0 || st.LastError != "" || st.LastSuccess.IsZero() {
+		t.Errorf("expected full recovery after one success, got %+v", st)
+	}
+}
+
+func TestProviderHealth_NilIsHealthy(t *testing.T) {
+	var h *ProviderHealth
+	h.Report(errors.New("ignored"), 0)
+	if !h.Healthy() || !h.Status().Healthy {
+		t.Error("nil tracker should always report healthy")
+	}
+}
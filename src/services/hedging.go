@@ -0,0 +1,53 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBankedHedges caps the hedges a quiet spell can save up, so a burst
+// of slow requests after it cannot double traffic for long.
+const maxBankedHedges = 10
+
+// Hedging sends a duplicate of a request to the next provider when the
+// first has not produced its first token within Delay; whichever answers
+// first serves the client and the other is cancelled. Duplicates cost
+// money, so they are bounded by Budget: every request earns Budget hedges
+// (0.05 allows one in twenty requests to be hedged) and each hedge spends
+// one. Methods are safe for concurrent use and no-ops on a nil Hedging.
+type Hedging struct {
+	Delay  time.Duration
+	Budget float64
+
+	mu     sync.Mutex
+	banked float64
+}
+
+// NewHedging creates a hedging policy.
+func NewHedging(delay time.Duration, budget float64) *Hedging {
+	return &Hedging{Delay: delay, Budget: budget}
+}
+
+// Earn credits the budget with one request.
+func (h *Hedging) Earn() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.banked = min(h.banked+h.Budget, maxBankedHedges)
+}
+
+// Spend takes one hedge from the budget, reporting whether one was left.
+func (h *Hedging) Spend() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.banked < 1-1e-9 { // twenty 0.05s may not quite sum to 1
+		return false
+	}
+	h.banked--
+	return true
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedging_Budget(t *testing.T) {
+	h := NewHedging(time.Second, 0.05)
+	if h.Spend() {
+		t.Fatal("hedged before any request earned budget")
+	}
+	for range 20 {
+		h.Earn()
+	}
+	if !h.Spend() {
+		t.Fatal("twenty requests at 0.05 did not earn a hedge")
+	}
+	if h.Spend() {
+		t.Fatal("spent a hedge twice")
+	}
+
+	for range 1000 {
+		h.Earn()
+	}
+	n := 0
+	for h.Spend() {
+		n++
+	}
+	if n != maxBankedHedges {
+		t.Errorf("banked %d hedges, want the cap of %d", n, maxBankedHedges)
+	}
+}
+
+func TestHedging_Nil(t *testing.T) {
+	var h *Hedging
+	h.Earn()
+	if h.Spend() {
+		t.Error("nil hedging spent a hedge")
+	}
+}
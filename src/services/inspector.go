@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Inspector stages, in the order a request passes through them.
+const (
+	InspectRequest   = "request"    // parsed client request, before plugins
+	InspectUpstream  = "upstream"   // program sent to the provider, after plugins
+	InspectResponse  = "response"   // complete upstream response, after plugins
+	InspectStreamEnd = "stream_end" // assembled streamed response
+	InspectError     = "error"      // failed provider call
+)
+
+// InspectorEvent is one stage of an in-flight request.
+type InspectorEvent struct {
+	Time     time.Time    `json:"time"`
+	TraceID  string       `json:"trace_id"`
+	KeyID    string       `json:"key_id,omitempty"`
+	Stage    string       `json:"stage"`
+	Provider string       `json:"provider,omitempty"`
+	Model    string       `json:"model,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Prog     *ail.Program `json:"-"`
+}
+
+// Inspector broadcasts request events to live subscribers. Publishing never
+// blocks: events for a subscriber whose buffer is full are dropped and
+// counted.
+type Inspector struct {
+	mu     sync.RWMutex
+	subs   map[*InspectorSubscription]struct{}
+	active atomic.Int32
+}
+
+// LiveInspector is the process-wide inspector fed by the inspect plugin.
+var LiveInspector = &Inspector{}
+
+// InspectorSubscription receives events until it is closed.
+type InspectorSubscription struct {
+	C       chan InspectorEvent
+	dropped atomic.Int64
+	hub     *Inspector
+}
+
+// Active reports whether anyone is subscribed, so publishers can skip
+// building events nobody reads.
+func (in *Inspector) Active() bool {
+	return in.active.Load() > 0
+}
+
+// Subscribe registers a subscriber with room for buffer pending events.
+func (in *Inspector) Subscribe(buffer int) *InspectorSubscription {
+	s := &InspectorSubscription{C: make(chan InspectorEvent, buffer), hub: in}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.subs == nil {
+		in.subs = make(map[*InspectorSubscription]struct{})
+	}
+	in.subs[s] = struct{}{}
+	in.active.Add(1)
+	return s
+}
+
+// Publish sends ev to every subscriber.
+func (in *Inspector) Publish(ev InspectorEvent) {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	for s := range in.subs {
+		select {
+		case s.C <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns and resets the number of events dropped since the last
+// call.
+func (s *InspectorSubscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes; C receives no further events.
+func (s *InspectorSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		s.hub.active.Add(-1)
+	}
+}
+
+// secretPattern matches credentials commonly pasted into prompts or tool
+// results: OpenAI/Anthropic-style keys, bearer tokens, AWS, Google, GitHub
+// and Slack tokens.
+var secretPattern = regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}|(?i:bearer)\s+[A-Za-z0-9._~+/=-]{8,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,}`)
+
+// RedactSecrets replaces credentials in s with "[REDACTED]".
+func RedactSecrets(s string) string {
+	return secretPattern.ReplaceAllString(s, "[REDACTED]")
+}
+
+// piiPatterns match personal data, in the order RedactPII replaces them:
+// email addresses, payment card numbers, US social security numbers,
+// phone numbers with separators and IPv4 addresses. Cards must pass the
+// Luhn check and addresses have octets up to 255, so order numbers and
+// version strings survive.
+var piiPatterns = []struct {
+	re    *regexp.Regexp
+	label string
+	valid func(string) bool
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), "[EMAIL]", nil},
+	{regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), "[CARD]", luhnValid},
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[SSN]", nil},
+	{regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)|\b\d{3})[ .-]?\d{3}[ .-]\d{4}\b`), "[PHONE]", nil},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "[IP]", ipv4Valid},
+}
+
+// RedactPII replaces credentials and personal data in s with placeholders
+// such as "[EMAIL]" and "[PHONE]".
+func RedactPII(s string) string {
+	return replacePII(s, func(label, _ string) string { return label })
+}
+
+// HashPII is RedactPII keeping each value's identity: personal data
+// becomes its placeholder plus the first 8 bytes of its HMAC-SHA256 under
+// key, such as "[EMAIL:3f2a9c1b07d4e856]", so the same address can be
+// followed through a corpus without being readable. Credentials are
+// redacted outright. key must be secret: short values such as phone
+// numbers are easily recovered from their tags by anyone holding it.
+func HashPII(s string, key []byte) string {
+	return replacePII(s, func(label, m string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(m))
+		return label[:len(label)-1] + ":" + hex.EncodeToString(mac.Sum(nil)[:8]) + "]"
+	})
+}
+
+// PIIHashKey derives the HashPII key from key, so that a key kept for
+// something else, such as sealing samples, is never the HMAC key itself.
+func PIIHashKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("pii-hash"))
+	return mac.Sum(nil)
+}
+
+// replacePII redacts credentials in s and replaces each piece of personal
+// data by repl of its placeholder and the matched text.
+func replacePII(s string, repl func(label, match string) string) string {
+	s = RedactSecrets(s)
+	for _, p := range piiPatterns {
+		s = p.re.ReplaceAllStringFunc(s, func(m string) string {
+			if p.valid != nil && !p.valid(m) {
+				return m
+			}
+			return repl(p.label, m)
+		})
+	}
+	return s
+}
+
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func ipv4Valid(s string) bool {
+	for _, octet := range strings.Split(s, ".") {
+		if n, err := strconv.Atoi(octet); err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// RedactContent returns a copy of prog with message text, reasoning, tool
+// arguments and tool results replaced by their sizes, leaving only the
+// program's structure.
+func RedactContent(prog *ail.Program) *ail.Program {
+	out := prog.Clone()
+	for i := range out.Code {
+		inst := &out.Code[i]
+		switch inst.Op {
+		case ail.TXT_CHUNK, ail.THINK_CHUNK, ail.RESULT_DATA:
+			inst.Str = redactedSize(len(inst.Str))
+		case ail.CALL_ARGS:
+			inst.JSON = []byte(`"` + redactedSize(len(inst.JSON)) + `"`)
+		}
+	}
+	return out
+}
+
+func redactedSize(n int) string {
+	return "[" + strconv.Itoa(n) + " bytes]"
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func TestInspector_PublishSubscribe(t *testing.T) {
+	in := &Inspector{}
+	in.Publish(InspectorEvent{Stage: InspectRequest}) // no subscribers
+	if in.Active() {
+		t.Fatal("inspector without subscribers should be inactive")
+	}
+
+	sub := in.Subscribe(1)
+	if !in.Active() {
+		t.Fatal("expected an active inspector")
+	}
+	in.Publish(InspectorEvent{TraceID: "a"})
+	in.Publish(InspectorEvent{TraceID: "b"}) // buffer full, dropped
+	if ev := <-sub.C; ev.TraceID != "a" {
+		t.Errorf("got event %q, want a", ev.TraceID)
+	}
+	if n := sub.Dropped(); n != 1 {
+		t.Errorf("dropped = %d, want 1", n)
+	}
+
+	sub.Close()
+	sub.Close()
+	if in.Active() {
+		t.Error("closed subscription should deactivate the inspector")
+	}
+	in.Publish(InspectorEvent{TraceID: "c"})
+	if len(sub.C) != 0 {
+		t.Error("closed subscription received an event")
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	got := RedactSecrets("key sk-abcdefghijklmnopqrstuv and Authorization: Bearer abc.def.ghi123")
+	if strings.Contains(got, "sk-abc") || strings.Contains(got, "abc.def") {
+		t.Errorf("secrets left in %q", got)
+	}
+
+	prog := ail.NewProgram()
+	prog.Emit(ail.MSG_START)
+	prog.Emit(ail.ROLE_USR)
+	prog.EmitString(ail.TXT_CHUNK, "top secret prompt")
+	prog.Emit(ail.MSG_END)
+	redacted := RedactContent(prog).Disasm()
+	if strings.Contains(redacted, "top secret") || !strings.Contains(redacted, "[17 bytes]") {
+		t.Errorf("content not redacted:\n%s", redacted)
+	}
+	if prog.Code[2].Str != "top secret prompt" {
+		t.Error("RedactContent modified the original program")
+	}
+}
+
+func TestRedactPII(t *testing.T) {
+	for in, want := range map[string]string{
+		"mail jane.doe+x@mail.example.co.uk today": "mail [EMAIL] today",
+		"card 4111 1111 1111 1111 exp 12/29":       "card [CARD] exp 12/29",
+		"order 4111 1111 1111 1112":                "order 4111 1111 1111 1112",
+		"ssn 078-05-1120":                          "ssn [SSN]",
+		"call +1 (555) 123-4567 or 555.123.4567":   "call [PHONE] or [PHONE]",
+		"from 192.168.10.4, version 1.2.3.400":     "from [IP], version 1.2.3.400",
+		"token sk-abcdefghijklmnopqrstuv":          "token [REDACTED]",
+		"nothing to see in 2026, id 12345":         "nothing to see in 2026, id 12345",
+	} {
+		if got := RedactPII(in); got != want {
+			t.Errorf("RedactPII(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHashPII(t *testing.T) {
+	a := HashPII("mail jane@example.com or call 555-123-4567", []byte("k1"))
+	if strings.Contains(a, "jane") || !strings.HasPrefix(a, "mail [EMAIL:") || !strings.Contains(a, " or call [PHONE:") {
+		t.Errorf("HashPII = %q", a)
+	}
+	if b := HashPII("mail jane@example.com or call 555-123-4567", []byte("k1")); b != a {
+		t.Errorf("hashes differ between calls: %q, %q", a, b)
+	}
+	if c := HashPII("mail jane@example.com or call 555-123-4567", []byte("k2")); c == a {
+		t.Error("hashes do not depend on the key")
+	}
+	if !regexp.MustCompile(`^mail \[EMAIL:[0-9a-f]{16}\] `).MatchString(a) {
+		t.Errorf("HashPII = %q, want 8-byte tags", a)
+	}
+	if k := PIIHashKey([]byte("k1")); len(k) != 32 || string(k) == "k1" {
+		t.Errorf("PIIHashKey = %x", k)
+	}
+	if got := HashPII("token sk-abcdefghijklmnopqrstuv", nil); got != "token [REDACTED]" {
+		t.Errorf("credentials hashed: %q", got)
+	}
+}
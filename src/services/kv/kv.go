@@ -9,6 +9,7 @@ package kv
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync"
 	"time"
 )
@@ -32,6 +33,12 @@ type Store interface {
 	Close() error
 }
 
+// Lister is implemented by stores that can enumerate their keys.
+type Lister interface {
+	// List returns every live key starting with prefix, with its value.
+	List(ctx context.Context, prefix string) (map[string]string, error)
+}
+
 // ─── Backend registry ────────────────────────────────────────────────────────
 
 // BackendFactory creates a Store from a DSN / config string.
@@ -67,6 +74,30 @@ func Open(name, dsn string) (Store, error) {
 	return f(dsn)
 }
 
+// HasBackend reports whether the named backend is registered; the empty
+// name is "memory".
+func HasBackend(name string) bool {
+	if name == "" {
+		name = "memory"
+	}
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	_, ok := backends[name]
+	return ok
+}
+
+// pingKey is read by Ping; it is never written.
+const pingKey = "kv:ping"
+
+// Ping checks that store answers a read, so a misconfigured backend is
+// reported at startup rather than on the first request using it.
+func Ping(ctx context.Context, store Store) error {
+	if _, err := store.Get(ctx, pingKey); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
 // ─── In-memory implementation ────────────────────────────────────────────────
 
 type memEntry struct {
@@ -137,4 +168,21 @@ func (m *MemoryStore) Delete(_ context.Context, key string) error {
 	return nil
 }
 
+func (m *MemoryStore) List(_ context.Context, prefix string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	out := make(map[string]string)
+	for key, e := range m.data {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			continue
+		}
+		out[key] = e.value
+	}
+	return out, nil
+}
+
 func (m *MemoryStore) Close() error { return nil }
\ No newline at end of file
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyAlpha is the EWMA smoothing factor; higher reacts faster.
+	latencyAlpha = 0.2
+
+	// latencyFailurePenalty is the sample recorded for a failed request, so
+	// a provider that fails fast does not look fast.
+	latencyFailurePenalty = 10 * time.Second
+
+	// latencyStaleAfter is how long an average stays trusted without new
+	// samples. Stale providers report no average, which the latency
+	// strategy treats optimistically so they get re-explored.
+	latencyStaleAfter = time.Minute
+
+	// latencyWindow is how many recent successful samples are kept for
+	// percentiles, and latencyMinSamples how many a percentile needs.
+	latencyWindow     = 100
+	latencyMinSamples = 20
+)
+
+// LatencyTracker keeps an exponentially weighted moving average of a
+// provider's observed time to first byte: the first stream event for
+// streams, the response headers otherwise. It also keeps a window of recent
+// successful samples for percentiles.
+type LatencyTracker struct {
+	mu      sync.Mutex
+	ewma    float64 // nanoseconds
+	samples int
+	last    time.Time
+	window  []time.Duration // ring of recent successful samples
+	next    int
+}
+
+// Observe records one successful request latency.
+func (t *LatencyTracker) Observe(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observeLocked(d)
+	if len(t.window) < latencyWindow {
+		t.window = append(t.window, d)
+	} else {
+		t.window[t.next] = d
+		t.next = (t.next + 1) % latencyWindow
+	}
+}
+
+// ObserveFailure records a failed request as a latency penalty. Failures
+// only affect the average, not percentiles.
+func (t *LatencyTracker) ObserveFailure() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observeLocked(latencyFailurePenalty)
+}
+
+func (t *LatencyTracker) observeLocked(d time.Duration) {
+	if t.samples == 0 {
+		t.ewma = float64(d)
+	} else {
+		t.ewma = latencyAlpha*float64(d) + (1-latencyAlpha)*t.ewma
+	}
+	t.samples++
+	t.last = time.Now()
+}
+
+// Mean returns the smoothed latency and whether it is backed by recent
+// samples.
+func (t *LatencyTracker) Mean() (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.samples == 0 || time.Since(t.last) > latencyStaleAfter {
+		return 0, false
+	}
+	return time.Duration(t.ewma), true
+}
+
+// Percentile returns the q-th (0..1) percentile of recent successful
+// samples, and whether there are enough recent samples to trust it.
+func (t *LatencyTracker) Percentile(q float64) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.window) < latencyMinSamples || time.Since(t.last) > latencyStaleAfter {
+		return 0, false
+	}
+	sorted := slices.Clone(t.window)
+	slices.Sort(sorted)
+	i := int(q * float64(len(sorted)-1))
+	return sorted[max(0, min(i, len(sorted)-1))], true
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "ai_router"
+
+// Metrics holds the router's Prometheus collectors. They are process-wide
+// so counters survive config reloads; RegisterMetrics attaches them to each
+// new metrics registry.
+var Metrics = struct {
+	Requests        *prometheus.CounterVec
+	RequestDuration *prometheus.HistogramVec
+	ProviderErrors  *prometheus.CounterVec
+	FallbackHops    *prometheus.HistogramVec
+	PluginDuration  *prometheus.HistogramVec
+	PluginErrors    *prometheus.CounterVec
+	StreamTTFT      *prometheus.HistogramVec
+	FirstToken      *prometheus.HistogramVec
+	TokensPerSecond *prometheus.HistogramVec
+	Tokens          *prometheus.CounterVec
+	Anomalies       *prometheus.CounterVec
+	Hedges          *prometheus.CounterVec
+	UpstreamConns   *prometheus.CounterVec
+	DSPyDuration    *prometheus.HistogramVec
+	DSPyErrors      *prometheus.CounterVec
+	DSPyLMCalls     *prometheus.CounterVec
+}{
+	Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Inference requests by serving provider and outcome.",
+	}, []string{"router", "provider", "outcome"}),
+	RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Inference request duration, including fallbacks and streaming.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"router", "outcome"}),
+	ProviderErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "provider_errors_total",
+		Help:      "Failed provider attempts.",
+	}, []string{"router", "provider"}),
+	FallbackHops: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fallback_hops",
+		Help:      "Providers tried after the first one, per request.",
+		Buckets:   []float64{0, 1, 2, 3, 5},
+	}, []string{"router"}),
+	PluginDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_duration_seconds",
+		Help:      "Plugin hook execution time.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"plugin", "hook"}),
+	PluginErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_errors_total",
+		Help:      "Failed plugin hooks; policy is closed (the attempt failed) or open (the plugin was skipped).",
+	}, []string{"plugin", "hook", "policy"}),
+	StreamTTFT: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_ttft_seconds",
+		Help:      "Time from sending a streaming request upstream to its first event.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 5, 10, 30},
+	}, []string{"router", "provider"}),
+	FirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_first_token_seconds",
+		Help:      "Time from dispatching a streaming request to the first content delta sent to the client.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 5, 10, 30},
+	}, []string{"router", "provider", "model"}),
+	TokensPerSecond: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_tokens_per_second",
+		Help:      "Sustained completion tokens per second of streamed responses, after the first token.",
+		Buckets:   []float64{5, 10, 20, 40, 60, 80, 120, 160, 250, 500},
+	}, []string{"router", "provider", "model"}),
+	Tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_total",
+		Help:      "Upstream-reported tokens; kind is prompt, completion or cached_prompt (prompt-cache hits).",
+	}, []string{"router", "provider", "model", "kind"}),
+	Anomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "anomalies_total",
+		Help:      "Requests flagged by the watchdog; reason is latency or tokens.",
+	}, []string{"router", "reason"}),
+	Hedges: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "hedges_total",
+		Help:      "Requests that were slow to a first token; result is won (the backup served), lost (the primary did), failed or over_budget (not hedged).",
+	}, []string{"router", "result"}),
+	UpstreamConns: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_requests_total",
+		Help:      "Upstream requests by protocol and whether their connection was reused (false: dialed, with a TLS handshake).",
+	}, []string{"provider", "proto", "reused"}),
+	DSPyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dspy_duration_seconds",
+		Help:      "DSPy module runs by kind; outcome is ok, error or fallback (served by plain inference).",
+		Buckets:   []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"kind", "outcome"}),
+	DSPyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dspy_sidecar_errors_total",
+		Help:      "Failed DSPy sidecar calls; reason is unreachable or sidecar (it answered with an error).",
+	}, []string{"kind", "reason"}),
+	DSPyLMCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dspy_lm_calls_total",
+		Help:      "LM calls DSPy modules made back through the router.",
+	}, []string{"kind"}),
+}
+
+// RegisterMetrics registers the router collectors with reg. Collectors
+// already registered there are skipped, so every router may call it.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		Metrics.Requests,
+		Metrics.RequestDuration,
+		Metrics.ProviderErrors,
+		Metrics.FallbackHops,
+		Metrics.PluginDuration,
+		Metrics.PluginErrors,
+		Metrics.StreamTTFT,
+		Metrics.FirstToken,
+		Metrics.TokensPerSecond,
+		Metrics.Tokens,
+		Metrics.Anomalies,
+		Metrics.Hedges,
+		Metrics.UpstreamConns,
+		Metrics.DSPyDuration,
+		Metrics.DSPyErrors,
+		Metrics.DSPyLMCalls,
+	} {
+		if err := reg.Register(c); err != nil {
+			var already prometheus.AlreadyRegisteredError
+			if !errors.As(err, &already) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ObservePlugin records how long a plugin hook ran.
+func ObservePlugin(plugin, hook string, start time.Time) {
+	Metrics.PluginDuration.WithLabelValues(plugin, hook).Observe(time.Since(start).Seconds())
+}
+
+// ObservePluginError counts a failed plugin hook under the failure policy
+// applied, "open" or "closed".
+func ObservePluginError(plugin, hook, policy string) {
+	Metrics.PluginErrors.WithLabelValues(plugin, hook, policy).Inc()
+}
+
+// ObserveUsage adds the usage reported by provider for model.
+func ObserveUsage(router, provider, model string, u Usage) {
+	Metrics.Tokens.WithLabelValues(router, provider, model, "prompt").Add(float64(u.PromptTokens))
+	Metrics.Tokens.WithLabelValues(router, provider, model, "completion").Add(float64(u.CompletionTokens))
+	Metrics.Tokens.WithLabelValues(router, provider, model, "cached_prompt").Add(float64(u.CachedTokens))
+}
+
+// ObserveDSPy records a DSPy module run of kind that took since start.
+func ObserveDSPy(kind, outcome string, start time.Time) {
+	Metrics.DSPyDuration.WithLabelValues(kind, outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDSPyError counts a failed DSPy sidecar call.
+func ObserveDSPyError(kind, reason string) {
+	Metrics.DSPyErrors.WithLabelValues(kind, reason).Inc()
+}
+
+// ObserveDSPyLMCalls adds the LM calls a DSPy module of kind made.
+func ObserveDSPyLMCalls(kind string, n int) {
+	Metrics.DSPyLMCalls.WithLabelValues(kind).Add(float64(n))
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterMetrics_Idempotent(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	if err := RegisterMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMetrics(reg); err != nil {
+		t.Errorf("second router registering into the same registry should not fail: %v", err)
+	}
+}
+
+func TestUsageFromProgram(t *testing.T) {
+	if _, ok := UsageFromProgram(ail.NewProgram()); ok {
+		t.Error("program without USAGE should report no usage")
+	}
+	prog := ail.NewProgram()
+	prog.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":100,"completion_tokens":20,"prompt_tokens_details":{"cached_tokens":64}}`))
+	u, ok := UsageFromProgram(prog)
+	if !ok || u != (Usage{PromptTokens: 100, CompletionTokens: 20, CachedTokens: 64}) {
+		t.Errorf("unexpected usage %+v (ok=%v)", u, ok)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import "strings"
+
+// ModelPrice is the list price of a model in USD per one million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+	// CachedInput is the price of prompt tokens served from the upstream's
+	// prompt cache; zero bills them at Input.
+	CachedInput float64 `json:"cached_input,omitempty"`
+}
+
+// Cost returns the USD cost of the given token counts at this price.
+func (mp ModelPrice) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*mp.Input + float64(outputTokens)*mp.Output) / 1e6
+}
+
+// UsageCost returns the USD cost of upstream-reported usage. Cached tokens
+// are part of PromptTokens and billed at CachedInput when it is set.
+func (mp ModelPrice) UsageCost(u Usage) float64 {
+	if mp.CachedInput == 0 || u.CachedTokens == 0 {
+		return mp.Cost(u.PromptTokens, u.CompletionTokens)
+	}
+	uncached := max(u.PromptTokens-u.CachedTokens, 0)
+	return mp.Cost(uncached, u.CompletionTokens) + float64(u.CachedTokens)*mp.CachedInput/1e6
+}
+
+// PriceCatalog holds list prices of well-known models, used when a provider
+// has no price configured for a model. Dated snapshots ("gpt-4o-2024-08-06")
+// and vendor-prefixed IDs ("openai/gpt-4o") resolve to their base entry.
+var PriceCatalog = map[string]ModelPrice{
+	// OpenAI
+	"gpt-5":                  {Input: 1.25, Output: 10, CachedInput: 0.125},
+	"gpt-5-mini":             {Input: 0.25, Output: 2, CachedInput: 0.025},
+	"gpt-5-nano":             {Input: 0.05, Output: 0.40, CachedInput: 0.005},
+	"gpt-4.1":                {Input: 2, Output: 8, CachedInput: 0.50},
+	"gpt-4.1-mini":           {Input: 0.40, Output: 1.60, CachedInput: 0.10},
+	"gpt-4.1-nano":           {Input: 0.10, Output: 0.40, CachedInput: 0.025},
+	"gpt-4o":                 {Input: 2.50, Output: 10, CachedInput: 1.25},
+	"gpt-4o-mini":            {Input: 0.15, Output: 0.60, CachedInput: 0.075},
+	"o3":                     {Input: 2, Output: 8, CachedInput: 0.50},
+	"o3-mini":                {Input: 1.10, Output: 4.40, CachedInput: 0.55},
+	"o4-mini":                {Input: 1.10, Output: 4.40, CachedInput: 0.275},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+
+	// Anthropic
+	"claude-opus-4":     {Input: 15, Output: 75, CachedInput: 1.50},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CachedInput: 0.30},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CachedInput: 0.30},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4, CachedInput: 0.08},
+
+	// Google
+	"gemini-2.5-pro":   {Input: 1.25, Output: 10, CachedInput: 0.31},
+	"gemini-2.5-flash": {Input: 0.30, Output: 2.50, CachedInput: 0.075},
+	"gemini-2.0-flash": {Input: 0.10, Output: 0.40, CachedInput: 0.025},
+}
+
+// CatalogPrice looks model up in PriceCatalog.
+func CatalogPrice(model string) (ModelPrice, bool) {
+	return catalogEntry(PriceCatalog, model)
+}
+
+func isSnapshotOf(model, base string) bool {
+	rest, ok := strings.CutPrefix(model, base+"-")
+	if !ok || rest == "" {
+		return false
+	}
+	return rest == "latest" || (rest[0] >= '0' && rest[0] <= '9')
+}
+
+// PriceFor returns the price of model on this provider. An exact model
+// entry wins over the provider-wide "*" entry, which wins over the
+// router's catalog.
+func (p *ProviderService) PriceFor(model string) (ModelPrice, bool) {
+	prices := p.ConfiguredPrices()
+	if mp, ok := prices[model]; ok {
+		return mp, true
+	}
+	if mp, ok := prices["*"]; ok {
+		return mp, true
+	}
+	if p.Router != nil {
+		return p.Router.Catalog.Price(model)
+	}
+	return CatalogPrice(model)
+}
+
+// CostOf returns the USD cost of usage reported for model on this
+// provider; false when the model has no known price.
+func (p *ProviderService) CostOf(model string, u Usage) (float64, bool) {
+	mp, ok := p.PriceFor(model)
+	if !ok {
+		return 0, false
+	}
+	return mp.UsageCost(u), true
+}
+
+// ConfiguredPrices returns the provider's configured prices; callers must
+// not modify them.
+func (p *ProviderService) ConfiguredPrices() map[string]ModelPrice {
+	p.pricesMu.RLock()
+	defer p.pricesMu.RUnlock()
+	return p.Prices
+}
+
+// SetPrices replaces the provider's configured prices, e.g. on a policy
+// reload.
+func (p *ProviderService) SetPrices(prices map[string]ModelPrice) {
+	p.pricesMu.Lock()
+	p.Prices = prices
+	p.pricesMu.Unlock()
+}
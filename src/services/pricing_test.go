@@ -0,0 +1,55 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCatalogPrice(t *testing.T) {
+	cases := map[string]string{
+		"gpt-4o":                    "gpt-4o",
+		"gpt-4o-mini-2024-07-18":    "gpt-4o-mini",
+		"openai/gpt-4.1-nano":       "gpt-4.1-nano",
+		"claude-sonnet-4-20250514":  "claude-sonnet-4",
+		"claude-3-5-haiku-latest":   "claude-3-5-haiku",
+		"anthropic/claude-opus-4-1": "claude-opus-4",
+		"o3-pro":                    "",
+		"gpt-4o-realtime-preview":   "",
+		"some-local-model":          "",
+	}
+	for model, base := range cases {
+		got, ok := CatalogPrice(model)
+		if ok != (base != "") || (ok && got != PriceCatalog[base]) {
+			t.Errorf("%s: got %+v/%v, want catalog entry %q", model, got, ok, base)
+		}
+	}
+}
+
+func TestPriceFor_ConfigOverridesCatalog(t *testing.T) {
+	p := &ProviderService{Prices: map[string]ModelPrice{"gpt-4o": {Input: 1, Output: 1}}}
+	if mp, _ := p.PriceFor("gpt-4o"); mp.Input != 1 {
+		t.Errorf("configured price should win, got %+v", mp)
+	}
+	if mp, ok := p.PriceFor("gpt-4o-mini"); !ok || mp != PriceCatalog["gpt-4o-mini"] {
+		t.Errorf("expected catalog fallback, got %+v/%v", mp, ok)
+	}
+
+	local := &ProviderService{Prices: map[string]ModelPrice{"*": {}}}
+	if cost, ok := local.CostOf("gpt-4o", Usage{PromptTokens: 1000}); !ok || cost != 0 {
+		t.Errorf("provider-wide price should override catalog, got %v/%v", cost, ok)
+	}
+}
+
+func TestUsageCost(t *testing.T) {
+	mp := ModelPrice{Input: 2, Output: 8, CachedInput: 0.5}
+	got := mp.UsageCost(Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000, CachedTokens: 400_000})
+	// 600k uncached at $2 + 400k cached at $0.50 + 500k output at $8.
+	if want := 1.2 + 0.2 + 4.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("UsageCost = %v, want %v", got, want)
+	}
+
+	noCache := ModelPrice{Input: 2, Output: 8}
+	if got := noCache.UsageCost(Usage{PromptTokens: 1_000_000, CachedTokens: 400_000}); math.Abs(got-2) > 1e-9 {
+		t.Errorf("cached tokens should bill at Input without a cached price, got %v", got)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority is a request's traffic class. Under contention higher classes
+// are admitted first and lower classes are shed first.
+type Priority int
+
+const (
+	PriorityLow    Priority = 0
+	PriorityNormal Priority = 1
+	PriorityHigh   Priority = 2
+)
+
+// ParsePriority accepts low, normal, high or a non-negative integer.
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(s) {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid priority %q: expected low, normal, high or a number", s)
+	}
+	return Priority(n), nil
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// AuthTenant derives the stable, non-reversible tenant ID used for an
+// Authorization header value.
+func AuthTenant(authorization string) string {
+	sum := sha256.Sum256([]byte(authorization))
+	return "auth:" + hex.EncodeToString(sum[:8])
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"net/http"
 	"net/url"
+	"path"
+	"sync"
 
 	"github.com/neutrome-labs/ail"
 )
@@ -21,10 +24,78 @@ type ProviderService struct {
 	// target providers.
 	ExportedModels map[string]bool
 
+	// ExportPatterns extends ExportedModels with glob patterns
+	// (path.Match syntax, e.g. "gpt-4*"): a model matching any of them is
+	// exported too.
+	ExportPatterns []string
+
 	// Private marks a provider as completely hidden from external access.
 	// A private provider exports no models and rejects all direct inference.
 	// It can only be used as an upstream target for virtual providers.
 	Private bool
+
+	// RateLimiter enforces the provider's RPM/TPM budgets. Nil means
+	// unlimited.
+	RateLimiter *RateLimiter
+
+	// Concurrency caps the number of in-flight requests. Nil means
+	// unlimited.
+	Concurrency *ConcurrencyLimiter
+
+	// Health is maintained by the active health checker. Nil means health
+	// checking is disabled and the provider is always considered healthy.
+	Health *ProviderHealth
+
+	// Prices maps model IDs (or "*" for any model) to their list price on
+	// this provider. Used by the cost routing strategy. Set it before use;
+	// replace it later with SetPrices.
+	Prices   map[string]ModelPrice
+	pricesMu sync.RWMutex
+
+	// Latency tracks the smoothed latency of requests served by this
+	// provider. Used by the latency routing strategy.
+	Latency *LatencyTracker
+
+	// Quality maps model IDs (or "*" for any model) to an operator-assigned
+	// quality tier; higher is better. Used by the quality routing strategy.
+	Quality map[string]int
+
+	// Timeouts bounds upstream requests. Nil means no first-byte or total
+	// bound.
+	Timeouts *Timeouts
+
+	// StructuredOutputs selects how response_format reaches the upstream:
+	// "" maps it onto the style's native mechanism, "grammar" sends a GBNF
+	// grammar (llama.cpp).
+	StructuredOutputs string
+
+	// ToolChoice is "emulate" for upstreams that cannot force tool use,
+	// whose forced tool choices are retried until a tool is called; ""
+	// passes them on natively.
+	ToolChoice string
+
+	// HTTPClient is the provider's own client, built by NewProviderClient
+	// from its connect timeout and http_client settings. Nil falls back to
+	// a shared client with the default settings.
+	HTTPClient *http.Client
+}
+
+// Client returns the HTTP client used for upstream requests.
+func (p *ProviderService) Client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return defaultProviderClient()
+}
+
+// QualityFor returns the configured quality tier of model on this provider.
+// An exact model entry wins over the provider-wide "*" entry.
+func (p *ProviderService) QualityFor(model string) (int, bool) {
+	if q, ok := p.Quality[model]; ok {
+		return q, true
+	}
+	q, ok := p.Quality["*"]
+	return q, ok
 }
 
 // IsModelExported returns true if the given model is allowed by the exports
@@ -34,8 +105,21 @@ func (p *ProviderService) IsModelExported(model string) bool {
 	if p.Private {
 		return false
 	}
-	if len(p.ExportedModels) == 0 {
+	if !p.HasExports() {
 		return true
 	}
-	return p.ExportedModels[model]
+	if p.ExportedModels[model] {
+		return true
+	}
+	for _, pattern := range p.ExportPatterns {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExports reports whether an exports filter is configured.
+func (p *ProviderService) HasExports() bool {
+	return len(p.ExportedModels) > 0 || len(p.ExportPatterns) > 0
 }
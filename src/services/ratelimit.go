@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// ErrRateLimited is returned by RateLimiter.Acquire when the configured
+// budget cannot be satisfied within the allowed wait.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError is the concrete error returned by RateLimiter.Acquire. It
+// matches ErrRateLimited under errors.Is and carries how long the caller
+// would have had to wait for the budget to cover the request.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string { return ErrRateLimited.Error() }
+
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// RateLimitConfig describes a requests-per-minute and tokens-per-minute
+// budget. A zero value disables the corresponding limit.
+type RateLimitConfig struct {
+	RPM int `json:"rpm,omitempty"`
+	TPM int `json:"tpm,omitempty"`
+}
+
+// IsZero reports whether no limit is configured.
+func (c RateLimitConfig) IsZero() bool {
+	return c.RPM <= 0 && c.TPM <= 0
+}
+
+// ─── Token bucket ────────────────────────────────────────────────────────────
+
+// TokenBucket is a classic token bucket refilled continuously at a fixed
+// rate. Reservations may drive the balance negative; the returned delay is
+// how long the caller must wait before the reservation is covered.
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+// NewTokenBucket creates a full bucket holding perMinute tokens and
+// refilling at perMinute/60 tokens per second.
+func NewTokenBucket(perMinute int) *TokenBucket {
+	return &TokenBucket{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// Reserve takes n tokens from the bucket and returns how long the caller
+// must wait until they are actually available (zero if available now).
+func (b *TokenBucket) Reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Cancel returns n previously reserved tokens to the bucket.
+func (b *TokenBucket) Cancel(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens += n
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// ─── Rate limiter ────────────────────────────────────────────────────────────
+
+// limitBuckets pairs the request and token buckets for one scope.
+type limitBuckets struct {
+	requests *TokenBucket
+	tokens   *TokenBucket
+}
+
+func newLimitBuckets(cfg RateLimitConfig) limitBuckets {
+	var lb limitBuckets
+	if cfg.RPM > 0 {
+		lb.requests = NewTokenBucket(cfg.RPM)
+	}
+	if cfg.TPM > 0 {
+		lb.tokens = NewTokenBucket(cfg.TPM)
+	}
+	return lb
+}
+
+// RateLimiter enforces RPM/TPM budgets for a provider as a whole and for
+// individual upstream models on that provider. Bursts beyond the budget are
+// queued for up to MaxWait before Acquire gives up with ErrRateLimited.
+type RateLimiter struct {
+	MaxWait time.Duration
+
+	provider limitBuckets
+	models   map[string]limitBuckets
+}
+
+// NewRateLimiter builds a limiter from provider-wide and per-model budgets.
+// Returns nil when no limits are configured; a nil *RateLimiter admits
+// everything.
+func NewRateLimiter(provider RateLimitConfig, models map[string]RateLimitConfig, maxWait time.Duration) *RateLimiter {
+	if provider.IsZero() && len(models) == 0 {
+		return nil
+	}
+	l := &RateLimiter{
+		MaxWait:  maxWait,
+		provider: newLimitBuckets(provider),
+		models:   make(map[string]limitBuckets, len(models)),
+	}
+	for model, cfg := range models {
+		if !cfg.IsZero() {
+			l.models[model] = newLimitBuckets(cfg)
+		}
+	}
+	return l
+}
+
+// Acquire reserves one request and the estimated token count against the
+// provider and model budgets, sleeping while the buckets refill. If the
+// required wait exceeds MaxWait the reservation is rolled back and a
+// *RateLimitError (matching ErrRateLimited) is returned. On success the
+// returned cancel func refunds the reservation, for callers that end up not
+// sending the request after all.
+func (l *RateLimiter) Acquire(ctx context.Context, model string, tokens int) (cancel func(), err error) {
+	return l.AcquireWithin(ctx, model, tokens, -1)
+}
+
+// AcquireWithin is Acquire with the wait additionally capped at budget, so
+// a caller trying several providers in turn can bound its total queueing
+// time. A negative budget means no extra cap.
+func (l *RateLimiter) AcquireWithin(ctx context.Context, model string, tokens int, budget time.Duration) (cancel func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	maxWait := l.MaxWait
+	if budget >= 0 && budget < maxWait {
+		maxWait = budget
+	}
+
+	type reservation struct {
+		bucket *TokenBucket
+		n      float64
+	}
+	var held []reservation
+	var wait time.Duration
+
+	reserve := func(b *TokenBucket, n float64) {
+		if b == nil || n <= 0 {
+			return
+		}
+		// A request larger than the whole bucket could never be admitted;
+		// clamp it so it waits for a full bucket instead.
+		n = min(n, b.capacity)
+		if d := b.Reserve(n); d > wait {
+			wait = d
+		}
+		held = append(held, reservation{b, n})
+	}
+	rollback := func() {
+		for _, r := range held {
+			r.bucket.Cancel(r.n)
+		}
+	}
+
+	scopes := []limitBuckets{l.provider}
+	if mb, ok := l.models[model]; ok {
+		scopes = append(scopes, mb)
+	}
+	for _, s := range scopes {
+		reserve(s.requests, 1)
+		reserve(s.tokens, float64(tokens))
+	}
+
+	if wait == 0 {
+		return rollback, nil
+	}
+	if wait > maxWait {
+		rollback()
+		return nil, &RateLimitError{RetryAfter: wait}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return rollback, nil
+	case <-ctx.Done():
+		rollback()
+		return nil, ctx.Err()
+	}
+}
+
+// EstimateTokens returns an upper-bound estimate of the tokens a request
+// will consume: its prompt tokens plus the requested completion budget
+// (SET_MAX). Used for TPM accounting before the request is sent, when
+// exact usage is not yet known.
+func EstimateTokens(prog *ail.Program) int {
+	prompt, completion := EstimatePromptCompletion(prog)
+	return prompt + completion
+}
+
+// EstimatePromptCompletion splits EstimateTokens into its prompt and
+// completion parts. Prompt tokens come from CountTokens; completion is the
+// SET_MAX budget, or 0 when unset.
+func EstimatePromptCompletion(prog *ail.Program) (prompt, completion int) {
+	for _, inst := range prog.Code {
+		if inst.Op == ail.SET_MAX {
+			completion = int(inst.Int)
+		}
+	}
+	return CountTokens(prog.GetModel(), prog), completion
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_ReserveWithinCapacity(t *testing.T) {
+	b := NewTokenBucket(60)
+	if d := b.Reserve(60); d != 0 {
+		t.Errorf("expected no wait for full bucket, got %v", d)
+	}
+	// Empty now: one more token at 1 token/sec needs ~1s.
+	d := b.Reserve(1)
+	if d < 900*time.Millisecond || d > 1100*time.Millisecond {
+		t.Errorf("expected ~1s wait, got %v", d)
+	}
+}
+
+func TestTokenBucket_CancelRefunds(t *testing.T) {
+	b := NewTokenBucket(10)
+	b.Reserve(10)
+	b.Cancel(10)
+	if d := b.Reserve(10); d != 0 {
+		t.Errorf("expected refunded tokens to be available, got wait %v", d)
+	}
+}
+
+func TestRateLimiter_NilAdmitsEverything(t *testing.T) {
+	var l *RateLimiter
+	if _, err := l.Acquire(context.Background(), "gpt-4", 1_000_000); err != nil {
+		t.Errorf("nil limiter should admit, got %v", err)
+	}
+	if NewRateLimiter(RateLimitConfig{}, nil, time.Second) != nil {
+		t.Error("expected nil limiter when nothing is configured")
+	}
+}
+
+func TestRateLimiter_RejectsBeyondMaxWait(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{RPM: 1}, nil, 10*time.Millisecond)
+	if _, err := l.Acquire(context.Background(), "m", 0); err != nil {
+		t.Fatalf("first request should pass: %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "m", 0); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestRateLimiter_ModelScope(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{}, map[string]RateLimitConfig{
+		"small": {TPM: 100},
+	}, 0)
+	if _, err := l.Acquire(context.Background(), "small", 100); err != nil {
+		t.Fatalf("within model budget: %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "small", 50); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected model TPM to reject, got %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "other", 1000); err != nil {
+		t.Errorf("unlimited model should pass, got %v", err)
+	}
+}
+
+func TestRateLimiter_ClampsOversizedRequest(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{TPM: 100}, nil, time.Second)
+	if _, err := l.Acquire(context.Background(), "m", 1000); err != nil {
+		t.Errorf("request larger than TPM should be clamped to a full bucket, got %v", err)
+	}
+}
+
+func TestRateLimiter_RetryAfter(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{RPM: 60}, nil, 0)
+	for i := 0; i < 60; i++ {
+		_, _ = l.Acquire(context.Background(), "m", 0)
+	}
+	_, err := l.Acquire(context.Background(), "m", 0)
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected *RateLimitError matching ErrRateLimited, got %v", err)
+	}
+	if rlErr.RetryAfter < 900*time.Millisecond || rlErr.RetryAfter > 1100*time.Millisecond {
+		t.Errorf("expected ~1s retry-after, got %v", rlErr.RetryAfter)
+	}
+}
+
+func TestRateLimiter_AcquireWithinBudget(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{RPM: 60}, nil, 5*time.Second)
+	for i := 0; i < 60; i++ {
+		_, _ = l.Acquire(context.Background(), "m", 0)
+	}
+	// ~1s wait fits MaxWait but not the caller's 10ms budget.
+	if _, err := l.AcquireWithin(context.Background(), "m", 0, 10*time.Millisecond); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected budget to reject, got %v", err)
+	}
+}
+
+func TestRateLimiter_CancelRefunds(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{RPM: 1}, nil, 0)
+	cancel, err := l.Acquire(context.Background(), "m", 0)
+	if err != nil {
+		t.Fatalf("first request should pass: %v", err)
+	}
+	cancel()
+	if _, err := l.Acquire(context.Background(), "m", 0); err != nil {
+		t.Errorf("cancelled reservation should be refunded, got %v", err)
+	}
+}
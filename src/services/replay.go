@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// ReplaySample is one sampled request directory: <dir>/request.ail plus the
+// request.up.ail and response.ail baselines the sampler stored next to it.
+type ReplaySample struct {
+	Name string // path relative to the corpus root, slash-separated
+	Dir  string
+}
+
+// ReplayRunner sends a request program through the pipeline and returns
+// the program sent upstream and the complete response.
+type ReplayRunner func(ctx context.Context, req *ail.Program) (upstream, response *ail.Program, err error)
+
+// ReplayOptions controls what a replay compares.
+type ReplayOptions struct {
+	SkipUpstream bool
+	SkipResponse bool
+	// IgnoreOps lists opcodes (e.g. "RESP_ID", "USAGE") whose lines are
+	// left out of the comparison because they differ on every call.
+	IgnoreOps []string
+	// Key unseals samples the sampler encrypted (see Seal).
+	Key []byte
+}
+
+// DefaultReplayIgnoreOps are ignored when ReplayOptions.IgnoreOps is nil.
+var DefaultReplayIgnoreOps = []string{"RESP_ID"}
+
+// ReplayDiff compares one stored program with its replayed counterpart.
+// Diff holds the changed disassembly lines, prefixed with "-" (stored) or
+// "+" (replayed).
+type ReplayDiff struct {
+	Changed         bool     `json:"changed"`
+	BaselineMissing bool     `json:"baseline_missing,omitempty"`
+	Diff            []string `json:"diff,omitempty"`
+}
+
+// Replay statuses.
+const (
+	ReplayUnchanged = "unchanged"
+	ReplayChanged   = "changed"
+	ReplayFailed    = "error"
+)
+
+// ReplayResult is the outcome of replaying one sample.
+type ReplayResult struct {
+	Sample   string      `json:"sample"`
+	Model    string      `json:"model,omitempty"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Upstream *ReplayDiff `json:"upstream,omitempty"`
+	Response *ReplayDiff `json:"response,omitempty"`
+}
+
+// ReplayReport is the machine-readable outcome of a corpus replay.
+type ReplayReport struct {
+	Total     int            `json:"total"`
+	Unchanged int            `json:"unchanged"`
+	Changed   int            `json:"changed"`
+	Failed    int            `json:"failed"`
+	Results   []ReplayResult `json:"results"`
+}
+
+// maxDiffLines bounds the LCS table; larger programs are reported as
+// changed without a line diff.
+const maxDiffLines = 4000
+
+// LoadReplayCorpus finds every sample below dir, in lexical order. A
+// positive limit stops after that many samples.
+func LoadReplayCorpus(dir string, limit int) ([]ReplaySample, error) {
+	errLimit := errors.New("limit reached")
+	var samples []ReplaySample
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "request.ail" {
+			return nil
+		}
+		sampleDir := filepath.Dir(path)
+		rel, err := filepath.Rel(dir, sampleDir)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, ReplaySample{Name: filepath.ToSlash(rel), Dir: sampleDir})
+		if limit > 0 && len(samples) >= limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// RunReplay replays samples one at a time and diffs each against its
+// stored baselines. It stops early, returning the partial report, when
+// ctx is cancelled.
+func RunReplay(ctx context.Context, samples []ReplaySample, run ReplayRunner, opts ReplayOptions) *ReplayReport {
+	ignore := opts.IgnoreOps
+	if ignore == nil {
+		ignore = DefaultReplayIgnoreOps
+	}
+	report := &ReplayReport{Results: make([]ReplayResult, 0, len(samples))}
+	for _, s := range samples {
+		if ctx.Err() != nil {
+			break
+		}
+		res := replaySample(ctx, s, run, opts, ignore)
+		switch res.Status {
+		case ReplayUnchanged:
+			report.Unchanged++
+		case ReplayChanged:
+			report.Changed++
+		default:
+			report.Failed++
+		}
+		report.Total++
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+func replaySample(ctx context.Context, s ReplaySample, run ReplayRunner, opts ReplayOptions, ignore []string) ReplayResult {
+	res := ReplayResult{Sample: s.Name, Status: ReplayFailed}
+	req, err := ReadSample(filepath.Join(s.Dir, "request.ail"), opts.Key)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Model = req.GetModel()
+
+	up, resp, err := run(ctx, req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Status = ReplayUnchanged
+	if !opts.SkipUpstream {
+		res.Upstream = diffBaseline(filepath.Join(s.Dir, "request.up.ail"), up, opts.Key, ignore)
+	}
+	if !opts.SkipResponse {
+		res.Response = diffBaseline(filepath.Join(s.Dir, "response.ail"), resp, opts.Key, ignore)
+	}
+	for _, d := range []*ReplayDiff{res.Upstream, res.Response} {
+		if d != nil && d.Changed {
+			res.Status = ReplayChanged
+		}
+	}
+	return res
+}
+
+func diffBaseline(path string, got *ail.Program, key []byte, ignore []string) *ReplayDiff {
+	want, err := ReadSample(path, key)
+	if err != nil {
+		return &ReplayDiff{BaselineMissing: true}
+	}
+	diff := DiffAIL(want, got, ignore...)
+	return &ReplayDiff{Changed: len(diff) > 0, Diff: diff}
+}
+
+// ReadSample reads a program the sampler stored, unsealing it with key
+// when it is sealed.
+func ReadSample(path string, key []byte) (*ail.Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = Unseal(key, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ail.Decode(bytes.NewReader(data))
+}
+
+// DiffAIL returns a line diff of the disassembly of two programs, or nil
+// when they match. Lines starting with one of ignoreOps are skipped.
+func DiffAIL(old, new *ail.Program, ignoreOps ...string) []string {
+	return DiffLines(disasmLines(old, ignoreOps), disasmLines(new, ignoreOps))
+}
+
+// DiffLines returns a line diff of a and b, or nil when they match.
+// Removed lines are prefixed with "-" and added ones with "+".
+func DiffLines(a, b []string) []string {
+	if slices.Equal(a, b) {
+		return nil
+	}
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return []string{"~ programs differ (too large to diff)"}
+	}
+
+	// Longest common subsequence, walked forwards to emit -/+ lines.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+func disasmLines(prog *ail.Program, ignoreOps []string) []string {
+	if prog == nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(prog.Disasm(), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || ignoredLine(trimmed, ignoreOps) {
+			continue
+		}
+		lines = append(lines, trimmed)
+	}
+	return lines
+}
+
+func ignoredLine(line string, ops []string) bool {
+	for _, op := range ops {
+		if line == op || strings.HasPrefix(line, op+" ") {
+			return true
+		}
+	}
+	return false
+}
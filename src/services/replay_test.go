@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func replayProg(model, text string) *ail.Program {
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, model)
+	prog.Emit(ail.MSG_START)
+	prog.Emit(ail.ROLE_USR)
+	prog.EmitString(ail.TXT_CHUNK, text)
+	prog.Emit(ail.MSG_END)
+	return prog
+}
+
+func writeProgram(t *testing.T, path string, prog *ail.Program) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffAIL(t *testing.T) {
+	a := replayProg("m", "hello")
+	if d := DiffAIL(a, a.Clone()); d != nil {
+		t.Fatalf("identical programs diff: %v", d)
+	}
+
+	d := DiffAIL(a, replayProg("m", "bye"))
+	if len(d) != 2 || d[0][0] != '-' || d[1][0] != '+' {
+		t.Fatalf("diff = %q, want one - and one + line", d)
+	}
+
+	withID := a.Clone()
+	withID.EmitString(ail.RESP_ID, "chatcmpl-1")
+	if d := DiffAIL(a, withID, "RESP_ID"); d != nil {
+		t.Errorf("ignored opcode still diffed: %v", d)
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"same", "changed", "nobaseline"} {
+		writeProgram(t, filepath.Join(dir, "2026/10/15", name, "request.ail"), replayProg("m", name))
+	}
+	writeProgram(t, filepath.Join(dir, "2026/10/15/same/request.up.ail"), replayProg("m", "same"))
+	writeProgram(t, filepath.Join(dir, "2026/10/15/same/response.ail"), replayProg("m", "reply"))
+	writeProgram(t, filepath.Join(dir, "2026/10/15/changed/request.up.ail"), replayProg("m", "old"))
+	writeProgram(t, filepath.Join(dir, "2026/10/15/changed/response.ail"), replayProg("m", "reply"))
+
+	samples, err := LoadReplayCorpus(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range samples {
+		names = append(names, s.Name)
+	}
+	want := []string{"2026/10/15/changed", "2026/10/15/nobaseline", "2026/10/15/same"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("samples = %v, want %v", names, want)
+	}
+	if limited, _ := LoadReplayCorpus(dir, 1); len(limited) != 1 {
+		t.Errorf("limit 1 loaded %d samples", len(limited))
+	}
+
+	run := func(_ context.Context, req *ail.Program) (*ail.Program, *ail.Program, error) {
+		return req.Clone(), replayProg("m", "reply"), nil
+	}
+	report := RunReplay(context.Background(), samples, run, ReplayOptions{})
+	if report.Total != 3 || report.Changed != 1 || report.Unchanged != 2 || report.Failed != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	changed := report.Results[0]
+	if changed.Status != ReplayChanged || !changed.Upstream.Changed || changed.Response.Changed {
+		t.Errorf("changed sample = %+v", changed)
+	}
+	if nb := report.Results[1]; !nb.Upstream.BaselineMissing || !nb.Response.BaselineMissing {
+		t.Errorf("missing baselines not reported: %+v", nb)
+	}
+
+	report = RunReplay(context.Background(), samples, run, ReplayOptions{SkipUpstream: true})
+	if report.Changed != 0 || report.Results[0].Upstream != nil {
+		t.Errorf("SkipUpstream still compared upstream: %+v", report.Results[0])
+	}
+}
@@ -1,15 +1,62 @@
 package services
 
-import "net/http"
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+)
+
+// DefaultCaptureSpillThreshold is the body size past which a capture made
+// by NewResponseCapture moves to a temporary file.
+const DefaultCaptureSpillThreshold = 4 << 20
 
 // ResponseCaptureWriter captures response data instead of writing to HTTP.
 // Implements http.ResponseWriter and http.Flusher so that it can be used
 // as a drop-in replacement wherever a real ResponseWriter is expected
 // (including SSE writers that require Flush support).
+//
+// With a SpillThreshold the body moves to a temporary file once it grows
+// past it, so tool loops and chains capturing long generations don't
+// hold them in memory. Read a capture through Len, Reader, WriteTo or
+// Bytes, which work either way; Response is only the in-memory body.
+// Close removes the file; one left open is removed when the capture is
+// garbage collected.
 type ResponseCaptureWriter struct {
-	Response   []byte
+	Response   []byte // nil once the body has spilled
 	Headers    http.Header
 	StatusCode int
+
+	// SpillThreshold, when positive, is the body size past which it moves
+	// to a file in SpillDir (os.TempDir when empty).
+	SpillThreshold int
+	SpillDir       string
+
+	spill   *spillFile
+	cleanup runtime.Cleanup
+	size    int64 // of the spilled body
+}
+
+// NewResponseCapture returns a capture that spills past
+// DefaultCaptureSpillThreshold.
+func NewResponseCapture() *ResponseCaptureWriter {
+	return &ResponseCaptureWriter{SpillThreshold: DefaultCaptureSpillThreshold}
+}
+
+// spillFile is a capture's temporary file. It is unlinked as soon as it
+// is created where the platform allows, so nothing is left behind if the
+// process dies.
+type spillFile struct {
+	f    *os.File
+	name string // still to be removed; empty once unlinked
+}
+
+func (s *spillFile) close() {
+	s.f.Close()
+	if s.name != "" {
+		os.Remove(s.name)
+	}
 }
 
 func (w *ResponseCaptureWriter) Header() http.Header {
@@ -20,10 +67,39 @@ func (w *ResponseCaptureWriter) Header() http.Header {
 }
 
 func (w *ResponseCaptureWriter) Write(data []byte) (int, error) {
+	if w.spill != nil {
+		n, err := w.spill.f.Write(data)
+		w.size += int64(n)
+		return n, err
+	}
 	w.Response = append(w.Response, data...)
+	if w.SpillThreshold > 0 && len(w.Response) > w.SpillThreshold {
+		w.spillToFile()
+	}
 	return len(data), nil
 }
 
+// spillToFile moves the body to a temporary file. When no file can be
+// made (a read-only or wasm host) the capture stays in memory.
+func (w *ResponseCaptureWriter) spillToFile() {
+	f, err := os.CreateTemp(w.SpillDir, "ai-capture-*")
+	if err != nil {
+		w.SpillThreshold = 0
+		return
+	}
+	s := &spillFile{f: f, name: f.Name()}
+	if os.Remove(s.name) == nil {
+		s.name = ""
+	}
+	if _, err := f.Write(w.Response); err != nil {
+		s.close()
+		w.SpillThreshold = 0
+		return
+	}
+	w.spill, w.size, w.Response = s, int64(len(w.Response)), nil
+	w.cleanup = runtime.AddCleanup(w, (*spillFile).close, s)
+}
+
 func (w *ResponseCaptureWriter) WriteHeader(statusCode int) {
 	w.StatusCode = statusCode
 }
@@ -31,8 +107,58 @@ func (w *ResponseCaptureWriter) WriteHeader(statusCode int) {
 // Flush implements http.Flusher — no-op for capture.
 func (w *ResponseCaptureWriter) Flush() {}
 
+// Len returns the size of the captured body.
+func (w *ResponseCaptureWriter) Len() int64 {
+	if w.spill != nil {
+		return w.size
+	}
+	return int64(len(w.Response))
+}
+
+// Spilled reports whether the body has moved to a temporary file.
+func (w *ResponseCaptureWriter) Spilled() bool { return w.spill != nil }
+
+// Reader returns a reader over the captured body, from its start. It is
+// valid until Close.
+func (w *ResponseCaptureWriter) Reader() io.Reader {
+	if w.spill != nil {
+		return io.NewSectionReader(w.spill.f, 0, w.size)
+	}
+	return bytes.NewReader(w.Response)
+}
+
+// WriteTo writes the captured body to dst.
+func (w *ResponseCaptureWriter) WriteTo(dst io.Writer) (int64, error) {
+	if w.spill == nil {
+		n, err := dst.Write(w.Response)
+		return int64(n), err
+	}
+	return io.Copy(dst, w.Reader())
+}
+
+// Bytes returns the whole captured body, reading it back into memory when
+// it has spilled.
+func (w *ResponseCaptureWriter) Bytes() ([]byte, error) {
+	if w.spill == nil {
+		return w.Response, nil
+	}
+	return io.ReadAll(w.Reader())
+}
+
+// Close removes the spill file, if any, dropping the body it holds.
+func (w *ResponseCaptureWriter) Close() error {
+	if w.spill == nil {
+		return nil
+	}
+	w.cleanup.Stop()
+	w.spill.close()
+	w.spill, w.size = nil, 0
+	return nil
+}
+
 // Compile-time interface checks.
 var (
 	_ http.ResponseWriter = (*ResponseCaptureWriter)(nil)
 	_ http.Flusher        = (*ResponseCaptureWriter)(nil)
+	_ io.WriterTo         = (*ResponseCaptureWriter)(nil)
 )
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResponseCapture_Spill(t *testing.T) {
+	dir := t.TempDir()
+	w := &ResponseCaptureWriter{SpillThreshold: 16, SpillDir: dir}
+	_, _ = w.Write([]byte("0123456789"))
+	if w.Spilled() || string(w.Response) != "0123456789" {
+		t.Fatalf("spilled below the threshold: %q", w.Response)
+	}
+
+	_, _ = w.Write([]byte("abcdefghij"))
+	_, _ = w.Write([]byte("KLMNO"))
+	const want = "0123456789abcdefghijKLMNO"
+	if !w.Spilled() || w.Response != nil || w.Len() != int64(len(want)) {
+		t.Fatalf("spilled = %v, in memory %q, len %d", w.Spilled(), w.Response, w.Len())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill file left in %s: %v", dir, entries)
+	}
+
+	got, err := w.Bytes()
+	if err != nil || string(got) != want {
+		t.Errorf("Bytes = %q, %v", got, err)
+	}
+	var out bytes.Buffer
+	if n, err := w.WriteTo(&out); err != nil || n != int64(len(want)) || out.String() != want {
+		t.Errorf("WriteTo = %d %q, %v", n, out.String(), err)
+	}
+	// Each reader starts over, whatever the last one read.
+	r1, _ := io.ReadAll(io.LimitReader(w.Reader(), 4))
+	r2, _ := io.ReadAll(w.Reader())
+	if string(r1) != "0123" || string(r2) != want {
+		t.Errorf("readers read %q and %q", r1, r2)
+	}
+
+	if err := w.Close(); err != nil || w.Spilled() || w.Len() != 0 {
+		t.Errorf("after Close: spilled = %v, len %d, err %v", w.Spilled(), w.Len(), err)
+	}
+}
+
+func TestResponseCapture_InMemory(t *testing.T) {
+	w := &ResponseCaptureWriter{}
+	body := strings.Repeat("x", DefaultCaptureSpillThreshold+1)
+	_, _ = w.Write([]byte(body))
+	if w.Spilled() || w.Len() != int64(len(body)) {
+		t.Fatalf("a capture without a threshold spilled, len %d", w.Len())
+	}
+	if err := w.Close(); err != nil || string(w.Response) != body {
+		t.Error("Close dropped an in-memory body")
+	}
+
+	// A spill dir that cannot be written keeps the body in memory.
+	w = &ResponseCaptureWriter{SpillThreshold: 4, SpillDir: "/nonexistent/capture-dir"}
+	_, _ = w.Write([]byte("0123456789"))
+	_, _ = w.Write([]byte("!"))
+	if got, _ := w.Bytes(); w.Spilled() || string(got) != "0123456789!" {
+		t.Errorf("unwritable spill dir: spilled = %v, body %q", w.Spilled(), got)
+	}
+}
@@ -12,4 +12,60 @@ type RouterService struct {
 	Auth   AuthService
 	Mu     sync.RWMutex
 	Logger *zap.Logger
+
+	// Scheduler is the optional router-wide fair admission queue.
+	// Nil admits every request immediately.
+	Scheduler *FairScheduler
+
+	// Cooldowns records upstream 429s per provider/model. Nil disables
+	// cooldown tracking.
+	Cooldowns *Cooldowns
+
+	// Affinity remembers the provider serving each conversation. Nil
+	// disables sticky routing.
+	Affinity *Affinity
+
+	// Hedging duplicates requests slow to their first token to a backup
+	// provider. Nil disables hedging.
+	Hedging *Hedging
+
+	// Shedder rejects requests while the process is overloaded. Nil
+	// disables load shedding.
+	Shedder *LoadShedder
+
+	// AccessLog receives one line per client request. Nil disables access
+	// logging.
+	AccessLog *zap.Logger
+
+	// Usage aggregates per-key token and request counts. Nil disables
+	// usage accounting.
+	Usage *UsageAccounting
+
+	// Webhooks notifies external systems of request and provider events.
+	// Nil disables webhooks.
+	Webhooks *Webhooks
+
+	// PluginTimings echoes each plugin's run time and failures to clients
+	// in the X-Plugin-Timings response header.
+	PluginTimings bool
+
+	// PluginReport shapes the X-Plugins-Executed response header. The zero
+	// value is the plain comma-separated list of plugins.
+	PluginReport PluginReportConfig
+
+	// Catalog holds model metadata: context windows, modalities, prices.
+	// Nil has only the built-in entries.
+	Catalog *Catalog
+}
+
+// PluginReportConfig configures the plugin execution report.
+type PluginReportConfig struct {
+	// Format is "" for the plain list, "structured" for a structured-field
+	// list with each plugin's params, run time and whether it modified
+	// the program, or "json" for the same as a JSON object.
+	Format string
+	// MaxBytes caps the header; plugins past it are dropped and counted.
+	MaxBytes int
+	// Debug lets a request ask for the full report in the response body.
+	Debug bool
 }
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by FairScheduler.Admit when the wait queue
+	// has reached its configured size.
+	ErrQueueFull = errors.New("admission queue full")
+
+	// ErrQueueTimeout is returned by FairScheduler.Admit when a request
+	// waited MaxWait without being admitted.
+	ErrQueueTimeout = errors.New("admission queue wait timed out")
+)
+
+// FairSchedulerConfig configures the router-level admission queue.
+type FairSchedulerConfig struct {
+	MaxInFlight int            `json:"max_in_flight,omitempty"`
+	MaxQueue    int            `json:"max_queue,omitempty"`
+	MaxWait     time.Duration  `json:"max_wait,omitempty"`
+	Weights     map[string]int `json:"weights,omitempty"` // tenant → weight (default 1)
+}
+
+// queueWaiter is a request parked in the admission queue.
+type queueWaiter struct {
+	tenant   string
+	priority Priority
+	tag      float64 // virtual finish time; lowest is admitted first within a class
+	ready    chan struct{}
+	err      error // set before ready is closed when the waiter is evicted
+}
+
+// FairScheduler is an admission queue that bounds the number of requests
+// in flight across the whole router and, when saturated, admits waiting
+// requests in weighted-fair order across tenants (API keys, users, …).
+//
+// It uses virtual finish-time tagging: each tenant's next request is tagged
+// max(now, lastTag) + 1/weight, so a tenant flooding the queue only pushes
+// its own requests further back while others keep their share.
+//
+// Priority classes sit above fairness: a waiting higher-class request is
+// always admitted before any lower-class one, and when the queue is full a
+// new request evicts the newest waiter of a lower class to make room.
+type FairScheduler struct {
+	cfg FairSchedulerConfig
+
+	mu       sync.Mutex
+	inFlight int
+	vtime    float64            // tag of the most recently admitted waiter
+	lastTag  map[string]float64 // tenant → last assigned finish tag
+	waiters  []*queueWaiter
+}
+
+// NewFairScheduler creates a scheduler. Returns nil when MaxInFlight <= 0;
+// a nil *FairScheduler admits everything immediately.
+func NewFairScheduler(cfg FairSchedulerConfig) *FairScheduler {
+	if cfg.MaxInFlight <= 0 {
+		return nil
+	}
+	return &FairScheduler{
+		cfg:     cfg,
+		lastTag: make(map[string]float64),
+	}
+}
+
+func (s *FairScheduler) weight(tenant string) float64 {
+	if w, ok := s.cfg.Weights[tenant]; ok && w > 0 {
+		return float64(w)
+	}
+	return 1
+}
+
+// Admit blocks until the request may proceed. The returned release func
+// must be called exactly once when the request completes.
+func (s *FairScheduler) Admit(ctx context.Context, tenant string, priority Priority) (release func(), err error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.inFlight < s.cfg.MaxInFlight && len(s.waiters) == 0 {
+		s.inFlight++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	if s.cfg.MaxQueue > 0 && len(s.waiters) >= s.cfg.MaxQueue && !s.evictLocked(priority) {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	start := s.lastTag[tenant]
+	if start < s.vtime {
+		start = s.vtime
+	}
+	qw := &queueWaiter{
+		tenant:   tenant,
+		priority: priority,
+		tag:      start + 1/s.weight(tenant),
+		ready:    make(chan struct{}),
+	}
+	s.lastTag[tenant] = qw.tag
+	s.waiters = append(s.waiters, qw)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.cfg.MaxWait > 0 {
+		timer := time.NewTimer(s.cfg.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-qw.ready:
+		if qw.err != nil {
+			return nil, qw.err
+		}
+		return s.release, nil
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// Abandoned: remove from the queue unless we were admitted concurrently.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.waiters {
+		if w == qw {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return nil, err
+		}
+	}
+	// Evicted concurrently: it never held a slot.
+	if qw.err != nil {
+		return nil, qw.err
+	}
+	// Already admitted by dispatch — hand the slot back.
+	s.inFlight--
+	s.dispatchLocked()
+	return nil, err
+}
+
+// release frees a slot and admits the next waiter, if any.
+func (s *FairScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.dispatchLocked()
+}
+
+// evictLocked drops the newest waiter of the lowest class below priority,
+// failing it with ErrQueueFull. Reports whether room was made. Caller must
+// hold s.mu.
+func (s *FairScheduler) evictLocked(priority Priority) bool {
+	victim := -1
+	for i, w := range s.waiters {
+		if w.priority >= priority {
+			continue
+		}
+		if victim < 0 || w.priority < s.waiters[victim].priority ||
+			(w.priority == s.waiters[victim].priority && w.tag > s.waiters[victim].tag) {
+			victim = i
+		}
+	}
+	if victim < 0 {
+		return false
+	}
+	w := s.waiters[victim]
+	s.waiters = append(s.waiters[:victim], s.waiters[victim+1:]...)
+	w.err = ErrQueueFull
+	close(w.ready)
+	return true
+}
+
+// dispatchLocked admits waiters of the highest class with the lowest finish
+// tags while capacity allows. Caller must hold s.mu.
+func (s *FairScheduler) dispatchLocked() {
+	for s.inFlight < s.cfg.MaxInFlight && len(s.waiters) > 0 {
+		best := 0
+		for i, w := range s.waiters {
+			b := s.waiters[best]
+			if w.priority > b.priority || (w.priority == b.priority && w.tag < b.tag) {
+				best = i
+			}
+		}
+		w := s.waiters[best]
+		s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+		s.vtime = w.tag
+		s.inFlight++
+		close(w.ready)
+	}
+	if len(s.waiters) == 0 && s.inFlight == 0 {
+		// Idle: reset virtual time so tags don't grow without bound.
+		s.vtime = 0
+		for k := range s.lastTag {
+			delete(s.lastTag, k)
+		}
+	}
+}
+
+// QueueLength returns the number of waiting requests.
+func (s *FairScheduler) QueueLength() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters)
+}
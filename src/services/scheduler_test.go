@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFairScheduler_NilAdmits(t *testing.T) {
+	var s *FairScheduler
+	release, err := s.Admit(context.Background(), "t", PriorityNormal)
+	if err != nil {
+		t.Fatalf("nil scheduler should admit, got %v", err)
+	}
+	release()
+}
+
+func TestFairScheduler_QueueFull(t *testing.T) {
+	s := NewFairScheduler(FairSchedulerConfig{MaxInFlight: 1, MaxQueue: 1})
+	release, _ := s.Admit(context.Background(), "a", PriorityNormal)
+	defer release()
+
+	// Park one waiter so the queue is full.
+	go func() {
+		if r, err := s.Admit(context.Background(), "a", PriorityNormal); err == nil {
+			r()
+		}
+	}()
+	for s.QueueLength() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := s.Admit(context.Background(), "b", PriorityNormal); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestFairScheduler_InterleavesTenants(t *testing.T) {
+	s := NewFairScheduler(FairSchedulerConfig{MaxInFlight: 1})
+	hold, _ := s.Admit(context.Background(), "noisy", PriorityNormal)
+
+	order := make(chan string, 4)
+	enqueue := func(tenant string) {
+		go func() {
+			r, err := s.Admit(context.Background(), tenant, PriorityNormal)
+			if err != nil {
+				return
+			}
+			order <- tenant
+			r()
+		}()
+		// Wait for the waiter to be parked so tags are assigned in order.
+		for n := s.QueueLength(); s.QueueLength() == n; {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	enqueue("noisy")
+	enqueue("noisy")
+	enqueue("noisy")
+	enqueue("quiet")
+	hold()
+
+	got := []string{<-order, <-order}
+	if got[0] != "noisy" || got[1] != "quiet" {
+		t.Errorf("expected quiet tenant to be admitted second, got %v", got)
+	}
+	<-order
+	<-order
+}
+
+func TestFairScheduler_PriorityFirstAndEviction(t *testing.T) {
+	s := NewFairScheduler(FairSchedulerConfig{MaxInFlight: 1, MaxQueue: 1})
+	hold, _ := s.Admit(context.Background(), "x", PriorityNormal)
+
+	lowErr := make(chan error, 1)
+	go func() {
+		_, err := s.Admit(context.Background(), "low", PriorityLow)
+		lowErr <- err
+	}()
+	for s.QueueLength() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Queue is full: a high-priority arrival evicts the low waiter.
+	highDone := make(chan struct{})
+	go func() {
+		r, err := s.Admit(context.Background(), "high", PriorityHigh)
+		if err == nil {
+			r()
+		}
+		close(highDone)
+	}()
+	if err := <-lowErr; !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected low waiter evicted with ErrQueueFull, got %v", err)
+	}
+
+	// An equal-or-lower arrival cannot evict the high waiter.
+	if _, err := s.Admit(context.Background(), "low2", PriorityLow); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected low arrival rejected, got %v", err)
+	}
+	hold()
+	<-highDone
+}
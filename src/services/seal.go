@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// sealMagic starts every object sealed by Seal, so readers can tell sealed
+// samples from plain ones.
+const sealMagic = "AILSEAL1"
+
+// ParseSealKey parses a 32-byte AES-256 key given as 64 hex digits or in
+// standard base64.
+func ParseSealKey(s string) ([]byte, error) {
+	decode := base64.StdEncoding.DecodeString
+	if len(s) == 64 {
+		decode = hex.DecodeString
+	}
+	key, err := decode(s)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key must be 32 bytes, as 64 hex digits or base64")
+	}
+	return key, nil
+}
+
+// Seal encrypts data with AES-256-GCM: the result is sealMagic, a random
+// nonce and the ciphertext.
+func Seal(key, data []byte) ([]byte, error) {
+	aead, err := sealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(sealMagic)+aead.NonceSize(), len(sealMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, sealMagic)
+	nonce := out[len(sealMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, []byte(sealMagic)), nil
+}
+
+// IsSealed reports whether data was sealed by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealMagic))
+}
+
+// Unseal decrypts data sealed by Seal. Data that is not sealed is
+// returned as is, so corpora may mix sealed and plain samples.
+func Unseal(key, data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, errors.New("sealed sample: no key")
+	}
+	aead, err := sealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[len(sealMagic):]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("sealed sample: truncated")
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(sealMagic))
+	if err != nil {
+		return nil, fmt.Errorf("sealed sample: %w", err)
+	}
+	return plain, nil
+}
+
+func sealAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
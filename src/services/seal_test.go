@@ -0,0 +1,40 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	key, err := ParseSealKey(strings.Repeat("0f", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b64, err := ParseSealKey(base64.StdEncoding.EncodeToString(key)); err != nil || !bytes.Equal(b64, key) {
+		t.Errorf("base64 key = %x, %v", b64, err)
+	}
+	for _, bad := range []string{"", "0f0f", strings.Repeat("zz", 32)} {
+		if _, err := ParseSealKey(bad); err == nil {
+			t.Errorf("key %q accepted", bad)
+		}
+	}
+
+	sealed, err := Seal(key, []byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("request")) {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	if plain, err := Unseal(key, sealed); err != nil || string(plain) != "request" {
+		t.Errorf("Unseal = %q, %v", plain, err)
+	}
+	if _, err := Unseal(bytes.Repeat([]byte{1}, 32), sealed); err == nil {
+		t.Error("unsealed with the wrong key")
+	}
+	if plain, err := Unseal(nil, []byte("plain")); err != nil || string(plain) != "plain" {
+		t.Errorf("plain data: %q, %v", plain, err)
+	}
+}
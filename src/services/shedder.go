@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned by LoadShedder.Enter when the process is over
+// one of its configured thresholds.
+var ErrOverloaded = errors.New("server overloaded")
+
+// loadSampleInterval is how often the shedder samples heap usage.
+const loadSampleInterval = 500 * time.Millisecond
+
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// LoadShedderConfig holds the overload thresholds. Zero disables a check.
+type LoadShedderConfig struct {
+	MaxGoroutines int
+	MaxInFlight   int
+	MaxHeapBytes  uint64
+	// RetryAfter is advertised to shed clients.
+	RetryAfter time.Duration
+}
+
+// LoadShedder rejects new requests while the process is overloaded, before
+// queueing or provider work starts, so it degrades by refusing work rather
+// than by slowing everything down.
+//
+// Thresholds apply as configured to normal-priority traffic. Low-priority
+// traffic is shed earlier, at 80% of each threshold, and high-priority
+// traffic is admitted up to 120%, so lower classes are always shed first.
+type LoadShedder struct {
+	cfg      LoadShedderConfig
+	inFlight atomic.Int64
+	heap     atomic.Uint64
+}
+
+// NewLoadShedder creates a shedder. Returns nil when no threshold is set; a
+// nil *LoadShedder admits everything.
+func NewLoadShedder(cfg LoadShedderConfig) *LoadShedder {
+	if cfg.MaxGoroutines <= 0 && cfg.MaxInFlight <= 0 && cfg.MaxHeapBytes == 0 {
+		return nil
+	}
+	return &LoadShedder{cfg: cfg}
+}
+
+// Start samples heap usage in the background until ctx is cancelled.
+// Goroutine and in-flight counts are cheap and read on every request.
+func (s *LoadShedder) Start(ctx context.Context) {
+	if s == nil || s.cfg.MaxHeapBytes == 0 {
+		return
+	}
+	s.sampleHeap()
+	go func() {
+		ticker := time.NewTicker(loadSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.sampleHeap()
+			}
+		}
+	}()
+}
+
+func (s *LoadShedder) sampleHeap() {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		s.heap.Store(sample[0].Value.Uint64())
+	}
+}
+
+// RetryAfter returns the back-off advertised to shed clients.
+func (s *LoadShedder) RetryAfter() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.cfg.RetryAfter
+}
+
+// shedScale is the fraction of each threshold at which a class is shed.
+func shedScale(p Priority) float64 {
+	switch {
+	case p <= PriorityLow:
+		return 0.8
+	case p >= PriorityHigh:
+		return 1.2
+	}
+	return 1
+}
+
+// Overloaded reports whether any threshold, scaled for priority, is
+// exceeded, with the reason.
+func (s *LoadShedder) Overloaded(priority Priority) (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+	scale := shedScale(priority)
+	if limit := int64(float64(s.cfg.MaxInFlight) * scale); s.cfg.MaxInFlight > 0 && s.inFlight.Load() >= limit {
+		return true, fmt.Sprintf("in_flight>=%d", limit)
+	}
+	if limit := int(float64(s.cfg.MaxGoroutines) * scale); s.cfg.MaxGoroutines > 0 && runtime.NumGoroutine() >= limit {
+		return true, fmt.Sprintf("goroutines>=%d", limit)
+	}
+	if limit := uint64(float64(s.cfg.MaxHeapBytes) * scale); s.cfg.MaxHeapBytes > 0 && s.heap.Load() >= limit {
+		return true, fmt.Sprintf("heap>=%d", limit)
+	}
+	return false, ""
+}
+
+// Enter admits a request unless the process is overloaded for its class.
+// The returned release func must be called when the request finishes.
+func (s *LoadShedder) Enter(priority Priority) (release func(), err error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	if over, reason := s.Overloaded(priority); over {
+		return nil, fmt.Errorf("%w: %s", ErrOverloaded, reason)
+	}
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }, nil
+}
+
+// InFlight returns the number of admitted requests still running.
+func (s *LoadShedder) InFlight() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.inFlight.Load()
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder_InFlight(t *testing.T) {
+	s := NewLoadShedder(LoadShedderConfig{MaxInFlight: 2, RetryAfter: time.Second})
+	r1, err := s.Enter(PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Enter(PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Enter(PriorityNormal); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded at the in-flight cap, got %v", err)
+	}
+	r1()
+	if _, err := s.Enter(PriorityNormal); err != nil {
+		t.Errorf("released slot should admit again, got %v", err)
+	}
+}
+
+func TestLoadShedder_Goroutines(t *testing.T) {
+	s := NewLoadShedder(LoadShedderConfig{MaxGoroutines: 1})
+	if over, reason := s.Overloaded(PriorityNormal); !over || reason == "" {
+		t.Errorf("test process runs more than one goroutine, expected overload")
+	}
+}
+
+func TestLoadShedder_NilAdmits(t *testing.T) {
+	if NewLoadShedder(LoadShedderConfig{RetryAfter: time.Second}) != nil {
+		t.Error("expected nil shedder without thresholds")
+	}
+	var s *LoadShedder
+	release, err := s.Enter(PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestLoadShedder_ShedsLowerClassesFirst(t *testing.T) {
+	s := NewLoadShedder(LoadShedderConfig{MaxInFlight: 10})
+	for i := 0; i < 8; i++ {
+		if _, err := s.Enter(PriorityHigh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Enter(PriorityLow); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("low priority should be shed at 80%% load, got %v", err)
+	}
+	if _, err := s.Enter(PriorityNormal); err != nil {
+		t.Errorf("normal priority should still be admitted at 80%%, got %v", err)
+	}
+	_, _ = s.Enter(PriorityNormal)
+	if _, err := s.Enter(PriorityNormal); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("normal priority should be shed at 100%%, got %v", err)
+	}
+	if _, err := s.Enter(PriorityHigh); err != nil {
+		t.Errorf("high priority should be admitted up to 120%%, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrFirstByteTimeout is the cancellation cause when a provider sends
+	// nothing (no response headers, or no first stream event) in time.
+	ErrFirstByteTimeout = errors.New("provider first-byte timeout")
+
+	// ErrTotalTimeout is the cancellation cause when a provider request,
+	// including the whole streamed body, runs past its total budget.
+	ErrTotalTimeout = errors.New("provider total timeout")
+
+	// ErrRequestDeadline is the cancellation cause when a request runs past
+	// the deadline its client set.
+	ErrRequestDeadline = errors.New("request deadline exceeded")
+)
+
+const (
+	// DefaultConnectTimeout bounds dialing and the TLS handshake when a
+	// provider sets no connect timeout.
+	DefaultConnectTimeout = 10 * time.Second
+
+	defaultAdaptiveFactor = 3
+	defaultAdaptiveMin    = time.Second
+
+	// adaptivePercentile is the observed first-byte percentile the adaptive
+	// timeout scales from.
+	adaptivePercentile = 0.95
+)
+
+// Timeouts bounds how long a single upstream request may take. Zero
+// disables a bound.
+type Timeouts struct {
+	Connect   time.Duration
+	FirstByte time.Duration
+	Total     time.Duration
+
+	// Adaptive derives the first-byte timeout from the provider's observed
+	// p95 time to first byte × AdaptiveFactor, floored at AdaptiveMin and
+	// capped by FirstByte when set. Until enough samples exist the static
+	// FirstByte applies.
+	Adaptive       bool
+	AdaptiveFactor float64
+	AdaptiveMin    time.Duration
+}
+
+// FirstByteFor returns the first-byte timeout to apply given the provider's
+// latency history.
+func (t *Timeouts) FirstByteFor(lat *LatencyTracker) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if !t.Adaptive {
+		return t.FirstByte
+	}
+	p, ok := lat.Percentile(adaptivePercentile)
+	if !ok {
+		return t.FirstByte
+	}
+	factor, floor := t.AdaptiveFactor, t.AdaptiveMin
+	if factor <= 0 {
+		factor = defaultAdaptiveFactor
+	}
+	if floor <= 0 {
+		floor = defaultAdaptiveMin
+	}
+	d := max(time.Duration(float64(p)*factor), floor)
+	if t.FirstByte > 0 {
+		d = min(d, t.FirstByte)
+	}
+	return d
+}
+
+// TotalTimeout returns the total request budget, or 0 for none.
+func (t *Timeouts) TotalTimeout() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.Total
+}
+
+// RequestTimeoutHeader carries a client's end-to-end budget for a request,
+// and the budget left when the router calls out on its behalf (sidecars).
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// ParseRequestTimeout reads a client timeout: seconds ("30", "2.5") or a
+// Go duration ("1m30s"). It must be positive.
+func ParseRequestTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return 0, fmt.Errorf("invalid timeout %q: want seconds or a duration", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be positive", s)
+	}
+	return d, nil
+}
+
+// WithRequestDeadline bounds ctx by a client's timeout, with
+// ErrRequestDeadline as the cause once it passes.
+func WithRequestDeadline(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithDeadlineCause(ctx, time.Now().Add(d), ErrRequestDeadline)
+}
+
+// DeadlineExceeded reports whether ctx ended on its client's deadline.
+func DeadlineExceeded(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrRequestDeadline)
+}
+
+// RemainingTimeout formats the time left before ctx's deadline for
+// RequestTimeoutHeader, rounded up to the millisecond; ok is false when
+// ctx has no deadline.
+func RemainingTimeout(ctx context.Context) (string, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return "", false
+	}
+	left := max(time.Until(deadline), time.Millisecond)
+	return strconv.FormatFloat(math.Ceil(left.Seconds()*1000)/1000, 'f', -1, 64), true
+}
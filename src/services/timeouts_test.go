@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeouts_FirstByteFor(t *testing.T) {
+	var none *Timeouts
+	if d := none.FirstByteFor(nil); d != 0 {
+		t.Errorf("nil timeouts should not bound requests, got %v", d)
+	}
+
+	static := &Timeouts{FirstByte: 30 * time.Second}
+	if d := static.FirstByteFor(&LatencyTracker{}); d != 30*time.Second {
+		t.Errorf("expected static first-byte timeout, got %v", d)
+	}
+
+	lat := &LatencyTracker{}
+	adaptive := &Timeouts{FirstByte: 30 * time.Second, Adaptive: true, AdaptiveFactor: 2}
+	for i := 0; i < latencyMinSamples-1; i++ {
+		lat.Observe(2 * time.Second)
+	}
+	if d := adaptive.FirstByteFor(lat); d != 30*time.Second {
+		t.Errorf("too few samples should fall back to the static timeout, got %v", d)
+	}
+	lat.Observe(2 * time.Second)
+	if d := adaptive.FirstByteFor(lat); d != 4*time.Second {
+		t.Errorf("expected p95 × factor = 4s, got %v", d)
+	}
+
+	// Failures penalize the average but must not inflate the percentile.
+	lat.ObserveFailure()
+	if d := adaptive.FirstByteFor(lat); d != 4*time.Second {
+		t.Errorf("failures should not move the adaptive timeout, got %v", d)
+	}
+
+	for i := 0; i < latencyWindow; i++ {
+		lat.Observe(time.Millisecond)
+	}
+	if d := adaptive.FirstByteFor(lat); d != defaultAdaptiveMin {
+		t.Errorf("expected the adaptive floor, got %v", d)
+	}
+	for i := 0; i < latencyWindow; i++ {
+		lat.Observe(time.Minute)
+	}
+	if d := adaptive.FirstByteFor(lat); d != 30*time.Second {
+		t.Errorf("expected first_byte to cap the adaptive timeout, got %v", d)
+	}
+}
+
+func TestParseRequestTimeout(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"30":    30 * time.Second,
+		"2.5":   2500 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+		" 10 ":  10 * time.Second,
+	} {
+		if d, err := ParseRequestTimeout(in); err != nil || d != want {
+			t.Errorf("%q: got %v, %v; want %v", in, d, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "-5", "soon", "NaN", "Inf", "-1s"} {
+		if _, err := ParseRequestTimeout(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	if _, ok := RemainingTimeout(context.Background()); ok {
+		t.Error("a context without deadline should have no remaining timeout")
+	}
+
+	ctx, cancel := WithRequestDeadline(context.Background(), 1500*time.Millisecond)
+	left, ok := RemainingTimeout(ctx)
+	cancel()
+	if !ok || (left != "1.5" && left != "1.499") {
+		t.Errorf("remaining timeout = %q, %v", left, ok)
+	}
+	if DeadlineExceeded(ctx) || !ClientGone(ctx, nil) {
+		t.Error("cancelling the request is a client disconnect, not its deadline")
+	}
+
+	ctx, cancel = WithRequestDeadline(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if !DeadlineExceeded(ctx) {
+		t.Error("expected the deadline to be exceeded")
+	}
+	if ClientGone(ctx, nil) {
+		t.Error("a passed deadline is not a client disconnect")
+	}
+	if left, _ := RemainingTimeout(ctx); left != "0.001" {
+		t.Errorf("remaining timeout past the deadline = %q", left)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+	tiktoken "github.com/pkoukk/tiktoken-go"
+)
+
+// Token counting uses the tiktoken BPE encoding of the model: o200k_base or
+// cl100k_base for OpenAI models and cl100k_base as an approximation for
+// everything else. Encodings load in the background on first use (from
+// TIKTOKEN_CACHE_DIR, else downloaded once); until one is ready, and when
+// it cannot be loaded, counts fall back to ~4 characters per token.
+
+const (
+	encodingO200K  = "o200k_base"
+	encodingCL100K = "cl100k_base"
+
+	// Chat framing overhead as documented for OpenAI chat models.
+	tokensPerMessage   = 3
+	tokensReplyPriming = 3
+
+	charsPerToken        = 4
+	encoderRetryInterval = time.Minute
+)
+
+// o200kPrefixes lists model families on o200k_base that tiktoken-go does
+// not map yet.
+var o200kPrefixes = []string{"gpt-5", "o1", "o3", "o4", "chatgpt-4o"}
+
+type encoderState struct {
+	mu       sync.Mutex
+	enc      *tiktoken.Tiktoken
+	loading  bool
+	failedAt time.Time
+}
+
+var encoders sync.Map // encoding name → *encoderState
+
+// EncodingForModel returns the tiktoken encoding name used to count model's
+// tokens. Provider prefixes and plugin suffixes are ignored.
+func EncodingForModel(model string) string {
+	model = tokenizerModel(model)
+	if name, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return name
+	}
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return name
+		}
+	}
+	for _, prefix := range o200kPrefixes {
+		if model == prefix || strings.HasPrefix(model, prefix+"-") {
+			return encodingO200K
+		}
+	}
+	return encodingCL100K
+}
+
+// tokenizerModel strips the provider prefix ("openai/gpt-4o") and plugin
+// suffixes ("gpt-4o+slwin") from a model name.
+func tokenizerModel(model string) string {
+	if i := strings.LastIndexByte(model, '/'); i >= 0 {
+		model = model[i+1:]
+	}
+	if i := strings.IndexByte(model, '+'); i >= 0 {
+		model = model[:i]
+	}
+	return model
+}
+
+// encoder returns the loaded encoding, or nil while it is loading or
+// unavailable. The first call starts a background load.
+func encoder(name string) *tiktoken.Tiktoken {
+	v, _ := encoders.LoadOrStore(name, &encoderState{})
+	st := v.(*encoderState)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.enc != nil || st.loading || time.Since(st.failedAt) < encoderRetryInterval {
+		return st.enc
+	}
+	st.loading = true
+	go func() {
+		enc, err := tiktoken.GetEncoding(name)
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.loading = false
+		if err != nil {
+			st.failedAt = time.Now()
+			return
+		}
+		st.enc = enc
+	}()
+	return nil
+}
+
+// TokenizerReady reports whether model's encoding is loaded, i.e. whether
+// counts for it are exact rather than estimated.
+func TokenizerReady(model string) bool {
+	return encoder(EncodingForModel(model)) != nil
+}
+
+// CountText returns the number of tokens in text for model.
+func CountText(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	if enc := encoder(EncodingForModel(model)); enc != nil {
+		return len(enc.EncodeOrdinary(text))
+	}
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// CountTokens returns the prompt tokens of prog for model: message content,
+// reasoning, tool calls and results, tool definitions and chat framing.
+func CountTokens(model string, prog *ail.Program) int {
+	text, messages := promptText(prog, 0, len(prog.Code)-1)
+	n := CountText(model, text) + messages*tokensPerMessage
+	if messages > 0 {
+		n += tokensReplyPriming
+	}
+	return n
+}
+
+// CountMessageTokens returns the tokens of one message, framing included.
+func CountMessageTokens(model string, prog *ail.Program, msg ail.MessageSpan) int {
+	text, _ := promptText(prog, msg.Start, msg.End)
+	return CountText(model, text) + tokensPerMessage
+}
+
+// promptText concatenates the model-visible text of prog.Code[from..to]
+// and counts the messages started in that range.
+func promptText(prog *ail.Program, from, to int) (string, int) {
+	var sb strings.Builder
+	messages := 0
+	for i := max(from, 0); i <= to && i < len(prog.Code); i++ {
+		inst := prog.Code[i]
+		switch inst.Op {
+		case ail.MSG_START:
+			messages++
+		case ail.TXT_CHUNK, ail.THINK_CHUNK, ail.RESULT_DATA, ail.CALL_NAME, ail.DEF_NAME, ail.DEF_DESC:
+			sb.WriteString(inst.Str)
+			sb.WriteByte('\n')
+		case ail.CALL_ARGS, ail.DEF_SCHEMA:
+			sb.Write(inst.JSON)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), messages
+}
+
+// CountCompletionTokens returns the tokens generated in a response program
+// or assembled stream: text, reasoning and tool calls. Used when the
+// upstream reports no usage.
+func CountCompletionTokens(model string, prog *ail.Program) int {
+	var sb strings.Builder
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.TXT_CHUNK, ail.THINK_CHUNK, ail.CALL_NAME, ail.STREAM_DELTA, ail.STREAM_THINK_DELTA:
+			sb.WriteString(inst.Str)
+		case ail.CALL_ARGS:
+			sb.Write(inst.JSON)
+		case ail.STREAM_TOOL_DELTA:
+			var td struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			}
+			if json.Unmarshal(inst.JSON, &td) == nil {
+				sb.WriteString(td.Name)
+				sb.WriteString(td.Arguments)
+			}
+		}
+	}
+	return CountText(model, sb.String())
+}
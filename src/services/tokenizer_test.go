@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	cases := map[string]string{
+		"gpt-4o":                 encodingO200K,
+		"openai/gpt-4o-mini":     encodingO200K,
+		"gpt-5-mini+slwin":       encodingO200K,
+		"o3":                     encodingO200K,
+		"gpt-4-0613":             encodingCL100K,
+		"gpt-3.5-turbo":          encodingCL100K,
+		"claude-sonnet-4":        encodingCL100K,
+		"omni-model-without-map": encodingCL100K,
+	}
+	for model, want := range cases {
+		if got := EncodingForModel(model); got != want {
+			t.Errorf("%s: got %s, want %s", model, got, want)
+		}
+	}
+}
+
+// forceHeuristic marks encoding as failed so counts use the character
+// heuristic regardless of network access.
+func forceHeuristic(t *testing.T, encoding string) {
+	t.Helper()
+	encoders.Store(encoding, &encoderState{failedAt: time.Now()})
+	t.Cleanup(func() { encoders.Delete(encoding) })
+}
+
+func TestCountTokens_Heuristic(t *testing.T) {
+	forceHeuristic(t, encodingCL100K)
+
+	if got := CountText("some-model", "12345678"); got != 2 {
+		t.Errorf("CountText = %d, want 2", got)
+	}
+	if TokenizerReady("some-model") {
+		t.Error("expected the tokenizer to report not ready")
+	}
+
+	prog := ail.NewProgram()
+	prog.EmitString(ail.SET_MODEL, "some-model")
+	for _, text := range []string{"aaaaaaa", "bbbbbbb"} {
+		prog.Emit(ail.MSG_START)
+		prog.Emit(ail.ROLE_USR)
+		prog.EmitString(ail.TXT_CHUNK, text)
+		prog.Emit(ail.MSG_END)
+	}
+	// "aaaaaaa\nbbbbbbb\n" is 16 chars → 4 tokens, plus framing.
+	if got, want := CountTokens("some-model", prog), 4+2*tokensPerMessage+tokensReplyPriming; got != want {
+		t.Errorf("CountTokens = %d, want %d", got, want)
+	}
+	msg := prog.Messages()[0]
+	if got, want := CountMessageTokens("some-model", prog, msg), 2+tokensPerMessage; got != want {
+		t.Errorf("CountMessageTokens = %d, want %d", got, want)
+	}
+}
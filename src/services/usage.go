@@ -0,0 +1,46 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Usage is the token usage an upstream reported for one response.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// CachedTokens is the part of PromptTokens served from the provider's
+	// prompt cache, when reported.
+	CachedTokens int
+}
+
+// UsageFromProgram sums the USAGE instructions of a response program or
+// stream chunk. Reports false when the program carries none.
+func UsageFromProgram(prog *ail.Program) (Usage, bool) {
+	var total Usage
+	found := false
+	if prog == nil {
+		return total, false
+	}
+	for _, inst := range prog.Code {
+		if inst.Op != ail.USAGE {
+			continue
+		}
+		var u struct {
+			PromptTokens        int `json:"prompt_tokens"`
+			CompletionTokens    int `json:"completion_tokens"`
+			PromptTokensDetails struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"prompt_tokens_details"`
+		}
+		if json.Unmarshal(inst.JSON, &u) != nil {
+			continue
+		}
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.CachedTokens += u.PromptTokensDetails.CachedTokens
+		found = true
+	}
+	return total, found
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Webhook event types.
+const (
+	WebhookRequestCompleted = "request.completed"
+	WebhookRequestFailed    = "request.failed"
+	// WebhookBudgetExceeded fires when every candidate provider was out of
+	// rate-limit budget (or cooling down) and the client got a 429.
+	WebhookBudgetExceeded = "budget.exceeded"
+	// WebhookCircuitOpened fires when a provider is taken out of rotation:
+	// its health check failed, or an upstream 429 put a model on cooldown.
+	WebhookCircuitOpened = "provider.circuit_opened"
+	// WebhookModelDeprecated fires when a request names a deprecated
+	// model, whether or not it was redirected to the replacement.
+	WebhookModelDeprecated = "model.deprecated"
+)
+
+// WebhookEvents lists every event type, in documentation order.
+var WebhookEvents = []string{WebhookRequestCompleted, WebhookRequestFailed, WebhookBudgetExceeded, WebhookCircuitOpened, WebhookModelDeprecated}
+
+// Webhook delivery headers. The signature is Stripe-style,
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">", so receivers can
+// reject stale replays.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIDHeader        = "X-Webhook-Id"
+)
+
+// WebhookEndpoint is a URL events are POSTed to.
+type WebhookEndpoint struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // empty means every event
+}
+
+// WebhookEvent is the JSON body of a delivery. ID stays the same across
+// retries so receivers can deduplicate.
+type WebhookEvent struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Created int64          `json:"created"`
+	Router  string         `json:"router"`
+	Data    map[string]any `json:"data"`
+}
+
+type webhookDelivery struct {
+	url     string
+	event   string
+	id      string
+	body    []byte
+	attempt int
+}
+
+// Webhooks POSTs signed events to the configured endpoints. Delivery is
+// asynchronous: Emit never blocks the request path, and failed deliveries
+// (network errors, 408, 429, 5xx) are retried with exponential backoff.
+// Events emitted while the queue is full are dropped and logged.
+type Webhooks struct {
+	Router      string
+	Endpoints   []WebhookEndpoint
+	Secret      string        // HMAC key; deliveries are unsigned without one
+	MaxAttempts int           // including the first
+	Backoff     time.Duration // delay before the first retry, doubled after each
+	Client      *http.Client
+	Logger      *zap.Logger
+
+	queue chan webhookDelivery
+}
+
+const (
+	webhookQueueSize = 1024
+	webhookWorkers   = 4
+)
+
+// NewWebhooks creates an emitter for router; call Start before emitting.
+func NewWebhooks(router string, endpoints []WebhookEndpoint, secret string, maxAttempts int, backoff, timeout time.Duration, logger *zap.Logger) *Webhooks {
+	return &Webhooks{
+		Router:      router,
+		Endpoints:   endpoints,
+		Secret:      secret,
+		MaxAttempts: max(maxAttempts, 1),
+		Backoff:     backoff,
+		Client:      &http.Client{Timeout: timeout},
+		Logger:      logger,
+		queue:       make(chan webhookDelivery, webhookQueueSize),
+	}
+}
+
+// Start runs the delivery workers until ctx is cancelled; pending and
+// scheduled retries are dropped then.
+func (wh *Webhooks) Start(ctx context.Context) {
+	if wh == nil {
+		return
+	}
+	for range webhookWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-wh.queue:
+					wh.deliver(ctx, d)
+				}
+			}
+		}()
+	}
+}
+
+// Emit queues event for every endpoint subscribed to it. A nil emitter
+// ignores the call.
+func (wh *Webhooks) Emit(event string, data map[string]any) {
+	if wh == nil {
+		return
+	}
+	ev := WebhookEvent{
+		ID:      "evt_" + uuid.NewString(),
+		Type:    event,
+		Created: time.Now().Unix(),
+		Router:  wh.Router,
+		Data:    data,
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		wh.Logger.Error("webhook encode failed", zap.String("event", event), zap.Error(err))
+		return
+	}
+	for _, ep := range wh.Endpoints {
+		if len(ep.Events) > 0 && !slices.Contains(ep.Events, event) {
+			continue
+		}
+		wh.enqueue(webhookDelivery{url: ep.URL, event: event, id: ev.ID, body: body, attempt: 1})
+	}
+}
+
+func (wh *Webhooks) enqueue(d webhookDelivery) {
+	select {
+	case wh.queue <- d:
+	default:
+		wh.Logger.Warn("webhook queue full, dropping event",
+			zap.String("url", d.url), zap.String("event", d.event), zap.String("id", d.id))
+	}
+}
+
+func (wh *Webhooks) deliver(ctx context.Context, d webhookDelivery) {
+	retry, err := wh.post(ctx, d)
+	if err == nil {
+		return
+	}
+	if !retry || d.attempt >= wh.MaxAttempts || ctx.Err() != nil {
+		wh.Logger.Error("webhook delivery failed",
+			zap.String("url", d.url), zap.String("event", d.event), zap.String("id", d.id),
+			zap.Int("attempts", d.attempt), zap.Error(err))
+		return
+	}
+	delay := wh.Backoff << (d.attempt - 1)
+	wh.Logger.Debug("webhook delivery failed, retrying",
+		zap.String("url", d.url), zap.String("id", d.id),
+		zap.Int("attempt", d.attempt), zap.Duration("delay", delay), zap.Error(err))
+	d.attempt++
+	time.AfterFunc(delay, func() {
+		if ctx.Err() == nil {
+			wh.enqueue(d)
+		}
+	})
+}
+
+// post sends one delivery attempt, reporting whether a failure is worth
+// retrying.
+func (wh *Webhooks) post(ctx context.Context, d webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, d.event)
+	req.Header.Set(WebhookIDHeader, d.id)
+	if wh.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(wh.Secret, time.Now().Unix(), d.body))
+	}
+	res, err := wh.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = res.StatusCode == http.StatusRequestTimeout ||
+		res.StatusCode == http.StatusTooManyRequests ||
+		res.StatusCode >= 500
+	return retry, fmt.Errorf("endpoint returned %s", res.Status)
+}
+
+// SignWebhook returns the signature header value for body sent at ts.
+func SignWebhook(secret string, ts int64, body []byte) string {
+	t := strconv.FormatInt(ts, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWebhooks_SignsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	delivered := make(chan WebhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get(WebhookSignatureHeader)
+		ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+		n, _ := strconv.ParseInt(ts, 10, 64)
+		if sig != SignWebhook("s3cret", n, body) {
+			t.Errorf("bad signature %q", sig)
+		}
+		mu.Lock()
+		ids = append(ids, r.Header.Get(WebhookIDHeader))
+		first := len(ids) == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Error(err)
+		}
+		delivered <- ev
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wh := NewWebhooks("r", []WebhookEndpoint{
+		{URL: srv.URL, Events: []string{WebhookBudgetExceeded}},
+		{URL: srv.URL + "/other", Events: []string{WebhookRequestCompleted}},
+	}, "s3cret", 3, time.Millisecond, time.Second, zap.NewNop())
+	wh.Start(ctx)
+	wh.Emit(WebhookBudgetExceeded, map[string]any{"model": "m"})
+
+	select {
+	case ev := <-delivered:
+		if ev.Type != WebhookBudgetExceeded || ev.Router != "r" || ev.Data["model"] != "m" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event not delivered after retry")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Errorf("expected one retry with a stable ID, got %v", ids)
+	}
+}
+
+func TestWebhooks_ClientErrorNotRetried(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhooks("r", []WebhookEndpoint{{URL: srv.URL}}, "", 3, time.Millisecond, time.Second, zap.NewNop())
+	d := webhookDelivery{url: srv.URL, event: WebhookRequestFailed, id: "evt_1", body: []byte("{}"), attempt: 1}
+	if retry, err := wh.post(context.Background(), d); err == nil || retry {
+		t.Fatalf("post = %v, %v; want a non-retryable error", retry, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected one call, got %d", calls)
+	}
+
+	var nilWebhooks *Webhooks
+	nilWebhooks.Emit(WebhookRequestCompleted, nil)
+}
@@ -10,6 +10,7 @@ import (
 
 // Event represents a single SSE event
 type Event struct {
+	Name  string // the "event:" field, empty when the event had none
 	Data  []byte // Raw JSON bytes for passthrough
 	Error error
 	Done  bool
@@ -18,6 +19,7 @@ type Event struct {
 // Reader provides a streaming SSE parser
 type Reader struct {
 	scanner   *bufio.Scanner
+	eventName string
 	eventData bytes.Buffer
 }
 
@@ -62,18 +64,26 @@ func (r *Reader) ReadEvents() <-chan Event {
 				continue
 			}
 
-			// Other SSE fields (event, id, retry) - not used by OpenAI; ignore
-			if strings.HasPrefix(line, "event:") ||
-				strings.HasPrefix(line, "id:") ||
+			// Event name, which Anthropic and Responses streams send
+			if strings.HasPrefix(line, "event:") {
+				r.eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+				continue
+			}
+
+			// Other SSE fields (id, retry) - not used; ignore
+			if strings.HasPrefix(line, "id:") ||
 				strings.HasPrefix(line, "retry:") {
 				continue
 			}
 
 			// Blank line indicates end of an event
 			if strings.TrimSpace(line) == "" {
-				if r.eventData.Len() > 0 {
+				if r.eventData.Len() == 0 {
+					r.eventName = "" // an event without data is dropped
+				} else {
 					event := r.parseEvent(r.eventData.String())
 					r.eventData.Reset()
+					r.eventName = ""
 					events <- event
 					if event.Done || event.Error != nil {
 						return
@@ -104,5 +114,5 @@ func (r *Reader) parseEvent(payload string) Event {
 	if payload == "[DONE]" {
 		return Event{Done: true}
 	}
-	return Event{Data: []byte(payload)}
+	return Event{Name: r.eventName, Data: []byte(payload)}
 }
@@ -0,0 +1,34 @@
+package sse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReader_EventNames(t *testing.T) {
+	stream := "event: message_start\ndata: {\"a\":1}\n\n" +
+		": keepalive\n\n" +
+		"data: {\"b\":2}\n\n" +
+		"event: orphan\n\n" +
+		"event: ping\r\ndata: {}\r\n\r\n" +
+		"data: [DONE]\n\n"
+
+	var got []Event
+	for ev := range NewDefaultReader(strings.NewReader(stream)).ReadEvents() {
+		got = append(got, ev)
+	}
+	want := []Event{
+		{Name: "message_start", Data: []byte(`{"a":1}`)},
+		{Data: []byte(`{"b":2}`)},
+		{Name: "ping", Data: []byte(`{}`)},
+		{Done: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || string(got[i].Data) != string(want[i].Data) || got[i].Done != want[i].Done {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -1,14 +1,23 @@
 package sse
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/open-ai-router/src/bufpool"
 )
 
-// Writer provides SSE response writing utilities
+// Writer provides SSE response writing utilities. Its methods are safe for
+// concurrent use, so a keepalive goroutine can share it with the handler.
 type Writer struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
+
+	mu        sync.Mutex
+	lastWrite time.Time
 }
 
 // NewWriter creates a new SSE writer and sets appropriate headers
@@ -22,40 +31,107 @@ func NewWriter(w http.ResponseWriter) *Writer {
 	hdr.Set("X-Accel-Buffering", "no")
 	hdr.Del("Content-Encoding")
 
-	return &Writer{w: w, flusher: flusher}
+	return &Writer{w: w, flusher: flusher, lastWrite: time.Now()}
 }
 
-// WriteHeartbeat writes an SSE comment as a heartbeat/init signal
-func (sw *Writer) WriteHeartbeat(msg string) error {
-	if _, err := sw.w.Write([]byte(":" + msg + "\n\n")); err != nil {
+// write writes an event, comment or sentinel as one unit, so events never
+// interleave with keepalives, and flushes.
+func (sw *Writer) write(b []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if _, err := sw.w.Write(b); err != nil {
 		return err
 	}
-	sw.Flush()
+	sw.lastWrite = time.Now()
+	if sw.flusher != nil {
+		sw.flusher.Flush()
+	}
 	return nil
 }
 
+// writeFrame frames data as one SSE event (or comment, with prefix ":")
+// in a pooled buffer: streams write an event per chunk, and the three
+// writes it used to take each crossed every wrapping writer.
+func (sw *Writer) writeFrame(event, prefix string, data []byte) error {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(prefix)
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return sw.write(buf.Bytes())
+}
+
+// WriteHeartbeat writes an SSE comment as a heartbeat/init signal
+func (sw *Writer) WriteHeartbeat(msg string) error {
+	return sw.writeFrame("", ":"+msg, nil)
+}
+
+// StartKeepalive writes a ":keepalive" comment whenever nothing has been
+// written for interval, so proxies don't close a stream that is waiting on
+// a slow upstream, tool loop or sidecar. The returned func stops it and
+// must be called before the handler returns. A non-positive interval
+// disables keepalives.
+func (sw *Writer) StartKeepalive(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			sw.mu.Lock()
+			wait := interval - time.Since(sw.lastWrite)
+			sw.mu.Unlock()
+			if wait <= 0 {
+				if sw.WriteHeartbeat("keepalive") != nil {
+					return
+				}
+				wait = interval
+			}
+			timer.Reset(wait)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
 // WriteData writes a data event with JSON payload
 func (sw *Writer) WriteData(data any) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if err := json.NewEncoder(buf).Encode(data); err != nil {
 		return err
 	}
-	return sw.WriteRaw(jsonData)
+	// Encode ends the value with a newline; the event adds its own.
+	return sw.WriteRaw(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
 }
 
 // WriteRaw writes raw bytes as an SSE data event
 func (sw *Writer) WriteRaw(data []byte) error {
-	if _, err := sw.w.Write([]byte("data: ")); err != nil {
-		return err
-	}
-	if _, err := sw.w.Write(data); err != nil {
-		return err
-	}
-	if _, err := sw.w.Write([]byte("\n\n")); err != nil {
-		return err
-	}
-	sw.Flush()
-	return nil
+	return sw.writeFrame("", "data: ", data)
+}
+
+// WriteEvent writes data as an SSE event with the given event name; an
+// empty name writes a plain data event.
+func (sw *Writer) WriteEvent(name string, data []byte) error {
+	return sw.writeFrame(name, "data: ", data)
 }
 
 // WriteError writes an error event in a standard format
@@ -63,17 +139,18 @@ func (sw *Writer) WriteError(message string) error {
 	return sw.WriteData(map[string]string{"error": message})
 }
 
+// doneEvent is the [DONE] sentinel; writers never retain what they're given.
+var doneEvent = []byte("data: [DONE]\n\n")
+
 // WriteDone writes the [DONE] sentinel to signal stream end
 func (sw *Writer) WriteDone() error {
-	if _, err := sw.w.Write([]byte("data: [DONE]\n\n")); err != nil {
-		return err
-	}
-	sw.Flush()
-	return nil
+	return sw.write(doneEvent)
 }
 
 // Flush flushes the response writer if it supports flushing
 func (sw *Writer) Flush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
 	if sw.flusher != nil {
 		sw.flusher.Flush()
 	}
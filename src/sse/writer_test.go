@@ -0,0 +1,113 @@
+package sse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedRecorder lets the test read the body while the keepalive
+// goroutine writes to it.
+type lockedRecorder struct {
+	*httptest.ResponseRecorder
+	mu sync.Mutex
+}
+
+func (r *lockedRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ResponseRecorder.Write(b)
+}
+
+func (r *lockedRecorder) body() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Body.String()
+}
+
+func TestWriter_KeepaliveOnlyWhenIdle(t *testing.T) {
+	rec := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sw := NewWriter(rec)
+	stop := sw.StartKeepalive(20 * time.Millisecond)
+
+	// Steady writes keep the stream busy: no keepalive in between.
+	for range 5 {
+		if err := sw.WriteRaw([]byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if strings.Contains(rec.body(), ":keepalive") {
+		t.Fatalf("keepalive written while the stream was busy: %q", rec.body())
+	}
+
+	time.Sleep(70 * time.Millisecond)
+	stop()
+	n := strings.Count(rec.body(), ":keepalive\n\n")
+	if n < 1 {
+		t.Fatalf("no keepalive during idle gap: %q", rec.body())
+	}
+
+	// Nothing is written once stopped.
+	before := rec.body()
+	time.Sleep(50 * time.Millisecond)
+	if rec.body() != before {
+		t.Error("keepalive written after stop")
+	}
+	stop()
+}
+
+func TestWriter_KeepaliveDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := NewWriter(rec)
+	stop := sw.StartKeepalive(0)
+	time.Sleep(10 * time.Millisecond)
+	stop()
+	if rec.Body.Len() != 0 {
+		t.Errorf("unexpected output %q", rec.Body.String())
+	}
+}
+
+func TestWriter_WriteEvent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := NewWriter(rec)
+	_ = sw.WriteEvent("message_stop", []byte(`{"type":"message_stop"}`))
+	_ = sw.WriteEvent("", []byte(`{}`))
+	want := "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\ndata: {}\n\n"
+	if rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+// flushDiscard is a flushing ResponseWriter that drops the body.
+type flushDiscard struct{ header http.Header }
+
+func (w *flushDiscard) Header() http.Header         { return w.header }
+func (w *flushDiscard) Write(b []byte) (int, error) { return len(b), nil }
+func (w *flushDiscard) WriteHeader(int)             {}
+func (w *flushDiscard) Flush()                      {}
+
+func BenchmarkWriter_WriteEvent(b *testing.B) {
+	sw := NewWriter(&flushDiscard{header: http.Header{}})
+	data := []byte(`{"id":"chatcmpl-1","object":"chat.completion.chunk","model":"m","choices":[{"index":0,"delta":{"content":"token "}}]}`)
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := sw.WriteEvent("message", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriter_WriteData(b *testing.B) {
+	sw := NewWriter(&flushDiscard{header: http.Header{}})
+	payload := map[string]any{"id": "chatcmpl-1", "choices": []any{map[string]any{"index": 0}}}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := sw.WriteData(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package styles
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// ChoiceIndexMeta is the SET_META key marking the choice a stream chunk
+// belongs to, when one response streams several choices. Chunks without
+// it belong to choice 0.
+const ChoiceIndexMeta = "choice_index"
+
+// splitChoice returns a chunk's choice index and the chunk without its
+// marker.
+func splitChoice(chunk *ail.Program) (int, *ail.Program) {
+	for i, inst := range chunk.Code {
+		if inst.Op == ail.SET_META && inst.Key == ChoiceIndexMeta {
+			n, _ := strconv.Atoi(inst.Str)
+			return n, chunk.ClearAtIndex(i)
+		}
+	}
+	return 0, chunk
+}
+
+// firstChoiceEncoder is for client styles without multiple choices: it
+// encodes choice 0 and drops the rest.
+type firstChoiceEncoder struct {
+	StreamEncoder
+}
+
+func (e firstChoiceEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	index, chunk := splitChoice(chunk)
+	if index != 0 {
+		return nil, nil
+	}
+	return e.StreamEncoder.Push(chunk)
+}
+
+// setChoiceIndex rewrites the choice index of an encoded chat chunk; the
+// chat emitter always writes 0.
+func setChoiceIndex(data []byte, index int) []byte {
+	var chunk map[string]json.RawMessage
+	if json.Unmarshal(data, &chunk) != nil {
+		return data
+	}
+	var choices []map[string]any
+	if json.Unmarshal(chunk["choices"], &choices) != nil || len(choices) == 0 {
+		return data
+	}
+	for _, c := range choices {
+		c["index"] = index
+	}
+	chunk["choices"], _ = json.Marshal(choices)
+	out, err := json.Marshal(chunk)
+	if err != nil {
+		return data
+	}
+	return out
+}
@@ -17,10 +17,12 @@ type Style = ail.Style
 
 // Re-export style constants from the ail package — single source of truth.
 // StyleVirtual is router-only: it has no upstream provider, so AIL doesn't
-// (and shouldn't) define a parser/emitter for it.
+// (and shouldn't) define a parser/emitter for it. StyleSynthetic is too:
+// its upstream is the router's own stand-in, which speaks chat completions.
 const (
 	StyleUnknown         Style = ""
 	StyleVirtual         Style = "virtual"
+	StyleSynthetic       Style = "synthetic"
 	StyleChatCompletions       = ail.StyleChatCompletions
 	StyleResponses             = ail.StyleResponses
 	StyleAnthropic             = ail.StyleAnthropic
@@ -31,11 +33,13 @@ const (
 
 // ParseStyle parses a style string, defaulting to OpenAI chat completions.
 // This is the router-level parser that knows about all styles including
-// router-only ones like "virtual".
+// router-only ones like "virtual" and "synthetic".
 func ParseStyle(s string) (Style, error) {
 	switch s {
 	case "virtual":
 		return StyleVirtual, nil
+	case "synthetic":
+		return StyleSynthetic, nil
 	case "chat-completions", "openai", "":
 		return StyleChatCompletions, nil
 	case "openai-responses", "responses":
@@ -0,0 +1,348 @@
+package styles
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Media is an image, audio clip or file of a program, resolved from the
+// buffer of its IMG_REF or AUD_REF and the media_type SET_META before it.
+// The buffer holds a URL, a data: URI or bare base64; files ride on
+// IMG_REF, as AIL has no opcode of their own, told apart by their type.
+// Exactly one of URL and Data is set.
+type Media struct {
+	URL      string // http(s) URL, for upstreams to fetch
+	MimeType string
+	Data     string // base64
+}
+
+// ResolveMedia reads a media buffer. mimeType is its SET_META media_type,
+// if any; otherwise the type comes from the data: URI, the URL's
+// extension, or the content itself.
+func ResolveMedia(buf []byte, mimeType string, audio bool) Media {
+	s := string(buf)
+	if rest, ok := strings.CutPrefix(s, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok {
+			params := strings.Split(meta, ";")
+			if mimeType == "" {
+				mimeType = params[0]
+			}
+			if params[len(params)-1] != "base64" {
+				text, _ := url.PathUnescape(data)
+				data = base64.StdEncoding.EncodeToString([]byte(text))
+			}
+			return Media{MimeType: orDefaultMime(mimeType, data, audio), Data: data}
+		}
+	}
+	if strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://") {
+		if mimeType == "" {
+			if u, err := url.Parse(s); err == nil {
+				mimeType, _, _ = strings.Cut(mime.TypeByExtension(path.Ext(u.Path)), ";")
+			}
+		}
+		if mimeType == "" && !audio {
+			mimeType = "image/png"
+		}
+		return Media{URL: s, MimeType: mimeType}
+	}
+	return Media{MimeType: orDefaultMime(mimeType, s, audio), Data: s}
+}
+
+// orDefaultMime returns mimeType, else the type sniffed from the first
+// bytes of data, else image/png or audio/wav.
+func orDefaultMime(mimeType, data string, audio bool) string {
+	if mimeType != "" {
+		return mimeType
+	}
+	head := data[:min(len(data), 512)]
+	head = head[:len(head)/4*4]
+	if raw, err := base64.StdEncoding.DecodeString(head); err == nil {
+		if t, _, _ := strings.Cut(http.DetectContentType(raw), ";"); t != "application/octet-stream" && t != "text/plain" {
+			return t
+		}
+	}
+	if audio {
+		return "audio/wav"
+	}
+	return "image/png"
+}
+
+// DataURI returns m inline as a data: URI.
+func (m Media) DataURI() string {
+	return "data:" + m.MimeType + ";base64," + m.Data
+}
+
+// IsImage reports whether m is an image rather than a file.
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.MimeType, "image/")
+}
+
+// Filename returns a name for m sent as a file, from its type.
+func (m Media) Filename() string {
+	if exts, _ := mime.ExtensionsByType(m.MimeType); len(exts) > 0 {
+		return "file" + exts[len(exts)-1]
+	}
+	return "file"
+}
+
+// GetParser returns the request parser for a client style. The ail
+// parsers drop some media parts, so the parser returned first rewrites
+// those it skips into the shapes it reads: chat "file" parts, Anthropic
+// URL and document sources and Gemini fileData, with URLs carried in the
+// data field. Responses input with content arrays is filled in after
+// parsing.
+func GetParser(s Style) (ail.Parser, error) {
+	inner, err := ail.GetParser(s)
+	if err != nil {
+		return nil, err
+	}
+	switch s {
+	case StyleChatCompletions, StyleAnthropic, StyleGoogleGenAI, StyleResponses:
+		return mediaParser{inner: inner, style: s}, nil
+	}
+	return inner, nil
+}
+
+type mediaParser struct {
+	inner ail.Parser
+	style Style
+}
+
+func (p mediaParser) ParseRequest(body []byte) (*ail.Program, error) {
+	var err error
+	switch p.style {
+	case StyleChatCompletions:
+		body, err = rewriteParts(body, "messages", "content", []string{`"file"`}, chatFilePart)
+	case StyleAnthropic:
+		body, err = rewriteParts(body, "messages", "content", []string{`"source"`}, anthropicSourcePart)
+	case StyleGoogleGenAI:
+		body, err = rewriteParts(body, "contents", "parts", []string{`"fileData"`, `"file_data"`}, googleFilePart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	prog, err := p.inner.ParseRequest(body)
+	if err != nil || p.style != StyleResponses {
+		return prog, err
+	}
+	return responsesContent(prog, body)
+}
+
+// rewriteParts applies rewrite to each part of the messages' content
+// arrays, re-encoding the body only when one changed. Bodies without any
+// of the markers need no rewriting.
+func rewriteParts(body []byte, messagesKey, contentKey string, markers []string, rewrite func(map[string]json.RawMessage) (bool, error)) ([]byte, error) {
+	if !slices.ContainsFunc(markers, func(m string) bool { return bytes.Contains(body, []byte(m)) }) {
+		return body, nil
+	}
+	var req map[string]json.RawMessage
+	if json.Unmarshal(body, &req) != nil {
+		return body, nil // the parser reports it
+	}
+	var msgs []map[string]json.RawMessage
+	if json.Unmarshal(req[messagesKey], &msgs) != nil {
+		return body, nil
+	}
+	changed := false
+	for _, msg := range msgs {
+		var parts []map[string]json.RawMessage
+		if json.Unmarshal(msg[contentKey], &parts) != nil {
+			continue
+		}
+		partsChanged := false
+		for _, part := range parts {
+			ok, err := rewrite(part)
+			if err != nil {
+				return nil, err
+			}
+			partsChanged = partsChanged || ok
+		}
+		if partsChanged {
+			msg[contentKey], _ = json.Marshal(parts)
+			changed = true
+		}
+	}
+	if !changed {
+		return body, nil
+	}
+	req[messagesKey], _ = json.Marshal(msgs)
+	return json.Marshal(req)
+}
+
+// setPart replaces the fields of part with those of v.
+func setPart(part map[string]json.RawMessage, v map[string]any) {
+	clear(part)
+	for k, val := range v {
+		part[k], _ = json.Marshal(val)
+	}
+}
+
+func partType(part map[string]json.RawMessage) string {
+	var t string
+	_ = json.Unmarshal(part["type"], &t)
+	return t
+}
+
+// chatFilePart turns a chat file part into an image_url part with its
+// data: URI.
+func chatFilePart(part map[string]json.RawMessage) (bool, error) {
+	if partType(part) != "file" {
+		return false, nil
+	}
+	var f struct {
+		FileData string `json:"file_data"`
+		FileID   string `json:"file_id"`
+	}
+	_ = json.Unmarshal(part["file"], &f)
+	if f.FileData == "" {
+		if f.FileID != "" {
+			return false, errors.New("file parts must carry file_data; uploaded file_id references cannot be routed")
+		}
+		return false, errors.New("file part without file_data")
+	}
+	setPart(part, map[string]any{"type": "image_url", "image_url": map[string]string{"url": f.FileData}})
+	return true, nil
+}
+
+// anthropicSourcePart turns Anthropic URL and text sources, and document
+// blocks, into base64 image blocks.
+func anthropicSourcePart(part map[string]json.RawMessage) (bool, error) {
+	t := partType(part)
+	if t != "image" && t != "document" {
+		return false, nil
+	}
+	var src struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+		URL       string `json:"url"`
+	}
+	if json.Unmarshal(part["source"], &src) != nil {
+		return false, nil
+	}
+	switch src.Type {
+	case "url":
+		src.Data = src.URL
+	case "text":
+		src.Data = base64.StdEncoding.EncodeToString([]byte(src.Data))
+		if src.MediaType == "" {
+			src.MediaType = "text/plain"
+		}
+	case "base64":
+		if t == "image" {
+			return false, nil
+		}
+	default:
+		return false, fmt.Errorf("unsupported %s source type %q", t, src.Type)
+	}
+	source := map[string]string{"type": "base64", "data": src.Data}
+	if src.MediaType != "" {
+		source["media_type"] = src.MediaType
+	}
+	setPart(part, map[string]any{"type": "image", "source": source})
+	return true, nil
+}
+
+// googleFilePart turns a Gemini fileData part into inlineData carrying its
+// URI.
+func googleFilePart(part map[string]json.RawMessage) (bool, error) {
+	raw, ok := part["fileData"]
+	if !ok {
+		if raw, ok = part["file_data"]; !ok {
+			return false, nil
+		}
+	}
+	var f struct {
+		MimeType  string `json:"mimeType"`
+		MimeType2 string `json:"mime_type"`
+		FileURI   string `json:"fileUri"`
+		FileURI2  string `json:"file_uri"`
+	}
+	_ = json.Unmarshal(raw, &f)
+	setPart(part, map[string]any{"inlineData": map[string]string{
+		"mimeType": cmp.Or(f.MimeType, f.MimeType2),
+		"data":     cmp.Or(f.FileURI, f.FileURI2),
+	}})
+	return true, nil
+}
+
+// responsesContent fills in the content of Responses input items given as
+// arrays of parts, which the ail parser skips: input_text, input_image and
+// input_file (by file_data or file_url).
+func responsesContent(prog *ail.Program, body []byte) (*ail.Program, error) {
+	var req struct {
+		Input json.RawMessage `json:"input"`
+	}
+	var items []json.RawMessage
+	if json.Unmarshal(body, &req) != nil || json.Unmarshal(req.Input, &items) != nil {
+		return prog, nil
+	}
+	var objects []map[string]json.RawMessage
+	for _, raw := range items {
+		var item map[string]json.RawMessage
+		if json.Unmarshal(raw, &item) == nil {
+			objects = append(objects, item)
+		}
+	}
+	// Each object item became a message, after the one for instructions.
+	spans := prog.Messages()
+	if len(spans) < len(objects) {
+		return prog, nil
+	}
+	spans = spans[len(spans)-len(objects):]
+	for i := len(objects) - 1; i >= 0; i-- {
+		var parts []json.RawMessage
+		if json.Unmarshal(objects[i]["content"], &parts) != nil {
+			continue
+		}
+		var content []ail.Instruction
+		for _, part := range parts {
+			inst, err := responsesPart(prog, part)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, inst...)
+		}
+		prog = prog.InsertBefore(spans[i].End, content...)
+	}
+	return prog, nil
+}
+
+func responsesPart(prog *ail.Program, raw json.RawMessage) ([]ail.Instruction, error) {
+	var p struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL string `json:"image_url"`
+		FileData string `json:"file_data"`
+		FileURL  string `json:"file_url"`
+		FileID   string `json:"file_id"`
+	}
+	_ = json.Unmarshal(raw, &p)
+	switch p.Type {
+	case "input_text", "output_text", "text":
+		return []ail.Instruction{{Op: ail.TXT_CHUNK, Str: p.Text}}, nil
+	case "input_image", "input_file":
+		src := cmp.Or(p.ImageURL, p.FileData, p.FileURL)
+		if src == "" {
+			if p.FileID != "" {
+				return nil, fmt.Errorf("%s parts must carry their data or URL; uploaded file_id references cannot be routed", p.Type)
+			}
+			return nil, fmt.Errorf("%s part without data", p.Type)
+		}
+		ref := prog.AddBuffer([]byte(src))
+		return []ail.Instruction{{Op: ail.IMG_REF, Ref: ref}}, nil
+	}
+	return nil, nil
+}
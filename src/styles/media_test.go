@@ -0,0 +1,135 @@
+package styles
+
+import (
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// mediaOf returns the media of prog's refs, in order.
+func mediaOf(prog *ail.Program) []Media {
+	var out []Media
+	mimeType := ""
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.SET_META:
+			if inst.Key == "media_type" {
+				mimeType = inst.Str
+			}
+		case ail.IMG_REF, ail.AUD_REF:
+			out = append(out, ResolveMedia(prog.Buffers[inst.Ref], mimeType, inst.Op == ail.AUD_REF))
+			mimeType = ""
+		}
+	}
+	return out
+}
+
+func parseWith(t *testing.T, s Style, body string) *ail.Program {
+	t.Helper()
+	p, err := GetParser(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := p.ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func checkMedia(t *testing.T, got []Media, want ...Media) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("media = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("media %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResolveMedia(t *testing.T) {
+	cases := []struct {
+		buf, mime string
+		audio     bool
+		want      Media
+	}{
+		{"https://x.test/a.webp?s=1", "", false, Media{URL: "https://x.test/a.webp?s=1", MimeType: "image/webp"}},
+		{"data:application/pdf;base64,JVBERi0=", "", false, Media{MimeType: "application/pdf", Data: "JVBERi0="}},
+		{"data:text/plain,hi%20there", "", false, Media{MimeType: "text/plain", Data: "aGkgdGhlcmU="}},
+		{"/9j/4AAQSkZJRg==", "", false, Media{MimeType: "image/jpeg", Data: "/9j/4AAQSkZJRg=="}},
+		{"AAAA", "image/gif", false, Media{MimeType: "image/gif", Data: "AAAA"}},
+		{"AAAA", "", true, Media{MimeType: "audio/wav", Data: "AAAA"}},
+	}
+	for _, tc := range cases {
+		if got := ResolveMedia([]byte(tc.buf), tc.mime, tc.audio); got != tc.want {
+			t.Errorf("%q: %+v, want %+v", tc.buf, got, tc.want)
+		}
+	}
+}
+
+func TestGetParser_ChatFiles(t *testing.T) {
+	prog := parseWith(t, StyleChatCompletions, `{"model": "m", "messages": [{"role": "user", "content": [
+		{"type": "text", "text": "Summarize"},
+		{"type": "file", "file": {"filename": "a.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}},
+		{"type": "input_audio", "input_audio": {"data": "UklGRg==", "format": "mp3"}}]}]}`)
+	checkMedia(t, mediaOf(prog),
+		Media{MimeType: "application/pdf", Data: "JVBERi0="},
+		Media{MimeType: "audio/mp3", Data: "UklGRg=="})
+
+	p, _ := GetParser(StyleChatCompletions)
+	if _, err := p.ParseRequest([]byte(`{"model": "m", "messages": [{"role": "user", "content": [{"type": "file", "file": {"file_id": "file-1"}}]}]}`)); err == nil {
+		t.Error("file_id accepted")
+	}
+}
+
+func TestGetParser_AnthropicSources(t *testing.T) {
+	prog := parseWith(t, StyleAnthropic, `{"model": "m", "max_tokens": 10, "messages": [{"role": "user", "content": [
+		{"type": "image", "source": {"type": "url", "url": "https://x.test/a.png"}},
+		{"type": "image", "source": {"type": "base64", "media_type": "image/gif", "data": "R0lGOD=="}},
+		{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "JVBERi0="}},
+		{"type": "document", "source": {"type": "text", "media_type": "text/plain", "data": "hi"}},
+		{"type": "text", "text": "What are these?"}]}]}`)
+	checkMedia(t, mediaOf(prog),
+		Media{URL: "https://x.test/a.png", MimeType: "image/png"},
+		Media{MimeType: "image/gif", Data: "R0lGOD=="},
+		Media{MimeType: "application/pdf", Data: "JVBERi0="},
+		Media{MimeType: "text/plain", Data: "aGk="})
+}
+
+func TestGetParser_GeminiFileData(t *testing.T) {
+	prog := parseWith(t, StyleGoogleGenAI, `{"contents": [{"role": "user", "parts": [
+		{"text": "Describe"},
+		{"fileData": {"mimeType": "video/mp4", "fileUri": "gs://bucket/clip.mp4"}},
+		{"inlineData": {"mimeType": "audio/wav", "data": "UklGRg=="}}]}]}`)
+	checkMedia(t, mediaOf(prog),
+		Media{MimeType: "video/mp4", Data: "gs://bucket/clip.mp4"},
+		Media{MimeType: "audio/wav", Data: "UklGRg=="})
+}
+
+func TestGetParser_GeminiSnakeCaseFileData(t *testing.T) {
+	prog := parseWith(t, StyleGoogleGenAI, `{"contents": [{"role": "user", "parts": [
+		{"text": "Describe"},
+		{"file_data": {"mime_type": "application/pdf", "file_uri": "gs://bucket/doc.pdf"}},
+		{"file_data": {"mimeType": "video/mp4", "mime_type": "video/webm", "fileUri": "gs://bucket/clip.mp4", "file_uri": "gs://bucket/clip.webm"}}]}]}`)
+	checkMedia(t, mediaOf(prog),
+		Media{MimeType: "application/pdf", Data: "gs://bucket/doc.pdf"},
+		Media{MimeType: "video/mp4", Data: "gs://bucket/clip.mp4"})
+}
+
+func TestGetParser_ResponsesContent(t *testing.T) {
+	prog := parseWith(t, StyleResponses, `{"model": "m", "instructions": "Be brief.", "input": [
+		{"role": "user", "content": [
+			{"type": "input_text", "text": "Read this"},
+			{"type": "input_image", "image_url": "https://x.test/a.jpg"},
+			{"type": "input_file", "filename": "a.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}]},
+		{"role": "assistant", "content": "Done."}]}`)
+	msgs := prog.Messages()
+	if len(msgs) != 3 || prog.MessageText(msgs[1]) != "Read this" || prog.MessageText(msgs[2]) != "Done." {
+		t.Fatalf("messages:\n%s", prog.Disasm())
+	}
+	checkMedia(t, mediaOf(prog),
+		Media{URL: "https://x.test/a.jpg", MimeType: "image/jpeg"},
+		Media{MimeType: "application/pdf", Data: "JVBERi0="})
+}
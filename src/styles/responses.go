@@ -0,0 +1,181 @@
+package styles
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neutrome-labs/ail"
+)
+
+// GetResponseEmitter returns the response emitter for a client style. The
+// ail package has no emitter for OpenAI Responses, so that one lives here.
+func GetResponseEmitter(s Style) (ail.ResponseEmitter, error) {
+	if s == StyleResponses {
+		return ResponsesEmitter{}, nil
+	}
+	return ail.GetResponseEmitter(s)
+}
+
+// ResponsesEmitter encodes a response program as an OpenAI Responses object.
+// Reasoning, text and function calls become output items in program order;
+// only the first choice is kept, as the format has no choices.
+type ResponsesEmitter struct{}
+
+func (ResponsesEmitter) EmitResponse(prog *ail.Program) ([]byte, error) {
+	var (
+		id, model, finish string
+		usage             streamUsage
+		output            []any
+		msgs              int
+		text, reasoning   strings.Builder
+		call              map[string]any
+	)
+	flushText := func() {
+		if reasoning.Len() > 0 {
+			output = append(output, responsesReasoningItem(newItemID("rs"), reasoning.String()))
+			reasoning.Reset()
+		}
+		if text.Len() > 0 {
+			output = append(output, responsesMessageItem(newItemID("msg"), "completed", text.String()))
+			text.Reset()
+		}
+	}
+
+	for _, inst := range prog.Code {
+		switch inst.Op {
+		case ail.RESP_ID:
+			id = inst.Str
+		case ail.RESP_MODEL:
+			model = inst.Str
+		case ail.USAGE:
+			usage.observe(inst.JSON)
+		case ail.MSG_START:
+			msgs++
+		case ail.TXT_CHUNK:
+			if msgs <= 1 {
+				text.WriteString(inst.Str)
+			}
+		case ail.THINK_CHUNK:
+			if msgs <= 1 {
+				reasoning.WriteString(inst.Str)
+			}
+		case ail.CALL_START:
+			if msgs <= 1 {
+				flushText()
+				call = responsesCallItem(newItemID("fc"), "completed", inst.Str, "", "")
+			}
+		case ail.CALL_NAME:
+			if call != nil {
+				call["name"] = inst.Str
+			}
+		case ail.CALL_ARGS:
+			if call != nil {
+				call["arguments"] = string(inst.JSON)
+			}
+		case ail.CALL_END:
+			if call != nil {
+				output = append(output, call)
+				call = nil
+			}
+		case ail.RESP_DONE:
+			if msgs <= 1 {
+				finish = inst.Str
+			}
+		case ail.MSG_END:
+			if msgs <= 1 {
+				flushText()
+			}
+		}
+	}
+	flushText()
+	if id == "" {
+		id = newItemID("resp")
+	}
+	if finish == "" {
+		finish = "stop"
+	}
+	return json.Marshal(responsesObject(id, model, time.Now().Unix(), finish, output, &usage))
+}
+
+// responsesObject builds a Responses "response" object. An empty finish
+// reason means it is still in progress, a length or content_filter one marks
+// it incomplete; usage is omitted when nil.
+func responsesObject(id, model string, created int64, finish string, output []any, usage *streamUsage) map[string]any {
+	if output == nil {
+		output = []any{}
+	}
+	resp := map[string]any{
+		"id":         id,
+		"object":     "response",
+		"created_at": created,
+		"status":     "completed",
+		"model":      model,
+		"output":     output,
+	}
+	switch finish {
+	case "":
+		resp["status"] = "in_progress"
+	case "length":
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]string{"reason": "max_output_tokens"}
+	case "content_filter":
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]string{"reason": "content_filter"}
+	}
+	if usage != nil {
+		resp["usage"] = map[string]int{
+			"input_tokens":  usage.Prompt,
+			"output_tokens": usage.Completion,
+			"total_tokens":  usage.Prompt + usage.Completion,
+		}
+	}
+	return resp
+}
+
+func responsesMessageItem(id, status, text string) map[string]any {
+	content := []any{}
+	if status == "completed" {
+		content = append(content, responsesTextPart(text))
+	}
+	return map[string]any{
+		"id":      id,
+		"type":    "message",
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+func responsesTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func responsesReasoningItem(id, summary string) map[string]any {
+	parts := []any{}
+	if summary != "" {
+		parts = append(parts, responsesSummaryPart(summary))
+	}
+	return map[string]any{"id": id, "type": "reasoning", "summary": parts}
+}
+
+func responsesSummaryPart(text string) map[string]any {
+	return map[string]any{"type": "summary_text", "text": text}
+}
+
+func responsesCallItem(id, status, callID, name, args string) map[string]any {
+	return map[string]any{
+		"id":        id,
+		"type":      "function_call",
+		"status":    status,
+		"call_id":   callID,
+		"name":      name,
+		"arguments": args,
+	}
+}
+
+// newItemID returns a random Responses-style ID such as "msg_3f2a...".
+func newItemID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
@@ -0,0 +1,280 @@
+package styles
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// Event is one server-sent event for the client. Name is the SSE "event:"
+// field, which Anthropic and Responses clients dispatch on; it is empty
+// for styles that only send data lines.
+type Event struct {
+	Name string
+	Data []byte
+}
+
+// StreamEncoder turns the AIL stream chunks of one upstream response into
+// client events of a target style. It is not safe for concurrent use.
+//
+// Tool-call deltas are handled per target:
+//
+//   - chat-completions, anthropic-messages, openai-responses stream tool
+//     calls incrementally. Calls are renumbered densely from 0 in order of
+//     appearance (upstreams index them by content block or output item),
+//     and argument fragments are held back until the call's name is known,
+//     since each of these formats announces a call with its name first.
+//   - google-genai needs whole function calls, so deltas are buffered until
+//     the response finishes.
+//
+// With ToolDeltasBuffered every target gets each call whole, as one delta
+// sent before the finish reason.
+//
+// Anthropic and Responses streams end with events that carry the usage,
+// which OpenAI-style upstreams report after the finish reason; those
+// events are only written by Flush, once the upstream stream has closed.
+type StreamEncoder interface {
+	// Push encodes one chunk; it may return no events or several.
+	Push(chunk *ail.Program) ([]Event, error)
+	// Flush returns the events held back until the end of the stream.
+	Flush() ([]Event, error)
+}
+
+// NewStreamEncoder returns an encoder from the upstream style to the client
+// style; tools selects incremental or buffered tool-call deltas.
+func NewStreamEncoder(from, to Style, tools ToolDeltas) (StreamEncoder, error) {
+	enc, err := newStreamEncoder(from, to)
+	if err != nil || tools != ToolDeltasBuffered {
+		return enc, err
+	}
+	return &bufferedToolsEncoder{StreamEncoder: enc}, nil
+}
+
+func newStreamEncoder(from, to Style) (StreamEncoder, error) {
+	if _, err := ail.GetStreamChunkParser(from); err != nil {
+		return nil, err
+	}
+	switch to {
+	case StyleAnthropic:
+		return firstChoiceEncoder{newAnthropicStreamEncoder()}, nil
+	case StyleResponses:
+		return firstChoiceEncoder{newResponsesStreamEncoder()}, nil
+	}
+	conv, err := ail.NewStreamConverter(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if to == StyleChatCompletions {
+		return &chatStreamEncoder{conv: conv}, nil
+	}
+	return firstChoiceEncoder{&convStreamEncoder{conv: conv}}, nil
+}
+
+// StreamEndsWithDone reports whether streams of the style end with a
+// "data: [DONE]" sentinel. Anthropic and Responses streams end with their
+// own terminal events instead.
+func StreamEndsWithDone(s Style) bool {
+	return s != StyleAnthropic && s != StyleResponses
+}
+
+// convStreamEncoder encodes through ail.StreamConverter unchanged.
+type convStreamEncoder struct {
+	conv *ail.StreamConverter
+}
+
+func (e *convStreamEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	return dataEvents(e.conv.PushProgram(chunk))
+}
+
+func (e *convStreamEncoder) Flush() ([]Event, error) {
+	return dataEvents(e.conv.Flush())
+}
+
+// chatStreamEncoder normalizes tool-call deltas before the converter: the
+// chat emitter keeps only one tool call per chunk, so each delta goes out
+// in its own chunk. Chunks marked with ChoiceIndexMeta go out under their
+// choice index, with tool calls numbered per choice.
+type chatStreamEncoder struct {
+	conv  *ail.StreamConverter
+	tools map[int]*toolCalls
+}
+
+func (e *chatStreamEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	index, chunk := splitChoice(chunk)
+	if e.tools == nil {
+		e.tools = make(map[int]*toolCalls)
+	}
+	tools := e.tools[index]
+	if tools == nil {
+		tools = &toolCalls{}
+		e.tools[index] = tools
+	}
+
+	var events []Event
+	emit := func(prog *ail.Program) error {
+		out, err := dataEvents(e.conv.PushProgram(prog))
+		if index != 0 {
+			for i := range out {
+				out[i].Data = setChoiceIndex(out[i].Data, index)
+			}
+		}
+		events = append(events, out...)
+		return err
+	}
+	// Most chunks carry no tool deltas and go to the converter as they
+	// are, without a copy per chunk.
+	if !slices.ContainsFunc(chunk.Code, func(inst ail.Instruction) bool { return inst.Op == ail.STREAM_TOOL_DELTA }) {
+		return events, emit(chunk)
+	}
+
+	current := ail.NewProgram()
+	hasTool := false
+	push := func() error {
+		if current.Len() == 0 {
+			return nil
+		}
+		err := emit(current)
+		current, hasTool = ail.NewProgram(), false
+		return err
+	}
+	for _, inst := range chunk.Code {
+		if inst.Op != ail.STREAM_TOOL_DELTA {
+			current.Code = append(current.Code, inst)
+			continue
+		}
+		call, opened, args, ok := tools.push(inst.JSON)
+		if !ok || (!opened && args == "") {
+			continue
+		}
+		if hasTool {
+			if err := push(); err != nil {
+				return events, err
+			}
+		}
+		td := map[string]any{"index": call.index}
+		if opened {
+			td["id"], td["name"] = call.id, call.name
+		}
+		if args != "" {
+			td["arguments"] = args
+		}
+		j, _ := json.Marshal(td)
+		current.EmitJSON(ail.STREAM_TOOL_DELTA, j)
+		hasTool = true
+	}
+	return events, push()
+}
+
+func (e *chatStreamEncoder) Flush() ([]Event, error) {
+	return dataEvents(e.conv.Flush())
+}
+
+func dataEvents(outs [][]byte, err error) ([]Event, error) {
+	events := make([]Event, 0, len(outs))
+	for _, out := range outs {
+		events = append(events, Event{Data: out})
+	}
+	return events, err
+}
+
+// toolCalls tracks the tool calls of one streamed response.
+type toolCalls struct {
+	bySource map[int]*toolCall
+	calls    []*toolCall
+}
+
+type toolCall struct {
+	index    int // dense, in order of appearance
+	id       string
+	name     string
+	args     strings.Builder // arguments released so far
+	opened   bool
+	heldArgs strings.Builder // arguments received before the name
+}
+
+// push applies one STREAM_TOOL_DELTA. It reports the call, whether the
+// delta opened it (its name is now known), and the argument text that may
+// now be sent. ok is false for a malformed delta.
+func (t *toolCalls) push(raw json.RawMessage) (call *toolCall, opened bool, args string, ok bool) {
+	var td struct {
+		Index     int    `json:"index"`
+		ID        string `json:"id,omitempty"`
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments,omitempty"`
+	}
+	if json.Unmarshal(raw, &td) != nil {
+		return nil, false, "", false
+	}
+	if t.bySource == nil {
+		t.bySource = make(map[int]*toolCall)
+	}
+	call, found := t.bySource[td.Index]
+	if !found {
+		call = &toolCall{index: len(t.calls)}
+		t.bySource[td.Index] = call
+		t.calls = append(t.calls, call)
+	}
+	if td.ID != "" && call.id == "" {
+		call.id = td.ID
+	}
+	if td.Name != "" && call.name == "" {
+		call.name = td.Name
+	}
+
+	if !call.opened {
+		call.heldArgs.WriteString(td.Arguments)
+		if call.name == "" {
+			return call, false, "", true
+		}
+		call.opened, opened = true, true
+		if call.id == "" {
+			call.id = "call_" + strconv.Itoa(call.index)
+		}
+		args = call.heldArgs.String()
+		call.heldArgs.Reset()
+	} else {
+		args = td.Arguments
+	}
+	call.args.WriteString(args)
+	return call, opened, args, true
+}
+
+// streamUsage keeps the latest token counts reported in a stream; some
+// upstreams report prompt and completion tokens in different events.
+type streamUsage struct {
+	Prompt, Completion int
+}
+
+func (u *streamUsage) observe(raw json.RawMessage) {
+	var v struct {
+		PromptTokens     *int `json:"prompt_tokens"`
+		CompletionTokens *int `json:"completion_tokens"`
+	}
+	if json.Unmarshal(raw, &v) != nil {
+		return
+	}
+	if v.PromptTokens != nil {
+		u.Prompt = *v.PromptTokens
+	}
+	if v.CompletionTokens != nil {
+		u.Completion = *v.CompletionTokens
+	}
+}
+
+// eventList collects the named JSON events an encoder produces.
+type eventList struct {
+	events []Event
+}
+
+func (l *eventList) emit(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("styles: encode %s event: %w", name, err)
+	}
+	l.events = append(l.events, Event{Name: name, Data: data})
+	return nil
+}
@@ -0,0 +1,206 @@
+package styles
+
+import (
+	"encoding/json"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// anthropicStreamEncoder writes the Messages streaming protocol:
+// message_start, then content_block_start / content_block_delta /
+// content_block_stop per text, thinking or tool_use block, and finally
+// message_delta (stop reason and usage) and message_stop.
+type anthropicStreamEncoder struct {
+	id, model  string
+	started    bool
+	blocks     int    // content blocks opened so far
+	open       string // type of the open block, "" when none
+	toolBlocks map[*toolCall]int
+	tools      toolCalls
+	stopReason string
+	usage      streamUsage
+}
+
+func newAnthropicStreamEncoder() *anthropicStreamEncoder {
+	return &anthropicStreamEncoder{toolBlocks: make(map[*toolCall]int)}
+}
+
+func (e *anthropicStreamEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	var out eventList
+	emit := out.emit
+
+	// Metadata may follow STREAM_START within a chunk.
+	for _, inst := range chunk.Code {
+		switch inst.Op {
+		case ail.RESP_ID:
+			e.id = inst.Str
+		case ail.RESP_MODEL:
+			e.model = inst.Str
+		case ail.USAGE:
+			e.usage.observe(inst.JSON)
+		case ail.RESP_DONE:
+			e.stopReason = anthropicStopReason(inst.Str)
+		}
+	}
+
+	for _, inst := range chunk.Code {
+		var err error
+		switch inst.Op {
+		case ail.STREAM_START:
+			err = e.start(emit)
+		case ail.STREAM_DELTA:
+			if err = e.openBlock("text", emit); err == nil {
+				err = emit("content_block_delta", map[string]any{
+					"type":  "content_block_delta",
+					"index": e.blocks - 1,
+					"delta": map[string]any{"type": "text_delta", "text": inst.Str},
+				})
+			}
+		case ail.STREAM_THINK_DELTA:
+			if err = e.openBlock("thinking", emit); err == nil {
+				err = emit("content_block_delta", map[string]any{
+					"type":  "content_block_delta",
+					"index": e.blocks - 1,
+					"delta": map[string]any{"type": "thinking_delta", "thinking": inst.Str},
+				})
+			}
+		case ail.STREAM_TOOL_DELTA:
+			err = e.toolDelta(inst.JSON, emit)
+		}
+		if err != nil {
+			return out.events, err
+		}
+	}
+	return out.events, nil
+}
+
+func (e *anthropicStreamEncoder) start(emit func(string, any) error) error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	return emit("message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":            e.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         e.model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": e.usage.Prompt, "output_tokens": 0},
+		},
+	})
+}
+
+// openBlock makes a text or thinking block the open one, closing any other.
+func (e *anthropicStreamEncoder) openBlock(kind string, emit func(string, any) error) error {
+	if e.open == kind {
+		return nil
+	}
+	if err := e.start(emit); err != nil {
+		return err
+	}
+	if err := e.closeBlock(emit); err != nil {
+		return err
+	}
+	block := map[string]any{"type": kind, kind: ""}
+	if kind == "thinking" {
+		block["signature"] = ""
+	}
+	e.open = kind
+	e.blocks++
+	return emit("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         e.blocks - 1,
+		"content_block": block,
+	})
+}
+
+func (e *anthropicStreamEncoder) closeBlock(emit func(string, any) error) error {
+	if e.open == "" {
+		return nil
+	}
+	e.open = ""
+	return emit("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": e.blocks - 1,
+	})
+}
+
+func (e *anthropicStreamEncoder) toolDelta(raw json.RawMessage, emit func(string, any) error) error {
+	call, opened, args, ok := e.tools.push(raw)
+	if !ok {
+		return nil
+	}
+	if opened {
+		if err := e.start(emit); err != nil {
+			return err
+		}
+		if err := e.closeBlock(emit); err != nil {
+			return err
+		}
+		e.toolBlocks[call] = e.blocks
+		e.open = "tool_use"
+		e.blocks++
+		if err := emit("content_block_start", map[string]any{
+			"type":  "content_block_start",
+			"index": e.toolBlocks[call],
+			"content_block": map[string]any{
+				"type":  "tool_use",
+				"id":    call.id,
+				"name":  call.name,
+				"input": map[string]any{},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	if args == "" {
+		return nil
+	}
+	return emit("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": e.toolBlocks[call],
+		"delta": map[string]any{"type": "input_json_delta", "partial_json": args},
+	})
+}
+
+func (e *anthropicStreamEncoder) Flush() ([]Event, error) {
+	var out eventList
+	emit := out.emit
+	if err := e.start(emit); err != nil {
+		return out.events, err
+	}
+	if err := e.closeBlock(emit); err != nil {
+		return out.events, err
+	}
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	if err := emit("message_delta", map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": map[string]int{"input_tokens": e.usage.Prompt, "output_tokens": e.usage.Completion},
+	}); err != nil {
+		return out.events, err
+	}
+	return out.events, emit("message_stop", map[string]any{"type": "message_stop"})
+}
+
+// anthropicStopReason maps an AIL finish reason to a Messages stop_reason.
+func anthropicStopReason(reason string) string {
+	switch reason {
+	case "stop":
+		return "end_turn"
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	}
+	return reason
+}
@@ -0,0 +1,270 @@
+package styles
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// responsesStreamEncoder writes the Responses streaming protocol:
+// response.created and response.in_progress, then output_item.added /
+// *.delta / *.done / output_item.done per message, reasoning or
+// function_call item, and finally response.completed (or
+// response.incomplete) carrying the whole response.
+//
+// Text and reasoning items close when another item opens. Function calls
+// stay open until the end, since chat upstreams may interleave the
+// argument deltas of parallel calls.
+type responsesStreamEncoder struct {
+	id, model string
+	created   int64
+	seq       int
+	started   bool
+	output    []any // finished items, by output_index
+	open      *responsesItem
+	calls     []*responsesItem
+	toolItems map[*toolCall]*responsesItem
+	tools     toolCalls
+	finish    string
+	usage     streamUsage
+}
+
+type responsesItem struct {
+	index int // output_index
+	id    string
+	kind  string // "message", "reasoning" or "function_call"
+	text  strings.Builder
+	call  *toolCall
+}
+
+func newResponsesStreamEncoder() *responsesStreamEncoder {
+	return &responsesStreamEncoder{
+		created:   time.Now().Unix(),
+		toolItems: make(map[*toolCall]*responsesItem),
+	}
+}
+
+func (e *responsesStreamEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	var out eventList
+	emit := e.emitter(&out)
+
+	for _, inst := range chunk.Code {
+		switch inst.Op {
+		case ail.RESP_ID:
+			e.id = inst.Str
+		case ail.RESP_MODEL:
+			e.model = inst.Str
+		case ail.USAGE:
+			e.usage.observe(inst.JSON)
+		case ail.RESP_DONE:
+			e.finish = inst.Str
+		}
+	}
+
+	for _, inst := range chunk.Code {
+		var err error
+		switch inst.Op {
+		case ail.STREAM_START:
+			err = e.start(emit)
+		case ail.STREAM_DELTA:
+			err = e.textDelta("message", inst.Str, emit)
+		case ail.STREAM_THINK_DELTA:
+			err = e.textDelta("reasoning", inst.Str, emit)
+		case ail.STREAM_TOOL_DELTA:
+			err = e.toolDelta(inst.JSON, emit)
+		}
+		if err != nil {
+			return out.events, err
+		}
+	}
+	return out.events, nil
+}
+
+// emitter stamps every event with its type and the stream's sequence number.
+func (e *responsesStreamEncoder) emitter(out *eventList) func(string, map[string]any) error {
+	return func(name string, v map[string]any) error {
+		v["type"] = name
+		v["sequence_number"] = e.seq
+		e.seq++
+		return out.emit(name, v)
+	}
+}
+
+func (e *responsesStreamEncoder) response(finish string) map[string]any {
+	if e.id == "" {
+		e.id = newItemID("resp")
+	}
+	var usage *streamUsage
+	if finish != "" {
+		usage = &e.usage
+	}
+	return responsesObject(e.id, e.model, e.created, finish, e.output, usage)
+}
+
+func (e *responsesStreamEncoder) start(emit func(string, map[string]any) error) error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	if err := emit("response.created", map[string]any{"response": e.response("")}); err != nil {
+		return err
+	}
+	return emit("response.in_progress", map[string]any{"response": e.response("")})
+}
+
+func (e *responsesStreamEncoder) textDelta(kind, delta string, emit func(string, map[string]any) error) error {
+	if e.open == nil || e.open.kind != kind {
+		if err := e.start(emit); err != nil {
+			return err
+		}
+		if err := e.closeOpen(emit); err != nil {
+			return err
+		}
+		if err := e.openText(kind, emit); err != nil {
+			return err
+		}
+	}
+	it := e.open
+	it.text.WriteString(delta)
+	if kind == "reasoning" {
+		return emit("response.reasoning_summary_text.delta", map[string]any{
+			"item_id": it.id, "output_index": it.index, "summary_index": 0, "delta": delta,
+		})
+	}
+	return emit("response.output_text.delta", map[string]any{
+		"item_id": it.id, "output_index": it.index, "content_index": 0, "delta": delta,
+	})
+}
+
+func (e *responsesStreamEncoder) openText(kind string, emit func(string, map[string]any) error) error {
+	it := &responsesItem{index: len(e.output), kind: kind}
+	e.output = append(e.output, nil)
+	e.open = it
+	if kind == "reasoning" {
+		it.id = newItemID("rs")
+		if err := emit("response.output_item.added", map[string]any{
+			"output_index": it.index, "item": responsesReasoningItem(it.id, ""),
+		}); err != nil {
+			return err
+		}
+		return emit("response.reasoning_summary_part.added", map[string]any{
+			"item_id": it.id, "output_index": it.index, "summary_index": 0, "part": responsesSummaryPart(""),
+		})
+	}
+	it.id = newItemID("msg")
+	if err := emit("response.output_item.added", map[string]any{
+		"output_index": it.index, "item": responsesMessageItem(it.id, "in_progress", ""),
+	}); err != nil {
+		return err
+	}
+	return emit("response.content_part.added", map[string]any{
+		"item_id": it.id, "output_index": it.index, "content_index": 0, "part": responsesTextPart(""),
+	})
+}
+
+// closeOpen finishes the open text or reasoning item, if any.
+func (e *responsesStreamEncoder) closeOpen(emit func(string, map[string]any) error) error {
+	it := e.open
+	if it == nil {
+		return nil
+	}
+	e.open = nil
+	text := it.text.String()
+	var item map[string]any
+	if it.kind == "reasoning" {
+		item = responsesReasoningItem(it.id, text)
+		if err := emit("response.reasoning_summary_text.done", map[string]any{
+			"item_id": it.id, "output_index": it.index, "summary_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := emit("response.reasoning_summary_part.done", map[string]any{
+			"item_id": it.id, "output_index": it.index, "summary_index": 0, "part": responsesSummaryPart(text),
+		}); err != nil {
+			return err
+		}
+	} else {
+		item = responsesMessageItem(it.id, "completed", text)
+		if err := emit("response.output_text.done", map[string]any{
+			"item_id": it.id, "output_index": it.index, "content_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := emit("response.content_part.done", map[string]any{
+			"item_id": it.id, "output_index": it.index, "content_index": 0, "part": responsesTextPart(text),
+		}); err != nil {
+			return err
+		}
+	}
+	e.output[it.index] = item
+	return emit("response.output_item.done", map[string]any{"output_index": it.index, "item": item})
+}
+
+func (e *responsesStreamEncoder) toolDelta(raw json.RawMessage, emit func(string, map[string]any) error) error {
+	call, opened, args, ok := e.tools.push(raw)
+	if !ok {
+		return nil
+	}
+	if opened {
+		if err := e.start(emit); err != nil {
+			return err
+		}
+		if err := e.closeOpen(emit); err != nil {
+			return err
+		}
+		it := &responsesItem{index: len(e.output), id: newItemID("fc"), kind: "function_call", call: call}
+		e.output = append(e.output, nil)
+		e.calls = append(e.calls, it)
+		e.toolItems[call] = it
+		if err := emit("response.output_item.added", map[string]any{
+			"output_index": it.index,
+			"item":         responsesCallItem(it.id, "in_progress", call.id, call.name, ""),
+		}); err != nil {
+			return err
+		}
+	}
+	if args == "" {
+		return nil
+	}
+	it := e.toolItems[call]
+	return emit("response.function_call_arguments.delta", map[string]any{
+		"item_id": it.id, "output_index": it.index, "delta": args,
+	})
+}
+
+func (e *responsesStreamEncoder) Flush() ([]Event, error) {
+	var out eventList
+	emit := e.emitter(&out)
+	if err := e.start(emit); err != nil {
+		return out.events, err
+	}
+	if err := e.closeOpen(emit); err != nil {
+		return out.events, err
+	}
+	for _, it := range e.calls {
+		args := it.call.args.String()
+		item := responsesCallItem(it.id, "completed", it.call.id, it.call.name, args)
+		e.output[it.index] = item
+		if err := emit("response.function_call_arguments.done", map[string]any{
+			"item_id": it.id, "output_index": it.index, "arguments": args,
+		}); err != nil {
+			return out.events, err
+		}
+		if err := emit("response.output_item.done", map[string]any{"output_index": it.index, "item": item}); err != nil {
+			return out.events, err
+		}
+	}
+
+	finish := e.finish
+	if finish == "" {
+		finish = "stop"
+	}
+	resp := e.response(finish)
+	name := "response.completed"
+	if resp["status"] == "incomplete" {
+		name = "response.incomplete"
+	}
+	return out.events, emit(name, map[string]any{"response": resp})
+}
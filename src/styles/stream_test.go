@@ -0,0 +1,404 @@
+package styles
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/neutrome-labs/ail"
+)
+
+func chunk(build func(p *ail.Program)) *ail.Program {
+	p := ail.NewProgram()
+	build(p)
+	return p
+}
+
+func toolDelta(p *ail.Program, v map[string]any) {
+	j, _ := json.Marshal(v)
+	p.EmitJSON(ail.STREAM_TOOL_DELTA, j)
+}
+
+// chatToolStream is a chat-completions upstream stream: text, then two
+// parallel tool calls whose argument deltas interleave.
+func chatToolStream() []*ail.Program {
+	return []*ail.Program{
+		chunk(func(p *ail.Program) {
+			p.Emit(ail.STREAM_START)
+			p.EmitString(ail.RESP_ID, "chatcmpl-1")
+			p.EmitString(ail.RESP_MODEL, "gpt-x")
+			p.EmitString(ail.STREAM_DELTA, "Checking")
+		}),
+		chunk(func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 0, "id": "call_a", "name": "weather", "arguments": `{"city":`})
+		}),
+		chunk(func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 1, "id": "call_b", "name": "time", "arguments": "{}"})
+			toolDelta(p, map[string]any{"index": 0, "arguments": `"Oslo"}`})
+		}),
+		chunk(func(p *ail.Program) { p.EmitString(ail.RESP_DONE, "tool_calls") }),
+		chunk(func(p *ail.Program) {
+			p.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":7,"completion_tokens":5,"total_tokens":12}`))
+			p.Emit(ail.STREAM_END)
+		}),
+	}
+}
+
+func encodeAll(t *testing.T, from, to Style, chunks []*ail.Program) []Event {
+	t.Helper()
+	enc, err := NewStreamEncoder(from, to, ToolDeltasIncremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	for _, c := range chunks {
+		out, err := enc.Push(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, out...)
+	}
+	out, err := enc.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(events, out...)
+}
+
+func decode(t *testing.T, ev Event) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal(ev.Data, &v); err != nil {
+		t.Fatalf("bad event %s: %v", ev.Data, err)
+	}
+	return v
+}
+
+func TestStreamEncoder_ChatToAnthropic(t *testing.T) {
+	events := encodeAll(t, StyleChatCompletions, StyleAnthropic, chatToolStream())
+
+	var names []string
+	args := map[float64]string{}
+	for _, ev := range events {
+		names = append(names, ev.Name)
+		v := decode(t, ev)
+		if v["type"] != ev.Name {
+			t.Errorf("event %q has type %v", ev.Name, v["type"])
+		}
+		if d, _ := v["delta"].(map[string]any); d["type"] == "input_json_delta" {
+			args[v["index"].(float64)] += d["partial_json"].(string)
+		}
+	}
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_stop", // text
+		"content_block_start", "content_block_delta", // weather
+		"content_block_stop", "content_block_start", "content_block_delta", // time
+		"content_block_delta", // late weather args
+		"content_block_stop",
+		"message_delta", "message_stop",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("events:\n got %v\nwant %v", names, want)
+	}
+	if args[1] != `{"city":"Oslo"}` || args[2] != "{}" {
+		t.Errorf("tool arguments by block = %v", args)
+	}
+
+	md := decode(t, events[len(events)-2])
+	if md["delta"].(map[string]any)["stop_reason"] != "tool_use" {
+		t.Errorf("message_delta = %v", md)
+	}
+	if md["usage"].(map[string]any)["output_tokens"] != float64(5) {
+		t.Errorf("message_delta usage = %v", md["usage"])
+	}
+	if StreamEndsWithDone(StyleAnthropic) {
+		t.Error("anthropic streams must not end with [DONE]")
+	}
+}
+
+func TestStreamEncoder_ChatToResponses(t *testing.T) {
+	events := encodeAll(t, StyleChatCompletions, StyleResponses, chatToolStream())
+
+	seq := 0.0
+	for _, ev := range events {
+		v := decode(t, ev)
+		if v["type"] != ev.Name || v["sequence_number"] != seq {
+			t.Errorf("event %d: %s", int(seq), ev.Data)
+		}
+		seq++
+	}
+	if events[0].Name != "response.created" {
+		t.Errorf("first event = %s", events[0].Name)
+	}
+
+	last := decode(t, events[len(events)-1])
+	if events[len(events)-1].Name != "response.completed" {
+		t.Fatalf("last event = %s", events[len(events)-1].Name)
+	}
+	resp := last["response"].(map[string]any)
+	output := resp["output"].([]any)
+	if len(output) != 3 {
+		t.Fatalf("output = %v", output)
+	}
+	if msg := output[0].(map[string]any); msg["type"] != "message" {
+		t.Errorf("output[0] = %v", msg)
+	}
+	weather := output[1].(map[string]any)
+	if weather["call_id"] != "call_a" || weather["arguments"] != `{"city":"Oslo"}` || weather["status"] != "completed" {
+		t.Errorf("output[1] = %v", weather)
+	}
+	if output[2].(map[string]any)["name"] != "time" {
+		t.Errorf("output[2] = %v", output[2])
+	}
+	if resp["usage"].(map[string]any)["total_tokens"] != float64(12) {
+		t.Errorf("usage = %v", resp["usage"])
+	}
+}
+
+func TestStreamEncoder_ResponsesIncomplete(t *testing.T) {
+	events := encodeAll(t, StyleChatCompletions, StyleResponses, []*ail.Program{
+		chunk(func(p *ail.Program) {
+			p.Emit(ail.STREAM_START)
+			p.EmitString(ail.STREAM_DELTA, "Once upon")
+			p.EmitString(ail.RESP_DONE, "length")
+		}),
+	})
+	last := events[len(events)-1]
+	if last.Name != "response.incomplete" {
+		t.Fatalf("last event = %s", last.Name)
+	}
+	resp := decode(t, last)["response"].(map[string]any)
+	if resp["incomplete_details"].(map[string]any)["reason"] != "max_output_tokens" {
+		t.Errorf("response = %v", resp)
+	}
+}
+
+func TestStreamEncoder_ToChatRenumbersAndHoldsArgs(t *testing.T) {
+	// An Anthropic upstream keys tool deltas by content block, and the
+	// arguments can arrive before a delta naming the call.
+	events := encodeAll(t, StyleAnthropic, StyleChatCompletions, []*ail.Program{
+		chunk(func(p *ail.Program) { p.EmitString(ail.STREAM_DELTA, "Hi") }),
+		chunk(func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 3, "arguments": `{"q":`})
+		}),
+		chunk(func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 3, "id": "toolu_1", "name": "search"})
+			toolDelta(p, map[string]any{"index": 5, "id": "toolu_2", "name": "fetch", "arguments": "{}"})
+		}),
+		chunk(func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 3, "arguments": `1}`})
+		}),
+	})
+
+	type call struct {
+		id, name, args string
+	}
+	calls := map[float64]*call{}
+	var order []float64
+	for _, ev := range events {
+		if ev.Name != "" {
+			t.Errorf("chat event has name %q", ev.Name)
+		}
+		choices, _ := decode(t, ev)["choices"].([]any)
+		for _, c := range choices {
+			delta, _ := c.(map[string]any)["delta"].(map[string]any)
+			tcs, _ := delta["tool_calls"].([]any)
+			for _, tc := range tcs {
+				tc := tc.(map[string]any)
+				idx := tc["index"].(float64)
+				got := calls[idx]
+				if got == nil {
+					got = &call{}
+					calls[idx] = got
+					order = append(order, idx)
+				}
+				if id, _ := tc["id"].(string); id != "" {
+					got.id = id
+				}
+				fn, _ := tc["function"].(map[string]any)
+				if name, _ := fn["name"].(string); name != "" {
+					got.name = name
+				} else if got.name == "" {
+					t.Errorf("call %v: arguments sent before its name", idx)
+				}
+				if a, _ := fn["arguments"].(string); a != "" {
+					got.args += a
+				}
+			}
+		}
+	}
+	if len(order) != 2 || order[0] != 0 || order[1] != 1 {
+		t.Fatalf("tool indices = %v, want [0 1]", order)
+	}
+	if c := calls[0]; c.id != "toolu_1" || c.name != "search" || c.args != `{"q":1}` {
+		t.Errorf("call 0 = %+v", c)
+	}
+	if c := calls[1]; c.id != "toolu_2" || c.name != "fetch" || c.args != "{}" {
+		t.Errorf("call 1 = %+v", c)
+	}
+}
+
+func TestStreamEncoder_GoogleBuffersToolCalls(t *testing.T) {
+	enc, err := NewStreamEncoder(StyleChatCompletions, StyleGoogleGenAI, ToolDeltasIncremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := enc.Push(chunk(func(p *ail.Program) {
+		toolDelta(p, map[string]any{"index": 0, "id": "call_a", "name": "weather", "arguments": `{"city":"Oslo"}`})
+	}))
+	if err != nil || len(out) != 0 {
+		t.Fatalf("tool delta was not buffered: %v, %v", out, err)
+	}
+	out, err = enc.Flush()
+	if err != nil || len(out) == 0 || !strings.Contains(string(out[len(out)-1].Data), "functionCall") {
+		t.Fatalf("flush = %v, %v", out, err)
+	}
+}
+
+func TestResponsesEmitter(t *testing.T) {
+	p := ail.NewProgram()
+	p.EmitString(ail.RESP_ID, "resp_1")
+	p.EmitString(ail.RESP_MODEL, "gpt-x")
+	p.Emit(ail.MSG_START)
+	p.Emit(ail.ROLE_AST)
+	p.EmitString(ail.TXT_CHUNK, "Looking it up.")
+	p.EmitString(ail.CALL_START, "call_a")
+	p.EmitString(ail.CALL_NAME, "weather")
+	p.EmitJSON(ail.CALL_ARGS, json.RawMessage(`{"city":"Oslo"}`))
+	p.Emit(ail.CALL_END)
+	p.EmitString(ail.RESP_DONE, "tool_calls")
+	p.Emit(ail.MSG_END)
+	p.EmitJSON(ail.USAGE, json.RawMessage(`{"prompt_tokens":3,"completion_tokens":4}`))
+
+	em, err := GetResponseEmitter(StyleResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := em.EmitResponse(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Output []struct {
+			Type      string `json:"type"`
+			CallID    string `json:"call_id"`
+			Arguments string `json:"arguments"`
+			Content   []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"output"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "resp_1" || resp.Status != "completed" || resp.Usage.TotalTokens != 7 || len(resp.Output) != 2 {
+		t.Fatalf("response = %s", body)
+	}
+	if resp.Output[0].Type != "message" || resp.Output[0].Content[0].Text != "Looking it up." {
+		t.Errorf("output[0] = %+v", resp.Output[0])
+	}
+	if resp.Output[1].CallID != "call_a" || resp.Output[1].Arguments != `{"city":"Oslo"}` {
+		t.Errorf("output[1] = %+v", resp.Output[1])
+	}
+}
+
+func TestStreamEncoder_ChatChoiceIndices(t *testing.T) {
+	marked := func(index string, build func(p *ail.Program)) *ail.Program {
+		return chunk(func(p *ail.Program) {
+			build(p)
+			p.EmitKeyVal(ail.SET_META, ChoiceIndexMeta, index)
+		})
+	}
+	events := encodeAll(t, StyleChatCompletions, StyleChatCompletions, []*ail.Program{
+		marked("0", func(p *ail.Program) { p.EmitString(ail.STREAM_DELTA, "a") }),
+		marked("1", func(p *ail.Program) {
+			toolDelta(p, map[string]any{"index": 4, "id": "call_b", "name": "time", "arguments": "{}"})
+		}),
+		marked("1", func(p *ail.Program) { p.EmitString(ail.RESP_DONE, "tool_calls") }),
+	})
+	if len(events) != 3 {
+		t.Fatalf("events = %d", len(events))
+	}
+	for i, want := range []float64{0, 1, 1} {
+		v := decode(t, events[i])
+		if _, leaked := v[ChoiceIndexMeta]; leaked {
+			t.Errorf("event %d leaks the choice marker: %s", i, events[i].Data)
+		}
+		c := v["choices"].([]any)[0].(map[string]any)
+		if c["index"] != want {
+			t.Errorf("event %d choice index = %v, want %v", i, c["index"], want)
+		}
+	}
+	tc := decode(t, events[1])["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)["tool_calls"].([]any)[0]
+	if tc.(map[string]any)["index"] != float64(0) {
+		t.Errorf("tool call not numbered per choice: %v", tc)
+	}
+
+	// Clients without multiple choices only get the first.
+	events = encodeAll(t, StyleChatCompletions, StyleAnthropic, []*ail.Program{
+		marked("1", func(p *ail.Program) { p.EmitString(ail.STREAM_DELTA, "b") }),
+	})
+	for _, ev := range events {
+		if strings.Contains(string(ev.Data), `"b"`) {
+			t.Errorf("choice 1 reached an anthropic client: %s", ev.Data)
+		}
+	}
+}
+
+func TestStreamEncoder_BufferedToolDeltas(t *testing.T) {
+	enc, err := NewStreamEncoder(StyleChatCompletions, StyleChatCompletions, ToolDeltasBuffered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	for i, c := range chatToolStream() {
+		out, err := enc.Push(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ev := range out {
+			if i < 3 && strings.Contains(string(ev.Data), "tool_calls") {
+				t.Errorf("tool call sent before the finish: %s", ev.Data)
+			}
+		}
+		events = append(events, out...)
+	}
+	out, err := enc.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = append(events, out...)
+
+	var calls []map[string]any
+	finishAt, lastCallAt := -1, -1
+	for i, ev := range events {
+		for _, c := range decode(t, ev)["choices"].([]any) {
+			c := c.(map[string]any)
+			if c["finish_reason"] != nil {
+				finishAt = i
+			}
+			delta, _ := c["delta"].(map[string]any)
+			tcs, _ := delta["tool_calls"].([]any)
+			for _, tc := range tcs {
+				calls = append(calls, tc.(map[string]any))
+				lastCallAt = i
+			}
+		}
+	}
+	if len(calls) != 2 || lastCallAt > finishAt {
+		t.Fatalf("calls = %v (last at %d, finish at %d)", calls, lastCallAt, finishAt)
+	}
+	args := func(c map[string]any) any { return c["function"].(map[string]any)["arguments"] }
+	if calls[0]["id"] != "call_a" || args(calls[0]) != `{"city":"Oslo"}` {
+		t.Errorf("call 0 = %v", calls[0])
+	}
+	if calls[1]["id"] != "call_b" || args(calls[1]) != "{}" {
+		t.Errorf("call 1 = %v", calls[1])
+	}
+}
@@ -0,0 +1,127 @@
+package styles
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/neutrome-labs/ail"
+)
+
+// ToolDeltas selects how streamed tool calls reach the client.
+type ToolDeltas string
+
+const (
+	// ToolDeltasIncremental streams tool-call arguments as they arrive.
+	ToolDeltasIncremental ToolDeltas = "incremental"
+	// ToolDeltasBuffered holds each tool call back until the response
+	// finishes and sends it whole, in one delta with the complete
+	// arguments, for client SDKs that fail on partial JSON arguments.
+	ToolDeltasBuffered ToolDeltas = "buffered"
+)
+
+// ParseToolDeltas parses a tool-delta mode; "" is incremental.
+func ParseToolDeltas(s string) (ToolDeltas, error) {
+	switch ToolDeltas(s) {
+	case "", ToolDeltasIncremental:
+		return ToolDeltasIncremental, nil
+	case ToolDeltasBuffered:
+		return ToolDeltasBuffered, nil
+	}
+	return "", fmt.Errorf("unknown tool delta mode %q (want incremental or buffered)", s)
+}
+
+// bufferedToolsEncoder holds STREAM_TOOL_DELTAs back and releases each
+// call as a single complete delta just before the finish reason of its
+// choice, or at Flush when the upstream sent none.
+type bufferedToolsEncoder struct {
+	StreamEncoder
+	choices map[string]*toolCalls // by ChoiceIndexMeta, "" for unmarked
+	order   []string
+}
+
+func (e *bufferedToolsEncoder) Push(chunk *ail.Program) ([]Event, error) {
+	choice := choiceMarker(chunk)
+	out := ail.NewProgram()
+	out.Buffers = chunk.Buffers
+	for _, inst := range chunk.Code {
+		switch inst.Op {
+		case ail.STREAM_TOOL_DELTA:
+			e.calls(choice).push(inst.JSON)
+			continue
+		case ail.RESP_DONE:
+			e.release(choice, out)
+		}
+		out.Code = append(out.Code, inst)
+	}
+	if out.Len() == 0 {
+		return nil, nil
+	}
+	return e.StreamEncoder.Push(out)
+}
+
+func (e *bufferedToolsEncoder) Flush() ([]Event, error) {
+	var events []Event
+	for _, choice := range e.order {
+		chunk := ail.NewProgram()
+		e.release(choice, chunk)
+		if chunk.Len() == 0 {
+			continue
+		}
+		if choice != "" {
+			chunk.EmitKeyVal(ail.SET_META, ChoiceIndexMeta, choice)
+		}
+		out, err := e.StreamEncoder.Push(chunk)
+		events = append(events, out...)
+		if err != nil {
+			return events, err
+		}
+	}
+	out, err := e.StreamEncoder.Flush()
+	return append(events, out...), err
+}
+
+func (e *bufferedToolsEncoder) calls(choice string) *toolCalls {
+	if e.choices == nil {
+		e.choices = make(map[string]*toolCalls)
+	}
+	t := e.choices[choice]
+	if t == nil {
+		t = &toolCalls{}
+		e.choices[choice] = t
+		e.order = append(e.order, choice)
+	}
+	return t
+}
+
+// release appends the choice's buffered calls to prog as complete deltas.
+func (e *bufferedToolsEncoder) release(choice string, prog *ail.Program) {
+	t := e.choices[choice]
+	if t == nil {
+		return
+	}
+	for _, call := range t.calls {
+		if !call.opened {
+			continue // never named; nothing a client could call
+		}
+		j, _ := json.Marshal(map[string]any{
+			"index":     call.index,
+			"id":        call.id,
+			"name":      call.name,
+			"arguments": call.args.String(),
+		})
+		prog.EmitJSON(ail.STREAM_TOOL_DELTA, j)
+	}
+	e.choices[choice] = &toolCalls{}
+}
+
+func choiceMarker(chunk *ail.Program) string {
+	for _, inst := range chunk.Code {
+		if inst.Op == ail.SET_META && inst.Key == ChoiceIndexMeta {
+			if _, err := strconv.Atoi(inst.Str); err == nil {
+				return inst.Str
+			}
+		}
+	}
+	return ""
+}